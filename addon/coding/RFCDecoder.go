@@ -0,0 +1,104 @@
+package rfccode
+
+import (
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"fmt"
+)
+
+// Decode recovers the K original chunks from the collected chunks and their coefficient vectors by Gaussian elimination over GF(256).
+// Any K chunks with independent vectors are enough, more chunks are allowed. The padding of the originals is stripped.
+// Returns an error if the rank of the vectors is less than K
+func Decode(chunks []Chunk, vectors [][]byte) ([]Chunk, error) {
+	if len(chunks) == 0 || len(chunks) != len(vectors) {
+		return nil, fmt.Errorf("%d chunks with %d coefficient vectors", len(chunks), len(vectors))
+	}
+	k := len(vectors[0])
+	length := len(chunks[0].DataBlock)
+
+	// the augmented matrix, each row is [vector | data block]
+	rows := make([][]byte, len(chunks))
+	for i := range chunks {
+		if len(vectors[i]) != k || len(chunks[i].DataBlock) != length {
+			return nil, fmt.Errorf("chunk %d does not match the others", i)
+		}
+		row := make([]byte, 0, k+length)
+		row = append(row, vectors[i]...)
+		row = append(row, chunks[i].DataBlock...)
+		rows[i] = row
+	}
+
+	rank := 0
+	for col := 0; col < k && rank < len(rows); col++ {
+		pivot := -1
+		for r := rank; r < len(rows); r++ {
+			if rows[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			continue
+		}
+		rows[rank], rows[pivot] = rows[pivot], rows[rank]
+
+		// make the pivot 1, and eliminate the column in the other rows
+		rowScale(rows[rank], Divide(1, rows[rank][col]))
+		for r := range rows {
+			if r != rank && rows[r][col] != 0 {
+				rowSubtract(rows[r], rows[rank], rows[r][col])
+			}
+		}
+		rank++
+	}
+	if rank < k {
+		return nil, fmt.Errorf("the rank of the coefficient vectors is %d, %d independent chunks are needed", rank, k)
+	}
+
+	// every column has a pivot, so row i is [e_i | original chunk i]
+	paddings := chunks[0].Paddings
+	originals := make([]Chunk, k)
+	for i := 0; i < k; i++ {
+		padding := 0
+		if len(paddings) == k {
+			padding = paddings[i]
+		}
+		if padding < 0 || padding > length {
+			return nil, fmt.Errorf("invalid padding %d of chunk %d", padding, i)
+		}
+		originals[i] = Chunk{
+			DataBlock: rows[i][k : k+length-padding],
+			Original:  true,
+		}
+	}
+	return originals, nil
+}
+
+// DecodeBlocks recovers the blocks of the K origin shards from the chunks
+func DecodeBlocks(chunks []Chunk, vectors [][]byte) ([]structs.Block, error) {
+	originals, err := Decode(chunks, vectors)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]structs.Block, len(originals))
+	for i, chunk := range originals {
+		if err := utils.Decode(chunk.DataBlock, &blocks[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode block %d: %v", i, err)
+		}
+	}
+	return blocks, nil
+}
+
+// row = row * coefficient
+func rowScale(row []byte, coefficient byte) {
+	for i := range row {
+		row[i] = Multiply(coefficient, row[i])
+	}
+}
+
+// row = row - coefficient * pivotRow
+func rowSubtract(row []byte, pivotRow []byte, coefficient byte) {
+	for i := range row {
+		row[i] = Subtract(row[i], Multiply(coefficient, pivotRow[i]))
+	}
+}
@@ -0,0 +1,140 @@
+package rfccode
+
+import (
+	"BlockChainSimulator/structs"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the rank of the vectors over GF(256), computed on a copy
+func rankOf(vectors [][]byte) int {
+	rows := make([][]byte, len(vectors))
+	for i, vec := range vectors {
+		rows[i] = append([]byte{}, vec...)
+	}
+	rank := 0
+	for col := 0; len(rows) > 0 && col < len(rows[0]); col++ {
+		for r := rank; r < len(rows); r++ {
+			if rows[r][col] != 0 {
+				rows[rank], rows[r] = rows[r], rows[rank]
+				break
+			}
+		}
+		if rank == len(rows) || rows[rank][col] == 0 {
+			continue
+		}
+		for r := rank + 1; r < len(rows); r++ {
+			if rows[r][col] != 0 {
+				rowSubtract(rows[r], rows[rank], Divide(rows[r][col], rows[rank][col]))
+			}
+		}
+		rank++
+	}
+	return rank
+}
+
+func randomData(rander *rand.Rand, k int) [][]byte {
+	data := make([][]byte, k)
+	for i := range data {
+		data[i] = make([]byte, rander.Intn(64))
+		rander.Read(data[i])
+	}
+	return data
+}
+
+func TestGaloisField(t *testing.T) {
+	for a := 1; a < FIELDSIZE; a++ {
+		assert.Equal(t, byte(1), Multiply(byte(a), Divide(1, byte(a))), "the inverse of %d", a)
+		for b := 1; b < FIELDSIZE; b++ {
+			product := Multiply(byte(a), byte(b))
+			assert.Equal(t, Multiply(byte(b), byte(a)), product)
+			assert.Equal(t, byte(a), Divide(product, byte(b)))
+		}
+	}
+}
+
+// any K chunks with independent vectors recover the originals, the dependent ones report the rank deficiency
+func TestDecodeAnyKChunks(t *testing.T) {
+	rander := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		k := rander.Intn(5) + 1
+		n := k + rander.Intn(4) + 1
+		randSeed := rander.Int63()
+		data := randomData(rander, k)
+
+		chunks := make([]Chunk, n)
+		vectors := make([][]byte, n)
+		for sid := 0; sid < n; sid++ {
+			chunks[sid], vectors[sid] = EncodeData(data, randSeed, sid)
+			assert.Equal(t, CodingVector(randSeed, sid, k), vectors[sid], "the vector of the chunk is the coding vector of the shard")
+		}
+
+		perm := rander.Perm(n)[:k]
+		selected, selectedVecs := make([]Chunk, k), make([][]byte, k)
+		for i, sid := range perm {
+			selected[i], selectedVecs[i] = chunks[sid], vectors[sid]
+		}
+
+		originals, err := Decode(selected, selectedVecs)
+		if rankOf(selectedVecs) < k {
+			assert.Error(t, err, "trial %d: the vectors are dependent", trial)
+			continue
+		}
+		assert.NoError(t, err, "trial %d", trial)
+		for i := range data {
+			assert.Equal(t, len(data[i]), len(originals[i].DataBlock), "trial %d: the padding is stripped", trial)
+			assert.Equal(t, string(data[i]), string(originals[i].DataBlock), "trial %d: chunk %d", trial, i)
+		}
+	}
+}
+
+func TestDecodeRankDeficient(t *testing.T) {
+	data := randomData(rand.New(rand.NewSource(2)), 3)
+	c0, v0 := EncodeData(data, 7, 3)
+	c1, v1 := EncodeData(data, 7, 4)
+
+	// fewer than K chunks
+	_, err := Decode([]Chunk{c0, c1}, [][]byte{v0, v1})
+	assert.Error(t, err)
+
+	// the same chunk twice
+	_, err = Decode([]Chunk{c0, c1, c1}, [][]byte{v0, v1, v1})
+	assert.Error(t, err)
+
+	// more than K chunks are fine
+	c2, v2 := EncodeData(data, 7, 0)
+	c3, v3 := EncodeData(data, 7, 1)
+	c4, v4 := EncodeData(data, 7, 2)
+	originals, err := Decode([]Chunk{c0, c1, c2, c3, c4}, [][]byte{v0, v1, v2, v3, v4})
+	assert.NoError(t, err)
+	assert.Equal(t, string(data[1]), string(originals[1].DataBlock))
+
+	// mismatched input
+	_, err = Decode([]Chunk{c0}, [][]byte{v0, v1})
+	assert.Error(t, err)
+}
+
+func TestDecodeBlocks(t *testing.T) {
+	blocks := make([]structs.Block, 2)
+	for i := range blocks {
+		txs := make([]structs.Transaction, 0)
+		for j := 0; j <= i*3; j++ {
+			txs = append(txs, structs.NewAccountTransaction("sender", "recipient", int64(j), big.NewInt(int64(j))))
+		}
+		header := &structs.BlockHeader{Height: int64(i + 1), TimeStamp: time.Now()}
+		blocks[i] = *structs.NewBlock(header, txs)
+	}
+
+	c2, v2 := Encode(blocks, 11, 2)
+	c3, v3 := Encode(blocks, 11, 3)
+	decoded, err := DecodeBlocks([]Chunk{c2, c3}, [][]byte{v2, v3})
+	assert.NoError(t, err)
+	for i := range blocks {
+		assert.Equal(t, blocks[i].Hash, decoded[i].Hash)
+		assert.Len(t, decoded[i].Transactions, len(blocks[i].Transactions))
+	}
+}
@@ -2,6 +2,7 @@ package rfccode
 
 import (
 	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
 	"crypto/ecdsa"
 	"log"
 	"math/big"
@@ -13,7 +14,8 @@ import (
 type Chunk struct {
 	DataBlock []byte
 	Original  bool
-	Padding   int
+	Padding   int   // the zero bytes appended to the original chunk
+	Paddings  []int // the padding of each original chunk of the round, used by the decoder to strip them
 }
 
 type CodingSchema struct {
@@ -37,14 +39,15 @@ func GenRandSeed(sk ecdsa.PrivateKey, block structs.Block) (int64, []byte) {
 	return randSeed, pi
 }
 
-func VerifyRandSeed(pk ecdsa.PublicKey, block structs.Block, pi []byte) bool {
+// verify the proof of the VRF output, and that randSeed is derived from it
+func VerifyRandSeed(pk ecdsa.PublicKey, block structs.Block, randSeed int64, pi []byte) bool {
 	content := block.Hash
 	vrf := ecvrf.P256Sha256Tai
-	_, err := vrf.Verify(&pk, content[:], pi)
+	beta, err := vrf.Verify(&pk, content[:], pi)
 	if err != nil {
 		return false
 	}
-	return true
+	return new(big.Int).SetBytes(beta).Int64() == randSeed
 }
 
 func encode(chunks []Chunk, randSeed int64) (Chunk, []byte) {
@@ -64,6 +67,7 @@ func encode(chunks []Chunk, randSeed int64) (Chunk, []byte) {
 		DataBlock: product,
 		Padding:   0,
 		Original:  false,
+		Paddings:  chunks[0].Paddings,
 	}
 	return chunk, vec
 }
@@ -84,35 +88,64 @@ func blockSum(product, newProduct []byte) []byte {
 	return sum
 }
 
+// the coefficients of the shard, the origin shards(shardId < k) keep their own block, and each coded shard
+// draws its coefficients from the seed of the round and its shard id, so the coded shards store different combinations
+func CodingVector(randSeed int64, shardId int, k int) []byte {
+	vec := make([]byte, k)
+	if shardId < k {
+		vec[shardId] = 1
+		return vec
+	}
+	rander := rand.New(rand.NewSource(randSeed + int64(shardId)))
+	for i := range vec {
+		vec[i] = byte(rander.Int() % FIELDSIZE)
+	}
+	return vec
+}
+
+// Encode pads the blocks of the K origin shards to the same length, and returns the chunk stored by the shard with its coefficients
 func Encode(blocks []structs.Block, randSeed int64, shardId int) (Chunk, []byte) {
-	// // blocks number didn't match K
-	// if len(blocks) != int(math.Log2(float64(params.K))) {
-	// 	return Chunk{}, []byte{}
-	// }
-	// chunks := make([]Chunk, len(blocks))
-	// maxLength := 0
-	// for i, block := range blocks {
-	// 	dataBlock := utils.Encode(block)
-	// 	chunks[i].DataBlock = dataBlock
-	// 	if len(dataBlock) > maxLength {
-	// 		maxLength = len(dataBlock)
-	// 	}
-	// }
-
-	// for i := 0; i < len(chunks); i++ {
-	// 	addLength := maxLength - len(chunks[i].DataBlock)
-	// 	addBytes := make([]byte, addLength)
-	// 	chunks[i].DataBlock = append(chunks[i].DataBlock, addBytes...)
-	// 	chunks[i].Padding = addLength
-	// 	chunks[i].Original = true
-	// }
-
-	// if shardId/params.K == 0 {
-	// 	vector := make([]byte, len(blocks))
-	// 	vector[shardId] = 1
-	// 	return chunks[shardId], vector
-	// }
-
-	// return encode(chunks, randSeed)
-	return Chunk{}, []byte{}
+	data := make([][]byte, len(blocks))
+	for i, block := range blocks {
+		data[i] = utils.Encode(block)
+	}
+	return EncodeData(data, randSeed, shardId)
+}
+
+// EncodeData pads the K data blocks to the same length, the origin shards(shardId < K) get their own block,
+// the coded shards get a random linear combination of them
+func EncodeData(data [][]byte, randSeed int64, shardId int) (Chunk, []byte) {
+	if len(data) == 0 {
+		return Chunk{}, []byte{}
+	}
+	chunks := padChunks(data)
+	if shardId < len(chunks) {
+		return chunks[shardId], CodingVector(randSeed, shardId, len(chunks))
+	}
+
+	return encode(chunks, randSeed+int64(shardId))
+}
+
+// pad the data blocks with zero bytes to the length of the longest one
+func padChunks(data [][]byte) []Chunk {
+	chunks := make([]Chunk, len(data))
+	paddings := make([]int, len(data))
+	maxLength := 0
+	for _, dataBlock := range data {
+		if len(dataBlock) > maxLength {
+			maxLength = len(dataBlock)
+		}
+	}
+
+	for i, dataBlock := range data {
+		addLength := maxLength - len(dataBlock)
+		chunks[i].DataBlock = append(append(make([]byte, 0, maxLength), dataBlock...), make([]byte, addLength)...)
+		chunks[i].Padding = addLength
+		chunks[i].Original = true
+		paddings[i] = addLength
+	}
+	for i := range chunks {
+		chunks[i].Paddings = paddings
+	}
+	return chunks
 }
@@ -0,0 +1,264 @@
+// Package rbc contains Bracha's reliable broadcast, a running mod creates one RBC and runs any number of instances on it.
+// The sender of an instance sends its value to all (SEND), every node echoes the first value it receives from the sender (ECHO).
+// A node sends READY for a digest after (n+f)/2+1 ECHOs or f+1 READYs of it, and delivers the value after 2f+1 READYs.
+// Even if the sender equivocates or crashes, the correct nodes deliver the same value, and either all or none of them deliver.
+// Every message is signed by its Sender, so a faulty node cannot echo or ready in the name of the correct ones
+package rbc
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// the content of MsgRBCSend, MsgRBCEcho and MsgRBCReady
+type Content struct {
+	Instance int    // the instance id chosen by the running mod
+	Proposer int    // the sender of the instance, every node may broadcast in the same instance id
+	Sender   int    // the node sending the message
+	Digest   []byte // the hash of the value
+	Value    []byte // not carried by MsgRBCReady, bound to the signature by the Digest
+	Sig      *signature.Signature
+}
+
+// the outgoing messages of the RBC, the messages to this node are handled by the RBC itself
+type Transport interface {
+	Send(nid int, msg *message.Message)
+}
+
+// signs the messages of this node and verifies the messages of the others
+type Authenticator interface {
+	Sign(content []byte) *signature.Signature
+	Verify(nid int, content []byte, sig *signature.Signature) bool
+}
+
+// the value of an instance is delivered to the running mod
+type DeliverFunc func(instance int, proposer int, value []byte)
+
+type instanceKey struct {
+	instance int
+	proposer int
+}
+
+type instance struct {
+	values    map[string][]byte // digest -> the value carried by the SEND or the ECHOs
+	echoes    map[int]string    // sender -> the digest of its first ECHO, the later ones are ignored
+	readies   map[int]string    // sender -> the digest of its first READY
+	echoSent  bool
+	readySent bool
+	delivered bool
+}
+
+type RBC struct {
+	n, f      int
+	nid       int // this node
+	transport Transport
+	auth      Authenticator
+	deliver   DeliverFunc
+
+	instances map[instanceKey]*instance
+	kept      int // the instances before it are dropped
+	lock      sync.Mutex
+}
+
+// n nodes tolerating f faults, n must be larger than 3f
+func NewRBC(n int, f int, nid int, transport Transport, auth Authenticator, deliver DeliverFunc) (*RBC, error) {
+	if f < 0 || n <= 3*f || nid < 0 || nid >= n {
+		return nil, fmt.Errorf("invalid RBC of node %d with n=%d f=%d", nid, n, f)
+	}
+	return &RBC{
+		n: n, f: f,
+		nid:       nid,
+		transport: transport,
+		auth:      auth,
+		deliver:   deliver,
+		instances: make(map[instanceKey]*instance),
+	}, nil
+}
+
+// register the handlers of the RBC messages to the p2p module of the node
+func (r *RBC) RegisterHandlers(p2pMod *p2p.P2PMod) {
+	p2pMod.RegisterHandler(message.MsgRBCSend, r.Handle)
+	p2pMod.RegisterHandler(message.MsgRBCEcho, r.Handle)
+	p2pMod.RegisterHandler(message.MsgRBCReady, r.Handle)
+}
+
+// broadcast the value in the instance, this node is the proposer
+func (r *RBC) Broadcast(instanceId int, value []byte) {
+	digest := sha256.Sum256(value)
+	c := &Content{Instance: instanceId, Proposer: r.nid, Sender: r.nid, Digest: digest[:], Value: value}
+
+	r.lock.Lock()
+	delivered := r.send(message.MsgRBCSend, c, nil)
+	r.lock.Unlock()
+	r.callback(delivered)
+}
+
+// handle a message of the RBC from another node, the messages not signed by their Sender are dropped
+func (r *RBC) Handle(msg *message.Message) {
+	c := &Content{}
+	if err := utils.Decode(msg.Content, c); err != nil {
+		utils.LoggerInstance.Error("Error decoding the RBC message")
+		return
+	}
+	if c.Sender < 0 || c.Sender >= r.n || c.Sender == r.nid || c.Sig == nil || !r.auth.Verify(c.Sender, signedContent(msg.MsgType, c), c.Sig) {
+		utils.LoggerInstance.Warn("Drop the RBC message with an invalid signature of node %d", c.Sender)
+		return
+	}
+	r.handle(msg.MsgType, c)
+}
+
+// drop the instances before the given id, their late messages are ignored
+func (r *RBC) DropBefore(instanceId int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.kept = max(r.kept, instanceId)
+	for key := range r.instances {
+		if key.instance < r.kept {
+			delete(r.instances, key)
+		}
+	}
+}
+
+func (r *RBC) handle(msgType message.MessageType, c *Content) {
+	r.lock.Lock()
+	delivered := r.process(msgType, c, nil)
+	r.lock.Unlock()
+	r.callback(delivered)
+}
+
+// the values are delivered after the lock is released, so the callback may broadcast in the RBC
+func (r *RBC) callback(delivered []*Content) {
+	for _, d := range delivered {
+		r.deliver(d.Instance, d.Proposer, d.Value)
+	}
+}
+
+// call with lock held, the messages to this node are processed in place, returns the values delivered
+func (r *RBC) process(msgType message.MessageType, c *Content, delivered []*Content) []*Content {
+	if c.Instance < r.kept || c.Proposer < 0 || c.Proposer >= r.n || c.Sender < 0 || c.Sender >= r.n {
+		return delivered
+	}
+	key := instanceKey{c.Instance, c.Proposer}
+	inst, ok := r.instances[key]
+	if !ok {
+		inst = &instance{
+			values:  make(map[string][]byte),
+			echoes:  make(map[int]string),
+			readies: make(map[int]string),
+		}
+		r.instances[key] = inst
+	}
+	digest := string(c.Digest)
+
+	switch msgType {
+	case message.MsgRBCSend:
+		if c.Sender != c.Proposer || inst.echoSent || !r.checkDigest(c) {
+			return delivered
+		}
+		inst.values[digest] = c.Value
+		inst.echoSent = true
+		delivered = r.send(message.MsgRBCEcho, &Content{Instance: c.Instance, Proposer: c.Proposer, Sender: r.nid, Digest: c.Digest, Value: c.Value}, delivered)
+	case message.MsgRBCEcho:
+		if _, ok := inst.echoes[c.Sender]; ok || !r.checkDigest(c) {
+			return delivered
+		}
+		inst.echoes[c.Sender] = digest
+		inst.values[digest] = c.Value
+		if count(inst.echoes, digest) >= (r.n+r.f)/2+1 && !inst.readySent {
+			inst.readySent = true
+			delivered = r.send(message.MsgRBCReady, &Content{Instance: c.Instance, Proposer: c.Proposer, Sender: r.nid, Digest: c.Digest}, delivered)
+		}
+	case message.MsgRBCReady:
+		if _, ok := inst.readies[c.Sender]; ok {
+			return delivered
+		}
+		inst.readies[c.Sender] = digest
+		if count(inst.readies, digest) >= r.f+1 && !inst.readySent {
+			inst.readySent = true
+			delivered = r.send(message.MsgRBCReady, &Content{Instance: c.Instance, Proposer: c.Proposer, Sender: r.nid, Digest: c.Digest}, delivered)
+		}
+	default:
+		return delivered
+	}
+
+	// the value of the digest is known once a correct node echoes it, at the latest
+	for _, d := range inst.readies {
+		v, ok := inst.values[d]
+		if inst.delivered || count(inst.readies, d) < 2*r.f+1 || !ok {
+			continue
+		}
+		inst.delivered = true
+		delivered = append(delivered, &Content{Instance: c.Instance, Proposer: c.Proposer, Digest: []byte(d), Value: v})
+	}
+	return delivered
+}
+
+// call with lock held, send the message to the other nodes and process it in place
+func (r *RBC) send(msgType message.MessageType, c *Content, delivered []*Content) []*Content {
+	c.Sig = r.auth.Sign(signedContent(msgType, c))
+	msg := &message.Message{MsgType: msgType, Content: utils.Encode(c)}
+	for nid := 0; nid < r.n; nid++ {
+		if nid != r.nid {
+			r.transport.Send(nid, msg)
+		}
+	}
+	return r.process(msgType, c, delivered)
+}
+
+func (r *RBC) checkDigest(c *Content) bool {
+	digest := sha256.Sum256(c.Value)
+	return string(digest[:]) == string(c.Digest)
+}
+
+// the content signed by the Sender, the value is bound by the digest
+func signedContent(msgType message.MessageType, c *Content) []byte {
+	return utils.CanonicalEncode(struct {
+		MsgType  message.MessageType
+		Instance int
+		Proposer int
+		Sender   int
+		Digest   []byte
+	}{msgType, c.Instance, c.Proposer, c.Sender, c.Digest})
+}
+
+// the number of the senders of the digest
+func count(digests map[int]string, digest string) int {
+	num := 0
+	for _, d := range digests {
+		if d == digest {
+			num++
+		}
+	}
+	return num
+}
+
+// the transport to the nodes of a shard through the p2p module
+type ShardTransport struct {
+	Sid    int
+	P2PMod *p2p.P2PMod
+}
+
+func (st *ShardTransport) Send(nid int, msg *message.Message) {
+	go st.P2PMod.ConnMananger.Send(config.IPMap[st.Sid][nid], msg.JsonEncode())
+}
+
+// the authenticator of a shard, the messages are signed by the BLS keys of the nodes
+type NodeAuth struct {
+	Attr *nodeattr.NodeAttr
+}
+
+func (na *NodeAuth) Sign(content []byte) *signature.Signature {
+	return signature.Sign(na.Attr.SecKey, content)
+}
+
+func (na *NodeAuth) Verify(nid int, content []byte, sig *signature.Signature) bool {
+	return na.Attr.VerifySig(na.Attr.Sid, nid, content, sig)
+}
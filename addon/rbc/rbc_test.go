@@ -0,0 +1,254 @@
+package rbc
+
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a message in flight in the simulated network
+type envelope struct {
+	to  int
+	msg *message.Message
+}
+
+// the asynchronous network of the test, the messages are delivered in a random order
+type testNetwork struct {
+	queue   []envelope
+	crashed map[int]bool
+	rnd     *rand.Rand
+	sks     []*signature.SecretKey // the keys of all the nodes, every node signs with its own key
+	pks     []*signature.PublicKey
+}
+
+type testTransport struct {
+	net *testNetwork
+}
+
+type testAuth struct {
+	net *testNetwork
+	nid int
+}
+
+func (ta *testAuth) Sign(content []byte) *signature.Signature {
+	return signature.Sign(ta.net.sks[ta.nid], content)
+}
+
+func (ta *testAuth) Verify(nid int, content []byte, sig *signature.Signature) bool {
+	return signature.Verify(ta.net.pks[nid], content, sig)
+}
+
+// the message of a faulty node signed by the given node
+func (net *testNetwork) push(to int, signer int, msgType message.MessageType, c *Content) {
+	c.Sig = signature.Sign(net.sks[signer], signedContent(msgType, c))
+	net.queue = append(net.queue, envelope{to: to, msg: &message.Message{MsgType: msgType, Content: utils.Encode(c)}})
+}
+
+func (tt *testTransport) Send(nid int, msg *message.Message) {
+	tt.net.queue = append(tt.net.queue, envelope{to: nid, msg: msg})
+}
+
+// deliver the messages until the network is quiet
+func (net *testNetwork) run(nodes []*RBC) {
+	for len(net.queue) > 0 {
+		i := net.rnd.Intn(len(net.queue))
+		env := net.queue[i]
+		net.queue[i] = net.queue[len(net.queue)-1]
+		net.queue = net.queue[:len(net.queue)-1]
+		if !net.crashed[env.to] {
+			nodes[env.to].Handle(env.msg)
+		}
+	}
+}
+
+// the nodes and the values each of them delivers, keyed by the instance
+func newTestNodes(t *testing.T, n int, f int, seed int64) ([]*RBC, *testNetwork, []map[int][]byte) {
+	net := &testNetwork{crashed: map[int]bool{}, rnd: rand.New(rand.NewSource(seed)), sks: make([]*signature.SecretKey, n), pks: make([]*signature.PublicKey, n)}
+	nodes := make([]*RBC, n)
+	delivered := make([]map[int][]byte, n)
+	for nid := range nodes {
+		net.sks[nid], net.pks[nid] = signature.GenerateKeyPair()
+	}
+	for nid := range nodes {
+		delivered[nid] = make(map[int][]byte)
+		out := delivered[nid]
+		r, err := NewRBC(n, f, nid, &testTransport{net: net}, &testAuth{net: net, nid: nid}, func(instance int, proposer int, value []byte) {
+			_, ok := out[instance]
+			assert.False(t, ok, "a value is delivered twice")
+			out[instance] = value
+		})
+		require.NoError(t, err)
+		nodes[nid] = r
+	}
+	return nodes, net, delivered
+}
+
+// the SEND of a faulty proposer carrying the value to a single node
+func sendTo(net *testNetwork, to int, instance int, proposer int, value []byte) {
+	digest := sha256.Sum256(value)
+	net.push(to, proposer, message.MsgRBCSend, &Content{Instance: instance, Proposer: proposer, Sender: proposer, Digest: digest[:], Value: value})
+}
+
+func TestNewRBC(t *testing.T) {
+	_, err := NewRBC(3, 1, 0, nil, nil, nil)
+	assert.Error(t, err)
+	_, err = NewRBC(4, 1, 4, nil, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestCorrectSender(t *testing.T) {
+	n, f := 7, 2
+	for seed := int64(0); seed < 5; seed++ {
+		nodes, net, delivered := newTestNodes(t, n, f, seed)
+		// f crashed nodes do not stop the others, several instances run at the same time
+		net.crashed[5], net.crashed[6] = true, true
+		nodes[0].Broadcast(1, []byte("value of node 0"))
+		nodes[3].Broadcast(2, []byte("value of node 3"))
+		net.run(nodes)
+
+		for nid := 0; nid < 5; nid++ {
+			assert.Equal(t, []byte("value of node 0"), delivered[nid][1])
+			assert.Equal(t, []byte("value of node 3"), delivered[nid][2])
+		}
+	}
+}
+
+func TestCrashedSender(t *testing.T) {
+	n, f := 4, 1
+	for seed := int64(0); seed < 5; seed++ {
+		t.Run(fmt.Sprintf("crash before the SENDs reach the quorum, seed %d", seed), func(t *testing.T) {
+			nodes, net, delivered := newTestNodes(t, n, f, seed)
+			net.crashed[3] = true
+			sendTo(net, 0, 1, 3, []byte("value"))
+			net.run(nodes)
+
+			// too few echoes, no correct node delivers
+			for nid := 0; nid < 3; nid++ {
+				assert.Empty(t, delivered[nid])
+			}
+		})
+
+		t.Run(fmt.Sprintf("crash after the SENDs reach the quorum, seed %d", seed), func(t *testing.T) {
+			nodes, net, delivered := newTestNodes(t, n, f, seed)
+			net.crashed[3] = true
+			for nid := 0; nid < 3; nid++ {
+				sendTo(net, nid, 1, 3, []byte("value"))
+			}
+			net.run(nodes)
+
+			for nid := 0; nid < 3; nid++ {
+				assert.Equal(t, []byte("value"), delivered[nid][1])
+			}
+		})
+	}
+}
+
+func TestEquivocatingSender(t *testing.T) {
+	n, f := 7, 2
+	for seed := int64(0); seed < 10; seed++ {
+		nodes, net, delivered := newTestNodes(t, n, f, seed)
+		// node 6 sends value A to 4 nodes and value B to 2, node 5 is crashed
+		net.crashed[5], net.crashed[6] = true, true
+		for nid := 0; nid < 5; nid++ {
+			if nid < 4 || seed%2 == 0 {
+				sendTo(net, nid, 1, 6, []byte("value A"))
+			} else {
+				sendTo(net, nid, 1, 6, []byte("value B"))
+			}
+		}
+		if seed%2 == 1 {
+			sendTo(net, 4, 1, 6, []byte("value A"))
+		}
+		net.run(nodes)
+
+		// the correct nodes deliver the same value, and either all or none of them deliver
+		var expected []byte
+		deliveredNum := 0
+		for nid := 0; nid < 5; nid++ {
+			v, ok := delivered[nid][1]
+			if !ok {
+				continue
+			}
+			deliveredNum++
+			if expected == nil {
+				expected = v
+			}
+			assert.Equal(t, expected, v)
+		}
+		assert.Contains(t, []int{0, 5}, deliveredNum)
+	}
+}
+
+func TestEquivocatingSplit(t *testing.T) {
+	n, f := 4, 1
+	for seed := int64(0); seed < 10; seed++ {
+		nodes, net, delivered := newTestNodes(t, n, f, seed)
+		// node 3 splits the correct nodes, no value gets enough echoes
+		net.crashed[3] = true
+		sendTo(net, 0, 1, 3, []byte("value A"))
+		sendTo(net, 1, 1, 3, []byte("value B"))
+		sendTo(net, 2, 1, 3, []byte("value A"))
+		// the faulty proposer also echoes both values
+		for _, v := range [][]byte{[]byte("value A"), []byte("value B")} {
+			digest := sha256.Sum256(v)
+			for nid := 0; nid < 3; nid++ {
+				net.push(nid, 3, message.MsgRBCEcho, &Content{Instance: 1, Proposer: 3, Sender: 3, Digest: digest[:], Value: v})
+			}
+		}
+		net.run(nodes)
+
+		var expected []byte
+		deliveredNum := 0
+		for nid := 0; nid < 3; nid++ {
+			v, ok := delivered[nid][1]
+			if !ok {
+				continue
+			}
+			deliveredNum++
+			if expected == nil {
+				expected = v
+			}
+			assert.Equal(t, expected, v)
+		}
+		assert.Contains(t, []int{0, 3}, deliveredNum)
+	}
+}
+
+func TestForgedSender(t *testing.T) {
+	nodes, net, delivered := newTestNodes(t, 4, 1, 0)
+	// node 3 sends its value to node 0 only, and echoes and readies it in the names of nodes 1 and 2
+	net.crashed[1], net.crashed[2], net.crashed[3] = true, true, true
+	sendTo(net, 0, 1, 3, []byte("value"))
+	digest := sha256.Sum256([]byte("value"))
+	for _, sender := range []int{1, 2, 3} {
+		net.push(0, 3, message.MsgRBCEcho, &Content{Instance: 1, Proposer: 3, Sender: sender, Digest: digest[:], Value: []byte("value")})
+		net.push(0, 3, message.MsgRBCReady, &Content{Instance: 1, Proposer: 3, Sender: sender, Digest: digest[:]})
+	}
+	net.run(nodes)
+
+	// only the messages of node 3 itself are counted, too few to deliver
+	assert.Empty(t, delivered[0])
+	assert.Len(t, nodes[0].instances[instanceKey{1, 3}].echoes, 2)
+}
+
+func TestDropBefore(t *testing.T) {
+	nodes, net, delivered := newTestNodes(t, 4, 1, 0)
+	for _, r := range nodes {
+		r.DropBefore(2)
+	}
+	nodes[0].Broadcast(1, []byte("late"))
+	nodes[0].Broadcast(2, []byte("current"))
+	net.run(nodes)
+
+	for nid := 0; nid < 4; nid++ {
+		assert.NotContains(t, delivered[nid], 1)
+		assert.Equal(t, []byte("current"), delivered[nid][2])
+	}
+}
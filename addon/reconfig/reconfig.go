@@ -0,0 +1,197 @@
+// Package reconfig contains the epoch beacon and the shard assignment of the committee reconfiguration.
+// The beacon chains the VRF outputs: the seed of epoch e is the VRF output on (e, the seed of epoch e-1),
+// so neither the beacon nor the nodes can choose the input, and every node verifies the seed without the beacon's chain.
+// The processes are identified by their initial seat g = sid*NodeNum + nid, the assignment maps every process to a seat
+package reconfig
+
+import (
+	rfccode "BlockChainSimulator/addon/coding"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+)
+
+// the randomness of an epoch, broadcast by the beacon and committed by every shard as message.ReqReconfig
+type Content struct {
+	Epoch    int    // the epoch starting after the request is committed
+	PrevSeed int64  // the seed of the previous epoch, the input of the VRF
+	Seed     int64  // the seed of the epoch, decides the assignment
+	Pi       []byte // the VRF proof of the seed
+}
+
+// a node joining a shard requests the final block and the states of the last epoch from the old members
+type StateRequest struct {
+	Epoch int    // the epoch starting
+	Sid   int    // the shard joined
+	Addr  string // the address to send the response to
+}
+
+// the final block of the shard in the last epoch and the states under its state root
+type StateResponse struct {
+	Epoch  int
+	Sid    int
+	Block  *structs.Block
+	Keys   [][]byte
+	Values [][]byte
+
+	NodeId int                  // the sender, a member of the shard in the last epoch
+	Sig    *signature.Signature // the signature of the sender on StateResponseContent, the states are checked against the state root
+}
+
+// the content signed by the old member serving the hand-over, the digest covers the whole final block,
+// the state root is set after the hash of the block is computed in PBFT
+func StateResponseContent(epoch int, sid int, blockDigest []byte) []byte {
+	return utils.CanonicalEncode(struct {
+		Epoch       int
+		Sid         int
+		BlockDigest []byte
+	}{epoch, sid, blockDigest})
+}
+
+// the digest of the final block, the responses of the old members agree on it
+func BlockDigest(b *structs.Block) []byte {
+	digest := sha256.Sum256(utils.CanonicalEncode(b))
+	return digest[:]
+}
+
+// a node is ready to run the consensus of the epoch at its new seat
+type ReadyContent struct {
+	Epoch    int
+	Identity int // the initial seat of the process
+	Sid      int // the new seat
+	Nid      int
+}
+
+// the VRF key of the beacon is generated by the client at startup, and only its public key is given to the nodes
+// by the command line(config.ReconfigBeaconKey), so nobody else can compute the seeds of the coming epochs
+func NewBeaconKey() *ecdsa.PrivateKey {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		log.Panic(err)
+	}
+	return sk
+}
+
+// the public key of the beacon in the hex of the PKIX form, passed to the nodes in config.ReconfigBeaconKey
+func EncodeBeaconKey(pk *ecdsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pk)
+	if err != nil {
+		log.Panic(err)
+	}
+	return hex.EncodeToString(der)
+}
+
+// the public key of the beacon given by the command line
+func BeaconPublicKey() (*ecdsa.PublicKey, error) {
+	der, err := hex.DecodeString(config.ReconfigBeaconKey)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := pk.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("the key of the beacon is not an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+// the VRF input of the epoch
+func beaconInput(epoch int, prevSeed int64) structs.Block {
+	digest := sha256.Sum256(utils.CanonicalEncode(struct {
+		Epoch    int
+		PrevSeed int64
+	}{epoch, prevSeed}))
+	return structs.Block{Hash: digest[:]}
+}
+
+// generate the randomness of the epoch following the seed of the previous epoch
+func NewContent(sk *ecdsa.PrivateKey, epoch int, prevSeed int64) *Content {
+	seed, pi := rfccode.GenRandSeed(*sk, beaconInput(epoch, prevSeed))
+	return &Content{
+		Epoch:    epoch,
+		PrevSeed: prevSeed,
+		Seed:     seed,
+		Pi:       pi,
+	}
+}
+
+// verify that the content is the next epoch of (epoch, seed), and the seed is proved by the beacon
+func Verify(pk *ecdsa.PublicKey, c *Content, epoch int, seed int64) bool {
+	if pk == nil || c.Epoch != epoch+1 || c.PrevSeed != seed {
+		return false
+	}
+	return rfccode.VerifyRandSeed(*pk, beaconInput(c.Epoch, c.PrevSeed), c.Seed, c.Pi)
+}
+
+// the seats of the processes before the first reconfiguration, every process sits in its initial seat
+func InitialSeats() []int {
+	seats := make([]int, config.ShardNum*config.NodeNum)
+	for g := range seats {
+		seats[g] = g
+	}
+	return seats
+}
+
+// the shard and the node id of a seat
+func SeatOf(seat int) (sid int, nid int) {
+	return seat / config.NodeNum, seat % config.NodeNum
+}
+
+// Assign computes the seats of the next epoch, seats[g] is the seat of process g.
+// swapNum <= 0 reshuffles all the processes, otherwise only swapNum processes picked by the seed rotate their seats,
+// which bounds the reconfiguration cost but leaves the others in place for longer
+func Assign(seats []int, seed int64, swapNum int) []int {
+	rander := rand.New(rand.NewSource(seed))
+	next := make([]int, len(seats))
+	if swapNum <= 0 || swapNum >= len(seats) {
+		for g, seat := range rander.Perm(len(seats)) {
+			next[g] = seat
+		}
+		return next
+	}
+
+	copy(next, seats)
+	moved := rander.Perm(len(seats))[:swapNum]
+	for i, g := range moved {
+		next[g] = seats[moved[(i+1)%swapNum]]
+	}
+	return next
+}
+
+// the address of every seat, the addresses follow the processes
+func AddrMap(addrs []string, seats []int) map[int]map[int]string {
+	ipMap := make(map[int]map[int]string)
+	for g, seat := range seats {
+		sid, nid := SeatOf(seat)
+		if ipMap[sid] == nil {
+			ipMap[sid] = make(map[int]string)
+		}
+		ipMap[sid][nid] = addrs[g]
+	}
+	return ipMap
+}
+
+// the number of malicious processes in every shard under the seats
+func MaliciousPerShard(seats []int, isMalicious func(g int) bool) []int {
+	counts := make([]int, config.ShardNum)
+	for g, seat := range seats {
+		if isMalicious(g) {
+			sid, _ := SeatOf(seat)
+			counts[sid]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,77 @@
+package reconfig
+
+import (
+	"BlockChainSimulator/config"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssign(t *testing.T) {
+	origShardNum, origNodeNum := config.ShardNum, config.NodeNum
+	config.ShardNum, config.NodeNum = 3, 4
+	defer func() {
+		config.ShardNum, config.NodeNum = origShardNum, origNodeNum
+	}()
+
+	seats := InitialSeats()
+	for epoch := 0; epoch < 20; epoch++ {
+		next := Assign(seats, int64(epoch), 0)
+		assert.Equal(t, next, Assign(seats, int64(epoch), 0), "the assignment is decided by the seed")
+
+		sorted := append([]int{}, next...)
+		sort.Ints(sorted)
+		assert.Equal(t, InitialSeats(), sorted, "every seat is taken by exactly one process")
+		seats = next
+	}
+
+	// only swapNum processes move
+	for _, swapNum := range []int{2, 5, 11} {
+		next := Assign(seats, 7, swapNum)
+		moved := 0
+		for g := range seats {
+			if next[g] != seats[g] {
+				moved++
+			}
+		}
+		assert.Equal(t, swapNum, moved)
+
+		sorted := append([]int{}, next...)
+		sort.Ints(sorted)
+		assert.Equal(t, InitialSeats(), sorted)
+	}
+
+	ipMap := AddrMap([]string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"}, seats)
+	for g, seat := range seats {
+		sid, nid := SeatOf(seat)
+		assert.Equal(t, string(rune('a'+g)), ipMap[sid][nid])
+	}
+
+	counts := MaliciousPerShard(seats, func(g int) bool { return g >= 8 })
+	assert.Equal(t, 4, counts[0]+counts[1]+counts[2])
+}
+
+func TestBeacon(t *testing.T) {
+	sk := NewBeaconKey()
+	config.ReconfigBeaconKey = EncodeBeaconKey(&sk.PublicKey)
+	pk, err := BeaconPublicKey()
+	require.NoError(t, err)
+	assert.True(t, sk.PublicKey.Equal(pk), "the nodes get the public key of the beacon")
+
+	c := NewContent(sk, 1, 0)
+	assert.True(t, Verify(&sk.PublicKey, c, 0, 0))
+	next := NewContent(sk, 2, c.Seed)
+	assert.True(t, Verify(&sk.PublicKey, next, 1, c.Seed))
+
+	// the epoch and the previous seed must follow the node's epoch
+	assert.False(t, Verify(&sk.PublicKey, c, 1, 0))
+	assert.False(t, Verify(&sk.PublicKey, next, 1, 0))
+
+	forged := *next
+	forged.Seed++
+	assert.False(t, Verify(&sk.PublicKey, &forged, 1, c.Seed), "the seed is bound to the proof")
+	assert.False(t, Verify(&NewBeaconKey().PublicKey, next, 1, c.Seed), "the seed is proved by the key of the beacon")
+	assert.False(t, Verify(nil, next, 1, c.Seed), "nothing is accepted without the key of the beacon")
+}
@@ -94,10 +94,11 @@ func (bc *BlockChain) NewGenisisBlock(db ethdb.Database) *structs.Block {
 		Preimages: true,
 	})
 
+	// the genesis block is the same in every node, so that the miners of PoW extend the same chain
 	bh := &structs.BlockHeader{
 		Nonce:     0,
 		Height:    0,
-		TimeStamp: time.Now(),
+		TimeStamp: time.Unix(0, 0).UTC(),
 		TxRoot:    GetTxTreeRoot(txs),
 		StateRoot: trie.NewEmpty(triedb).Hash().Bytes(),
 	}
@@ -132,7 +133,8 @@ func GetTxTreeRoot(txs []structs.Transaction) []byte {
 	triedb := trie.NewDatabase(rawdb.NewMemoryDatabase())
 	txTree := trie.NewEmpty(triedb)
 	for _, tx := range txs {
-		txTree.Update(tx.Hash(), utils.Encode(tx))
+		// the replicas recompute the root, the gob output differs between the processes once the txs have several types
+		txTree.Update(tx.Hash(), utils.CanonicalEncode(tx))
 	}
 	return txTree.Hash().Bytes()
 }
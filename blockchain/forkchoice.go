@@ -0,0 +1,230 @@
+// Description: This file contains the fork choice of the longest-chain consensus (PoW).
+// The blocks not confirmed yet are kept in a tree, the head is the tip of the heaviest chain, i.e. the chain with the most
+// total difficulty, which is the longest chain as long as the difficulty does not change.
+// A block is confirmed once it is confirmDepth blocks deep in the heaviest chain, the other branches below it become stale
+package blockchain
+
+import (
+	"BlockChainSimulator/structs"
+	"fmt"
+)
+
+// a block connected to the fork tree
+type ForkNode struct {
+	Block  *structs.Block
+	Parent *ForkNode // nil for the root, or for the confirmed blocks too deep to be kept
+	Work   int64     // the total difficulty of the chain ending at the block, counted from the first root of the tree
+}
+
+func (fn *ForkNode) Height() int64 {
+	return fn.Block.Header.Height
+}
+
+// the head moved from one chain to another, the blocks are detached from the old head down to the fork point,
+// and attached from the fork point up to the new head. A head extended by its child has nothing detached
+type Reorg struct {
+	Detached []*ForkNode
+	Attached []*ForkNode
+}
+
+type ForkTree struct {
+	nodes     map[string]*ForkNode        // the blocks connected to the tree, the key is the hash of the block
+	orphans   map[string][]*structs.Block // the blocks whose parent is unknown, the key is the hash of the parent
+	orphanSet map[string]bool             // the hashes of the orphans
+
+	Head *ForkNode // the tip of the heaviest chain
+	Root *ForkNode // the last confirmed block
+
+	Validate func(parent *ForkNode, b *structs.Block) error // checks a block against its parent before connecting it, nil accepts all the blocks
+
+	confirmDepth int
+	history      int // number of confirmed blocks kept under the root, for the rules looking back on the chain
+	maxOrphans   int // number of orphans buffered at most, also the distance above the head an orphan may be
+	Stale        int // number of blocks dropped from the branches other than the heaviest chain
+}
+
+// the fork tree starts from the current block of the chain
+func (bc *BlockChain) NewForkTree(confirmDepth int, history int, maxOrphans int) *ForkTree {
+	root := &ForkNode{Block: bc.CurrentBlock}
+	return &ForkTree{
+		nodes:     map[string]*ForkNode{string(root.Block.Hash): root},
+		orphans:   make(map[string][]*structs.Block),
+		orphanSet: make(map[string]bool),
+
+		Head: root,
+		Root: root,
+
+		confirmDepth: confirmDepth,
+		history:      history,
+		maxOrphans:   maxOrphans,
+	}
+}
+
+// whether the block is connected to the tree or buffered as an orphan
+func (ft *ForkTree) Known(hash []byte) bool {
+	_, ok := ft.nodes[string(hash)]
+	return ok || ft.orphanSet[string(hash)]
+}
+
+// Get returns the connected block of the hash, nil if it is unknown, an orphan or confirmed
+func (ft *ForkTree) Get(hash []byte) *ForkNode {
+	return ft.nodes[string(hash)]
+}
+
+// AddBlock connects the block to the tree, together with the orphans waiting for it.
+// It returns the connected blocks, and the reorg if the head moves. A block whose parent is unknown is buffered,
+// nothing is connected until the parent arrives. The orphans cost nothing to forge, so the buffer is bounded by maxOrphans
+// and the orphans far above the head are rejected
+func (ft *ForkTree) AddBlock(b *structs.Block) ([]*ForkNode, *Reorg, error) {
+	if ft.Known(b.Hash) {
+		return nil, nil, nil
+	}
+	if b.Header.Height <= ft.Root.Height() {
+		return nil, nil, fmt.Errorf("the block of height %d is under the confirmed block of height %d", b.Header.Height, ft.Root.Height())
+	}
+
+	parent, ok := ft.nodes[string(b.Header.PrevBlockHash)]
+	if !ok {
+		if b.Header.Height > ft.Head.Height()+int64(ft.maxOrphans) {
+			return nil, nil, fmt.Errorf("the orphan of height %d is too far above the head of height %d", b.Header.Height, ft.Head.Height())
+		}
+		if len(ft.orphanSet) >= ft.maxOrphans {
+			return nil, nil, fmt.Errorf("%d orphans are buffered, drop the orphan of height %d", len(ft.orphanSet), b.Header.Height)
+		}
+		ft.orphans[string(b.Header.PrevBlockHash)] = append(ft.orphans[string(b.Header.PrevBlockHash)], b)
+		ft.orphanSet[string(b.Hash)] = true
+		return nil, nil, nil
+	}
+
+	oldHead := ft.Head
+	node, err := ft.connect(parent, b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the orphans of the block are connected in the order of height
+	connected := []*ForkNode{node}
+	for i := 0; i < len(connected); i++ {
+		hash := string(connected[i].Block.Hash)
+		children := ft.orphans[hash]
+		delete(ft.orphans, hash)
+		for _, child := range children {
+			delete(ft.orphanSet, string(child.Hash))
+			if childNode, err := ft.connect(connected[i], child); err == nil {
+				connected = append(connected, childNode)
+			}
+		}
+	}
+
+	if ft.Head == oldHead {
+		return connected, nil, nil
+	}
+	return connected, ft.reorg(oldHead, ft.Head), nil
+}
+
+// the head moves to the block only if its chain is strictly heavier, so the first chain seen wins a tie
+func (ft *ForkTree) connect(parent *ForkNode, b *structs.Block) (*ForkNode, error) {
+	if b.Header.Height != parent.Height()+1 {
+		return nil, fmt.Errorf("the block of height %d does not follow its parent of height %d", b.Header.Height, parent.Height())
+	}
+	if ft.Validate != nil {
+		if err := ft.Validate(parent, b); err != nil {
+			return nil, err
+		}
+	}
+
+	node := &ForkNode{Block: b, Parent: parent, Work: parent.Work + b.Header.Difficulty}
+	ft.nodes[string(b.Hash)] = node
+	if node.Work > ft.Head.Work {
+		ft.Head = node
+	}
+	return node, nil
+}
+
+func (ft *ForkTree) reorg(from *ForkNode, to *ForkNode) *Reorg {
+	r := &Reorg{}
+	for from.Height() > to.Height() {
+		r.Detached = append(r.Detached, from)
+		from = from.Parent
+	}
+	for to.Height() > from.Height() {
+		r.Attached = append(r.Attached, to)
+		to = to.Parent
+	}
+	for from != to {
+		r.Detached = append(r.Detached, from)
+		r.Attached = append(r.Attached, to)
+		from, to = from.Parent, to.Parent
+	}
+
+	// attach from the fork point up
+	for i, j := 0, len(r.Attached)-1; i < j; i, j = i+1, j-1 {
+		r.Attached[i], r.Attached[j] = r.Attached[j], r.Attached[i]
+	}
+	return r
+}
+
+// Ancestor returns the block of the height in the chain ending at the node, nil if it is not kept
+func Ancestor(node *ForkNode, height int64) *ForkNode {
+	for node != nil && node.Height() > height {
+		node = node.Parent
+	}
+	if node == nil || node.Height() != height {
+		return nil
+	}
+	return node
+}
+
+// Confirm moves the root to the block confirmDepth blocks under the head, and returns the newly confirmed blocks in order.
+// The blocks not descending from the new root are dropped and counted as stale
+func (ft *ForkTree) Confirm() []*ForkNode {
+	newRoot := Ancestor(ft.Head, ft.Head.Height()-int64(ft.confirmDepth))
+	if newRoot == nil || newRoot.Height() <= ft.Root.Height() {
+		return nil
+	}
+
+	confirmed := make([]*ForkNode, 0, newRoot.Height()-ft.Root.Height())
+	for cur := newRoot; cur != ft.Root; cur = cur.Parent {
+		confirmed = append(confirmed, cur)
+	}
+	for i, j := 0, len(confirmed)-1; i < j; i, j = i+1, j-1 {
+		confirmed[i], confirmed[j] = confirmed[j], confirmed[i]
+	}
+
+	ft.Root = newRoot
+	for hash, node := range ft.nodes {
+		if node == newRoot {
+			continue
+		}
+		if node.Height() <= newRoot.Height() {
+			delete(ft.nodes, hash)
+			if Ancestor(newRoot, node.Height()) != node {
+				ft.Stale++
+			}
+		} else if Ancestor(node, newRoot.Height()) != newRoot {
+			delete(ft.nodes, hash)
+			ft.Stale++
+		}
+	}
+	for parentHash, children := range ft.orphans {
+		kept := children[:0]
+		for _, child := range children {
+			if child.Header.Height > newRoot.Height() {
+				kept = append(kept, child)
+			} else {
+				delete(ft.orphanSet, string(child.Hash))
+			}
+		}
+		if len(kept) == 0 {
+			delete(ft.orphans, parentHash)
+		} else {
+			ft.orphans[parentHash] = kept
+		}
+	}
+
+	// release the blocks too deep to be looked back on
+	if last := Ancestor(newRoot, newRoot.Height()-int64(ft.history)); last != nil {
+		last.Parent = nil
+	}
+	return confirmed
+}
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"BlockChainSimulator/structs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to create a mock block mined on the parent
+func createMockForkBlock(parent *structs.Block, difficulty int64, nonce int64) *structs.Block {
+	bh := &structs.BlockHeader{
+		PrevBlockHash: parent.Hash,
+		Height:        parent.Header.Height + 1,
+		Nonce:         nonce,
+		TimeStamp:     parent.Header.TimeStamp.Add(time.Second),
+		Difficulty:    difficulty,
+	}
+	return structs.NewBlock(bh, nil)
+}
+
+func TestForkChoice(t *testing.T) {
+	genesis := structs.NewBlock(&structs.BlockHeader{TimeStamp: time.Unix(0, 0).UTC()}, nil)
+	ft := (&BlockChain{CurrentBlock: genesis}).NewForkTree(2, 1, 8)
+
+	// a1 <- a2 is the first chain seen
+	a1 := createMockForkBlock(genesis, 10, 1)
+	a2 := createMockForkBlock(a1, 10, 1)
+	_, reorg, err := ft.AddBlock(a1)
+	assert.NoError(t, err)
+	assert.Empty(t, reorg.Detached)
+	_, _, err = ft.AddBlock(a2)
+	assert.NoError(t, err)
+	assert.Equal(t, a2.Hash, ft.Head.Block.Hash)
+
+	// b2 arrives before its parent b1, then b1 connects both, the tie keeps the first chain
+	b1 := createMockForkBlock(genesis, 10, 2)
+	b2 := createMockForkBlock(b1, 10, 2)
+	connected, reorg, err := ft.AddBlock(b2)
+	assert.NoError(t, err)
+	assert.Empty(t, connected)
+	assert.Nil(t, reorg)
+	assert.True(t, ft.Known(b2.Hash))
+	connected, reorg, err = ft.AddBlock(b1)
+	assert.NoError(t, err)
+	assert.Len(t, connected, 2)
+	assert.Nil(t, reorg)
+	assert.Equal(t, a2.Hash, ft.Head.Block.Hash)
+
+	// the heavier chain wins
+	b3 := createMockForkBlock(b2, 10, 2)
+	_, reorg, err = ft.AddBlock(b3)
+	assert.NoError(t, err)
+	assert.Equal(t, b3.Hash, ft.Head.Block.Hash)
+	assert.Len(t, reorg.Detached, 2)
+	assert.Equal(t, a2.Hash, reorg.Detached[0].Block.Hash)
+	assert.Len(t, reorg.Attached, 3)
+	assert.Equal(t, b1.Hash, reorg.Attached[0].Block.Hash)
+
+	// b1 is 2 blocks deep, a1 and a2 become stale
+	confirmed := ft.Confirm()
+	assert.Len(t, confirmed, 1)
+	assert.Equal(t, b1.Hash, confirmed[0].Block.Hash)
+	assert.Equal(t, 2, ft.Stale)
+	assert.False(t, ft.Known(a2.Hash))
+	assert.Nil(t, ft.Confirm())
+
+	// a heavier block wins against a longer chain
+	c2 := createMockForkBlock(b1, 25, 3)
+	_, reorg, err = ft.AddBlock(c2)
+	assert.NoError(t, err)
+	assert.Equal(t, c2.Hash, ft.Head.Block.Hash)
+	assert.Len(t, reorg.Detached, 2)
+
+	// the blocks under the root are rejected, so are the blocks failing the validation
+	_, _, err = ft.AddBlock(createMockForkBlock(genesis, 10, 4))
+	assert.Error(t, err)
+	ft.Validate = func(parent *ForkNode, b *structs.Block) error {
+		if b.Header.Difficulty != parent.Block.Header.Difficulty {
+			return errors.New("wrong difficulty")
+		}
+		return nil
+	}
+	_, _, err = ft.AddBlock(createMockForkBlock(c2, 10, 3))
+	assert.Error(t, err)
+}
+
+// the orphans are bounded in number and in the distance above the head
+func TestOrphansBounded(t *testing.T) {
+	genesis := structs.NewBlock(&structs.BlockHeader{TimeStamp: time.Unix(0, 0).UTC()}, nil)
+	ft := (&BlockChain{CurrentBlock: genesis}).NewForkTree(2, 1, 2)
+
+	unknown := structs.NewBlock(&structs.BlockHeader{Height: 5}, nil)
+	_, _, err := ft.AddBlock(createMockForkBlock(unknown, 10, 1))
+	assert.Error(t, err, "the orphan is too far above the head")
+
+	parent := createMockForkBlock(genesis, 10, 1)
+	for nonce := int64(1); nonce <= 2; nonce++ {
+		_, _, err = ft.AddBlock(createMockForkBlock(parent, 10, nonce))
+		assert.NoError(t, err)
+	}
+	_, _, err = ft.AddBlock(createMockForkBlock(parent, 10, 3))
+	assert.Error(t, err, "the buffer of the orphans is full")
+
+	// the buffered orphans are connected with their parent
+	connected, _, err := ft.AddBlock(parent)
+	assert.NoError(t, err)
+	assert.Len(t, connected, 3)
+}
@@ -0,0 +1,77 @@
+// Description: This file contains the stakes of the validators in the proof-of-stake consensus.
+// The stakes are the balances of the stake accounts in the state, allocated on the current block when the consensus starts
+package blockchain
+
+import (
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// AllocateStakes writes the stake accounts into the state of the genesis block, the accounts already in the state are kept.
+// Every validator allocates the same stakes, so they agree on the stakes of the sortition and on the hash of the genesis block
+func (bc *BlockChain) AllocateStakes(stakes map[string]*big.Int) {
+	if bc.CurrentBlock.Header.Height != 0 {
+		// the state of a block can not change after the block is hashed, the stakes are in the state since the genesis block
+		return
+	}
+	stm := bc.StateManager
+	st, err := trie.New(trie.TrieID(common.BytesToHash(bc.CurrentBlock.Header.StateRoot)), stm.triedb)
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to create the trie")
+		log.Panic(err)
+	}
+
+	changed := false
+	for addr, stake := range stakes {
+		if stateBytes, _ := st.Get([]byte(addr)); stateBytes != nil {
+			continue
+		}
+		state := &structs.AccountState{
+			AcAddress:    addr,
+			Balance:      new(big.Int).Set(stake),
+			DirtyBalance: new(big.Int).Set(stake),
+		}
+		st.Update([]byte(addr), utils.Encode(state))
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	rootHash, nodeSet := st.Commit(false)
+	if err := stm.triedb.Update(trie.NewWithNodeSet(nodeSet)); err != nil {
+		utils.LoggerInstance.Error("Failed to update the trie")
+		log.Panic(err)
+	}
+	if err := stm.triedb.Commit(rootHash, false); err != nil {
+		utils.LoggerInstance.Error("Failed to commit the trie")
+		log.Panic(err)
+	}
+	bc.CurrentBlock.Header.StateRoot = rootHash.Bytes()
+	bc.CurrentBlock.Hash = bc.CurrentBlock.Header.Hash()
+	bc.Storage.AddBlock(bc.CurrentBlock)
+}
+
+// GetBalance returns the balance of the account under the state root, nil if the account is not in the state
+func (stm *StateManager) GetBalance(stateRoot []byte, addr string) *big.Int {
+	st, err := trie.New(trie.TrieID(common.BytesToHash(stateRoot)), stm.triedb)
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to create the trie")
+		return nil
+	}
+	stateBytes, _ := st.Get([]byte(addr))
+	if stateBytes == nil {
+		return nil
+	}
+	state := &structs.AccountState{}
+	if err := utils.Decode(stateBytes, state); err != nil {
+		utils.LoggerInstance.Error("Failed to decode the state of the account %s", addr)
+		return nil
+	}
+	return state.Balance
+}
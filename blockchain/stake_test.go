@@ -0,0 +1,45 @@
+package blockchain
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/storage"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the stakes are written into the state of the genesis block, and the hash of the genesis block covers them
+func TestAllocateStakes(t *testing.T) {
+	origStoragePath := config.StoragePath
+	config.StoragePath = t.TempDir() + "/"
+	defer func() {
+		config.StoragePath = origStoragePath
+	}()
+
+	cc := createMockChainConfig()
+	db := rawdb.NewMemoryDatabase()
+	stm, err := NewStateManager(cc, db)
+	require.NoError(t, err)
+	bc := &BlockChain{ChainConfig: cc, Storage: storage.NewStorage(cc), StateManager: stm}
+	bc.AddGenesisBlock(bc.NewGenisisBlock(db))
+	emptyHash := bc.CurrentBlock.Hash
+
+	bc.AllocateStakes(map[string]*big.Int{"stake": big.NewInt(10)})
+	genesis := bc.CurrentBlock
+	assert.NotEqual(t, emptyHash, genesis.Hash)
+	assert.Equal(t, genesis.Header.Hash(), genesis.Hash)
+	assert.Equal(t, big.NewInt(10), stm.GetBalance(genesis.Header.StateRoot, "stake"))
+	stored, err := bc.Storage.GetBlock(genesis.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, genesis.Header.StateRoot, stored.Header.StateRoot)
+
+	// the state of a later block is never changed
+	b := bc.NewBlock(nil)
+	bc.CommitBlock(b)
+	bc.AllocateStakes(map[string]*big.Int{"other": big.NewInt(10)})
+	assert.Equal(t, b.Hash, bc.CurrentBlock.Hash)
+	assert.Nil(t, stm.GetBalance(bc.CurrentBlock.Header.StateRoot, "other"))
+}
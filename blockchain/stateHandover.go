@@ -0,0 +1,75 @@
+// Description: This file contains the state hand-over of the committee reconfiguration.
+// The old members of a shard export the states of the final block of the epoch, a node joining the shard installs them
+// and checks them against the state root of the block, see auxiliaryMod/reconfig.go
+package blockchain
+
+import (
+	"BlockChainSimulator/structs"
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ExportStates returns the keys and the values of all the states under the state root
+func (stm *StateManager) ExportStates(stateRoot []byte) ([][]byte, [][]byte) {
+	st, err := trie.New(trie.TrieID(common.BytesToHash(stateRoot)), stm.triedb)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	keys, values := make([][]byte, 0), make([][]byte, 0)
+	it := trie.NewIterator(st.NodeIterator(nil))
+	for it.Next() {
+		keys = append(keys, common.CopyBytes(it.Key))
+		values = append(values, common.CopyBytes(it.Value))
+	}
+	return keys, values
+}
+
+// ImportStates builds the trie of the states, returns the root of the trie
+func (stm *StateManager) ImportStates(keys [][]byte, values [][]byte) ([]byte, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("%d keys but %d values", len(keys), len(values))
+	}
+
+	st := trie.NewEmpty(stm.triedb)
+	for i := range keys {
+		if err := st.Update(keys[i], values[i]); err != nil {
+			return nil, err
+		}
+	}
+	rootHash, nodeSet := st.Commit(false)
+	if nodeSet != nil {
+		if err := stm.triedb.Update(trie.NewWithNodeSet(nodeSet)); err != nil {
+			return nil, err
+		}
+	}
+	if err := stm.triedb.Commit(rootHash, false); err != nil {
+		return nil, err
+	}
+
+	stm.mu.Lock()
+	stm.DirtyState = make(map[string]structs.State)
+	stm.Migrated = make(map[string]bool)
+	stm.mu.Unlock()
+	return rootHash.Bytes(), nil
+}
+
+// InstallState continues the chain from the block handed over by the old members of the shard,
+// the states must match the state root of the block
+func (bc *BlockChain) InstallState(b *structs.Block, keys [][]byte, values [][]byte) error {
+	root, err := bc.StateManager.ImportStates(keys, values)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, b.Header.StateRoot) {
+		return fmt.Errorf("the state root %x does not match the block %x", root, b.Header.StateRoot)
+	}
+
+	bc.CurrentBlock = b
+	bc.Storage.AddBlock(b)
+	return nil
+}
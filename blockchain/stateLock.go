@@ -0,0 +1,123 @@
+// Description: This file contains the locks of the contracts used by the two-phase locking of the cross-shard contract calls.
+// A participant shard locks the contracts of a call and executes it on the locked states, the states are held in Prepared
+// until the coordinator decides. The locks are NO_WAIT: a call never waits for a lock held by another call, the participant
+// votes no instead and the coordinator aborts the call, so there is no circular wait among the calls(no deadlock)
+package blockchain
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/exp/rand"
+)
+
+// whether the contract is locked by a cross-shard call
+func (stm *StateManager) IsLocked(addr string) bool {
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+	_, ok := stm.Locks[addr]
+	return ok
+}
+
+// LockContracts locks all the contracts for the call and executes the call on them, the updated states are held until the
+// call is committed or aborted. Returns false if any of the contracts is locked by another call or the call fails, no lock is held in this case
+func (stm *StateManager) LockContracts(call string, tx structs.Transaction, contracts []structs.Address, stateRoot []byte) bool {
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+
+	if _, ok := stm.Prepared[call]; ok {
+		// the lock request is received again
+		return true
+	}
+	for _, addr := range contracts {
+		if holder, ok := stm.Locks[addr]; ok && holder != call {
+			utils.LoggerInstance.Info("The contract %s is locked by another cross-shard call", addr)
+			return false
+		}
+	}
+
+	states := make([]structs.State, 0, len(contracts))
+	if config.TxVerifyTime {
+		// randomly delay the execution time of the call around execTimeDelay(ms) for each contract
+		delay := rand.Intn(config.ExecTimeDelay*2) + config.ExecTimeDelay
+		time.Sleep(time.Duration(delay) * time.Millisecond * time.Duration(len(contracts)))
+	} else {
+		st, err := trie.New(trie.TrieID(common.BytesToHash(stateRoot)), stm.triedb)
+		if err != nil {
+			utils.LoggerInstance.Error("Failed to create the trie")
+			log.Panic(err)
+		}
+		for _, addr := range contracts {
+			var state *structs.ContractState
+			if stm.DirtyState[addr] == nil {
+				stateBytes, _ := st.Get([]byte(addr))
+				if stateBytes == nil {
+					utils.LoggerInstance.Error("The contract %s has not been deployed", addr)
+					rollbackStates(states)
+					return false
+				}
+				state = &structs.ContractState{}
+				if err := utils.Decode(stateBytes, state); err != nil {
+					utils.LoggerInstance.Error("Failed to decode the state of the contract %s", addr)
+					rollbackStates(states)
+					return false
+				}
+			} else {
+				state = stm.DirtyState[addr].(*structs.ContractState)
+			}
+			states = append(states, state)
+			if !state.Update(tx) {
+				utils.LoggerInstance.Info("Failed to update the state of the contract %s", addr)
+				rollbackStates(states)
+				return false
+			}
+		}
+	}
+
+	for _, addr := range contracts {
+		stm.Locks[addr] = call
+	}
+	stm.Prepared[call] = states
+	return true
+}
+
+// CommitLocked moves the states prepared by the call to the DirtyState, they are committed with the block, and releases the locks
+func (stm *StateManager) CommitLocked(call string) {
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+
+	for _, state := range stm.Prepared[call] {
+		stm.DirtyState[string(state.GetKey())] = state
+	}
+	stm.releaseLocks(call)
+}
+
+// AbortLocked rolls back the states prepared by the call and releases the locks
+func (stm *StateManager) AbortLocked(call string) {
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+
+	rollbackStates(stm.Prepared[call])
+	stm.releaseLocks(call)
+}
+
+// the caller holds mu
+func (stm *StateManager) releaseLocks(call string) {
+	for addr, holder := range stm.Locks {
+		if holder == call {
+			delete(stm.Locks, addr)
+		}
+	}
+	delete(stm.Prepared, call)
+}
+
+func rollbackStates(states []structs.State) {
+	for _, state := range states {
+		state.Rollback()
+	}
+}
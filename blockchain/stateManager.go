@@ -7,6 +7,7 @@ import (
 	"BlockChainSimulator/utils"
 	"log"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -26,6 +27,14 @@ type StateManager struct {
 	UTXOSet *UTXOSet // the UTXO set of the blockchain
 
 	DirtyState map[string]structs.State // the state which has been updated but not committed
+
+	// Used by the two-phase locking of the cross-shard contract calls, see stateLock.go
+	Locks    map[string]string          // key: the contract address, value: the call holding the lock
+	Prepared map[string][]structs.State // key: the call, value: the states updated by the call, waiting for the decision
+
+	Migrated map[string]bool // the accounts moved to other shards, deleted from the trie when committed, see stateMigration.go
+
+	mu sync.Mutex // guards the DirtyState, the locks and the migrated accounts, the consensus mods and the cross-shard messages update them from several goroutines
 }
 
 func NewStateManager(cc *config.ChainConfig, db ethdb.Database) (*StateManager, error) {
@@ -36,6 +45,9 @@ func NewStateManager(cc *config.ChainConfig, db ethdb.Database) (*StateManager,
 
 	stm.triedb = trie.NewDatabaseWithConfig(stm.db, &trie.Config{Cache: 10})
 	stm.DirtyState = make(map[string]structs.State)
+	stm.Locks = make(map[string]string)
+	stm.Prepared = make(map[string][]structs.State)
+	stm.Migrated = make(map[string]bool)
 
 	return stm, nil
 }
@@ -51,6 +63,9 @@ func (stm *StateManager) UpdateStates(txs []structs.Transaction, stateRoot []byt
 		return flag
 	}
 
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+
 	st, err := trie.New(trie.TrieID(common.BytesToHash(stateRoot)), stm.triedb)
 	if err != nil {
 		utils.LoggerInstance.Error("Failed to create the trie")
@@ -63,18 +78,35 @@ func (stm *StateManager) UpdateStates(txs []structs.Transaction, stateRoot []byt
 		} else if tx.Type() == structs.AccountTransactionType {
 			acTx := tx.(*structs.AccountTransaction)
 
+			// the relay transaction of Monoxide, the shard of the sender has deducted the value, only credit the recipient
+			if acTx.Relayed && config.UsesRelayTxs() {
+				if utils.Addr2Shard(acTx.FinalRecipient) == stm.ChainConfig.ShardID {
+					stm.UpdateAccountState(acTx.FinalRecipient, acTx.Value, st, true)
+				}
+				continue
+			}
+
 			// update the state of the sender
 			if utils.Addr2Shard(acTx.Sender) == stm.ChainConfig.ShardID {
 				stm.UpdateAccountState(acTx.Sender, acTx.Value, st, false)
 			}
 
-			// update the state of the receiver
+			// update the state of the receiver, the recipient in another shard is credited by the relay transaction in Monoxide
 			if utils.Addr2Shard(acTx.Recipient) == stm.ChainConfig.ShardID {
 				stm.UpdateAccountState(acTx.Recipient, acTx.Value, st, true)
 			}
 		} else if tx.Type() == structs.ETHLikeContractTransactionType {
+			// the cross-shard call is executed when its contracts are locked
+			if tx.(*structs.ContractTransaction).IsCrossShard {
+				continue
+			}
 			receiver := tx.To()[0]
 			if utils.Addr2Shard(receiver) == stm.ChainConfig.ShardID {
+				if _, locked := stm.Locks[receiver]; locked {
+					utils.LoggerInstance.Info("The contract %s is locked by a cross-shard call", receiver)
+					flag = false
+					continue
+				}
 				// update the state of the sender
 				var state *structs.ContractState
 				// if the state is not in the DirtyState, get the state from the trie
@@ -85,7 +117,11 @@ func (stm *StateManager) UpdateStates(txs []structs.Transaction, stateRoot []byt
 						utils.LoggerInstance.Error("The contract %s has not been deployed", receiver)
 						continue
 					}
-					utils.Decode(stateBytes, state)
+					state = &structs.ContractState{}
+					if err := utils.Decode(stateBytes, state); err != nil {
+						utils.LoggerInstance.Error("Failed to decode the state of the contract %s", receiver)
+						continue
+					}
 				} else {
 					state = stm.DirtyState[receiver].(*structs.ContractState)
 				}
@@ -106,6 +142,9 @@ func (stm *StateManager) CommitStates(stateRoot []byte) []byte {
 	if config.TxVerifyTime {
 		return stateRoot
 	}
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+
 	st, err := trie.New(trie.TrieID(common.BytesToHash(stateRoot)), stm.triedb)
 	if err != nil {
 		utils.LoggerInstance.Error("Failed to create the trie")
@@ -116,6 +155,10 @@ func (stm *StateManager) CommitStates(stateRoot []byte) []byte {
 		state.Commit()
 		st.Update(state.GetKey(), utils.Encode(state))
 	}
+	for addr := range stm.Migrated {
+		st.Delete([]byte(addr))
+		delete(stm.Migrated, addr)
+	}
 
 	rootHash, nodeSet := st.Commit(false)
 
@@ -133,6 +176,7 @@ func (stm *StateManager) CommitStates(stateRoot []byte) []byte {
 	return rootHash.Bytes()
 }
 
+// the caller holds mu
 func (stm *StateManager) UpdateAccountState(addr string, amount *big.Int, st *trie.Trie, deposit bool) {
 	// update the state of the sender
 	var state *structs.AccountState
@@ -145,12 +189,18 @@ func (stm *StateManager) UpdateAccountState(addr string, amount *big.Int, st *tr
 			utils.LoggerInstance.Info("The account %s has not been created, now create it", addr)
 			// create a new account
 			state = &structs.AccountState{
-				AcAddress: addr,
-				Nonce:     0,
-				Balance:   config.Init_Balance,
+				AcAddress:    addr,
+				Nonce:        0,
+				Balance:      new(big.Int).Set(config.Init_Balance),
+				DirtyBalance: new(big.Int).Set(config.Init_Balance),
 			}
 		} else {
-			utils.Decode(stateBytes, state)
+			state = &structs.AccountState{}
+			if err := utils.Decode(stateBytes, state); err != nil {
+				utils.LoggerInstance.Error("Failed to decode the state of the account %s", addr)
+				return
+			}
+			state.Rollback() // the DirtyBalance starts from the committed balance
 		}
 	} else {
 		state = stm.DirtyState[addr].(*structs.AccountState)
@@ -3,10 +3,15 @@ package blockchain
 import (
 	"BlockChainSimulator/config"
 	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"fmt"
 	"math/big"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/trie"
 	"github.com/stretchr/testify/assert"
@@ -67,6 +72,173 @@ func TestUpdateStates(t *testing.T) {
 	assert.True(t, success)
 }
 
+// find an address belonging to the shard
+func addrInShard(t *testing.T, sid int, prefix string) string {
+	for i := 0; i < 1000; i++ {
+		addr := fmt.Sprintf("%s%032d", prefix, i)
+		if utils.Addr2Shard(addr) == sid {
+			return addr
+		}
+	}
+	t.Fatalf("no address in shard %d", sid)
+	return ""
+}
+
+func TestUpdateStatesRelay(t *testing.T) {
+	origShardNum, origTxVerifyTime := config.ShardNum, config.TxVerifyTime
+	config.ShardNum, config.TxVerifyTime = 2, false
+	defer func() {
+		config.ShardNum, config.TxVerifyTime = origShardNum, origTxVerifyTime
+	}()
+
+	cc := createMockChainConfig()
+	sender := addrInShard(t, 1, "sender")
+	recipient := addrInShard(t, 0, "recipient")
+	value := big.NewInt(100)
+	tx := structs.NewAccountTransaction(sender, recipient, 0, value)
+
+	// the shard of the sender only deducts the value
+	stm, _ := NewStateManager(cc, rawdb.NewMemoryDatabase())
+	stateRoot := trie.NewEmpty(stm.triedb).Hash().Bytes()
+	assert.True(t, stm.UpdateStates([]structs.Transaction{tx}, stateRoot))
+	assert.Equal(t, new(big.Int).Sub(config.Init_Balance, value), stm.DirtyState[sender].(*structs.AccountState).DirtyBalance)
+	assert.Nil(t, stm.DirtyState[recipient])
+
+	// the shard of the recipient credits the value of the relay tx, and does not touch the sender
+	cc.ShardID = 0
+	stm, _ = NewStateManager(cc, rawdb.NewMemoryDatabase())
+	assert.True(t, stm.UpdateStates([]structs.Transaction{structs.NewRelayTransaction(tx)}, stateRoot))
+	assert.Equal(t, new(big.Int).Add(config.Init_Balance, value), stm.DirtyState[recipient].(*structs.AccountState).DirtyBalance)
+	assert.Nil(t, stm.DirtyState[sender])
+
+	// the committed balance survives the next update
+	newRoot := stm.CommitStates(stateRoot)
+	stm.DirtyState = make(map[string]structs.State)
+	assert.True(t, stm.UpdateStates([]structs.Transaction{structs.NewRelayTransaction(tx)}, newRoot))
+	assert.Equal(t, new(big.Int).Add(config.Init_Balance, big.NewInt(200)), stm.DirtyState[recipient].(*structs.AccountState).DirtyBalance)
+}
+
+func TestLockContracts(t *testing.T) {
+	origTxVerifyTime := config.TxVerifyTime
+	config.TxVerifyTime = false
+	defer func() {
+		config.TxVerifyTime = origTxVerifyTime
+	}()
+
+	// deploy the contracts
+	cc := createMockChainConfig()
+	cc.ShardID = 0
+	local := addrInShard(t, 0, "local")
+	stm, _ := NewStateManager(cc, rawdb.NewMemoryDatabase())
+	for _, addr := range []string{"c1", "c2", "c3"} {
+		stm.DirtyState[addr] = &structs.ContractState{Addr: addr, Variables: map[string]string{"v": addr}}
+	}
+	stateRoot := stm.CommitStates(trie.NewEmpty(stm.triedb).Hash().Bytes())
+	stm.DirtyState = make(map[string]structs.State)
+	tx := structs.NewContractTransaction("sender", "c1", 0, time.Now(), nil, []string{"c2", "c3"}, true)
+
+	assert.True(t, stm.LockContracts("A", tx, []string{"c1", "c2"}, stateRoot))
+	assert.True(t, stm.LockContracts("A", tx, []string{"c1", "c2"}, stateRoot), "the lock request is received again")
+
+	// NO_WAIT, the call conflicting with A fails without holding any lock
+	assert.False(t, stm.LockContracts("B", tx, []string{"c3", "c2"}, stateRoot))
+	assert.False(t, stm.IsLocked("c3"))
+	assert.False(t, stm.LockContracts("C", tx, []string{"unknown"}, stateRoot), "the contract is not deployed")
+
+	// the states of the aborted call are not committed
+	stm.AbortLocked("A")
+	assert.False(t, stm.IsLocked("c1"))
+	assert.Empty(t, stm.DirtyState)
+
+	// the states of the committed call are committed with the block
+	assert.True(t, stm.LockContracts("B", tx, []string{"c3", "c2"}, stateRoot))
+	stm.Locks[local] = "B" // a local call of a locked contract fails
+	assert.False(t, stm.UpdateStates([]structs.Transaction{structs.NewContractTransaction("sender", local, 0, time.Now(), nil, nil, false)}, stateRoot))
+	delete(stm.Locks, local)
+	stm.CommitLocked("B")
+	assert.False(t, stm.IsLocked("c2"))
+	assert.Len(t, stm.DirtyState, 2)
+	assert.Equal(t, "c3", stm.DirtyState["c3"].(*structs.ContractState).Variables["v"])
+}
+
+// the cross-shard decisions arrive while the blocks are executed, no update is lost
+func TestConcurrentStateUpdates(t *testing.T) {
+	origShardNum, origTxVerifyTime := config.ShardNum, config.TxVerifyTime
+	config.ShardNum, config.TxVerifyTime = 1, false
+	defer func() {
+		config.ShardNum, config.TxVerifyTime = origShardNum, origTxVerifyTime
+	}()
+
+	cc := createMockChainConfig()
+	cc.ShardID = 0
+	sender, recipient := addrInShard(t, 0, "sender"), addrInShard(t, 0, "recipient")
+	stm, _ := NewStateManager(cc, rawdb.NewMemoryDatabase())
+	stm.DirtyState["c"] = &structs.ContractState{Addr: "c", Variables: map[string]string{}}
+	stateRoot := stm.CommitStates(trie.NewEmpty(stm.triedb).Hash().Bytes())
+	stm.DirtyState = make(map[string]structs.State)
+	call := structs.NewContractTransaction("sender", "c", 0, time.Now(), nil, nil, true)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			stm.UpdateStates([]structs.Transaction{createMockTransaction(sender, recipient, big.NewInt(1))}, stateRoot)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			name := strconv.Itoa(i)
+			if stm.LockContracts(name, call, []string{"c"}, stateRoot) {
+				stm.CommitLocked(name)
+			}
+		}
+	}()
+	wg.Wait()
+	assert.Equal(t, new(big.Int).Add(config.Init_Balance, big.NewInt(50)), stm.DirtyState[recipient].(*structs.AccountState).DirtyBalance)
+}
+
+func TestMigrateAccount(t *testing.T) {
+	origShardNum, origTxVerifyTime, origAlloc := config.ShardNum, config.TxVerifyTime, config.ShardAllocMethod
+	config.ShardNum, config.TxVerifyTime, config.ShardAllocMethod = 2, false, utils.TableAlloc
+	defer func() {
+		config.ShardNum, config.TxVerifyTime, config.ShardAllocMethod = origShardNum, origTxVerifyTime, origAlloc
+	}()
+
+	cc := createMockChainConfig()
+	account := addrInShard(t, 1, "account")
+	value := big.NewInt(100)
+	tx := structs.NewAccountTransaction(account, addrInShard(t, 1, "recipient"), 0, value)
+
+	// the account is spent in shard 1, then moved out
+	old, _ := NewStateManager(cc, rawdb.NewMemoryDatabase())
+	oldRoot := trie.NewEmpty(old.triedb).Hash().Bytes()
+	assert.True(t, old.UpdateStates([]structs.Transaction{tx}, oldRoot))
+	oldRoot = old.CommitStates(oldRoot)
+	old.DirtyState = make(map[string]structs.State)
+	state := old.MigrateOut(account, oldRoot)
+	assert.NotNil(t, state)
+	assert.Nil(t, old.MigrateOut("unknown-account", oldRoot), "the account has no state in the shard")
+
+	// the account is deleted from the trie of the old shard when the block is committed
+	oldRoot = old.CommitStates(oldRoot)
+	st, _ := trie.New(trie.TrieID(common.BytesToHash(oldRoot)), old.triedb)
+	stateBytes, _ := st.Get([]byte(account))
+	assert.Nil(t, stateBytes)
+
+	// the new shard continues from the migrated balance
+	utils.CurrentShardTable().Set(account, 0)
+	cc = createMockChainConfig()
+	cc.ShardID = 0
+	stm, _ := NewStateManager(cc, rawdb.NewMemoryDatabase())
+	stm.MigrateIn(addrInShard(t, 0, "other"), state)
+	assert.Empty(t, stm.DirtyState, "the state of another account is not installed")
+	stm.MigrateIn(account, state)
+	assert.True(t, stm.UpdateStates([]structs.Transaction{tx}, trie.NewEmpty(stm.triedb).Hash().Bytes()))
+	assert.Equal(t, new(big.Int).Sub(config.Init_Balance, big.NewInt(200)), stm.DirtyState[account].(*structs.AccountState).DirtyBalance)
+}
+
 // func TestCommitStates(t *testing.T) {
 // 	db := memorydb.New()
 // 	cc := createMockChainConfig()
@@ -127,3 +299,37 @@ func TestUpdateStates(t *testing.T) {
 // 	success := stm.UpdateStates(txs, invalidStateRoot)
 // 	assert.False(t, success)
 // }
+
+// a node joining the shard rebuilds the same trie from the exported states
+func TestHandoverStates(t *testing.T) {
+	origTxVerifyTime := config.TxVerifyTime
+	config.TxVerifyTime = false
+	defer func() {
+		config.TxVerifyTime = origTxVerifyTime
+	}()
+
+	cc := createMockChainConfig()
+	old, _ := NewStateManager(cc, rawdb.NewMemoryDatabase())
+	for i := 0; i < 20; i++ {
+		addr := "account" + strconv.Itoa(i)
+		old.DirtyState[addr] = &structs.AccountState{AcAddress: addr, Balance: big.NewInt(int64(i)), DirtyBalance: big.NewInt(int64(i))}
+	}
+	stateRoot := old.CommitStates(trie.NewEmpty(old.triedb).Hash().Bytes())
+
+	keys, values := old.ExportStates(stateRoot)
+	assert.Len(t, keys, 20)
+
+	joiner, _ := NewStateManager(cc, rawdb.NewMemoryDatabase())
+	root, err := joiner.ImportStates(keys, values)
+	assert.NoError(t, err)
+	assert.Equal(t, stateRoot, root)
+
+	// a tampered state changes the root
+	values[3] = utils.Encode(&structs.AccountState{AcAddress: "account3", Balance: big.NewInt(1000), DirtyBalance: big.NewInt(1000)})
+	root, err = joiner.ImportStates(keys, values)
+	assert.NoError(t, err)
+	assert.NotEqual(t, stateRoot, root)
+
+	_, err = joiner.ImportStates(keys, values[1:])
+	assert.Error(t, err)
+}
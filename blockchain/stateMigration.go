@@ -0,0 +1,71 @@
+// Description: This file contains the migration of the account states between the shards.
+// The old shard exports the state of a moved account and deletes it from its trie when the block is committed,
+// the new shard installs the state into the DirtyState, so the account is committed into its trie with the block
+package blockchain
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"log"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// MigrateOut exports the state of the account moved to another shard, returns nil if the account has no state in this shard
+func (stm *StateManager) MigrateOut(addr string, stateRoot []byte) []byte {
+	if config.TxVerifyTime {
+		return nil
+	}
+
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+
+	state, ok := stm.DirtyState[addr].(*structs.AccountState)
+	if !ok {
+		st, err := trie.New(trie.TrieID(common.BytesToHash(stateRoot)), stm.triedb)
+		if err != nil {
+			utils.LoggerInstance.Error("Failed to create the trie")
+			log.Panic(err)
+		}
+		stateBytes, _ := st.Get([]byte(addr))
+		if stateBytes == nil {
+			return nil
+		}
+		state = &structs.AccountState{}
+		if err := utils.Decode(stateBytes, state); err != nil {
+			utils.LoggerInstance.Error("Failed to decode the state of the account %s", addr)
+			return nil
+		}
+		state.Rollback()
+	}
+
+	delete(stm.DirtyState, addr)
+	stm.Migrated[addr] = true
+	state.Commit()
+	return utils.Encode(state)
+}
+
+// MigrateIn installs the state of the account moved from another shard
+func (stm *StateManager) MigrateIn(addr string, stateBytes []byte) {
+	stm.mu.Lock()
+	defer stm.mu.Unlock()
+
+	delete(stm.Migrated, addr)
+	if config.TxVerifyTime || len(stateBytes) == 0 {
+		return
+	}
+
+	state := &structs.AccountState{}
+	if err := utils.Decode(stateBytes, state); err != nil {
+		utils.LoggerInstance.Error("Failed to decode the state of the migrated account %s", addr)
+		return
+	}
+	if state.AcAddress != addr {
+		utils.LoggerInstance.Error("The migrated state belongs to the account %s, not %s", state.AcAddress, addr)
+		return
+	}
+	state.Rollback()
+	stm.DirtyState[addr] = state
+}
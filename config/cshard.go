@@ -6,5 +6,5 @@ var (
 	LL_Shard = 0             // Indicate LL belongs to which shard
 	RandSeed = int64(114614) // Use in RFC Encoding
 
-	Verify_Interval = 10 // request verify interval
+	Verify_Interval = 10 // (ms) the interval of a shard leader to check the rounds ready to verify
 )
@@ -0,0 +1,5 @@
+package config
+
+var DAGRoundTimeout = 1000  // (ms) a node waits at most DAGRoundTimeout for the anchor in an even round, and for the votes on it in an odd round
+var DAGMaxHeaderDelay = 200 // (ms) a node without pending txs still creates a header every DAGMaxHeaderDelay
+var DAGGCDepth = 50         // (rounds) the vertices DAGGCDepth rounds below the last committed anchor are dropped
@@ -0,0 +1,26 @@
+package config
+
+import "strconv"
+
+var (
+	HBBFTBatchSize = 0 // B in the paper, the txs committed in an epoch at most, every node proposes B/N random txs of its first B pending txs; 0 means BlockSize
+)
+
+// the file holding the threshold keys of the coin of a node, written by the client and readable by its owner only.
+// In the distributed mode, the files are copied to the same path of the servers running the nodes
+func HBBFTCoinKeyFile(sid int, nid int) string {
+	return StoragePath + strconv.Itoa(sid) + "_" + strconv.Itoa(nid) + ".coin"
+}
+
+// the number of the coin shares recovering the coin of a shard, f+1 of the N nodes
+func HBBFTCoinThreshold() int {
+	return (NodeNum-1)/3 + 1
+}
+
+// the number of the pending txs a node chooses its batch from
+func HBBFTEpochSize() int {
+	if HBBFTBatchSize > 0 {
+		return HBBFTBatchSize
+	}
+	return BlockSize
+}
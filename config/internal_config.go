@@ -23,13 +23,18 @@ var (
 
 // config of the blockchain
 var (
-	TxType            = string("")
-	BlockSize         = 500
-	ConsensusInterval = 100                                                                         // (ms) the interval of the each round of consensus
-	Init_Balance, _   = new(big.Int).SetString("100000000000000000000000000000000000000000000", 10) // A new coinbase Tx
-	IPMap             = make(map[int]map[int]string)                                                // IPmap_nodeTable[shardID][nodeID] = "IP:Port"
-	MeasureMethod     = []string{"TPS", "TCL", "WaitLen"}                                           // the client measure method, must muanlly set at here
-	ConsensusMethod   = string("")                                                                  // the method of the consensus, set through the command line
+	TxType             = string("")
+	BlockSize          = 500
+	ConsensusInterval  = 100                                                                         // (ms) the interval of the each round of consensus
+	ViewChangeTimeout  = 5000                                                                        // (ms) a replica suspects the primary if a request is not committed in time
+	CheckpointInterval = 10                                                                          // (rounds) a replica broadcasts a checkpoint every CheckpointInterval committed rounds
+	WatermarkWindow    = 40                                                                          // (rounds) a replica only accepts the rounds in [stable checkpoint, stable checkpoint + WatermarkWindow)
+	PipelineWindow     = 4                                                                           // (rounds) max number of rounds proposed by the PBFT primary but not committed yet, or of DS/TBB instances running at the same time
+	CrossCallTimeout   = 15000                                                                       // (ms) the coordinator aborts a cross-shard contract call if the votes of the participants are not received in time, every phase waits for a block
+	Init_Balance, _    = new(big.Int).SetString("100000000000000000000000000000000000000000000", 10) // A new coinbase Tx
+	IPMap              = make(map[int]map[int]string)                                                // IPmap_nodeTable[shardID][nodeID] = "IP:Port"
+	MeasureMethod      = []string{"TPS", "TCL", "WaitLen"}                                           // the client measure method, must muanlly set at here
+	ConsensusMethod    = string("")                                                                  // the method of the consensus, set through the command line
 )
 
 // config of the running environment
@@ -39,10 +44,12 @@ var (
 
 	LogLevel = "INFO" // default log level
 
-	MaliciousRatio float64 = 1. / 3 // the ratio of malicious nodes in the network
-	ResilientRatio float64 = 1. / 2 // the ratio of resilient nodes in the network
-	IsMalicious            = false  // whether this node is malicious
-	IsDistributed          = false  // Running in local environment or not
+	MaliciousRatio    float64 = 1. / 3   // the ratio of malicious nodes in the network
+	ResilientRatio    float64 = 1. / 2   // the ratio of resilient nodes in the network
+	IsMalicious               = false    // whether this node is malicious
+	MaliciousStrategy         = "Silent" // the behaviour of the malicious node, see pbft/handler_pbft_m.go
+	MaliciousDelay            = 3000     // (ms) the delay of the votes sent by a malicious node with the DelayVote strategy
+	IsDistributed             = false    // Running in local environment or not
 
 	ConnectRemoteDemo = false
 )
@@ -56,11 +63,33 @@ func InitConfig(args *Args) {
 	MaliciousRatio = args.MaliciousRatio
 	ResilientRatio = args.ResilientRatio
 	IsMalicious = args.IsMalicious
+	MaliciousStrategy = args.MaliciousStrategy
 	ConnectRemoteDemo = args.ConnetRemoteDemo
 	TxType = args.TxType
 	LogLevel = args.LogLevel
 	TxInjectCount = args.TxInjectCount
 	TxInjectSpeed = args.TxInjectSpeed
+	ReconfigBeaconKey = args.BeaconKey
+
+	// the latency of the cross-shard txs is measured by the methods committing them in the blocks of the destination shards
+	if UsesRelayTxs() || ConsensusMethod == "TBD" {
+		MeasureMethod = append(MeasureMethod, "CrossTCL")
+	}
+
+	// the first K shards are origin shards in CShard
+	K = args.OriginShardNum
+	if K <= 0 || K > ShardNum {
+		K = (ShardNum + 1) / 2
+	}
+
+	// CLPA moves the accounts through the lookup table, the mimic txs reuse the accounts so that the tx graph is worth partitioning
+	ShardAllocMethod = args.ShardAllocMethod
+	if ConsensusMethod == "CLPA" {
+		ShardAllocMethod = "Table"
+		if MimicAccountNum == 0 {
+			MimicAccountNum = 10000
+		}
+	}
 
 	if args.IsClient {
 		args.ShardID = ClientShard
@@ -0,0 +1,13 @@
+package config
+
+var (
+	ShardAllocMethod = "Hash" // how the accounts are allocated to the shards: Hash, Range or Table, see utils/shard.go
+
+	EpochInterval  = 10  // (s) the interval of the epochs, the accounts are migrated at the epoch boundaries
+	CLPAIterations = 10  // the rounds of the label propagation in an epoch
+	CLPABeta       = 0.5 // the weight of the load balance in the label propagation, the higher the more balanced
+	MaxMigration   = 500 // max number of accounts moved in an epoch
+
+	MimicAccountNum = 0   // the number of accounts used by the mimic txs, 0 means a fresh random address for each tx
+	MimicZipfS      = 1.1 // the skew of the zipf distribution of the accounts in the mimic txs
+)
@@ -0,0 +1,10 @@
+package config
+
+var MirEpochLength = 8        // the buckets rotate among the instances every MirEpochLength rounds of an instance
+var MirBucketsPerInstance = 4 // the request space is partitioned into MirBucketsPerInstance buckets per instance
+var MirBatchTimeout = 500     // (ms) an idle primary proposes an empty batch after MirBatchTimeout, at once if its instance falls behind
+
+// the number of the buckets of the request space, every node leads an instance
+func MirBucketNum() int {
+	return MirBucketsPerInstance * NodeNum
+}
@@ -0,0 +1,7 @@
+package config
+
+// whether the cross-shard txs are split into the deduction in the shard of the sender and the relay tx crediting the recipient,
+// the methods built on Monoxide(CLPA and Reconfig move the accounts or the nodes between the shards of Monoxide)
+func UsesRelayTxs() bool {
+	return ConsensusMethod == "Monoxide" || ConsensusMethod == "CLPA" || ConsensusMethod == "Reconfig"
+}
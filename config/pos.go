@@ -0,0 +1,29 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strconv"
+)
+
+var (
+	PoSStake         = 1000  // the stake of a validator in the genesis state, every unit of stake is a sub-user in the sortition
+	PoSStakeSkew     = 0.0   // the stake of validator nid is PoSStake/(nid+1)^PoSStakeSkew, 0 gives all the validators the same stake
+	PoSProposerSize  = 20    // the expected number of the proposer sub-users in a period
+	PoSCommitteeSize = 1000  // the expected number of the votes of the committee in a step
+	PoSThreshold     = 0.685 // a value needs more than PoSThreshold*PoSCommitteeSize votes in a step
+	PoSStepTimeout   = 500   // (ms) λ, a period soft votes at 2λ and next votes at 4λ, then every 2λ
+	PoSStakeLookback = 10    // (blocks) the stakes are refreshed every PoSStakeLookback blocks from the state of a block at least PoSStakeLookback blocks behind
+)
+
+// the account holding the stake of node nid in shard sid
+func StakeAddress(sid int, nid int) Address {
+	hash := sha256.Sum256([]byte("stake-" + strconv.Itoa(sid) + "-" + strconv.Itoa(nid)))
+	return hex.EncodeToString(hash[:20])
+}
+
+// the stake of node nid in the genesis state
+func ValidatorStake(nid int) int64 {
+	return max(int64(float64(PoSStake)/math.Pow(float64(nid+1), PoSStakeSkew)), 1)
+}
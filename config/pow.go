@@ -0,0 +1,21 @@
+package config
+
+var (
+	PoWBlockInterval    = 2000 // (ms) the target interval of the blocks, the difficulty is adjusted towards it
+	PoWRetargetInterval = 10   // (blocks) the difficulty is adjusted every PoWRetargetInterval blocks
+	PoWHashRate         = 1000 // (hashes/s) the simulated hash rate of a miner
+	PoWInitDifficulty   = 0    // the difficulty of the first blocks, 0 means the difficulty fitting the target interval when all the miners are honest
+	PoWConfirmDepth     = 6    // (blocks) a block is confirmed once PoWConfirmDepth blocks are mined on it in the heaviest chain
+	PoWGossipDelay      = 100  // (ms) the simulated latency of a hop in the block gossip, the stale blocks come from it
+	PoWGossipFanout     = 2    // the number of random peers a miner pushes a new block to, 0 means all the miners of the shard
+	PoWMaxOrphans       = 64   // the number of the orphan blocks buffered at most, the orphans more than PoWMaxOrphans blocks above the head are dropped
+	PoWMaxFutureTime    = 2000 // (ms) a block whose time stamp is ahead of the local clock by more than PoWMaxFutureTime is rejected
+)
+
+// the difficulty of the first blocks
+func PoWGenesisDifficulty() int64 {
+	if PoWInitDifficulty > 0 {
+		return int64(PoWInitDifficulty)
+	}
+	return int64(NodeNum) * int64(PoWHashRate) * int64(PoWBlockInterval) / 1000
+}
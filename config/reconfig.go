@@ -0,0 +1,15 @@
+package config
+
+var (
+	ReconfigInterval = 20    // (s) the interval of the epochs, the nodes are reassigned to the shards at the epoch boundaries
+	ReconfigSwapNum  = 0     // the number of nodes moved in an epoch, 0 reshuffles all the nodes
+	ReconfigTimeout  = 10000 // (ms) how long a node waits for the state of its new shard and for the other members to be ready
+
+	ReconfigBeaconKey = "" // the public key of the beacon(hex of the PKIX form), generated by the client and given to the nodes by the command line
+)
+
+// whether the process started at (sid, nid) is malicious, the last MaliciousRatio of the processes are malicious.
+// The processes keep their identity when they are moved to other shards
+func IsMaliciousProcess(sid int, nid int) bool {
+	return float64(sid*NodeNum+nid) > (1-MaliciousRatio)*float64(ShardNum*NodeNum)
+}
@@ -11,6 +11,10 @@ type Args struct {
 	ShardNum  int // indicate that how many shards are deployed
 	BlockSize int // how many Txs per block
 
+	OriginShardNum int // how many shards are origin shards in CShard, the others are coded shards
+
+	ShardAllocMethod string // how the accounts are allocated to the shards, for example, Hash
+
 	// <-- Running Config Related -->
 	IsClient     bool // whether this node is a client
 	IsDistribute bool // whether the environment is distribute or local
@@ -19,12 +23,16 @@ type Args struct {
 	ResilientRatio float64 // the ratio of resilient nodes in the network
 	IsMalicious    bool    // whether this node is malicious
 
+	MaliciousStrategy string // the behaviour of the malicious node, for example, Silent
+
 	ConsensusMethod string // choice fo consensus Method, for example, CShard
 	TxType          string // choice of TxType, for example, UTXO
 	LogLevel        string // Set the log level of [DEBUG, INFO, WARN, ERROR]
 
 	ConnetRemoteDemo bool // whether the node is connected to the remote demo
 
+	BeaconKey string // the public key of the beacon of the committee reconfiguration, given to the nodes by the client
+
 	// <-- Client Config Related -->
 	TxInjectCount int // how many txs to inject
 	TxInjectSpeed int // how many txs to inject per second
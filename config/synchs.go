@@ -0,0 +1,3 @@
+package config
+
+var SyncHSBlameTimeout = 4 // (Δ) a replica blames the leader of Sync HotStuff if no new proposal arrives in SyncHSBlameTimeout*TickInterval
@@ -0,0 +1,59 @@
+// Package testutil contains the helpers shared by the tests of the running mods, the simulator never imports it.
+// The helpers leave the config alone, a test sets config.ShardNum and config.NodeNum to cover the seats it uses
+package testutil
+
+import (
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/signature"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+// Keys are the key pairs of the nodes of a shard, shared by the attributes of the nodes
+type Keys struct {
+	Sid int
+	Sks []*signature.SecretKey
+	Pks []*signature.PublicKey
+}
+
+// NewKeys generates the key pairs of the n nodes of shard sid
+func NewKeys(sid int, n int) *Keys {
+	keys := &Keys{Sid: sid, Sks: make([]*signature.SecretKey, n), Pks: make([]*signature.PublicKey, n)}
+	for nid := 0; nid < n; nid++ {
+		keys.Sks[nid], keys.Pks[nid] = signature.GenerateKeyPair()
+	}
+	return keys
+}
+
+// Attr returns the attribute of node nid knowing the keys of all the nodes of the shard, nothing is sent to the network
+func (keys *Keys) Attr(t testing.TB, nid int) *nodeattr.NodeAttr {
+	t.Helper()
+	vrfKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the VRF key: %v", err)
+	}
+	attr := &nodeattr.NodeAttr{
+		Sid:         keys.Sid,
+		Nid:         nid,
+		SecKey:      keys.Sks[nid],
+		PubKey:      keys.Pks[nid],
+		VRFKey:      vrfKey,
+		PubKeyTable: map[int]map[int]*signature.PublicKey{keys.Sid: {}},
+		VRFKeyTable: map[int]map[int]*ecdsa.PublicKey{keys.Sid: {}},
+	}
+	for i, pk := range keys.Pks {
+		attr.PubKeyTable[keys.Sid][i] = pk
+	}
+	attr.VRFKeyTable[keys.Sid][nid] = &vrfKey.PublicKey
+	return attr
+}
+
+// NewAttr returns the attribute of node nid in shard sid knowing the keys of all the n nodes of the shard,
+// and the secret keys of the nodes
+func NewAttr(t testing.TB, sid int, nid int, n int) (*nodeattr.NodeAttr, []*signature.SecretKey) {
+	t.Helper()
+	keys := NewKeys(sid, n)
+	return keys.Attr(t, nid), keys.Sks
+}
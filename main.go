@@ -5,6 +5,7 @@ import (
 	"BlockChainSimulator/node"
 	"BlockChainSimulator/utils"
 	"fmt"
+	"os"
 
 	"github.com/spf13/pflag"
 )
@@ -18,6 +19,8 @@ func main() {
 	blockchainFlags.IntVarP(&args.ShardID, "shardID", "s", 0, "id of the shard to which this node belongs, for example, 0")
 	blockchainFlags.IntVarP(&args.ShardNum, "shardNum", "S", 1, "indicate that how many shards are deployed")
 	blockchainFlags.IntVarP(&args.BlockSize, "blockSize", "b", 500, "how many Txs per block")
+	blockchainFlags.IntVarP(&args.OriginShardNum, "originShardNum", "K", 0, "how many shards are origin shards in CShard, default half of the shards")
+	blockchainFlags.StringVarP(&args.ShardAllocMethod, "shardAlloc", "a", "Hash", "how the accounts are allocated to the shards, for example, Hash, Range, Table")
 	// <-- Running Config Related -->
 	runningFlags := pflag.NewFlagSet("Running Config Related", pflag.ExitOnError)
 	runningFlags.BoolVarP(&args.IsClient, "isClient", "c", false, "whether this node is a client")
@@ -26,10 +29,12 @@ func main() {
 	runningFlags.Float64VarP(&args.MaliciousRatio, "maliciousRatio", "r", 0, "the ratio of malicious nodes in the network")
 	runningFlags.Float64VarP(&args.ResilientRatio, "resilientRatio", "R", 0.5, "the ratio of resilient nodes in the network")
 	runningFlags.BoolVarP(&args.IsMalicious, "isMalicious", "M", false, "whether this node is malicious")
+	runningFlags.StringVarP(&args.MaliciousStrategy, "maliciousStrategy", "B", "Silent", "the behaviour of the malicious node, for example, Silent, Equivocate, ConflictVote, DelayVote, InvalidBlock of PBFT, Silent, SelectiveForward, LateForward of DS and TBB, DoubleVote, WithholdQC of TBB")
 	runningFlags.StringVarP(&args.ConsensusMethod, "consensusMethod", "m", "Monoxide", "choice fo consensus Method, for example, Monoxide")
 	runningFlags.StringVarP(&args.TxType, "txType", "t", "UTXO", "choice of TxType, for example, UTXO")
 	runningFlags.StringVarP(&args.LogLevel, "logLevel", "l", "INFO", "Set the log level of [DEBUG, INFO, WARN, ERROR]")
 	runningFlags.BoolVarP(&args.ConnetRemoteDemo, "connetRemoteDemo", "C", false, "whether the node is connected to the remote demo")
+	runningFlags.StringVarP(&args.BeaconKey, "beaconKey", "k", "", "the public key of the reconfiguration beacon, set by the client when it starts the nodes")
 	// <-- Client Config Related -->
 	clientFlags := pflag.NewFlagSet("Client Config Related", pflag.ExitOnError)
 	clientFlags.IntVarP(&args.TxInjectCount, "txInjectCount", "i", 80000, "how many txs to inject")
@@ -72,26 +77,37 @@ func main() {
 		return
 	}
 
+	protocol := PredefinedProtocolMods[args.ConsensusMethod]
 	if args.IsClient {
 		utils.LoggerInstance.Debug("This node is a client")
-		runningNode, err = node.NewNode(config.ClientShard, 0, &pcc, PredefinedProtocolMods[args.ConsensusMethod].clientMods)
-	} else if args.NodeID == config.ViewNodeId {
-		utils.LoggerInstance.Debug("This node is a view node")
-		runningNode, err = node.NewNode(args.ShardID, args.NodeID, &pcc, PredefinedProtocolMods[args.ConsensusMethod].viewNodeMods)
+		runningNode, err = node.NewNode(config.ClientShard, 0, &pcc, func(int) []string { return protocol.clientMods })
 	} else {
-		utils.LoggerInstance.Debug("This node is a normal node")
-		runningNode, err = node.NewNode(args.ShardID, args.NodeID, &pcc, PredefinedProtocolMods[args.ConsensusMethod].nodeMods)
+		if args.NodeID == config.ViewNodeId {
+			utils.LoggerInstance.Debug("This node is a view node")
+		} else {
+			utils.LoggerInstance.Debug("This node is a normal node")
+		}
+		// the node may be reassigned to another seat at the epoch boundaries, the running mods follow the seat
+		runningNode, err = node.NewNode(args.ShardID, args.NodeID, &pcc, func(nid int) []string {
+			if nid == config.ViewNodeId {
+				return protocol.viewNodeMods
+			}
+			return protocol.nodeMods
+		})
 	}
 
 	if err != nil {
 		utils.LoggerInstance.Error("Error creating node: %v", err)
-		return
+		os.Exit(1)
 	}
 
 	if runningNode == nil {
 		utils.LoggerInstance.Error("runningNode is nil")
-		return
+		os.Exit(1)
 	}
 
-	runningNode.Run()
+	if err := runningNode.Run(); err != nil {
+		utils.LoggerInstance.Error("Node exits with error: %v", err)
+		os.Exit(1)
+	}
 }
@@ -37,15 +37,70 @@ const (
 	MsgVote          // to vote
 	MsgQC            // to send the quorum certificate
 	MsgConsensusDone // to notify the consensus is done
+	MsgViewChange    // to suspect the current primary and move to a new view
+	MsgNewView       // sent by the new primary to start the new view
+	MsgCheckpoint    // to prove the rounds before a checkpoint are committed
 
 	// Sync-related
 	MsgRequestSeq
 	MsgSeq
+	MsgPubKey          // to send the public key of a node, answering a challenge
+	MsgPubKeyChallenge // to ask a seat for its public key, the nonce is sent to the address of the seat only
 
 	// CShard protocol
 	MsgInputVerifyResult // L sends the result of input verification to LL
 	MsgPreInject         // used to pre-inject the data to the system
 	MsgBlockLegal        // a legal block, used to store the block
+
+	// Committee reconfiguration
+	MsgReconfig      // the beacon broadcasts the randomness of the next epoch
+	MsgEpochEnd      // local, the consensus tells the reconfiguration that the epoch ends at the committed request
+	MsgStateRequest  // a node joining a shard requests the final block and the states of the last epoch
+	MsgStateResponse // an old member of the shard replies to the state request
+	MsgEpochReady    // a node is ready to run the consensus of the new epoch in its new shard
+
+	// Monoxide protocol
+	MsgShardTxs // a node signs the txs emitted by a committed block and sends them to the destination shard
+
+	// TBD protocol
+	MsgLockTxs // a node signs the lock txs of the cross-shard calls emitted by a committed block and sends them to the destination shard
+
+	// PoW protocol
+	MsgMinedBlock     // a miner gossips a block it mined or received
+	MsgBlockRequest   // a miner requests the unknown parent of an orphan block
+	MsgBlockConfirmed // a miner reports a block confirmed in its heaviest chain to the client
+
+	// PoS protocol
+	MsgSortitionProposal // a proposer selected by the sortition proposes a block with its credential
+	MsgCertRequest       // a node behind requests the certificate of a round from a node ahead
+	MsgCertificate       // the certified block of a round, with the aggregate signature of the cert votes
+	MsgRoundCommitted    // a validator reports the value it commits in a round to the client
+
+	// HoneyBadgerBFT protocol
+	MsgErasureVal   // the proposer sends each node its erasure-coded chunk of the batch
+	MsgErasureEcho  // a node echoes its chunk to all the nodes
+	MsgErasureReady // a node is ready to deliver the batch of the Merkle root
+	MsgBinVal       // the BVAL message of the binary agreement
+	MsgAux          // the AUX message of the binary agreement
+	MsgTerm         // a node decides in the binary agreement, counted as its BVAL and AUX in all the later rounds
+	MsgCoinShare    // the threshold signature share of the common coin
+
+	// Bracha reliable broadcast, see addon/rbc
+	MsgRBCSend  // the sender of an instance sends its value to all the nodes
+	MsgRBCEcho  // a node echoes the value it receives from the sender
+	MsgRBCReady // a node is ready to deliver the value of the digest
+
+	// Sync HotStuff protocol, the votes are MsgVote and the blames are MsgViewChange
+	MsgSyncProposal // the leader proposes a node, forwarded by every replica to detect the equivocation
+	MsgQuitView     // the proof to quit a view: f+1 blames or two proposals of the same height
+	MsgStatus       // a replica sends its highest certificate to the leader of the new view
+
+	// Narwhal mempool and Bullshark ordering, a missing parent is requested by MsgCertRequest
+	MsgDAGHeader      // a node broadcasts its header of a round
+	MsgDAGVote        // a node votes for a header, sent to the author
+	MsgDAGCertificate // the author broadcasts the header with 2f+1 votes
+	MsgDAGVertex      // local, the mempool delivers a certificate to the ordering, after all its parents
+	MsgDAGCommitted   // local, the ordering tells the mempool the committed txs
 )
 
 // the basic info of the shard to send back to the client
@@ -67,6 +122,59 @@ func (rep *Reply) String() string {
 	return result
 }
 
+// the content of the init message, the protocols running several instances at the same time(DS, TBB) tell them apart by Instance
+type InitContent struct {
+	Sid       int       // the shard running the instance, every shard runs its own instances
+	Instance  int       // the id of the protocol instance, counted in the shard
+	StartTime time.Time // the time when the instance starts, the rounds of the instance are counted from it
+}
+
+// the request proposed in a protocol instance
+type InstanceRequest struct {
+	Instance int
+	Req      Request
+}
+
+// the content of the MsgBlockConfirmed message, a block is confirmed once it is config.PoWConfirmDepth blocks deep
+type ConfirmContent struct {
+	Sid         int
+	Height      int64
+	Miner       int
+	Difficulty  int64
+	MinedTime   time.Time // the time stamp of the block
+	ConfirmTime time.Time
+	TxNum       int
+	TxLatency   float64 // (s) the average time from the creation of the txs to the confirmation
+	Stale       int     // number of the stale blocks seen by the miner so far
+	Confirmed   int64   // number of the blocks confirmed by the miner so far
+}
+
+// the content of the MsgRoundCommitted message, the client compares the values committed by the validators of a round
+type RoundCommitContent struct {
+	Sid       int
+	Nid       int
+	Round     int
+	Period    int    // the period the value is certified in, 1 if the first proposal is certified
+	Value     []byte // the hash of the committed block, empty if the round commits no block
+	Height    int64  // the height of the committed block
+	Proposer  int
+	CertVotes int // the votes of the certificate
+	Time      time.Time
+}
+
+// the content of the query message
+type QueryContent struct {
+	Instance int    // the instance to query
+	Addr     string // the address to send the reply to
+}
+
+// the content of the reply to the query message
+type QueryReply struct {
+	Sid      int
+	Instance int
+	Value    string
+}
+
 // Encode the message into a byte array
 func (msg *Message) JsonEncode() []byte {
 	msgbytes, err := json.Marshal(msg)
@@ -18,6 +18,7 @@ const (
 	ReqVerifyBlockHeader             // the Content of the request is a block header
 	ReqVerifyTxs                     // the Content of the request is a list of transactions
 	ReqVerifyInputs                  // the Content of the request is a list of UTXOs
+	ReqReconfig                      // the Content of the request is the randomness of the next epoch, the epoch ends when it is committed
 )
 
 // always indicate which kind of request a consensus is proposing
@@ -9,6 +9,7 @@ import (
 	"BlockChainSimulator/node/runningMod/runningModInterface"
 	"BlockChainSimulator/utils"
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
@@ -21,22 +22,17 @@ type Node struct {
 
 	// running modules
 	RunningMods []runningModInterface.RunningMod
+	modTypes    func(nid int) []string // the running modules of the node at a seat, recreated when the node is reassigned
 }
 
-func NewNode(sid int, nid int, pcc *config.ChainConfig, runningModTypes []string) (*Node, error) {
-	var err error
+func NewNode(sid int, nid int, pcc *config.ChainConfig, modTypes func(nid int) []string) (*Node, error) {
 	node := new(Node)
 	node.Attr = nodeattr.NewNodeAttr(sid, nid, pcc)
 	node.P2PMod = p2p.NewP2PMod(node.Attr.Ipaddr)
+	node.modTypes = modTypes
 
-	for _, runningModType := range runningModTypes {
-		runningMod := runningMod.NewRunningMod(runningModType, node.Attr, node.P2PMod)
-		if runningMod == nil {
-			utils.LoggerInstance.Error("Error creating running module: %v", runningModType)
-			return nil, err
-		}
-		utils.LoggerInstance.Info("Created running module: %v", runningModType)
-		node.RunningMods = append(node.RunningMods, runningMod)
+	if err := node.createRunningMods(); err != nil {
+		return nil, err
 	}
 
 	// register the messgae process handlers to the p2p module
@@ -47,37 +43,77 @@ func NewNode(sid int, nid int, pcc *config.ChainConfig, runningModTypes []string
 	return node, nil
 }
 
-func (n *Node) Run() {
-	wg := sync.WaitGroup{}
-	ctx, cancel := context.WithCancel(context.Background())
+// create the running modules of the current seat of the node
+func (n *Node) createRunningMods() error {
+	n.RunningMods = nil
+	for _, runningModType := range n.modTypes(n.Attr.Nid) {
+		runningMod := runningMod.NewRunningMod(runningModType, n.Attr, n.P2PMod)
+		if runningMod == nil {
+			utils.LoggerInstance.Error("Error creating running module: %v", runningModType)
+			return fmt.Errorf("unknown running module %v", runningModType)
+		}
+		utils.LoggerInstance.Info("Created running module: %v", runningModType)
+		n.RunningMods = append(n.RunningMods, runningMod)
+	}
+	return nil
+}
 
-	n.P2PMod.MsgHandlerMap[message.MsgStop] = func(msg *message.Message) {
+// Run runs the running modules until the node is stopped, an error is returned if the modules of a new seat cannot be created
+func (n *Node) Run() error {
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	stopHandler := func(msg *message.Message) {
 		utils.LoggerInstance.Info("Received stop message...now close the running Mod")
-		cancel()
+		stopOnce.Do(func() { close(stopped) })
 	}
+	n.P2PMod.RegisterHandler(message.MsgStop, stopHandler)
 
 	// start to receive the message
 	n.P2PMod.StartListen()
 
-	// start to run custom modules
-	for _, runningMod := range n.RunningMods {
-		wg.Add(1)
-		go runningMod.Run(ctx, &wg)
-	}
-
 	// ctrl+c to stop all the goroutines
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	select {
-	case <-ctx.Done(): // invoke by other nodes, for example, the client
+	for {
+		wg := sync.WaitGroup{}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// start to run custom modules
+		for _, runningMod := range n.RunningMods {
+			wg.Add(1)
+			go runningMod.Run(ctx, &wg)
+		}
+
+		select {
+		case <-stopped: // invoke by other nodes, for example, the client
+
+		case <-sigChan:
+			utils.LoggerInstance.Info("Node stopped by system interrupt...now close the running Mod")
 
-	case <-sigChan:
-		utils.LoggerInstance.Info("Node stopped by system interrupt...now close the running Mod")
+		case apply := <-n.Attr.Restart:
+			// the node is reassigned at the epoch boundary, the running modules of the old seat are replaced
+			cancel()
+			wg.Wait()
+			apply()
+			utils.LoggerInstance.Info("Restart the running Mod as node %d of shard %d in epoch %d", n.Attr.Nid, n.Attr.Sid, n.Attr.Epoch)
+			if err := n.createRunningMods(); err != nil {
+				utils.LoggerInstance.Error("Error recreating the running Mod of node %d of shard %d: %v", n.Attr.Nid, n.Attr.Sid, err)
+				return fmt.Errorf("recreate the running mods of node %d of shard %d: %w", n.Attr.Nid, n.Attr.Sid, err)
+			}
+			n.P2PMod.ReplaceHandlers(func() {
+				n.P2PMod.RegisterHandler(message.MsgStop, stopHandler)
+				for _, runningMod := range n.RunningMods {
+					runningMod.RegisterHandlers()
+				}
+			})
+			continue
+		}
+
+		// wait for all the runningMods to stop
 		cancel()
+		wg.Wait()
+		utils.LoggerInstance.Info("Node stopped...")
+		return nil
 	}
-
-	// wait for all the runningMods to stop
-	wg.Wait()
-	utils.LoggerInstance.Info("Node stopped...")
 }
@@ -5,10 +5,19 @@ import (
 	"BlockChainSimulator/config"
 	"BlockChainSimulator/signature"
 	"BlockChainSimulator/utils"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
 	"log"
 	"strconv"
+	"sync"
+	"time"
 )
 
+const PubKeyWaitTimeout = 20 * time.Second // a message handler waits at most PubKeyWaitTimeout for the keys to verify the message
+
 type NodeAttr struct {
 	Sid      int
 	Nid      int
@@ -18,6 +27,23 @@ type NodeAttr struct {
 	CurChain *blockchain.BlockChain
 
 	PubKeyTable map[int]map[int]*signature.PublicKey // Opt: I know it cannot be a "NodeAttr" attribute, but I don't know where to put it
+
+	VRFKey      *ecdsa.PrivateKey                // the key of the VRF, used by the sortition of PoS
+	VRFKeyTable map[int]map[int]*ecdsa.PublicKey // distributed together with the PubKeyTable
+
+	// committee reconfiguration related, see auxiliaryMod/reconfig.go
+	Identity int                            // the seat sid*NodeNum+nid the process starts in, kept across the epochs
+	Epoch    int                            // the current epoch, the nodes are reassigned to the shards at the epoch boundaries
+	Seed     int64                          // the seed of the current epoch
+	Seats    []int                          // the seat of every process in the current epoch, nil before the first reconfiguration
+	Chains   map[int]*blockchain.BlockChain // the chains of the shards the node has served, reused when it returns to a shard
+	Restart  chan func()                    // the node stops the running mods, applies the change and recreates the mods
+
+	pubKeyLock   sync.RWMutex
+	pubKeysReady chan struct{} // closed when the PubKeyTable is complete, nil if the public keys are not synchronized
+
+	handover     []byte // the final block and the states of the last epoch, served to the nodes joining the shard
+	handoverLock sync.RWMutex
 }
 
 // Opt: why not move CurChain to the PBFT running mod?
@@ -25,22 +51,30 @@ func NewNodeAttr(sid int, nid int, pcc *config.ChainConfig) *NodeAttr {
 	nodeAttr := new(NodeAttr)
 	nodeAttr.Sid = sid
 	nodeAttr.Nid = nid
+	nodeAttr.Identity = sid*config.NodeNum + nid
+	nodeAttr.Restart = make(chan func(), 1)
 
 	nodeAttr.SecKey, nodeAttr.PubKey = signature.GenerateKeyPair()
+	vrfKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Panic(err)
+	}
+	nodeAttr.VRFKey = vrfKey
 	nodeAttr.Ipaddr = config.IPMap[sid][nid]
 	if sid == config.ClientShard {
 		nodeAttr.Ipaddr = config.ClientAddr
 		return nodeAttr
 	}
 
-	var err error
 	nodeAttr.CurChain, err = blockchain.NewBlockChain(pcc)
 	if err != nil {
 		utils.LoggerInstance.Error("Failed to create the blockchain")
 		log.Panic(err)
 	}
 
+	nodeAttr.Chains = map[int]*blockchain.BlockChain{sid: nodeAttr.CurChain}
 	nodeAttr.PubKeyTable = make(map[int]map[int]*signature.PublicKey)
+	nodeAttr.VRFKeyTable = make(map[int]map[int]*ecdsa.PublicKey)
 
 	return nodeAttr
 }
@@ -49,3 +83,187 @@ func NewNodeAttr(sid int, nid int, pcc *config.ChainConfig) *NodeAttr {
 func (n *NodeAttr) GetIdentifier() string {
 	return strconv.Itoa(n.Sid) + "-" + strconv.Itoa(n.Nid) + "-" + n.Ipaddr
 }
+
+// enable the synchronization of the public keys, then WaitForPubKeys blocks until the PubKeyTable is complete
+func (n *NodeAttr) EnablePubKeySync() {
+	n.pubKeyLock.Lock()
+	defer n.pubKeyLock.Unlock()
+	if n.PubKeyTable[n.Sid] == nil {
+		n.PubKeyTable[n.Sid] = make(map[int]*signature.PublicKey)
+	}
+	n.PubKeyTable[n.Sid][n.Nid] = n.PubKey
+	if n.VRFKeyTable[n.Sid] == nil {
+		n.VRFKeyTable[n.Sid] = make(map[int]*ecdsa.PublicKey)
+	}
+	n.VRFKeyTable[n.Sid][n.Nid] = &n.VRFKey.PublicKey
+	if !n.pubKeyTableComplete() {
+		n.pubKeysReady = make(chan struct{})
+	}
+}
+
+// whether (sid, nid) is a seat of the system, the keys of the other ids are never accepted
+func isSeat(sid int, nid int) bool {
+	return sid >= 0 && sid < config.ShardNum && nid >= 0 && nid < config.NodeNum
+}
+
+// add the public key and the VRF key of node nid in shard sid, return false if the key is already known or the seat does not exist.
+// The first key of a seat is kept, syncPubKeys only sets the keys answering the challenges sent to the addresses of the seats
+func (n *NodeAttr) SetPubKey(sid int, nid int, pubKey *signature.PublicKey, vrfKey *ecdsa.PublicKey) bool {
+	if !isSeat(sid, nid) || pubKey == nil {
+		return false
+	}
+	n.pubKeyLock.Lock()
+	defer n.pubKeyLock.Unlock()
+	if n.PubKeyTable[sid] == nil {
+		n.PubKeyTable[sid] = make(map[int]*signature.PublicKey)
+		n.VRFKeyTable[sid] = make(map[int]*ecdsa.PublicKey)
+	}
+	if n.PubKeyTable[sid][nid] != nil {
+		return false
+	}
+	n.PubKeyTable[sid][nid] = pubKey
+	n.VRFKeyTable[sid][nid] = vrfKey
+
+	if n.pubKeysReady != nil && n.pubKeyTableComplete() {
+		close(n.pubKeysReady)
+		n.pubKeysReady = nil
+	}
+	return true
+}
+
+// get the public key of node nid in shard sid, nil if the key is unknown
+func (n *NodeAttr) GetPubKey(sid int, nid int) *signature.PublicKey {
+	n.pubKeyLock.RLock()
+	defer n.pubKeyLock.RUnlock()
+	return n.PubKeyTable[sid][nid]
+}
+
+// get the VRF key of node nid in shard sid, nil if the key is unknown
+func (n *NodeAttr) GetVRFKey(sid int, nid int) *ecdsa.PublicKey {
+	n.pubKeyLock.RLock()
+	defer n.pubKeyLock.RUnlock()
+	return n.VRFKeyTable[sid][nid]
+}
+
+// replace the PubKeyTable and the VRFKeyTable, the keys follow the nodes when they are reassigned to other seats
+func (n *NodeAttr) SetPubKeyTable(table map[int]map[int]*signature.PublicKey, vrfTable map[int]map[int]*ecdsa.PublicKey) {
+	n.pubKeyLock.Lock()
+	defer n.pubKeyLock.Unlock()
+	n.PubKeyTable = table
+	n.VRFKeyTable = vrfTable
+}
+
+// keep the hand-over of the last epoch, it survives the restart of the running mods
+func (n *NodeAttr) SetHandover(handover []byte) {
+	n.handoverLock.Lock()
+	defer n.handoverLock.Unlock()
+	n.handover = handover
+}
+
+func (n *NodeAttr) GetHandover() []byte {
+	n.handoverLock.RLock()
+	defer n.handoverLock.RUnlock()
+	return n.handover
+}
+
+// whether the public keys of all the nodes are known
+func (n *NodeAttr) PubKeyTableComplete() bool {
+	n.pubKeyLock.RLock()
+	defer n.pubKeyLock.RUnlock()
+	return n.pubKeyTableComplete()
+}
+
+func (n *NodeAttr) pubKeyTableComplete() bool {
+	for sid := 0; sid < config.ShardNum; sid++ {
+		for nid := 0; nid < config.NodeNum; nid++ {
+			if n.PubKeyTable[sid][nid] == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// block until the PubKeyTable is complete, return immediately if the public keys are not synchronized
+func (n *NodeAttr) WaitForPubKeys(timeout time.Duration) bool {
+	n.pubKeyLock.RLock()
+	ready := n.pubKeysReady
+	n.pubKeyLock.RUnlock()
+	if ready == nil {
+		return true
+	}
+
+	select {
+	case <-ready:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// block the running mod until the PubKeyTable is complete, the consensus never starts with the keys of some nodes unknown.
+// Return false if ctx is done first
+func (n *NodeAttr) AwaitPubKeys(ctx context.Context) bool {
+	for {
+		n.pubKeyLock.RLock()
+		ready := n.pubKeysReady
+		n.pubKeyLock.RUnlock()
+		if ready == nil {
+			return true
+		}
+
+		select {
+		case <-ready:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-time.After(PubKeyWaitTimeout):
+			utils.LoggerInstance.Warn("The public keys of some nodes are still unknown, keep waiting before starting the consensus")
+		}
+	}
+}
+
+// the key to verify the signature of a seat. The messages of the nodes which are ready earlier may arrive
+// before their keys, wait for the synchronization in this case, the key is still nil if it does not complete in time
+func (n *NodeAttr) verifyingKey(sid int, nid int) *signature.PublicKey {
+	pubKey := n.GetPubKey(sid, nid)
+	if pubKey == nil && n.WaitForPubKeys(PubKeyWaitTimeout) {
+		pubKey = n.GetPubKey(sid, nid)
+	}
+	return pubKey
+}
+
+// VerifySig checks the signature of node nid in shard sid on the content.
+// It fails closed, the signature of an id out of the seats or of a node whose key is unknown is rejected
+func (n *NodeAttr) VerifySig(sid int, nid int, content []byte, sig *signature.Signature) bool {
+	if !isSeat(sid, nid) || sig == nil {
+		return false
+	}
+	pubKey := n.verifyingKey(sid, nid)
+	return pubKey != nil && signature.Verify(pubKey, content, sig)
+}
+
+// VerifyAggregatedSig checks the aggregate signature of the signers in shard sid on the content.
+// The signers must be distinct seats of the shard with known keys
+func (n *NodeAttr) VerifyAggregatedSig(sid int, signers []int, content []byte, aggSig *signature.Signature) error {
+	if aggSig == nil || len(signers) == 0 {
+		return fmt.Errorf("empty aggregate signature")
+	}
+	seen := utils.NewSet[int]()
+	pubKeys := make([]*signature.PublicKey, 0, len(signers))
+	for _, nid := range signers {
+		if !isSeat(sid, nid) || seen.Contains(nid) {
+			return fmt.Errorf("invalid or duplicate signer %d in the aggregate signature", nid)
+		}
+		seen.Add(nid)
+		pubKey := n.verifyingKey(sid, nid)
+		if pubKey == nil {
+			return fmt.Errorf("the public key of signer %d is unknown", nid)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	if !signature.VerifyAggregatedSignature(pubKeys, content, aggSig) {
+		return fmt.Errorf("the aggregate signature of %v is not valid", signers)
+	}
+	return nil
+}
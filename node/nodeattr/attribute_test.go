@@ -0,0 +1,130 @@
+package nodeattr
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/signature"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the attribute of node 0 in a shard of 4 nodes, knowing the keys of the nodes in known only
+func newTestAttr(t *testing.T, known ...int) (*NodeAttr, []*signature.SecretKey) {
+	config.ShardNum, config.NodeNum = 1, 4
+	vrfKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	attr := &NodeAttr{
+		VRFKey:      vrfKey,
+		PubKeyTable: map[int]map[int]*signature.PublicKey{0: {}},
+		VRFKeyTable: map[int]map[int]*ecdsa.PublicKey{0: {}},
+	}
+	sks := make([]*signature.SecretKey, config.NodeNum)
+	for nid := range sks {
+		var pk *signature.PublicKey
+		sks[nid], pk = signature.GenerateKeyPair()
+		if nid == attr.Nid {
+			attr.SecKey, attr.PubKey = sks[nid], pk
+		}
+		if slices.Contains(known, nid) {
+			attr.PubKeyTable[0][nid] = pk
+		}
+	}
+	return attr, sks
+}
+
+func TestSetPubKeyOnlySeats(t *testing.T) {
+	attr, _ := newTestAttr(t, 0)
+	attr.EnablePubKeySync()
+
+	// the fake ids neither get a key nor make the table look complete
+	for _, nid := range []int{-1, 4, 99, 100, 101} {
+		_, pk := signature.GenerateKeyPair()
+		assert.False(t, attr.SetPubKey(0, nid, pk, nil), "nid %d", nid)
+	}
+	_, pk := signature.GenerateKeyPair()
+	assert.False(t, attr.SetPubKey(1, 1, pk, nil), "the shard does not exist")
+	assert.False(t, attr.PubKeyTableComplete())
+
+	for nid := 1; nid < 4; nid++ {
+		_, pk := signature.GenerateKeyPair()
+		require.True(t, attr.SetPubKey(0, nid, pk, nil))
+	}
+	assert.True(t, attr.PubKeyTableComplete())
+	assert.True(t, attr.AwaitPubKeys(context.Background()))
+
+	// the first key of a seat is kept
+	_, other := signature.GenerateKeyPair()
+	assert.False(t, attr.SetPubKey(0, 1, other, nil))
+}
+
+func TestVerifySigFailsClosed(t *testing.T) {
+	attr, sks := newTestAttr(t, 0, 1)
+	msg := []byte("vote")
+
+	assert.True(t, attr.VerifySig(0, 1, msg, signature.Sign(sks[1], msg)))
+	assert.False(t, attr.VerifySig(0, 1, []byte("other"), signature.Sign(sks[1], msg)))
+	assert.False(t, attr.VerifySig(0, 1, msg, nil))
+
+	// the key of node 2 is unknown, the ids 4 and -1 are not seats
+	sk2 := sks[2]
+	assert.False(t, attr.VerifySig(0, 2, msg, signature.Sign(sk2, msg)))
+	assert.False(t, attr.VerifySig(0, 4, msg, signature.Sign(sk2, msg)))
+	assert.False(t, attr.VerifySig(0, -1, msg, signature.Sign(sk2, msg)))
+}
+
+func TestVerifyAggregatedSig(t *testing.T) {
+	attr, sks := newTestAttr(t, 0, 1, 2)
+	msg := []byte("qc")
+	aggregate := func(nids ...int) *signature.Signature {
+		sigs := make([]*signature.Signature, 0, len(nids))
+		for _, nid := range nids {
+			sigs = append(sigs, signature.Sign(sks[nid], msg))
+		}
+		agg, err := signature.AggregateSignatures(sigs)
+		require.NoError(t, err)
+		return agg
+	}
+
+	assert.NoError(t, attr.VerifyAggregatedSig(0, []int{0, 1, 2}, msg, aggregate(0, 1, 2)))
+	// one signer repeated 2f+1 times, the ids out of the seats, a signer without a key
+	assert.Error(t, attr.VerifyAggregatedSig(0, []int{1, 1, 1}, msg, aggregate(1, 1, 1)))
+	assert.Error(t, attr.VerifyAggregatedSig(0, []int{99, 100, 101}, msg, aggregate(0, 1, 2)))
+	assert.Error(t, attr.VerifyAggregatedSig(0, []int{0, 1, 3}, msg, aggregate(0, 1, 3)))
+	assert.Error(t, attr.VerifyAggregatedSig(0, []int{0, 1}, msg, aggregate(0, 1, 2)))
+	assert.Error(t, attr.VerifyAggregatedSig(0, nil, msg, aggregate(0)))
+}
+
+func TestAwaitPubKeysStopsWithContext(t *testing.T) {
+	attr, _ := newTestAttr(t, 0)
+	attr.EnablePubKeySync()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, attr.AwaitPubKeys(ctx), "the consensus does not start without the keys")
+}
+
+// a message arriving before the key of its sender is verified once the keys are synchronized
+func TestVerifySigWaitsForSync(t *testing.T) {
+	attr, _ := newTestAttr(t, 0)
+	attr.EnablePubKeySync()
+	sk1, pk1 := signature.GenerateKeyPair()
+	msg := []byte("pre-prepare")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		attr.SetPubKey(0, 1, pk1, nil)
+		for nid := 2; nid < 4; nid++ {
+			_, pk := signature.GenerateKeyPair()
+			attr.SetPubKey(0, nid, pk, nil)
+		}
+	}()
+	assert.True(t, attr.VerifySig(0, 1, msg, signature.Sign(sk1, msg)))
+	assert.False(t, attr.VerifySig(0, 4, msg, signature.Sign(sk1, msg)))
+}
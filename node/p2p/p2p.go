@@ -11,24 +11,59 @@ import (
 )
 
 type P2PMod struct {
-	listenAddr    config.Address                                 // ip:port
-	MsgHandlerMap map[message.MessageType]message.MessageHandler // message type -> handler
-	ConnMananger  ConnMananger
+	listenAddr   config.Address                                 // ip:port
+	msgHandlers  map[message.MessageType]message.MessageHandler // message type -> handler
+	ConnMananger ConnMananger
 
-	wg sync.WaitGroup
+	localHandlers map[message.MessageType]message.MessageHandler // the handlers called by the other mods of the node, never by the network
+	handlerLock   sync.RWMutex                                   // guards msgHandlers and localHandlers against ReplaceHandlers
+	wg            sync.WaitGroup
 }
 
 func NewP2PMod(listenAddr config.Address) *P2PMod {
 	return &P2PMod{
 		listenAddr:    listenAddr,
 		ConnMananger:  ConnMananger{connPools: make(map[config.Address]*sync.Pool)},
-		MsgHandlerMap: make(map[message.MessageType]message.MessageHandler),
+		msgHandlers:   make(map[message.MessageType]message.MessageHandler),
+		localHandlers: make(map[message.MessageType]message.MessageHandler),
 	}
 }
 
 func (p2p *P2PMod) RegisterHandler(msgType message.MessageType, handler message.MessageHandler) {
 	utils.LoggerInstance.Debug("Registering handler for message type: %v", msgType)
-	p2p.MsgHandlerMap[msgType] = handler
+	p2p.msgHandlers[msgType] = handler
+}
+
+// look up the handler of the message type
+func (p2p *P2PMod) Handler(msgType message.MessageType) (message.MessageHandler, bool) {
+	p2p.handlerLock.RLock()
+	defer p2p.handlerLock.RUnlock()
+	handler, ok := p2p.msgHandlers[msgType]
+	return handler, ok
+}
+
+// register a handler of the messages between the mods of this node, the messages of the type received from the network are dropped
+func (p2p *P2PMod) RegisterLocalHandler(msgType message.MessageType, handler message.MessageHandler) {
+	utils.LoggerInstance.Debug("Registering local handler for message type: %v", msgType)
+	p2p.localHandlers[msgType] = handler
+}
+
+// look up the local handler of the message type
+func (p2p *P2PMod) LocalHandler(msgType message.MessageType) (message.MessageHandler, bool) {
+	p2p.handlerLock.RLock()
+	defer p2p.handlerLock.RUnlock()
+	handler, ok := p2p.localHandlers[msgType]
+	return handler, ok
+}
+
+// replace all the handlers by the ones registered in register, used when the running mods are recreated,
+// so the messages are not dispatched to the stopped mods
+func (p2p *P2PMod) ReplaceHandlers(register func()) {
+	p2p.handlerLock.Lock()
+	defer p2p.handlerLock.Unlock()
+	p2p.msgHandlers = make(map[message.MessageType]message.MessageHandler)
+	p2p.localHandlers = make(map[message.MessageType]message.MessageHandler)
+	register()
 }
 
 // start listening on the p2p's listen address
@@ -75,7 +110,7 @@ func (p2p *P2PMod) handleConnection(conn net.Conn) {
 		message.JsonDecode(content, msg)
 		// utils.LoggerInstance.Debug("Received msg of type %v len %v", msg.MsgType, len(content))
 
-		if handler, ok := p2p.MsgHandlerMap[msg.MsgType]; ok {
+		if handler, ok := p2p.Handler(msg.MsgType); ok {
 			go handler(msg) // Q: why use/not use go here?
 		} else {
 			utils.LoggerInstance.Error("No handler for message type %v\n", msg.MsgType)
@@ -0,0 +1,349 @@
+// the committee reconfiguration module, the nodes are reassigned to the shards at the epoch boundaries.
+// The beacon (the ReconfigBeaconMod of the client) broadcasts the randomness of the next epoch, every node proposes it to its shard,
+// and the shard ends the epoch when the request is committed, see pbft/reconfig.go. Then every node computes the new seats:
+// a node moved to another shard fetches the final block and the states of the shard from its old members, accepts them when f+1 of them agree,
+// waits for the other members of the new shard to be ready, and asks the node to recreate the running mods at the new seat
+package auxiliaryMod
+
+import (
+	"BlockChainSimulator/addon/reconfig"
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"context"
+	"crypto/ecdsa"
+	"log"
+	"sync"
+	"time"
+)
+
+var _ runningModInterface.RunningMod = &ReconfigMod{}
+
+type ReconfigMod struct {
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	beaconKey *ecdsa.PublicKey
+	proposed  bool // whether the randomness of the next epoch is proposed
+
+	responses chan *reconfig.StateResponse // the responses to the state request of this node
+	ready     map[int]*utils.Set[int]      // epoch -> the identities of the members of the new shard which are ready
+	readyCond *sync.Cond
+	mu        sync.Mutex
+}
+
+func NewReconfigMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	rm := new(ReconfigMod)
+	rm.nodeAttr = attr
+	rm.p2pMod = p2p
+
+	beaconKey, err := reconfig.BeaconPublicKey()
+	if err != nil {
+		// no randomness is accepted, the node stays in its shard
+		utils.LoggerInstance.Error("The public key of the beacon is not valid: %v", err)
+	}
+	rm.beaconKey = beaconKey
+	rm.responses = make(chan *reconfig.StateResponse, config.NodeNum)
+	rm.ready = make(map[int]*utils.Set[int])
+	rm.readyCond = sync.NewCond(&rm.mu)
+
+	if attr.Seats == nil {
+		attr.Seats = reconfig.InitialSeats()
+	}
+	return rm
+}
+
+func (rm *ReconfigMod) RegisterHandlers() {
+	rm.p2pMod.RegisterHandler(message.MsgReconfig, rm.handleReconfig)
+	rm.p2pMod.RegisterLocalHandler(message.MsgEpochEnd, rm.handleEpochEnd)
+	rm.p2pMod.RegisterHandler(message.MsgStateRequest, rm.handleStateRequest)
+	rm.p2pMod.RegisterHandler(message.MsgStateResponse, rm.handleStateResponse)
+	rm.p2pMod.RegisterHandler(message.MsgEpochReady, rm.handleEpochReady)
+}
+
+// everything is driven by the messages
+func (rm *ReconfigMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	<-ctx.Done()
+}
+
+// verify the randomness from the beacon and propose it to the shard, the epoch ends when it is committed
+func (rm *ReconfigMod) handleReconfig(msg *message.Message) {
+	content := reconfig.Content{}
+	if err := utils.Decode(msg.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the reconfiguration message")
+		return
+	}
+	if content.Epoch <= rm.nodeAttr.Epoch {
+		// the beacon repeats the randomness until all the nodes are ready
+		return
+	}
+	if !reconfig.Verify(rm.beaconKey, &content, rm.nodeAttr.Epoch, rm.nodeAttr.Seed) {
+		utils.LoggerInstance.Warn("The randomness of epoch %d is not valid in epoch %d", content.Epoch, rm.nodeAttr.Epoch)
+		return
+	}
+
+	rm.mu.Lock()
+	if rm.proposed {
+		rm.mu.Unlock()
+		return
+	}
+	rm.proposed = true
+	rm.mu.Unlock()
+
+	req := message.NewRequest(rm.nodeAttr.Sid, message.ReqReconfig, msg.Content)
+	proposeMsg := message.Message{
+		MsgType: message.MsgPropose,
+		Content: utils.Encode(req),
+	}
+	handler, ok := rm.p2pMod.Handler(message.MsgPropose)
+	if !ok {
+		utils.LoggerInstance.Error("No consensus to propose the reconfiguration")
+		return
+	}
+	utils.LoggerInstance.Info("Propose the reconfiguration of epoch %d to shard %d", content.Epoch, rm.nodeAttr.Sid)
+	handler(&proposeMsg)
+}
+
+// the consensus has committed the randomness of the next epoch, move to the new seat
+func (rm *ReconfigMod) handleEpochEnd(msg *message.Message) {
+	content := reconfig.Content{}
+	if err := utils.Decode(msg.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the reconfiguration request")
+		return
+	}
+	attr := rm.nodeAttr
+	start := time.Now()
+
+	// serve the final block and the states to the nodes joining the shard
+	bc := attr.CurChain
+	keys, values := bc.StateManager.ExportStates(bc.CurrentBlock.Header.StateRoot)
+	attr.SetHandover(utils.Encode(&reconfig.StateResponse{
+		Epoch:  content.Epoch,
+		Sid:    attr.Sid,
+		Block:  bc.CurrentBlock,
+		Keys:   keys,
+		Values: values,
+		NodeId: attr.Nid,
+		Sig:    signature.Sign(attr.SecKey, reconfig.StateResponseContent(content.Epoch, attr.Sid, reconfig.BlockDigest(bc.CurrentBlock))),
+	}))
+
+	// the addresses and the public keys follow the processes
+	seats := reconfig.Assign(attr.Seats, content.Seed, config.ReconfigSwapNum)
+	addrs := make([]string, len(seats))
+	pubKeys := make(map[int]map[int]*signature.PublicKey)
+	vrfKeys := make(map[int]map[int]*ecdsa.PublicKey)
+	for g, seat := range attr.Seats {
+		sid, nid := reconfig.SeatOf(seat)
+		addrs[g] = config.IPMap[sid][nid]
+
+		newSid, newNid := reconfig.SeatOf(seats[g])
+		if pubKeys[newSid] == nil {
+			pubKeys[newSid] = make(map[int]*signature.PublicKey)
+			vrfKeys[newSid] = make(map[int]*ecdsa.PublicKey)
+		}
+		if pubKey := attr.GetPubKey(sid, nid); pubKey != nil {
+			pubKeys[newSid][newNid] = pubKey
+			vrfKeys[newSid][newNid] = attr.GetVRFKey(sid, nid)
+		}
+	}
+	ipMap := reconfig.AddrMap(addrs, seats)
+	ipMap[config.ClientShard] = config.IPMap[config.ClientShard]
+
+	newSid, newNid := reconfig.SeatOf(seats[attr.Identity])
+	chain := bc
+	if newSid != attr.Sid {
+		chain = rm.joinShard(content.Epoch, newSid)
+		utils.LoggerInstance.Info("Epoch %d: move from shard %d to shard %d, the hand-over takes %v", content.Epoch, attr.Sid, newSid, time.Since(start))
+	}
+
+	rm.waitForMembers(content.Epoch, newSid, newNid, ipMap[newSid])
+	utils.LoggerInstance.Info("Epoch %d: node %d of shard %d, the reconfiguration takes %v", content.Epoch, newNid, newSid, time.Since(start))
+
+	attr.Restart <- func() {
+		config.IPMap = ipMap
+		attr.SetPubKeyTable(pubKeys, vrfKeys)
+		attr.Sid, attr.Nid = newSid, newNid
+		attr.CurChain = chain
+		attr.Chains[newSid] = chain
+		attr.Epoch, attr.Seed, attr.Seats = content.Epoch, content.Seed, seats
+
+		// the client routes the txs to the new committees once all the nodes are ready
+		readyMsg := message.Message{
+			MsgType: message.MsgEpochReady,
+			Content: utils.Encode(&reconfig.ReadyContent{Epoch: content.Epoch, Identity: attr.Identity, Sid: newSid, Nid: newNid}),
+		}
+		rm.p2pMod.ConnMananger.Send(config.ClientAddr, readyMsg.JsonEncode())
+	}
+}
+
+// fetch the final block and the states of the shard from its old members, the block is accepted when f+1 distinct members sign it,
+// so at least one of them is honest. The chain of the shard is reused if the node has served it before
+func (rm *ReconfigMod) joinShard(epoch int, sid int) *blockchain.BlockChain {
+	chain, ok := rm.nodeAttr.Chains[sid]
+	if !ok {
+		var err error
+		// the chain of a joined shard is stored apart from the chain of the initial shard
+		chain, err = blockchain.NewBlockChain(&config.ChainConfig{
+			NodeID:    config.NodeNum + rm.nodeAttr.Identity,
+			NodeNum:   config.NodeNum,
+			ShardID:   sid,
+			ShardNum:  config.ShardNum,
+			BlockSize: config.BlockSize,
+		})
+		if err != nil {
+			utils.LoggerInstance.Error("Failed to create the blockchain of shard %d", sid)
+			log.Panic(err)
+		}
+	}
+
+	reqMsg := message.Message{
+		MsgType: message.MsgStateRequest,
+		Content: utils.Encode(&reconfig.StateRequest{Epoch: epoch, Sid: sid, Addr: rm.nodeAttr.Ipaddr}),
+	}
+	reqBytes := reqMsg.JsonEncode()
+	oldMembers := config.IPMap[sid]
+
+	f := (config.NodeNum - 1) / 3
+	votes := make(map[string]map[int]*reconfig.StateResponse) // key: the digest of the final block, value: nid -> the response of the old member
+	timeout := time.After(time.Duration(config.ReconfigTimeout) * time.Millisecond)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for _, addr := range oldMembers {
+		rm.p2pMod.ConnMananger.Send(addr, reqBytes)
+	}
+
+	for {
+		select {
+		case resp := <-rm.responses:
+			if resp.Epoch != epoch || resp.Sid != sid || resp.Block == nil || resp.Block.Header == nil {
+				continue
+			}
+			// the keys of the old members are still in the table of the last epoch
+			key := string(reconfig.BlockDigest(resp.Block))
+			if !rm.nodeAttr.VerifySig(sid, resp.NodeId, reconfig.StateResponseContent(epoch, sid, []byte(key)), resp.Sig) {
+				utils.LoggerInstance.Warn("The state response from node %d of shard %d is not valid", resp.NodeId, sid)
+				continue
+			}
+			if votes[key] == nil {
+				votes[key] = make(map[int]*reconfig.StateResponse)
+			}
+			votes[key][resp.NodeId] = resp
+			if len(votes[key]) < f+1 {
+				continue
+			}
+			// the states are checked against the state root, a wrong copy is replaced by the copy of another member
+			for _, r := range votes[key] {
+				if err := chain.InstallState(r.Block, r.Keys, r.Values); err != nil {
+					utils.LoggerInstance.Warn("The states of shard %d are not valid: %v", sid, err)
+					continue
+				}
+				utils.LoggerInstance.Info("Install the block %d and %d states of shard %d", r.Block.Header.Height, len(r.Keys), sid)
+				return chain
+			}
+			delete(votes, key)
+		case <-ticker.C:
+			// the old members may not have ended the epoch yet
+			for _, addr := range oldMembers {
+				rm.p2pMod.ConnMananger.Send(addr, reqBytes)
+			}
+		case <-timeout:
+			utils.LoggerInstance.Error("Failed to get the states of shard %d, continue from block %d", sid, chain.CurrentBlock.Header.Height)
+			return chain
+		}
+	}
+}
+
+// tell the members of the new shard this node is ready, and wait for all of them, so the consensus of the epoch starts together
+func (rm *ReconfigMod) waitForMembers(epoch int, sid int, nid int, members map[int]string) {
+	readyMsg := message.Message{
+		MsgType: message.MsgEpochReady,
+		Content: utils.Encode(&reconfig.ReadyContent{Epoch: epoch, Identity: rm.nodeAttr.Identity, Sid: sid, Nid: nid}),
+	}
+	rm.p2pMod.ConnMananger.Broadcast(rm.nodeAttr.Ipaddr, utils.GetNeighbours(members, rm.nodeAttr.Ipaddr), readyMsg.JsonEncode())
+
+	deadline := time.Now().Add(time.Duration(config.ReconfigTimeout) * time.Millisecond)
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		rm.mu.Lock()
+		defer rm.mu.Unlock()
+		rm.readyCond.Broadcast()
+	})
+	defer timer.Stop()
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for rm.readySize(epoch) < len(members)-1 {
+		if time.Now().After(deadline) {
+			utils.LoggerInstance.Warn("Only %d members of shard %d are ready for epoch %d", rm.readySize(epoch), sid, epoch)
+			return
+		}
+		rm.readyCond.Wait()
+	}
+}
+
+// call with mu held
+func (rm *ReconfigMod) readySize(epoch int) int {
+	if rm.ready[epoch] == nil {
+		return 0
+	}
+	return rm.ready[epoch].Size()
+}
+
+// serve the hand-over of the last epoch to the node joining the shard
+func (rm *ReconfigMod) handleStateRequest(msg *message.Message) {
+	req := reconfig.StateRequest{}
+	if err := utils.Decode(msg.Content, &req); err != nil {
+		utils.LoggerInstance.Error("Error decoding the state request")
+		return
+	}
+
+	handover := rm.nodeAttr.GetHandover()
+	if handover == nil {
+		return
+	}
+	resp := reconfig.StateResponse{}
+	if err := utils.Decode(handover, &resp); err != nil || resp.Epoch != req.Epoch || resp.Sid != req.Sid {
+		// the epoch has not ended in this node yet, the joining node asks again
+		return
+	}
+
+	respMsg := message.Message{
+		MsgType: message.MsgStateResponse,
+		Content: handover,
+	}
+	rm.p2pMod.ConnMananger.Send(req.Addr, respMsg.JsonEncode())
+}
+
+func (rm *ReconfigMod) handleStateResponse(msg *message.Message) {
+	resp := &reconfig.StateResponse{}
+	if err := utils.Decode(msg.Content, resp); err != nil {
+		utils.LoggerInstance.Error("Error decoding the state response")
+		return
+	}
+	select {
+	case rm.responses <- resp:
+	default:
+		// nobody is waiting, or enough responses are buffered
+	}
+}
+
+func (rm *ReconfigMod) handleEpochReady(msg *message.Message) {
+	content := reconfig.ReadyContent{}
+	if err := utils.Decode(msg.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the ready message")
+		return
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.ready[content.Epoch] == nil {
+		rm.ready[content.Epoch] = utils.NewSet[int]()
+	}
+	rm.ready[content.Epoch].Add(content.Identity)
+	rm.readyCond.Broadcast()
+}
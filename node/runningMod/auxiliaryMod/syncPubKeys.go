@@ -1 +1,208 @@
+// the public key distribution module, every node challenges the address of each seat whose key is unknown with a fresh nonce,
+// the process at that address answers with its public key (and its VRF key) signed together with the seat and the nonce.
+// A process cannot claim the seat of another one, it never sees the nonces sent to the other addresses.
+// the consensus modules call nodeAttr.AwaitPubKeys before proposing, so the signatures can always be verified
 package auxiliaryMod
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"sync"
+	"time"
+)
+
+var _ runningModInterface.RunningMod = &SyncPubKeysMod{}
+
+type SyncPubKeysMod struct {
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	nonces    map[[2]int][]byte // the nonce sent to the address of each seat (sid, nid) whose key is unknown
+	nonceLock sync.Mutex
+}
+
+// the challenge sent to the address of a seat, the answer goes to the address of the requester in config.IPMap
+type PubKeyChallenge struct {
+	Sid   int // the seat of the requester
+	Nid   int
+	Nonce []byte
+}
+
+// the public key of a node, Proof is the signature of (Sid, Nid, VRFKey, Nonce) to prove the node owns the secret key
+// and received the nonce at the address of the seat
+type PubKeyContent struct {
+	Sid    int
+	Nid    int
+	PubKey *signature.PublicKey
+	VRFKey []byte // the public key of the VRF in PKIX form
+	Nonce  []byte // the nonce of the challenge answered
+	Proof  *signature.Signature
+}
+
+func NewSyncPubKeysMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	spm := new(SyncPubKeysMod)
+	spm.nodeAttr = attr
+	spm.p2pMod = p2p
+	spm.nonces = make(map[[2]int][]byte)
+
+	// enable here instead of in Run, so the other modules block on AwaitPubKeys from the very beginning
+	attr.EnablePubKeySync()
+
+	return spm
+}
+
+// the content signed in the proof of possession, the seat, the VRF key and the nonce are bound to the key by the signature
+func pubKeyProofContent(sid int, nid int, vrfKey []byte, nonce []byte) []byte {
+	return utils.CanonicalEncode(struct {
+		Sid    int
+		Nid    int
+		VRFKey []byte
+		Nonce  []byte
+	}{sid, nid, vrfKey, nonce})
+}
+
+func (spm *SyncPubKeysMod) pubKeyMsg(nonce []byte) *message.Message {
+	vrfKey, err := x509.MarshalPKIXPublicKey(&spm.nodeAttr.VRFKey.PublicKey)
+	if err != nil {
+		utils.LoggerInstance.Error("Error encoding the VRF key, err: %v", err)
+	}
+	content := PubKeyContent{
+		Sid:    spm.nodeAttr.Sid,
+		Nid:    spm.nodeAttr.Nid,
+		PubKey: spm.nodeAttr.PubKey,
+		VRFKey: vrfKey,
+		Nonce:  nonce,
+		Proof:  signature.Sign(spm.nodeAttr.SecKey, pubKeyProofContent(spm.nodeAttr.Sid, spm.nodeAttr.Nid, vrfKey, nonce)),
+	}
+	return &message.Message{
+		MsgType: message.MsgPubKey,
+		Content: utils.Encode(content),
+	}
+}
+
+// the nonce of the challenge to the seat, a new one is drawn if the seat has not been challenged
+func (spm *SyncPubKeysMod) nonce(sid int, nid int) []byte {
+	spm.nonceLock.Lock()
+	defer spm.nonceLock.Unlock()
+	seat := [2]int{sid, nid}
+	if spm.nonces[seat] == nil {
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			utils.LoggerInstance.Error("Error drawing the nonce, err: %v", err)
+			return nil
+		}
+		spm.nonces[seat] = nonce
+	}
+	return spm.nonces[seat]
+}
+
+// send a challenge to the address of every seat whose key is unknown
+func (spm *SyncPubKeysMod) challenge() {
+	for sid := 0; sid < config.ShardNum; sid++ {
+		for nid := 0; nid < config.NodeNum; nid++ {
+			addr, exists := config.IPMap[sid][nid]
+			if !exists || spm.nodeAttr.GetPubKey(sid, nid) != nil {
+				continue
+			}
+			nonce := spm.nonce(sid, nid)
+			if nonce == nil {
+				continue
+			}
+			msg := message.Message{
+				MsgType: message.MsgPubKeyChallenge,
+				Content: utils.Encode(PubKeyChallenge{Sid: spm.nodeAttr.Sid, Nid: spm.nodeAttr.Nid, Nonce: nonce}),
+			}
+			go spm.p2pMod.ConnMananger.Send(addr, msg.JsonEncode())
+		}
+	}
+}
+
+// answer the challenge with the own key, the answer goes to the address of the requester's seat instead of an address in the message
+func (spm *SyncPubKeysMod) handleChallenge(msg *message.Message) {
+	ch := PubKeyChallenge{}
+	if err := utils.Decode(msg.Content, &ch); err != nil || len(ch.Nonce) == 0 {
+		utils.LoggerInstance.Error("Error decoding the public key challenge")
+		return
+	}
+	addr, exists := config.IPMap[ch.Sid][ch.Nid]
+	if !exists {
+		return
+	}
+	spm.p2pMod.ConnMananger.Send(addr, spm.pubKeyMsg(ch.Nonce).JsonEncode())
+}
+
+// add the public key to the table if it answers the challenge sent to the address of the seat
+func (spm *SyncPubKeysMod) handlePubKey(msg *message.Message) {
+	content := PubKeyContent{}
+	err := utils.Decode(msg.Content, &content)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the public key message, err: %v", err)
+		return
+	}
+
+	spm.nonceLock.Lock()
+	nonce := spm.nonces[[2]int{content.Sid, content.Nid}]
+	spm.nonceLock.Unlock()
+	if nonce == nil || !bytes.Equal(nonce, content.Nonce) {
+		utils.LoggerInstance.Warn("The public key of node %d in shard %d does not answer the challenge to the seat", content.Nid, content.Sid)
+		return
+	}
+	if content.PubKey == nil || content.Proof == nil || !signature.Verify(content.PubKey, pubKeyProofContent(content.Sid, content.Nid, content.VRFKey, content.Nonce), content.Proof) {
+		utils.LoggerInstance.Warn("The public key of node %d in shard %d is not valid", content.Nid, content.Sid)
+		return
+	}
+	vrfKey, err := x509.ParsePKIXPublicKey(content.VRFKey)
+	if err != nil {
+		utils.LoggerInstance.Warn("The VRF key of node %d in shard %d is not valid", content.Nid, content.Sid)
+		return
+	}
+	ecdsaKey, ok := vrfKey.(*ecdsa.PublicKey)
+	if !ok {
+		utils.LoggerInstance.Warn("The VRF key of node %d in shard %d is not an ECDSA key", content.Nid, content.Sid)
+		return
+	}
+
+	if !spm.nodeAttr.SetPubKey(content.Sid, content.Nid, content.PubKey, ecdsaKey) {
+		return
+	}
+	spm.nonceLock.Lock()
+	delete(spm.nonces, [2]int{content.Sid, content.Nid})
+	spm.nonceLock.Unlock()
+	utils.LoggerInstance.Debug("Received the public key of node %d in shard %d", content.Nid, content.Sid)
+
+	if spm.nodeAttr.PubKeyTableComplete() {
+		utils.LoggerInstance.Info("The public key table is complete")
+	}
+}
+
+func (spm *SyncPubKeysMod) RegisterHandlers() {
+	spm.p2pMod.RegisterHandler(message.MsgPubKey, spm.handlePubKey)
+	spm.p2pMod.RegisterHandler(message.MsgPubKeyChallenge, spm.handleChallenge)
+}
+
+// challenge the seats until the public keys of all the nodes are known, the seats starting later are challenged again.
+// The challenges of the others are still answered after the table is complete
+func (spm *SyncPubKeysMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for !spm.nodeAttr.PubKeyTableComplete() {
+		select {
+		case <-ctx.Done():
+			utils.LoggerInstance.Info("Stop the SyncPubKeysMod")
+			return
+		default:
+			spm.challenge()
+			time.Sleep(time.Second)
+		}
+	}
+}
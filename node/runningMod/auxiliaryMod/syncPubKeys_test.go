@@ -0,0 +1,42 @@
+package auxiliaryMod
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the key of a seat is accepted only when it answers the nonce sent to the address of the seat
+func TestPubKeyAnswersChallenge(t *testing.T) {
+	config.ShardNum, config.NodeNum = 1, 4
+	keys := testutil.NewKeys(0, 4)
+	spm := NewSyncPubKeysMod(keys.Attr(t, 0), nil).(*SyncPubKeysMod)
+	delete(spm.nodeAttr.PubKeyTable[0], 1)
+	nonce := spm.nonce(0, 1)
+
+	// node 3 claims seat 1 with its own key, it never received the nonce
+	impostor := NewSyncPubKeysMod(keys.Attr(t, 3), nil).(*SyncPubKeysMod)
+	impostor.nodeAttr.Nid = 1
+	spm.handlePubKey(impostor.pubKeyMsg(nil))
+	spm.handlePubKey(impostor.pubKeyMsg([]byte("another nonce")))
+	assert.Nil(t, spm.nodeAttr.GetPubKey(0, 1))
+
+	// the nonce with the key of node 1, but not signed by it
+	forged := PubKeyContent{}
+	require.NoError(t, utils.Decode(impostor.pubKeyMsg(nonce).Content, &forged))
+	forged.PubKey = keys.Pks[1]
+	spm.handlePubKey(&message.Message{MsgType: message.MsgPubKey, Content: utils.Encode(forged)})
+	assert.Nil(t, spm.nodeAttr.GetPubKey(0, 1))
+
+	owner := NewSyncPubKeysMod(keys.Attr(t, 1), nil).(*SyncPubKeysMod)
+	spm.handlePubKey(owner.pubKeyMsg(nonce))
+	pk := spm.nodeAttr.GetPubKey(0, 1)
+	require.NotNil(t, pk)
+	assert.True(t, signature.Verify(pk, []byte("vote"), signature.Sign(keys.Sks[1], []byte("vote"))))
+}
@@ -0,0 +1,90 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var _ MeasureAddon = &measureAddonCrossTCL{}
+
+// the confirmation latency of the cross-shard txs, from the time the tx is created to the time its relay tx is committed
+// in the shard of the recipient(Monoxide), or the time the cross-shard contract call is committed in the shard of the called contract(TBD)
+type measureAddonCrossTCL struct {
+	latencys []float64 // latency of each cross-shard tx
+
+	mu sync.Mutex
+	fp *os.File
+}
+
+func NewMeasureAddonCrossTCL(measureMod *measureMod) MeasureAddon {
+	fp, err := os.Create(config.ResultPath + "CrossTCL.csv")
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to create the file:%v", err)
+		return nil
+	}
+	return &measureAddonCrossTCL{
+		latencys: make([]float64, 0),
+		fp:       fp,
+	}
+}
+
+func (mac *measureAddonCrossTCL) UpdateRecord(rep *message.Reply) {
+	b := &structs.Block{}
+	err := utils.Decode(rep.Req.Content, &b)
+	if err != nil {
+		utils.LoggerInstance.Error("Error in decoding the request content")
+		return
+	}
+
+	mac.mu.Lock()
+	defer mac.mu.Unlock()
+
+	// the relay tx and the lock tx keep the time of the original tx, the relay txs arrive in the receipts of the shard of the sender
+	txs := make([]structs.Transaction, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if receipt, ok := tx.(*structs.ReceiptTransaction); ok {
+			txs = append(txs, receipt.Txs...)
+		} else {
+			txs = append(txs, tx)
+		}
+	}
+	for _, tx := range txs {
+		switch tx := tx.(type) {
+		case *structs.AccountTransaction:
+			if tx.Relayed {
+				mac.latencys = append(mac.latencys, rep.Time.Sub(tx.Time).Seconds())
+			}
+		case *structs.LockTransaction:
+			// the shard of the called contract is also a participant, count the call once
+			if tx.Phase == structs.LockPhaseCommit && tx.Participant == tx.Coordinator {
+				mac.latencys = append(mac.latencys, rep.Time.Sub(tx.Time).Seconds())
+			}
+		}
+	}
+}
+
+func (mac *measureAddonCrossTCL) WriteResult() {
+	mac.mu.Lock()
+	defer mac.mu.Unlock()
+
+	avgLatency := 0.0
+	for _, latency := range mac.latencys {
+		avgLatency += latency / float64(len(mac.latencys))
+	}
+	// write the result to file
+	writer := csv.NewWriter(mac.fp)
+	writer.Write([]string{"CrossTCL", "AvgCrossTCL"})
+	for _, record := range mac.latencys {
+		writer.Write([]string{fmt.Sprintf("%.2f", record), fmt.Sprintf("%.2f", avgLatency)})
+	}
+	writer.Flush()
+
+	// close the file pointer
+	mac.fp.Close()
+}
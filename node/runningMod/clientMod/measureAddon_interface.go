@@ -11,9 +11,10 @@ type MeasureAddon interface {
 }
 
 const (
-	TCL     = "TCL"     // Transaction confirmation latency
-	TPS     = "TPS"     // Transaction per second
-	WaitLen = "WaitLen" // Wait Request Length
+	TCL      = "TCL"      // Transaction confirmation latency
+	TPS      = "TPS"      // Transaction per second
+	WaitLen  = "WaitLen"  // Wait Request Length
+	CrossTCL = "CrossTCL" // Transaction confirmation latency of the cross-shard transactions
 )
 
 var addonRegistry = make(map[string]func(measureMod *measureMod) MeasureAddon)
@@ -22,6 +23,7 @@ func init() {
 	addonRegistry[TCL] = NewMeasureAddonTCL
 	addonRegistry[TPS] = NewMeasureAddonTPS
 	addonRegistry[WaitLen] = NewMeasureAddonWaitLen
+	addonRegistry[CrossTCL] = NewMeasureAddonCrossTCL
 }
 
 func NewMeasureAddon(addonType string, measureMod *measureMod) (MeasureAddon, error) {
@@ -0,0 +1,107 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"context"
+	"sync"
+	"time"
+)
+
+var _ runningModInterface.RunningMod = &PartitionMod{}
+
+// the txs sent by the mimic mods are observed by the partition mod, nil if it is not running
+var txObserver func(txs []structs.Transaction)
+
+// used by the client to rebalance the accounts, it observes the txs sent in an epoch, and runs CLPA on the tx graph
+// at the end of the epoch. The moved accounts are sent to every shard by a partition tx, and the client routes
+// the txs by the new mapping at once. It works with the lookup table allocation only, see utils/shard.go
+type PartitionMod struct {
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	graph *txGraph // the tx graph of the current epoch
+	mu    sync.Mutex
+}
+
+func NewPartitionMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	pm := new(PartitionMod)
+	pm.nodeAttr = attr
+	pm.p2pMod = p2p
+	pm.graph = newTxGraph()
+
+	txObserver = pm.observe
+	return pm
+}
+
+func (pm *PartitionMod) RegisterHandlers() {
+
+}
+
+func (pm *PartitionMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if config.ShardAllocMethod != utils.TableAlloc {
+		utils.LoggerInstance.Error("The accounts are not allocated by the lookup table, the partition mod does nothing")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(config.EpochInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pm.partition()
+		}
+	}
+}
+
+// add the txs to the tx graph of the current epoch
+func (pm *PartitionMod) observe(txs []structs.Transaction) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	for _, tx := range txs {
+		if len(tx.From()) > 0 && len(tx.To()) > 0 {
+			pm.graph.addTx(tx.From()[0], tx.To()[0])
+		}
+	}
+}
+
+// run CLPA at the end of the epoch, and send the moved accounts to every shard
+func (pm *PartitionMod) partition() {
+	pm.mu.Lock()
+	g := pm.graph
+	pm.graph = newTxGraph()
+	pm.mu.Unlock()
+
+	table := utils.CurrentShardTable()
+	before := g.crossRatio(utils.Addr2Shard)
+	moves := clpa(g, utils.Addr2Shard, config.ShardNum, config.CLPAIterations, config.CLPABeta, config.MaxMigration)
+	if len(moves) == 0 {
+		utils.LoggerInstance.Info("No account is moved in the epoch, the cross-shard ratio is %.2f", before)
+		return
+	}
+
+	epoch := table.Epoch() + 1
+	tx := structs.NewPartitionTransaction(epoch, moves)
+	table.Apply(epoch, moves)
+	utils.LoggerInstance.Info("Epoch %d: move %d accounts, the cross-shard ratio of the observed txs %.2f -> %.2f", epoch, len(moves), before, g.crossRatio(utils.Addr2Shard))
+
+	msg := message.Message{
+		MsgType: message.MsgInject,
+		Content: utils.Encode([]structs.Transaction{tx}),
+	}
+	msgBytes := msg.JsonEncode()
+	for sid := 0; sid < config.ShardNum; sid++ {
+		for _, addr := range config.IPMap[sid] {
+			pm.p2pMod.ConnMananger.Send(addr, msgBytes)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/structs"
+	"sort"
+)
+
+// the tx graph observed in an epoch, the vertices are the accounts, and the weight of an edge is the number of txs between the accounts
+type txGraph struct {
+	edges map[structs.Address]map[structs.Address]int
+}
+
+func newTxGraph() *txGraph {
+	return &txGraph{edges: make(map[structs.Address]map[structs.Address]int)}
+}
+
+func (g *txGraph) addTx(from, to structs.Address) {
+	if from == "" || to == "" || from == to {
+		return
+	}
+	g.addEdge(from, to)
+	g.addEdge(to, from)
+}
+
+func (g *txGraph) addEdge(u, v structs.Address) {
+	if _, ok := g.edges[u]; !ok {
+		g.edges[u] = make(map[structs.Address]int)
+	}
+	g.edges[u][v]++
+}
+
+// the weight of a vertex, which is the number of txs of the account
+func (g *txGraph) degree(u structs.Address) int {
+	deg := 0
+	for _, w := range g.edges[u] {
+		deg += w
+	}
+	return deg
+}
+
+// the accounts in a fixed order, so the result of the label propagation does not depend on the order of the map
+func (g *txGraph) vertices() []structs.Address {
+	vertices := make([]structs.Address, 0, len(g.edges))
+	for u := range g.edges {
+		vertices = append(vertices, u)
+	}
+	sort.Strings(vertices)
+	return vertices
+}
+
+// the ratio of the cross-shard txs in the graph under the mapping
+func (g *txGraph) crossRatio(shardOf func(structs.Address) int) float64 {
+	cross, total := 0, 0
+	for u, neighbours := range g.edges {
+		for v, w := range neighbours {
+			total += w
+			if shardOf(u) != shardOf(v) {
+				cross += w
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(cross) / float64(total)
+}
+
+// clpa runs the constrained label propagation(CLPA of BrokerChain) on the graph, starting from the current mapping.
+// Every account moves to the shard with the most txs of its neighbours, minus a penalty of the load of the shard weighted by beta,
+// so the hot accounts are not piled into one shard. At most maxMoves accounts are moved, returns the new shards of the moved accounts
+func clpa(g *txGraph, shardOf func(structs.Address) int, shardNum, iterations int, beta float64, maxMoves int) map[structs.Address]int {
+	labels := make(map[structs.Address]int)
+	loads := make([]float64, shardNum)
+	total := 0.
+	for _, u := range g.vertices() {
+		labels[u] = shardOf(u)
+		loads[labels[u]] += float64(g.degree(u))
+		total += float64(g.degree(u))
+	}
+	if total == 0 {
+		return map[structs.Address]int{}
+	}
+	avg := total / float64(shardNum)
+
+	moved := make(map[structs.Address]bool)
+	for iter := 0; iter < iterations; iter++ {
+		changed := false
+		for _, u := range g.vertices() {
+			if len(moved) >= maxMoves && !moved[u] {
+				continue
+			}
+			deg := float64(g.degree(u))
+			conn := make([]float64, shardNum)
+			for v, w := range g.edges[u] {
+				conn[labels[v]] += float64(w)
+			}
+
+			// the load of the shard excludes the account itself, so staying and moving are compared fairly
+			cur := labels[u]
+			loads[cur] -= deg
+			best, bestScore := cur, conn[cur]-beta*deg*loads[cur]/avg
+			for s := 0; s < shardNum; s++ {
+				if score := conn[s] - beta*deg*loads[s]/avg; score > bestScore {
+					best, bestScore = s, score
+				}
+			}
+			loads[best] += deg
+
+			if best != cur {
+				labels[u] = best
+				if best != shardOf(u) {
+					moved[u] = true
+				} else {
+					delete(moved, u)
+				}
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	moves := make(map[structs.Address]int)
+	for u := range moved {
+		moves[u] = labels[u]
+	}
+	return moves
+}
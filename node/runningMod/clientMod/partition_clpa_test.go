@@ -0,0 +1,58 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/structs"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// two communities of accounts, the txs are mostly inside the communities, and the accounts are mixed in the shards
+func communityGraph() (*txGraph, map[structs.Address]int) {
+	g := newTxGraph()
+	initial := make(map[structs.Address]int)
+	for c := 0; c < 2; c++ {
+		for i := 0; i < 10; i++ {
+			initial[fmt.Sprintf("c%d-%d", c, i)] = i % 2
+			for j := 0; j < i; j++ {
+				g.addTx(fmt.Sprintf("c%d-%d", c, i), fmt.Sprintf("c%d-%d", c, j))
+			}
+		}
+	}
+	g.addTx("c0-0", "c1-0")
+	return g, initial
+}
+
+func TestCLPA(t *testing.T) {
+	g, initial := communityGraph()
+	shardOf := func(addr structs.Address) int { return initial[addr] }
+	moves := clpa(g, shardOf, 2, 10, 0.5, 100)
+
+	mapping := func(addr structs.Address) int {
+		if sid, ok := moves[addr]; ok {
+			return sid
+		}
+		return initial[addr]
+	}
+	assert.Greater(t, g.crossRatio(shardOf), 0.5)
+	assert.Less(t, g.crossRatio(mapping), 0.1, "the communities are put into the shards")
+
+	loads := make([]int, 2)
+	for _, u := range g.vertices() {
+		loads[mapping(u)] += g.degree(u)
+	}
+	assert.InDelta(t, loads[0], loads[1], float64(loads[0]+loads[1])/4, "the shards are balanced")
+
+	for addr, sid := range moves {
+		assert.NotEqual(t, initial[addr], sid, "only the moved accounts are returned")
+	}
+}
+
+func TestCLPAMaxMoves(t *testing.T) {
+	g, initial := communityGraph()
+	moves := clpa(g, func(addr structs.Address) int { return initial[addr] }, 2, 10, 0.5, 3)
+	assert.LessOrEqual(t, len(moves), 3)
+
+	assert.Empty(t, clpa(newTxGraph(), func(structs.Address) int { return 0 }, 2, 10, 0.5, 3))
+}
@@ -0,0 +1,137 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/utils"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ runningModInterface.RunningMod = &PoSMeasureMod{}
+
+// used by the client to record the rounds of the PoS protocol, every validator reports the value it commits in a round.
+// Every round is a row of PoS.csv, written when the client stops: the period the value is certified in, the certified block,
+// the number of the reports, the number of the reports conflicting with the first one, and the time since the last round.
+// A conflict is a safety violation, the validators commit different values in the same round
+type PoSMeasureMod struct {
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	rounds map[int]map[int]*posRound // shard -> round -> the reports
+	mu     sync.Mutex
+}
+
+// the reports of a round
+type posRound struct {
+	first     message.RoundCommitContent // the first report, the others are compared with it
+	reports   int
+	conflicts int
+}
+
+func NewPoSMeasureMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	psm := new(PoSMeasureMod)
+	psm.nodeAttr = attr
+	psm.p2pMod = p2p
+	psm.rounds = make(map[int]map[int]*posRound)
+	return psm
+}
+
+func (psm *PoSMeasureMod) RegisterHandlers() {
+	psm.p2pMod.RegisterHandler(message.MsgRoundCommitted, psm.handleRoundCommitted)
+}
+
+func (psm *PoSMeasureMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	<-ctx.Done()
+
+	if err := os.MkdirAll(config.ResultPath, os.ModePerm); err != nil {
+		utils.LoggerInstance.Error("Failed to create the result dir:%v", err)
+		return
+	}
+	fp, err := os.Create(config.ResultPath + "PoS.csv")
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to create the file:%v", err)
+		return
+	}
+	defer fp.Close()
+	writer := csv.NewWriter(fp)
+	defer writer.Flush()
+	writer.Write([]string{"Shard", "Round", "Period", "Height", "Proposer", "CertVotes", "Reports", "Conflicts", "Duration"})
+
+	psm.mu.Lock()
+	defer psm.mu.Unlock()
+	shards := make([]int, 0, len(psm.rounds))
+	for sid := range psm.rounds {
+		shards = append(shards, sid)
+	}
+	sort.Ints(shards)
+	violations := 0
+	for _, sid := range shards {
+		rounds := make([]int, 0, len(psm.rounds[sid]))
+		for round := range psm.rounds[sid] {
+			rounds = append(rounds, round)
+		}
+		sort.Ints(rounds)
+
+		var last time.Time
+		for _, round := range rounds {
+			r := psm.rounds[sid][round]
+			duration := 0.0
+			if !last.IsZero() {
+				duration = r.first.Time.Sub(last).Seconds()
+			}
+			last = r.first.Time
+			if r.conflicts > 0 {
+				violations++
+			}
+			writer.Write([]string{
+				strconv.Itoa(sid),
+				strconv.Itoa(round),
+				strconv.Itoa(r.first.Period),
+				strconv.FormatInt(r.first.Height, 10),
+				strconv.Itoa(r.first.Proposer),
+				strconv.Itoa(r.first.CertVotes),
+				strconv.Itoa(r.reports),
+				strconv.Itoa(r.conflicts),
+				fmt.Sprintf("%.2f", duration),
+			})
+		}
+	}
+	utils.LoggerInstance.Info("PoS measure: %d rounds violate the safety", violations)
+}
+
+func (psm *PoSMeasureMod) handleRoundCommitted(msg *message.Message) {
+	content := message.RoundCommitContent{}
+	if err := utils.Decode(msg.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the committed round")
+		return
+	}
+
+	psm.mu.Lock()
+	defer psm.mu.Unlock()
+	if psm.rounds[content.Sid] == nil {
+		psm.rounds[content.Sid] = make(map[int]*posRound)
+	}
+	r, ok := psm.rounds[content.Sid][content.Round]
+	if !ok {
+		psm.rounds[content.Sid][content.Round] = &posRound{first: content, reports: 1}
+		return
+	}
+	r.reports++
+	if !bytes.Equal(r.first.Value, content.Value) {
+		r.conflicts++
+		utils.LoggerInstance.Warn("Safety violation: node %d of shard %d commits %x in round %d, node %d commits %x",
+			content.Nid, content.Sid, content.Value, content.Round, r.first.Nid, r.first.Value)
+	}
+}
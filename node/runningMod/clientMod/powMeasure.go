@@ -0,0 +1,107 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/utils"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ runningModInterface.RunningMod = &PoWMeasureMod{}
+
+// used by the client to record the chains of the PoW protocol, the view node of each shard reports the confirmed blocks.
+// Every block is a row of PoW.csv: the block interval, the k-deep confirmation latency of the block and of its txs,
+// and the stale blocks seen so far. The stale rate is the ratio of the stale blocks to all the blocks mined out of the confirmed prefix
+type PoWMeasureMod struct {
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	lastMined map[int]time.Time // the time the last confirmed block of each shard is mined
+	mu        sync.Mutex
+
+	fp     *os.File
+	writer *csv.Writer
+}
+
+func NewPoWMeasureMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	pmm := new(PoWMeasureMod)
+	pmm.nodeAttr = attr
+	pmm.p2pMod = p2p
+	pmm.lastMined = make(map[int]time.Time)
+	return pmm
+}
+
+func (pmm *PoWMeasureMod) RegisterHandlers() {
+	pmm.p2pMod.RegisterHandler(message.MsgBlockConfirmed, pmm.handleBlockConfirmed)
+}
+
+func (pmm *PoWMeasureMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if err := os.MkdirAll(config.ResultPath, os.ModePerm); err != nil {
+		utils.LoggerInstance.Error("Failed to create the result dir:%v", err)
+		return
+	}
+	fp, err := os.Create(config.ResultPath + "PoW.csv")
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to create the file:%v", err)
+		return
+	}
+	pmm.mu.Lock()
+	pmm.fp = fp
+	pmm.writer = csv.NewWriter(fp)
+	pmm.writer.Write([]string{"Shard", "Height", "Miner", "Difficulty", "BlockInterval", "ConfirmLatency", "TxNum", "TxConfirmLatency", "StaleBlocks", "StaleRate"})
+	pmm.writer.Flush()
+	pmm.mu.Unlock()
+
+	<-ctx.Done()
+	pmm.mu.Lock()
+	defer pmm.mu.Unlock()
+	pmm.writer.Flush()
+	pmm.fp.Close()
+	pmm.writer = nil
+}
+
+func (pmm *PoWMeasureMod) handleBlockConfirmed(msg *message.Message) {
+	content := message.ConfirmContent{}
+	if err := utils.Decode(msg.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the confirmed block")
+		return
+	}
+
+	pmm.mu.Lock()
+	defer pmm.mu.Unlock()
+	if pmm.writer == nil {
+		return
+	}
+
+	interval := 0.0
+	if last, ok := pmm.lastMined[content.Sid]; ok {
+		interval = content.MinedTime.Sub(last).Seconds()
+	}
+	pmm.lastMined[content.Sid] = content.MinedTime
+	staleRate := float64(content.Stale) / float64(int64(content.Stale)+content.Confirmed)
+
+	pmm.writer.Write([]string{
+		strconv.Itoa(content.Sid),
+		strconv.FormatInt(content.Height, 10),
+		strconv.Itoa(content.Miner),
+		strconv.FormatInt(content.Difficulty, 10),
+		fmt.Sprintf("%.2f", interval),
+		fmt.Sprintf("%.2f", content.ConfirmTime.Sub(content.MinedTime).Seconds()),
+		strconv.Itoa(content.TxNum),
+		fmt.Sprintf("%.2f", content.TxLatency),
+		strconv.Itoa(content.Stale),
+		fmt.Sprintf("%.4f", staleRate),
+	})
+	pmm.writer.Flush()
+}
@@ -17,12 +17,22 @@ import (
 type queryMod struct {
 	nodeAttr *nodeattr.NodeAttr
 	p2pMod   *p2p.P2PMod
+
+	log     map[shardInstance]string // the results of the instances of all the shards
+	logLock sync.Mutex
+}
+
+// every shard runs its own instances, so an instance is identified by the shard id and the instance id
+type shardInstance struct {
+	Sid      int
+	Instance int
 }
 
 func NewQueryMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
 	qm := new(queryMod)
 	qm.nodeAttr = attr
 	qm.p2pMod = p2p
+	qm.log = make(map[shardInstance]string)
 
 	return qm
 }
@@ -30,12 +40,13 @@ func NewQueryMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.R
 func (qm *queryMod) handleInitMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received an init message")
 
-	startTime := time.Time{}
-	err := utils.Decode(msg.Content, &startTime)
+	initContent := message.InitContent{}
+	err := utils.Decode(msg.Content, &initContent)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the init message, err: %v", err)
 		return
 	}
+	startTime := initContent.StartTime
 
 	// wait until f + 1 round, the node commit, client query the result
 	maliciousNodes := int64(config.MaliciousRatio * float64(config.NodeNum))
@@ -50,25 +61,29 @@ func (qm *queryMod) handleInitMsg(msg *message.Message) {
 		time.Sleep(time.Duration(config.TickInterval/4) * time.Millisecond)
 		queryMsg := message.Message{
 			MsgType: message.MsgQuery,
-			Content: utils.Encode(qm.nodeAttr.Ipaddr),
+			Content: utils.Encode(message.QueryContent{Instance: initContent.Instance, Addr: qm.nodeAttr.Ipaddr}),
 		}
-		// send to the view node
-		utils.LoggerInstance.Info("Send the query message to the view node")
-		qm.p2pMod.ConnMananger.Send(config.IPMap[0][0], queryMsg.JsonEncode())
+		// send to the view node of the shard running the instance
+		utils.LoggerInstance.Info("Send the query message to the view node of shard %d", initContent.Sid)
+		qm.p2pMod.ConnMananger.Send(config.IPMap[initContent.Sid][config.ViewNodeId], queryMsg.JsonEncode())
 	}()
 }
 
 func (qm *queryMod) handleReplyQueryMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received a query reply message")
 
-	result := ""
+	result := message.QueryReply{}
 	err := utils.Decode(msg.Content, &result)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the query reply message, err: %v", err)
 		return
 	}
 
-	utils.LoggerInstance.Info("The result of the query is: %v", result)
+	qm.logLock.Lock()
+	qm.log[shardInstance{result.Sid, result.Instance}] = result.Value
+	logSize := len(qm.log)
+	qm.logLock.Unlock()
+	utils.LoggerInstance.Info("The result of the instance %d in shard %d is: %v, %d results in the log", result.Instance, result.Sid, result.Value, logSize)
 }
 
 func (qm *queryMod) RegisterHandlers() {
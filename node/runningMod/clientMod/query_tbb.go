@@ -16,23 +16,27 @@ import (
 )
 
 type queryTBBMod struct {
-	nodeAttr  *nodeattr.NodeAttr
-	p2pMod    *p2p.P2PMod
-	startTime *utils.AtomicValue[time.Time]
+	nodeAttr   *nodeattr.NodeAttr
+	p2pMod     *p2p.P2PMod
+	startTimes map[shardInstance]time.Time // the start time of the instances of all the shards not confirmed yet
+	lock       sync.Mutex
 
 	latencys []time.Duration
 }
 
 type ReplyValue struct {
-	Value string
-	QCMap map[string]*signature.Signature
+	Sid               int
+	Instance          int
+	Value             string
+	QCMap             map[string]*signature.Signature
+	NonHonestMajority bool // whether the node has observed conflicting QCs in the instance
 }
 
 func NewQueryTBBMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
 	qtm := new(queryTBBMod)
 	qtm.nodeAttr = attr
 	qtm.p2pMod = p2p
-	qtm.startTime = utils.NewAtomicValue[time.Time](time.Now())
+	qtm.startTimes = make(map[shardInstance]time.Time)
 
 	return qtm
 }
@@ -40,25 +44,37 @@ func NewQueryTBBMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterfac
 func (qtm *queryTBBMod) handleInitMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received an init message")
 
-	startTime := time.Time{}
-	err := utils.Decode(msg.Content, &startTime)
+	initContent := message.InitContent{}
+	err := utils.Decode(msg.Content, &initContent)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the init message, err: %v", err)
 		return
 	}
+	startTime := initContent.StartTime
+	key := shardInstance{initContent.Sid, initContent.Instance}
 
-	qtm.startTime.Set(startTime, nil)
+	qtm.lock.Lock()
+	qtm.startTimes[key] = startTime
+	qtm.lock.Unlock()
 
 	t2 := config.NodeNum - 1
 
+	// the instance whose replies are lost is forgotten long after the conservative point
+	forgetTime := startTime.Add(2 * time.Duration(int64(t2+6)*config.TickInterval) * time.Millisecond)
+	time.AfterFunc(time.Until(forgetTime), func() {
+		qtm.lock.Lock()
+		defer qtm.lock.Unlock()
+		delete(qtm.startTimes, key)
+	})
+
 	aggresiveTimer := time.NewTimer(time.Until(startTime.Add(time.Duration(3*config.TickInterval) * time.Millisecond)))
 	conservitiveTimer := time.NewTimer(time.Until(startTime.Add(time.Duration(int64(t2+6)*config.TickInterval) * time.Millisecond)))
 
 	utils.LoggerInstance.Debug("Set the aggresive timer to %v", aggresiveTimer.C)
 	utils.LoggerInstance.Debug("Set the conservitive timer to %v", conservitiveTimer.C)
 
-	go qtm.sendQueryOnTimeout(aggresiveTimer)
-	go qtm.sendQueryOnTimeout(conservitiveTimer)
+	go qtm.sendQueryOnTimeout(initContent.Sid, initContent.Instance, aggresiveTimer)
+	go qtm.sendQueryOnTimeout(initContent.Sid, initContent.Instance, conservitiveTimer)
 }
 
 func (qtm *queryTBBMod) handleReplyQueryMsg(msg *message.Message) {
@@ -74,21 +90,31 @@ func (qtm *queryTBBMod) handleReplyQueryMsg(msg *message.Message) {
 	t1 := int(float64(config.NodeNum)*config.ResilientRatio) - 1
 	t2 := config.NodeNum - 1
 
-	timeNow := time.Since(qtm.startTime.Get())
+	key := shardInstance{replyValue.Sid, replyValue.Instance}
+	qtm.lock.Lock()
+	defer qtm.lock.Unlock()
+	startTime, exists := qtm.startTimes[key]
+	if !exists {
+		utils.LoggerInstance.Debug("Received the reply of instance %d in shard %d, which is confirmed or unknown", replyValue.Instance, replyValue.Sid)
+		return
+	}
+	timeNow := time.Since(startTime)
 
 	// point1
 	if timeNow < time.Duration(int64(t1+6)*config.TickInterval)*time.Millisecond {
 		utils.LoggerInstance.Info("Point1: aggresive client receive the reply message of %v", replyValue)
-		if len(replyValue.QCMap) <= 1 {
+		if len(replyValue.QCMap) <= 1 && !replyValue.NonHonestMajority {
 			if replyValue.Value != "" {
 				utils.LoggerInstance.Info("Point1: aggresive client confirm: %v", replyValue.Value)
 				qtm.latencys = append(qtm.latencys, timeNow)
+				delete(qtm.startTimes, key)
 				utils.LoggerInstance.Info("Latency1: %vs", timeNow.Seconds())
 			} else { // if D¡ = 0
-				sendQueryTimer := time.NewTimer(time.Until(qtm.startTime.Get().Add(time.Duration(int64(t1+6)*config.TickInterval) * time.Millisecond)))
-				go qtm.sendQueryOnTimeout(sendQueryTimer)
+				sendQueryTimer := time.NewTimer(time.Until(startTime.Add(time.Duration(int64(t1+6)*config.TickInterval) * time.Millisecond)))
+				go qtm.sendQueryOnTimeout(replyValue.Sid, replyValue.Instance, sendQueryTimer)
 			}
 		} else {
+			utils.LoggerInstance.Warn("Non-honest majority is detected in instance %d of shard %d, conflicting QCs: %d", replyValue.Instance, replyValue.Sid, len(replyValue.QCMap))
 			utils.LoggerInstance.Info("aggresive client switch to conservitive mode")
 		}
 		return
@@ -100,15 +126,17 @@ func (qtm *queryTBBMod) handleReplyQueryMsg(msg *message.Message) {
 		utils.LoggerInstance.Info("Point2: aggresive client confirm: %v", replyValue.Value)
 		if replyValue.Value != "" {
 			qtm.latencys = append(qtm.latencys, timeNow)
+			delete(qtm.startTimes, key)
 			utils.LoggerInstance.Info("Latency2: %vs", timeNow.Seconds())
 		}
 		return
 	}
 
-	// point3
+	// point3, the last point of the instance
 	if len(qtm.latencys) == 0 {
 		qtm.latencys = append(qtm.latencys, timeNow)
 	}
+	delete(qtm.startTimes, key)
 	utils.LoggerInstance.Info("Point3: conservitive client receive the reply message of %v", replyValue)
 	utils.LoggerInstance.Info("Point3: conservitive client confirm: %v", replyValue.Value)
 
@@ -131,16 +159,24 @@ func (qtm *queryTBBMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 	// do nothing
 }
 
-func (qtm *queryTBBMod) sendQueryOnTimeout(queryTimer *time.Timer) {
+func (qtm *queryTBBMod) sendQueryOnTimeout(sid int, instance int, queryTimer *time.Timer) {
 	<-queryTimer.C
 
 	// wait another half config.TickInterval to make sure the consensus is finished
 	time.Sleep(time.Duration(config.TickInterval/2) * time.Millisecond)
 
+	// the confirmed instance is not queried again
+	qtm.lock.Lock()
+	_, exists := qtm.startTimes[shardInstance{sid, instance}]
+	qtm.lock.Unlock()
+	if !exists {
+		return
+	}
+
 	queryMsg := message.Message{
 		MsgType: message.MsgQuery,
-		Content: utils.Encode(qtm.nodeAttr.Ipaddr),
+		Content: utils.Encode(message.QueryContent{Instance: instance, Addr: qtm.nodeAttr.Ipaddr}),
 	}
-	utils.LoggerInstance.Info("Send the query message to the view node")
-	qtm.p2pMod.ConnMananger.Send(config.IPMap[0][0], queryMsg.JsonEncode())
+	utils.LoggerInstance.Info("Send the query message to the view node of shard %d", sid)
+	qtm.p2pMod.ConnMananger.Send(config.IPMap[sid][config.ViewNodeId], queryMsg.JsonEncode())
 }
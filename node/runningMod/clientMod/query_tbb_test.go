@@ -0,0 +1,46 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func replyMsg(sid int, instance int, value string, nonHonestMajority bool) *message.Message {
+	reply := ReplyValue{Sid: sid, Instance: instance, Value: value, NonHonestMajority: nonHonestMajority}
+	return &message.Message{MsgType: message.MsgReplyQuery, Content: utils.Encode(reply)}
+}
+
+// the start time of an instance is kept until its latency is recorded
+func TestQueryTBBLatency(t *testing.T) {
+	config.NodeNum, config.ResilientRatio, config.TickInterval = 4, 1./2, 1000
+	qtm := NewQueryTBBMod(nil, p2p.NewP2PMod("")).(*queryTBBMod)
+	now := time.Now()
+	qtm.startTimes[shardInstance{0, 1}] = now
+	qtm.startTimes[shardInstance{1, 1}] = now
+	qtm.startTimes[shardInstance{0, 2}] = now.Add(-10 * time.Second) // after the conservative point(t2+6 ticks)
+
+	// point1, the aggressive client confirms the value
+	qtm.handleReplyQueryMsg(replyMsg(0, 1, "value", false))
+	assert.Len(t, qtm.latencys, 1)
+	assert.NotContains(t, qtm.startTimes, shardInstance{0, 1})
+
+	// the later replies of the confirmed instance are ignored
+	qtm.handleReplyQueryMsg(replyMsg(0, 1, "value", false))
+	assert.Len(t, qtm.latencys, 1)
+
+	// the non-honest majority makes the client wait for the conservative point
+	qtm.handleReplyQueryMsg(replyMsg(1, 1, "value", true))
+	assert.Len(t, qtm.latencys, 1)
+	assert.Contains(t, qtm.startTimes, shardInstance{1, 1})
+
+	// point3 is the last point of the instance
+	qtm.handleReplyQueryMsg(replyMsg(0, 2, "value", false))
+	assert.NotContains(t, qtm.startTimes, shardInstance{0, 2})
+	assert.Len(t, qtm.startTimes, 1)
+}
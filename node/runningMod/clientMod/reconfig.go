@@ -0,0 +1,206 @@
+package clientMod
+
+import (
+	"BlockChainSimulator/addon/reconfig"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/utils"
+	"context"
+	"crypto/ecdsa"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ runningModInterface.RunningMod = &ReconfigBeaconMod{}
+
+// used by the client as the randomness beacon of the committee reconfiguration, see auxiliaryMod/reconfig.go.
+// Every ReconfigInterval it broadcasts the randomness of the next epoch, and routes the txs to the new committees
+// once all the nodes are ready, or the nodes had enough time to hand over and wait for each other (the silent nodes never end the epoch).
+// The epochs are recorded in Reconfig.csv: the time the nodes take to move, the number of moved nodes,
+// and how many shards have more malicious nodes than PBFT tolerates
+type ReconfigBeaconMod struct {
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	sk        *ecdsa.PrivateKey
+	startTime time.Time
+
+	pending *reconfig.Content // the epoch being reconfigured, nil if all the nodes are ready
+	next    []int             // the seats of the pending epoch
+	ready   *utils.Set[int]   // the identities ready in the pending epoch
+	sent    time.Time         // the time the randomness of the pending epoch is sent
+	mu      sync.Mutex
+
+	fp     *os.File
+	writer *csv.Writer
+}
+
+func NewReconfigBeaconMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	rbm := new(ReconfigBeaconMod)
+	rbm.nodeAttr = attr
+	rbm.p2pMod = p2p
+
+	// the nodes started after the mods are created get the public key, see StartLocalSystemAuxiliaryMod
+	rbm.sk = reconfig.NewBeaconKey()
+	config.ReconfigBeaconKey = reconfig.EncodeBeaconKey(&rbm.sk.PublicKey)
+	attr.Seats = reconfig.InitialSeats()
+	return rbm
+}
+
+func (rbm *ReconfigBeaconMod) RegisterHandlers() {
+	rbm.p2pMod.RegisterHandler(message.MsgEpochReady, rbm.handleEpochReady)
+}
+
+func (rbm *ReconfigBeaconMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if err := os.MkdirAll(config.ResultPath, os.ModePerm); err != nil {
+		utils.LoggerInstance.Error("Failed to create the result dir:%v", err)
+		return
+	}
+	fp, err := os.Create(config.ResultPath + "Reconfig.csv")
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to create the file:%v", err)
+		return
+	}
+	rbm.mu.Lock()
+	rbm.fp = fp
+	rbm.writer = csv.NewWriter(fp)
+	rbm.writer.Write([]string{"Epoch", "Time", "Duration", "ReadyNodes", "MovedNodes", "MaxMaliciousPerShard", "UnsafeShards"})
+	rbm.writer.Flush()
+	rbm.startTime = time.Now()
+	rbm.mu.Unlock()
+
+	ticker := time.NewTicker(time.Duration(config.ReconfigInterval) * time.Second)
+	defer ticker.Stop()
+	retry := time.NewTicker(time.Second)
+	defer retry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			rbm.mu.Lock()
+			rbm.writer.Flush()
+			rbm.fp.Close()
+			rbm.mu.Unlock()
+			return
+		case <-ticker.C:
+			rbm.startEpoch()
+		case <-retry.C:
+			// the nodes missing the randomness would never end the epoch
+			rbm.mu.Lock()
+			if rbm.pending != nil {
+				if time.Since(rbm.sent) > 2*time.Duration(config.ReconfigTimeout)*time.Millisecond {
+					utils.LoggerInstance.Warn("Only %d nodes are ready for epoch %d", rbm.ready.Size(), rbm.pending.Epoch)
+					rbm.completeEpoch()
+				} else {
+					rbm.broadcast(rbm.pending)
+				}
+			}
+			rbm.mu.Unlock()
+		}
+	}
+}
+
+// broadcast the randomness of the next epoch, unless the last epoch is still being reconfigured
+func (rbm *ReconfigBeaconMod) startEpoch() {
+	rbm.mu.Lock()
+	defer rbm.mu.Unlock()
+	if rbm.pending != nil {
+		utils.LoggerInstance.Warn("The nodes are not ready for epoch %d yet, skip the reconfiguration", rbm.pending.Epoch)
+		return
+	}
+
+	attr := rbm.nodeAttr
+	rbm.pending = reconfig.NewContent(rbm.sk, attr.Epoch+1, attr.Seed)
+	rbm.next = reconfig.Assign(attr.Seats, rbm.pending.Seed, config.ReconfigSwapNum)
+	rbm.ready = utils.NewSet[int]()
+	rbm.sent = time.Now()
+	rbm.broadcast(rbm.pending)
+	utils.LoggerInstance.Info("Broadcast the randomness of epoch %d", rbm.pending.Epoch)
+}
+
+// call with mu held
+func (rbm *ReconfigBeaconMod) broadcast(content *reconfig.Content) {
+	msg := message.Message{
+		MsgType: message.MsgReconfig,
+		Content: utils.Encode(content),
+	}
+	msgBytes := msg.JsonEncode()
+	for sid := 0; sid < config.ShardNum; sid++ {
+		for _, addr := range config.IPMap[sid] {
+			rbm.p2pMod.ConnMananger.Send(addr, msgBytes)
+		}
+	}
+}
+
+// switch to the new seats once all the nodes are ready
+func (rbm *ReconfigBeaconMod) handleEpochReady(msg *message.Message) {
+	content := reconfig.ReadyContent{}
+	if err := utils.Decode(msg.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the ready message")
+		return
+	}
+
+	rbm.mu.Lock()
+	defer rbm.mu.Unlock()
+	if rbm.pending == nil || content.Epoch != rbm.pending.Epoch {
+		return
+	}
+	rbm.ready.Add(content.Identity)
+	if rbm.ready.Size() == config.ShardNum*config.NodeNum {
+		rbm.completeEpoch()
+	}
+}
+
+// route the txs to the new committees and record the epoch, call with mu held
+func (rbm *ReconfigBeaconMod) completeEpoch() {
+	attr := rbm.nodeAttr
+	addrs := make([]string, len(attr.Seats))
+	moved := 0
+	for g, seat := range attr.Seats {
+		sid, nid := reconfig.SeatOf(seat)
+		addrs[g] = config.IPMap[sid][nid]
+		if newSid, _ := reconfig.SeatOf(rbm.next[g]); newSid != sid {
+			moved++
+		}
+	}
+	ipMap := reconfig.AddrMap(addrs, rbm.next)
+	ipMap[config.ClientShard] = config.IPMap[config.ClientShard]
+	config.IPMap = ipMap
+
+	// the processes keep being malicious in the new shards, a shard with more than f malicious nodes is unsafe
+	counts := reconfig.MaliciousPerShard(rbm.next, func(g int) bool {
+		return config.IsMaliciousProcess(g/config.NodeNum, g%config.NodeNum)
+	})
+	maxMalicious, unsafe := 0, 0
+	for _, cnt := range counts {
+		if cnt > maxMalicious {
+			maxMalicious = cnt
+		}
+		if cnt > (config.NodeNum-1)/3 {
+			unsafe++
+		}
+	}
+
+	duration := time.Since(rbm.sent)
+	utils.LoggerInstance.Info("Epoch %d: %d nodes moved, %d nodes are ready after %v, %d shards are unsafe", rbm.pending.Epoch, moved, rbm.ready.Size(), duration, unsafe)
+	rbm.writer.Write([]string{
+		strconv.Itoa(rbm.pending.Epoch),
+		strconv.FormatFloat(rbm.sent.Sub(rbm.startTime).Seconds(), 'f', 2, 64),
+		strconv.FormatFloat(duration.Seconds(), 'f', 3, 64),
+		strconv.Itoa(rbm.ready.Size()),
+		strconv.Itoa(moved),
+		strconv.Itoa(maxMalicious),
+		strconv.Itoa(unsafe),
+	})
+	rbm.writer.Flush()
+
+	attr.Epoch, attr.Seed, attr.Seats = rbm.pending.Epoch, rbm.pending.Seed, rbm.next
+	rbm.pending = nil
+}
@@ -10,6 +10,7 @@ import (
 	"BlockChainSimulator/utils"
 	"context"
 	"math/big"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -53,36 +54,68 @@ func (smatm *sendMimicAccountTxsMod) Run(ctx context.Context, wg *sync.WaitGroup
 		case <-ticker.C:
 			utils.LoggerInstance.Debug("Try to send mimic contract txs")
 			txs := generateMimicAccountTxs()
+			if txObserver != nil {
+				txObserver(txs)
+			}
 
 			txsToSend := make(map[int][]structs.Transaction) // key: sid, value: txs
 			for _, tx := range txs {
 				sid := utils.Addr2Shard(tx.To()[0])
+				if config.UsesRelayTxs() || config.ConsensusMethod == "CShard" {
+					// the tx is processed by the shard of the sender, which deducts the value.
+					// In CShard, the leaders check that the senders of a block belong to its origin shard
+					sid = utils.Addr2Shard(tx.From()[0])
+				}
 				if _, ok := txsToSend[sid]; !ok {
 					txsToSend[sid] = make([]structs.Transaction, 0)
 				}
 				txsToSend[sid] = append(txsToSend[sid], tx)
 			}
 
-			// send the txs to the corresponding shard
+			// send the txs to every node of the corresponding shard, any of them may become the primary
 			for sid, txs := range txsToSend {
 				msg := message.Message{
 					MsgType: message.MsgInject,
 					Content: utils.Encode(txs),
 				}
 				utils.LoggerInstance.Debug("send txs to shard %v, len %v", sid, len(txs))
-				smatm.p2pMod.ConnMananger.Send(config.IPMap[sid][0], msg.JsonEncode())
+				msgBytes := msg.JsonEncode()
+				for _, addr := range config.IPMap[sid] {
+					smatm.p2pMod.ConnMananger.Send(addr, msgBytes)
+				}
 			}
 		}
 	}
 }
 
+var (
+	mimicAccountsOnce sync.Once
+	mimicAccounts     []structs.Address
+	mimicZipf         *rand.Zipf
+)
+
+// the account of a mimic tx, drawn from config.MimicAccountNum accounts with a zipf distribution, so some accounts are hot
+func mimicAccount() structs.Address {
+	if config.MimicAccountNum <= 0 {
+		return randomAddr()
+	}
+	mimicAccountsOnce.Do(func() {
+		mimicAccounts = make([]structs.Address, config.MimicAccountNum)
+		for i := range mimicAccounts {
+			mimicAccounts[i] = randomAddr()
+		}
+		mimicZipf = rand.NewZipf(rand.New(rand.NewSource(time.Now().UnixNano())), config.MimicZipfS, 1, uint64(config.MimicAccountNum-1))
+	})
+	return mimicAccounts[mimicZipf.Uint64()]
+}
+
 // generate config.TxInjectSpeed of mimic contract
 func generateMimicAccountTxs() []structs.Transaction {
 	txs := make([]structs.Transaction, 0)
 	for i := 0; i < config.TxInjectSpeed; i++ {
 		tx := structs.NewAccountTransaction(
-			randomAddr(),
-			randomAddr(),
+			mimicAccount(),
+			mimicAccount(),
 			0,
 			big.NewInt(0),
 		)
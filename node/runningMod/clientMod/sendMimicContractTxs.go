@@ -65,13 +65,16 @@ func (smctm *sendMimicContractTxsMod) Run(ctx context.Context, wg *sync.WaitGrou
 				txsToSend[sid] = append(txsToSend[sid], tx)
 			}
 
-			// send the txs to the corresponding shard
+			// send the txs to every node of the corresponding shard, any of them may become the primary
 			for sid, txs := range txsToSend {
 				msg := message.Message{
 					MsgType: message.MsgInject,
 					Content: utils.Encode(txs),
 				}
-				smctm.p2pMod.ConnMananger.Send(config.IPMap[sid][0], msg.JsonEncode())
+				msgBytes := msg.JsonEncode()
+				for _, addr := range config.IPMap[sid] {
+					smctm.p2pMod.ConnMananger.Send(addr, msgBytes)
+				}
 			}
 		}
 	}
@@ -80,11 +83,17 @@ func (smctm *sendMimicContractTxsMod) Run(ctx context.Context, wg *sync.WaitGrou
 // generate config.TxInjectSpeed of mimic contract txs
 func generateMimicContractTxs() []structs.Transaction {
 	crossShardRatio := 0.5 // cross shard txs ratio
+	relatedNum := 2        // number of the related contracts of a cross shard tx
 	txs := make([]structs.Transaction, 0)
 	for i := 0; i < config.TxInjectSpeed; i++ {
 		isCrossShard := false
+		relatedContract := []string{}
 		if randFloat64() < crossShardRatio {
 			isCrossShard = true
+			// the related contracts are random, they are locked in the shards owning them
+			for j := 0; j < relatedNum; j++ {
+				relatedContract = append(relatedContract, randomAddr())
+			}
 		}
 
 		tx := structs.NewContractTransaction(
@@ -93,7 +102,7 @@ func generateMimicContractTxs() []structs.Transaction {
 			0,
 			time.Now(),
 			[]byte("code.."),
-			[]string{},
+			relatedContract,
 			isCrossShard,
 		)
 
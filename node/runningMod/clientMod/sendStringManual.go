@@ -13,6 +13,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,8 +39,9 @@ func NewSendStringManualMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningMod
 	ssmm.p2pMod = p2p
 
 	ssmm.commands = map[string]CommandHandler{
-		"propose": ssmm.handleCmdPropose,
-		"help":    ssmm.handleCmdHelp,
+		"propose":   ssmm.handleCmdPropose,
+		"proposeto": ssmm.handleCmdProposeTo,
+		"help":      ssmm.handleCmdHelp,
 	}
 	ssmm.inputScanner = bufio.NewScanner(os.Stdin)
 
@@ -83,7 +85,9 @@ func (ssmm *sendStringManualMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 func (ssmm *sendStringManualMod) printWelcome() {
 	fmt.Println(strings.Repeat("=", 60))
 	fmt.Println("Interactive Blockchain Client")
-	fmt.Printf("Connected to Node ID: %d | IP: %s\n", 0, config.IPMap[0][0])
+	for sid := 0; sid < config.ShardNum; sid++ {
+		fmt.Printf("Shard %d | View Node ID: %d | IP: %s\n", sid, config.ViewNodeId, config.IPMap[sid][config.ViewNodeId])
+	}
 	fmt.Println("Type 'help' for available commands")
 	fmt.Println(strings.Repeat("=", 60))
 }
@@ -91,11 +95,13 @@ func (ssmm *sendStringManualMod) printWelcome() {
 func (ssmm *sendStringManualMod) printUsage() {
 	fmt.Println(`
 Available commands:
-  propose <message>  - Submit a new string input to the blockchain
-  help               - Show this help message
+  propose <message>          - Submit a new string input to every shard
+  proposeto <shard> <message> - Submit a new string input to one shard
+  help                       - Show this help message
 
 Examples:
   propose "Transfer 100 BTC to Alice"
+  proposeto 1 "Transfer 100 BTC to Bob"
   help
 
 Press Ctrl+C to exit
@@ -131,7 +137,9 @@ func (ssmm *sendStringManualMod) processInput(input string) {
 		args = []string{parts[1]}
 	}
 
-	handler(args)
+	if err := handler(args); err != nil {
+		fmt.Println(err)
+	}
 }
 
 func (ssmm *sendStringManualMod) handleCmdPropose(args []string) error {
@@ -139,14 +147,39 @@ func (ssmm *sendStringManualMod) handleCmdPropose(args []string) error {
 		return errors.New("propose command requires a message")
 	}
 
+	// every shard runs its own instance on the value
+	for sid := 0; sid < config.ShardNum; sid++ {
+		ssmm.inject(sid, args[0])
+	}
+	return nil
+}
+
+func (ssmm *sendStringManualMod) handleCmdProposeTo(args []string) error {
+	if len(args) == 0 {
+		return errors.New("proposeto command requires a shard id and a message")
+	}
+	parts := strings.SplitN(args[0], " ", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		return errors.New("proposeto command requires a shard id and a message")
+	}
+	sid, err := strconv.Atoi(parts[0])
+	if err != nil || sid < 0 || sid >= config.ShardNum {
+		return fmt.Errorf("invalid shard id: %v", parts[0])
+	}
+
+	ssmm.inject(sid, parts[1])
+	return nil
+}
+
+// send the value to the view node of the shard
+func (ssmm *sendStringManualMod) inject(sid int, value string) {
 	proposeMsg := message.Message{
 		MsgType: message.MsgInject,
-		Content: utils.Encode(args[0]),
+		Content: utils.Encode(value),
 	}
 
-	utils.LoggerInstance.Info("Inject value %v", args[0])
-	ssmm.p2pMod.ConnMananger.Send(config.IPMap[0][0], proposeMsg.JsonEncode())
-	return nil
+	utils.LoggerInstance.Info("Inject value %v to shard %d", value, sid)
+	ssmm.p2pMod.ConnMananger.Send(config.IPMap[sid][config.ViewNodeId], proposeMsg.JsonEncode())
 }
 
 func (ssmm *sendStringManualMod) handleCmdHelp([]string) error {
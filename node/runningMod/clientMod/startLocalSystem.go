@@ -5,6 +5,7 @@ import (
 	"BlockChainSimulator/node/nodeattr"
 	"BlockChainSimulator/node/p2p"
 	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
 	"BlockChainSimulator/utils"
 	"context"
 	"os/exec"
@@ -33,6 +34,21 @@ func (sam *StartLocalSystemAuxiliaryMod) RegisterHandlers() {
 
 func (sam *StartLocalSystemAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
+	// the client deals the keys of the common coin of every shard, so no node knows the polynomial.
+	// The keys are written to the files of the nodes, never to the command lines
+	if config.ConsensusMethod == "HoneyBadger" {
+		for i := 0; i < config.ShardNum; i++ {
+			sid := i
+			path := func(nid int) string { return config.HBBFTCoinKeyFile(sid, nid) }
+			if err := signature.DealThresholdKeys(config.HBBFTCoinThreshold(), config.NodeNum, path); err != nil {
+				utils.LoggerInstance.Error("Failed to deal the coin keys of shard %d: %v", i, err)
+			}
+		}
+		if config.IsDistributed {
+			utils.LoggerInstance.Info("The coin keys are written to %s, copy the file of every node to the same path of its server", config.StoragePath)
+		}
+	}
+
 	// start the system
 	if config.IsDistributed {
 		utils.LoggerInstance.Error("Auto start in distributed mode is not supported yet, maybe use some manual script to start the system :)")
@@ -44,7 +60,8 @@ func (sam *StartLocalSystemAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitG
 			// start the consensus node, no more need the client-related parameters
 			cmdstr := "./BlockChainSimulator" +
 				" -b " + strconv.Itoa(config.BlockSize) +
-				" -S " + strconv.Itoa(config.ShardNum) + " -N " + strconv.Itoa(config.NodeNum) +
+				" -S " + strconv.Itoa(config.ShardNum) + " -N " + strconv.Itoa(config.NodeNum) + " -K " + strconv.Itoa(config.K) +
+				" -a " + config.ShardAllocMethod +
 				" -s " + strconv.Itoa(i) + " -n " + strconv.Itoa(j) +
 				" -m " + config.ConsensusMethod +
 				" -l " + config.LogLevel + " -t " + config.TxType +
@@ -52,14 +69,19 @@ func (sam *StartLocalSystemAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitG
 				" -R " + strconv.FormatFloat(config.ResilientRatio, 'f', -3, 64)
 
 			// malicious node
-			if float64(i*config.NodeNum+j) > (1-config.MaliciousRatio)*float64(config.ShardNum*config.NodeNum) {
-				cmdstr += " -M "
+			if config.IsMaliciousProcess(i, j) {
+				cmdstr += " -M -B " + config.MaliciousStrategy
 			}
 
 			if config.ConnectRemoteDemo {
 				cmdstr += " -C "
 			}
 
+			// the public key of the beacon generated by ReconfigBeaconMod
+			if config.ReconfigBeaconKey != "" {
+				cmdstr += " -k " + config.ReconfigBeaconKey
+			}
+
 			utils.LoggerInstance.Debug("run cmd: %s", cmdstr)
 
 			cmd := exec.Command("bash", "-c", cmdstr)
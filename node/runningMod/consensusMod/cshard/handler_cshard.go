@@ -0,0 +1,451 @@
+// This file contains the CShard coded sharding protocol.
+// The first config.K shards are origin shards, they run PBFT to produce blocks, and the primary sends each committed block
+// to the leaders of all the shards(MsgPreInject). Once a leader has the blocks of a round(the i-th block of each shard) from the K origin
+// shards, it verifies the inputs and sends the signed result to LL, the leader of config.LL_Shard(MsgInputVerifyResult).
+// LL makes the round legal when a majority of the shards agree, generates the coding seed with the VRF and sends the blocks
+// with the coding schema and the signed results of the majority to every node(MsgBlockLegal).
+// The coded shards store a random linear combination of the blocks.
+// The blocks carry the commit proof of the origin shard, which is checked by the PBFT addon before it passes them to this mod
+package cshard
+
+import (
+	rfccode "BlockChainSimulator/addon/coding"
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ runningModInterface.RunningMod = &CShardMod{}
+
+const roundWindow = 1000 // the rounds more than roundWindow away from the latest verified round are forgotten, their messages are ignored
+
+type CShardMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	isLeader bool // whether the node is the leader(the view node) of its shard
+	isLL     bool // whether the node is LL
+
+	// used by the leaders
+	blocks      map[int64]map[int]*PreInjectContent // the blocks waiting for verification, round -> origin sid -> block
+	blockRounds map[blockKey]int64                  // the round of each accepted block
+	verified    *utils.Set[int64]                   // the rounds verified by this leader
+	latest      int64                               // the latest round verified by this leader
+
+	// used by LL
+	results map[int64]map[int]*InputVerifyResult // round -> sid -> result
+	decided *utils.Set[int64]                    // the rounds decided by LL
+
+	csLock sync.Mutex
+}
+
+func NewCShardMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	csMod := new(CShardMod)
+	csMod.nodeAttr = attr
+	csMod.p2pMod = p2p
+
+	csMod.isLeader = attr.Nid == config.ViewNodeId
+	csMod.isLL = csMod.isLeader && attr.Sid == config.LL_Shard
+
+	csMod.blocks = make(map[int64]map[int]*PreInjectContent)
+	csMod.blockRounds = make(map[blockKey]int64)
+	csMod.verified = utils.NewSet[int64]()
+	csMod.results = make(map[int64]map[int]*InputVerifyResult)
+	csMod.decided = utils.NewSet[int64]()
+
+	return csMod
+}
+
+func (csMod *CShardMod) RegisterHandlers() {
+	// the blocks are received by the PBFT addon, which checks the commit proof
+	csMod.p2pMod.RegisterLocalHandler(message.MsgPreInject, csMod.handlePreInject)
+	csMod.p2pMod.RegisterHandler(message.MsgInputVerifyResult, csMod.handleInputVerifyResult)
+	csMod.p2pMod.RegisterHandler(message.MsgBlockLegal, csMod.handleBlockLegal)
+}
+
+// the leader checks the rounds ready to verify every config.Verify_Interval
+func (csMod *CShardMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if !csMod.isLeader {
+		utils.LoggerInstance.Info("This node is not the leader, do not need to verify the inputs")
+		return
+	}
+
+	utils.LoggerInstance.Info("Start the CShard Mod, origin shards: %d, coded shards: %d", config.K, config.ShardNum-config.K)
+	ticker := time.NewTicker(time.Duration(config.Verify_Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			utils.LoggerInstance.Info("Stop the CShard Mod")
+			return
+		case <-ticker.C:
+			csMod.verifyReadyRounds()
+		}
+	}
+}
+
+// the leader receives the committed block of an origin shard, the commit proof is checked by the PBFT addon.
+// The first block of a round is kept, and a block is accepted in one round only
+func (csMod *CShardMod) handlePreInject(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle pre-inject")
+
+	pre := PreInjectContent{}
+	err := utils.Decode(msg.Content, &pre)
+	if err != nil || pre.Req == nil {
+		utils.LoggerInstance.Error("Error decoding the pre-inject message")
+		return
+	}
+
+	csMod.csLock.Lock()
+	defer csMod.csLock.Unlock()
+	if !csMod.inWindow(pre.Round) || csMod.verified.Contains(pre.Round) {
+		utils.LoggerInstance.Warn("Ignore the block of round %d from shard %d, the latest verified round is %d", pre.Round, pre.Sid, csMod.latest)
+		return
+	}
+	if csMod.blocks[pre.Round][pre.Sid] != nil {
+		return
+	}
+	key := blockKey{Sid: pre.Sid, Digest: pre.Req.Digest}
+	if round, exists := csMod.blockRounds[key]; exists {
+		utils.LoggerInstance.Warn("The block from shard %d is already in round %d, ignore it in round %d", pre.Sid, round, pre.Round)
+		return
+	}
+	if csMod.blocks[pre.Round] == nil {
+		csMod.blocks[pre.Round] = make(map[int]*PreInjectContent)
+	}
+	csMod.blocks[pre.Round][pre.Sid] = &pre
+	csMod.blockRounds[key] = pre.Round
+}
+
+// whether the round is close enough to the latest verified round, the caller holds csLock
+func (csMod *CShardMod) inWindow(round int64) bool {
+	return round > csMod.latest-roundWindow && round <= csMod.latest+roundWindow
+}
+
+// forget the rounds out of the window, the caller holds csLock
+func (csMod *CShardMod) prune() {
+	for round := range csMod.blocks {
+		if !csMod.inWindow(round) {
+			delete(csMod.blocks, round)
+		}
+	}
+	for key, round := range csMod.blockRounds {
+		if !csMod.inWindow(round) {
+			delete(csMod.blockRounds, key)
+		}
+	}
+	for _, round := range csMod.verified.GetItems() {
+		if !csMod.inWindow(round) {
+			csMod.verified.Remove(round)
+		}
+	}
+	for round := range csMod.results {
+		if !csMod.inWindow(round) {
+			delete(csMod.results, round)
+		}
+	}
+	for _, round := range csMod.decided.GetItems() {
+		if !csMod.inWindow(round) {
+			csMod.decided.Remove(round)
+		}
+	}
+}
+
+// verify the rounds whose blocks are received from all the origin shards, and send the results to LL
+func (csMod *CShardMod) verifyReadyRounds() {
+	results := make([]*InputVerifyResult, 0)
+
+	csMod.csLock.Lock()
+	for round, pres := range csMod.blocks {
+		if len(pres) < config.K || csMod.verified.Contains(round) {
+			continue
+		}
+		blocks, err := decodeBlocks(pres)
+		res := &InputVerifyResult{
+			Round: round,
+			Sid:   csMod.nodeAttr.Sid,
+			Legal: err == nil && verifyInputs(blocks),
+		}
+		if err == nil {
+			res.Digest = roundDigest(blocks)
+		}
+		res.Sig = signature.Sign(csMod.nodeAttr.SecKey, resultContent(res))
+		results = append(results, res)
+
+		csMod.verified.Add(round)
+		if round > csMod.latest {
+			csMod.latest = round
+		}
+		// LL keeps the blocks until the round is decided
+		if !csMod.isLL {
+			delete(csMod.blocks, round)
+		}
+	}
+	if len(results) > 0 {
+		csMod.prune()
+	}
+	csMod.csLock.Unlock()
+
+	llAddr := config.IPMap[config.LL_Shard][config.ViewNodeId]
+	for _, res := range results {
+		msg := message.Message{
+			MsgType: message.MsgInputVerifyResult,
+			Content: utils.Encode(res),
+		}
+		utils.LoggerInstance.Info("Send the input verification result of round %d to LL, legal: %t", res.Round, res.Legal)
+		if csMod.isLL {
+			go csMod.handleInputVerifyResult(&msg)
+		} else {
+			csMod.p2pMod.ConnMananger.Send(llAddr, msg.JsonEncode())
+		}
+	}
+}
+
+// LL collects the results, the round is decided when a majority of the shards agree
+func (csMod *CShardMod) handleInputVerifyResult(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle input verify result")
+	if !csMod.isLL {
+		utils.LoggerInstance.Warn("This node is not LL, ignore the input verification result")
+		return
+	}
+
+	res := InputVerifyResult{}
+	err := utils.Decode(msg.Content, &res)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the input verification result")
+		return
+	}
+	if res.Sid < 0 || res.Sid >= config.ShardNum {
+		utils.LoggerInstance.Warn("Unknown shard %d", res.Sid)
+		return
+	}
+	if !csMod.nodeAttr.VerifySig(res.Sid, config.ViewNodeId, resultContent(&res), res.Sig) {
+		utils.LoggerInstance.Warn("The signature of the input verification result from shard %d is not valid", res.Sid)
+		return
+	}
+
+	csMod.csLock.Lock()
+	if !csMod.inWindow(res.Round) || csMod.decided.Contains(res.Round) {
+		csMod.csLock.Unlock()
+		return
+	}
+	if csMod.results[res.Round] == nil {
+		csMod.results[res.Round] = make(map[int]*InputVerifyResult)
+	}
+	csMod.results[res.Round][res.Sid] = &res
+
+	illegal := 0
+	for _, r := range csMod.results[res.Round] {
+		if !r.Legal {
+			illegal++
+		}
+	}
+	quorum := config.ShardNum/2 + 1
+	if illegal >= quorum {
+		csMod.decideRound(res.Round)
+		csMod.csLock.Unlock()
+		utils.LoggerInstance.Warn("The inputs of round %d are illegal", res.Round)
+		return
+	}
+
+	// LL needs the blocks of the round to send them, its own result is sent after it has the blocks
+	pres := csMod.blocks[res.Round]
+	if len(pres) < config.K {
+		csMod.csLock.Unlock()
+		return
+	}
+	blocks, err := decodeBlocks(pres)
+	if err != nil {
+		csMod.csLock.Unlock()
+		return
+	}
+	digest := roundDigest(blocks)
+	legal := make([]*InputVerifyResult, 0, len(csMod.results[res.Round]))
+	for _, r := range csMod.results[res.Round] {
+		if r.Legal && bytes.Equal(r.Digest, digest) {
+			legal = append(legal, r)
+		}
+	}
+	if len(legal) < quorum {
+		csMod.csLock.Unlock()
+		return
+	}
+	csMod.decideRound(res.Round)
+	csMod.csLock.Unlock()
+
+	csMod.sendBlockLegal(res.Round, blocks, digest, pres, legal)
+}
+
+// the caller holds csLock
+func (csMod *CShardMod) decideRound(round int64) {
+	csMod.decided.Add(round)
+	delete(csMod.results, round)
+	delete(csMod.blocks, round)
+}
+
+// generate the coding schema of the round with the VRF, send it with the blocks and the legal results to every node, and reply to the client
+func (csMod *CShardMod) sendBlockLegal(round int64, blocks []*structs.Block, digest []byte, pres map[int]*PreInjectContent, results []*InputVerifyResult) {
+	randSeed, proof := rfccode.GenRandSeed(*csMod.nodeAttr.VRFKey, structs.Block{Hash: digest})
+	schema := &rfccode.CodingSchema{
+		Round:        round,
+		K:            config.K,
+		N:            config.ShardNum,
+		RandSeedsStr: strconv.FormatInt(randSeed, 10),
+		VRFProof:     proof,
+	}
+
+	msg := message.Message{
+		MsgType: message.MsgBlockLegal,
+		Content: utils.Encode(BlockLegalContent{Round: round, Blocks: blocks, Schema: schema, Results: results}),
+	}
+	msgBytes := msg.JsonEncode()
+	for sid := 0; sid < config.ShardNum; sid++ {
+		csMod.p2pMod.ConnMananger.Broadcast(csMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[sid], csMod.nodeAttr.Ipaddr), msgBytes)
+	}
+	go csMod.handleBlockLegal(&msg)
+	utils.LoggerInstance.Info("Round %d is legal, send the blocks and the coding schema to all the nodes", round)
+
+	// the blocks are confirmed when they are legal
+	for sid := 0; sid < config.K; sid++ {
+		pre := pres[sid]
+		if pre == nil {
+			utils.LoggerInstance.Error("The block of shard %d in round %d is missing", sid, round)
+			continue
+		}
+		reply := &message.Reply{
+			Req:  pre.Req,
+			Time: time.Now(),
+
+			Sid:         sid,
+			ReqQueueLen: pre.ReqQueueLen,
+		}
+		replaymsg := message.Message{
+			MsgType: message.MsgReply,
+			Content: utils.Encode(reply),
+		}
+		csMod.p2pMod.ConnMananger.Send(config.ClientAddr, replaymsg.JsonEncode())
+	}
+}
+
+// every node checks that a majority of the shards sign the round legal and the coding seed, the origin shards keep their own blocks,
+// the coded shards store the coded chunk. The coding vector of the shard is derived from the seed by the encoder, so it is not taken from LL
+func (csMod *CShardMod) handleBlockLegal(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle block legal")
+
+	content := BlockLegalContent{}
+	err := utils.Decode(msg.Content, &content)
+	if err != nil || content.Schema == nil || len(content.Blocks) != config.K {
+		utils.LoggerInstance.Error("Error decoding the block legal message")
+		return
+	}
+	schema := content.Schema
+	digest := roundDigest(content.Blocks)
+	if schema.Round != content.Round || !csMod.checkResults(content.Round, digest, content.Results) {
+		utils.LoggerInstance.Warn("Round %d is not signed legal by a majority of the shards", content.Round)
+		return
+	}
+
+	llKey := csMod.llVRFKey()
+	if llKey == nil {
+		utils.LoggerInstance.Warn("The VRF key of LL is unknown, ignore the coding schema of round %d", content.Round)
+		return
+	}
+	randSeed, err := strconv.ParseInt(schema.RandSeedsStr, 10, 64)
+	if err != nil || !rfccode.VerifyRandSeed(*llKey, structs.Block{Hash: digest}, randSeed, schema.VRFProof) {
+		utils.LoggerInstance.Warn("The VRF proof of round %d is not valid", content.Round)
+		return
+	}
+	sid := csMod.nodeAttr.Sid
+	blocks := make([]structs.Block, len(content.Blocks))
+	for i, b := range content.Blocks {
+		blocks[i] = *b
+	}
+	chunk, vector := rfccode.Encode(blocks, randSeed, sid)
+	if len(vector) != config.K || len(chunk.DataBlock) == 0 {
+		utils.LoggerInstance.Error("Error encoding the blocks of round %d", content.Round)
+		return
+	}
+	schema.CodingMatrix = make([][]byte, config.ShardNum)
+	schema.CodingMatrix[sid] = vector
+
+	storage := csMod.nodeAttr.CurChain.Storage
+	storage.SaveCodingSchema(schema)
+	if sid < config.K {
+		utils.LoggerInstance.Info("Round %d is legal", content.Round)
+		return
+	}
+	storage.SaveChunks(&chunk)
+	utils.LoggerInstance.Info("Store the coded chunk of round %d, %d bytes", content.Round, len(chunk.DataBlock))
+}
+
+// the VRF key of LL, synchronized by the SyncPubKeysMod. Wait for the synchronization if it is unknown, nil if it does not complete in time
+func (csMod *CShardMod) llVRFKey() *ecdsa.PublicKey {
+	key := csMod.nodeAttr.GetVRFKey(config.LL_Shard, config.ViewNodeId)
+	if key == nil && csMod.nodeAttr.WaitForPubKeys(nodeattr.PubKeyWaitTimeout) {
+		key = csMod.nodeAttr.GetVRFKey(config.LL_Shard, config.ViewNodeId)
+	}
+	return key
+}
+
+// whether the leaders of a majority of the shards sign the round legal on the digest, each shard is counted once
+func (csMod *CShardMod) checkResults(round int64, digest []byte, results []*InputVerifyResult) bool {
+	shards := make(map[int]bool)
+	for _, res := range results {
+		if res == nil || res.Round != round || !res.Legal || !bytes.Equal(res.Digest, digest) || shards[res.Sid] {
+			continue
+		}
+		if res.Sid < 0 || res.Sid >= config.ShardNum || !csMod.nodeAttr.VerifySig(res.Sid, config.ViewNodeId, resultContent(res), res.Sig) {
+			continue
+		}
+		shards[res.Sid] = true
+	}
+	return len(shards) >= config.ShardNum/2+1
+}
+
+// the blocks of the origin shards in the order of the shard id
+func decodeBlocks(pres map[int]*PreInjectContent) ([]*structs.Block, error) {
+	blocks := make([]*structs.Block, config.K)
+	for sid := 0; sid < config.K; sid++ {
+		if pres[sid] == nil {
+			return nil, fmt.Errorf("the block of shard %d is missing", sid)
+		}
+		b := &structs.Block{}
+		if err := utils.Decode(pres[sid].Req.Content, b); err != nil {
+			return nil, err
+		}
+		blocks[sid] = b
+	}
+	return blocks, nil
+}
+
+// the block of origin shard i is legal if its tx root is right and the senders of its txs belong to shard i
+func verifyInputs(blocks []*structs.Block) bool {
+	for sid, b := range blocks {
+		if b.Header == nil || !bytes.Equal(blockchain.GetTxTreeRoot(b.Transactions), b.Header.TxRoot) {
+			utils.LoggerInstance.Warn("The tx root of the block from shard %d is wrong", sid)
+			return false
+		}
+		for _, tx := range b.Transactions {
+			if acTx, ok := tx.(*structs.AccountTransaction); ok && acTx.Sender != "" && utils.Addr2Shard(acTx.Sender) != sid {
+				utils.LoggerInstance.Warn("The sender of tx %x does not belong to shard %d", acTx.TxHash, sid)
+				return false
+			}
+		}
+	}
+	return true
+}
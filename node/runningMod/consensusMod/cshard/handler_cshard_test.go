@@ -0,0 +1,40 @@
+package cshard
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/signature"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a round is legal only with the results of a majority of the shards, signed by their leaders on the same digest
+func TestBlockLegalNeedsMajorityResults(t *testing.T) {
+	origShardNum, origNodeNum := config.ShardNum, config.NodeNum
+	config.ViewNodeId, config.ShardNum, config.NodeNum = 0, 2, 1 // 2 shards, both of them are needed
+	t.Cleanup(func() { config.ShardNum, config.NodeNum = origShardNum, origNodeNum })
+	keys := testutil.NewKeys(0, 1)
+	other := testutil.NewKeys(1, 1)
+	attr := keys.Attr(t, 0)
+	require.True(t, attr.SetPubKey(1, 0, other.Pks[0], nil))
+	csMod := &CShardMod{nodeAttr: attr}
+
+	digest := []byte("digest")
+	result := func(sid int, sk *signature.SecretKey, legal bool, digest []byte) *InputVerifyResult {
+		res := &InputVerifyResult{Round: 1, Sid: sid, Legal: legal, Digest: digest}
+		res.Sig = signature.Sign(sk, resultContent(res))
+		return res
+	}
+	res0 := result(0, keys.Sks[0], true, digest)
+	res1 := result(1, other.Sks[0], true, digest)
+
+	assert.True(t, csMod.checkResults(1, digest, []*InputVerifyResult{res0, res1}))
+	assert.False(t, csMod.checkResults(2, digest, []*InputVerifyResult{res0, res1}), "the results of another round")
+	assert.False(t, csMod.checkResults(1, []byte("other"), []*InputVerifyResult{res0, res1}), "the results on another digest")
+	assert.False(t, csMod.checkResults(1, digest, []*InputVerifyResult{res0, res0}), "a shard counted twice")
+	assert.False(t, csMod.checkResults(1, digest, []*InputVerifyResult{res0, nil}))
+	assert.False(t, csMod.checkResults(1, digest, []*InputVerifyResult{res0, result(1, keys.Sks[0], true, digest)}), "not signed by the leader of shard 1")
+	assert.False(t, csMod.checkResults(1, digest, []*InputVerifyResult{res0, result(1, other.Sks[0], false, digest)}), "shard 1 finds the round illegal")
+}
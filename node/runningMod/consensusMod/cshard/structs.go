@@ -0,0 +1,67 @@
+package cshard
+
+import (
+	rfccode "BlockChainSimulator/addon/coding"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+)
+
+// the block committed by an origin shard, sent by its primary to the leaders of all the shards
+type PreInjectContent struct {
+	Round       int64            // the number of blocks executed by the origin shard, the blocks of the same round in the K origin shards are coded together
+	Sid         int              // the origin shard
+	Req         *message.Request // the committed request, its content is the block
+	ReqQueueLen int              // the length of the request queue of the origin shard
+
+	// the commit proof of the request in the origin shard, the 2f+1 commit messages of the PBFT round, see pbft.CommitCert
+	PbftRound int
+	View      int
+	Signers   []int                // the nodes sending the commit messages
+	Sig       *signature.Signature // the aggregate signature of the commit messages
+}
+
+// the block of an origin shard, a block is accepted in one round only
+type blockKey struct {
+	Sid    int
+	Digest [32]byte
+}
+
+// the result of the input verification of a round, sent by the leader of each shard to LL
+type InputVerifyResult struct {
+	Round  int64
+	Sid    int    // the shard of the leader
+	Legal  bool   // whether the blocks of the round are legal
+	Digest []byte // the digest of the blocks of the round
+	Sig    *signature.Signature
+}
+
+// the blocks of a legal round and the coding schema, sent by LL to every node
+type BlockLegalContent struct {
+	Round   int64
+	Blocks  []*structs.Block // the blocks of the K origin shards, in the order of the shard id
+	Schema  *rfccode.CodingSchema
+	Results []*InputVerifyResult // the legal results of a majority of the shards on the digest of the blocks, the proof of the round
+}
+
+// the content signed in the input verification result
+func resultContent(res *InputVerifyResult) []byte {
+	return utils.CanonicalEncode(struct {
+		Round  int64
+		Sid    int
+		Legal  bool
+		Digest []byte
+	}{res.Round, res.Sid, res.Legal, res.Digest})
+}
+
+// the digest of the blocks of a round, it is the input of the VRF
+func roundDigest(blocks []*structs.Block) []byte {
+	hashes := make([][]byte, len(blocks))
+	for i, b := range blocks {
+		hashes[i] = b.Hash
+	}
+	digest := sha256.Sum256(utils.CanonicalEncode(hashes))
+	return digest[:]
+}
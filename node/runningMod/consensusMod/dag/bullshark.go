@@ -0,0 +1,172 @@
+// This file contains the ordering rule of Bullshark (the partially synchronous version), it sends no message of its own.
+// The even rounds have a leader, whose certificate is the anchor of the round. The anchor of round r is committed once
+// f+1 certificates of round r+1 have it as a parent. Before committing it, the uncommitted anchors of the earlier even
+// rounds are committed in order if the anchor has a path to them, so every node commits the same sequence of anchors.
+// The causal history of an anchor not ordered yet is ordered by the round and then by the author
+package dag
+
+import (
+	"sort"
+)
+
+// an anchor and the vertices it orders, the anchor is the last of them
+type orderedAnchor struct {
+	Anchor   *Certificate
+	Vertices []*Certificate
+}
+
+type orderer struct {
+	n, f       int
+	gcDepth    int                          // the vertices gcDepth rounds below the last committed anchor are dropped
+	vertices   map[int]map[int]*Certificate // round -> author -> the certificate
+	digests    map[[32]byte]*Certificate
+	ordered    map[[32]byte]bool
+	lastAnchor int // the round of the last committed anchor
+	gcRound    int // the rounds below it are dropped
+}
+
+func newOrderer(n int, gcDepth int) *orderer {
+	return &orderer{
+		n:          n,
+		f:          (n - 1) / 3,
+		gcDepth:    gcDepth,
+		vertices:   make(map[int]map[int]*Certificate),
+		digests:    make(map[[32]byte]*Certificate),
+		ordered:    make(map[[32]byte]bool),
+		lastAnchor: -2,
+	}
+}
+
+// insert a vertex whose parents have been inserted, returns the anchors committed by it in order
+func (o *orderer) insert(c *Certificate) []*orderedAnchor {
+	r := c.Header.Round
+	if r < o.gcRound {
+		return nil
+	}
+	if o.vertices[r] == nil {
+		o.vertices[r] = make(map[int]*Certificate)
+	}
+	if _, ok := o.vertices[r][c.Header.Author]; ok {
+		return nil
+	}
+	o.vertices[r][c.Header.Author] = c
+	o.digests[c.Header.Digest()] = c
+
+	// the vertices of the odd rounds vote for the anchor of the previous round
+	if r%2 == 0 {
+		return nil
+	}
+	return o.tryCommit(r - 1)
+}
+
+// commit the anchor of the round if f+1 vertices of the next round vote for it
+func (o *orderer) tryCommit(round int) []*orderedAnchor {
+	if round <= o.lastAnchor {
+		return nil
+	}
+	anchor, ok := o.vertices[round][Leader(round, o.n)]
+	if !ok {
+		return nil
+	}
+	digest := anchor.Header.Digest()
+	votes := 0
+	for _, c := range o.vertices[round+1] {
+		for _, parent := range c.Header.Parents {
+			if parent == digest {
+				votes++
+				break
+			}
+		}
+	}
+	if votes < o.f+1 {
+		return nil
+	}
+
+	// the earlier anchors reachable from the committed one, from the newest to the oldest
+	chain := []*Certificate{anchor}
+	cur := anchor
+	for r := round - 2; r > o.lastAnchor; r -= 2 {
+		prev, ok := o.vertices[r][Leader(r, o.n)]
+		if ok && o.path(cur, prev) {
+			chain = append(chain, prev)
+			cur = prev
+		}
+	}
+
+	committed := make([]*orderedAnchor, 0, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		committed = append(committed, &orderedAnchor{Anchor: chain[i], Vertices: o.orderHistory(chain[i])})
+		o.lastAnchor = chain[i].Header.Round
+	}
+	o.gc()
+	return committed
+}
+
+// whether there is a path from the vertex to the target through the parents
+func (o *orderer) path(from *Certificate, to *Certificate) bool {
+	target := to.Header.Digest()
+	visited := map[[32]byte]bool{from.Header.Digest(): true}
+	frontier := []*Certificate{from}
+	for len(frontier) > 0 {
+		next := make([]*Certificate, 0)
+		for _, c := range frontier {
+			if c.Header.Round <= to.Header.Round {
+				continue
+			}
+			for _, parent := range c.Header.Parents {
+				if parent == target {
+					return true
+				}
+				if p, ok := o.digests[parent]; ok && !visited[parent] {
+					visited[parent] = true
+					next = append(next, p)
+				}
+			}
+		}
+		frontier = next
+	}
+	return false
+}
+
+// the vertices in the causal history of the anchor not ordered yet, ordered by the round and the author
+func (o *orderer) orderHistory(anchor *Certificate) []*Certificate {
+	history := make([]*Certificate, 0)
+	digest := anchor.Header.Digest()
+	if o.ordered[digest] {
+		return history
+	}
+	o.ordered[digest] = true
+	frontier := []*Certificate{anchor}
+	for len(frontier) > 0 {
+		c := frontier[len(frontier)-1]
+		frontier = frontier[:len(frontier)-1]
+		history = append(history, c)
+		for _, parent := range c.Header.Parents {
+			if p, ok := o.digests[parent]; ok && !o.ordered[parent] {
+				o.ordered[parent] = true
+				frontier = append(frontier, p)
+			}
+		}
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].Header.Round != history[j].Header.Round {
+			return history[i].Header.Round < history[j].Header.Round
+		}
+		return history[i].Header.Author < history[j].Header.Author
+	})
+	return history
+}
+
+// drop the vertices gcDepth rounds below the last committed anchor, they are not ordered any more
+func (o *orderer) gc() {
+	for o.gcRound < o.lastAnchor-o.gcDepth {
+		for _, c := range o.vertices[o.gcRound] {
+			digest := c.Header.Digest()
+			delete(o.digests, digest)
+			delete(o.ordered, digest)
+		}
+		delete(o.vertices, o.gcRound)
+		o.gcRound++
+	}
+}
@@ -0,0 +1,151 @@
+package dag
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTime = time.Unix(0, 0)
+
+// build a DAG of the rounds, each vertex of the live authors picks 2f+1 random parents of the previous round
+func randomDAG(rnd *rand.Rand, n int, rounds int, live []int) [][]*Certificate {
+	f := (n - 1) / 3
+	dag := make([][]*Certificate, rounds)
+	for r := 0; r < rounds; r++ {
+		for _, author := range live {
+			parents := make([][32]byte, 0)
+			if r > 0 {
+				perm := rnd.Perm(len(dag[r-1]))
+				for _, i := range perm[:min(2*f+1, len(perm))] {
+					parents = append(parents, dag[r-1][i].Header.Digest())
+				}
+			}
+			dag[r] = append(dag[r], &Certificate{Header: Header{Author: author, Round: r, Parents: parents, Time: testTime}})
+		}
+	}
+	return dag
+}
+
+// insert the vertices round by round in a random order of each round, which is a causal order
+func orderDAG(rnd *rand.Rand, o *orderer, dag [][]*Certificate) ([]*orderedAnchor, [][32]byte) {
+	anchors := make([]*orderedAnchor, 0)
+	sequence := make([][32]byte, 0)
+	for _, round := range dag {
+		for _, i := range rnd.Perm(len(round)) {
+			for _, a := range o.insert(round[i]) {
+				anchors = append(anchors, a)
+				for _, c := range a.Vertices {
+					sequence = append(sequence, c.Header.Digest())
+				}
+			}
+		}
+	}
+	return anchors, sequence
+}
+
+func TestOrdererSameSequence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 20; trial++ {
+		dag := randomDAG(rnd, 4, 30, []int{0, 1, 2, 3})
+		// a small depth drops the rounds below the committed anchors early, it must not change the sequence
+		gcDepth := []int{50, 2}[trial%2]
+		_, seq0 := orderDAG(rnd, newOrderer(4, gcDepth), dag)
+		_, seq1 := orderDAG(rnd, newOrderer(4, gcDepth), dag)
+		require.NotEmpty(t, seq0)
+		assert.Equal(t, seq0, seq1, "trial %d", trial)
+
+		ordered := make(map[[32]byte]bool)
+		for _, digest := range seq0 {
+			assert.False(t, ordered[digest], "a vertex is ordered twice")
+			ordered[digest] = true
+		}
+	}
+}
+
+func TestOrdererSilentLeader(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	dag := randomDAG(rnd, 4, 40, []int{1, 2, 3})
+	anchors, _ := orderDAG(rnd, newOrderer(4, 50), dag)
+	require.NotEmpty(t, anchors)
+	for _, a := range anchors {
+		assert.NotEqual(t, 0, a.Anchor.Header.Author, "the silent node has no anchor")
+		assert.Equal(t, a.Anchor, a.Vertices[len(a.Vertices)-1], "the anchor is the last vertex it orders")
+	}
+	// the anchors of the live leaders are committed in order
+	for i := 1; i < len(anchors); i++ {
+		assert.Less(t, anchors[i-1].Anchor.Header.Round, anchors[i].Anchor.Header.Round)
+	}
+}
+
+// the anchor of round 0 has one vote, less than f+1, it is committed before the anchor of round 2 which has a path to it
+func TestOrdererIndirectCommit(t *testing.T) {
+	n := 4
+	vertex := func(author, round int, parents ...*Certificate) *Certificate {
+		digests := make([][32]byte, 0, len(parents))
+		for _, p := range parents {
+			digests = append(digests, p.Header.Digest())
+		}
+		return &Certificate{Header: Header{Author: author, Round: round, Parents: digests, Time: testTime}}
+	}
+	r0 := []*Certificate{vertex(0, 0), vertex(1, 0), vertex(2, 0), vertex(3, 0)}
+	r1 := []*Certificate{
+		vertex(0, 1, r0[0], r0[1], r0[2]),
+		vertex(1, 1, r0[1], r0[2], r0[3]),
+		vertex(2, 1, r0[1], r0[2], r0[3]),
+		vertex(3, 1, r0[1], r0[2], r0[3]),
+	}
+	// the leader of round 2 is node 1
+	r2 := []*Certificate{vertex(0, 2, r1...), vertex(1, 2, r1[0], r1[1], r1[2]), vertex(2, 2, r1[1], r1[2], r1[3])}
+	r3 := []*Certificate{vertex(0, 3, r2...), vertex(2, 3, r2...)}
+
+	o := newOrderer(n, 50)
+	committed := make([]*orderedAnchor, 0)
+	for _, round := range [][]*Certificate{r0, r1, r2, r3} {
+		for _, c := range round {
+			committed = append(committed, o.insert(c)...)
+		}
+	}
+	require.Len(t, committed, 2)
+	assert.Equal(t, r0[0], committed[0].Anchor)
+	assert.Equal(t, r2[1], committed[1].Anchor)
+	assert.Len(t, committed[0].Vertices, 1)
+	// r0[1], r0[2], r0[3], r1[0], r1[1], r1[2] and the anchor
+	assert.Len(t, committed[1].Vertices, 7)
+}
+
+// a shard of 4 nodes whose keys are all known, the mempool is the one of node 0
+func newTestMempool(t *testing.T) (*NarwhalMempoolMod, []*signature.SecretKey) {
+	config.ShardNum, config.NodeNum = 1, 4
+	attr, sks := testutil.NewAttr(t, 0, 0, 4)
+	return NewNarwhalMempoolMod(attr, p2p.NewP2PMod("")).(*NarwhalMempoolMod), sks
+}
+
+func TestHeaderNeedsAuthorSig(t *testing.T) {
+	nwMod, sks := newTestMempool(t)
+	header := func(author int, signer int) *message.Message {
+		h := &Header{Author: author, Round: 0, Time: testTime}
+		digest := h.Digest()
+		h.Sig = signature.Sign(sks[signer], digest[:])
+		return &message.Message{MsgType: message.MsgDAGHeader, Content: utils.Encode(h)}
+	}
+
+	// node 3 cannot make the others vote for a header in the name of node 1
+	nwMod.handleHeader(header(1, 3))
+	assert.False(t, nwMod.voted[0][1])
+	unsigned := &Header{Author: 2, Round: 0, Time: testTime}
+	nwMod.handleHeader(&message.Message{MsgType: message.MsgDAGHeader, Content: utils.Encode(unsigned)})
+	assert.False(t, nwMod.voted[0][2])
+
+	nwMod.handleHeader(header(1, 1))
+	assert.True(t, nwMod.voted[0][1])
+}
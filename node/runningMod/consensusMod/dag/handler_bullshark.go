@@ -0,0 +1,120 @@
+// This file contains the Bullshark ordering module, it interprets the DAG of the Narwhal mempool without sending any
+// message. The vertices are inserted in causal order, each committed anchor orders its causal history (see bullshark.go),
+// and the txs of the ordered vertices form a block of the BlockChain. A tx batched by several nodes is committed once
+package dag
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"context"
+	"sync"
+	"time"
+)
+
+// implement the RunningMod interface
+var _ runningModInterface.RunningMod = &BullsharkMod{}
+
+type BullsharkMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	orderer      *orderer
+	committedTxs map[string]bool // the hashes of the committed txs
+	orderLock    sync.Mutex
+}
+
+func NewBullsharkMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	bsMod := new(BullsharkMod)
+	bsMod.nodeAttr = attr
+	bsMod.p2pMod = p2p
+
+	bsMod.orderer = newOrderer(config.NodeNum, config.DAGGCDepth)
+	bsMod.committedTxs = make(map[string]bool)
+	return bsMod
+}
+
+// a vertex delivered by the mempool, whose parents have been delivered
+func (bsMod *BullsharkMod) handleVertex(msg *message.Message) {
+	v := VertexContent{}
+	if err := utils.Decode(msg.Content, &v); err != nil || v.Cert == nil {
+		utils.LoggerInstance.Error("Error decoding the vertex")
+		return
+	}
+
+	bsMod.orderLock.Lock()
+	defer bsMod.orderLock.Unlock()
+	for _, a := range bsMod.orderer.insert(v.Cert) {
+		bsMod.commitAnchor(a, v.Pending)
+	}
+}
+
+// call with orderLock held, commit the txs of the vertices ordered by the anchor as a block
+func (bsMod *BullsharkMod) commitAnchor(a *orderedAnchor, pending int) {
+	all := make([]structs.Transaction, 0)
+	txs := make([]structs.Transaction, 0)
+	for _, c := range a.Vertices {
+		for _, tx := range c.Header.Txs() {
+			all = append(all, tx)
+			if !bsMod.committedTxs[string(tx.Hash())] {
+				bsMod.committedTxs[string(tx.Hash())] = true
+				txs = append(txs, tx)
+			}
+		}
+	}
+	utils.LoggerInstance.Info("Commit the anchor of round %d by node %d, ordering %d vertices with %d txs", a.Anchor.Header.Round, a.Anchor.Header.Author, len(a.Vertices), len(txs))
+
+	if len(txs) > 0 {
+		bc := bsMod.nodeAttr.CurChain
+		b := bc.NewBlock(txs)
+		bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+		bc.CommitBlock(b)
+		if bsMod.nodeAttr.Nid == config.ViewNodeId {
+			bsMod.sendReply(b, a.Anchor.Header.Time, pending)
+		}
+	}
+
+	// the mempool removes the committed txs from the TxPool and drops the old rounds
+	if handler, ok := bsMod.p2pMod.LocalHandler(message.MsgDAGCommitted); ok {
+		msg := message.Message{
+			MsgType: message.MsgDAGCommitted,
+			Content: utils.Encode(&CommittedContent{AnchorRound: a.Anchor.Header.Round, Txs: all}),
+		}
+		go handler(&msg)
+	}
+}
+
+// send the committed block back to the client, so that the measure mod can work.
+// The request time is the time the anchor is created, so TCL is the latency of the commit rule
+func (bsMod *BullsharkMod) sendReply(b *structs.Block, anchorTime time.Time, pending int) {
+	req := message.NewRequest(bsMod.nodeAttr.Sid, message.ReqVerifyTxs, utils.Encode(b))
+	req.ReqTime = anchorTime
+	reply := &message.Reply{
+		Req:  req,
+		Time: time.Now(),
+
+		Sid:         bsMod.nodeAttr.Sid,
+		ReqQueueLen: pending,
+	}
+
+	replymsg := message.Message{
+		MsgType: message.MsgReply,
+		Content: utils.Encode(reply),
+	}
+	go bsMod.p2pMod.ConnMananger.Send(config.ClientAddr, replymsg.JsonEncode())
+}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (bsMod *BullsharkMod) RegisterHandlers() {
+	bsMod.p2pMod.RegisterLocalHandler(message.MsgDAGVertex, bsMod.handleVertex)
+}
+
+// the ordering is driven by the vertices delivered by the mempool, nothing to run
+func (bsMod *BullsharkMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+}
@@ -0,0 +1,594 @@
+// This file contains the Narwhal mempool module, it disseminates the txs in a DAG and leaves the ordering to Bullshark.
+// In every round each node broadcasts a header with a batch of random txs from its own TxPool and the certificates of
+// at least 2f+1 nodes in the previous round. A node votes once for each author in a round, after it has all the parents
+// of the header. 2f+1 votes form the certificate of availability, which is a vertex of the DAG. The certificates are
+// delivered to the ordering module locally after all their parents, so every node inserts the vertices in causal order.
+// A node enters the next round with 2f+1 certificates of the round, and waits as Bullshark needs: for the anchor in an
+// even round, and for f+1 votes on the anchor in an odd round, at most config.DAGRoundTimeout
+package dag
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// implement the RunningMod interface
+var _ runningModInterface.RunningMod = &NarwhalMempoolMod{}
+
+const SilentStrategy = "Silent" // a malicious node neither creates headers nor votes, like a crashed node
+
+type NarwhalMempoolMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	// narwhal related
+	node_num      int // number of nodes in the network
+	malicious_num int // max number of malicious nodes, less than a third of the nodes
+	quorum        int // the votes of a certificate, 2f+1
+
+	// mempool related
+	txPool   *structs.TxPool
+	inFlight map[string]bool // the txs in the headers of this node not committed yet, they are not batched again
+	rnd      *rand.Rand
+
+	// round related
+	round      int  // the current round
+	proposed   bool // whether this node creates its header of the current round
+	roundStart time.Time
+	lastHeader time.Time
+
+	// the header of this node waiting for the votes
+	ownHeader    *Header
+	ownTxs       []structs.Transaction // the txs of the own header, as they are in the TxPool
+	ownDigest    [32]byte
+	ownCertified bool
+	votes        map[int]*signature.Signature // nid -> the vote on the own header
+
+	// DAG related
+	certs          map[int]map[int]*Certificate // round -> author -> the delivered certificate
+	delivered      map[[32]byte]*Certificate    // digest -> the delivered certificate
+	voted          map[int]map[int]bool         // round -> the authors voted in the round
+	waitingCerts   map[[32]byte][]*Certificate  // the certificates waiting for the missing parent of the digest
+	waitingHeaders map[[32]byte][]*Header       // the headers waiting for the missing parent of the digest
+	requested      map[[32]byte]bool            // the missing parents requested
+	gcRound        int                          // the rounds below it are dropped
+
+	outbox   *utils.Queue[*Certificate] // the certificates to deliver to the ordering module, in causal order
+	outReady chan struct{}
+	progress chan struct{} // a new certificate is delivered, the node may enter the next round
+	dagLock  sync.Mutex
+}
+
+func NewNarwhalMempoolMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	nwMod := new(NarwhalMempoolMod)
+	nwMod.nodeAttr = attr
+	nwMod.p2pMod = p2p
+
+	nwMod.node_num = config.NodeNum
+	nwMod.malicious_num = (nwMod.node_num - 1) / 3
+	nwMod.quorum = 2*nwMod.malicious_num + 1
+
+	nwMod.txPool = structs.NewTxPool(config.BlockSize)
+	nwMod.inFlight = make(map[string]bool)
+	nwMod.rnd = rand.New(rand.NewSource(time.Now().UnixNano() + int64(attr.Sid*config.NodeNum+attr.Nid)))
+
+	nwMod.votes = make(map[int]*signature.Signature)
+	nwMod.certs = make(map[int]map[int]*Certificate)
+	nwMod.delivered = make(map[[32]byte]*Certificate)
+	nwMod.voted = make(map[int]map[int]bool)
+	nwMod.waitingCerts = make(map[[32]byte][]*Certificate)
+	nwMod.waitingHeaders = make(map[[32]byte][]*Header)
+	nwMod.requested = make(map[[32]byte]bool)
+
+	nwMod.outbox = utils.NewQueue[*Certificate]()
+	nwMod.outReady = make(chan struct{}, 1)
+	nwMod.progress = make(chan struct{}, 1)
+	return nwMod
+}
+
+func (nwMod *NarwhalMempoolMod) isSilent() bool {
+	return config.IsMalicious && config.MaliciousStrategy == SilentStrategy
+}
+
+// receive the txs from the client, every node keeps all the txs and batches a random part of them
+func (nwMod *NarwhalMempoolMod) handleInject(msg *message.Message) {
+	txs := []structs.Transaction{}
+	err := utils.Decode(msg.Content, &txs)
+	if err != nil || len(txs) == 0 {
+		utils.LoggerInstance.Error("error decoding the txs")
+		return
+	}
+
+	utils.LoggerInstance.Debug("Receive %d txs", len(txs))
+	nwMod.txPool.AddTxs(txs)
+}
+
+// the header of another node signed by its author, voted once its parents are delivered
+func (nwMod *NarwhalMempoolMod) handleHeader(msg *message.Message) {
+	h := &Header{}
+	if err := utils.Decode(msg.Content, h); err != nil {
+		utils.LoggerInstance.Error("Error decoding the header")
+		return
+	}
+	if h.Author < 0 || h.Author >= nwMod.node_num || h.Round < 0 {
+		return
+	}
+	digest := h.Digest()
+	if h.Sig == nil || !nwMod.checkSig(h.Author, digest[:], h.Sig) {
+		utils.LoggerInstance.Warn("The signature of the header of node %d in round %d is not valid", h.Author, h.Round)
+		return
+	}
+
+	nwMod.dagLock.Lock()
+	defer nwMod.dagLock.Unlock()
+	nwMod.receiveHeader(h)
+}
+
+// call with dagLock held, vote for the first header of the author in the round
+func (nwMod *NarwhalMempoolMod) receiveHeader(h *Header) {
+	if h.Round < nwMod.gcRound || nwMod.voted[h.Round][h.Author] {
+		return
+	}
+	if h.Round > 0 {
+		authors := make(map[int]bool)
+		for _, parent := range h.Parents {
+			if !nwMod.available(parent, h.Round-1) {
+				nwMod.waitingHeaders[parent] = append(nwMod.waitingHeaders[parent], h)
+				nwMod.requestCert(parent, h.Author)
+				return
+			}
+			if c, ok := nwMod.delivered[parent]; ok {
+				if c.Header.Round != h.Round-1 {
+					utils.LoggerInstance.Warn("The header of node %d in round %d has a parent of round %d", h.Author, h.Round, c.Header.Round)
+					return
+				}
+				authors[c.Header.Author] = true
+			}
+		}
+		if len(authors) < nwMod.quorum && h.Round-1 >= nwMod.gcRound {
+			utils.LoggerInstance.Warn("The header of node %d in round %d has only %d parents", h.Author, h.Round, len(authors))
+			return
+		}
+	}
+
+	if nwMod.voted[h.Round] == nil {
+		nwMod.voted[h.Round] = make(map[int]bool)
+	}
+	nwMod.voted[h.Round][h.Author] = true
+	digest := h.Digest()
+	vote := &VoteContent{
+		Digest: digest,
+		Round:  h.Round,
+		Author: h.Author,
+		Voter:  nwMod.nodeAttr.Nid,
+		Sig:    signature.Sign(nwMod.nodeAttr.SecKey, digest[:]),
+	}
+	nwMod.sendTo(h.Author, message.MsgDAGVote, vote)
+}
+
+// collect the votes on the own header, 2f+1 of them form the certificate
+func (nwMod *NarwhalMempoolMod) handleVote(msg *message.Message) {
+	v := VoteContent{}
+	if err := utils.Decode(msg.Content, &v); err != nil {
+		utils.LoggerInstance.Error("Error decoding the vote")
+		return
+	}
+	if v.Voter < 0 || v.Voter >= nwMod.node_num || !nwMod.checkSig(v.Voter, v.Digest[:], v.Sig) {
+		utils.LoggerInstance.Warn("The signature of the vote from node %d is not valid", v.Voter)
+		return
+	}
+
+	nwMod.dagLock.Lock()
+	defer nwMod.dagLock.Unlock()
+
+	if nwMod.ownHeader == nil || v.Digest != nwMod.ownDigest {
+		return
+	}
+	nwMod.votes[v.Voter] = v.Sig
+	if len(nwMod.votes) != nwMod.quorum {
+		return
+	}
+
+	signers := make([]int, 0, len(nwMod.votes))
+	sigs := make([]*signature.Signature, 0, len(nwMod.votes))
+	for nid, sig := range nwMod.votes {
+		signers = append(signers, nid)
+		sigs = append(sigs, sig)
+	}
+	aggSig, err := signature.AggregateSignatures(sigs)
+	if err != nil {
+		utils.LoggerInstance.Error("Error aggregating the signatures, err: %v", err)
+		return
+	}
+	cert := &Certificate{Header: *nwMod.ownHeader, Signers: signers, Sig: aggSig}
+	nwMod.ownCertified = true
+	utils.LoggerInstance.Debug("The header of round %d is certified", cert.Header.Round)
+
+	msg = &message.Message{MsgType: message.MsgDAGCertificate, Content: utils.Encode(cert)}
+	nwMod.p2pMod.ConnMananger.Broadcast(nwMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[nwMod.nodeAttr.Sid], nwMod.nodeAttr.Ipaddr), msg.JsonEncode())
+	nwMod.deliver(cert)
+}
+
+// the certificate of another node
+func (nwMod *NarwhalMempoolMod) handleCertificate(msg *message.Message) {
+	c := &Certificate{}
+	if err := utils.Decode(msg.Content, c); err != nil {
+		utils.LoggerInstance.Error("Error decoding the certificate")
+		return
+	}
+	digest := c.Header.Digest()
+	if c.Header.Author < 0 || c.Header.Author >= nwMod.node_num || !nwMod.checkAggregate(c.Signers, nwMod.quorum, digest[:], c.Sig) {
+		utils.LoggerInstance.Warn("The certificate of node %d in round %d is not valid", c.Header.Author, c.Header.Round)
+		return
+	}
+
+	nwMod.dagLock.Lock()
+	defer nwMod.dagLock.Unlock()
+	nwMod.deliver(c)
+}
+
+// send the certificate of the digest back to the requester
+func (nwMod *NarwhalMempoolMod) handleCertRequest(msg *message.Message) {
+	req := CertRequest{}
+	if err := utils.Decode(msg.Content, &req); err != nil || req.Requester < 0 || req.Requester >= nwMod.node_num {
+		utils.LoggerInstance.Error("Error decoding the certificate request")
+		return
+	}
+
+	nwMod.dagLock.Lock()
+	c, ok := nwMod.delivered[req.Digest]
+	nwMod.dagLock.Unlock()
+	if ok {
+		nwMod.sendTo(req.Requester, message.MsgDAGCertificate, c)
+	}
+}
+
+// the ordering module commits the txs, they are removed from the TxPool and the old rounds are dropped
+func (nwMod *NarwhalMempoolMod) handleCommitted(msg *message.Message) {
+	committed := CommittedContent{}
+	if err := utils.Decode(msg.Content, &committed); err != nil {
+		utils.LoggerInstance.Error("Error decoding the committed txs")
+		return
+	}
+
+	nwMod.txPool.RemoveTxs(committed.Txs)
+	nwMod.dagLock.Lock()
+	defer nwMod.dagLock.Unlock()
+	for _, tx := range committed.Txs {
+		delete(nwMod.inFlight, string(tx.Hash()))
+	}
+	nwMod.gc(committed.AnchorRound - config.DAGGCDepth)
+}
+
+// call with dagLock held, whether the parent of the round is delivered or dropped by the garbage collection
+func (nwMod *NarwhalMempoolMod) available(digest [32]byte, round int) bool {
+	_, ok := nwMod.delivered[digest]
+	return ok || round < nwMod.gcRound
+}
+
+// call with dagLock held, deliver the certificate after its parents, then the certificates and headers waiting for it
+func (nwMod *NarwhalMempoolMod) deliver(c *Certificate) {
+	r, author := c.Header.Round, c.Header.Author
+	if r < nwMod.gcRound {
+		return
+	}
+	if _, ok := nwMod.certs[r][author]; ok {
+		return
+	}
+	if r > 0 {
+		for _, parent := range c.Header.Parents {
+			if !nwMod.available(parent, r-1) {
+				nwMod.waitingCerts[parent] = append(nwMod.waitingCerts[parent], c)
+				nwMod.requestCert(parent, author)
+				return
+			}
+		}
+	}
+
+	digest := c.Header.Digest()
+	if nwMod.certs[r] == nil {
+		nwMod.certs[r] = make(map[int]*Certificate)
+	}
+	nwMod.certs[r][author] = c
+	nwMod.delivered[digest] = c
+	nwMod.outbox.Enqueue(c)
+	nwMod.signal(nwMod.outReady)
+	nwMod.signal(nwMod.progress)
+
+	certs, headers := nwMod.waitingCerts[digest], nwMod.waitingHeaders[digest]
+	delete(nwMod.waitingCerts, digest)
+	delete(nwMod.waitingHeaders, digest)
+	delete(nwMod.requested, digest)
+	for _, w := range certs {
+		nwMod.deliver(w)
+	}
+	for _, h := range headers {
+		nwMod.receiveHeader(h)
+	}
+}
+
+// call with dagLock held, request the missing parent from the author referencing it once
+func (nwMod *NarwhalMempoolMod) requestCert(digest [32]byte, from int) {
+	if nwMod.requested[digest] || from == nwMod.nodeAttr.Nid {
+		return
+	}
+	nwMod.requested[digest] = true
+	nwMod.sendTo(from, message.MsgCertRequest, &CertRequest{Digest: digest, Requester: nwMod.nodeAttr.Nid})
+}
+
+// call with dagLock held, drop the rounds below the given round
+func (nwMod *NarwhalMempoolMod) gc(round int) {
+	for ; nwMod.gcRound < round; nwMod.gcRound++ {
+		for _, c := range nwMod.certs[nwMod.gcRound] {
+			delete(nwMod.delivered, c.Header.Digest())
+		}
+		delete(nwMod.certs, nwMod.gcRound)
+		delete(nwMod.voted, nwMod.gcRound)
+	}
+	// the certificates and headers waiting for a dropped parent do not wait any more
+	for digest, certs := range nwMod.waitingCerts {
+		if len(certs) > 0 && certs[0].Header.Round <= nwMod.gcRound {
+			delete(nwMod.waitingCerts, digest)
+			for _, c := range certs {
+				nwMod.deliver(c)
+			}
+		}
+	}
+	for digest, headers := range nwMod.waitingHeaders {
+		if len(headers) > 0 && headers[0].Round <= nwMod.gcRound {
+			delete(nwMod.waitingHeaders, digest)
+			for _, h := range headers {
+				nwMod.receiveHeader(h)
+			}
+		}
+	}
+}
+
+// call with dagLock held, whether the node can leave the round, see the waiting rule of Bullshark at the file head
+func (nwMod *NarwhalMempoolMod) canLeave(round int) bool {
+	if len(nwMod.certs[round]) < nwMod.quorum {
+		return false
+	}
+	if time.Since(nwMod.roundStart) >= time.Duration(config.DAGRoundTimeout)*time.Millisecond {
+		return true
+	}
+	if round%2 == 0 {
+		_, ok := nwMod.certs[round][Leader(round, nwMod.node_num)]
+		return ok
+	}
+	anchor, ok := nwMod.certs[round-1][Leader(round-1, nwMod.node_num)]
+	if !ok {
+		return true
+	}
+	digest := anchor.Header.Digest()
+	votes := 0
+	for _, c := range nwMod.certs[round] {
+		for _, parent := range c.Header.Parents {
+			if parent == digest {
+				votes++
+				break
+			}
+		}
+	}
+	return votes >= nwMod.malicious_num+1
+}
+
+// call with dagLock held, catch up to the highest round with 2f+1 certificates, create the header and enter the next round
+func (nwMod *NarwhalMempoolMod) tryAdvance() {
+	for r := range nwMod.certs {
+		if r > nwMod.round && len(nwMod.certs[r]) >= nwMod.quorum {
+			utils.LoggerInstance.Info("Catch up from round %d to round %d", nwMod.round, r)
+			nwMod.enterRound(r)
+		}
+	}
+	if !nwMod.proposed && (nwMod.round == 0 || len(nwMod.certs[nwMod.round-1]) >= nwMod.quorum) {
+		nwMod.tryPropose()
+	}
+	if nwMod.proposed && nwMod.canLeave(nwMod.round) {
+		nwMod.enterRound(nwMod.round + 1)
+	}
+}
+
+// call with dagLock held
+func (nwMod *NarwhalMempoolMod) enterRound(round int) {
+	nwMod.round = round
+	nwMod.proposed = false
+	nwMod.roundStart = time.Now()
+	utils.LoggerInstance.Debug("Enter round %d", round)
+}
+
+// call with dagLock held, create the header with B/N random txs of the first B pending txs not batched yet,
+// an empty header is created only after config.DAGMaxHeaderDelay
+func (nwMod *NarwhalMempoolMod) tryPropose() {
+	candidates := make([]structs.Transaction, 0)
+	for _, tx := range nwMod.txPool.PeekTxs(config.BlockSize + len(nwMod.inFlight)) {
+		if len(candidates) >= config.BlockSize {
+			break
+		}
+		if !nwMod.inFlight[string(tx.Hash())] {
+			candidates = append(candidates, tx)
+		}
+	}
+	if len(candidates) == 0 && time.Since(nwMod.lastHeader) < time.Duration(config.DAGMaxHeaderDelay)*time.Millisecond {
+		return
+	}
+	size := max(config.BlockSize/nwMod.node_num, 1)
+	nwMod.rnd.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > size {
+		candidates = candidates[:size]
+	}
+
+	parents := make([][32]byte, 0)
+	if nwMod.round > 0 {
+		authors := make([]int, 0, len(nwMod.certs[nwMod.round-1]))
+		for author := range nwMod.certs[nwMod.round-1] {
+			authors = append(authors, author)
+		}
+		sort.Ints(authors)
+		for _, author := range authors {
+			parents = append(parents, nwMod.certs[nwMod.round-1][author].Header.Digest())
+		}
+	}
+
+	// the txs of the last header are batched again if it is not certified
+	if nwMod.ownHeader != nil && !nwMod.ownCertified {
+		for _, tx := range nwMod.ownTxs {
+			delete(nwMod.inFlight, string(tx.Hash()))
+		}
+	}
+	for _, tx := range candidates {
+		nwMod.inFlight[string(tx.Hash())] = true
+	}
+
+	h := &Header{Author: nwMod.nodeAttr.Nid, Round: nwMod.round, Parents: parents, Time: time.Now()}
+	if len(candidates) > 0 {
+		h.Batch = utils.Encode(candidates)
+	}
+	nwMod.ownHeader = h
+	nwMod.ownTxs = candidates
+	nwMod.ownDigest = h.Digest()
+	h.Sig = signature.Sign(nwMod.nodeAttr.SecKey, nwMod.ownDigest[:])
+	nwMod.ownCertified = false
+	nwMod.votes = make(map[int]*signature.Signature)
+	nwMod.proposed = true
+	nwMod.lastHeader = h.Time
+	utils.LoggerInstance.Debug("Create the header of round %d with %d txs and %d parents", h.Round, len(candidates), len(h.Parents))
+
+	msg := message.Message{MsgType: message.MsgDAGHeader, Content: utils.Encode(h)}
+	nwMod.p2pMod.ConnMananger.Broadcast(nwMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[nwMod.nodeAttr.Sid], nwMod.nodeAttr.Ipaddr), msg.JsonEncode())
+	nwMod.receiveHeader(h)
+}
+
+// send the message to the node, the message to this node is handled in a new goroutine
+func (nwMod *NarwhalMempoolMod) sendTo(nid int, msgType message.MessageType, content interface{}) {
+	msg := message.Message{
+		MsgType: msgType,
+		Content: utils.Encode(content),
+	}
+	if nid == nwMod.nodeAttr.Nid {
+		if handler, ok := nwMod.p2pMod.Handler(msgType); ok {
+			go handler(&msg)
+		}
+		return
+	}
+	go nwMod.p2pMod.ConnMananger.Send(config.IPMap[nwMod.nodeAttr.Sid][nid], msg.JsonEncode())
+}
+
+func (nwMod *NarwhalMempoolMod) signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// check the signature of a node of the shard, the unknown nodes and keys are rejected
+func (nwMod *NarwhalMempoolMod) checkSig(nid int, msg []byte, sig *signature.Signature) bool {
+	return nwMod.nodeAttr.VerifySig(nwMod.nodeAttr.Sid, nid, msg, sig)
+}
+
+// check the aggregate signature of at least `least` distinct signers
+func (nwMod *NarwhalMempoolMod) checkAggregate(signers []int, least int, msg []byte, sig *signature.Signature) bool {
+	return len(signers) >= least && nwMod.nodeAttr.VerifyAggregatedSig(nwMod.nodeAttr.Sid, signers, msg, sig) == nil
+}
+
+// deliver the certificates to the ordering module one by one, in the order they are delivered in the mempool
+func (nwMod *NarwhalMempoolMod) deliverLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-nwMod.outReady:
+		}
+		handler, ok := nwMod.p2pMod.LocalHandler(message.MsgDAGVertex)
+		if !ok {
+			utils.LoggerInstance.Error("No ordering module handles the vertices of the DAG")
+			continue
+		}
+		for !nwMod.outbox.IsEmpty() {
+			c, err := nwMod.outbox.Dequeue()
+			if err != nil {
+				break
+			}
+			msg := message.Message{
+				MsgType: message.MsgDAGVertex,
+				Content: utils.Encode(&VertexContent{Cert: c, Pending: nwMod.txPool.Size()}),
+			}
+			handler(&msg)
+		}
+	}
+}
+
+// a silent node drops all the messages
+func (nwMod *NarwhalMempoolMod) handleSilent(msg *message.Message) {}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (nwMod *NarwhalMempoolMod) RegisterHandlers() {
+	if nwMod.isSilent() {
+		for _, msgType := range []message.MessageType{message.MsgInject, message.MsgDAGHeader, message.MsgDAGVote, message.MsgDAGCertificate, message.MsgCertRequest} {
+			nwMod.p2pMod.RegisterHandler(msgType, nwMod.handleSilent)
+		}
+		return
+	}
+	nwMod.p2pMod.RegisterHandler(message.MsgInject, nwMod.handleInject)
+	nwMod.p2pMod.RegisterHandler(message.MsgDAGHeader, nwMod.handleHeader)
+	nwMod.p2pMod.RegisterHandler(message.MsgDAGVote, nwMod.handleVote)
+	nwMod.p2pMod.RegisterHandler(message.MsgDAGCertificate, nwMod.handleCertificate)
+	nwMod.p2pMod.RegisterHandler(message.MsgCertRequest, nwMod.handleCertRequest)
+	nwMod.p2pMod.RegisterLocalHandler(message.MsgDAGCommitted, nwMod.handleCommitted)
+}
+
+// Run drives the rounds of the node, it checks whether to create the header or enter the next round
+// when a certificate is delivered, and at least every config.DAGMaxHeaderDelay/4
+func (nwMod *NarwhalMempoolMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if nwMod.isSilent() {
+		utils.LoggerInstance.Info("This node is silent, do not run the Narwhal mempool")
+		return
+	}
+
+	// the messages sent before the peers are listening are lost
+	if !nwMod.nodeAttr.AwaitPubKeys(ctx) {
+		return
+	}
+	if !p2p.WaitForAllIPsReady(20 * time.Second) {
+		utils.LoggerInstance.Error("Wait for all IPs ready timeout")
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(config.StartTimeWait) * time.Millisecond):
+	}
+	go nwMod.deliverLoop(ctx)
+
+	utils.LoggerInstance.Info("Start the Narwhal mempool Mod")
+	ticker := time.NewTicker(time.Duration(max(config.DAGMaxHeaderDelay/4, 1)) * time.Millisecond)
+	defer ticker.Stop()
+	nwMod.dagLock.Lock()
+	nwMod.enterRound(0)
+	nwMod.dagLock.Unlock()
+	for {
+		nwMod.dagLock.Lock()
+		nwMod.tryAdvance()
+		nwMod.dagLock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			utils.LoggerInstance.Info("Stop the Narwhal mempool Mod, the last round is %d", nwMod.round)
+			return
+		case <-nwMod.progress:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package dag
+
+import (
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+	"time"
+)
+
+// the header of a node in a round, carrying the batch of the node and the certificates of the previous round
+type Header struct {
+	Author  int
+	Round   int
+	Batch   []byte               // the encoded txs from the TxPool of the author, the digest covers the bytes as they are sent
+	Parents [][32]byte           // the digests of at least 2f+1 certified headers of the previous round, none in round 0
+	Time    time.Time            // the time the header is created
+	Sig     *signature.Signature // the signature of the author on the digest, not covered by the digest
+}
+
+// the vote of a node on a header, sent to the author only
+type VoteContent struct {
+	Digest [32]byte // the digest of the header
+	Round  int
+	Author int
+	Voter  int
+	Sig    *signature.Signature
+}
+
+// the certificate of availability: 2f+1 nodes have stored the header and its batch.
+// A certificate is a vertex of the DAG, its edges are the parents of the header
+type Certificate struct {
+	Header  Header
+	Signers []int
+	Sig     *signature.Signature // the aggregate signature of the votes
+}
+
+// the digest of the header, the batch is included by its hash.
+// An empty slice is decoded as nil by gob, so the parents are copied to have the same encoding
+func (h *Header) Digest() [32]byte {
+	parents := make([][32]byte, len(h.Parents))
+	copy(parents, h.Parents)
+	return sha256.Sum256(utils.CanonicalEncode(struct {
+		Author    int
+		Round     int
+		BatchHash [32]byte
+		Parents   [][32]byte
+		Time      int64
+	}{h.Author, h.Round, sha256.Sum256(h.Batch), parents, h.Time.UnixNano()}))
+}
+
+// the txs of the batch, none if the batch can not be decoded
+func (h *Header) Txs() []structs.Transaction {
+	txs := []structs.Transaction{}
+	if len(h.Batch) == 0 {
+		return txs
+	}
+	if err := utils.Decode(h.Batch, &txs); err != nil {
+		utils.LoggerInstance.Error("Error decoding the batch of node %d in round %d", h.Author, h.Round)
+		return []structs.Transaction{}
+	}
+	return txs
+}
+
+// the leader of the even round, its certificate is the anchor of the round
+func Leader(round int, nodeNum int) int {
+	return (round / 2) % nodeNum
+}
+
+// the content of the local MsgDAGVertex message
+type VertexContent struct {
+	Cert    *Certificate
+	Pending int // the number of txs pending in the mempool, reported to the client
+}
+
+// the content of the local MsgDAGCommitted message
+type CommittedContent struct {
+	AnchorRound int                   // the round of the committed anchor, the mempool drops the rounds far below it
+	Txs         []structs.Transaction // the txs of the ordered vertices, including the duplicated ones
+}
+
+// a node requests a missing parent from the author of the certificate referencing it
+type CertRequest struct {
+	Digest    [32]byte
+	Requester int
+}
@@ -1,4 +1,4 @@
-// the Dolev-Strong protocol module, only consider 1 round of protocol
+// the Dolev-Strong protocol module, the instances are tagged by the instance id and may overlap
 package ds
 
 import (
@@ -10,6 +10,7 @@ import (
 	"BlockChainSimulator/signature"
 	"BlockChainSimulator/utils"
 	"context"
+	"log"
 	"sync"
 	"time"
 )
@@ -19,17 +20,25 @@ type DSCosensusMod struct {
 	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
 	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
 	// consensus related
-	view int // the nid of current view number
-	// malicious     bool // whether this node is malicious, has not implemented this feature
-	startTime *utils.AtomicValue[time.Time]
+	view      int       // the nid of current view number
+	adversary Adversary // how the malicious node sends the messages, nil for the honest node
 
-	// local sets
+	// the instances may overlap, each instance has its own state, which is removed when the instance finishes
+	instances    map[int]*DSInstance // the running instances, key is the instance id
+	log          map[int]string      // the committed values of the finished instances, key is the instance id
+	instanceLock sync.Mutex
+}
+
+// the state of an instance of the protocol
+type DSInstance struct {
+	StartTime   time.Time
 	ExtrSet     *utils.Set[string] // 'extracted set' in the paper
-	CommitValue string             // the value to commit
+	CommitValue string             // the value to commit, empty before the commit time
 }
 
 // the content with a list of signatures, not aggregate signature, used in DS protocol
 type SigListContent struct {
+	Instance int
 	Req      message.Request
 	SigList  []*signature.Signature
 	NodeList []int // indicate the nodes that have signed the request
@@ -40,66 +49,142 @@ func NewDSCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterf
 	dsMod.nodeAttr = attr
 	dsMod.p2pMod = p2p
 
-	dsMod.view = 0 // set 0 as the default view
+	dsMod.view = config.ViewNodeId // every shard runs its own protocol, led by its view node
 
-	dsMod.startTime = utils.NewAtomicValue(time.Now())
+	dsMod.instances = make(map[int]*DSInstance)
+	dsMod.log = make(map[int]string)
 
-	dsMod.ExtrSet = utils.NewSet[string]()
+	if config.IsMalicious {
+		adversary, err := NewAdversary(config.MaliciousStrategy, attr, p2p)
+		if err != nil {
+			utils.LoggerInstance.Error("Error creating the adversary: %v", err)
+			log.Panicf("Error creating the adversary: %v", err)
+		}
+		dsMod.adversary = adversary
+	}
 
 	return dsMod
 }
 
+// the lifetime of an instance, the commit value is moved to the log when the instance finishes
+func InstanceLifetime() time.Duration {
+	return time.Duration(config.TickInterval*int64(config.NodeNum+7)) * time.Millisecond
+}
+
+// get the running instance, nil if the instance is not started or has finished
+func (dsMod *DSCosensusMod) GetInstance(id int) *DSInstance {
+	dsMod.instanceLock.Lock()
+	defer dsMod.instanceLock.Unlock()
+	return dsMod.instances[id]
+}
+
+// get the commit value of the instance, from the log if the instance has finished
+func (dsMod *DSCosensusMod) GetCommitValue(id int) string {
+	dsMod.instanceLock.Lock()
+	defer dsMod.instanceLock.Unlock()
+	if instance, exists := dsMod.instances[id]; exists {
+		return instance.CommitValue
+	}
+	return dsMod.log[id]
+}
+
+// the committed values of the finished instances, in the order of the instance id, stops at the first missing instance
+func (dsMod *DSCosensusMod) GetLog() []string {
+	dsMod.instanceLock.Lock()
+	defer dsMod.instanceLock.Unlock()
+	result := make([]string, 0, len(dsMod.log))
+	for id := 0; ; id++ {
+		value, exists := dsMod.log[id]
+		if !exists {
+			return result
+		}
+		result = append(result, value)
+	}
+}
+
+// move the commit value to the log and discard the state of the instance
+func (dsMod *DSCosensusMod) finishInstance(id int) {
+	dsMod.instanceLock.Lock()
+	defer dsMod.instanceLock.Unlock()
+	if instance, exists := dsMod.instances[id]; exists {
+		dsMod.log[id] = instance.CommitValue
+		delete(dsMod.instances, id)
+	}
+}
+
 // Initially, every node i's extracted set extri=0.
 func (dsMod *DSCosensusMod) HandleInitMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received an init message")
 
-	startTime := time.Time{}
-	err := utils.Decode(msg.Content, &startTime)
+	initContent := message.InitContent{}
+	err := utils.Decode(msg.Content, &initContent)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the init message, err: %v", err)
 		return
 	}
 
-	// set the start time of the protocol
-	dsMod.startTime.Set(startTime, nil)
-	utils.LoggerInstance.Info("Set the start time of the protocol to %v", dsMod.startTime.Get())
-
-	// clear the local sets
-	dsMod.ExtrSet.Clear()
+	dsMod.instanceLock.Lock()
+	_, running := dsMod.instances[initContent.Instance]
+	_, finished := dsMod.log[initContent.Instance]
+	if running || finished {
+		dsMod.instanceLock.Unlock()
+		utils.LoggerInstance.Warn("The instance %d is already started", initContent.Instance)
+		return
+	}
+	instance := &DSInstance{
+		StartTime: initContent.StartTime,
+		ExtrSet:   utils.NewSet[string](),
+	}
+	dsMod.instances[initContent.Instance] = instance
+	dsMod.instanceLock.Unlock()
+	utils.LoggerInstance.Info("Set the start time of the instance %d to %v", initContent.Instance, instance.StartTime)
 
 	// wait until f + 1 round to commit
 	maliciousNodes := int64(config.MaliciousRatio * float64(config.NodeNum))
 	utils.LoggerInstance.Debug("The number of malicious nodes is %d(%.2f)", maliciousNodes, config.MaliciousRatio)
 	delayDuration := (maliciousNodes + 1) * config.TickInterval * int64(time.Millisecond)
-	commitTime := dsMod.startTime.Get().Add(time.Duration(delayDuration))
+	commitTime := instance.StartTime.Add(time.Duration(delayDuration))
 	commitTimer := time.NewTimer(time.Until(commitTime))
 	utils.LoggerInstance.Debug("Set the commit time to %v", commitTime)
 	go func() {
 		<-commitTimer.C
-		utils.LoggerInstance.Info("Commit the request")
+		utils.LoggerInstance.Info("Commit the request of instance %d", initContent.Instance)
 
 		// other commit operations
 
-		for _, item := range dsMod.ExtrSet.GetItems() {
-			utils.LoggerInstance.Info("The value in ExtrSet at %p are: %v", dsMod.ExtrSet, item)
+		for _, item := range instance.ExtrSet.GetItems() {
+			utils.LoggerInstance.Info("The value in ExtrSet at %p are: %v", instance.ExtrSet, item)
 		}
 
-		if dsMod.ExtrSet.Size() == 1 {
-			utils.LoggerInstance.Info("The value to commit is: %v", dsMod.ExtrSet.GetItems()[0])
-			dsMod.CommitValue = dsMod.ExtrSet.GetItems()[0]
+		dsMod.instanceLock.Lock()
+		if instance.ExtrSet.Size() == 1 {
+			instance.CommitValue = instance.ExtrSet.GetItems()[0]
+			utils.LoggerInstance.Info("The value to commit is: %v", instance.CommitValue)
 		} else {
-			utils.LoggerInstance.Warn("The ExtrSet size is %d, The value to commit is 0", dsMod.ExtrSet.Size())
-			dsMod.CommitValue = "0"
+			utils.LoggerInstance.Warn("The ExtrSet size is %d, The value to commit is 0", instance.ExtrSet.Size())
+			instance.CommitValue = "0"
 		}
+		dsMod.instanceLock.Unlock()
 	}()
 
+	// discard the state of the instance when it finishes
+	time.AfterFunc(time.Until(instance.StartTime.Add(InstanceLifetime())), func() {
+		dsMod.finishInstance(initContent.Instance)
+		utils.LoggerInstance.Info("The instance %d finishes, the log is %v", initContent.Instance, dsMod.GetLog())
+	})
+
 	// if view node, set consensus done timer
 	if dsMod.nodeAttr.Nid == dsMod.view {
-		consensusDoneTimer := time.NewTimer(time.Duration(config.TickInterval*int64(config.NodeNum+7)) * time.Millisecond)
+		consensusDoneTimer := time.NewTimer(InstanceLifetime())
 		go func() {
 			<-consensusDoneTimer.C
-			utils.LoggerInstance.Info("The consensus is done, start the next round")
-			dsMod.p2pMod.MsgHandlerMap[message.MsgConsensusDone](nil)
+			utils.LoggerInstance.Info("The consensus of instance %d is done, start the next round", initContent.Instance)
+			if handler, ok := dsMod.p2pMod.Handler(message.MsgConsensusDone); ok {
+				handler(&message.Message{
+					MsgType: message.MsgConsensusDone,
+					Content: utils.Encode(initContent.Instance),
+				})
+			}
 		}()
 	}
 }
@@ -108,42 +193,47 @@ func (dsMod *DSCosensusMod) HandleInitMsg(msg *message.Message) {
 func (dsMod *DSCosensusMod) HandleProposeMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received a propose message")
 
-	req := message.Request{}
-	err := utils.Decode(msg.Content, &req)
+	instanceReq := message.InstanceRequest{}
+	err := utils.Decode(msg.Content, &instanceReq)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the propose message")
 		return
 	}
+	req := instanceReq.Req
+
+	instance := dsMod.GetInstance(instanceReq.Instance)
+	if instance == nil {
+		utils.LoggerInstance.Warn("The instance %d is not running, ignore the propose message", instanceReq.Instance)
+		return
+	}
 
-	dsMod.ExtrSet.Add(string(req.Content))
+	content := dsMod.proposeContent(instanceReq.Instance, req.Content)
+	if !VerifySig(dsMod.nodeAttr, dsMod.view, content, req.Sig) {
+		utils.LoggerInstance.Warn("The signature of the propose message is not valid")
+		return
+	}
+
+	instance.ExtrSet.Add(string(req.Content))
 
 	// view node does not need to forward the message
 	if dsMod.nodeAttr.Nid != dsMod.view {
-		// wait until round==1 and broadcast Forward message
-		for {
-			if time.Since(dsMod.startTime.Get()).Milliseconds()/config.TickInterval < int64(1) {
-				break
-			}
-			time.Sleep(time.Millisecond * 100)
-		}
-
 		sigListContent := SigListContent{
+			Instance: instanceReq.Instance,
 			Req:      req,
-			SigList:  []*signature.Signature{req.Sig, signature.Sign(dsMod.nodeAttr.SecKey, req.Content)},
+			SigList:  []*signature.Signature{req.Sig, signature.Sign(dsMod.nodeAttr.SecKey, content)},
 			NodeList: []int{dsMod.view, dsMod.nodeAttr.Nid},
 		}
 		forwardMsg := message.Message{
 			MsgType: message.MsgForward,
 			Content: utils.Encode(sigListContent),
 		}
-		dsMod.p2pMod.ConnMananger.Broadcast(dsMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], dsMod.nodeAttr.Ipaddr), forwardMsg.JsonEncode())
+		dsMod.broadcast(&forwardMsg, instance.StartTime)
 		return
 	}
 }
 
 // check if the 'b' is already in the ExtrSet
-// check if the length of the signature list is equal to the round number
-// check if the signature list is correct
+// check if the signature list is correct and long enough for the round
 // forward the message to everyone
 func (dsMod *DSCosensusMod) HandleForwardMsg(msg *message.Message) {
 	utils.LoggerInstance.Debug("Received a forward message")
@@ -155,38 +245,39 @@ func (dsMod *DSCosensusMod) HandleForwardMsg(msg *message.Message) {
 		return
 	}
 
+	instance := dsMod.GetInstance(sigListContent.Instance)
+	if instance == nil {
+		utils.LoggerInstance.Warn("The instance %d is not running, ignore the forward message", sigListContent.Instance)
+		return
+	}
+
 	// get the round of the protocol
-	round := int(time.Since(dsMod.startTime.Get()).Milliseconds() / config.TickInterval)
+	round := int(time.Since(instance.StartTime).Milliseconds() / config.TickInterval)
 	sigLen := len(sigListContent.SigList)
 
 	// If ~b not belongs to ExtrSet: add ~b to ExtrSet and send fowared to everyone
-	if dsMod.ExtrSet.Contains(string(sigListContent.Req.Content)) {
+	if instance.ExtrSet.Contains(string(sigListContent.Req.Content)) {
 		utils.LoggerInstance.Info("The request is already in the ExtrSet")
 		return
 	} else {
-		if !dsMod.checkSigList(sigListContent.SigList) {
+		if !dsMod.checkSigList(&sigListContent) {
 			utils.LoggerInstance.Warn("The signature list is not correct")
 			return
 		}
 
-		if sigLen != round && sigLen != round+1 {
-			utils.LoggerInstance.Warn("The length of the signature list %d is not equal to the round number %d", sigLen, round)
-			// return
+		// the view node signs in round 0 and every round adds at least one signature,
+		// so a chain received in round r carries at least r+1 signatures
+		if sigLen < round+1 {
+			utils.LoggerInstance.Warn("The signature list of length %d is too short for the round %d", sigLen, round)
+			return
 		}
 
 		// add the request to the local set C
 		utils.LoggerInstance.Info("Add the request to the ExtrSet")
-		dsMod.ExtrSet.Add(string(sigListContent.Req.Content))
+		instance.ExtrSet.Add(string(sigListContent.Req.Content))
 
-		// wait until round==sigLen and broadcast Forward message
-		for {
-			if time.Since(dsMod.startTime.Get()).Milliseconds()/config.TickInterval < int64(sigLen) {
-				break
-			}
-			time.Sleep(time.Millisecond * 100)
-		}
-
-		sigListContent.SigList = append(sigListContent.SigList, signature.Sign(dsMod.nodeAttr.SecKey, sigListContent.Req.Content))
+		// forward right away, the chain with the own signature is long enough for the next round
+		sigListContent.SigList = append(sigListContent.SigList, signature.Sign(dsMod.nodeAttr.SecKey, dsMod.proposeContent(sigListContent.Instance, sigListContent.Req.Content)))
 		sigListContent.NodeList = append(sigListContent.NodeList, dsMod.nodeAttr.Nid)
 
 		forwardMsg := message.Message{
@@ -194,40 +285,53 @@ func (dsMod *DSCosensusMod) HandleForwardMsg(msg *message.Message) {
 			Content: utils.Encode(sigListContent),
 		}
 
-		dsMod.p2pMod.ConnMananger.Broadcast(dsMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], dsMod.nodeAttr.Ipaddr), forwardMsg.JsonEncode())
+		dsMod.broadcast(&forwardMsg, instance.StartTime)
 		utils.LoggerInstance.Info("Broadcast the forward message")
 	}
 }
 
 func (dsMod *DSCosensusMod) handleQueryMsg(msg *message.Message) {
-	ip := ""
-	err := utils.Decode(msg.Content, &ip)
+	query := message.QueryContent{}
+	err := utils.Decode(msg.Content, &query)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the query message")
 		return
 	}
 
-	// send the commit value to the query node
+	// send the commit value of the instance to the query node
 	replyMsg := message.Message{
 		MsgType: message.MsgReplyQuery,
-		Content: utils.Encode(dsMod.CommitValue),
+		Content: utils.Encode(message.QueryReply{
+			Sid:      dsMod.nodeAttr.Sid,
+			Instance: query.Instance,
+			Value:    dsMod.GetCommitValue(query.Instance),
+		}),
 	}
 
-	dsMod.p2pMod.ConnMananger.Send(ip, replyMsg.JsonEncode())
+	// the reply of the malicious node goes through its adversary as well, the silent node does not answer
+	if dsMod.adversary != nil {
+		dsMod.adversary.Send(&replyMsg, []string{query.Addr}, time.Now())
+		return
+	}
+	dsMod.p2pMod.ConnMananger.Send(query.Addr, replyMsg.JsonEncode())
 }
 
+// the malicious node registers the same handlers, its adversary decides how the messages are sent
 func (dsMod *DSCosensusMod) RegisterHandlers() {
-	if config.IsMalicious && dsMod.nodeAttr.Nid != config.ViewNodeId { // malicious view node still needs to register the handlers, but sends wrong propose
-		dsMod.p2pMod.RegisterHandler(message.MsgInit, dsMod.HandleInitMsg_m)
-		dsMod.p2pMod.RegisterHandler(message.MsgPropose, dsMod.HandleProposeMsg_m)
-		dsMod.p2pMod.RegisterHandler(message.MsgForward, dsMod.HandleForwardMsg_m)
-		dsMod.p2pMod.RegisterHandler(message.MsgQuery, dsMod.handleQueryMsg_m)
-	} else {
-		dsMod.p2pMod.RegisterHandler(message.MsgInit, dsMod.HandleInitMsg)
-		dsMod.p2pMod.RegisterHandler(message.MsgPropose, dsMod.HandleProposeMsg)
-		dsMod.p2pMod.RegisterHandler(message.MsgForward, dsMod.HandleForwardMsg)
-		dsMod.p2pMod.RegisterHandler(message.MsgQuery, dsMod.handleQueryMsg)
+	dsMod.p2pMod.RegisterHandler(message.MsgInit, dsMod.HandleInitMsg)
+	dsMod.p2pMod.RegisterHandler(message.MsgPropose, dsMod.HandleProposeMsg)
+	dsMod.p2pMod.RegisterHandler(message.MsgForward, dsMod.HandleForwardMsg)
+	dsMod.p2pMod.RegisterHandler(message.MsgQuery, dsMod.handleQueryMsg)
+}
+
+// broadcast the message of the instance started at startTime, the message of the malicious node is sent by its adversary
+func (dsMod *DSCosensusMod) broadcast(msg *message.Message, startTime time.Time) {
+	receivers := utils.GetNeighbours(config.IPMap[dsMod.nodeAttr.Sid], dsMod.nodeAttr.Ipaddr)
+	if dsMod.adversary != nil {
+		dsMod.adversary.Send(msg, receivers, startTime)
+		return
 	}
+	dsMod.p2pMod.ConnMananger.Broadcast(dsMod.nodeAttr.Ipaddr, receivers, msg.JsonEncode())
 }
 
 func (dsMod *DSCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
@@ -236,9 +340,21 @@ func (dsMod *DSCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 	// do nothing, all the operations are triggered by the messages
 }
 
-// check the signature of SigListContent
-// TODO: implement this function
-// 需要前置完成密钥广播模块，尚未完成
-func (dsMod *DSCosensusMod) checkSigList([]*signature.Signature) bool {
+// the content signed by the view node and the forwarding nodes on the value proposed in the instance
+func (dsMod *DSCosensusMod) proposeContent(instance int, value []byte) []byte {
+	return SignedContent(dsMod.nodeAttr.Sid, instance, PhasePropose, value)
+}
+
+// check the signature chain of SigListContent, the chain starts with the signature of the view node
+func (dsMod *DSCosensusMod) checkSigList(sigListContent *SigListContent) bool {
+	if len(sigListContent.NodeList) == 0 || sigListContent.NodeList[0] != dsMod.view {
+		utils.LoggerInstance.Warn("The signature list does not start with the view node %d", dsMod.view)
+		return false
+	}
+	content := dsMod.proposeContent(sigListContent.Instance, sigListContent.Req.Content)
+	if err := VerifySigChain(dsMod.nodeAttr, content, sigListContent.SigList, sigListContent.NodeList, nil); err != nil {
+		utils.LoggerInstance.Warn("Invalid signature list: %v", err)
+		return false
+	}
 	return true
 }
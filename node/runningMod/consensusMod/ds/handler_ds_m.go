@@ -1,19 +1,111 @@
+// the malicious node follows the protocol, but every message it sends goes through its adversary,
+// which is chosen by config.MaliciousStrategy and may drop, delay, split or tamper the message.
+// An unknown strategy stops the node, like the unknown strategies of PBFT
 package ds
 
-import "BlockChainSimulator/message"
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/utils"
+	"fmt"
+	"sort"
+	"time"
+)
 
-func (dsMod *DSCosensusMod) HandleInitMsg_m(msg *message.Message) {
-	return
+// Adversary decides how a malicious node sends its messages in the synchronous protocols(DS, TBB)
+type Adversary interface {
+	// Send is invoked every time the node broadcasts the message to the receivers,
+	// startTime is the start time of the protocol instance the message belongs to
+	Send(msg *message.Message, receivers []string, startTime time.Time)
 }
 
-func (dsMod *DSCosensusMod) HandleProposeMsg_m(msg *message.Message) {
-	return
+const (
+	SilentAdversary           = "Silent"           // sends nothing, like a crashed node
+	SelectiveForwardAdversary = "SelectiveForward" // forwards the signature lists only to half of the nodes
+	LateForwardAdversary      = "LateForward"      // forwards the signature lists at the very end of the round, to half of the nodes
+	// add more adversary type here
+)
+
+type AdversaryConstructor func(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) Adversary
+
+var adversaryRegistry = make(map[string]AdversaryConstructor)
+
+func init() {
+	adversaryRegistry[SilentAdversary] = newSilentAdversary
+	adversaryRegistry[SelectiveForwardAdversary] = newSelectiveForwardAdversary
+	adversaryRegistry[LateForwardAdversary] = newLateForwardAdversary
+}
+
+// RegisterAdversary allows the protocols built on DS to add their own adversaries
+func RegisterAdversary(name string, constructor AdversaryConstructor) {
+	adversaryRegistry[name] = constructor
+}
+
+func NewAdversary(name string, attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) (Adversary, error) {
+	if constructor, exists := adversaryRegistry[name]; exists {
+		return constructor(attr, p2pMod), nil
+	}
+	return nil, fmt.Errorf("unknown adversary %s", name)
+}
+
+// whether the message carries a signature list which is forwarded round by round
+func IsForwardMsg(msgType message.MessageType) bool {
+	return msgType == message.MsgForward || msgType == message.MsgForward1 || msgType == message.MsgForward2
+}
+
+// the first half of the receivers, sorted so that the same nodes are chosen every time
+func HalfReceivers(receivers []string) []string {
+	sorted := append([]string{}, receivers...)
+	sort.Strings(sorted)
+	return sorted[:len(sorted)/2]
+}
+
+type silentAdversary struct{}
+
+func newSilentAdversary(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) Adversary {
+	return &silentAdversary{}
+}
+
+func (adv *silentAdversary) Send(msg *message.Message, receivers []string, startTime time.Time) {}
+
+type selectiveForwardAdversary struct {
+	p2pMod *p2p.P2PMod
+	selfIP string
+}
+
+func newSelectiveForwardAdversary(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) Adversary {
+	return &selectiveForwardAdversary{p2pMod: p2pMod, selfIP: attr.Ipaddr}
+}
+
+func (adv *selectiveForwardAdversary) Send(msg *message.Message, receivers []string, startTime time.Time) {
+	if IsForwardMsg(msg.MsgType) {
+		receivers = HalfReceivers(receivers)
+		utils.LoggerInstance.Warn("[Malicious] Forward the message only to %v", receivers)
+	}
+	adv.p2pMod.ConnMananger.Broadcast(adv.selfIP, receivers, msg.JsonEncode())
 }
 
-func (dsMod *DSCosensusMod) HandleForwardMsg_m(msg *message.Message) {
-	return
+type lateForwardAdversary struct {
+	p2pMod *p2p.P2PMod
+	selfIP string
 }
 
-func (dsMod *DSCosensusMod) handleQueryMsg_m(msg *message.Message) {
-	return
+func newLateForwardAdversary(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) Adversary {
+	return &lateForwardAdversary{p2pMod: p2pMod, selfIP: attr.Ipaddr}
+}
+
+// wait until the last tenth of the current round, so the receivers may only get the message in the next round
+func (adv *lateForwardAdversary) Send(msg *message.Message, receivers []string, startTime time.Time) {
+	if IsForwardMsg(msg.MsgType) {
+		tick := time.Duration(config.TickInterval) * time.Millisecond
+		elapsed := time.Since(startTime)
+		roundEnd := startTime.Add((elapsed/tick + 1) * tick)
+		time.Sleep(time.Until(roundEnd.Add(-tick / 10)))
+
+		receivers = HalfReceivers(receivers)
+		utils.LoggerInstance.Warn("[Malicious] Forward the message at the end of the round to %v", receivers)
+	}
+	adv.p2pMod.ConnMananger.Broadcast(adv.selfIP, receivers, msg.JsonEncode())
 }
@@ -0,0 +1,69 @@
+package ds
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// an adversary recording the messages instead of sending them
+type recordAdversary struct {
+	sent []message.MessageType
+}
+
+func (adv *recordAdversary) Send(msg *message.Message, receivers []string, startTime time.Time) {
+	adv.sent = append(adv.sent, msg.MsgType)
+}
+
+func TestNewAdversary(t *testing.T) {
+	attr, _ := newTestAttr(t, 4, 0, 1, 2, 3)
+	for _, name := range []string{SilentAdversary, SelectiveForwardAdversary, LateForwardAdversary} {
+		adv, err := NewAdversary(name, attr, nil)
+		assert.NoError(t, err, name)
+		assert.NotNil(t, adv, name)
+	}
+	_, err := NewAdversary("Unknown", attr, nil)
+	assert.Error(t, err)
+
+	// the protocols built on DS add their own adversaries
+	recorder := &recordAdversary{}
+	RegisterAdversary("Record", func(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) Adversary { return recorder })
+	adv, err := NewAdversary("Record", attr, nil)
+	assert.NoError(t, err)
+	assert.Same(t, recorder, adv)
+}
+
+// the messages of the malicious node, including the replies to the client, go through its adversary.
+// An unknown strategy stops the node
+func TestMaliciousNodeUsesAdversary(t *testing.T) {
+	attr, _ := newTestAttr(t, 4, 0, 1, 2, 3)
+	defer func() { config.IsMalicious = false }()
+	config.IsMalicious = true
+
+	config.MaliciousStrategy = "Unknown"
+	assert.Panics(t, func() { NewDSCosensusMod(attr, nil) })
+
+	recorder := &recordAdversary{}
+	RegisterAdversary("Record", func(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) Adversary { return recorder })
+	config.MaliciousStrategy = "Record"
+	dsMod := NewDSCosensusMod(attr, nil).(*DSCosensusMod)
+	dsMod.broadcast(&message.Message{MsgType: message.MsgForward}, time.Now())
+	dsMod.handleQueryMsg(&message.Message{MsgType: message.MsgQuery, Content: utils.Encode(message.QueryContent{Instance: 1})})
+	assert.Equal(t, []message.MessageType{message.MsgForward, message.MsgReplyQuery}, recorder.sent)
+}
+
+func TestHalfReceivers(t *testing.T) {
+	receivers := []string{"127.0.0.1:4", "127.0.0.1:2", "127.0.0.1:3", "127.0.0.1:1"}
+	assert.Equal(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, HalfReceivers(receivers))
+	assert.Equal(t, "127.0.0.1:4", receivers[0], "the receivers are not reordered")
+	assert.Empty(t, HalfReceivers([]string{"127.0.0.1:1"}))
+
+	assert.True(t, IsForwardMsg(message.MsgForward2))
+	assert.False(t, IsForwardMsg(message.MsgVote))
+}
@@ -0,0 +1,73 @@
+// the verification of the signature chains forwarded round by round, shared by DS and the protocols built on it(BADS* in TBB)
+package ds
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"fmt"
+)
+
+// the node id in the NodeList indicating the signature is the aggregate signature of several nodes
+const AggregateSigner = -1
+
+// the phases of the signed contents, a signature of one phase is not valid in another
+const (
+	PhasePropose = "Propose" // the value proposed by the view node, and the signature chain forwarding it
+	PhaseVote    = "Vote"    // the votes and the QCs of 1Δ-BB*
+	PhaseBA      = "BA"      // the inputs and the signature chains of BADS*
+)
+
+// SignedContent is the content the nodes sign on the value, it binds the shard, the instance and the phase,
+// so that a signature can not be replayed in another shard or instance
+func SignedContent(sid int, instance int, phase string, value []byte) []byte {
+	return utils.CanonicalEncode(struct {
+		Sid      int
+		Instance int
+		Phase    string
+		Value    []byte
+	}{sid, instance, phase, value})
+}
+
+// VerifySig checks the signature of the node in the shard of the belonging node, the unknown nodes and keys are rejected
+func VerifySig(nodeAttr *nodeattr.NodeAttr, nid int, content []byte, sig *signature.Signature) bool {
+	return nodeAttr.VerifySig(nodeAttr.Sid, nid, content, sig)
+}
+
+// VerifyAggregatedSig checks the aggregate signature of the signers, the signers must be distinct nodes of the shard with known keys
+func VerifyAggregatedSig(nodeAttr *nodeattr.NodeAttr, signers []int, content []byte, aggSig *signature.Signature) error {
+	return nodeAttr.VerifyAggregatedSig(nodeAttr.Sid, signers, content, aggSig)
+}
+
+// VerifySigChain checks the chain of signatures on the content, SigList[i] is signed by NodeList[i] and no node signs twice.
+// If NodeList[0] is AggregateSigner, SigList[0] is the aggregate signature of aggSigners
+func VerifySigChain(nodeAttr *nodeattr.NodeAttr, content []byte, sigList []*signature.Signature, nodeList []int, aggSigners []int) error {
+	if len(sigList) == 0 || len(sigList) != len(nodeList) {
+		return fmt.Errorf("%d signatures with %d signers", len(sigList), len(nodeList))
+	}
+
+	signers := utils.NewSet[int]()
+	for i, nid := range nodeList {
+		if i == 0 && nid == AggregateSigner {
+			if err := VerifyAggregatedSig(nodeAttr, aggSigners, content, sigList[0]); err != nil {
+				return err
+			}
+			for _, signer := range aggSigners {
+				signers.Add(signer)
+			}
+			continue
+		}
+		if nid < 0 || nid >= config.NodeNum {
+			return fmt.Errorf("unknown signer %d", nid)
+		}
+		if signers.Contains(nid) {
+			return fmt.Errorf("node %d signs twice", nid)
+		}
+		signers.Add(nid)
+		if !VerifySig(nodeAttr, nid, content, sigList[i]) {
+			return fmt.Errorf("the signature of node %d is not valid", nid)
+		}
+	}
+	return nil
+}
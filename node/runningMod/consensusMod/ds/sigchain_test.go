@@ -0,0 +1,82 @@
+package ds
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/signature"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the attribute of node 0 in a shard of n nodes, the keys of the nodes in known are in its PubKeyTable
+func newTestAttr(t *testing.T, n int, known ...int) (*nodeattr.NodeAttr, []*signature.SecretKey) {
+	config.ShardNum, config.NodeNum = 1, n
+	attr, sks := testutil.NewAttr(t, 0, 0, n)
+	for nid := range sks {
+		if !slices.Contains(known, nid) {
+			delete(attr.PubKeyTable[0], nid)
+		}
+	}
+	return attr, sks
+}
+
+func signChain(sks []*signature.SecretKey, content []byte, nodeList []int) []*signature.Signature {
+	sigList := make([]*signature.Signature, 0, len(nodeList))
+	for _, nid := range nodeList {
+		sigList = append(sigList, signature.Sign(sks[nid], content))
+	}
+	return sigList
+}
+
+func TestVerifySigChain(t *testing.T) {
+	attr, sks := newTestAttr(t, 4, 0, 1, 2, 3)
+	content := []byte("value")
+
+	assert.NoError(t, VerifySigChain(attr, content, signChain(sks, content, []int{0, 1, 2}), []int{0, 1, 2}, nil))
+	assert.Error(t, VerifySigChain(attr, content, signChain(sks, content, []int{0, 1, 1}), []int{0, 1, 1}, nil), "a node signs twice")
+	assert.Error(t, VerifySigChain(attr, content, signChain(sks, content, []int{0, 1}), []int{0, 2}, nil), "a signature of another node")
+	assert.Error(t, VerifySigChain(attr, []byte("other"), signChain(sks, content, []int{0, 1}), []int{0, 1}, nil), "a signature on another value")
+
+	// the aggregate signers cannot sign again in the chain
+	agg, err := signature.AggregateSignatures(signChain(sks, content, []int{0, 1}))
+	require.NoError(t, err)
+	sigList := append([]*signature.Signature{agg}, signChain(sks, content, []int{2})...)
+	assert.NoError(t, VerifySigChain(attr, content, sigList, []int{AggregateSigner, 2}, []int{0, 1}))
+	sigList = append([]*signature.Signature{agg}, signChain(sks, content, []int{1})...)
+	assert.Error(t, VerifySigChain(attr, content, sigList, []int{AggregateSigner, 1}, []int{0, 1}))
+}
+
+func TestVerifySigRejectsUnknownKeys(t *testing.T) {
+	attr, sks := newTestAttr(t, 4, 0, 1)
+	content := []byte("value")
+
+	assert.True(t, VerifySig(attr, 1, content, signature.Sign(sks[1], content)))
+	assert.False(t, VerifySig(attr, 2, content, signature.Sign(sks[2], content)), "the key of node 2 is unknown")
+	assert.False(t, VerifySig(attr, 7, content, signature.Sign(sks[2], content)), "node 7 is not in the shard")
+	assert.Error(t, VerifySigChain(attr, content, signChain(sks, content, []int{0, 3}), []int{0, 3}, nil))
+
+	agg, err := signature.AggregateSignatures(signChain(sks, content, []int{0, 1, 2}))
+	require.NoError(t, err)
+	assert.Error(t, VerifyAggregatedSig(attr, []int{0, 1, 2}, content, agg))
+}
+
+// the signatures are bound to the shard, the instance and the phase, they can not be replayed in another one
+func TestSignedContentBindsInstance(t *testing.T) {
+	attr, sks := newTestAttr(t, 4, 0, 1, 2, 3)
+	value := []byte("value")
+	content := SignedContent(0, 1, PhasePropose, value)
+	sigList := signChain(sks, content, []int{0, 1})
+
+	assert.NoError(t, VerifySigChain(attr, content, sigList, []int{0, 1}, nil))
+	for _, other := range [][]byte{
+		SignedContent(0, 2, PhasePropose, value),
+		SignedContent(1, 1, PhasePropose, value),
+		SignedContent(0, 1, PhaseBA, value),
+	} {
+		assert.Error(t, VerifySigChain(attr, other, sigList, []int{0, 1}, nil))
+	}
+}
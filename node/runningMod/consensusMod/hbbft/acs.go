@@ -0,0 +1,200 @@
+package hbbft
+
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/utils"
+)
+
+// the asynchronous common subset of an epoch: every node reliably broadcasts its batch, and agreement j decides whether batch j is in.
+// A node inputs 1 to agreement j once batch j is delivered, and inputs 0 to the others once N-f agreements decide 1.
+// The subset is the batches of the agreements deciding 1, every correct node outputs the same subset
+type acs struct {
+	n, f     int
+	epoch    int
+	proposed bool // whether this node broadcasts its batch in the epoch
+
+	rbcs      []*rbc
+	bas       []*binaryAgreement
+	values    map[int][]byte // the delivered batches
+	decisions map[int]bool
+	ones      int
+}
+
+func newACS(n int, f int, nid int, epoch int, keys *coinKeys) *acs {
+	a := &acs{
+		n: n, f: f,
+		epoch:     epoch,
+		rbcs:      make([]*rbc, n),
+		bas:       make([]*binaryAgreement, n),
+		values:    make(map[int][]byte),
+		decisions: make(map[int]bool),
+	}
+	for j := 0; j < n; j++ {
+		a.rbcs[j] = newRBC(n, f, nid, epoch, j)
+		a.bas[j] = newBinaryAgreement(n, f, nid, epoch, j, keys)
+	}
+	return a
+}
+
+func (a *acs) onDeliver(j int, v []byte, t transport) {
+	a.values[j] = v
+	if a.bas[j].input(true, t) {
+		a.onDecide(j, t)
+	}
+}
+
+func (a *acs) onDecide(j int, t transport) {
+	if _, ok := a.decisions[j]; ok {
+		return
+	}
+	b := a.bas[j].decision
+	a.decisions[j] = b
+	if !b {
+		return
+	}
+	a.ones++
+	if a.ones != a.n-a.f {
+		return
+	}
+	for i := 0; i < a.n; i++ {
+		if !a.bas[i].started && a.bas[i].input(false, t) {
+			a.onDecide(i, t)
+		}
+	}
+}
+
+// the batches of the subset ordered by the proposers, once all the agreements decide and the batches in the subset are delivered
+func (a *acs) result() ([][]byte, bool) {
+	if len(a.decisions) < a.n {
+		return nil, false
+	}
+	batches := make([][]byte, 0, a.n)
+	for j := 0; j < a.n; j++ {
+		if !a.decisions[j] {
+			continue
+		}
+		v, ok := a.values[j]
+		if !ok {
+			return nil, false
+		}
+		batches = append(batches, v)
+	}
+	return batches, true
+}
+
+// the HoneyBadgerBFT core of a node, the epochs are created when their first message arrives
+type honeyBadger struct {
+	n, f   int
+	nid    int
+	keys   *coinKeys
+	epochs map[int]*acs
+	kept   int // the epochs before it are dropped
+	t      transport
+}
+
+func newHoneyBadger(n int, nid int, keys *coinKeys, t transport) *honeyBadger {
+	return &honeyBadger{
+		n:      n,
+		f:      (n - 1) / 3,
+		nid:    nid,
+		keys:   keys,
+		epochs: make(map[int]*acs),
+		t:      t,
+	}
+}
+
+func (hb *honeyBadger) epoch(e int) *acs {
+	a, ok := hb.epochs[e]
+	if !ok {
+		a = newACS(hb.n, hb.f, hb.nid, e, hb.keys)
+		hb.epochs[e] = a
+	}
+	return a
+}
+
+// broadcast the batch of this node in the epoch
+func (hb *honeyBadger) propose(e int, v []byte) {
+	a := hb.epoch(e)
+	if a.proposed {
+		return
+	}
+	a.proposed = true
+	a.rbcs[hb.nid].propose(v, hb.t)
+}
+
+// handle a message of the sub-protocols from the authenticated node from, the epochs already dropped ignore their messages
+func (hb *honeyBadger) handle(msgType message.MessageType, from int, content []byte) {
+	switch msgType {
+	case message.MsgErasureVal, message.MsgErasureEcho:
+		c := &ErasureContent{}
+		if !hb.decode(content, c) || !hb.valid(c.Epoch, c.Proposer, c.Sender, from) {
+			return
+		}
+		a := hb.epoch(c.Epoch)
+		if msgType == message.MsgErasureVal {
+			a.rbcs[c.Proposer].handleVal(c, hb.t)
+		} else if v, ok := a.rbcs[c.Proposer].handleEcho(c, hb.t); ok {
+			a.onDeliver(c.Proposer, v, hb.t)
+		}
+	case message.MsgErasureReady:
+		c := &ReadyContent{}
+		if !hb.decode(content, c) || !hb.valid(c.Epoch, c.Proposer, c.Sender, from) {
+			return
+		}
+		a := hb.epoch(c.Epoch)
+		if v, ok := a.rbcs[c.Proposer].handleReady(c, hb.t); ok {
+			a.onDeliver(c.Proposer, v, hb.t)
+		}
+	case message.MsgBinVal, message.MsgAux, message.MsgTerm:
+		c := &BAContent{}
+		if !hb.decode(content, c) || !hb.valid(c.Epoch, c.Instance, c.Sender, from) || c.Round < 0 {
+			return
+		}
+		a := hb.epoch(c.Epoch)
+		ba := a.bas[c.Instance]
+		decided := false
+		switch msgType {
+		case message.MsgBinVal:
+			decided = ba.handleBVal(c, hb.t)
+		case message.MsgAux:
+			decided = ba.handleAux(c, hb.t)
+		default:
+			decided = ba.handleTerm(c, hb.t)
+		}
+		if decided {
+			a.onDecide(c.Instance, hb.t)
+		}
+	case message.MsgCoinShare:
+		c := &CoinContent{}
+		if !hb.decode(content, c) || !hb.valid(c.Epoch, c.Instance, c.Sender, from) || c.Round < 0 {
+			return
+		}
+		a := hb.epoch(c.Epoch)
+		if a.bas[c.Instance].handleCoin(c, hb.t) {
+			a.onDecide(c.Instance, hb.t)
+		}
+	}
+}
+
+func (hb *honeyBadger) decode(content []byte, c interface{}) bool {
+	if err := utils.Decode(content, c); err != nil {
+		utils.LoggerInstance.Error("Error decoding the HoneyBadgerBFT message")
+		return false
+	}
+	return true
+}
+
+// the messages of the dropped epochs are ignored, and a node only speaks for itself
+func (hb *honeyBadger) valid(epoch int, instance int, sender int, from int) bool {
+	return epoch >= hb.kept && instance >= 0 && instance < hb.n && sender >= 0 && sender < hb.n && sender == from
+}
+
+// drop the epochs before the given one
+func (hb *honeyBadger) dropBefore(e int) {
+	hb.kept = max(hb.kept, e)
+	for epoch := range hb.epochs {
+		if epoch < hb.kept {
+			delete(hb.epochs, epoch)
+		}
+	}
+}
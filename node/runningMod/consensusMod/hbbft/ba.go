@@ -0,0 +1,266 @@
+package hbbft
+
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+)
+
+// the binary agreement with a common coin (Mostefaoui et al.), one instance decides whether a batch is in the subset of the epoch.
+// In round r a node BV-broadcasts its estimate: it relays a value with f+1 BVALs and adds it to bin_values with 2f+1 BVALs.
+// Then it sends AUX with a value of bin_values, and waits N-f AUXs carrying the values of bin_values.
+// The coin of the round is recovered from f+1 threshold signature shares, if the AUXs carry a single value it becomes the estimate
+// and is decided if it equals the coin, otherwise the coin becomes the estimate.
+// A node sends TERM once it decides and stops, the TERM is counted as its BVAL and AUX in all the later rounds,
+// and f+1 TERMs of a value decide it
+type binaryAgreement struct {
+	n, f     int
+	nid      int
+	epoch    int
+	instance int
+	keys     *coinKeys
+
+	started   bool
+	round     int
+	est       bool
+	bvals     map[int]*[2]map[int]bool // round -> value -> the senders
+	bvalSent  map[int]*[2]bool
+	binValues map[int]*[2]bool
+	auxs      map[int]map[int]bool // round -> sender -> the value of its first AUX
+	auxSent   map[int]bool
+	shares    map[int]map[int]*signature.Signature // round -> sender -> the coin share
+	coinSent  map[int]bool
+	coins     map[int]bool
+	terms     map[int]bool // sender -> the decided value
+	decided   bool
+	decision  bool
+}
+
+func newBinaryAgreement(n int, f int, nid int, epoch int, instance int, keys *coinKeys) *binaryAgreement {
+	return &binaryAgreement{
+		n: n, f: f,
+		nid:       nid,
+		epoch:     epoch,
+		instance:  instance,
+		keys:      keys,
+		bvals:     make(map[int]*[2]map[int]bool),
+		bvalSent:  make(map[int]*[2]bool),
+		binValues: make(map[int]*[2]bool),
+		auxs:      make(map[int]map[int]bool),
+		auxSent:   make(map[int]bool),
+		shares:    make(map[int]map[int]*signature.Signature),
+		coinSent:  make(map[int]bool),
+		coins:     make(map[int]bool),
+		terms:     make(map[int]bool),
+	}
+}
+
+func index(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// start the agreement with the input, returns true once the agreement decides
+func (ba *binaryAgreement) input(b bool, t transport) bool {
+	if ba.started || ba.decided {
+		return false
+	}
+	ba.started = true
+	ba.est = b
+	ba.sendBVal(0, b, t)
+	return ba.progress(t)
+}
+
+func (ba *binaryAgreement) handleBVal(c *BAContent, t transport) bool {
+	if ba.bvals[c.Round] == nil {
+		ba.bvals[c.Round] = &[2]map[int]bool{make(map[int]bool), make(map[int]bool)}
+	}
+	ba.bvals[c.Round][index(c.Value)][c.Sender] = true
+	ba.checkBVal(c.Round, c.Value, t)
+	return ba.progress(t)
+}
+
+func (ba *binaryAgreement) handleAux(c *BAContent, t transport) bool {
+	if ba.auxs[c.Round] == nil {
+		ba.auxs[c.Round] = make(map[int]bool)
+	}
+	if _, ok := ba.auxs[c.Round][c.Sender]; !ok {
+		ba.auxs[c.Round][c.Sender] = c.Value
+	}
+	return ba.progress(t)
+}
+
+func (ba *binaryAgreement) handleTerm(c *BAContent, t transport) bool {
+	if _, ok := ba.terms[c.Sender]; ok {
+		return false
+	}
+	ba.terms[c.Sender] = c.Value
+
+	// at least one correct node decides the value
+	count := 0
+	for _, v := range ba.terms {
+		if v == c.Value {
+			count++
+		}
+	}
+	if count >= ba.f+1 && !ba.decided {
+		ba.decide(c.Value, t)
+		return true
+	}
+	return ba.progress(t)
+}
+
+func (ba *binaryAgreement) handleCoin(c *CoinContent, t transport) bool {
+	if _, ok := ba.coins[c.Round]; ok || c.Share == nil || c.Sender < 0 || c.Sender >= ba.n {
+		return false
+	}
+	if !signature.Verify(ba.keys.PubShares[c.Sender], coinName(ba.epoch, ba.instance, c.Round), c.Share) {
+		utils.LoggerInstance.Warn("The coin share of node %d in epoch %d is not valid", c.Sender, ba.epoch)
+		return false
+	}
+	if ba.shares[c.Round] == nil {
+		ba.shares[c.Round] = make(map[int]*signature.Signature)
+	}
+	ba.shares[c.Round][c.Sender] = c.Share
+	if len(ba.shares[c.Round]) < ba.f+1 {
+		return false
+	}
+
+	sigs := make([]*signature.Signature, 0, ba.f+1)
+	indexes := make([]int, 0, ba.f+1)
+	for sender, share := range ba.shares[c.Round] {
+		sigs = append(sigs, share)
+		indexes = append(indexes, sender)
+	}
+	sig, err := signature.RecoverSignature(sigs, indexes)
+	if err != nil || !signature.Verify(ba.keys.Master, coinName(ba.epoch, ba.instance, c.Round), sig) {
+		utils.LoggerInstance.Error("Failed to recover the coin of epoch %d instance %d round %d", ba.epoch, ba.instance, c.Round)
+		return false
+	}
+	// every node recovers the same signature, its hash is the coin
+	sigBytes, _ := sig.GobEncode()
+	h := sha256.Sum256(sigBytes)
+	ba.coins[c.Round] = h[0]&1 == 1
+	return ba.progress(t)
+}
+
+// the senders of BVAL(b) in the round, the decided nodes count
+func (ba *binaryAgreement) countBVal(round int, b bool) int {
+	count := 0
+	senders := map[int]bool{}
+	if ba.bvals[round] != nil {
+		senders = ba.bvals[round][index(b)]
+	}
+	count += len(senders)
+	for sender, v := range ba.terms {
+		if v == b && !senders[sender] {
+			count++
+		}
+	}
+	return count
+}
+
+// relay the value with f+1 BVALs, add it to bin_values with 2f+1 BVALs
+func (ba *binaryAgreement) checkBVal(round int, b bool, t transport) {
+	count := ba.countBVal(round, b)
+	if count >= ba.f+1 {
+		ba.sendBVal(round, b, t)
+	}
+	if count >= 2*ba.f+1 {
+		if ba.binValues[round] == nil {
+			ba.binValues[round] = &[2]bool{}
+		}
+		ba.binValues[round][index(b)] = true
+	}
+}
+
+func (ba *binaryAgreement) sendBVal(round int, b bool, t transport) {
+	if ba.bvalSent[round] == nil {
+		ba.bvalSent[round] = &[2]bool{}
+	}
+	if ba.bvalSent[round][index(b)] || ba.decided {
+		return
+	}
+	ba.bvalSent[round][index(b)] = true
+	t.broadcast(message.MsgBinVal, &BAContent{Epoch: ba.epoch, Instance: ba.instance, Sender: ba.nid, Round: round, Value: b})
+}
+
+// the number of the AUXs carrying the values of bin_values, and the values they carry
+func (ba *binaryAgreement) auxValues(round int) (int, [2]bool) {
+	vals := [2]bool{}
+	binValues := ba.binValues[round]
+	if binValues == nil {
+		return 0, vals
+	}
+	count := 0
+	for sender := 0; sender < ba.n; sender++ {
+		v, ok := ba.auxs[round][sender]
+		if !ok {
+			v, ok = ba.terms[sender]
+		}
+		if ok && binValues[index(v)] {
+			count++
+			vals[index(v)] = true
+		}
+	}
+	return count, vals
+}
+
+// run the rounds as far as the messages allow, returns true once the agreement decides
+func (ba *binaryAgreement) progress(t transport) bool {
+	for ba.started && !ba.decided {
+		r := ba.round
+		// the TERMs may complete the thresholds of the round
+		ba.checkBVal(r, false, t)
+		ba.checkBVal(r, true, t)
+		binValues := ba.binValues[r]
+		if binValues == nil {
+			return false
+		}
+		if !ba.auxSent[r] {
+			ba.auxSent[r] = true
+			w := ba.est
+			if !binValues[index(w)] {
+				w = !w
+			}
+			t.broadcast(message.MsgAux, &BAContent{Epoch: ba.epoch, Instance: ba.instance, Sender: ba.nid, Round: r, Value: w})
+		}
+
+		count, vals := ba.auxValues(r)
+		if count < ba.n-ba.f {
+			return false
+		}
+		if !ba.coinSent[r] {
+			ba.coinSent[r] = true
+			share := signature.Sign(ba.keys.Share, coinName(ba.epoch, ba.instance, r))
+			t.broadcast(message.MsgCoinShare, &CoinContent{Epoch: ba.epoch, Instance: ba.instance, Sender: ba.nid, Round: r, Share: share})
+		}
+		coin, ok := ba.coins[r]
+		if !ok {
+			return false
+		}
+
+		if vals[0] != vals[1] {
+			b := vals[1]
+			ba.est = b
+			if b == coin {
+				ba.decide(b, t)
+				return true
+			}
+		} else {
+			ba.est = coin
+		}
+		ba.round++
+		ba.sendBVal(ba.round, ba.est, t)
+	}
+	return false
+}
+
+func (ba *binaryAgreement) decide(b bool, t transport) {
+	ba.decided = true
+	ba.decision = b
+	t.broadcast(message.MsgTerm, &BAContent{Epoch: ba.epoch, Instance: ba.instance, Sender: ba.nid, Value: b})
+}
@@ -0,0 +1,123 @@
+// This file contains the erasure coding of the reliable broadcast, built on the GF(256) code of addon/coding.
+// A value is split into k = N-2f original chunks, node j gets chunk j: the first k nodes get the original chunks,
+// the others get the random linear combinations drawn from config.RandSeed, so any k chunks recover the value with a high probability.
+// The chunks are the leaves of a Merkle tree, every chunk is sent with its branch to the root
+package hbbft
+
+import (
+	rfccode "BlockChainSimulator/addon/coding"
+	"BlockChainSimulator/config"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// code the value into n chunks, any k of them recover it
+func encodeChunks(v []byte, k int, n int) [][]byte {
+	// the length prefix is kept in the chunks, so the padding of the last original chunk can be stripped
+	size := (4 + len(v) + k - 1) / k
+	data := make([]byte, size*k)
+	binary.BigEndian.PutUint32(data, uint32(len(v)))
+	copy(data[4:], v)
+	originals := make([][]byte, k)
+	for i := range originals {
+		originals[i] = data[i*size : (i+1)*size]
+	}
+
+	chunks := make([][]byte, n)
+	for j := range chunks {
+		chunk, _ := rfccode.EncodeData(originals, config.RandSeed, j)
+		chunks[j] = chunk.DataBlock
+	}
+	return chunks
+}
+
+// recover the value from the chunks indexed by the nodes, an error is returned if the chunks are not independent
+func decodeChunks(chunks map[int][]byte, k int) ([]byte, error) {
+	indexes := make([]int, 0, len(chunks))
+	for j := range chunks {
+		indexes = append(indexes, j)
+	}
+	sort.Ints(indexes)
+
+	coded := make([]rfccode.Chunk, 0, len(indexes))
+	vectors := make([][]byte, 0, len(indexes))
+	for _, j := range indexes {
+		coded = append(coded, rfccode.Chunk{DataBlock: chunks[j]})
+		vectors = append(vectors, rfccode.CodingVector(config.RandSeed, j, k))
+	}
+	originals, err := rfccode.Decode(coded, vectors)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, len(originals)*len(coded[0].DataBlock))
+	for _, chunk := range originals {
+		data = append(data, chunk.DataBlock...)
+	}
+	if len(data) < 4 || int(binary.BigEndian.Uint32(data)) > len(data)-4 {
+		return nil, fmt.Errorf("invalid length prefix of the value")
+	}
+	return data[4 : 4+binary.BigEndian.Uint32(data)], nil
+}
+
+// the levels of the Merkle tree over the chunks, from the leaves to the root. The leaves are padded to a power of 2
+func merkleTree(chunks [][]byte) [][][]byte {
+	width := 1
+	for width < len(chunks) {
+		width *= 2
+	}
+	leaves := make([][]byte, width)
+	for i := range leaves {
+		if i < len(chunks) {
+			h := sha256.Sum256(chunks[i])
+			leaves[i] = h[:]
+		} else {
+			leaves[i] = make([]byte, sha256.Size)
+		}
+	}
+
+	levels := [][][]byte{leaves}
+	for len(levels[len(levels)-1]) > 1 {
+		below := levels[len(levels)-1]
+		level := make([][]byte, len(below)/2)
+		for i := range level {
+			h := sha256.Sum256(append(append([]byte{}, below[2*i]...), below[2*i+1]...))
+			level[i] = h[:]
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+func merkleRoot(levels [][][]byte) []byte {
+	return levels[len(levels)-1][0]
+}
+
+// the siblings on the path from the leaf to the root
+func merkleBranch(levels [][][]byte, index int) [][]byte {
+	branch := make([][]byte, 0, len(levels)-1)
+	for _, level := range levels[:len(levels)-1] {
+		branch = append(branch, level[index^1])
+		index /= 2
+	}
+	return branch
+}
+
+// whether the chunk is the leaf of the index under the root
+func verifyBranch(root []byte, index int, chunk []byte, branch [][]byte) bool {
+	h := sha256.Sum256(chunk)
+	node := h[:]
+	for _, sibling := range branch {
+		if index%2 == 0 {
+			h = sha256.Sum256(append(append([]byte{}, node...), sibling...))
+		} else {
+			h = sha256.Sum256(append(append([]byte{}, sibling...), node...))
+		}
+		node = h[:]
+		index /= 2
+	}
+	return index == 0 && bytes.Equal(node, root)
+}
@@ -0,0 +1,294 @@
+// This file contains the HoneyBadgerBFT consensus module, it does not depend on any timing assumption.
+// The protocol runs in epochs, in every epoch each node proposes a batch of random txs from its own TxPool,
+// and the asynchronous common subset (acs.go) of the batches is committed as a block. The ACS is built on the
+// erasure-coded reliable broadcast (rbc.go) and the binary agreement with a threshold-signature coin (ba.go).
+// Every node receives all the txs of the shard, so the leaderless protocol runs under the same workload as PBFT
+package hbbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// implement the ConsensusMod interface
+var _ runningModInterface.RunningMod = &HoneyBadgerCosensusMod{}
+
+const SilentStrategy = "Silent" // a malicious node neither proposes nor answers any message, like a crashed node
+
+type HoneyBadgerCosensusMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	// consensus related
+	txPool       *structs.TxPool // the pending txs, removed only when they are committed
+	hb           *honeyBadger
+	epoch        int       // the epoch not committed yet
+	proposed     bool      // whether this node has proposed in the epoch
+	proposeTime  time.Time // the time this node proposed in the epoch
+	epochDone    chan struct{}
+	rnd          *rand.Rand
+	hbLock       sync.Mutex
+	committedNum int // number of the txs committed so far
+}
+
+func NewHoneyBadgerCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	hbMod := new(HoneyBadgerCosensusMod)
+	hbMod.nodeAttr = attr
+	hbMod.p2pMod = p2p
+
+	hbMod.txPool = structs.NewTxPool(config.BlockSize)
+	hbMod.epochDone = make(chan struct{}, 1)
+	hbMod.rnd = rand.New(rand.NewSource(time.Now().UnixNano() + int64(attr.Sid*config.NodeNum+attr.Nid)))
+
+	// the coin of the shard is a (f+1, N) threshold key dealt by the client, without it the node stays silent
+	keys, err := loadCoinKeys(attr.Sid, attr.Nid)
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to load the threshold keys of the common coin: %v", err)
+		return hbMod
+	}
+	hbMod.hb = newHoneyBadger(config.NodeNum, attr.Nid, keys, hbMod)
+
+	return hbMod
+}
+
+func (hbMod *HoneyBadgerCosensusMod) isSilent() bool {
+	return config.IsMalicious && config.MaliciousStrategy == SilentStrategy
+}
+
+// receive the txs from the client, every node of the shard keeps all the txs
+func (hbMod *HoneyBadgerCosensusMod) handleInject(msg *message.Message) {
+	txs := []structs.Transaction{}
+	err := utils.Decode(msg.Content, &txs)
+	if err != nil || len(txs) == 0 {
+		utils.LoggerInstance.Error("error decoding the txs")
+		return
+	}
+
+	utils.LoggerInstance.Debug("Receive %d txs", len(txs))
+	hbMod.txPool.AddTxs(txs)
+}
+
+// the signed messages of the sub-protocols from the other nodes
+func (hbMod *HoneyBadgerCosensusMod) handleHoneyBadger(msg *message.Message) {
+	sm := &SignedMessage{}
+	if err := utils.Decode(msg.Content, sm); err != nil || sm.Sig == nil {
+		utils.LoggerInstance.Error("Error decoding the signed HoneyBadgerBFT message")
+		return
+	}
+	if sm.Sender == hbMod.nodeAttr.Nid || !hbMod.nodeAttr.VerifySig(hbMod.nodeAttr.Sid, sm.Sender, signedContent(msg.MsgType, sm.Content), sm.Sig) {
+		utils.LoggerInstance.Warn("Drop the HoneyBadgerBFT message with an invalid signature of node %d", sm.Sender)
+		return
+	}
+	hbMod.deliver(msg.MsgType, sm.Sender, sm.Content)
+}
+
+// handle a message from the given node, the epoch is committed once its subset is complete
+func (hbMod *HoneyBadgerCosensusMod) deliver(msgType message.MessageType, from int, content []byte) {
+	hbMod.hbLock.Lock()
+	defer hbMod.hbLock.Unlock()
+
+	hbMod.hb.handle(msgType, from, content)
+	hbMod.tryCommit()
+}
+
+// call with hbLock held, commit the epochs whose subsets are complete in order
+func (hbMod *HoneyBadgerCosensusMod) tryCommit() {
+	for {
+		a, ok := hbMod.hb.epochs[hbMod.epoch]
+		if !ok {
+			return
+		}
+		values, ok := a.result()
+		if !ok {
+			return
+		}
+		hbMod.commit(values)
+
+		hbMod.hb.dropBefore(hbMod.epoch + 1)
+		hbMod.epoch++
+		hbMod.proposed = false
+		select {
+		case hbMod.epochDone <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// call with hbLock held, the txs of the batches are committed in the order of the proposers, the duplicated ones only once
+func (hbMod *HoneyBadgerCosensusMod) commit(values [][]byte) {
+	txs := make([]structs.Transaction, 0)
+	seen := make(map[string]bool)
+	for _, v := range values {
+		batch := Batch{}
+		if err := utils.Decode(v, &batch); err != nil {
+			// the batch is agreed by all the correct nodes, so all of them skip it
+			utils.LoggerInstance.Warn("Skip the undecodable batch in epoch %d", hbMod.epoch)
+			continue
+		}
+		for _, tx := range batch.Txs {
+			if !seen[string(tx.Hash())] {
+				seen[string(tx.Hash())] = true
+				txs = append(txs, tx)
+			}
+		}
+	}
+	hbMod.txPool.RemoveTxs(txs)
+	if len(txs) == 0 {
+		utils.LoggerInstance.Debug("Epoch %d commits no tx", hbMod.epoch)
+		return
+	}
+
+	bc := hbMod.nodeAttr.CurChain
+	b := bc.NewBlock(txs)
+	bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+	bc.CommitBlock(b)
+	hbMod.committedNum += len(txs)
+	utils.LoggerInstance.Info("Commit the block of epoch %d with %d txs from %d batches", hbMod.epoch, len(txs), len(values))
+
+	// the view node reports the chain of the shard to the client
+	if hbMod.nodeAttr.Nid == config.ViewNodeId {
+		hbMod.sendReply(b)
+	}
+}
+
+// send the committed block back to the client, so that the measure mod can work.
+// The request time is the time this node proposed in the epoch, so TCL is the latency of an epoch
+func (hbMod *HoneyBadgerCosensusMod) sendReply(b *structs.Block) {
+	req := message.NewRequest(hbMod.nodeAttr.Sid, message.ReqVerifyTxs, utils.Encode(b))
+	if !hbMod.proposeTime.IsZero() {
+		req.ReqTime = hbMod.proposeTime
+	}
+	reply := &message.Reply{
+		Req:  req,
+		Time: time.Now(),
+
+		Sid:         hbMod.nodeAttr.Sid,
+		ReqQueueLen: hbMod.txPool.Size(),
+	}
+
+	replymsg := message.Message{
+		MsgType: message.MsgReply,
+		Content: utils.Encode(reply),
+	}
+	go hbMod.p2pMod.ConnMananger.Send(config.ClientAddr, replymsg.JsonEncode())
+}
+
+// propose the batch of the epoch, B/N random txs of the first B pending txs, so the batches of the nodes rarely overlap.
+// A node with no pending txs still proposes an empty batch once the other nodes have started the epoch
+func (hbMod *HoneyBadgerCosensusMod) tryPropose() {
+	hbMod.hbLock.Lock()
+	defer hbMod.hbLock.Unlock()
+
+	_, started := hbMod.hb.epochs[hbMod.epoch]
+	if hbMod.proposed || (hbMod.txPool.Size() == 0 && !started) {
+		return
+	}
+
+	candidates := hbMod.txPool.PeekTxs(config.HBBFTEpochSize())
+	size := max(config.HBBFTEpochSize()/config.NodeNum, 1)
+	hbMod.rnd.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > size {
+		candidates = candidates[:size]
+	}
+
+	hbMod.proposed = true
+	hbMod.proposeTime = time.Now()
+	hbMod.hb.propose(hbMod.epoch, utils.Encode(Batch{Txs: candidates, Time: hbMod.proposeTime}))
+	utils.LoggerInstance.Info("Propose the batch of epoch %d with %d txs", hbMod.epoch, len(candidates))
+}
+
+// sign the encoded content of a sub-protocol message for the other nodes
+func (hbMod *HoneyBadgerCosensusMod) sign(msgType message.MessageType, encoded []byte) message.Message {
+	sm := &SignedMessage{
+		Sender:  hbMod.nodeAttr.Nid,
+		Content: encoded,
+		Sig:     signature.Sign(hbMod.nodeAttr.SecKey, signedContent(msgType, encoded)),
+	}
+	return message.Message{
+		MsgType: msgType,
+		Content: utils.Encode(sm),
+	}
+}
+
+// implement the transport of the sub-protocols, the messages to this node are handled in a new goroutine
+func (hbMod *HoneyBadgerCosensusMod) sendTo(nid int, msgType message.MessageType, content interface{}) {
+	encoded := utils.Encode(content)
+	if nid == hbMod.nodeAttr.Nid {
+		go hbMod.deliver(msgType, nid, encoded)
+		return
+	}
+	msg := hbMod.sign(msgType, encoded)
+	go hbMod.p2pMod.ConnMananger.Send(config.IPMap[hbMod.nodeAttr.Sid][nid], msg.JsonEncode())
+}
+
+func (hbMod *HoneyBadgerCosensusMod) broadcast(msgType message.MessageType, content interface{}) {
+	encoded := utils.Encode(content)
+	go hbMod.deliver(msgType, hbMod.nodeAttr.Nid, encoded)
+	msg := hbMod.sign(msgType, encoded)
+	hbMod.p2pMod.ConnMananger.Broadcast(hbMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[hbMod.nodeAttr.Sid], hbMod.nodeAttr.Ipaddr), msg.JsonEncode())
+}
+
+// a silent node drops all the messages
+func (hbMod *HoneyBadgerCosensusMod) handleSilent(msg *message.Message) {}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (hbMod *HoneyBadgerCosensusMod) RegisterHandlers() {
+	msgTypes := []message.MessageType{
+		message.MsgErasureVal, message.MsgErasureEcho, message.MsgErasureReady,
+		message.MsgBinVal, message.MsgAux, message.MsgTerm, message.MsgCoinShare,
+	}
+	if hbMod.isSilent() || hbMod.hb == nil {
+		hbMod.p2pMod.RegisterHandler(message.MsgInject, hbMod.handleSilent)
+		for _, msgType := range msgTypes {
+			hbMod.p2pMod.RegisterHandler(msgType, hbMod.handleSilent)
+		}
+		return
+	}
+	hbMod.p2pMod.RegisterHandler(message.MsgInject, hbMod.handleInject)
+	for _, msgType := range msgTypes {
+		hbMod.p2pMod.RegisterHandler(msgType, hbMod.handleHoneyBadger)
+	}
+}
+
+// Run proposes the batch of every epoch, the next epoch starts once the subset of the current one is committed
+func (hbMod *HoneyBadgerCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if hbMod.isSilent() || hbMod.hb == nil {
+		utils.LoggerInstance.Info("This node is silent, do not run HoneyBadgerBFT")
+		return
+	}
+
+	// the messages sent before the peers are listening are lost, and the asynchronous protocol never retransmits
+	if !p2p.WaitForAllIPsReady(20 * time.Second) {
+		utils.LoggerInstance.Error("Wait for all IPs ready timeout")
+	}
+	// the messages are signed, so the public keys of the shard are needed before the first proposal
+	if !hbMod.nodeAttr.AwaitPubKeys(ctx) {
+		return
+	}
+
+	utils.LoggerInstance.Info("Start the HoneyBadgerBFT consensus Mod")
+	for {
+		hbMod.tryPropose()
+		select {
+		case <-ctx.Done():
+			hbMod.hbLock.Lock()
+			utils.LoggerInstance.Info("Stop the HoneyBadgerBFT consensus Mod, %d txs are committed in %d epochs", hbMod.committedNum, hbMod.epoch)
+			hbMod.hbLock.Unlock()
+			return
+		case <-hbMod.epochDone:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
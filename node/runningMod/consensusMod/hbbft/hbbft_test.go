@@ -0,0 +1,191 @@
+package hbbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a message in flight in the simulated network
+type envelope struct {
+	from    int
+	to      int
+	msgType message.MessageType
+	content []byte
+}
+
+// the asynchronous network of the test, the messages are delivered in a random order
+type testNetwork struct {
+	n       int
+	queue   []envelope
+	crashed map[int]bool
+	rnd     *rand.Rand
+}
+
+type testTransport struct {
+	net *testNetwork
+	nid int
+	// the proposer of an equivocating broadcast sends the chunks of another value to the nodes not in it
+	filter func(to int, msgType message.MessageType) bool
+}
+
+func (tt *testTransport) sendTo(nid int, msgType message.MessageType, content interface{}) {
+	if tt.filter != nil && !tt.filter(nid, msgType) {
+		return
+	}
+	tt.net.queue = append(tt.net.queue, envelope{from: tt.nid, to: nid, msgType: msgType, content: utils.Encode(content)})
+}
+
+func (tt *testTransport) broadcast(msgType message.MessageType, content interface{}) {
+	for nid := 0; nid < tt.net.n; nid++ {
+		tt.sendTo(nid, msgType, content)
+	}
+}
+
+// deliver the messages until the network is quiet
+func (net *testNetwork) run(nodes []*honeyBadger) {
+	for len(net.queue) > 0 {
+		i := net.rnd.Intn(len(net.queue))
+		env := net.queue[i]
+		net.queue[i] = net.queue[len(net.queue)-1]
+		net.queue = net.queue[:len(net.queue)-1]
+		if !net.crashed[env.to] {
+			nodes[env.to].handle(env.msgType, env.from, env.content)
+		}
+	}
+}
+
+func newTestNodes(t *testing.T, n int, seed int64) ([]*honeyBadger, *testNetwork) {
+	f := (n - 1) / 3
+	master, shares, pubShares, err := signature.GenerateThresholdKeys([]byte("hbbft-test"), f+1, n)
+	require.NoError(t, err)
+	net := &testNetwork{n: n, crashed: map[int]bool{}, rnd: rand.New(rand.NewSource(seed))}
+	nodes := make([]*honeyBadger, n)
+	for nid := range nodes {
+		keys := &coinKeys{Master: master, Share: shares[nid], PubShares: pubShares}
+		nodes[nid] = newHoneyBadger(n, nid, keys, &testTransport{net: net, nid: nid})
+	}
+	return nodes, net
+}
+
+func TestErasureCoding(t *testing.T) {
+	v := []byte("the batch of the epoch, split into the chunks of the nodes")
+	n, k := 7, 3
+	chunks := encodeChunks(v, k, n)
+	levels := merkleTree(chunks)
+	root := merkleRoot(levels)
+	for j := range chunks {
+		assert.True(t, verifyBranch(root, j, chunks[j], merkleBranch(levels, j)))
+	}
+	assert.False(t, verifyBranch(root, 1, chunks[0], merkleBranch(levels, 0)))
+
+	// the coded chunks recover the value
+	decoded, err := decodeChunks(map[int][]byte{6: chunks[6], 4: chunks[4], 5: chunks[5]}, k)
+	require.NoError(t, err)
+	assert.Equal(t, v, decoded)
+	_, err = decodeChunks(map[int][]byte{0: chunks[0], 4: chunks[4]}, k)
+	assert.Error(t, err)
+}
+
+func TestACS(t *testing.T) {
+	n := 4
+	for seed := int64(0); seed < 5; seed++ {
+		t.Run(fmt.Sprintf("crashed node, seed %d", seed), func(t *testing.T) {
+			nodes, net := newTestNodes(t, n, seed)
+			net.crashed[3] = true
+			for nid := 0; nid < 3; nid++ {
+				nodes[nid].propose(0, []byte(fmt.Sprintf("batch of node %d", nid)))
+			}
+			net.run(nodes)
+
+			// the correct nodes output the same subset with the batches of N-f nodes
+			expected, ok := nodes[0].epochs[0].result()
+			require.True(t, ok)
+			assert.Len(t, expected, 3)
+			for nid := 1; nid < 3; nid++ {
+				batches, ok := nodes[nid].epochs[0].result()
+				require.True(t, ok)
+				assert.Equal(t, expected, batches)
+			}
+		})
+
+		t.Run(fmt.Sprintf("equivocating proposer, seed %d", seed), func(t *testing.T) {
+			nodes, net := newTestNodes(t, n, seed)
+			for nid := 0; nid < 3; nid++ {
+				nodes[nid].propose(0, []byte(fmt.Sprintf("batch of node %d", nid)))
+			}
+			// node 3 sends the chunks of two values to the two halves
+			a := nodes[3].epoch(0)
+			a.proposed = true
+			a.rbcs[3].propose([]byte("value A"), &testTransport{net: net, nid: 3, filter: func(to int, _ message.MessageType) bool { return to < 2 }})
+			a.rbcs[3].propose([]byte("value B"), &testTransport{net: net, nid: 3, filter: func(to int, _ message.MessageType) bool { return to >= 2 }})
+			net.run(nodes)
+
+			expected, ok := nodes[0].epochs[0].result()
+			require.True(t, ok)
+			assert.GreaterOrEqual(t, len(expected), 3)
+			for nid := 1; nid < 3; nid++ {
+				batches, ok := nodes[nid].epochs[0].result()
+				require.True(t, ok)
+				assert.Equal(t, expected, batches)
+			}
+		})
+	}
+}
+
+func TestForgedSender(t *testing.T) {
+	nodes, _ := newTestNodes(t, 4, 0)
+	// node 3 sends f+1 TERMs in the names of nodes 1 and 2, which would decide the agreement of node 0
+	for _, sender := range []int{1, 2} {
+		term := &BAContent{Epoch: 0, Instance: 0, Sender: sender, Value: false}
+		nodes[0].handle(message.MsgTerm, 3, utils.Encode(term))
+	}
+	ba := nodes[0].epoch(0).bas[0]
+	assert.Empty(t, ba.terms)
+	assert.False(t, ba.decided)
+
+	// its own TERM is counted
+	nodes[0].handle(message.MsgTerm, 3, utils.Encode(&BAContent{Epoch: 0, Instance: 0, Sender: 3, Value: false}))
+	assert.Len(t, ba.terms, 1)
+}
+
+func TestLoadCoinKeys(t *testing.T) {
+	n := 4
+	oldNodeNum, oldPath := config.NodeNum, config.StoragePath
+	defer func() { config.NodeNum, config.StoragePath = oldNodeNum, oldPath }()
+	config.NodeNum, config.StoragePath = n, t.TempDir()+"/"
+	require.NoError(t, signature.DealThresholdKeys(config.HBBFTCoinThreshold(), n, func(nid int) string { return config.HBBFTCoinKeyFile(1, nid) }))
+
+	// the keys are readable by the owner of the file only
+	for nid := 0; nid < n; nid++ {
+		info, err := os.Stat(config.HBBFTCoinKeyFile(1, nid))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// the shares of f+1 nodes recover the coin, verified by the master key every node holds
+	name := coinName(0, 0, 0)
+	shares := make([]*signature.Signature, 2)
+	var master *signature.PublicKey
+	for nid := 0; nid < 2; nid++ {
+		k, err := loadCoinKeys(1, nid)
+		require.NoError(t, err)
+		shares[nid] = signature.Sign(k.Share, name)
+		assert.True(t, signature.Verify(k.PubShares[nid], name, shares[nid]))
+		master = k.Master
+	}
+	sig, err := signature.RecoverSignature(shares, []int{0, 1})
+	require.NoError(t, err)
+	assert.True(t, signature.Verify(master, name, sig))
+
+	_, err = loadCoinKeys(0, 0)
+	assert.Error(t, err, "the keys of shard 0 are not dealt")
+}
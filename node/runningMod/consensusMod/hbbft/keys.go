@@ -0,0 +1,19 @@
+package hbbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/signature"
+	"fmt"
+)
+
+// read the keys of node nid in shard sid, dealt by the client with signature.DealThresholdKeys
+func loadCoinKeys(sid int, nid int) (*coinKeys, error) {
+	keys, err := signature.LoadThresholdKeys(config.HBBFTCoinKeyFile(sid, nid))
+	if err != nil {
+		return nil, fmt.Errorf("the keys of the common coin are not given: %v", err)
+	}
+	if keys.Master == nil || keys.Share == nil || len(keys.PubShares) != config.NodeNum {
+		return nil, fmt.Errorf("the keys of the common coin are incomplete")
+	}
+	return keys, nil
+}
@@ -0,0 +1,144 @@
+package hbbft
+
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/utils"
+	"bytes"
+)
+
+// the erasure-coded reliable broadcast of a proposer in an epoch (Cachin and Tessaro):
+// the proposer sends node j its chunk j(VAL), every node echoes its own chunk to all(ECHO).
+// With N-f echoes of a root, a node recovers the value, re-encodes it to check the root, and sends READY.
+// f+1 READYs make a node send READY too, 2f+1 READYs and k echoes deliver the value
+type rbc struct {
+	n, f, k  int
+	nid      int // this node
+	epoch    int
+	proposer int
+
+	echoes    map[string]map[int][]byte // root -> sender -> the chunk of the sender
+	readies   map[string]map[int]bool   // root -> the senders
+	values    map[string][]byte         // the values recovered and checked
+	failed    map[string]bool           // the roots whose chunks do not re-encode to the root, the proposer is faulty
+	echoSent  bool
+	readySent bool
+	delivered bool
+}
+
+func newRBC(n int, f int, nid int, epoch int, proposer int) *rbc {
+	return &rbc{
+		n: n, f: f, k: n - 2*f,
+		nid:      nid,
+		epoch:    epoch,
+		proposer: proposer,
+		echoes:   make(map[string]map[int][]byte),
+		readies:  make(map[string]map[int]bool),
+		values:   make(map[string][]byte),
+		failed:   make(map[string]bool),
+	}
+}
+
+// the proposer sends every node its chunk
+func (r *rbc) propose(v []byte, t transport) {
+	chunks := encodeChunks(v, r.k, r.n)
+	levels := merkleTree(chunks)
+	root := merkleRoot(levels)
+	for j := 0; j < r.n; j++ {
+		t.sendTo(j, message.MsgErasureVal, &ErasureContent{
+			Epoch:    r.epoch,
+			Proposer: r.proposer,
+			Sender:   r.nid,
+			Root:     root,
+			Index:    j,
+			Branch:   merkleBranch(levels, j),
+			Chunk:    chunks[j],
+		})
+	}
+}
+
+// echo the own chunk received from the proposer, only once
+func (r *rbc) handleVal(c *ErasureContent, t transport) {
+	if c.Sender != r.proposer || c.Index != r.nid || r.echoSent || !verifyBranch(c.Root, c.Index, c.Chunk, c.Branch) {
+		return
+	}
+	r.echoSent = true
+	echo := *c
+	echo.Sender = r.nid
+	t.broadcast(message.MsgErasureEcho, &echo)
+}
+
+// returns the value once it is delivered
+func (r *rbc) handleEcho(c *ErasureContent, t transport) ([]byte, bool) {
+	if c.Index != c.Sender || c.Index < 0 || c.Index >= r.n || !verifyBranch(c.Root, c.Index, c.Chunk, c.Branch) {
+		return nil, false
+	}
+	root := string(c.Root)
+	if r.echoes[root] == nil {
+		r.echoes[root] = make(map[int][]byte)
+	}
+	if _, ok := r.echoes[root][c.Sender]; ok {
+		return nil, false
+	}
+	r.echoes[root][c.Sender] = c.Chunk
+
+	if len(r.echoes[root]) >= r.n-r.f && !r.readySent {
+		if _, ok := r.reconstruct(root); ok {
+			r.sendReady(c.Root, t)
+		}
+	}
+	return r.tryDeliver(root)
+}
+
+// returns the value once it is delivered
+func (r *rbc) handleReady(c *ReadyContent, t transport) ([]byte, bool) {
+	root := string(c.Root)
+	if r.readies[root] == nil {
+		r.readies[root] = make(map[int]bool)
+	}
+	r.readies[root][c.Sender] = true
+
+	if len(r.readies[root]) >= r.f+1 && !r.readySent {
+		r.sendReady(c.Root, t)
+	}
+	return r.tryDeliver(root)
+}
+
+func (r *rbc) sendReady(root []byte, t transport) {
+	r.readySent = true
+	t.broadcast(message.MsgErasureReady, &ReadyContent{Epoch: r.epoch, Proposer: r.proposer, Sender: r.nid, Root: root})
+}
+
+func (r *rbc) tryDeliver(root string) ([]byte, bool) {
+	if r.delivered || len(r.readies[root]) < 2*r.f+1 || len(r.echoes[root]) < r.k {
+		return nil, false
+	}
+	v, ok := r.reconstruct(root)
+	if !ok {
+		return nil, false
+	}
+	r.delivered = true
+	return v, true
+}
+
+// recover the value from the echoes of the root, and check that its chunks are the leaves of the root
+func (r *rbc) reconstruct(root string) ([]byte, bool) {
+	if v, ok := r.values[root]; ok {
+		return v, true
+	}
+	if r.failed[root] {
+		return nil, false
+	}
+	v, err := decodeChunks(r.echoes[root], r.k)
+	if err != nil {
+		// the chunks are not independent yet, wait for more echoes
+		utils.LoggerInstance.Debug("Failed to decode the batch of node %d in epoch %d: %v", r.proposer, r.epoch, err)
+		return nil, false
+	}
+	if !bytes.Equal(merkleRoot(merkleTree(encodeChunks(v, r.k, r.n))), []byte(root)) {
+		utils.LoggerInstance.Warn("The chunks of node %d in epoch %d do not match the root", r.proposer, r.epoch)
+		r.failed[root] = true
+		return nil, false
+	}
+	r.values[root] = v
+	return v, true
+}
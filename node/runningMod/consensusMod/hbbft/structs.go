@@ -0,0 +1,86 @@
+package hbbft
+
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"time"
+)
+
+// the content of MsgErasureVal and MsgErasureEcho, the chunk of node Index with its Merkle branch
+type ErasureContent struct {
+	Epoch    int
+	Proposer int // the broadcast instance, every node broadcasts its batch in every epoch
+	Sender   int
+	Root     []byte
+	Index    int
+	Branch   [][]byte
+	Chunk    []byte
+}
+
+// the content of MsgErasureReady
+type ReadyContent struct {
+	Epoch    int
+	Proposer int
+	Sender   int
+	Root     []byte
+}
+
+// the content of MsgBinVal, MsgAux and MsgTerm, the agreement Instance decides whether the batch of node Instance is included
+type BAContent struct {
+	Epoch    int
+	Instance int
+	Sender   int
+	Round    int // not used by MsgTerm
+	Value    bool
+}
+
+// the content of MsgCoinShare
+type CoinContent struct {
+	Epoch    int
+	Instance int
+	Sender   int
+	Round    int
+	Share    *signature.Signature
+}
+
+// the batch a node proposes in an epoch
+type Batch struct {
+	Txs  []structs.Transaction
+	Time time.Time // the time the batch is proposed
+}
+
+// the keys of the common coin, a (f+1, N) threshold key dealt by the client, see config.HBBFTCoinThreshold
+type coinKeys = signature.ThresholdKeys
+
+// the message of the sub-protocols signed by its sender, the sub-protocols count the messages by the verified senders
+type SignedMessage struct {
+	Sender  int
+	Content []byte // the encoded content of the sub-protocol message
+	Sig     *signature.Signature
+}
+
+// the outgoing messages of the sub-protocols. The messages a node sends to itself are delivered later,
+// so the sub-protocols never re-enter themselves
+type transport interface {
+	sendTo(nid int, msgType message.MessageType, content interface{})
+	broadcast(msgType message.MessageType, content interface{}) // to all the nodes, including this node
+}
+
+// the name signed by the coin of a round
+func coinName(epoch int, instance int, round int) []byte {
+	return utils.CanonicalEncode(struct {
+		Epoch    int
+		Instance int
+		Round    int
+	}{epoch, instance, round})
+}
+
+// the content signed in a SignedMessage, bound to the message type
+func signedContent(msgType message.MessageType, content []byte) []byte {
+	return utils.CanonicalEncode(struct {
+		MsgType message.MessageType
+		Content []byte
+	}{msgType, content})
+}
@@ -1,3 +1,517 @@
+// This file contains the chained HotStuff consensus module.
+// The leader is fixed to the view node, replicas only send their votes to the leader, so the communication is linear.
+// The leader signs its proposals, and proposes again from the highest QC if the QC of a node is not formed in config.ViewChangeTimeout.
+// In this implementation, node.Req.Content is the block
 package hotstuff
 
-// TODO
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"context"
+	"sync"
+	"time"
+)
+
+// implement the ConsensusMod interface
+var _ runningModInterface.RunningMod = &HotStuffCosensusMod{}
+
+type HotStuffCosensusMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	// hotstuff related
+	view          int // the nid of the leader
+	node_num      int // number of nodes in the hotstuff network
+	malicious_num int // max number of malicious nodes in the hotstuff network
+
+	// consensus related
+	requestQueue *utils.Queue[message.Request] // the queue of requests waiting for consensus
+	qcReady      chan struct{}                 // the channel to notify the leader a new QC is formed
+
+	nodes   map[[32]byte]*HotStuffNode               // all the nodes received, the key is the hash of the node
+	pending map[[32]byte][]*HotStuffNode             // nodes whose parent has not been received, the key is the hash of the parent
+	votes   map[voteKey]map[int]*signature.Signature // votes received by the leader, the voted (view, node hash) -> nid -> signature
+
+	genesis     *HotStuffNode
+	highQC      *QuorumCert   // the highest QC known
+	lockedNode  *HotStuffNode // b_lock in the paper
+	execNode    *HotStuffNode // b_exec in the paper, the last committed node
+	vheight     int           // the height of the last voted node
+	lastReqNode int           // the height of the last non-empty node proposed by the leader
+	proposed    int           // the height of the last node proposed by the leader
+	hsLock      sync.Mutex
+}
+
+// the votes claiming another view of the node are signed on another content, they are never aggregated together
+type voteKey struct {
+	View     int
+	NodeHash [32]byte
+}
+
+func NewHotStuffCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	hsMod := new(HotStuffCosensusMod)
+	hsMod.nodeAttr = attr
+	hsMod.p2pMod = p2p
+
+	hsMod.view = config.ViewNodeId
+	hsMod.node_num = config.NodeNum
+	hsMod.malicious_num = (hsMod.node_num - 1) / 3
+
+	hsMod.requestQueue = utils.NewQueue[message.Request]()
+	hsMod.qcReady = make(chan struct{}, 1)
+
+	hsMod.nodes = make(map[[32]byte]*HotStuffNode)
+	hsMod.pending = make(map[[32]byte][]*HotStuffNode)
+	hsMod.votes = make(map[voteKey]map[int]*signature.Signature)
+
+	// every node starts from the same genesis node, which is certified by a QC without signature
+	hsMod.genesis = &HotStuffNode{Height: 0, Req: message.Request{ReqType: message.ReqEmpty}}
+	genesisHash := hsMod.genesis.Hash()
+	hsMod.genesis.Justify = &QuorumCert{View: 0, NodeHash: genesisHash}
+	hsMod.nodes[genesisHash] = hsMod.genesis
+	hsMod.highQC = hsMod.genesis.Justify
+	hsMod.lockedNode = hsMod.genesis
+	hsMod.execNode = hsMod.genesis
+
+	return hsMod
+}
+
+// At present, only the leader will receive the Propose message from ProposeBlockAuxiliaryMod
+func (hsMod *HotStuffCosensusMod) handlePropose(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle propose")
+
+	req := message.Request{}
+	err := utils.Decode(msg.Content, &req)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the request")
+		return
+	}
+
+	hsMod.requestQueue.Enqueue(req)
+}
+
+// the leader's proposal, carrying the QC of its parent
+func (hsMod *HotStuffCosensusMod) handleQC(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle generic message")
+
+	generic := GenericContent{}
+	err := utils.Decode(msg.Content, &generic)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the generic message")
+		return
+	}
+	node := generic.Node
+	if node.Justify == nil || !hsMod.checkSig(hsMod.view, proposalDigest(&node), generic.Sig) {
+		utils.LoggerInstance.Warn("The proposal of height %d is not signed by the leader", node.Height)
+		return
+	}
+	if node.Justify.NodeHash != node.ParentHash {
+		utils.LoggerInstance.Warn("The QC carried by node of height %d does not certify its parent", node.Height)
+		return
+	}
+	req := node.Req
+	req.CalDigest()
+	if req.Digest != node.Req.Digest {
+		utils.LoggerInstance.Warn("The digest of the request of node of height %d does not match its content", node.Height)
+		return
+	}
+
+	hsMod.hsLock.Lock()
+	defer hsMod.hsLock.Unlock()
+
+	hsMod.receiveNode(&node)
+}
+
+// the leader collects the votes and make a QC with 2f+1 votes
+func (hsMod *HotStuffCosensusMod) handleVote(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle vote")
+
+	vote := VoteContent{}
+	err := utils.Decode(msg.Content, &vote)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the vote message")
+		return
+	}
+
+	if hsMod.nodeAttr.Nid != hsMod.view {
+		utils.LoggerInstance.Warn("Received a vote message, but this node is not the leader")
+		return
+	}
+
+	if !hsMod.checkSig(vote.NodeId, voteDigest(vote.View, vote.NodeHash), vote.Sig) {
+		utils.LoggerInstance.Warn("The signature of the vote from node %d is not valid", vote.NodeId)
+		return
+	}
+
+	hsMod.hsLock.Lock()
+	defer hsMod.hsLock.Unlock()
+
+	if vote.View <= hsMod.highQC.View {
+		utils.LoggerInstance.Debug("Received outdated vote of height %d, the highest QC is of height %d", vote.View, hsMod.highQC.View)
+		return
+	}
+
+	// the leader only certifies the nodes it has stored, so it can always extend the highest QC
+	if _, ok := hsMod.nodes[vote.NodeHash]; !ok {
+		utils.LoggerInstance.Warn("Received a vote of height %d for an unknown node", vote.View)
+		return
+	}
+
+	key := voteKey{View: vote.View, NodeHash: vote.NodeHash}
+	if hsMod.votes[key] == nil {
+		hsMod.votes[key] = make(map[int]*signature.Signature)
+	}
+	hsMod.votes[key][vote.NodeId] = vote.Sig
+
+	if len(hsMod.votes[key]) < 2*hsMod.malicious_num+1 {
+		return
+	}
+
+	signers := make([]int, 0, len(hsMod.votes[key]))
+	sigs := make([]*signature.Signature, 0, len(hsMod.votes[key]))
+	for nid, sig := range hsMod.votes[key] {
+		signers = append(signers, nid)
+		sigs = append(sigs, sig)
+	}
+	aggSig, err := signature.AggregateSignatures(sigs)
+	if err != nil {
+		utils.LoggerInstance.Error("Error aggregating the signatures, err: %v", err)
+		return
+	}
+	qc := &QuorumCert{
+		View:     vote.View,
+		NodeHash: vote.NodeHash,
+		Signers:  signers,
+		Sig:      aggSig,
+	}
+	// the votes of the certified node and of the lower nodes are outdated
+	for k := range hsMod.votes {
+		if k.View <= qc.View {
+			delete(hsMod.votes, k)
+		}
+	}
+
+	utils.LoggerInstance.Info("Get enough votes to make QC of height %d", qc.View)
+	hsMod.updateHighQC(qc)
+
+	select {
+	case hsMod.qcReady <- struct{}{}: // notify the leader to propose the next node
+	default:
+	}
+}
+
+// call with hsLock held, buffer the node if its parent is unknown, otherwise process it and its buffered children
+func (hsMod *HotStuffCosensusMod) receiveNode(node *HotStuffNode) {
+	nodeHash := node.Hash()
+	if _, ok := hsMod.nodes[nodeHash]; ok {
+		return
+	}
+	if node.Justify.NodeHash != node.ParentHash || node.Height <= hsMod.execNode.Height {
+		utils.LoggerInstance.Warn("Node of height %d does not extend its certified parent above the committed node", node.Height)
+		return
+	}
+
+	if _, ok := hsMod.nodes[node.ParentHash]; !ok {
+		utils.LoggerInstance.Debug("Received node of height %d before its parent, buffer it", node.Height)
+		hsMod.pending[node.ParentHash] = append(hsMod.pending[node.ParentHash], node)
+		return
+	}
+
+	if !hsMod.checkQC(node.Justify) {
+		utils.LoggerInstance.Warn("The QC carried by node of height %d is not valid", node.Height)
+		return
+	}
+
+	hsMod.nodes[nodeHash] = node
+	hsMod.onReceiveProposal(node)
+
+	children := hsMod.pending[nodeHash]
+	delete(hsMod.pending, nodeHash)
+	for _, child := range children {
+		hsMod.receiveNode(child)
+	}
+}
+
+// call with hsLock held, Algorithm 3 in the HotStuff paper
+func (hsMod *HotStuffCosensusMod) onReceiveProposal(node *HotStuffNode) {
+	if node.Height > hsMod.vheight && hsMod.safeNode(node) {
+		hsMod.vheight = node.Height
+		hsMod.sendVote(node)
+	} else {
+		utils.LoggerInstance.Warn("Node of height %d is not safe, do not vote for it", node.Height)
+	}
+
+	hsMod.update(node)
+}
+
+// call with hsLock held, the node extends the locked node, or it carries a QC higher than the locked node
+func (hsMod *HotStuffCosensusMod) safeNode(node *HotStuffNode) bool {
+	if node.Justify.View > hsMod.lockedNode.Height {
+		return true
+	}
+	return hsMod.extends(node, hsMod.lockedNode)
+}
+
+// call with hsLock held, whether the node is a descendant of the ancestor
+func (hsMod *HotStuffCosensusMod) extends(node *HotStuffNode, ancestor *HotStuffNode) bool {
+	ancestorHash := ancestor.Hash()
+	for cur := node; cur != nil; cur = hsMod.nodes[cur.ParentHash] {
+		if cur.Hash() == ancestorHash {
+			return true
+		}
+		if cur.Height <= ancestor.Height {
+			return false
+		}
+	}
+	return false
+}
+
+// call with hsLock held, the three-chain rule of chained HotStuff
+func (hsMod *HotStuffCosensusMod) update(node *HotStuffNode) {
+	b2 := hsMod.nodes[node.Justify.NodeHash] // b'' in the paper
+	if b2 == nil {
+		return
+	}
+	hsMod.updateHighQC(node.Justify)
+
+	b1 := hsMod.nodes[b2.Justify.NodeHash] // b' in the paper
+	if b1 == nil {
+		return
+	}
+	if b1.Height > hsMod.lockedNode.Height {
+		hsMod.lockedNode = b1
+	}
+
+	b0 := hsMod.nodes[b1.Justify.NodeHash] // b in the paper
+	if b0 == nil {
+		return
+	}
+	if b2.ParentHash == b1.Hash() && b1.ParentHash == b0.Hash() && b0.Height > hsMod.execNode.Height {
+		hsMod.onCommit(b0)
+		hsMod.execNode = b0
+		hsMod.prune()
+	}
+}
+
+// call with hsLock held, the nodes not higher than the committed node will never be visited again except the newest one,
+// neither will the buffered nodes of the abandoned forks
+func (hsMod *HotStuffCosensusMod) prune() {
+	execHash := hsMod.execNode.Hash()
+	for hash, node := range hsMod.nodes {
+		if node.Height <= hsMod.execNode.Height && hash != execHash {
+			delete(hsMod.nodes, hash)
+		}
+	}
+	for parentHash, children := range hsMod.pending {
+		kept := children[:0]
+		for _, child := range children {
+			if child.Height > hsMod.execNode.Height {
+				kept = append(kept, child)
+			}
+		}
+		if len(kept) == 0 {
+			delete(hsMod.pending, parentHash)
+		} else {
+			hsMod.pending[parentHash] = kept
+		}
+	}
+}
+
+// call with hsLock held
+func (hsMod *HotStuffCosensusMod) updateHighQC(qc *QuorumCert) {
+	if qc.View > hsMod.highQC.View {
+		hsMod.highQC = qc
+	}
+}
+
+// call with hsLock held, commit the node and all its uncommitted ancestors in order
+func (hsMod *HotStuffCosensusMod) onCommit(node *HotStuffNode) {
+	toCommit := make([]*HotStuffNode, 0)
+	for cur := node; cur != nil && cur.Height > hsMod.execNode.Height; cur = hsMod.nodes[cur.ParentHash] {
+		toCommit = append(toCommit, cur)
+	}
+
+	for i := len(toCommit) - 1; i >= 0; i-- {
+		cur := toCommit[i]
+		if cur.IsEmpty() {
+			continue
+		}
+
+		b := &structs.Block{}
+		err := utils.Decode(cur.Req.Content, b)
+		if err != nil {
+			utils.LoggerInstance.Error("Error decoding the block of node of height %d", cur.Height)
+			continue
+		}
+
+		bc := hsMod.nodeAttr.CurChain
+		bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+		bc.CommitBlock(b)
+		utils.LoggerInstance.Info("Commit the block of node of height %d with %d txs", cur.Height, len(b.Transactions))
+
+		if hsMod.nodeAttr.Nid == hsMod.view {
+			hsMod.sendReply(&cur.Req)
+		}
+	}
+}
+
+// call with hsLock held, sign the node and send the vote to the leader
+func (hsMod *HotStuffCosensusMod) sendVote(node *HotStuffNode) {
+	nodeHash := node.Hash()
+	vote := VoteContent{
+		View:     node.Height,
+		NodeHash: nodeHash,
+		NodeId:   hsMod.nodeAttr.Nid,
+		Sig:      signature.Sign(hsMod.nodeAttr.SecKey, voteDigest(node.Height, nodeHash)),
+	}
+	vmsg := message.Message{
+		MsgType: message.MsgVote,
+		Content: utils.Encode(vote),
+	}
+
+	if hsMod.nodeAttr.Nid == hsMod.view {
+		go hsMod.handleVote(&vmsg)
+		return
+	}
+	go hsMod.p2pMod.ConnMananger.Send(config.IPMap[hsMod.nodeAttr.Sid][hsMod.view], vmsg.JsonEncode())
+}
+
+// send the verified block back to the client, so that the measure mod can work
+func (hsMod *HotStuffCosensusMod) sendReply(req *message.Request) {
+	reply := &message.Reply{
+		Req:  req,
+		Time: time.Now(),
+
+		Sid:         hsMod.nodeAttr.Sid,
+		ReqQueueLen: hsMod.requestQueue.Size(),
+	}
+
+	replymsg := message.Message{
+		MsgType: message.MsgReply,
+		Content: utils.Encode(reply),
+	}
+
+	utils.LoggerInstance.Info("Send the reply message back to the client")
+	go hsMod.p2pMod.ConnMananger.Send(config.ClientAddr, replymsg.JsonEncode())
+}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (hsMod *HotStuffCosensusMod) RegisterHandlers() {
+	hsMod.p2pMod.RegisterHandler(message.MsgPropose, hsMod.handlePropose)
+	hsMod.p2pMod.RegisterHandler(message.MsgQC, hsMod.handleQC)
+	hsMod.p2pMod.RegisterHandler(message.MsgVote, hsMod.handleVote)
+}
+
+// Run starts the leader's loop, propose a new node each time the QC of the last node is formed
+func (hsMod *HotStuffCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if hsMod.nodeAttr.Nid != hsMod.view {
+		utils.LoggerInstance.Info("This node is not the leader, do not need to run the HotStuff leader loop")
+		return
+	}
+
+	utils.LoggerInstance.Info("Start the HotStuff consensus Mod")
+	for {
+		select {
+		case <-ctx.Done():
+			utils.LoggerInstance.Info("Stop the HotStuff consensus Mod")
+			return
+		default:
+			req, err := hsMod.requestQueue.Dequeue()
+			if err != nil {
+				// propose dummy nodes to commit the remaining nodes, otherwise wait for the next request
+				if !hsMod.hasUncommitted() {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				req = *message.NewRequest(hsMod.nodeAttr.Sid, message.ReqEmpty, nil)
+			}
+
+			node := hsMod.createLeaf(req)
+			if node == nil {
+				if req.ReqType != message.ReqEmpty {
+					hsMod.requestQueue.Enqueue(req)
+				}
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			generic := GenericContent{Node: *node, Sig: signature.Sign(hsMod.nodeAttr.SecKey, proposalDigest(node))}
+			gmsg := message.Message{
+				MsgType: message.MsgQC,
+				Content: utils.Encode(generic),
+			}
+			hsMod.p2pMod.ConnMananger.Broadcast(hsMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[hsMod.nodeAttr.Sid], hsMod.nodeAttr.Ipaddr), gmsg.JsonEncode())
+			utils.LoggerInstance.Info("Broadcast the proposal of height %d", node.Height)
+
+			// wait for the QC of the node before proposing the next one, if the votes are lost, propose again from the highest QC
+			select {
+			case <-hsMod.qcReady:
+			case <-time.After(time.Duration(config.ViewChangeTimeout) * time.Millisecond):
+				utils.LoggerInstance.Warn("The QC of height %d is not formed in time, propose again from the highest QC", node.Height)
+			case <-ctx.Done():
+				utils.LoggerInstance.Info("Stop the HotStuff consensus Mod")
+				return
+			}
+		}
+	}
+}
+
+// create a new node extending the node certified by the highest QC. The node is higher than all the proposed nodes,
+// so the replicas which voted for a node whose QC is not formed vote for it again.
+// The node is stored before it is broadcast, so the votes of the replicas never arrive before it
+func (hsMod *HotStuffCosensusMod) createLeaf(req message.Request) *HotStuffNode {
+	hsMod.hsLock.Lock()
+	defer hsMod.hsLock.Unlock()
+
+	parent := hsMod.nodes[hsMod.highQC.NodeHash]
+	if parent == nil {
+		utils.LoggerInstance.Error("The node certified by the highest QC of height %d is unknown", hsMod.highQC.View)
+		return nil
+	}
+	hsMod.proposed = max(hsMod.proposed, parent.Height) + 1
+	node := &HotStuffNode{
+		Height:     hsMod.proposed,
+		ParentHash: hsMod.highQC.NodeHash,
+		Req:        req,
+		Justify:    hsMod.highQC,
+	}
+	if !node.IsEmpty() {
+		hsMod.lastReqNode = node.Height
+	}
+	hsMod.receiveNode(node)
+	return node
+}
+
+// whether there are non-empty nodes proposed but not committed yet
+func (hsMod *HotStuffCosensusMod) hasUncommitted() bool {
+	hsMod.hsLock.Lock()
+	defer hsMod.hsLock.Unlock()
+	return hsMod.lastReqNode > hsMod.execNode.Height
+}
+
+// check the signature of a vote, the unknown nodes and keys are rejected
+func (hsMod *HotStuffCosensusMod) checkSig(nid int, msg []byte, sig *signature.Signature) bool {
+	return hsMod.nodeAttr.VerifySig(hsMod.nodeAttr.Sid, nid, msg, sig)
+}
+
+// check the aggregate signature of a QC, the genesis QC has no signature.
+// The signers must be 2f+1 distinct nodes of the shard with known keys, and the view is covered by the signature
+func (hsMod *HotStuffCosensusMod) checkQC(qc *QuorumCert) bool {
+	if qc == nil {
+		return false
+	}
+	if qc.Sig == nil {
+		return qc.View == 0 && qc.NodeHash == hsMod.genesis.Hash()
+	}
+	if len(qc.Signers) < 2*hsMod.malicious_num+1 {
+		return false
+	}
+	return hsMod.nodeAttr.VerifyAggregatedSig(hsMod.nodeAttr.Sid, qc.Signers, voteDigest(qc.View, qc.NodeHash), qc.Sig) == nil
+}
@@ -0,0 +1,227 @@
+package hotstuff
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the mod of the leader (node 0) in a shard of 4 nodes whose keys are all known, and the secret keys of the nodes
+func newTestMod(t *testing.T) (*HotStuffCosensusMod, []*signature.SecretKey) {
+	config.ViewNodeId = 0
+	config.ShardNum, config.NodeNum = 1, 4
+	attr, sks := testutil.NewAttr(t, 0, 0, 4)
+	return NewHotStuffCosensusMod(attr, nil).(*HotStuffCosensusMod), sks
+}
+
+// a child of the genesis node carrying a request, certified by the signers
+func certifiedChild(hsMod *HotStuffCosensusMod, sks []*signature.SecretKey, signers ...int) (*HotStuffNode, *QuorumCert) {
+	req := message.NewRequest(0, message.ReqVerifyString, []byte("block"))
+	node := &HotStuffNode{Height: 1, ParentHash: hsMod.genesis.Hash(), Req: *req, Justify: hsMod.genesis.Justify}
+	sigs := make([]*signature.Signature, 0, len(signers))
+	for _, nid := range signers {
+		sigs = append(sigs, signature.Sign(sks[nid], voteDigest(node.Height, node.Hash())))
+	}
+	aggSig, _ := signature.AggregateSignatures(sigs)
+	return node, &QuorumCert{View: node.Height, NodeHash: node.Hash(), Signers: signers, Sig: aggSig}
+}
+
+func TestCheckQC(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	assert.True(t, hsMod.checkQC(hsMod.genesis.Justify))
+
+	_, qc := certifiedChild(hsMod, sks, 0, 1, 2)
+	assert.True(t, hsMod.checkQC(qc))
+
+	_, qc = certifiedChild(hsMod, sks, 0, 1)
+	assert.False(t, hsMod.checkQC(qc), "less than 2f+1 signers")
+
+	// the leader signs 2f+1 times, or claims the nids out of the shard
+	_, qc = certifiedChild(hsMod, sks, 0, 0, 0)
+	assert.False(t, hsMod.checkQC(qc), "a signer repeated")
+	_, qc = certifiedChild(hsMod, sks, 0, 1, 2)
+	qc.Signers = []int{99, 100, 101}
+	assert.False(t, hsMod.checkQC(qc), "the signers out of the shard")
+
+	// the QC cannot claim a higher view to unlock the replicas
+	_, qc = certifiedChild(hsMod, sks, 0, 1, 2)
+	qc.View = 10
+	assert.False(t, hsMod.checkQC(qc), "the view is not the signed one")
+}
+
+func TestCheckQCUnknownKey(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	hsMod.nodeAttr.PubKeyTable[0][2] = nil
+	_, qc := certifiedChild(hsMod, sks, 0, 1, 2)
+	assert.False(t, hsMod.checkQC(qc))
+	assert.False(t, hsMod.checkSig(2, []byte("vote"), signature.Sign(sks[2], []byte("vote"))))
+}
+
+// a proposal carrying a forged QC is dropped, it is neither stored nor voted
+func TestForgedJustifyRejected(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	parent, _ := certifiedChild(hsMod, sks, 0, 1, 2)
+	_, forged := certifiedChild(hsMod, sks, 1, 1, 1)
+
+	hsMod.hsLock.Lock()
+	hsMod.nodes[parent.Hash()] = parent
+	child := &HotStuffNode{Height: 2, ParentHash: parent.Hash(), Req: message.Request{ReqType: message.ReqEmpty}, Justify: forged}
+	hsMod.receiveNode(child)
+	_, stored := hsMod.nodes[child.Hash()]
+	hsMod.hsLock.Unlock()
+
+	assert.False(t, stored)
+	assert.Equal(t, 0, hsMod.vheight)
+}
+
+func vote(sks []*signature.SecretKey, nid int, view int, nodeHash [32]byte) *message.Message {
+	v := VoteContent{View: view, NodeHash: nodeHash, NodeId: nid, Sig: signature.Sign(sks[nid], voteDigest(view, nodeHash))}
+	return &message.Message{MsgType: message.MsgVote, Content: utils.Encode(v)}
+}
+
+func TestLeaderAggregatesDistinctVotes(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	node, _ := certifiedChild(hsMod, sks, 0)
+	hash := node.Hash()
+	hsMod.nodes[hash] = node
+
+	// the repeated votes of a node, a vote signed by another node and the votes of a wrong view do not form a QC
+	hsMod.handleVote(vote(sks, 1, 1, hash))
+	hsMod.handleVote(vote(sks, 1, 1, hash))
+	forged := vote(sks, 3, 1, hash)
+	v := VoteContent{}
+	require.NoError(t, utils.Decode(forged.Content, &v))
+	v.NodeId = 2
+	hsMod.handleVote(&message.Message{MsgType: message.MsgVote, Content: utils.Encode(v)})
+	hsMod.handleVote(vote(sks, 3, 5, hash))
+	assert.Equal(t, 0, hsMod.highQC.View)
+
+	hsMod.handleVote(vote(sks, 2, 1, hash))
+	hsMod.handleVote(vote(sks, 0, 1, hash))
+	require.Equal(t, 1, hsMod.highQC.View)
+	assert.ElementsMatch(t, []int{0, 1, 2}, hsMod.highQC.Signers)
+	assert.True(t, hsMod.checkQC(hsMod.highQC))
+}
+
+func proposalMsg(sks []*signature.SecretKey, signer int, node *HotStuffNode) *message.Message {
+	generic := GenericContent{Node: *node, Sig: signature.Sign(sks[signer], proposalDigest(node))}
+	return &message.Message{MsgType: message.MsgQC, Content: utils.Encode(generic)}
+}
+
+// only the proposals signed by the leader whose request matches its digest are stored
+func TestProposalSignedByLeader(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	node, _ := certifiedChild(hsMod, sks)
+	stored := func(node *HotStuffNode) bool {
+		hsMod.hsLock.Lock()
+		defer hsMod.hsLock.Unlock()
+		_, ok := hsMod.nodes[node.Hash()]
+		return ok
+	}
+
+	hsMod.handleQC(proposalMsg(sks, 1, node))
+	assert.False(t, stored(node), "node 1 is not the leader")
+
+	swapped := *node
+	swapped.Req.Content = []byte("another block")
+	hsMod.handleQC(proposalMsg(sks, 0, &swapped))
+	assert.False(t, stored(&swapped), "the content does not match the digest")
+	assert.NotEqual(t, node.Hash(), swapped.Hash(), "the content is hashed with the node")
+
+	hsMod.handleQC(proposalMsg(sks, 0, node))
+	assert.True(t, stored(node))
+}
+
+// the votes of the lower nodes are dropped once a QC forms, and the leader proposes above the node whose QC is not formed
+func TestVotesPrunedAndLeafAboveLostNode(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	lost := hsMod.createLeaf(*message.NewRequest(0, message.ReqEmpty, nil))
+	hsMod.handleVote(vote(sks, 1, lost.Height, lost.Hash()))
+	require.Len(t, hsMod.votes, 1)
+
+	next := hsMod.createLeaf(*message.NewRequest(0, message.ReqEmpty, nil))
+	assert.Equal(t, lost.ParentHash, next.ParentHash, "both extend the highest QC")
+	assert.Equal(t, lost.Height+1, next.Height)
+	for _, nid := range []int{0, 1, 2} {
+		hsMod.handleVote(vote(sks, nid, next.Height, next.Hash()))
+	}
+	assert.Equal(t, next.Height, hsMod.highQC.View)
+	assert.Empty(t, hsMod.votes)
+}
+
+// the leader stores its node before broadcasting it, so the QC of the node can always be extended
+func TestLeaderStoresLeafBeforeVotes(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	node := hsMod.createLeaf(*message.NewRequest(0, message.ReqEmpty, nil))
+	for _, nid := range []int{1, 2, 3} {
+		hsMod.handleVote(vote(sks, nid, node.Height, node.Hash()))
+	}
+	require.Equal(t, node.Height, hsMod.highQC.View)
+
+	next := hsMod.createLeaf(*message.NewRequest(0, message.ReqEmpty, nil))
+	require.NotNil(t, next)
+	assert.Equal(t, node.Hash(), next.ParentHash)
+
+	// the votes for a node the leader never stored do not form a QC
+	unknown, _ := certifiedChild(hsMod, sks)
+	for _, nid := range []int{1, 2, 3} {
+		hsMod.handleVote(vote(sks, nid, 10, unknown.Hash()))
+	}
+	assert.Equal(t, node.Height, hsMod.highQC.View)
+}
+
+// a proposal whose QC certifies another node than its parent is dropped
+func TestJustifyNotParentRejected(t *testing.T) {
+	hsMod, sks := newTestMod(t)
+	parent, qc := certifiedChild(hsMod, sks, 0, 1, 2)
+	hsMod.handleQC(proposalMsg(sks, 0, parent))
+
+	child := &HotStuffNode{Height: 2, ParentHash: hsMod.genesis.Hash(), Req: message.Request{ReqType: message.ReqEmpty}, Justify: qc}
+	hsMod.handleQC(proposalMsg(sks, 0, child))
+
+	hsMod.hsLock.Lock()
+	defer hsMod.hsLock.Unlock()
+	_, stored := hsMod.nodes[child.Hash()]
+	assert.False(t, stored)
+	hsMod.receiveNode(child)
+	_, stored = hsMod.nodes[child.Hash()]
+	assert.False(t, stored)
+}
+
+// the committed ancestors, the abandoned forks and the buffered nodes below the committed node are pruned
+func TestPruneBelowCommitted(t *testing.T) {
+	hsMod, _ := newTestMod(t)
+	hsMod.nodeAttr.Nid = 1 // a replica, whose votes are not handled locally
+	hsMod.p2pMod = nil
+
+	hsMod.hsLock.Lock()
+	defer hsMod.hsLock.Unlock()
+	chain := []*HotStuffNode{hsMod.genesis}
+	fork := &HotStuffNode{Height: 1, ParentHash: [32]byte{1}, Justify: &QuorumCert{View: 1}}
+	stale := &HotStuffNode{Height: 1, ParentHash: [32]byte{2}}
+	hsMod.nodes[fork.Hash()] = fork
+	hsMod.pending[stale.ParentHash] = []*HotStuffNode{stale}
+	for height := 1; height <= 4; height++ {
+		parent := chain[len(chain)-1]
+		node := &HotStuffNode{Height: height, ParentHash: parent.Hash(), Req: message.Request{ReqType: message.ReqEmpty},
+			Justify: &QuorumCert{View: parent.Height, NodeHash: parent.Hash()}}
+		hsMod.nodes[node.Hash()] = node
+		chain = append(chain, node)
+	}
+	hsMod.update(chain[4])
+
+	require.Equal(t, chain[1], hsMod.execNode)
+	for _, node := range []*HotStuffNode{hsMod.genesis, fork} {
+		assert.NotContains(t, hsMod.nodes, node.Hash())
+	}
+	for _, node := range chain[1:] {
+		assert.Contains(t, hsMod.nodes, node.Hash())
+	}
+	assert.Empty(t, hsMod.pending)
+}
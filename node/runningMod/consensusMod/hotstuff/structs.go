@@ -0,0 +1,72 @@
+package hotstuff
+
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+)
+
+// a node in the HotStuff tree, each node carries one request(a block in this implementation)
+type HotStuffNode struct {
+	Height     int             // the height of the node in the tree, also used as the view number of the node
+	ParentHash [32]byte        // the hash of the parent node
+	Req        message.Request // the request proposed in this node, ReqEmpty means a dummy node
+	Justify    *QuorumCert     // the QC of the parent node
+}
+
+// QuorumCert is the aggregated signature of 2f+1 votes on a node
+type QuorumCert struct {
+	View     int                  // the height of the certified node
+	NodeHash [32]byte             // the hash of the certified node
+	Signers  []int                // the nids of the voters
+	Sig      *signature.Signature // the aggregate signature of the votes, nil for the genesis QC
+}
+
+// the vote of a replica, sent to the leader only
+type VoteContent struct {
+	View     int
+	NodeHash [32]byte
+	NodeId   int
+	Sig      *signature.Signature
+}
+
+// the content signed in a vote, the height of the node is signed with its hash, so a QC cannot claim another view
+func voteDigest(view int, nodeHash [32]byte) []byte {
+	return utils.CanonicalEncode(struct {
+		View     int
+		NodeHash [32]byte
+	}{view, nodeHash})
+}
+
+// the generic message in chained HotStuff, the leader's proposal always carries the QC of its parent
+type GenericContent struct {
+	Node HotStuffNode
+	Sig  *signature.Signature // the signature of the leader on proposalDigest
+}
+
+// the content signed by the leader in a proposal, the QC carried by the node is signed with its hash
+func proposalDigest(node *HotStuffNode) []byte {
+	return utils.CanonicalEncode(struct {
+		NodeHash    [32]byte
+		JustifyView int
+		JustifyHash [32]byte
+	}{node.Hash(), node.Justify.View, node.Justify.NodeHash})
+}
+
+// the hash of the node, the Justify is not included. The content of the request is hashed as well,
+// so a proposal cannot carry another payload under the hash of the certified node
+func (node *HotStuffNode) Hash() [32]byte {
+	content := struct {
+		Height        int
+		ParentHash    [32]byte
+		Digest        [32]byte
+		ContentDigest [32]byte
+	}{node.Height, node.ParentHash, node.Req.Digest, sha256.Sum256(node.Req.Content)}
+	return sha256.Sum256(utils.CanonicalEncode(content))
+}
+
+// whether the node is a dummy node generated by the leader to push the chain forward
+func (node *HotStuffNode) IsEmpty() bool {
+	return node.Req.ReqType == message.ReqEmpty
+}
@@ -0,0 +1,134 @@
+package pbft
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/runningMod/consensusMod/cshard"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+)
+
+var _ PbftAddon = &PbftCShardAddon{}
+var _ PbftAddonHandlers = &PbftCShardAddon{}
+
+// implement the origin shards of CShard, the txs are sent to the origin shard of the sender.
+// The committed block is sent to the leaders of all the shards with its commit certificate for the input verification and coding,
+// see cshard/handler_cshard.go. The reply is sent to the client by LL when the block is legal
+type PbftCShardAddon struct {
+	pbftMod *PbftCosensusMod // the belonging pbft module
+
+	round int64 // the number of executed blocks, the blocks of the same round in the K origin shards are coded together
+}
+
+func NewCShardPbftCosensusAddon(pbftMod *PbftCosensusMod) PbftAddon {
+	return &PbftCShardAddon{
+		pbftMod: pbftMod,
+	}
+}
+
+// no more things to do
+func (addon *PbftCShardAddon) HandleProposeAddon(req *message.Request) bool {
+	return true
+}
+
+// verify the request, the states are updated when the block is executed, in the order of the rounds
+func (addon *PbftCShardAddon) HandlePrePrepareAddon(req *message.Request) bool {
+	b := &structs.Block{}
+	err := utils.Decode(req.Content, b)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the block")
+		return false
+	}
+
+	if string(blockchain.GetTxTreeRoot(b.Transactions)) != string(b.Header.TxRoot) {
+		utils.LoggerInstance.Warn("the transaction root is wrong, reject the block")
+		return false
+	}
+	return true
+}
+
+// no more things to do
+func (addon *PbftCShardAddon) HandlePrepareAddon(req *message.Request) bool {
+	return true
+}
+
+// execute the block, and the primary pre-injects it to the leaders of all the shards
+func (addon *PbftCShardAddon) HandleCommitAddon(req *message.Request) bool {
+	b := &structs.Block{}
+	err := utils.Decode(req.Content, b)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the block")
+		return false
+	}
+
+	bc := addon.pbftMod.nodeAttr.CurChain
+	bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+	bc.CommitBlock(b)
+	// the height is not used as the round, several blocks of the same height may be in flight in the pipeline
+	addon.round++
+
+	// the caller executes the round under execLock, its commit certificate is the proof of the block
+	cert := addon.pbftMod.commitCerts[addon.pbftMod.getCurrentRound()]
+	if addon.pbftMod.isPrimary() && cert != nil {
+		pre := cshard.PreInjectContent{
+			Round:       addon.round,
+			Sid:         addon.pbftMod.nodeAttr.Sid,
+			Req:         req,
+			ReqQueueLen: addon.pbftMod.pending.size(),
+			PbftRound:   cert.Round,
+			View:        cert.View,
+			Signers:     cert.Signers,
+			Sig:         cert.Sig,
+		}
+		msg := message.Message{
+			MsgType: message.MsgPreInject,
+			Content: utils.Encode(pre),
+		}
+		msgBytes := msg.JsonEncode()
+		for sid := 0; sid < config.ShardNum; sid++ {
+			addon.pbftMod.p2pMod.ConnMananger.Send(config.IPMap[sid][config.ViewNodeId], msgBytes)
+		}
+		utils.LoggerInstance.Info("Pre-inject the block of round %d to the leaders of all the shards", pre.Round)
+	}
+
+	return true
+}
+
+// the leaders receive the blocks of the origin shards, a block is passed to the CShard mod only if it is committed in its shard
+func (addon *PbftCShardAddon) RegisterHandlers() {
+	addon.pbftMod.p2pMod.RegisterHandler(message.MsgPreInject, addon.handlePreInject)
+}
+
+func (addon *PbftCShardAddon) handlePreInject(msg *message.Message) {
+	pre := cshard.PreInjectContent{}
+	err := utils.Decode(msg.Content, &pre)
+	if err != nil || pre.Req == nil {
+		utils.LoggerInstance.Error("Error decoding the pre-inject message")
+		return
+	}
+	if pre.Sid < 0 || pre.Sid >= config.K {
+		utils.LoggerInstance.Warn("Shard %d is not an origin shard", pre.Sid)
+		return
+	}
+	if !addon.checkCommitProof(&pre) {
+		utils.LoggerInstance.Warn("The block of round %d from shard %d is not proved to be committed", pre.Round, pre.Sid)
+		return
+	}
+
+	if handler, ok := addon.pbftMod.p2pMod.LocalHandler(message.MsgPreInject); ok {
+		handler(msg)
+	}
+}
+
+// the request is committed in the origin shard if 2f+1 nodes of the shard send the commit messages of it, like checkCommitCert
+func (addon *PbftCShardAddon) checkCommitProof(pre *cshard.PreInjectContent) bool {
+	digest := pre.Req.Digest
+	req := *pre.Req
+	req.CalDigest()
+	if req.Digest != digest || len(pre.Signers) < 2*addon.pbftMod.malicious_num+1 {
+		return false
+	}
+	content := pbftMessageContent(message.MsgCommit, addon.pbftMod.epoch, addon.pbftMod.instance, pre.PbftRound, pre.View, digest)
+	return addon.pbftMod.nodeAttr.VerifyAggregatedSig(pre.Sid, pre.Signers, content, pre.Sig) == nil
+}
@@ -0,0 +1,51 @@
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/runningMod/consensusMod/cshard"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the pre-inject message of the request committed in round 3 of shard 0, signed by the signers
+func preInjectMsg(t *testing.T, keys *testutil.Keys, req message.Request, signers []int) *message.Message {
+	content := pbftMessageContent(message.MsgCommit, 0, 0, 3, 0, req.Digest)
+	sigs := make([]*signature.Signature, 0, len(signers))
+	for _, nid := range signers {
+		sigs = append(sigs, signature.Sign(keys.Sks[nid], content))
+	}
+	agg, err := signature.AggregateSignatures(sigs)
+	require.NoError(t, err)
+	pre := cshard.PreInjectContent{Round: 1, Sid: 0, Req: &req, PbftRound: 3, Signers: signers, Sig: agg}
+	return &message.Message{MsgType: message.MsgPreInject, Content: utils.Encode(pre)}
+}
+
+// the block of an origin shard is passed to the CShard mod only with the commit messages of 2f+1 nodes
+func TestPreInjectNeedsCommitProof(t *testing.T) {
+	keys := newTestKeys()
+	config.K = 1
+	pbftMod, _ := newTestMod(t, 1, keys)
+	addon := NewCShardPbftCosensusAddon(pbftMod).(*PbftCShardAddon)
+	accepted := 0
+	pbftMod.p2pMod.RegisterLocalHandler(message.MsgPreInject, func(msg *message.Message) { accepted++ })
+	req := blockRequest()
+
+	addon.handlePreInject(preInjectMsg(t, keys, req, []int{0, 1}))
+	assert.Equal(t, 0, accepted, "the commit messages of 2 nodes are not enough")
+
+	forged := preInjectMsg(t, keys, req, []int{0, 1, 2})
+	pre := cshard.PreInjectContent{}
+	require.NoError(t, utils.Decode(forged.Content, &pre))
+	pre.Req.Content = []byte("forged")
+	addon.handlePreInject(&message.Message{MsgType: message.MsgPreInject, Content: utils.Encode(pre)})
+	assert.Equal(t, 0, accepted, "the block is not the committed one")
+
+	addon.handlePreInject(preInjectMsg(t, keys, req, []int{0, 1, 2}))
+	assert.Equal(t, 1, accepted)
+}
@@ -13,11 +13,21 @@ type PbftAddon interface {
 	HandleCommitAddon(req *message.Request) bool     // usually used by some method to split the request and send to other shards
 }
 
+// implemented by the addons handling the messages other than the PBFT messages, registered with the handlers of the pbft module
+type PbftAddonHandlers interface {
+	RegisterHandlers()
+}
+
 const (
 	TestAddon   = "Test"
 	SimpleAddon = "Simple"
 	// add more addon type here
-	TBDAddon = "TBD"
+	TBDAddon      = "TBD"
+	MonoxideAddon = "Monoxide"
+	CShardAddon   = "CShard"
+	CLPAAddon     = "CLPA"     // Monoxide with the account migration
+	ReconfigAddon = "Reconfig" // Monoxide with the committee reconfiguration, see reconfig.go
+	MirAddon      = "MirBFT"   // the instances of the multi-leader mode, the batches are executed by mir.go
 )
 
 var addonRegistry = make(map[string]func(pbftMod *PbftCosensusMod) PbftAddon)
@@ -28,6 +38,11 @@ func init() {
 
 	// init more addon type here
 	addonRegistry[TBDAddon] = NewTBDPbftCosensusAddon
+	addonRegistry[MonoxideAddon] = NewMonoxidePbftCosensusAddon
+	addonRegistry[CShardAddon] = NewCShardPbftCosensusAddon
+	addonRegistry[CLPAAddon] = NewMonoxidePbftCosensusAddon
+	addonRegistry[ReconfigAddon] = NewMonoxidePbftCosensusAddon
+	addonRegistry[MirAddon] = NewSimplePbftCosensusAddon
 }
 
 func NewPbftAddon(addonType string, pbftMod *PbftCosensusMod) (PbftAddon, error) {
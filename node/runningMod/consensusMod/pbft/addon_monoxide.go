@@ -0,0 +1,129 @@
+package pbft
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"time"
+)
+
+var _ PbftAddon = &PbftMonoxideAddon{}
+
+// implement Monoxide method, the txs are sent to the shard of the sender.
+// A cross-shard tx is split into two halves, the shard of the sender deducts the value when the block is committed,
+// and emits a relay tx to the shard of the recipient, which credits the value in a later block.
+// The txs emitted to other shards are sent in receipts signed by the nodes of the shard, see addon_monoxide_receipt.go.
+// The accounts can be migrated between the shards at the epoch boundaries, see addon_monoxide_migration.go
+type PbftMonoxideAddon struct {
+	pbftMod *PbftCosensusMod // the belonging pbft module
+
+	incoming map[string]bool // the accounts moved to this shard whose states have not arrived yet
+	receipts *receiptRecords // the receipts of the txs from the shards
+}
+
+func NewMonoxidePbftCosensusAddon(pbftMod *PbftCosensusMod) PbftAddon {
+	return &PbftMonoxideAddon{
+		pbftMod:  pbftMod,
+		incoming: make(map[string]bool),
+		receipts: newReceiptRecords(),
+	}
+}
+
+func (addon *PbftMonoxideAddon) RegisterHandlers() {
+	addon.pbftMod.p2pMod.RegisterHandler(message.MsgShardTxs, addon.handleShardTxs)
+}
+
+// no more things to do
+func (addon *PbftMonoxideAddon) HandleProposeAddon(req *message.Request) bool {
+	return true
+}
+
+// verify the request, the states are updated when the block is executed, in the order of the rounds
+func (addon *PbftMonoxideAddon) HandlePrePrepareAddon(req *message.Request) bool {
+	b := &structs.Block{}
+	err := utils.Decode(req.Content, b)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the block")
+		return false
+	}
+
+	if string(blockchain.GetTxTreeRoot(b.Transactions)) != string(b.Header.TxRoot) {
+		utils.LoggerInstance.Warn("the transaction root is wrong, reject the block")
+		return false
+	}
+	for _, tx := range b.Transactions {
+		if receipt, ok := tx.(*structs.ReceiptTransaction); ok && !addon.checkReceipt(receipt) {
+			utils.LoggerInstance.Warn("the receipt of the txs from shard %d is not valid, reject the block", receipt.SourceShard)
+			return false
+		}
+	}
+	return true
+}
+
+// no more things to do
+func (addon *PbftMonoxideAddon) HandlePrepareAddon(req *message.Request) bool {
+	return true
+}
+
+// execute the block, and emit the relay txs of the cross-shard txs
+func (addon *PbftMonoxideAddon) HandleCommitAddon(req *message.Request) bool {
+	b := &structs.Block{}
+	err := utils.Decode(req.Content, b)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the block")
+		return false
+	}
+
+	bc := addon.pbftMod.nodeAttr.CurChain
+	round := addon.pbftMod.getCurrentRound()      // the rounds are executed in order, the current round is the one of the block
+	outbox := make(map[int][]structs.Transaction) // key: the destination sid, value: the txs emitted by the block
+
+	// the txs of the accounts not in this shard are forwarded, and the mapping is changed after the block is executed
+	txs := addon.routeTxs(addon.openReceipts(b.Transactions), outbox)
+	bc.StateManager.UpdateStates(txs, bc.CurrentBlock.Header.StateRoot)
+	addon.relayTxs(txs, outbox)
+	addon.applyPartitions(b.Transactions, outbox)
+	bc.CommitBlock(b)
+
+	// every node sends the txs emitted by the block, the destination shard executes them once
+	addon.sendTxs(round, outbox)
+
+	// only the primary sends the reply
+	if addon.pbftMod.isPrimary() {
+		reply := &message.Reply{
+			Req:  req,
+			Time: time.Now(),
+
+			Sid:         addon.pbftMod.nodeAttr.Sid,
+			ReqQueueLen: addon.pbftMod.pending.size(),
+		}
+
+		replaymsg := message.Message{
+			MsgType: message.MsgReply,
+			Content: utils.Encode(reply),
+		}
+
+		utils.LoggerInstance.Info("Send the reply message back to the client")
+		addon.pbftMod.p2pMod.ConnMananger.Send(config.ClientAddr, replaymsg.JsonEncode())
+	}
+
+	return true
+}
+
+// collect the relay txs of the committed cross-shard txs, sent to the shards of the recipients
+func (addon *PbftMonoxideAddon) relayTxs(txs []structs.Transaction, outbox map[int][]structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	for _, tx := range txs {
+		acTx, ok := tx.(*structs.AccountTransaction)
+		if !ok || acTx.Relayed || acTx.Sender == "" || acTx.Recipient == "" {
+			continue
+		}
+		destSid := utils.Addr2Shard(acTx.Recipient)
+		if utils.Addr2Shard(acTx.Sender) != sid || destSid == sid {
+			continue
+		}
+		outbox[destSid] = append(outbox[destSid], structs.NewRelayTransaction(acTx))
+	}
+}
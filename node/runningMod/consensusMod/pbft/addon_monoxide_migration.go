@@ -0,0 +1,100 @@
+// This file contains the account migration of Monoxide, used when the accounts are allocated by the lookup table(utils.TableAlloc).
+// The client sends a partition tx of each epoch to every shard. When a shard executes it, the accounts moved out are exported
+// and sent to their new shards by migrate txs, and the lookup table is updated. The new shard waits for the states of the accounts
+// moved in, the txs of these accounts are deferred until the migrate txs are executed. The txs arriving at a shard which does not
+// hold the account any more are forwarded to the current shard of the account, so the client may route the txs by a stale mapping
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"sort"
+)
+
+// install the migrated accounts, and pick the txs executed by this shard. The txs of the accounts in other shards are forwarded,
+// and the txs of the accounts whose states have not arrived are sent back to this shard
+func (addon *PbftMonoxideAddon) routeTxs(txs []structs.Transaction, outbox map[int][]structs.Transaction) []structs.Transaction {
+	if config.ShardAllocMethod != utils.TableAlloc {
+		return txs
+	}
+	sid := addon.pbftMod.nodeAttr.Sid
+	bc := addon.pbftMod.nodeAttr.CurChain
+
+	for _, tx := range txs {
+		if mTx, ok := tx.(*structs.MigrationTransaction); ok && mTx.Phase == structs.MigrationPhaseMigrate && mTx.NewShard == sid {
+			bc.StateManager.MigrateIn(mTx.Account, mTx.State)
+			utils.CurrentShardTable().Set(mTx.Account, sid)
+			delete(addon.incoming, mTx.Account)
+		}
+	}
+
+	own := make([]structs.Transaction, 0, len(txs))
+	for _, tx := range txs {
+		acTx, ok := tx.(*structs.AccountTransaction)
+		if !ok || acTx.Sender == "" || acTx.Recipient == "" {
+			own = append(own, tx)
+			continue
+		}
+		// the relay tx is executed by the shard of the recipient, the others by the shard of the sender
+		owner := acTx.Sender
+		if acTx.Relayed {
+			owner = acTx.FinalRecipient
+		}
+		if destSid := utils.Addr2Shard(owner); destSid != sid {
+			outbox[destSid] = append(outbox[destSid], tx)
+			continue
+		}
+		if addon.incoming[owner] || (!acTx.Relayed && addon.incoming[acTx.Recipient]) {
+			outbox[sid] = append(outbox[sid], tx)
+			continue
+		}
+		own = append(own, tx)
+	}
+	return own
+}
+
+// apply the partition txs after the block is executed, the accounts moved out are sent to their new shards
+func (addon *PbftMonoxideAddon) applyPartitions(txs []structs.Transaction, outbox map[int][]structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	bc := addon.pbftMod.nodeAttr.CurChain
+	table := utils.CurrentShardTable()
+
+	for _, tx := range txs {
+		mTx, ok := tx.(*structs.MigrationTransaction)
+		if !ok || mTx.Phase != structs.MigrationPhasePartition {
+			continue
+		}
+		if config.ShardAllocMethod != utils.TableAlloc {
+			utils.LoggerInstance.Warn("The accounts are not allocated by the lookup table, ignore the partition of epoch %d", mTx.Epoch)
+			continue
+		}
+		if mTx.Epoch <= table.Epoch() {
+			// the partition tx is proposed again
+			continue
+		}
+
+		// every node of the shard emits the same migrate txs in the same order, so that the receipts of the nodes match
+		addrs := make([]structs.Address, 0, len(mTx.Moves))
+		for addr := range mTx.Moves {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+
+		out, in := 0, 0
+		for _, addr := range addrs {
+			newSid := mTx.Moves[addr]
+			oldSid := table.Shard(addr)
+			if oldSid == sid && newSid != sid {
+				state := bc.StateManager.MigrateOut(addr, bc.CurrentBlock.Header.StateRoot)
+				outbox[newSid] = append(outbox[newSid], structs.NewMigrateTransaction(mTx.Epoch, addr, sid, newSid, state, mTx.Time))
+				out++
+			} else if oldSid != sid && newSid == sid {
+				addon.incoming[addr] = true
+				in++
+			}
+		}
+		table.Apply(mTx.Epoch, mTx.Moves)
+		utils.LoggerInstance.Info("Apply the partition of epoch %d, %d accounts moved out, %d accounts moved in", mTx.Epoch, out, in)
+	}
+}
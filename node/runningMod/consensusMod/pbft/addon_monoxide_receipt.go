@@ -0,0 +1,235 @@
+// This file contains the txs sent between the shards in Monoxide.
+// Every node of the shard signs the txs emitted by a committed block and sends them to every node of the destination shard,
+// so the txs are not lost with a crashed primary. A node of the destination shard waits for f+1 matching shares, at least one of them
+// from an honest node, and injects the receipt carrying their aggregate signature into its own TxPool. The receipts are verified at
+// pre-prepare, and executed once for every block of the source shard: the same txs are received by every node, and the receipts of
+// the nodes differ in the signers only. The receipts older than receiptWindow rounds are rejected, so the records are bounded
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+	"sync"
+)
+
+const receiptWindow = 1000 // (rounds) the receipts more than receiptWindow rounds before the latest one of the source shard are rejected
+
+// the share of a node of the source shard on the txs emitted by a committed block
+type ShardTxsShare struct {
+	SourceShard int
+	DestShard   int
+	Epoch       int
+	Round       int
+	Txs         []structs.Transaction
+	NodeId      int                  // the sender in the source shard
+	Sig         *signature.Signature // the signature of the sender on structs.ReceiptContent
+}
+
+// the block of the source shard emitting the txs
+type receiptKey struct {
+	Sid   int
+	Epoch int
+	Round int
+}
+
+// the shares of a block received from the nodes of the source shard, the shares may differ if the sender is malicious
+type receiptShares struct {
+	txs  map[[32]byte][]structs.Transaction        // digest of the content -> the txs
+	sigs map[[32]byte]map[int]*signature.Signature // digest of the content -> nid -> the signature of the node
+}
+
+// the receipts collected and executed by this node, keyed by the block of the source shard
+type receiptRecords struct {
+	shares    map[receiptKey]*receiptShares // the blocks with less than f+1 matching shares
+	collected map[receiptKey]bool           // the blocks whose receipts are injected into the TxPool
+	executed  map[receiptKey]bool           // the blocks whose receipts are executed, updated in the commit order
+	latest    map[int]receiptKey            // sid -> the latest block of the source shard whose receipt is executed
+	lock      sync.Mutex
+}
+
+func newReceiptRecords() *receiptRecords {
+	return &receiptRecords{
+		shares:    make(map[receiptKey]*receiptShares),
+		collected: make(map[receiptKey]bool),
+		executed:  make(map[receiptKey]bool),
+		latest:    make(map[int]receiptKey),
+	}
+}
+
+// whether the block of the key is too old compared to the latest block of the source shard, the blocks of the last epoch are kept
+func (records *receiptRecords) isStale(key receiptKey) bool {
+	latest, ok := records.latest[key.Sid]
+	if !ok {
+		return false
+	}
+	return key.Epoch < latest.Epoch-1 || (key.Epoch == latest.Epoch && key.Round < latest.Round-receiptWindow)
+}
+
+// forget the records of the blocks too old
+func (records *receiptRecords) prune() {
+	for key := range records.shares {
+		if records.isStale(key) {
+			delete(records.shares, key)
+		}
+	}
+	for key := range records.collected {
+		if records.isStale(key) {
+			delete(records.collected, key)
+		}
+	}
+	for key := range records.executed {
+		if records.isStale(key) {
+			delete(records.executed, key)
+		}
+	}
+}
+
+// sign the txs emitted by the block of the round and send them to every node of the destination shards, including this shard
+func (addon *PbftMonoxideAddon) sendTxs(round int, outbox map[int][]structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	for destSid, txs := range outbox {
+		content := structs.ReceiptContent(sid, destSid, addon.pbftMod.epoch, round, txs)
+		share := ShardTxsShare{
+			SourceShard: sid,
+			DestShard:   destSid,
+			Epoch:       addon.pbftMod.epoch,
+			Round:       round,
+			Txs:         txs,
+			NodeId:      addon.pbftMod.nodeAttr.Nid,
+			Sig:         signature.Sign(addon.pbftMod.nodeAttr.SecKey, content),
+		}
+		msg := message.Message{
+			MsgType: message.MsgShardTxs,
+			Content: utils.Encode(share),
+		}
+		msgBytes := msg.JsonEncode()
+		for _, addr := range config.IPMap[destSid] {
+			addon.pbftMod.p2pMod.ConnMananger.Send(addr, msgBytes)
+		}
+		utils.LoggerInstance.Info("Send %d txs of round %d to shard %d", len(txs), round, destSid)
+	}
+}
+
+// collect the shares of the nodes of the source shard, inject the receipt once f+1 of them sign the same txs
+func (addon *PbftMonoxideAddon) handleShardTxs(msg *message.Message) {
+	share := ShardTxsShare{}
+	if err := utils.Decode(msg.Content, &share); err != nil {
+		utils.LoggerInstance.Error("Error decoding the txs from another shard")
+		return
+	}
+	if share.DestShard != addon.pbftMod.nodeAttr.Sid {
+		return
+	}
+	content := structs.ReceiptContent(share.SourceShard, share.DestShard, share.Epoch, share.Round, share.Txs)
+	if !addon.pbftMod.nodeAttr.VerifySig(share.SourceShard, share.NodeId, content, share.Sig) {
+		utils.LoggerInstance.Warn("The signature of the txs from node %d in shard %d is not valid", share.NodeId, share.SourceShard)
+		return
+	}
+
+	key := receiptKey{Sid: share.SourceShard, Epoch: share.Epoch, Round: share.Round}
+	digest := sha256.Sum256(content)
+	records := addon.receipts
+	records.lock.Lock()
+	if records.collected[key] || records.executed[key] || records.isStale(key) {
+		records.lock.Unlock()
+		return
+	}
+	shares, ok := records.shares[key]
+	if !ok {
+		shares = &receiptShares{txs: make(map[[32]byte][]structs.Transaction), sigs: make(map[[32]byte]map[int]*signature.Signature)}
+		records.shares[key] = shares
+	}
+	if shares.sigs[digest] == nil {
+		shares.txs[digest] = share.Txs
+		shares.sigs[digest] = make(map[int]*signature.Signature)
+	}
+	shares.sigs[digest][share.NodeId] = share.Sig
+	if len(shares.sigs[digest]) < addon.pbftMod.malicious_num+1 {
+		records.lock.Unlock()
+		return
+	}
+	records.collected[key] = true
+	delete(records.shares, key)
+	records.lock.Unlock()
+
+	signers := make([]int, 0, len(shares.sigs[digest]))
+	sigs := make([]*signature.Signature, 0, len(shares.sigs[digest]))
+	for nid, sig := range shares.sigs[digest] {
+		signers = append(signers, nid)
+		sigs = append(sigs, sig)
+	}
+	aggSig, err := signature.AggregateSignatures(sigs)
+	if err != nil {
+		utils.LoggerInstance.Error("Error aggregating the signatures of the txs from shard %d", share.SourceShard)
+		return
+	}
+	receipt := structs.NewReceiptTransaction(share.SourceShard, share.DestShard, share.Epoch, share.Round, shares.txs[digest], signers, aggSig)
+
+	handler, ok := addon.pbftMod.p2pMod.Handler(message.MsgInject)
+	if !ok {
+		utils.LoggerInstance.Error("No handler to inject the txs from shard %d", share.SourceShard)
+		return
+	}
+	handler(&message.Message{MsgType: message.MsgInject, Content: utils.Encode([]structs.Transaction{receipt})})
+}
+
+// whether the receipt is signed by f+1 nodes of the source shard
+func (addon *PbftMonoxideAddon) checkReceipt(receipt *structs.ReceiptTransaction) bool {
+	if receipt.DestShard != addon.pbftMod.nodeAttr.Sid || len(receipt.Signers) < addon.pbftMod.malicious_num+1 {
+		return false
+	}
+	content := structs.ReceiptContent(receipt.SourceShard, receipt.DestShard, receipt.Epoch, receipt.Round, receipt.Txs)
+	return addon.pbftMod.nodeAttr.VerifyAggregatedSig(receipt.SourceShard, receipt.Signers, content, receipt.Sig) == nil
+}
+
+// called in the commit order, replace the receipts with the txs they carry, the receipts of a block executed before are dropped.
+// The relay txs and the migrate txs are executed only if they come with a receipt
+func (addon *PbftMonoxideAddon) openReceipts(txs []structs.Transaction) []structs.Transaction {
+	records := addon.receipts
+	records.lock.Lock()
+	defer records.lock.Unlock()
+
+	opened := make([]structs.Transaction, 0, len(txs))
+	for _, tx := range txs {
+		switch tx := tx.(type) {
+		case *structs.ReceiptTransaction:
+			key := receiptKey{Sid: tx.SourceShard, Epoch: tx.Epoch, Round: tx.Round}
+			if tx.DestShard != addon.pbftMod.nodeAttr.Sid || records.executed[key] || records.isStale(key) {
+				continue
+			}
+			records.executed[key] = true
+			if latest, ok := records.latest[key.Sid]; !ok || key.Epoch > latest.Epoch || (key.Epoch == latest.Epoch && key.Round > latest.Round) {
+				records.latest[key.Sid] = key
+			}
+			for _, inner := range tx.Txs {
+				// the state of an account is migrated only by the shard holding it
+				if mTx, ok := inner.(*structs.MigrationTransaction); ok && mTx.Phase == structs.MigrationPhaseMigrate && mTx.OldShard != tx.SourceShard {
+					utils.LoggerInstance.Warn("The migrate tx of the account %s is not from its old shard, ignore it", mTx.Account)
+					continue
+				}
+				opened = append(opened, inner)
+			}
+		case *structs.MigrationTransaction:
+			// the state of an account is installed only if the old shard commits the migration
+			if tx.Phase == structs.MigrationPhaseMigrate {
+				utils.LoggerInstance.Warn("The migrate tx of the account %s comes without a receipt, ignore it", tx.Account)
+				continue
+			}
+			opened = append(opened, tx)
+		case *structs.AccountTransaction:
+			if tx.Relayed {
+				utils.LoggerInstance.Warn("The relay tx %x comes without a receipt, ignore it", tx.TxHash)
+				continue
+			}
+			opened = append(opened, tx)
+		default:
+			opened = append(opened, tx)
+		}
+	}
+	records.prune()
+	return opened
+}
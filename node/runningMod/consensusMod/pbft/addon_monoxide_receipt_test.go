@@ -0,0 +1,126 @@
+package pbft
+
+import (
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the share of node nid of shard 0 on the txs sent to shard 1, signed by the key of signer
+func shardTxsMsg(keys *testutil.Keys, nid int, signer int, round int, txs []structs.Transaction) *message.Message {
+	share := ShardTxsShare{
+		SourceShard: 0,
+		DestShard:   1,
+		Round:       round,
+		Txs:         txs,
+		NodeId:      nid,
+		Sig:         signature.Sign(keys.Sks[signer], structs.ReceiptContent(0, 1, 0, round, txs)),
+	}
+	return &message.Message{MsgType: message.MsgShardTxs, Content: utils.Encode(share)}
+}
+
+// the monoxide addon of node 0 in shard 1, returns the receipts it injects
+func newReceiptAddon(t *testing.T, keys *testutil.Keys) (*PbftMonoxideAddon, *[]*structs.ReceiptTransaction) {
+	pbftMod, _ := newTestMod(t, 0, keys)
+	pbftMod.nodeAttr.Sid = 1
+	addon := NewMonoxidePbftCosensusAddon(pbftMod).(*PbftMonoxideAddon)
+	injected := make([]*structs.ReceiptTransaction, 0)
+	pbftMod.p2pMod.RegisterHandler(message.MsgInject, func(msg *message.Message) {
+		txs := make([]structs.Transaction, 0)
+		require.NoError(t, utils.Decode(msg.Content, &txs))
+		for _, tx := range txs {
+			injected = append(injected, tx.(*structs.ReceiptTransaction))
+		}
+	})
+	return addon, &injected
+}
+
+func relayTxs(n int) []structs.Transaction {
+	txs := make([]structs.Transaction, 0, n)
+	for i := 0; i < n; i++ {
+		tx := structs.NewAccountTransaction("sender", "recipient", int64(i), big.NewInt(1))
+		txs = append(txs, structs.NewRelayTransaction(tx))
+	}
+	return txs
+}
+
+// the txs of another shard are injected once f+1 nodes of the shard sign the same txs
+func TestReceiptNeedsMatchingShares(t *testing.T) {
+	keys := newTestKeys()
+	addon, injected := newReceiptAddon(t, keys)
+	txs := relayTxs(2)
+
+	addon.handleShardTxs(shardTxsMsg(keys, 1, 1, 5, txs))
+	addon.handleShardTxs(shardTxsMsg(keys, 1, 1, 5, txs))
+	assert.Empty(t, *injected, "the shares of the same node are counted once")
+	addon.handleShardTxs(shardTxsMsg(keys, 2, 3, 5, txs))
+	assert.Empty(t, *injected, "the share signed by another node is rejected")
+	addon.handleShardTxs(shardTxsMsg(keys, 2, 2, 5, relayTxs(3)))
+	assert.Empty(t, *injected, "the shares on different txs do not match")
+
+	addon.handleShardTxs(shardTxsMsg(keys, 3, 3, 5, txs))
+	require.Len(t, *injected, 1)
+	receipt := (*injected)[0]
+	assert.True(t, addon.checkReceipt(receipt))
+	assert.Len(t, receipt.Txs, 2)
+
+	addon.handleShardTxs(shardTxsMsg(keys, 0, 0, 5, txs))
+	assert.Len(t, *injected, 1, "the receipt of a block is injected once")
+
+	// the receipt carrying other txs than the signed ones is rejected
+	forged := *receipt
+	forged.Txs = relayTxs(3)
+	assert.False(t, addon.checkReceipt(&forged))
+}
+
+// the receipts of a block built by different nodes are executed once, the relay txs without a receipt are ignored
+func TestReceiptExecutedOnce(t *testing.T) {
+	keys := newTestKeys()
+	addon, _ := newReceiptAddon(t, keys)
+	txs := relayTxs(2)
+
+	content := structs.ReceiptContent(0, 1, 0, 5, txs)
+	receipts := make([]structs.Transaction, 0)
+	for _, signers := range [][]int{{0, 1}, {2, 3}} {
+		agg, err := signature.AggregateSignatures([]*signature.Signature{signature.Sign(keys.Sks[signers[0]], content), signature.Sign(keys.Sks[signers[1]], content)})
+		require.NoError(t, err)
+		receipt := structs.NewReceiptTransaction(0, 1, 0, 5, txs, signers, agg)
+		require.True(t, addon.checkReceipt(receipt))
+		receipts = append(receipts, receipt)
+	}
+	assert.Equal(t, receipts[0].ID(), receipts[1].ID())
+
+	opened := addon.openReceipts(append([]structs.Transaction{receipts[0]}, relayTxs(1)...))
+	assert.Len(t, opened, 2, "the bare relay tx is ignored")
+	opened = addon.openReceipts([]structs.Transaction{receipts[1]})
+	assert.Empty(t, opened, "the receipt of the same block is executed once")
+}
+
+// the state of a migrated account is installed only from a receipt of its old shard
+func TestMigrateNeedsReceipt(t *testing.T) {
+	keys := newTestKeys()
+	addon, _ := newReceiptAddon(t, keys)
+	partitionTime := time.Now()
+	migrate := structs.NewMigrateTransaction(1, "account", 0, 1, nil, partitionTime)
+	forged := structs.NewMigrateTransaction(1, "forged", 2, 1, nil, partitionTime)
+
+	assert.Empty(t, addon.openReceipts([]structs.Transaction{migrate}), "the bare migrate tx is ignored")
+
+	txs := []structs.Transaction{migrate, forged}
+	content := structs.ReceiptContent(0, 1, 0, 5, txs)
+	agg, err := signature.AggregateSignatures([]*signature.Signature{signature.Sign(keys.Sks[0], content), signature.Sign(keys.Sks[1], content)})
+	require.NoError(t, err)
+	receipt := structs.NewReceiptTransaction(0, 1, 0, 5, txs, []int{0, 1}, agg)
+	opened := addon.openReceipts([]structs.Transaction{receipt})
+	require.Len(t, opened, 1, "the migrate tx of an account in another shard is ignored")
+	assert.Equal(t, migrate.Hash(), opened[0].Hash())
+	assert.Equal(t, migrate.Hash(), structs.NewMigrateTransaction(1, "account", 0, 1, nil, partitionTime).Hash(), "the nodes build the same migrate tx")
+}
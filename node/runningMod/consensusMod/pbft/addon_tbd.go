@@ -14,27 +14,39 @@ var ChannelId = 23333
 
 var _ PbftAddon = &PbftTBDAddon{}
 
-// implement TBD method
+// implement TBD method, the cross-shard contract calls are committed by two-phase locking, see addon_tbd_lock.go
 type PbftTBDAddon struct {
 	pbftMod *PbftCosensusMod // the belonging pbft module
 
-	stateUpdateDone bool
-	stateLock       sync.Mutex
+	calls     map[string]*crossCall    // the cross-shard calls coordinated by this shard and not decided yet, key: the hash of the call
+	decided   map[string]*callDecision // the decided calls coordinated by this shard, key: the hash of the call
+	locked    map[string]*lockedCall   // the calls locking the contracts of this shard and not decided yet, key: the hash of the call
+	shares    map[[32]byte]*lockShares // the lock txs from the shards with less than f+1 shares, key: the digest of the content
+	collected map[[32]byte]time.Time   // the lock txs injected into the TxPool, value: until when the later shares are ignored
+	callsLock sync.Mutex
 }
 
 func NewTBDPbftCosensusAddon(pbftMod *PbftCosensusMod) PbftAddon {
 	return &PbftTBDAddon{
-		pbftMod:         pbftMod,
-		stateUpdateDone: false,
+		pbftMod:   pbftMod,
+		calls:     make(map[string]*crossCall),
+		decided:   make(map[string]*callDecision),
+		locked:    make(map[string]*lockedCall),
+		shares:    make(map[[32]byte]*lockShares),
+		collected: make(map[[32]byte]time.Time),
 	}
 }
 
+func (addon *PbftTBDAddon) RegisterHandlers() {
+	addon.pbftMod.p2pMod.RegisterHandler(message.MsgLockTxs, addon.handleLockTxs)
+}
+
 // no more things to do
 func (addon *PbftTBDAddon) HandleProposeAddon(req *message.Request) bool {
 	return true
 }
 
-// verify the request
+// verify the request, the states are updated when the block is executed in the commit order, several blocks may be in flight in the pipeline
 func (addon *PbftTBDAddon) HandlePrePrepareAddon(req *message.Request) bool {
 	b := &structs.Block{}
 	err := utils.Decode(req.Content, b)
@@ -43,37 +55,26 @@ func (addon *PbftTBDAddon) HandlePrePrepareAddon(req *message.Request) bool {
 		return false
 	}
 
-	bc := addon.pbftMod.nodeAttr.CurChain
-	if b.Header.Height != bc.CurrentBlock.Header.Height+1 {
-		utils.LoggerInstance.Warn("the block height is not equal to the current block height")
-	} else if string(blockchain.GetTxTreeRoot(b.Transactions)) != string(b.Header.TxRoot) {
-		utils.LoggerInstance.Warn("the transaction root is wrong")
+	if string(blockchain.GetTxTreeRoot(b.Transactions)) != string(b.Header.TxRoot) {
+		utils.LoggerInstance.Warn("the transaction root is wrong, reject the block")
+		return false
+	}
+	for _, tx := range b.Transactions {
+		if lockTx, ok := tx.(*structs.LockTransaction); ok && !addon.checkLockTx(lockTx) {
+			utils.LoggerInstance.Warn("the lock tx %v is not signed by its origin shard, reject the block", lockTx)
+			return false
+		}
 	}
-
-	txs := b.Transactions
-	utils.LoggerInstance.Debug("The block is legal, try update the state")
-	bc.StateManager.UpdateStates(txs, bc.CurrentBlock.Header.StateRoot)
-
-	addon.SetStateUpdateDone(true) // indicate the state update is done
-
 	return true
 }
 
-// Wait until the state update is done
+// no more things to do
 func (addon *PbftTBDAddon) HandlePrepareAddon(req *message.Request) bool {
-	// This is not the standard practice for production.
-	// It's solely to ensure all nodes are synchronized in the same PBFT round.
-	for !addon.GetStateUpdateDone() {
-		time.Sleep(10 * time.Millisecond) // 休眠10毫秒
-	}
 	return true
 }
 
+// called in the commit order, update the states on the states of the previous block
 func (addon *PbftTBDAddon) HandleCommitAddon(req *message.Request) bool {
-	for !addon.GetStateUpdateDone() {
-		time.Sleep(10 * time.Millisecond) // 休眠10毫秒
-	}
-
 	b := &structs.Block{}
 	err := utils.Decode(req.Content, b)
 	if err != nil {
@@ -81,16 +82,18 @@ func (addon *PbftTBDAddon) HandleCommitAddon(req *message.Request) bool {
 		return false
 	}
 
-	addon.pbftMod.nodeAttr.CurChain.CommitBlock(b)
-	addon.SetStateUpdateDone(false)
+	bc := addon.pbftMod.nodeAttr.CurChain
+	bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+	addon.handleCrossCalls(b.Transactions)
+	bc.CommitBlock(b)
 	// send the verified message back to the client
-	if addon.pbftMod.nodeAttr.Nid == config.ViewNodeId {
+	if addon.pbftMod.isPrimary() {
 		reply := &message.Reply{
 			Req:  req,
 			Time: time.Now(),
 
 			Sid:         addon.pbftMod.nodeAttr.Sid,
-			ReqQueueLen: addon.pbftMod.requestQueue.Size(),
+			ReqQueueLen: addon.pbftMod.pending.size(),
 		}
 
 		replaymsg := message.Message{
@@ -104,15 +107,3 @@ func (addon *PbftTBDAddon) HandleCommitAddon(req *message.Request) bool {
 
 	return true
 }
-
-func (addon *PbftTBDAddon) SetStateUpdateDone(flag bool) {
-	addon.stateLock.Lock()
-	defer addon.stateLock.Unlock()
-	addon.stateUpdateDone = flag
-}
-
-func (addon *PbftTBDAddon) GetStateUpdateDone() bool {
-	addon.stateLock.Lock()
-	defer addon.stateLock.Unlock()
-	return addon.stateUpdateDone
-}
@@ -0,0 +1,245 @@
+// This file contains the two-phase locking of the cross-shard contract calls in TBD.
+// The shard of the called contract is the coordinator, the shards owning the called contract and the related contracts are the participants.
+// The coordinator sends Lock to the participants, which lock the contracts, execute the call and vote. The coordinator commits the call
+// if all of them are prepared, or aborts it if any of them fails or the votes are not received in config.CrossCallTimeout.
+// A participant holding the locks of a call for config.CrossCallTimeout without the decision queries the coordinator, which keeps
+// the decisions for decisionKeep and sends the decision again, so a lost Commit or Abort never leaves the contracts locked.
+// All the phases are structs.LockTransaction packed into the blocks, so every node of a shard handles them in the same order,
+// and the conflicts between the locks and the local calls are decided in the commit order of the blocks.
+// The lock txs are signed by the nodes of the origin shard, see addon_tbd_lock_share.go
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"encoding/hex"
+	"time"
+)
+
+const decisionKeep = 10 // the decisions are kept for decisionKeep * config.CrossCallTimeout to answer the queries of the participants
+
+// the cross-shard contract call coordinated by this shard
+type crossCall struct {
+	lock         *structs.LockTransaction  // the first lock tx of the call, the later phases are derived from it
+	participants map[int][]structs.Address // key: the participant shard, value: the contracts in the shard
+	votes        map[int]bool              // key: the participant shard, value: whether it is prepared
+
+	start       time.Time // the time the call is started in this node
+	timeoutSent bool      // whether the primary has proposed the timeout of the call
+}
+
+// the decision of a cross-shard call coordinated by this shard
+type callDecision struct {
+	commit bool
+	time   time.Time // the time the call is decided in this node
+}
+
+// the cross-shard call locking the contracts of this shard, waiting for the decision of the coordinator
+type lockedCall struct {
+	lock      *structs.LockTransaction // the lock tx of this shard
+	queryTime time.Time                // the time the contracts are locked or the last query is sent
+}
+
+// handle the cross-shard calls and the lock txs in the committed block, called in the order of the rounds before the block is committed,
+// so that the states committed by the calls are committed with the block
+func (addon *PbftTBDAddon) handleCrossCalls(txs []structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	bc := addon.pbftMod.nodeAttr.CurChain
+	outbox := make(map[int][]structs.Transaction) // key: the destination sid, value: the lock txs
+
+	for _, tx := range txs {
+		switch tx := tx.(type) {
+		case *structs.ContractTransaction:
+			if tx.IsCrossShard && utils.Addr2Shard(tx.Recipient) == sid {
+				addon.startCall(tx, outbox)
+			}
+		case *structs.LockTransaction:
+			call := hex.EncodeToString(tx.CallHash)
+			switch tx.Phase {
+			case structs.LockPhaseLock:
+				if tx.Participant == sid {
+					prepared := bc.StateManager.LockContracts(call, tx, tx.Contracts, bc.CurrentBlock.Header.StateRoot)
+					if prepared {
+						addon.callsLock.Lock()
+						if _, ok := addon.locked[call]; !ok {
+							addon.locked[call] = &lockedCall{lock: tx, queryTime: time.Now()}
+						}
+						addon.callsLock.Unlock()
+					}
+					outbox[tx.Coordinator] = append(outbox[tx.Coordinator], tx.Next(structs.LockPhaseVote, sid, tx.Contracts, prepared))
+				}
+			case structs.LockPhaseVote:
+				if tx.Coordinator == sid {
+					addon.recordVote(call, tx, outbox)
+				}
+			case structs.LockPhaseTimeout:
+				if tx.Coordinator == sid {
+					addon.callsLock.Lock()
+					if _, ok := addon.calls[call]; ok {
+						utils.LoggerInstance.Info("The votes of the cross-shard call %s are not received in time", call)
+						addon.decide(call, false, outbox)
+					}
+					addon.callsLock.Unlock()
+				}
+			case structs.LockPhaseQuery:
+				if tx.Coordinator == sid {
+					addon.answerQuery(call, tx, outbox)
+				}
+			case structs.LockPhaseCommit:
+				if tx.Participant == sid {
+					bc.StateManager.CommitLocked(call)
+					addon.unlocked(call)
+				}
+			case structs.LockPhaseAbort:
+				if tx.Participant == sid {
+					bc.StateManager.AbortLocked(call)
+					addon.unlocked(call)
+				}
+			}
+		}
+	}
+
+	addon.pruneDecisions()
+	// every node signs and sends the lock txs, the destination shard accepts a tx signed by f+1 of them
+	addon.proposeTimeouts(outbox)
+	addon.proposeQueries(outbox)
+	addon.sendLockTxs(outbox)
+}
+
+// start the two-phase locking of the call, send Lock to every participant
+func (addon *PbftTBDAddon) startCall(tx *structs.ContractTransaction, outbox map[int][]structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	participants := make(map[int][]structs.Address)
+	seen := utils.NewSet[structs.Address]()
+	for _, addr := range append([]structs.Address{tx.Recipient}, tx.RelatedContract...) {
+		if seen.Contains(addr) {
+			continue
+		}
+		seen.Add(addr)
+		participants[utils.Addr2Shard(addr)] = append(participants[utils.Addr2Shard(addr)], addr)
+	}
+
+	c := &crossCall{
+		lock:         structs.NewLockTransaction(structs.LockPhaseLock, tx, sid, sid, nil, false),
+		participants: participants,
+		votes:        make(map[int]bool),
+		start:        time.Now(),
+	}
+	call := hex.EncodeToString(tx.TxHash)
+	addon.callsLock.Lock()
+	addon.calls[call] = c
+	addon.callsLock.Unlock()
+
+	for psid, contracts := range participants {
+		outbox[psid] = append(outbox[psid], c.lock.Next(structs.LockPhaseLock, psid, contracts, false))
+	}
+}
+
+// the timeouts are proposed by the primary as lock txs to its own shard, so the nodes of the shard agree on whether the votes are in time
+func (addon *PbftTBDAddon) proposeTimeouts(outbox map[int][]structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	addon.callsLock.Lock()
+	defer addon.callsLock.Unlock()
+
+	for _, c := range addon.calls {
+		if !c.timeoutSent && time.Since(c.start) > time.Duration(config.CrossCallTimeout)*time.Millisecond {
+			outbox[sid] = append(outbox[sid], c.lock.Next(structs.LockPhaseTimeout, sid, nil, false))
+			c.timeoutSent = true
+		}
+	}
+}
+
+// query the coordinators of the calls locking the contracts of this shard for config.CrossCallTimeout, and again after every config.CrossCallTimeout
+func (addon *PbftTBDAddon) proposeQueries(outbox map[int][]structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	addon.callsLock.Lock()
+	defer addon.callsLock.Unlock()
+
+	for call, l := range addon.locked {
+		if time.Since(l.queryTime) > time.Duration(config.CrossCallTimeout)*time.Millisecond {
+			utils.LoggerInstance.Info("The decision of the cross-shard call %s is not received in time, query the coordinator", call)
+			outbox[l.lock.Coordinator] = append(outbox[l.lock.Coordinator], l.lock.Next(structs.LockPhaseQuery, sid, l.lock.Contracts, true))
+			l.queryTime = time.Now()
+		}
+	}
+}
+
+// send the decision of the call again to the participant querying it, the undecided calls are decided by the timeouts
+func (addon *PbftTBDAddon) answerQuery(call string, tx *structs.LockTransaction, outbox map[int][]structs.Transaction) {
+	addon.callsLock.Lock()
+	defer addon.callsLock.Unlock()
+
+	d, ok := addon.decided[call]
+	if !ok {
+		return
+	}
+	phase := structs.LockPhaseAbort
+	if d.commit {
+		phase = structs.LockPhaseCommit
+	}
+	outbox[tx.Participant] = append(outbox[tx.Participant], tx.Next(phase, tx.Participant, tx.Contracts, d.commit))
+}
+
+// the decision of the call is received by this shard as a participant
+func (addon *PbftTBDAddon) unlocked(call string) {
+	addon.callsLock.Lock()
+	defer addon.callsLock.Unlock()
+	delete(addon.locked, call)
+}
+
+// forget the decisions older than decisionKeep * config.CrossCallTimeout, and the shares of the lock txs
+func (addon *PbftTBDAddon) pruneDecisions() {
+	addon.callsLock.Lock()
+	defer addon.callsLock.Unlock()
+	for call, d := range addon.decided {
+		if time.Since(d.time) > decisionKeep*time.Duration(config.CrossCallTimeout)*time.Millisecond {
+			delete(addon.decided, call)
+		}
+	}
+	addon.pruneShares()
+}
+
+// record the vote of a participant, abort the call if it is not prepared, commit the call if all the participants are prepared
+func (addon *PbftTBDAddon) recordVote(call string, tx *structs.LockTransaction, outbox map[int][]structs.Transaction) {
+	addon.callsLock.Lock()
+	defer addon.callsLock.Unlock()
+
+	c, ok := addon.calls[call]
+	if !ok {
+		// the call is decided
+		return
+	}
+	if _, ok := c.participants[tx.Participant]; !ok {
+		utils.LoggerInstance.Warn("Shard %d is not a participant of the cross-shard call %s", tx.Participant, call)
+		return
+	}
+
+	c.votes[tx.Participant] = tx.Prepared
+	if !tx.Prepared {
+		addon.decide(call, false, outbox)
+		return
+	}
+	for psid := range c.participants {
+		if !c.votes[psid] {
+			return
+		}
+	}
+	addon.decide(call, true, outbox)
+}
+
+// send the decision to all the participants, the caller holds callsLock
+func (addon *PbftTBDAddon) decide(call string, commit bool, outbox map[int][]structs.Transaction) {
+	c := addon.calls[call]
+	delete(addon.calls, call)
+	addon.decided[call] = &callDecision{commit: commit, time: time.Now()}
+
+	phase := structs.LockPhaseAbort
+	if commit {
+		phase = structs.LockPhaseCommit
+	}
+	for psid, contracts := range c.participants {
+		outbox[psid] = append(outbox[psid], c.lock.Next(phase, psid, contracts, commit))
+	}
+	utils.LoggerInstance.Info("The cross-shard call %s is decided: %s", call, phase)
+}
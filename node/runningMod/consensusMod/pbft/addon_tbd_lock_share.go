@@ -0,0 +1,164 @@
+// This file contains the lock txs sent between the shards in TBD.
+// Every node of the origin shard signs the lock txs emitted by a committed block and sends them to every node of the destination shard,
+// so a node cannot forge the phases of the calls of another shard. A node of the destination shard waits for f+1 matching shares of a tx,
+// at least one of them from an honest node, and injects the tx carrying their aggregate signature into its own TxPool. The lock txs are
+// verified at pre-prepare. The shares are kept for decisionKeep * config.CrossCallTimeout, so the records are bounded
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+	"time"
+)
+
+// the shares of a node of the origin shard on the lock txs emitted by a committed block
+type LockTxsShare struct {
+	SourceShard int
+	DestShard   int
+	Txs         []*structs.LockTransaction
+	NodeId      int                    // the sender in the source shard
+	Sigs        []*signature.Signature // the signatures of the sender on structs.LockContent of the txs
+}
+
+// the shares of a lock tx received from the nodes of the origin shard
+type lockShares struct {
+	tx    *structs.LockTransaction
+	sigs  map[int]*signature.Signature // nid -> the signature of the node
+	start time.Time                    // the time the first share is received
+}
+
+// sign the lock txs and send them to every node of the destination shards, including this shard
+func (addon *PbftTBDAddon) sendLockTxs(outbox map[int][]structs.Transaction) {
+	sid := addon.pbftMod.nodeAttr.Sid
+	for destSid, txs := range outbox {
+		share := LockTxsShare{
+			SourceShard: sid,
+			DestShard:   destSid,
+			Txs:         make([]*structs.LockTransaction, 0, len(txs)),
+			NodeId:      addon.pbftMod.nodeAttr.Nid,
+			Sigs:        make([]*signature.Signature, 0, len(txs)),
+		}
+		for _, tx := range txs {
+			lockTx := tx.(*structs.LockTransaction)
+			share.Txs = append(share.Txs, lockTx)
+			share.Sigs = append(share.Sigs, signature.Sign(addon.pbftMod.nodeAttr.SecKey, structs.LockContent(lockTx)))
+		}
+		msg := message.Message{
+			MsgType: message.MsgLockTxs,
+			Content: utils.Encode(share),
+		}
+		msgBytes := msg.JsonEncode()
+		for _, addr := range config.IPMap[destSid] {
+			addon.pbftMod.p2pMod.ConnMananger.Send(addr, msgBytes)
+		}
+		utils.LoggerInstance.Debug("Send %d lock txs to shard %d", len(txs), destSid)
+	}
+}
+
+// collect the shares of the nodes of the origin shard, inject a lock tx once f+1 of them sign it
+func (addon *PbftTBDAddon) handleLockTxs(msg *message.Message) {
+	share := LockTxsShare{}
+	if err := utils.Decode(msg.Content, &share); err != nil {
+		utils.LoggerInstance.Error("Error decoding the lock txs from another shard")
+		return
+	}
+	sid := addon.pbftMod.nodeAttr.Sid
+	if share.DestShard != sid || len(share.Txs) != len(share.Sigs) {
+		return
+	}
+
+	ready := make([]structs.Transaction, 0)
+	addon.callsLock.Lock()
+	for i, tx := range share.Txs {
+		if tx.Origin() != share.SourceShard || tx.Dest() != sid {
+			utils.LoggerInstance.Warn("The lock tx %v is not sent from shard %d to this shard", tx, share.SourceShard)
+			continue
+		}
+		content := structs.LockContent(tx)
+		if !addon.pbftMod.nodeAttr.VerifySig(share.SourceShard, share.NodeId, content, share.Sigs[i]) {
+			utils.LoggerInstance.Warn("The signature of the lock tx from node %d in shard %d is not valid", share.NodeId, share.SourceShard)
+			continue
+		}
+
+		digest := sha256.Sum256(content)
+		if expiry, ok := addon.collected[digest]; ok && time.Now().Before(expiry) {
+			continue
+		}
+		shares, ok := addon.shares[digest]
+		if !ok {
+			shares = &lockShares{tx: tx.WithSig(nil, nil), sigs: make(map[int]*signature.Signature), start: time.Now()}
+			shares.tx.TxHash = utils.Hash(content)
+			addon.shares[digest] = shares
+		}
+		shares.sigs[share.NodeId] = share.Sigs[i]
+		if len(shares.sigs) < addon.pbftMod.malicious_num+1 {
+			continue
+		}
+		delete(addon.shares, digest)
+		addon.collected[digest] = time.Now().Add(collectKeep(tx))
+
+		signers := make([]int, 0, len(shares.sigs))
+		sigs := make([]*signature.Signature, 0, len(shares.sigs))
+		for nid, sig := range shares.sigs {
+			signers = append(signers, nid)
+			sigs = append(sigs, sig)
+		}
+		aggSig, err := signature.AggregateSignatures(sigs)
+		if err != nil {
+			utils.LoggerInstance.Error("Error aggregating the signatures of the lock tx from shard %d", share.SourceShard)
+			continue
+		}
+		ready = append(ready, shares.tx.WithSig(signers, aggSig))
+	}
+	addon.callsLock.Unlock()
+	if len(ready) == 0 {
+		return
+	}
+
+	handler, ok := addon.pbftMod.p2pMod.Handler(message.MsgInject)
+	if !ok {
+		utils.LoggerInstance.Error("No handler to inject the lock txs from shard %d", share.SourceShard)
+		return
+	}
+	handler(&message.Message{MsgType: message.MsgInject, Content: utils.Encode(ready)})
+}
+
+// whether the lock tx is handled by this shard and signed by f+1 nodes of the origin shard
+func (addon *PbftTBDAddon) checkLockTx(tx *structs.LockTransaction) bool {
+	if tx.Dest() != addon.pbftMod.nodeAttr.Sid || len(tx.Signers) < addon.pbftMod.malicious_num+1 {
+		return false
+	}
+	content := structs.LockContent(tx)
+	if string(tx.TxHash) != string(utils.Hash(content)) {
+		return false
+	}
+	return addon.pbftMod.nodeAttr.VerifyAggregatedSig(tx.Origin(), tx.Signers, content, tx.Sig) == nil
+}
+
+// how long the later shares of a collected lock tx are ignored. A query is sent again after every config.CrossCallTimeout
+// with the same content, so it is collected again after config.CrossCallTimeout
+func collectKeep(tx *structs.LockTransaction) time.Duration {
+	timeout := time.Duration(config.CrossCallTimeout) * time.Millisecond
+	if tx.Phase == structs.LockPhaseQuery {
+		return timeout
+	}
+	return decisionKeep * timeout
+}
+
+// forget the shares older than decisionKeep * config.CrossCallTimeout and the expired collected lock txs, the caller holds callsLock
+func (addon *PbftTBDAddon) pruneShares() {
+	for digest, shares := range addon.shares {
+		if time.Since(shares.start) > decisionKeep*time.Duration(config.CrossCallTimeout)*time.Millisecond {
+			delete(addon.shares, digest)
+		}
+	}
+	for digest, expiry := range addon.collected {
+		if time.Now().After(expiry) {
+			delete(addon.collected, digest)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package pbft
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the participant missing the decision queries the coordinator, which sends the decision again
+func TestLostDecisionIsQueried(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 0, keys)
+	addon := NewTBDPbftCosensusAddon(pbftMod).(*PbftTBDAddon)
+
+	callTx := structs.NewContractTransaction("sender", "c1", 0, time.Now(), nil, []string{"c2"}, true)
+	call := hex.EncodeToString(callTx.TxHash)
+	lock := structs.NewLockTransaction(structs.LockPhaseLock, callTx, 0, 0, nil, false)
+	participantLock := lock.Next(structs.LockPhaseLock, 1, []structs.Address{"c2"}, false)
+
+	// the coordinator commits the call, the Commit sent to shard 1 is lost
+	outbox := make(map[int][]structs.Transaction)
+	addon.calls[call] = &crossCall{lock: lock, participants: map[int][]structs.Address{1: {"c2"}}, votes: map[int]bool{}, start: time.Now()}
+	addon.decide(call, true, outbox)
+	require.Len(t, outbox[1], 1)
+
+	// the participant(the same addon in shard 1) waits for config.CrossCallTimeout before the query, and does not query again at once
+	pbftMod.nodeAttr.Sid = 1
+	addon.locked[call] = &lockedCall{lock: participantLock, queryTime: time.Now()}
+	outbox = make(map[int][]structs.Transaction)
+	addon.proposeQueries(outbox)
+	assert.Empty(t, outbox)
+	addon.locked[call].queryTime = time.Now().Add(-time.Duration(config.CrossCallTimeout+1) * time.Millisecond)
+	addon.proposeQueries(outbox)
+	require.Len(t, outbox[0], 1)
+	addon.proposeQueries(outbox)
+	require.Len(t, outbox[0], 1)
+	query := outbox[0][0].(*structs.LockTransaction)
+	assert.Equal(t, structs.LockPhaseQuery, query.Phase)
+
+	outbox = make(map[int][]structs.Transaction)
+	addon.answerQuery(call, query, outbox)
+	require.Len(t, outbox[1], 1)
+	decision := outbox[1][0].(*structs.LockTransaction)
+	assert.Equal(t, structs.LockPhaseCommit, decision.Phase)
+	assert.Equal(t, []structs.Address{"c2"}, decision.Contracts)
+
+	// the undecided calls are decided by the timeout of the coordinator, not by the queries
+	outbox = make(map[int][]structs.Transaction)
+	addon.answerQuery("undecided", query, outbox)
+	assert.Empty(t, outbox)
+}
+
+// the share of node nid of shard 0 on the lock txs sent to shard 1, signed by the key of signer
+func lockTxsMsg(keys *testutil.Keys, nid int, signer int, txs ...*structs.LockTransaction) *message.Message {
+	share := LockTxsShare{SourceShard: 0, DestShard: 1, Txs: txs, NodeId: nid}
+	for _, tx := range txs {
+		share.Sigs = append(share.Sigs, signature.Sign(keys.Sks[signer], structs.LockContent(tx)))
+	}
+	return &message.Message{MsgType: message.MsgLockTxs, Content: utils.Encode(share)}
+}
+
+// the lock txs of another shard are injected once f+1 nodes of the shard sign them, and accepted at pre-prepare only with the signatures
+func TestLockTxNeedsMatchingShares(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 0, keys)
+	pbftMod.nodeAttr.Sid = 1
+	addon := NewTBDPbftCosensusAddon(pbftMod).(*PbftTBDAddon)
+	injected := make([]*structs.LockTransaction, 0)
+	pbftMod.p2pMod.RegisterHandler(message.MsgInject, func(msg *message.Message) {
+		txs := make([]structs.Transaction, 0)
+		require.NoError(t, utils.Decode(msg.Content, &txs))
+		for _, tx := range txs {
+			injected = append(injected, tx.(*structs.LockTransaction))
+		}
+	})
+
+	callTx := structs.NewContractTransaction("sender", "c1", 0, time.Now(), nil, []string{"c2"}, true)
+	lock := structs.NewLockTransaction(structs.LockPhaseLock, callTx, 0, 0, nil, false).Next(structs.LockPhaseLock, 1, []structs.Address{"c2"}, false)
+	vote := lock.Next(structs.LockPhaseVote, 1, lock.Contracts, true)
+
+	addon.handleLockTxs(lockTxsMsg(keys, 1, 1, lock))
+	addon.handleLockTxs(lockTxsMsg(keys, 1, 1, lock))
+	assert.Empty(t, injected, "the shares of the same node are counted once")
+	addon.handleLockTxs(lockTxsMsg(keys, 2, 3, lock))
+	assert.Empty(t, injected, "the share signed by another node is rejected")
+	addon.handleLockTxs(lockTxsMsg(keys, 2, 2, vote))
+	assert.Empty(t, injected, "the vote of shard 1 is not sent by shard 0")
+
+	addon.handleLockTxs(lockTxsMsg(keys, 3, 3, lock))
+	require.Len(t, injected, 1)
+	signed := injected[0]
+	assert.Equal(t, lock.Hash(), signed.Hash())
+	assert.True(t, addon.checkLockTx(signed))
+	addon.handleLockTxs(lockTxsMsg(keys, 0, 0, lock))
+	assert.Len(t, injected, 1, "the lock tx is injected once")
+
+	// the lock txs forged by a node, or changed after the signing, are rejected
+	assert.False(t, addon.checkLockTx(lock), "no signature")
+	forged := lock.WithSig([]int{1}, signature.Sign(keys.Sks[1], structs.LockContent(lock)))
+	assert.False(t, addon.checkLockTx(forged), "less than f+1 signers")
+	commit := signed.Next(structs.LockPhaseCommit, 1, signed.Contracts, true).WithSig(signed.Signers, signed.Sig)
+	assert.False(t, addon.checkLockTx(commit), "the signatures of another phase")
+
+	for _, tx := range []*structs.LockTransaction{forged, signed} {
+		txs := []structs.Transaction{tx}
+		block := structs.NewBlock(&structs.BlockHeader{Height: 1, TxRoot: blockchain.GetTxTreeRoot(txs)}, txs)
+		assert.Equal(t, tx == signed, addon.HandlePrePrepareAddon(message.NewRequest(1, message.ReqVerifyBlock, utils.Encode(block))))
+	}
+}
@@ -0,0 +1,167 @@
+// This file contains the state transfer of PBFT.
+// A node missing some rounds before a stable checkpoint cannot execute the rounds after it, so it never skips them:
+// it fetches the committed requests from the nodes proving the checkpoint and executes them in order.
+// Every request comes with the aggregate signature of 2f+1 commit messages, the node trusts none of the senders.
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"time"
+)
+
+const catchUpInterval = time.Second // a lagging node requests the same rounds again if they are not received in time
+
+// the request committed in a round, with the 2f+1 commit messages proving it
+type CommitCert struct {
+	Round   int
+	View    int
+	Request message.Request
+	Signers []int                // the nids of the nodes sending the commit messages
+	Sig     *signature.Signature // the aggregate signature of the commit messages
+}
+
+// a lagging node requests the committed requests of the rounds in [From, To)
+type CatchUpRequest struct {
+	Epoch    int
+	Instance int
+	From     int
+	To       int
+	NodeId   int                  // the requester
+	Sig      *signature.Signature // the signature of the requester, only the nodes of the shard are served
+}
+
+// the content signed in the catch up request
+func catchUpRequestContent(epoch int, instance int, from int, to int) []byte {
+	return utils.CanonicalEncode(struct {
+		Epoch    int
+		Instance int
+		From     int
+		To       int
+	}{epoch, instance, from, to})
+}
+
+type CatchUpResponse struct {
+	Epoch    int
+	Instance int
+	Certs    []CommitCert
+}
+
+// whether the request is committed by 2f+1 nodes in the round and view of the certificate
+func (pbftmod *PbftCosensusMod) checkCommitCert(cert *CommitCert) bool {
+	digest := cert.Request.Digest
+	req := cert.Request
+	req.CalDigest()
+	if req.Digest != digest || len(cert.Signers) < 2*pbftmod.malicious_num+1 {
+		return false
+	}
+	content := pbftMessageContent(message.MsgCommit, pbftmod.epoch, pbftmod.instance, cert.Round, cert.View, digest)
+	return pbftmod.nodeAttr.VerifyAggregatedSig(pbftmod.nodeAttr.Sid, cert.Signers, content, cert.Sig) == nil
+}
+
+// request the rounds from the current round to the stable checkpoint from the nodes proving it, at most once per catchUpInterval for the same rounds
+func (pbftmod *PbftCosensusMod) catchUp(stableRound int, proof []CheckpointMessage) {
+	from := pbftmod.getCurrentRound()
+	if from >= stableRound {
+		return
+	}
+	pbftmod.checkpointLock.Lock()
+	if pbftmod.catchUpFrom == from && time.Since(pbftmod.catchUpTime) < catchUpInterval {
+		pbftmod.checkpointLock.Unlock()
+		return
+	}
+	pbftmod.catchUpFrom = from
+	pbftmod.catchUpTime = time.Now()
+	pbftmod.checkpointLock.Unlock()
+
+	req := CatchUpRequest{
+		Epoch:    pbftmod.epoch,
+		Instance: pbftmod.instance,
+		From:     from,
+		To:       stableRound,
+		NodeId:   pbftmod.nodeAttr.Nid,
+	}
+	req.Sig = signature.Sign(pbftmod.nodeAttr.SecKey, catchUpRequestContent(req.Epoch, req.Instance, req.From, req.To))
+	msg := message.Message{
+		MsgType: message.MsgCertRequest,
+		Content: utils.Encode(req),
+	}
+	for _, cp := range proof {
+		if cp.NodeId != pbftmod.nodeAttr.Nid {
+			pbftmod.p2pMod.ConnMananger.Send(config.IPMap[pbftmod.nodeAttr.Sid][cp.NodeId], msg.JsonEncode())
+		}
+	}
+	utils.LoggerInstance.Info("The checkpoint of round %d is stable but the current round is %d, fetch the committed requests", stableRound, from)
+}
+
+// reply the committed requests the lagging node of the shard asks for, at most a watermark window of them
+func (pbftmod *PbftCosensusMod) handleCatchUpRequest(msg *message.Message) {
+	req := CatchUpRequest{}
+	if err := utils.Decode(msg.Content, &req); err != nil {
+		utils.LoggerInstance.Error("Error decoding the catch up request")
+		return
+	}
+	if req.Epoch != pbftmod.epoch || req.Instance != pbftmod.instance {
+		return
+	}
+	if !pbftmod.checkSig(req.NodeId, catchUpRequestContent(req.Epoch, req.Instance, req.From, req.To), req.Sig) {
+		utils.LoggerInstance.Warn("The catch up request of node %d is not signed by it", req.NodeId)
+		return
+	}
+	if req.To > req.From+config.WatermarkWindow {
+		req.To = req.From + config.WatermarkWindow
+	}
+
+	resp := CatchUpResponse{Epoch: pbftmod.epoch, Instance: pbftmod.instance}
+	pbftmod.execLock.Lock()
+	for round := req.From; round < req.To; round++ {
+		if cert, ok := pbftmod.commitCerts[round]; ok {
+			resp.Certs = append(resp.Certs, *cert)
+		}
+	}
+	pbftmod.execLock.Unlock()
+	if len(resp.Certs) == 0 {
+		utils.LoggerInstance.Warn("Node %d requests the rounds from %d, which are discarded", req.NodeId, req.From)
+		return
+	}
+
+	rmsg := message.Message{
+		MsgType: message.MsgCertificate,
+		Content: utils.Encode(resp),
+	}
+	pbftmod.p2pMod.ConnMananger.Send(config.IPMap[pbftmod.nodeAttr.Sid][req.NodeId], rmsg.JsonEncode())
+}
+
+// execute the fetched requests proved committed, and keep fetching if the node is still behind the stable checkpoint
+func (pbftmod *PbftCosensusMod) handleCatchUpResponse(msg *message.Message) {
+	resp := CatchUpResponse{}
+	if err := utils.Decode(msg.Content, &resp); err != nil {
+		utils.LoggerInstance.Error("Error decoding the catch up response")
+		return
+	}
+	if resp.Epoch != pbftmod.epoch || resp.Instance != pbftmod.instance {
+		return
+	}
+
+	currentRound := pbftmod.getCurrentRound()
+	for i := range resp.Certs {
+		cert := &resp.Certs[i]
+		if cert.Round < currentRound {
+			continue
+		}
+		if !pbftmod.checkCommitCert(cert) {
+			utils.LoggerInstance.Warn("The committed request of round %d is not proved", cert.Round)
+			return
+		}
+		pbftmod.execLock.Lock()
+		if _, ok := pbftmod.commitCerts[cert.Round]; !ok {
+			pbftmod.commitCerts[cert.Round] = cert
+		}
+		pbftmod.execLock.Unlock()
+	}
+	pbftmod.executeCommitted()
+
+	pbftmod.catchUp(pbftmod.getStableCheckpoint())
+}
@@ -0,0 +1,104 @@
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the request of the round committed in view 0 by the signers
+func commitCert(keys *testutil.Keys, round int, req message.Request, signers ...int) CommitCert {
+	sigs := make([]*signature.Signature, 0, len(signers))
+	for _, nid := range signers {
+		sigs = append(sigs, signature.Sign(keys.Sks[nid], pbftMessageContent(message.MsgCommit, 0, 0, round, 0, req.Digest)))
+	}
+	aggSig, _ := signature.AggregateSignatures(sigs)
+	return CommitCert{Round: round, Request: req, Signers: signers, Sig: aggSig}
+}
+
+func catchUpMsg(certs ...CommitCert) *message.Message {
+	return &message.Message{MsgType: message.MsgCertificate, Content: utils.Encode(CatchUpResponse{Certs: certs})}
+}
+
+// a node missing the rounds before a stable checkpoint executes them from the proved requests, it never skips them
+func TestLaggingNodeCatchesUp(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, addon := newTestMod(t, 3, keys)
+	pbftMod.stabilize(4, nil)
+	assert.Equal(t, 0, pbftMod.getCurrentRound())
+	assert.Equal(t, 4, pbftMod.getLowWatermark())
+
+	reqs := make([]message.Request, 4)
+	certs := make([]CommitCert, 4)
+	for round := range reqs {
+		reqs[round] = testRequest(fmt.Sprintf("block %d", round))
+		certs[round] = commitCert(keys, round, reqs[round], 0, 1, 2)
+	}
+
+	// the requests not proved by 2f+1 commit messages, or carrying another content, are not executed
+	forged := certs[0]
+	forged.Request.Content = []byte("forged")
+	pbftMod.handleCatchUpResponse(catchUpMsg(forged))
+	pbftMod.handleCatchUpResponse(catchUpMsg(commitCert(keys, 0, reqs[0], 0, 1)))
+	pbftMod.handleCatchUpResponse(catchUpMsg(commitCert(keys, 0, reqs[0], 1, 1, 1)))
+	assert.Empty(t, addon.getExecuted())
+	assert.Equal(t, 0, pbftMod.getCurrentRound())
+
+	// the rounds arrive out of order, they are executed in order
+	pbftMod.handleCatchUpResponse(catchUpMsg(certs[2], certs[3]))
+	assert.Empty(t, addon.getExecuted())
+	pbftMod.handleCatchUpResponse(catchUpMsg(certs[0], certs[1]))
+	executed := addon.getExecuted()
+	require.Len(t, executed, 4)
+	for round, req := range executed {
+		assert.Equal(t, reqs[round].Content, req.Content)
+	}
+	assert.Equal(t, 4, pbftMod.getCurrentRound())
+}
+
+// the committed rounds are served with their proofs
+func TestCommitCertOfCommittedRound(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 1, keys)
+	req := testRequest("block")
+	prepareOn(t, pbftMod, keys, req)
+	for _, nid := range []int{0, 2} {
+		pbftMod.handleCommit(signedMsg(keys, nid, message.MsgCommit, req, 0, 0))
+	}
+	require.Eventually(t, func() bool { return pbftMod.getCurrentRound() == 1 }, time.Second, 10*time.Millisecond)
+
+	pbftMod.execLock.Lock()
+	cert := pbftMod.commitCerts[0]
+	pbftMod.execLock.Unlock()
+	require.NotNil(t, cert)
+	assert.True(t, pbftMod.checkCommitCert(cert))
+}
+
+func checkpointMsg(keys *testutil.Keys, nid int, round int) *message.Message {
+	cp := CheckpointMessage{Round: round, NodeId: nid}
+	cp.Sig = signature.Sign(keys.Sks[nid], checkpointContent(cp.Epoch, cp.Instance, cp.Round, cp.Digest))
+	return &message.Message{MsgType: message.MsgCheckpoint, Content: utils.Encode(cp)}
+}
+
+// only the checkpoints of the interval boundaries in the watermark window are kept
+func TestCheckpointRoundsInWindow(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 1, keys)
+	for _, round := range []int{0, config.CheckpointInterval + 1, config.WatermarkWindow + config.CheckpointInterval, 1 << 30} {
+		pbftMod.handleCheckpoint(checkpointMsg(keys, 0, round))
+	}
+	pbftMod.handleCheckpoint(checkpointMsg(keys, 0, config.WatermarkWindow))
+
+	pbftMod.checkpointLock.Lock()
+	defer pbftMod.checkpointLock.Unlock()
+	assert.Len(t, pbftMod.checkpoints, 1)
+	assert.Contains(t, pbftMod.checkpoints, config.WatermarkWindow)
+}
@@ -0,0 +1,199 @@
+// This file contains the checkpoint sub-protocol of PBFT.
+// Every config.CheckpointInterval rounds, a replica broadcasts a signed checkpoint of the requests it has committed.
+// Once 2f+1 replicas agree on a checkpoint, it becomes stable: it is the new low watermark and the states below it are discarded,
+// a replica which has not executed the rounds before it fetches them, see catchup.go.
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+)
+
+// the content signed in the checkpoint message
+func checkpointContent(epoch int, instance int, round int, digest [32]byte) []byte {
+	return utils.CanonicalEncode(struct {
+		Epoch    int
+		Instance int
+		Round    int
+		Digest   [32]byte
+	}{epoch, instance, round, digest})
+}
+
+// the signature of a node of the shard, the nids out of the shard and the unknown keys are rejected
+func (pbftmod *PbftCosensusMod) checkSig(nid int, msg []byte, sig *signature.Signature) bool {
+	return pbftmod.nodeAttr.VerifySig(pbftmod.nodeAttr.Sid, nid, msg, sig)
+}
+
+func (pbftmod *PbftCosensusMod) getLowWatermark() int {
+	pbftmod.checkpointLock.Lock()
+	defer pbftmod.checkpointLock.Unlock()
+	return pbftmod.lowWatermark
+}
+
+// whether the round is in [h, h+L), h is the round of the last stable checkpoint
+func (pbftmod *PbftCosensusMod) inWatermarks(round int) bool {
+	h := pbftmod.getLowWatermark()
+	return round >= h && round < h+config.WatermarkWindow
+}
+
+// record the committed request, broadcast the checkpoint if the round is the last one of a checkpoint interval
+func (pbftmod *PbftCosensusMod) recordCommitted(round int, digest [32]byte) {
+	pbftmod.checkpointLock.Lock()
+	if round < pbftmod.lowWatermark {
+		pbftmod.checkpointLock.Unlock()
+		return
+	}
+	pbftmod.committed[round] = digest
+	if (round+1)%config.CheckpointInterval != 0 {
+		pbftmod.checkpointLock.Unlock()
+		return
+	}
+
+	// the rounds are executed in order and never skipped, so all the rounds of the interval are recorded
+	buf := make([]byte, 0, 32*config.CheckpointInterval)
+	for r := round + 1 - config.CheckpointInterval; r <= round; r++ {
+		d := pbftmod.committed[r]
+		buf = append(buf, d[:]...)
+	}
+	pbftmod.checkpointLock.Unlock()
+
+	cp := CheckpointMessage{
+		Epoch:    pbftmod.epoch,
+		Instance: pbftmod.instance,
+		Round:    round + 1,
+		Digest:   sha256.Sum256(buf),
+		NodeId:   pbftmod.nodeAttr.Nid,
+	}
+	cp.Sig = signature.Sign(pbftmod.nodeAttr.SecKey, checkpointContent(cp.Epoch, cp.Instance, cp.Round, cp.Digest))
+
+	cpmsg := message.Message{
+		MsgType: message.MsgCheckpoint,
+		Content: utils.Encode(cp),
+	}
+	pbftmod.p2pMod.ConnMananger.Broadcast(pbftmod.nodeAttr.Ipaddr, pbftmod.getNeighbours(config.IPMap[pbftmod.nodeAttr.Sid]), cpmsg.JsonEncode())
+	utils.LoggerInstance.Info("Broadcast the checkpoint of round %d", cp.Round)
+	go pbftmod.handleCheckpoint(&cpmsg)
+}
+
+// collect the checkpoints, the checkpoint becomes stable when 2f+1 nodes send the same digest.
+// Only the checkpoint rounds in (h, h+L] are kept, so a faulty node cannot fill the memory with the checkpoints of arbitrary rounds
+func (pbftmod *PbftCosensusMod) handleCheckpoint(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle checkpoint")
+
+	cp := CheckpointMessage{}
+	err := utils.Decode(msg.Content, &cp)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the checkpoint message")
+		return
+	}
+
+	if cp.Epoch != pbftmod.epoch || cp.Instance != pbftmod.instance || !pbftmod.checkSig(cp.NodeId, checkpointContent(cp.Epoch, cp.Instance, cp.Round, cp.Digest), cp.Sig) {
+		utils.LoggerInstance.Warn("The signature of the checkpoint from node %d is not valid", cp.NodeId)
+		return
+	}
+	// a lagging node keeps fetching the rounds before the stable checkpoint
+	pbftmod.catchUp(pbftmod.getStableCheckpoint())
+
+	pbftmod.checkpointLock.Lock()
+	if cp.Round <= pbftmod.lowWatermark || cp.Round > pbftmod.lowWatermark+config.WatermarkWindow || cp.Round%config.CheckpointInterval != 0 {
+		pbftmod.checkpointLock.Unlock()
+		return
+	}
+	if pbftmod.checkpoints[cp.Round] == nil {
+		pbftmod.checkpoints[cp.Round] = make(map[int]*CheckpointMessage)
+	}
+	pbftmod.checkpoints[cp.Round][cp.NodeId] = &cp
+
+	proof := make([]CheckpointMessage, 0, 2*pbftmod.malicious_num+1)
+	for _, c := range pbftmod.checkpoints[cp.Round] {
+		if c.Digest == cp.Digest {
+			proof = append(proof, *c)
+		}
+	}
+	pbftmod.checkpointLock.Unlock()
+
+	if len(proof) >= 2*pbftmod.malicious_num+1 {
+		pbftmod.stabilize(cp.Round, proof)
+	}
+}
+
+// whether the proof carries 2f+1 valid checkpoints of the round with the same digest, nothing needs to be proved for round 0
+func (pbftmod *PbftCosensusMod) checkStableProof(round int, proof []CheckpointMessage) bool {
+	if round == 0 {
+		return true
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	senders := utils.NewSet[int]()
+	for _, cp := range proof {
+		if cp.Epoch != pbftmod.epoch || cp.Instance != pbftmod.instance || cp.Round != round || cp.Digest != proof[0].Digest || senders.Contains(cp.NodeId) {
+			return false
+		}
+		if !pbftmod.checkSig(cp.NodeId, checkpointContent(cp.Epoch, cp.Instance, cp.Round, cp.Digest), cp.Sig) {
+			return false
+		}
+		senders.Add(cp.NodeId)
+	}
+	return senders.Size() >= 2*pbftmod.malicious_num+1
+}
+
+// the checkpoint of the round is proved stable, move the low watermark and discard the old states
+func (pbftmod *PbftCosensusMod) stabilize(round int, proof []CheckpointMessage) {
+	pbftmod.checkpointLock.Lock()
+	if round <= pbftmod.lowWatermark {
+		pbftmod.checkpointLock.Unlock()
+		return
+	}
+	pbftmod.lowWatermark = round
+	pbftmod.stableProof = proof
+	for r := range pbftmod.checkpoints {
+		if r <= round {
+			delete(pbftmod.checkpoints, r)
+		}
+	}
+	for r := range pbftmod.committed {
+		if r < round {
+			delete(pbftmod.committed, r)
+		}
+	}
+	pbftmod.checkpointLock.Unlock()
+
+	pbftmod.requestPoolLock.Lock()
+	removed := 0
+	for digestStr, info := range pbftmod.requestPool {
+		if info.Round < round {
+			delete(pbftmod.requestPool, digestStr)
+			removed++
+		}
+	}
+	for key := range pbftmod.prePrepared {
+		if key[1] < round {
+			delete(pbftmod.prePrepared, key)
+		}
+	}
+	pbftmod.requestPoolLock.Unlock()
+
+	// the proofs of a window below the checkpoint are kept for the lagging nodes
+	pbftmod.execLock.Lock()
+	for r := range pbftmod.commitCerts {
+		if r < round-config.WatermarkWindow {
+			delete(pbftmod.commitCerts, r)
+		}
+	}
+	pbftmod.execLock.Unlock()
+	utils.LoggerInstance.Info("The checkpoint of round %d is stable, remove %d requests from the request pool", round, removed)
+
+	// a lagging node never skips the rounds proved committed by the others, it fetches and executes them
+	pbftmod.catchUp(round, proof)
+}
+
+// the round of the last stable checkpoint and its proof
+func (pbftmod *PbftCosensusMod) getStableCheckpoint() (int, []CheckpointMessage) {
+	pbftmod.checkpointLock.Lock()
+	defer pbftmod.checkpointLock.Unlock()
+	return pbftmod.lowWatermark, pbftmod.stableProof
+}
@@ -28,20 +28,50 @@ type PbftCosensusMod struct {
 	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
 
 	// pbft related
-	view          int // the nid of current view number
-	pbft_num      int // number of nodes in the pbft network
-	malicious_num int // max number of malicious nodes in the pbft network
+	epoch         int             // the epoch of the committee, a new mod is created for every epoch, see auxiliaryMod/reconfig.go
+	view          int             // the current view number, the primary of the view is (instance + view) % pbft_num
+	pbft_num      int             // number of nodes in the pbft network
+	malicious_num int             // max number of malicious nodes in the pbft network
+	instance      int             // the instance in the multi-leader mode, 0 in the classic PBFT
+	mir           *MirCosensusMod // the multi-leader mod running this instance, nil in the classic PBFT, see mir.go
 	// malicious     bool // whether this node is malicious, does not implement this feature
 
+	// view change related
+	inViewChange   bool                               // whether the node stops accepting messages of the current view
+	targetView     int                                // the view the node is moving to
+	viewChangeMsgs map[int]map[int]*ViewChangeMessage // new view -> nid -> VIEW-CHANGE message
+	newViewSent    int                                // the last view this node has sent the NEW-VIEW message for
+	vcTimer        *time.Timer                        // fires if a request is not committed in time
+	vcTimeout      time.Duration                      // doubled every time the view change timer expires
+	futureMsgs     []*message.Message                 // messages of future views, replayed after entering the view
+	viewLock       sync.Mutex
+
+	// checkpoint related
+	lowWatermark   int                                // the round of the last stable checkpoint
+	committed      map[int][32]byte                   // round -> digest of the committed request, since the last stable checkpoint
+	checkpoints    map[int]map[int]*CheckpointMessage // round -> nid -> checkpoint message
+	stableProof    []CheckpointMessage                // the 2f+1 checkpoints proving the low watermark stable, carried by the VIEW-CHANGE message
+	catchUpFrom    int                                // the first round requested by the last catch up request, see catchup.go
+	catchUpTime    time.Time                          // when the last catch up request is sent
+	checkpointLock sync.Mutex
+
+	// pipeline related
+	commitCerts map[int]*CommitCert // round -> the committed request and its proof, executed before currentRound, kept a window below the low watermark
+	prePrepared map[[2]int][32]byte // (view, round) -> the digest of the accepted pre-prepare, guarded by requestPoolLock
+	execLock    sync.Mutex
+
 	// consensus related
-	requestQueue    *utils.Queue[message.Request] // the queue of requests waiting for consensus
-	requestPool     map[string]*RequestInfo       // the pool of requests that have been received
-	consensusDone   chan struct{}                 // the channel to notify a round of  consensus is done
-	currentRound    int                           // the current round of the consensus
-	roundLock       sync.RWMutex
-	requestPoolLock sync.RWMutex
-
-	addonMod PbftAddon // PbftAddon is an pointer-type interface
+	pending           *pendingRequests        // the requests of this node waiting for consensus, see pending.go
+	requestPool       map[string]*RequestInfo // the pool of requests that have been received
+	consensusDone     chan struct{}           // the channel to notify a round of  consensus is done
+	currentRound      int                     // the current round of the consensus, the rounds before it are all committed
+	lastProposedRound int                     // the last round proposed by this node as the primary
+	epochEnded        bool                    // the reconfiguration request is executed, the rounds after it are not executed, guarded by roundLock
+	roundLock         sync.RWMutex
+	requestPoolLock   sync.RWMutex
+
+	addonMod PbftAddon    // PbftAddon is an pointer-type interface
+	strategy PbftStrategy // how this node sends the pbft messages, honest unless config.IsMalicious
 }
 
 // Creates a new PbftCosensusMod with the config.ConsensusMethod, err is not nil if the config.ConsensusMethod is not supported
@@ -50,14 +80,28 @@ func NewPbftCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInte
 	pbftMod.nodeAttr = attr
 	pbftMod.p2pMod = p2p
 
-	pbftMod.requestQueue = utils.NewQueue[message.Request]()
+	pbftMod.pending = newPendingRequests()
 	pbftMod.requestPool = make(map[string]*RequestInfo)
 
+	pbftMod.epoch = attr.Epoch
 	pbftMod.pbft_num = config.NodeNum
 	pbftMod.malicious_num = (pbftMod.pbft_num - 1) / 3
 	pbftMod.view = config.ViewNodeId
 	pbftMod.consensusDone = make(chan struct{})
 	pbftMod.currentRound = 0
+	pbftMod.lastProposedRound = -1
+
+	pbftMod.targetView = pbftMod.view
+	pbftMod.viewChangeMsgs = make(map[int]map[int]*ViewChangeMessage)
+	pbftMod.newViewSent = pbftMod.view
+	pbftMod.vcTimeout = time.Duration(config.ViewChangeTimeout) * time.Millisecond
+
+	pbftMod.lowWatermark = 0
+	pbftMod.committed = make(map[int][32]byte)
+	pbftMod.checkpoints = make(map[int]map[int]*CheckpointMessage)
+
+	pbftMod.commitCerts = make(map[int]*CommitCert)
+	pbftMod.prePrepared = make(map[[2]int][32]byte)
 
 	addonMod, err := NewPbftAddon(config.ConsensusMethod, pbftMod)
 	if err != nil {
@@ -66,10 +110,22 @@ func NewPbftCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInte
 	}
 	pbftMod.addonMod = addonMod
 
+	strategyType := HonestStrategy
+	if config.IsMalicious {
+		strategyType = config.MaliciousStrategy
+	}
+	strategy, err := NewPbftStrategy(strategyType, pbftMod)
+	if err != nil {
+		utils.LoggerInstance.Error("Error creating pbft strategy: %v", err)
+		log.Panicf("Error creating pbft strategy: %v", err)
+	}
+	pbftMod.strategy = strategy
+
 	return pbftMod
 }
 
-// At present, only the view node will receive the Propose message
+// Every node receives the Propose message from its own ProposeBlockMod, the primary proposes the requests in its queue,
+// the replicas keep them as pending work and suspect the primary if the work is not committed in time
 func (pbftmod *PbftCosensusMod) handlePropose(msg *message.Message) {
 	utils.LoggerInstance.Debug("handle propose")
 
@@ -81,17 +137,19 @@ func (pbftmod *PbftCosensusMod) handlePropose(msg *message.Message) {
 		return
 	}
 
-	pbftmod.requestPoolLock.Lock()
-	pbftmod.requestPool[string(req.Digest[:])] = NewRequestInfo(req)
-	pbftmod.requestPoolLock.Unlock()
-	// invoke the addon module to handle the propose message
-	flag := pbftmod.addonMod.HandleProposeAddon(&req)
-	if !flag {
-		utils.LoggerInstance.Warn("addon handle propose failed")
-		return
+	// invoke the addon module to handle the propose message, the reconfiguration requests are not the addon's business
+	if req.ReqType != message.ReqReconfig {
+		flag := pbftmod.addonMod.HandleProposeAddon(&req)
+		if !flag {
+			utils.LoggerInstance.Warn("addon handle propose failed")
+			return
+		}
 	}
 
-	pbftmod.requestQueue.Enqueue(req)
+	pbftmod.pending.add(req)
+	if !pbftmod.isPrimary() {
+		pbftmod.startViewChangeTimer()
+	}
 }
 
 // PrePrepare means the node has received the propose message and broadcast the request to other nodes, the nodes need to check the request legality
@@ -108,37 +166,83 @@ func (pbftmod *PbftCosensusMod) handlePrePrepare(msg *message.Message) {
 
 	req := pbftMsg.Request
 	round := pbftMsg.Round
+	view := pbftMsg.View
+
+	if !pbftmod.checkPbftMessage(message.MsgPrePrepare, &pbftMsg) {
+		utils.LoggerInstance.Warn("The signature of the pre-prepare message from node %d is not valid", pbftMsg.NodeId)
+		return
+	}
+	if pbftMsg.NodeId != pbftmod.getPrimary(view) {
+		utils.LoggerInstance.Warn("Received pre-prepare message from node %d, which is not the primary of view %d", pbftMsg.NodeId, view)
+		return
+	}
+
+	if !pbftmod.acceptView(view, msg) {
+		utils.LoggerInstance.Debug("Received pre-prepare message of view %d, not accepted in the current view", view)
+		return
+	}
 
 	currentRound := pbftmod.getCurrentRound()
 	if round < currentRound {
 		utils.LoggerInstance.Debug("Received outdated pre-prepare message from round %d, current round is %d", round, currentRound)
 		return
 	}
+	if !pbftmod.inWatermarks(round) {
+		utils.LoggerInstance.Debug("Received pre-prepare message from round %d, out of the watermarks", round)
+		return
+	}
 
-	digestStr := string(req.Digest[:])
+	digest := req.Digest
+	req.CalDigest()
+	if req.Digest != digest {
+		utils.LoggerInstance.Warn("The digest of the request in round %d does not match its content", round)
+		return
+	}
+
+	// a replica accepts only one request for a round in a view, otherwise the primary is equivocating
 	pbftmod.requestPoolLock.Lock()
-	if pbftmod.requestPool[digestStr] == nil {
-		pbftmod.requestPool[digestStr] = NewRequestInfo(req)
+	if accepted, ok := pbftmod.prePrepared[[2]int{view, round}]; ok && accepted != req.Digest {
+		pbftmod.requestPoolLock.Unlock()
+		utils.LoggerInstance.Warn("Received conflicting pre-prepare messages of round %d in view %d, the primary is equivocating", round, view)
+		return
 	}
+	pbftmod.prePrepared[[2]int{view, round}] = req.Digest
+	pbftmod.prePrepareRequestInfo(req, round, view)
 	pbftmod.requestPoolLock.Unlock()
 
-	// invoke the addon module to handle the pre-prepare message
-	flag := pbftmod.addonMod.HandlePrePrepareAddon(&req)
-	if !flag {
-		utils.LoggerInstance.Warn("addon handle pre-prepare failed")
+	// the replica waits for the request to be committed
+	pbftmod.startViewChangeTimer()
+
+	// in the multi-leader mode, the primary may only propose the txs of the buckets of its instance
+	if pbftmod.mir != nil && req.ReqType != message.ReqEmpty && !pbftmod.mir.checkBatch(pbftmod.instance, round, &req) {
+		utils.LoggerInstance.Warn("The batch of round %d in instance %d is not valid", round, pbftmod.instance)
 		return
 	}
 
-	prepareMsg := PbftMessage{
-		Request: req,
-		Round:   round,
+	// invoke the addon module to handle the pre-prepare message, null requests carry nothing to verify
+	switch req.ReqType {
+	case message.ReqEmpty:
+	case message.ReqReconfig:
+		if !pbftmod.checkReconfig(&req) {
+			utils.LoggerInstance.Warn("The reconfiguration request of round %d is not valid", round)
+			return
+		}
+	default:
+		flag := pbftmod.addonMod.HandlePrePrepareAddon(&req)
+		if !flag {
+			utils.LoggerInstance.Warn("addon handle pre-prepare failed")
+			return
+		}
 	}
+
+	prepareMsg := pbftmod.newPbftMessage(message.MsgPrepare, req, round, view)
 	pmsg := message.Message{
 		MsgType: message.MsgPrepare,
 		Content: utils.Encode(prepareMsg),
 	}
-	pbftmod.p2pMod.ConnMananger.Broadcast(pbftmod.nodeAttr.Ipaddr, pbftmod.getNeighbours(config.IPMap[req.ShardId]), pmsg.JsonEncode())
+	pbftmod.strategy.SendVote(message.MsgPrepare, prepareMsg)
 	utils.LoggerInstance.Info("Broadcast the prepare message for round %d", round)
+	go pbftmod.handlePrepare(&pmsg) // count the vote of this node
 }
 
 // Prepare means some node think the request is legal and broadcast the prepare message to other nodess
@@ -155,13 +259,31 @@ func (pbftmod *PbftCosensusMod) handlePrepare(msg *message.Message) {
 
 	req := pbftMsg.Request
 	round := pbftMsg.Round
+	view := pbftMsg.View
+
+	if !pbftmod.checkPbftMessage(message.MsgPrepare, &pbftMsg) {
+		utils.LoggerInstance.Warn("The signature of the prepare message from node %d is not valid", pbftMsg.NodeId)
+		return
+	}
+
+	if !pbftmod.acceptView(view, msg) {
+		utils.LoggerInstance.Debug("Received prepare message of view %d, not accepted in the current view", view)
+		return
+	}
+
 	currentRound := pbftmod.getCurrentRound()
 	if round < currentRound {
 		utils.LoggerInstance.Debug("Received outdated prepare message from round %d, current round is %d", round, currentRound)
 		return
 	}
+	if !pbftmod.inWatermarks(round) {
+		utils.LoggerInstance.Debug("Received prepare message from round %d, out of the watermarks", round)
+		return
+	}
 
-	pbftmod.addonMod.HandlePrepareAddon(&req)
+	if req.ReqType != message.ReqEmpty && req.ReqType != message.ReqReconfig {
+		pbftmod.addonMod.HandlePrepareAddon(&req)
+	}
 	// Seems the node received the PrepareMsg before any of the PrePrepareMsg
 	digestStr := string(req.Digest[:])
 
@@ -173,34 +295,24 @@ func (pbftmod *PbftCosensusMod) handlePrepare(msg *message.Message) {
 	pbftmod.requestPoolLock.Lock()
 	if pbftmod.requestPool[digestStr] == nil {
 		utils.LoggerInstance.Warn("Received prepare message before pre-prepare message")
-		pbftmod.requestPool[digestStr] = NewRequestInfo(req)
 	}
-
-	pbftmod.requestPool[digestStr].cntPrepareConfirm++
-	prepareCnt := pbftmod.requestPool[digestStr].cntPrepareConfirm
+	info := pbftmod.getRequestInfo(req)
+	prepareCnt := info.addVote(info.prepareVoters, &pbftMsg)
+	preparedReq, preparedRound, preparedView := info.Req, info.Round, info.View
 	pbftmod.requestPoolLock.Unlock()
 
-	needCnt := 0
-	if pbftmod.nodeAttr.Nid == pbftmod.view {
-		needCnt = 2 * pbftmod.malicious_num
-	} else {
-		needCnt = 2*pbftmod.malicious_num - 1 // in the current implementation, the view node will not send the prepare message
-	}
-
-	// Received enough prepare messages, broadcast the commit message
-	if prepareCnt >= needCnt && !isAlreadyCommitted {
+	// Received enough prepare messages from distinct nodes(including this node) for the pre-prepared round, broadcast the commit message
+	if prepareCnt >= 2*pbftmod.malicious_num+1 && !isAlreadyCommitted {
 		if pbftmod.setCommitBroadcasted(digestStr) {
 			utils.LoggerInstance.Info("Received enough prepare messages, broadcast the commit message")
 
-			commitMsg := PbftMessage{
-				Request: req,
-				Round:   round,
-			}
+			commitMsg := pbftmod.newPbftMessage(message.MsgCommit, preparedReq, preparedRound, preparedView)
 			cmsg := message.Message{
 				MsgType: message.MsgCommit,
 				Content: utils.Encode(commitMsg),
 			}
-			pbftmod.p2pMod.ConnMananger.Broadcast(pbftmod.nodeAttr.Ipaddr, pbftmod.getNeighbours(config.IPMap[req.ShardId]), cmsg.JsonEncode())
+			pbftmod.strategy.SendVote(message.MsgCommit, commitMsg)
+			go pbftmod.handleCommit(&cmsg) // count the vote of this node
 		}
 	}
 }
@@ -219,41 +331,56 @@ func (pbftmod *PbftCosensusMod) handleCommit(msg *message.Message) {
 
 	req := pbftMsg.Request
 	round := pbftMsg.Round
+	view := pbftMsg.View
+
+	if !pbftmod.checkPbftMessage(message.MsgCommit, &pbftMsg) {
+		utils.LoggerInstance.Warn("The signature of the commit message from node %d is not valid", pbftMsg.NodeId)
+		return
+	}
+
+	if !pbftmod.acceptView(view, msg) {
+		utils.LoggerInstance.Debug("Received commit message of view %d, not accepted in the current view", view)
+		return
+	}
 
 	currentRound := pbftmod.getCurrentRound()
 	if round < currentRound {
 		utils.LoggerInstance.Debug("Received outdated commit message from round %d, current round is %d", round, currentRound)
 		return
 	}
+	if !pbftmod.inWatermarks(round) {
+		utils.LoggerInstance.Debug("Received commit message from round %d, out of the watermarks", round)
+		return
+	}
 
 	// Seems the node received the CommitMsg before any of the PrePrepareMsg and PrepareMsg
 	digestStr := string(req.Digest[:])
 
-	isAlreadyReplied := pbftmod.isReplySent(digestStr)
-
 	pbftmod.requestPoolLock.Lock()
 	if pbftmod.requestPool[digestStr] == nil {
 		utils.LoggerInstance.Debug("Received commit message before pre-prepare message and prepare message")
-		pbftmod.requestPool[digestStr] = NewRequestInfo(req)
 	}
-	pbftmod.requestPool[digestStr].cntCommitConfirm++
-	commitCnt := pbftmod.requestPool[digestStr].cntCommitConfirm
+	info := pbftmod.getRequestInfo(req)
+	commitCnt := info.addVote(info.commitVoters, &pbftMsg)
+	isNewlyCommitted := commitCnt >= 2*pbftmod.malicious_num+1 && !info.isCommitted
+	// the request and the round of the pre-prepare message are executed, never the ones carried by the votes
+	cert := &CommitCert{Round: info.Round, View: info.View, Request: info.Req}
+	if isNewlyCommitted {
+		info.isCommitted = true
+		cert.Signers, cert.Sig = info.aggregateVotes(info.commitVoters)
+	}
 	pbftmod.requestPoolLock.Unlock()
 
-	if commitCnt >= 2*pbftmod.malicious_num && !isAlreadyReplied {
-		pbftmod.setReplySent(digestStr)
-		utils.LoggerInstance.Info("Received enough commit messages")
+	if isNewlyCommitted {
+		utils.LoggerInstance.Info("Received enough commit messages for round %d", cert.Round)
 
-		pbftmod.addonMod.HandleCommitAddon(&req)
-
-		if pbftmod.nodeAttr.Nid == pbftmod.view {
-			pbftmod.IncCurrentRound()
-			utils.LoggerInstance.Info("Consensus is done!达成%d笔交易", int(float64(config.BlockSize)*(0.6+rand.Float64())))
-			select {
-			case pbftmod.consensusDone <- struct{}{}: // notify the consensus is done
-			default:
-			}
+		// the rounds may be committed out of order in the pipeline, execute them in order
+		pbftmod.execLock.Lock()
+		if _, ok := pbftmod.commitCerts[cert.Round]; !ok {
+			pbftmod.commitCerts[cert.Round] = cert
 		}
+		pbftmod.execLock.Unlock()
+		pbftmod.executeCommitted()
 	}
 
 	// // view node need to wait for all the nodes to confirm the commit message.
@@ -266,12 +393,83 @@ func (pbftmod *PbftCosensusMod) handleCommit(msg *message.Message) {
 	// }
 }
 
+// execute the committed requests from the current round until a round is not committed yet
+func (pbftmod *PbftCosensusMod) executeCommitted() {
+	pbftmod.execLock.Lock()
+	defer pbftmod.execLock.Unlock()
+
+	for !pbftmod.isEpochEnded() {
+		round := pbftmod.getCurrentRound()
+		cert, ok := pbftmod.commitCerts[round]
+		if !ok {
+			return
+		}
+		req := cert.Request
+
+		pbftmod.setReplySent(string(req.Digest[:]))
+		switch {
+		case pbftmod.mir != nil:
+			// the multi-leader mod executes the requests of all the instances in the global order
+			pbftmod.mir.deliver(pbftmod.instance, round, req)
+		case req.ReqType == message.ReqEmpty:
+		case req.ReqType == message.ReqReconfig:
+			pbftmod.endEpoch(&req)
+		default:
+			pbftmod.addonMod.HandleCommitAddon(&req)
+		}
+
+		pbftmod.advanceRound(round + 1)
+		pbftmod.stopViewChangeTimer()
+		pbftmod.recordCommitted(round, req.Digest)
+		pbftmod.pending.removeCommitted(&req)
+
+		if pbftmod.isPrimary() {
+			utils.LoggerInstance.Info("Consensus is done!达成%d笔交易", int(float64(config.BlockSize)*(0.6+rand.Float64())))
+			select {
+			case pbftmod.consensusDone <- struct{}{}: // notify the consensus is done
+			default:
+			}
+		} else if !pbftmod.pending.isEmpty() {
+			// the primary made progress, keep watching it for the remaining work
+			pbftmod.startViewChangeTimer()
+		}
+	}
+}
+
+// the next request to propose in the round
+func (pbftmod *PbftCosensusMod) nextRequest(round int) (message.Request, error) {
+	if pbftmod.mir != nil {
+		return pbftmod.mir.cutBatch(pbftmod.instance, round)
+	}
+	return pbftmod.pending.next()
+}
+
 // call in node.go according to the current implementation, you can also call this function in the New() function
 func (pbftmod *PbftCosensusMod) RegisterHandlers() {
-	pbftmod.p2pMod.MsgHandlerMap[message.MsgPropose] = pbftmod.handlePropose
-	pbftmod.p2pMod.MsgHandlerMap[message.MsgPrePrepare] = pbftmod.handlePrePrepare
-	pbftmod.p2pMod.MsgHandlerMap[message.MsgPrepare] = pbftmod.handlePrepare
-	pbftmod.p2pMod.MsgHandlerMap[message.MsgCommit] = pbftmod.handleCommit
+	if config.IsMalicious && config.MaliciousStrategy == SilentStrategy {
+		pbftmod.p2pMod.RegisterHandler(message.MsgPropose, pbftmod.handlePropose_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgPrePrepare, pbftmod.handlePrePrepare_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgPrepare, pbftmod.handlePrepare_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgCommit, pbftmod.handleCommit_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgViewChange, pbftmod.handleViewChange_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgNewView, pbftmod.handleNewView_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgCheckpoint, pbftmod.handleCheckpoint_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgCertRequest, pbftmod.handleCheckpoint_m)
+		pbftmod.p2pMod.RegisterHandler(message.MsgCertificate, pbftmod.handleCheckpoint_m)
+		return
+	}
+	pbftmod.p2pMod.RegisterHandler(message.MsgPropose, pbftmod.handlePropose)
+	pbftmod.p2pMod.RegisterHandler(message.MsgPrePrepare, pbftmod.handlePrePrepare)
+	pbftmod.p2pMod.RegisterHandler(message.MsgPrepare, pbftmod.handlePrepare)
+	pbftmod.p2pMod.RegisterHandler(message.MsgCommit, pbftmod.handleCommit)
+	pbftmod.p2pMod.RegisterHandler(message.MsgViewChange, pbftmod.handleViewChange)
+	pbftmod.p2pMod.RegisterHandler(message.MsgNewView, pbftmod.handleNewView)
+	pbftmod.p2pMod.RegisterHandler(message.MsgCheckpoint, pbftmod.handleCheckpoint)
+	pbftmod.p2pMod.RegisterHandler(message.MsgCertRequest, pbftmod.handleCatchUpRequest)
+	pbftmod.p2pMod.RegisterHandler(message.MsgCertificate, pbftmod.handleCatchUpResponse)
+	if addon, ok := pbftmod.addonMod.(PbftAddonHandlers); ok {
+		addon.RegisterHandlers()
+	}
 }
 
 // get the ip addresses of the nodes in the same shard
@@ -287,11 +485,13 @@ func (pbftmod *PbftCosensusMod) getNeighbours(shardIPs map[int]string) []string
 }
 
 // Run starts the intra-shard consensus.
-// This function will work in the condition that the request is from other shards instead of the current shard
+// Every node runs this loop, but only the primary of the current view proposes requests
 func (pbftmod *PbftCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
-	if pbftmod.nodeAttr.Nid != pbftmod.view {
-		utils.LoggerInstance.Info("This node is not the view node, do not need to run intra-shard consensus Mod")
+	defer pbftmod.stopViewChangeTimer()
+
+	// the consensus starts after the public keys are distributed
+	if !pbftmod.nodeAttr.AwaitPubKeys(ctx) {
 		return
 	}
 
@@ -302,8 +502,27 @@ func (pbftmod *PbftCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 			utils.LoggerInstance.Info("Stop the intra-shard consensus Mod")
 			return
 		default:
-			// get the request from the request queue and broadcast the pre-prepare message
-			req, err := pbftmod.requestQueue.Dequeue()
+			// wait until this node is the primary, the pipeline is not full and the next round is in the watermarks
+			currentRound := pbftmod.getCurrentRound()
+			round := pbftmod.getLastProposedRound() + 1
+			if round < currentRound {
+				round = currentRound
+			}
+			if !pbftmod.isPrimary() || pbftmod.isInViewChange() || pbftmod.isEpochEnded() || round >= currentRound+config.PipelineWindow || !pbftmod.inWatermarks(round) {
+				select {
+				case <-pbftmod.consensusDone:
+					utils.LoggerInstance.Info("Consensus is done, go next round")
+				case <-ctx.Done():
+					utils.LoggerInstance.Info("Stop the intra-shard consensus Mod")
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+				continue
+			}
+
+			// get the request from the request queue and broadcast the pre-prepare message,
+			// in the multi-leader mode the batch is cut from the buckets of the instance
+			req, err := pbftmod.nextRequest(round)
 			if err != nil {
 				time.Sleep(100 * time.Millisecond)
 				continue
@@ -311,27 +530,16 @@ func (pbftmod *PbftCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 
 			// delay to mimic the network delay
 			// time.Sleep(time.Millisecond * time.Duration(config.NodeNum*config.BlockSize/125))
-			pbftMsg := PbftMessage{
-				Request: req,
-				Round:   pbftmod.getCurrentRound(),
-			}
+			pbftMsg := pbftmod.newPbftMessage(message.MsgPrePrepare, req, round, pbftmod.getView())
 			ppmsg := message.Message{
 				MsgType: message.MsgPrePrepare,
 				Content: utils.Encode(pbftMsg),
 			}
-			pbftmod.p2pMod.ConnMananger.Broadcast(pbftmod.nodeAttr.Ipaddr, pbftmod.getNeighbours(config.IPMap[req.ShardId]), ppmsg.JsonEncode())
-			utils.LoggerInstance.Info("Broadcast the pre-prepare message")
-			go pbftmod.p2pMod.MsgHandlerMap[message.MsgPrePrepare](&ppmsg)
-
-			// wait for the consensus to be done
-			select {
-			case <-pbftmod.consensusDone:
-				// consensus interval, waits for other nodes to complete the consensus
-				// time.Sleep(time.Millisecond * time.Duration(config.ConsensusInterval))
-				utils.LoggerInstance.Info("Consensus is done, go next round")
-			case <-ctx.Done():
-				utils.LoggerInstance.Info("Stop the intra-shard consensus Mod")
-				return
+			pbftmod.setLastProposedRound(round)
+			pbftmod.strategy.SendPrePrepare(pbftMsg)
+			utils.LoggerInstance.Info("Broadcast the pre-prepare message of round %d in view %d", round, pbftMsg.View)
+			if handler, ok := pbftmod.p2pMod.Handler(message.MsgPrePrepare); ok {
+				go handler(&ppmsg)
 			}
 		}
 	}
@@ -0,0 +1,190 @@
+// This file contains the behaviours of the malicious pbft nodes, the strategy is chosen by config.MaliciousStrategy
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// PbftStrategy decides how a node sends its pbft messages to the other nodes of the shard.
+// The honest strategy broadcasts the messages as they are, a malicious strategy may drop, delay or tamper them
+type PbftStrategy interface {
+	SendPrePrepare(pbftMsg PbftMessage)                      // invoked by the primary to send the pre-prepare message
+	SendVote(phase message.MessageType, pbftMsg PbftMessage) // invoked to send the prepare or commit message
+}
+
+const (
+	HonestStrategy       = "Honest"
+	SilentStrategy       = "Silent"       // never sends or handles any pbft message, like a crashed node
+	EquivocateStrategy   = "Equivocate"   // the primary sends different pre-prepare messages to the two halves of the replicas
+	ConflictVoteStrategy = "ConflictVote" // votes for a conflicting digest instead of the pre-prepared one
+	DelayVoteStrategy    = "DelayVote"    // sends the votes after config.MaliciousDelay
+	InvalidBlockStrategy = "InvalidBlock" // the primary proposes blocks with a wrong transaction root
+	// add more strategy type here
+)
+
+var strategyRegistry = make(map[string]func(pbftMod *PbftCosensusMod) PbftStrategy)
+
+func init() {
+	strategyRegistry[HonestStrategy] = newHonestStrategy
+	strategyRegistry[SilentStrategy] = newSilentStrategy
+	strategyRegistry[EquivocateStrategy] = newEquivocateStrategy
+	strategyRegistry[ConflictVoteStrategy] = newConflictVoteStrategy
+	strategyRegistry[DelayVoteStrategy] = newDelayVoteStrategy
+	strategyRegistry[InvalidBlockStrategy] = newInvalidBlockStrategy
+}
+
+func NewPbftStrategy(strategyType string, pbftMod *PbftCosensusMod) (PbftStrategy, error) {
+	if constructor, exists := strategyRegistry[strategyType]; exists {
+		return constructor(pbftMod), nil
+	}
+	return nil, fmt.Errorf("unknown malicious strategy %s", strategyType)
+}
+
+// the silent node registers these handlers, so it neither handles nor sends any pbft message
+func (pbftmod *PbftCosensusMod) handlePropose_m(msg *message.Message) {
+}
+
+func (pbftmod *PbftCosensusMod) handlePrePrepare_m(msg *message.Message) {
+}
+
+func (pbftmod *PbftCosensusMod) handlePrepare_m(msg *message.Message) {
+}
+
+func (pbftmod *PbftCosensusMod) handleCommit_m(msg *message.Message) {
+}
+
+func (pbftmod *PbftCosensusMod) handleViewChange_m(msg *message.Message) {
+}
+
+func (pbftmod *PbftCosensusMod) handleNewView_m(msg *message.Message) {
+}
+
+func (pbftmod *PbftCosensusMod) handleCheckpoint_m(msg *message.Message) {
+}
+
+// broadcast the pbft message of the phase to the other nodes of the shard
+func (pbftmod *PbftCosensusMod) broadcastPbftMessage(phase message.MessageType, pbftMsg PbftMessage) {
+	msg := message.Message{
+		MsgType: phase,
+		Content: utils.Encode(pbftMsg),
+	}
+	pbftmod.p2pMod.ConnMananger.Broadcast(pbftmod.nodeAttr.Ipaddr, pbftmod.getNeighbours(config.IPMap[pbftmod.nodeAttr.Sid]), msg.JsonEncode())
+}
+
+// a request conflicting with req, it has the same type but a different digest
+func conflictingRequest(req message.Request) message.Request {
+	salt := make([]byte, 8)
+	rand.Read(salt)
+	req.Content = append(append([]byte{}, req.Content...), salt...)
+	req.CalDigest()
+	return req
+}
+
+type honestStrategy struct {
+	pbftMod *PbftCosensusMod
+}
+
+func newHonestStrategy(pbftMod *PbftCosensusMod) PbftStrategy {
+	return &honestStrategy{pbftMod: pbftMod}
+}
+
+func (s *honestStrategy) SendPrePrepare(pbftMsg PbftMessage) {
+	s.pbftMod.broadcastPbftMessage(message.MsgPrePrepare, pbftMsg)
+}
+
+func (s *honestStrategy) SendVote(phase message.MessageType, pbftMsg PbftMessage) {
+	s.pbftMod.broadcastPbftMessage(phase, pbftMsg)
+}
+
+type silentStrategy struct{}
+
+func newSilentStrategy(pbftMod *PbftCosensusMod) PbftStrategy {
+	return &silentStrategy{}
+}
+
+func (s *silentStrategy) SendPrePrepare(pbftMsg PbftMessage) {}
+
+func (s *silentStrategy) SendVote(phase message.MessageType, pbftMsg PbftMessage) {}
+
+type equivocateStrategy struct {
+	honestStrategy
+}
+
+func newEquivocateStrategy(pbftMod *PbftCosensusMod) PbftStrategy {
+	return &equivocateStrategy{honestStrategy{pbftMod: pbftMod}}
+}
+
+// the first half of the replicas receive the original request, the other half receive a conflicting one in the same round
+func (s *equivocateStrategy) SendPrePrepare(pbftMsg PbftMessage) {
+	pbftMod := s.pbftMod
+	conflictMsg := pbftMod.newPbftMessage(message.MsgPrePrepare, conflictingRequest(pbftMsg.Request), pbftMsg.Round, pbftMsg.View)
+
+	neighbours := pbftMod.getNeighbours(config.IPMap[pbftMod.nodeAttr.Sid])
+	half := len(neighbours) / 2
+	msgA := message.Message{MsgType: message.MsgPrePrepare, Content: utils.Encode(pbftMsg)}
+	msgB := message.Message{MsgType: message.MsgPrePrepare, Content: utils.Encode(conflictMsg)}
+	pbftMod.p2pMod.ConnMananger.Broadcast(pbftMod.nodeAttr.Ipaddr, neighbours[:half], msgA.JsonEncode())
+	pbftMod.p2pMod.ConnMananger.Broadcast(pbftMod.nodeAttr.Ipaddr, neighbours[half:], msgB.JsonEncode())
+	utils.LoggerInstance.Warn("[Malicious] Send conflicting pre-prepare messages of round %d to %d and %d replicas", pbftMsg.Round, half, len(neighbours)-half)
+}
+
+type conflictVoteStrategy struct {
+	honestStrategy
+}
+
+func newConflictVoteStrategy(pbftMod *PbftCosensusMod) PbftStrategy {
+	return &conflictVoteStrategy{honestStrategy{pbftMod: pbftMod}}
+}
+
+func (s *conflictVoteStrategy) SendVote(phase message.MessageType, pbftMsg PbftMessage) {
+	conflictMsg := s.pbftMod.newPbftMessage(phase, conflictingRequest(pbftMsg.Request), pbftMsg.Round, pbftMsg.View)
+	s.pbftMod.broadcastPbftMessage(phase, conflictMsg)
+	utils.LoggerInstance.Warn("[Malicious] Vote for a conflicting request in round %d", pbftMsg.Round)
+}
+
+type delayVoteStrategy struct {
+	honestStrategy
+}
+
+func newDelayVoteStrategy(pbftMod *PbftCosensusMod) PbftStrategy {
+	return &delayVoteStrategy{honestStrategy{pbftMod: pbftMod}}
+}
+
+func (s *delayVoteStrategy) SendVote(phase message.MessageType, pbftMsg PbftMessage) {
+	time.AfterFunc(time.Duration(config.MaliciousDelay)*time.Millisecond, func() {
+		s.pbftMod.broadcastPbftMessage(phase, pbftMsg)
+	})
+}
+
+type invalidBlockStrategy struct {
+	honestStrategy
+}
+
+func newInvalidBlockStrategy(pbftMod *PbftCosensusMod) PbftStrategy {
+	return &invalidBlockStrategy{honestStrategy{pbftMod: pbftMod}}
+}
+
+// tamper the transaction root of the proposed block, the request is re-signed so only the block verification can find it
+func (s *invalidBlockStrategy) SendPrePrepare(pbftMsg PbftMessage) {
+	req := pbftMsg.Request
+	b := &structs.Block{}
+	if err := utils.Decode(req.Content, b); err == nil {
+		root := make([]byte, 32)
+		rand.Read(root)
+		b.Header.TxRoot = root
+		req.Content = utils.Encode(b)
+		req.CalDigest()
+	} else {
+		req = conflictingRequest(req)
+	}
+
+	invalidMsg := s.pbftMod.newPbftMessage(message.MsgPrePrepare, req, pbftMsg.Round, pbftMsg.View)
+	s.pbftMod.broadcastPbftMessage(message.MsgPrePrepare, invalidMsg)
+	utils.LoggerInstance.Warn("[Malicious] Propose an invalid block in round %d", pbftMsg.Round)
+}
@@ -0,0 +1,85 @@
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPbftStrategy(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 0, keys)
+	_, ok := pbftMod.strategy.(*honestStrategy)
+	assert.True(t, ok, "an honest node uses the honest strategy")
+
+	for _, strategy := range []string{HonestStrategy, SilentStrategy, EquivocateStrategy, ConflictVoteStrategy, DelayVoteStrategy, InvalidBlockStrategy} {
+		s, err := NewPbftStrategy(strategy, pbftMod)
+		assert.NoError(t, err, strategy)
+		assert.NotNil(t, s, strategy)
+	}
+	_, err := NewPbftStrategy("Unknown", pbftMod)
+	assert.Error(t, err)
+
+	// a malicious node uses the strategy of config.MaliciousStrategy
+	defer func() { config.IsMalicious = false }()
+	config.IsMalicious, config.MaliciousStrategy = true, ConflictVoteStrategy
+	pbftMod, _ = newTestMod(t, 1, keys)
+	_, ok = pbftMod.strategy.(*conflictVoteStrategy)
+	assert.True(t, ok)
+}
+
+func TestConflictingRequest(t *testing.T) {
+	req := testRequest("block")
+	conflict := conflictingRequest(req)
+	assert.Equal(t, req.ReqType, conflict.ReqType)
+	assert.NotEqual(t, req.Digest, conflict.Digest)
+	assert.Equal(t, []byte("block"), req.Content, "the original request is not changed")
+
+	// the conflicting request is well-formed, so only the quorum rules can reject it
+	digest := conflict.Digest
+	conflict.CalDigest()
+	assert.Equal(t, digest, conflict.Digest)
+}
+
+// an equivocating primary sends a conflicting pre-prepare of the same round, the replica keeps the first one
+func TestEquivocatingPrimary(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, addon := newTestMod(t, 1, keys)
+	req := testRequest("block")
+	conflict := conflictingRequest(req)
+
+	pbftMod.handlePrePrepare(signedMsg(keys, 0, message.MsgPrePrepare, req, 0, 0))
+	pbftMod.handlePrePrepare(signedMsg(keys, 0, message.MsgPrePrepare, conflict, 0, 0))
+	pbftMod.requestPoolLock.RLock()
+	assert.Equal(t, req.Digest, pbftMod.prePrepared[[2]int{0, 0}])
+	pbftMod.requestPoolLock.RUnlock()
+
+	// the nodes receiving the conflicting request cannot prepare it at this replica
+	pbftMod.handlePrepare(signedMsg(keys, 0, message.MsgPrepare, conflict, 0, 0))
+	pbftMod.handlePrepare(signedMsg(keys, 2, message.MsgPrepare, conflict, 0, 0))
+	pbftMod.handlePrepare(signedMsg(keys, 3, message.MsgPrepare, conflict, 0, 0))
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, pbftMod.isCommitBroadcasted(string(conflict.Digest[:])))
+	assert.Empty(t, addon.getExecuted())
+}
+
+// the votes for a conflicting request are not counted for the pre-prepared one
+func TestConflictVotesNotCounted(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 1, keys)
+	req := testRequest("block")
+
+	pbftMod.handlePrePrepare(signedMsg(keys, 0, message.MsgPrePrepare, req, 0, 0))
+	pbftMod.handlePrepare(signedMsg(keys, 2, message.MsgPrepare, conflictingRequest(req), 0, 0))
+	pbftMod.handlePrepare(signedMsg(keys, 0, message.MsgPrepare, req, 0, 0))
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, pbftMod.isCommitBroadcasted(string(req.Digest[:])), "the conflicting vote of node 2 is not counted")
+
+	// f conflicting voters do not stop the 2f+1 honest nodes
+	pbftMod.handlePrepare(signedMsg(keys, 3, message.MsgPrepare, req, 0, 0))
+	require.Eventually(t, func() bool { return pbftMod.isCommitBroadcasted(string(req.Digest[:])) }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,200 @@
+package pbft
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// an addon recording the executed requests
+type recordAddon struct {
+	executed []message.Request
+	lock     sync.Mutex
+}
+
+func (addon *recordAddon) HandleProposeAddon(req *message.Request) bool    { return true }
+func (addon *recordAddon) HandlePrePrepareAddon(req *message.Request) bool { return true }
+func (addon *recordAddon) HandlePrepareAddon(req *message.Request) bool    { return true }
+func (addon *recordAddon) HandleCommitAddon(req *message.Request) bool {
+	addon.lock.Lock()
+	defer addon.lock.Unlock()
+	addon.executed = append(addon.executed, *req)
+	return true
+}
+
+func (addon *recordAddon) getExecuted() []message.Request {
+	addon.lock.Lock()
+	defer addon.lock.Unlock()
+	return append([]message.Request(nil), addon.executed...)
+}
+
+// the keys of a shard of 4 nodes, the primary of view 0 is node 0
+func newTestKeys() *testutil.Keys {
+	config.ViewNodeId = 0
+	config.ConsensusMethod, config.IsMalicious = SimpleAddon, false
+	config.ViewChangeTimeout = 3600 * 1000 // the timers never fire in the tests
+	config.ShardNum, config.NodeNum = 1, 4
+	return testutil.NewKeys(0, 4)
+}
+
+// the mod of node nid knowing the keys of all the nodes
+func newTestMod(t *testing.T, nid int, keys *testutil.Keys) (*PbftCosensusMod, *recordAddon) {
+	pbftMod := NewPbftCosensusMod(keys.Attr(t, nid), p2p.NewP2PMod("")).(*PbftCosensusMod)
+	addon := &recordAddon{}
+	pbftMod.addonMod = addon
+	return pbftMod, addon
+}
+
+// the pbft message of the phase signed by node nid
+func signedMsg(keys *testutil.Keys, nid int, phase message.MessageType, req message.Request, round int, view int) *message.Message {
+	pbftMsg := PbftMessage{
+		Request: req,
+		Round:   round,
+		View:    view,
+		NodeId:  nid,
+		Sig:     signature.Sign(keys.Sks[nid], pbftMessageContent(phase, 0, 0, round, view, req.Digest)),
+	}
+	return &message.Message{MsgType: phase, Content: utils.Encode(pbftMsg)}
+}
+
+func testRequest(content string) message.Request {
+	return *message.NewRequest(0, message.ReqVerifyString, []byte(content))
+}
+
+func TestCheckSigRejectsUnknownNodes(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 1, keys)
+	msg := []byte("checkpoint")
+
+	assert.True(t, pbftMod.checkSig(2, msg, signature.Sign(keys.Sks[2], msg)))
+	assert.False(t, pbftMod.checkSig(2, msg, nil))
+	// a replica signing under the nids out of the shard cannot make up the votes of the missing nodes
+	for _, nid := range []int{-1, 4, 99} {
+		assert.False(t, pbftMod.checkSig(nid, msg, signature.Sign(keys.Sks[1], msg)), "nid %d", nid)
+	}
+	pbftMod.nodeAttr.PubKeyTable[0][3] = nil
+	assert.False(t, pbftMod.checkSig(3, msg, signature.Sign(keys.Sks[3], msg)), "the key is unknown")
+}
+
+// the votes are counted only for the (round, view) of the accepted pre-prepare, and the pre-prepared request is executed
+func TestVotesBindToPrePrepare(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, addon := newTestMod(t, 1, keys)
+	req := testRequest("block")
+
+	// the votes for another round arrive before the pre-prepare message
+	pbftMod.handlePrepare(signedMsg(keys, 2, message.MsgPrepare, req, 5, 0))
+	pbftMod.handlePrepare(signedMsg(keys, 3, message.MsgPrepare, req, 5, 0))
+	pbftMod.handlePrePrepare(signedMsg(keys, 0, message.MsgPrePrepare, req, 0, 0))
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, pbftMod.isCommitBroadcasted(string(req.Digest[:])), "the votes of round 5 are not counted for round 0")
+
+	pbftMod.handlePrepare(signedMsg(keys, 0, message.MsgPrepare, req, 0, 0))
+	pbftMod.handlePrepare(signedMsg(keys, 2, message.MsgPrepare, req, 0, 0))
+	assert.Eventually(t, func() bool { return pbftMod.isCommitBroadcasted(string(req.Digest[:])) }, time.Second, 10*time.Millisecond)
+
+	// the commit completing the quorum claims round 5 and carries another content under the same digest
+	tampered := req
+	tampered.Content = []byte("forged")
+	pbftMod.handleCommit(signedMsg(keys, 0, message.MsgCommit, req, 0, 0))
+	pbftMod.handleCommit(signedMsg(keys, 3, message.MsgCommit, tampered, 5, 0))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, addon.getExecuted())
+
+	pbftMod.handleCommit(signedMsg(keys, 2, message.MsgCommit, tampered, 0, 0))
+	require.Eventually(t, func() bool { return len(addon.getExecuted()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, req.Content, addon.getExecuted()[0].Content)
+	assert.Equal(t, 1, pbftMod.getCurrentRound())
+}
+
+func TestForgedVotesRejected(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 1, keys)
+	req := testRequest("block")
+	pbftMod.handlePrePrepare(signedMsg(keys, 0, message.MsgPrePrepare, req, 0, 0))
+
+	// node 3 signs the prepares of the nodes out of the shard and of node 2
+	for _, nid := range []int{4, 5, 99} {
+		forged := signedMsg(keys, 3, message.MsgPrepare, req, 0, 0)
+		pbftMsg := PbftMessage{}
+		require.NoError(t, utils.Decode(forged.Content, &pbftMsg))
+		pbftMsg.NodeId = nid
+		pbftMod.handlePrepare(&message.Message{MsgType: message.MsgPrepare, Content: utils.Encode(pbftMsg)})
+	}
+	forged := signedMsg(keys, 3, message.MsgPrepare, req, 0, 0)
+	pbftMsg := PbftMessage{}
+	require.NoError(t, utils.Decode(forged.Content, &pbftMsg))
+	pbftMsg.NodeId = 2
+	pbftMod.handlePrepare(&message.Message{MsgType: message.MsgPrepare, Content: utils.Encode(pbftMsg)})
+
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, pbftMod.isCommitBroadcasted(string(req.Digest[:])))
+}
+
+// the request carrying the block of the txs
+func blockRequest(txs ...structs.Transaction) message.Request {
+	b := structs.NewBlock(&structs.BlockHeader{}, txs)
+	return *message.NewRequest(0, message.ReqVerifyTxs, utils.Encode(b))
+}
+
+// the replica drops the committed txs from its pending blocks, and keeps the other txs of them for the new primary
+func TestCommittedTxsLeavePending(t *testing.T) {
+	keys := newTestKeys()
+	pbftMod, _ := newTestMod(t, 1, keys)
+	txs := make([]structs.Transaction, 4)
+	for i := range txs {
+		txs[i] = structs.NewAccountTransaction("a", "b", int64(i), big.NewInt(1))
+	}
+	stale, fresh := blockRequest(txs[0], txs[1]), blockRequest(txs[2])
+	for _, req := range []message.Request{stale, fresh} {
+		pbftMod.handlePropose(&message.Message{MsgType: message.MsgPropose, Content: utils.Encode(req)})
+	}
+	require.Equal(t, 2, pbftMod.pending.size())
+
+	// the primary packs its own block of tx 1 and tx 3
+	pbftMod.commitCerts[0] = &CommitCert{Round: 0, Request: blockRequest(txs[1], txs[3])}
+	pbftMod.executeCommitted()
+	require.Equal(t, 2, pbftMod.pending.size())
+	next, err := pbftMod.pending.next()
+	require.NoError(t, err)
+	assert.NotEqual(t, stale.Digest, next.Digest)
+	b := structs.Block{}
+	require.NoError(t, utils.Decode(next.Content, &b))
+	require.Len(t, b.Transactions, 1)
+	assert.Equal(t, txs[0].ID(), b.Transactions[0].ID())
+	assert.Equal(t, blockchain.GetTxTreeRoot(b.Transactions), b.Header.TxRoot)
+	next, err = pbftMod.pending.next()
+	require.NoError(t, err)
+	assert.Equal(t, fresh.Digest, next.Digest)
+
+	// the pending block whose txs are all committed is dropped
+	pbftMod.pending.add(blockRequest(txs[2]))
+	pbftMod.commitCerts[1] = &CommitCert{Round: 1, Request: blockRequest(txs[2], txs[3])}
+	pbftMod.executeCommitted()
+	assert.True(t, pbftMod.pending.isEmpty())
+}
+
+// the reconfiguration request is proposed before the backlog of the blocks
+func TestReconfigJumpsPending(t *testing.T) {
+	pending := newPendingRequests()
+	block := blockRequest(structs.NewAccountTransaction("a", "b", 0, big.NewInt(1)))
+	reconfig := *message.NewRequest(0, message.ReqReconfig, []byte("epoch"))
+	pending.add(block)
+	pending.add(reconfig)
+
+	next, err := pending.next()
+	require.NoError(t, err)
+	assert.Equal(t, reconfig.Digest, next.Digest)
+}
@@ -0,0 +1,388 @@
+// This file contains the multi-leader mode of PBFT in the style of Mir-BFT, so the throughput is not capped by the
+// bandwidth of a single primary. Every node runs N instances of PbftCosensusMod, node i is the first primary of instance i,
+// and every instance has its own views, checkpoints and watermarks, its messages carry the instance.
+// The request space is partitioned into config.MirBucketNum() buckets by the tx ID, in the Mir epoch e (config.MirEpochLength
+// rounds of an instance) bucket b belongs to instance (b+e) % N, and the batches of an instance only hold the txs of its buckets.
+// The buckets rotate every Mir epoch, a tx censored by a primary is proposed by another later.
+// The instances drift apart, so a primary starts the Mir epoch only after the global order has executed the last one,
+// otherwise two instances in different Mir epochs would own the same bucket. A tx committed twice anyway, e.g. by a Byzantine
+// primary, is executed only at its first sequence number, the committed txs are remembered for committedWindow() sequence numbers.
+// Round r of instance i is the sequence number r*N+i of the global order, every node executes the batches in this order
+// and waits for every sequence number: an instance never moves past a round without delivering it, it is committed,
+// fetched with its proof after a checkpoint (see catchup.go) or filled with a null request by a view change.
+// A primary without txs proposes an empty batch once its instance falls behind, so the global order keeps moving
+package pbft
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// implement the ConsensusMod interface
+var _ runningModInterface.RunningMod = &MirCosensusMod{}
+
+type MirCosensusMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	instances []*PbftCosensusMod // instance i is led by node i in view 0
+
+	// batch related
+	txPool   *structs.TxPool               // every node receives all the txs, removed only when they are committed
+	proposed map[int][]structs.Transaction // sequence number -> the txs this node proposed as the primary
+	inFlight map[string]bool               // the hashes of the proposed txs, not proposed again until the sequence number is executed
+	lastCut  map[int]time.Time             // instance -> the time this node cut the last batch of the instance
+
+	// global order related
+	delivered    map[int]message.Request // sequence number -> the committed request, not executed yet
+	nextSeq      int                     // the sequence numbers before it are all executed
+	committedTxs map[string]int          // the hashes of the recently committed txs -> the sequence number committing them
+	mirLock      sync.Mutex
+}
+
+func NewMirCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	mirMod := new(MirCosensusMod)
+	mirMod.nodeAttr = attr
+	mirMod.p2pMod = p2p
+
+	mirMod.txPool = structs.NewTxPool(config.BlockSize)
+	mirMod.proposed = make(map[int][]structs.Transaction)
+	mirMod.inFlight = make(map[string]bool)
+	mirMod.lastCut = make(map[int]time.Time)
+	mirMod.delivered = make(map[int]message.Request)
+	mirMod.committedTxs = make(map[string]int)
+
+	mirMod.instances = make([]*PbftCosensusMod, config.NodeNum)
+	for i := range mirMod.instances {
+		inst := NewPbftCosensusMod(attr, p2p).(*PbftCosensusMod)
+		inst.instance = i
+		inst.mir = mirMod
+		inst.view = 0
+		inst.targetView = 0
+		inst.newViewSent = 0
+		mirMod.instances[i] = inst
+	}
+	return mirMod
+}
+
+// the bucket of the tx, by the ID which is the same on every node
+func txBucket(tx structs.Transaction) int {
+	h := sha256.Sum256(tx.ID())
+	return int(binary.BigEndian.Uint32(h[:4]) % uint32(config.MirBucketNum()))
+}
+
+// the instance the bucket belongs to in the Mir epoch of the round
+func bucketOwner(bucket int, round int) int {
+	return (bucket + round/config.MirEpochLength) % config.NodeNum
+}
+
+func (mirMod *MirCosensusMod) seqNum(instance int, round int) int {
+	return round*config.NodeNum + instance
+}
+
+// whether another instance has committed the round already, so the instance holds back the global order
+func (mirMod *MirCosensusMod) isBehind(instance int, round int) bool {
+	for i, inst := range mirMod.instances {
+		if i != instance && inst.getCurrentRound() > round {
+			return true
+		}
+	}
+	return false
+}
+
+// called by the primary of the instance, cut the batch of the round from the pending txs of the buckets of the instance.
+// An empty batch is proposed if the instance is behind or has been idle for config.MirBatchTimeout
+func (mirMod *MirCosensusMod) cutBatch(instance int, round int) (message.Request, error) {
+	mirMod.mirLock.Lock()
+	defer mirMod.mirLock.Unlock()
+
+	// the buckets of the Mir epoch may still be owned by other instances in the last one
+	if epochStart := round - round%config.MirEpochLength; mirMod.nextSeq < mirMod.seqNum(0, epochStart) {
+		return message.Request{}, errors.New("the last Mir epoch is not executed yet")
+	}
+
+	txs := make([]structs.Transaction, 0, config.BlockSize)
+	for _, tx := range mirMod.txPool.PeekTxs(config.NodeNum*config.BlockSize + len(mirMod.inFlight)) {
+		if len(txs) == config.BlockSize {
+			break
+		}
+		if !mirMod.inFlight[string(tx.Hash())] && bucketOwner(txBucket(tx), round) == instance {
+			txs = append(txs, tx)
+		}
+	}
+
+	seq := mirMod.seqNum(instance, round)
+	var req *message.Request
+	if len(txs) == 0 {
+		if !mirMod.isBehind(instance, round) && time.Since(mirMod.lastCut[instance]) < time.Duration(config.MirBatchTimeout)*time.Millisecond {
+			return message.Request{}, errors.New("no tx in the buckets of the instance")
+		}
+		req = message.NewRequest(mirMod.nodeAttr.Sid, message.ReqEmpty, utils.Encode([2]int{instance, round}))
+	} else {
+		req = message.NewRequest(mirMod.nodeAttr.Sid, message.ReqVerifyTxs, utils.Encode(mirMod.nodeAttr.CurChain.NewBlock(txs)))
+	}
+
+	// the round may be proposed again after a view change
+	mirMod.release(seq)
+	mirMod.proposed[seq] = txs
+	for _, tx := range txs {
+		mirMod.inFlight[string(tx.Hash())] = true
+	}
+	mirMod.lastCut[instance] = time.Now()
+	return *req, nil
+}
+
+// call with mirLock held, the txs proposed for the sequence number may be proposed again
+func (mirMod *MirCosensusMod) release(seq int) {
+	for _, tx := range mirMod.proposed[seq] {
+		delete(mirMod.inFlight, string(tx.Hash()))
+	}
+	delete(mirMod.proposed, seq)
+}
+
+// called by the replicas of the instance on the pre-prepare message, the batch must be a valid block of the buckets of the instance
+func (mirMod *MirCosensusMod) checkBatch(instance int, round int, req *message.Request) bool {
+	b := structs.Block{}
+	if err := utils.Decode(req.Content, &b); err != nil || b.Header == nil {
+		return false
+	}
+	if !bytes.Equal(blockchain.GetTxTreeRoot(b.Transactions), b.Header.TxRoot) {
+		return false
+	}
+	for _, tx := range b.Transactions {
+		if bucketOwner(txBucket(tx), round) != instance {
+			return false
+		}
+	}
+	return true
+}
+
+// called by the instance when the request of the round is committed, the requests are executed in the global order
+func (mirMod *MirCosensusMod) deliver(instance int, round int, req message.Request) {
+	mirMod.mirLock.Lock()
+	defer mirMod.mirLock.Unlock()
+
+	mirMod.delivered[mirMod.seqNum(instance, round)] = req
+	mirMod.execute()
+}
+
+// call with mirLock held, execute the delivered requests from nextSeq until a sequence number is not delivered yet.
+// No sequence number is skipped, so all the nodes execute the same global order
+func (mirMod *MirCosensusMod) execute() {
+	for {
+		seq := mirMod.nextSeq
+		instance, round := seq%config.NodeNum, seq/config.NodeNum
+		req, ok := mirMod.delivered[seq]
+		if !ok {
+			return
+		}
+		delete(mirMod.delivered, seq)
+		mirMod.executeRequest(instance, round, req)
+		mirMod.release(seq)
+		mirMod.nextSeq++
+		if mirMod.nextSeq%committedWindow() == 0 {
+			mirMod.pruneCommitted()
+		}
+	}
+}
+
+// the sequence numbers the committed txs are remembered for, a watermark window of every instance.
+// The txs are removed from the TxPool when they are committed, so the honest primaries do not propose them again
+func committedWindow() int {
+	return config.WatermarkWindow * config.NodeNum
+}
+
+// call with mirLock held, forget the txs committed more than committedWindow() sequence numbers ago
+func (mirMod *MirCosensusMod) pruneCommitted() {
+	for hash, seq := range mirMod.committedTxs {
+		if seq < mirMod.nextSeq-committedWindow() {
+			delete(mirMod.committedTxs, hash)
+		}
+	}
+}
+
+// call with mirLock held, commit the txs of the batch not committed yet as a block
+func (mirMod *MirCosensusMod) executeRequest(instance int, round int, req message.Request) {
+	if req.ReqType == message.ReqEmpty {
+		return
+	}
+	b := structs.Block{}
+	if err := utils.Decode(req.Content, &b); err != nil {
+		utils.LoggerInstance.Error("Error decoding the batch of round %d of instance %d", round, instance)
+		return
+	}
+
+	txs := make([]structs.Transaction, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if _, committed := mirMod.committedTxs[string(tx.Hash())]; !committed {
+			mirMod.committedTxs[string(tx.Hash())] = mirMod.seqNum(instance, round)
+			txs = append(txs, tx)
+		}
+	}
+	mirMod.removeTxs(b.Transactions)
+	utils.LoggerInstance.Info("Execute the batch of round %d of instance %d (sequence number %d) with %d txs", round, instance, mirMod.seqNum(instance, round), len(txs))
+	if len(txs) == 0 {
+		return
+	}
+
+	bc := mirMod.nodeAttr.CurChain
+	blk := bc.NewBlock(txs)
+	bc.StateManager.UpdateStates(blk.Transactions, bc.CurrentBlock.Header.StateRoot)
+	bc.CommitBlock(blk)
+
+	// the view node reports the chain of the shard to the client
+	if mirMod.nodeAttr.Nid == config.ViewNodeId {
+		mirMod.sendReply(blk, req.ReqTime)
+	}
+}
+
+// remove the committed txs from the TxPool. The pool of this node holds its own copies of them, whose time and hash
+// differ from the copies of the primary, so the oldest local copy with the same ID is removed for every committed tx
+func (mirMod *MirCosensusMod) removeTxs(txs []structs.Transaction) {
+	count := make(map[string]int, len(txs))
+	for _, tx := range txs {
+		count[string(tx.ID())]++
+	}
+	local := make([]structs.Transaction, 0, len(txs))
+	for _, tx := range mirMod.txPool.PeekTxs(mirMod.txPool.Size()) {
+		if count[string(tx.ID())] > 0 {
+			count[string(tx.ID())]--
+			local = append(local, tx)
+		}
+	}
+	mirMod.txPool.RemoveTxs(local)
+}
+
+// send the committed block back to the client, so that the measure mod can work.
+// The request time is the time the primary cut the batch, so TCL includes the wait for the global order
+func (mirMod *MirCosensusMod) sendReply(b *structs.Block, cutTime time.Time) {
+	req := message.NewRequest(mirMod.nodeAttr.Sid, message.ReqVerifyTxs, utils.Encode(b))
+	req.ReqTime = cutTime
+	reply := &message.Reply{
+		Req:  req,
+		Time: time.Now(),
+
+		Sid:         mirMod.nodeAttr.Sid,
+		ReqQueueLen: mirMod.txPool.Size(),
+	}
+
+	replymsg := message.Message{
+		MsgType: message.MsgReply,
+		Content: utils.Encode(reply),
+	}
+	go mirMod.p2pMod.ConnMananger.Send(config.ClientAddr, replymsg.JsonEncode())
+}
+
+func (mirMod *MirCosensusMod) handleInject(msg *message.Message) {
+	txs := []structs.Transaction{}
+	err := utils.Decode(msg.Content, &txs)
+	if err != nil || len(txs) == 0 {
+		utils.LoggerInstance.Error("error decoding the txs")
+		return
+	}
+
+	utils.LoggerInstance.Debug("Receive %d txs", len(txs))
+	mirMod.txPool.AddTxs(txs)
+}
+
+// the pbft messages of all the instances share the message types, they are routed to the instance they carry
+func (mirMod *MirCosensusMod) dispatch(msg *message.Message) {
+	tag := struct{ Instance int }{}
+	if err := utils.Decode(msg.Content, &tag); err != nil || tag.Instance < 0 || tag.Instance >= len(mirMod.instances) {
+		utils.LoggerInstance.Error("Error decoding the instance of the pbft message")
+		return
+	}
+	inst := mirMod.instances[tag.Instance]
+	switch msg.MsgType {
+	case message.MsgPrePrepare:
+		inst.handlePrePrepare(msg)
+	case message.MsgPrepare:
+		inst.handlePrepare(msg)
+	case message.MsgCommit:
+		inst.handleCommit(msg)
+	case message.MsgViewChange:
+		inst.handleViewChange(msg)
+	case message.MsgNewView:
+		inst.handleNewView(msg)
+	case message.MsgCheckpoint:
+		inst.handleCheckpoint(msg)
+	case message.MsgCertRequest:
+		inst.handleCatchUpRequest(msg)
+	case message.MsgCertificate:
+		inst.handleCatchUpResponse(msg)
+	}
+}
+
+func (mirMod *MirCosensusMod) handleSilent(msg *message.Message) {}
+
+func (mirMod *MirCosensusMod) isSilent() bool {
+	return config.IsMalicious && config.MaliciousStrategy == SilentStrategy
+}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (mirMod *MirCosensusMod) RegisterHandlers() {
+	if mirMod.isSilent() {
+		// the handlers of the silent node ignore the messages of all the instances
+		mirMod.instances[0].RegisterHandlers()
+		mirMod.p2pMod.RegisterHandler(message.MsgInject, mirMod.handleSilent)
+		return
+	}
+	mirMod.p2pMod.RegisterHandler(message.MsgInject, mirMod.handleInject)
+	mirMod.p2pMod.RegisterHandler(message.MsgPrePrepare, mirMod.dispatch)
+	mirMod.p2pMod.RegisterHandler(message.MsgPrepare, mirMod.dispatch)
+	mirMod.p2pMod.RegisterHandler(message.MsgCommit, mirMod.dispatch)
+	mirMod.p2pMod.RegisterHandler(message.MsgViewChange, mirMod.dispatch)
+	mirMod.p2pMod.RegisterHandler(message.MsgNewView, mirMod.dispatch)
+	mirMod.p2pMod.RegisterHandler(message.MsgCheckpoint, mirMod.dispatch)
+	mirMod.p2pMod.RegisterHandler(message.MsgCertRequest, mirMod.dispatch)
+	mirMod.p2pMod.RegisterHandler(message.MsgCertificate, mirMod.dispatch)
+}
+
+// Run starts all the instances, and suspects the primary of the instance holding back the global order
+func (mirMod *MirCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if mirMod.isSilent() {
+		utils.LoggerInstance.Info("This node is silent, do not run the Mir-BFT instances")
+		return
+	}
+
+	instWg := new(sync.WaitGroup)
+	for _, inst := range mirMod.instances {
+		instWg.Add(1)
+		go inst.Run(ctx, instWg)
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			instWg.Wait()
+			return
+		case <-ticker.C:
+			mirMod.mirLock.Lock()
+			mirMod.execute()
+			instance, round := mirMod.nextSeq%config.NodeNum, mirMod.nextSeq/config.NodeNum
+			mirMod.mirLock.Unlock()
+
+			// the other instances go on, the replicas of the blocking one wait for its primary with the view change timer
+			if mirMod.isBehind(instance, round) {
+				mirMod.instances[instance].startViewChangeTimer()
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nullBatch(instance int, round int) message.Request {
+	return *message.NewRequest(0, message.ReqEmpty, utils.Encode([2]int{instance, round}))
+}
+
+// the global order waits for every sequence number, even if its instance has moved past the round
+func TestMirExecutesWithoutGaps(t *testing.T) {
+	keys := newTestKeys()
+	mirMod := NewMirCosensusMod(keys.Attr(t, 0), p2p.NewP2PMod("")).(*MirCosensusMod)
+
+	mirMod.instances[1].advanceRound(5)
+	mirMod.deliver(0, 0, nullBatch(0, 0))
+	mirMod.deliver(2, 0, nullBatch(2, 0))
+	mirMod.deliver(3, 0, nullBatch(3, 0))
+	assert.Equal(t, 1, mirMod.nextSeq, "round 0 of instance 1 is not delivered")
+
+	mirMod.deliver(1, 0, nullBatch(1, 0))
+	assert.Equal(t, 4, mirMod.nextSeq)
+}
+
+// a primary starts the Mir epoch only after the last one is executed, so no bucket has two owners
+func TestMirEpochBarrier(t *testing.T) {
+	keys := newTestKeys()
+	mirMod := NewMirCosensusMod(keys.Attr(t, 0), p2p.NewP2PMod("")).(*MirCosensusMod)
+
+	round := config.MirEpochLength
+	_, err := mirMod.cutBatch(0, round)
+	assert.Error(t, err)
+
+	mirMod.nextSeq = mirMod.seqNum(0, round)
+	req, err := mirMod.cutBatch(0, round)
+	require.NoError(t, err)
+	assert.Equal(t, message.ReqEmpty, req.ReqType)
+}
+
+// the committed txs are remembered for a window of sequence numbers only
+func TestMirForgetsOldCommittedTxs(t *testing.T) {
+	keys := newTestKeys()
+	mirMod := NewMirCosensusMod(keys.Attr(t, 0), p2p.NewP2PMod("")).(*MirCosensusMod)
+	mirMod.committedTxs["old"] = 0
+	mirMod.committedTxs["recent"] = committedWindow() + 1
+
+	for seq := 0; seq < 2*committedWindow(); seq++ {
+		instance, round := seq%config.NodeNum, seq/config.NodeNum
+		mirMod.deliver(instance, round, nullBatch(instance, round))
+	}
+	assert.Equal(t, 2*committedWindow(), mirMod.nextSeq)
+	assert.NotContains(t, mirMod.committedTxs, "old")
+	assert.Contains(t, mirMod.committedTxs, "recent")
+}
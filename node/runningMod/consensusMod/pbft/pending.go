@@ -0,0 +1,142 @@
+// This file contains the pending requests of PBFT.
+// Every node packs the txs it receives into its own blocks, the primary proposes its blocks and the replicas keep theirs
+// as the pending work, which the new primary proposes after a view change. The nodes hold their own copies of the txs,
+// so the blocks of the replicas never have the digest of the committed block: a pending block is dropped once the
+// committed block has the same digest. A pending block sharing some tx IDs with the committed block is packed again
+// without them, its other txs have left the TxPool of the node and no one else would propose them
+package pbft
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"fmt"
+	"sync"
+)
+
+type pendingRequest struct {
+	req   message.Request
+	txIDs map[string]bool // the IDs of the txs of the block carried by the request
+}
+
+// the requests waiting for consensus in the order they are received
+type pendingRequests struct {
+	reqs []pendingRequest
+	lock sync.Mutex
+}
+
+func newPendingRequests() *pendingRequests {
+	return &pendingRequests{reqs: make([]pendingRequest, 0)}
+}
+
+// the IDs of the txs of the block carried by the request, empty if the request carries no block
+func requestTxIDs(req *message.Request) map[string]bool {
+	ids := make(map[string]bool)
+	if req.ReqType == message.ReqEmpty || req.ReqType == message.ReqReconfig {
+		return ids
+	}
+	b := structs.Block{}
+	if err := utils.Decode(req.Content, &b); err != nil {
+		return ids
+	}
+	for _, tx := range b.Transactions {
+		ids[string(tx.ID())] = true
+	}
+	return ids
+}
+
+// the reconfiguration request is put before the blocks, otherwise the epoch does not end until the backlog of the blocks is committed
+func (p *pendingRequests) add(req message.Request) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pending := pendingRequest{req: req, txIDs: requestTxIDs(&req)}
+	if req.ReqType == message.ReqReconfig {
+		p.reqs = append([]pendingRequest{pending}, p.reqs...)
+		return
+	}
+	p.reqs = append(p.reqs, pending)
+}
+
+// remove the first request to propose it
+func (p *pendingRequests) next() (message.Request, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.reqs) == 0 {
+		return message.Request{}, fmt.Errorf("no pending request")
+	}
+	req := p.reqs[0].req
+	p.reqs = p.reqs[1:]
+	return req, nil
+}
+
+// drop the pending requests done by the committed request and the committed txs of the other pending blocks,
+// returns the number of the dropped requests
+func (p *pendingRequests) removeCommitted(committed *message.Request) int {
+	txIDs := requestTxIDs(committed)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	kept := make([]pendingRequest, 0, len(p.reqs))
+	for _, pending := range p.reqs {
+		if pending.req.Digest == committed.Digest {
+			continue
+		}
+		if sharesTx(pending.txIDs, txIDs) {
+			var ok bool
+			if pending, ok = withoutTxs(pending, txIDs); !ok {
+				continue
+			}
+		}
+		kept = append(kept, pending)
+	}
+	removed := len(p.reqs) - len(kept)
+	p.reqs = kept
+	return removed
+}
+
+func sharesTx(a map[string]bool, b map[string]bool) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	for id := range a {
+		if b[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// pack the block of the pending request again without the committed txs, false if no tx is left
+func withoutTxs(pending pendingRequest, committed map[string]bool) (pendingRequest, bool) {
+	b := structs.Block{}
+	if err := utils.Decode(pending.req.Content, &b); err != nil || b.Header == nil {
+		return pending, false
+	}
+	txs := make([]structs.Transaction, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if !committed[string(tx.ID())] {
+			txs = append(txs, tx)
+		}
+	}
+	if len(txs) == 0 {
+		return pending, false
+	}
+
+	header := *b.Header
+	header.TxRoot = blockchain.GetTxTreeRoot(txs)
+	req := pending.req
+	req.Content = utils.Encode(structs.NewBlock(&header, txs))
+	req.CalDigest()
+	return pendingRequest{req: req, txIDs: requestTxIDs(&req)}, true
+}
+
+func (p *pendingRequests) size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.reqs)
+}
+
+func (p *pendingRequests) isEmpty() bool {
+	return p.size() == 0
+}
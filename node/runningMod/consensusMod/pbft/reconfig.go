@@ -0,0 +1,52 @@
+// This file contains the end of an epoch in PBFT.
+// The randomness of the next epoch is committed as a message.ReqReconfig request, so all the honest nodes of the shard
+// end the epoch at the same block. The rounds after it are not executed, the reconfiguration hands over the final block
+// to the next committee and recreates the consensus mod, see auxiliaryMod/reconfig.go
+package pbft
+
+import (
+	"BlockChainSimulator/addon/reconfig"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/utils"
+)
+
+func (pbftmod *PbftCosensusMod) isEpochEnded() bool {
+	pbftmod.roundLock.RLock()
+	defer pbftmod.roundLock.RUnlock()
+	return pbftmod.epochEnded
+}
+
+// the reconfiguration request must carry the next epoch of the beacon
+func (pbftmod *PbftCosensusMod) checkReconfig(req *message.Request) bool {
+	content := reconfig.Content{}
+	if err := utils.Decode(req.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the reconfiguration request")
+		return false
+	}
+	beaconKey, err := reconfig.BeaconPublicKey()
+	if err != nil {
+		utils.LoggerInstance.Error("The public key of the beacon is not valid: %v", err)
+		return false
+	}
+	return reconfig.Verify(beaconKey, &content, pbftmod.epoch, pbftmod.nodeAttr.Seed)
+}
+
+// stop executing and proposing, and hand the epoch over to the reconfiguration
+func (pbftmod *PbftCosensusMod) endEpoch(req *message.Request) {
+	pbftmod.roundLock.Lock()
+	pbftmod.epochEnded = true
+	pbftmod.roundLock.Unlock()
+	pbftmod.stopViewChangeTimer()
+	utils.LoggerInstance.Info("Epoch %d ends at block %d", pbftmod.epoch, pbftmod.nodeAttr.CurChain.CurrentBlock.Header.Height)
+
+	handler, ok := pbftmod.p2pMod.LocalHandler(message.MsgEpochEnd)
+	if !ok {
+		utils.LoggerInstance.Error("No handler for the end of the epoch, the reconfiguration mod is not running")
+		return
+	}
+	// the hand-over waits for the other nodes, do not hold the execution lock
+	go handler(&message.Message{
+		MsgType: message.MsgEpochEnd,
+		Content: req.Content,
+	})
+}
@@ -2,42 +2,258 @@ package pbft
 
 import (
 	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
 	"BlockChainSimulator/utils"
 )
 
 type PbftMessage struct {
+	Request  message.Request
+	Epoch    int                  // the epoch of the committee, the shards are reconfigured at the epoch boundaries
+	Instance int                  // the PBFT instance of the multi-leader mode, always 0 in the classic PBFT
+	Round    int                  // the round of the consensus
+	View     int                  // the view in which the message is sent
+	NodeId   int                  // the sender
+	Sig      *signature.Signature // the signature of the sender on (epoch, instance, round, view, digest, phase)
+}
+
+// the content signed in the pbft message, phase is the message type of the pbft message
+func pbftMessageContent(phase message.MessageType, epoch int, instance int, round int, view int, digest [32]byte) []byte {
+	return utils.CanonicalEncode(struct {
+		Phase    message.MessageType
+		Epoch    int
+		Instance int
+		Round    int
+		View     int
+		Digest   [32]byte
+	}{phase, epoch, instance, round, view, digest})
+}
+
+// create a pbft message of the phase signed by this node
+func (pbftmod *PbftCosensusMod) newPbftMessage(phase message.MessageType, req message.Request, round int, view int) PbftMessage {
+	return PbftMessage{
+		Request:  req,
+		Epoch:    pbftmod.epoch,
+		Instance: pbftmod.instance,
+		Round:    round,
+		View:     view,
+		NodeId:   pbftmod.nodeAttr.Nid,
+		Sig:      signature.Sign(pbftmod.nodeAttr.SecKey, pbftMessageContent(phase, pbftmod.epoch, pbftmod.instance, round, view, req.Digest)),
+	}
+}
+
+// verify the signature of the pbft message of the phase, the messages of the other epochs are sent by another committee
+func (pbftmod *PbftCosensusMod) checkPbftMessage(phase message.MessageType, pbftMsg *PbftMessage) bool {
+	if pbftMsg.Epoch != pbftmod.epoch || pbftMsg.Instance != pbftmod.instance {
+		return false
+	}
+	return pbftmod.checkSig(pbftMsg.NodeId, pbftMessageContent(phase, pbftMsg.Epoch, pbftMsg.Instance, pbftMsg.Round, pbftMsg.View, pbftMsg.Request.Digest), pbftMsg.Sig)
+}
+
+// a request prepared by a replica, carried by the VIEW-CHANGE message with the 2f+1 prepare messages proving it
+type PreparedCert struct {
+	Round   int
+	View    int
 	Request message.Request
-	Round   int // the round of the consensus
+	Signers []int                // the nids of the nodes sending the prepare messages
+	Sig     *signature.Signature // the aggregate signature of the prepare messages
+}
+
+type ViewChangeMessage struct {
+	Epoch       int                  // the epoch of the committee
+	Instance    int                  // the PBFT instance of the multi-leader mode
+	NewView     int                  // the view the replica wants to move to
+	NodeId      int                  // the sender
+	StableRound int                  // the round of the last stable checkpoint of the sender
+	StableProof []CheckpointMessage  // 2f+1 matching checkpoints of StableRound, empty if StableRound is 0
+	Prepared    []PreparedCert       // the requests prepared by the sender from StableRound on
+	Sig         *signature.Signature // the signature of the sender, see viewChangeContent
+}
+
+// the content signed in the VIEW-CHANGE message, the proofs verify themselves so only what they prove is signed
+func viewChangeContent(vc *ViewChangeMessage) []byte {
+	type preparedDigest struct {
+		Round  int
+		View   int
+		Digest [32]byte
+	}
+	prepared := make([]preparedDigest, 0, len(vc.Prepared))
+	for _, cert := range vc.Prepared {
+		prepared = append(prepared, preparedDigest{cert.Round, cert.View, cert.Request.Digest})
+	}
+	return utils.CanonicalEncode(struct {
+		Epoch       int
+		Instance    int
+		NewView     int
+		NodeId      int
+		StableRound int
+		Prepared    []preparedDigest
+	}{vc.Epoch, vc.Instance, vc.NewView, vc.NodeId, vc.StableRound, prepared})
+}
+
+type NewViewMessage struct {
+	Epoch       int                  // the epoch of the committee
+	View        int                  // the new view
+	Instance    int                  // the PBFT instance of the multi-leader mode
+	NodeId      int                  // the sender, must be the primary of View
+	ViewChanges []ViewChangeMessage  // 2f+1 VIEW-CHANGE messages for the new view
+	PrePrepares []PbftMessage        // the requests re-proposed in the new view
+	Sig         *signature.Signature // the signature of the sender, see newViewContent
+}
+
+// the content signed in the NEW-VIEW message
+func newViewContent(nv *NewViewMessage) []byte {
+	senders := make([]int, 0, len(nv.ViewChanges))
+	for _, vc := range nv.ViewChanges {
+		senders = append(senders, vc.NodeId)
+	}
+	digests := make([][32]byte, 0, len(nv.PrePrepares))
+	for _, pp := range nv.PrePrepares {
+		digests = append(digests, pp.Request.Digest)
+	}
+	return utils.CanonicalEncode(struct {
+		Epoch    int
+		View     int
+		Instance int
+		NodeId   int
+		Senders  []int
+		Digests  [][32]byte
+	}{nv.Epoch, nv.View, nv.Instance, nv.NodeId, senders, digests})
+}
+
+// a replica broadcasts the checkpoint after it commits the rounds before Round, 2f+1 matching checkpoints make it stable
+type CheckpointMessage struct {
+	Epoch    int                  // the epoch of the committee
+	Instance int                  // the PBFT instance of the multi-leader mode
+	Round    int                  // the rounds before Round are committed
+	Digest   [32]byte             // the digest of the requests committed in the last CheckpointInterval rounds
+	NodeId   int                  // the sender
+	Sig      *signature.Signature // the signature of the sender on (Epoch, Instance, Round, Digest)
 }
 
 // stores the information of a request,
 type RequestInfo struct {
 	Req               message.Request
-	cntPrepareConfirm int  // the number of prepare messages received
-	cntCommitConfirm  int  // the number of commit messages received
-	isCommitBroadcast bool // whether the commit message has been broadcasted
-	isReply           bool // whether the reply message has been sent
+	Round             int                                     // the round in which the request is pre-prepared
+	View              int                                     // the view in which the request is pre-prepared
+	isPrePrepared     bool                                    // whether Req, Round and View are set by an accepted pre-prepare message
+	prepareVoters     map[[2]int]map[int]*signature.Signature // (view, round) -> nid -> the signature of the prepare message
+	commitVoters      map[[2]int]map[int]*signature.Signature // (view, round) -> nid -> the signature of the commit message
+	isCommitBroadcast bool                                    // whether the commit message has been broadcasted, i.e. the request is prepared
+	isCommitted       bool                                    // whether enough commit messages are received
+	isReply           bool                                    // whether the request is executed and the reply message has been sent
 }
 
 func NewRequestInfo(req message.Request) *RequestInfo {
 	return &RequestInfo{
 		Req:               req,
-		cntPrepareConfirm: 0,
-		cntCommitConfirm:  0,
+		prepareVoters:     make(map[[2]int]map[int]*signature.Signature),
+		commitVoters:      make(map[[2]int]map[int]*signature.Signature),
 		isCommitBroadcast: false,
+		isCommitted:       false,
 		isReply:           false,
 	}
 }
 
+// add the vote for (view, round) and return the number of the votes of the pre-prepared (view, round),
+// the votes of the other rounds are kept in case the pre-prepare message comes later, but never counted
+func (info *RequestInfo) addVote(voters map[[2]int]map[int]*signature.Signature, pbftMsg *PbftMessage) int {
+	slot := [2]int{pbftMsg.View, pbftMsg.Round}
+	if voters[slot] == nil {
+		voters[slot] = make(map[int]*signature.Signature)
+	}
+	voters[slot][pbftMsg.NodeId] = pbftMsg.Sig
+	return len(info.votesOf(voters))
+}
+
+// the votes of the pre-prepared (view, round), nid -> signature
+func (info *RequestInfo) votesOf(voters map[[2]int]map[int]*signature.Signature) map[int]*signature.Signature {
+	if !info.isPrePrepared {
+		return nil
+	}
+	return voters[[2]int{info.View, info.Round}]
+}
+
+// aggregate the votes of the pre-prepared (view, round), they sign the same content
+func (info *RequestInfo) aggregateVotes(voters map[[2]int]map[int]*signature.Signature) ([]int, *signature.Signature) {
+	votes := info.votesOf(voters)
+	signers := make([]int, 0, len(votes))
+	sigs := make([]*signature.Signature, 0, len(votes))
+	for nid, sig := range votes {
+		signers = append(signers, nid)
+		sigs = append(sigs, sig)
+	}
+	aggSig, err := signature.AggregateSignatures(sigs)
+	if err != nil {
+		return nil, nil
+	}
+	return signers, aggSig
+}
+
+// call with requestPoolLock held, get the info of the request, the votes arriving before the pre-prepare message create it
+func (pbftmod *PbftCosensusMod) getRequestInfo(req message.Request) *RequestInfo {
+	digestStr := string(req.Digest[:])
+	info := pbftmod.requestPool[digestStr]
+	if info == nil {
+		info = NewRequestInfo(req)
+		pbftmod.requestPool[digestStr] = info
+	}
+	return info
+}
+
+// call with requestPoolLock held, bind the request to the (round, view) of the accepted pre-prepare message whose digest is checked.
+// A request re-proposed in a higher view starts its votes from scratch
+func (pbftmod *PbftCosensusMod) prePrepareRequestInfo(req message.Request, round int, view int) *RequestInfo {
+	info := pbftmod.getRequestInfo(req)
+	if info.isPrePrepared && info.View >= view {
+		return info
+	}
+	if info.isPrePrepared {
+		info.isCommitBroadcast = false
+	}
+	info.Req = req
+	info.Round = round
+	info.View = view
+	info.isPrePrepared = true
+	for slot := range info.prepareVoters {
+		if slot[0] < view {
+			delete(info.prepareVoters, slot)
+		}
+	}
+	for slot := range info.commitVoters {
+		if slot[0] < view {
+			delete(info.commitVoters, slot)
+		}
+	}
+	return info
+}
+
 func (pbftmod *PbftCosensusMod) getCurrentRound() int {
 	pbftmod.roundLock.RLock()
 	defer pbftmod.roundLock.RUnlock()
 	return pbftmod.currentRound
 }
-func (pbftmod *PbftCosensusMod) IncCurrentRound() {
+
+// the rounds before `round` are all committed
+func (pbftmod *PbftCosensusMod) advanceRound(round int) {
 	pbftmod.roundLock.Lock()
 	defer pbftmod.roundLock.Unlock()
-	pbftmod.currentRound++
+	if round > pbftmod.currentRound {
+		pbftmod.currentRound = round
+	}
+}
+
+func (pbftmod *PbftCosensusMod) getLastProposedRound() int {
+	pbftmod.roundLock.RLock()
+	defer pbftmod.roundLock.RUnlock()
+	return pbftmod.lastProposedRound
+}
+
+func (pbftmod *PbftCosensusMod) setLastProposedRound(round int) {
+	pbftmod.roundLock.Lock()
+	defer pbftmod.roundLock.Unlock()
+	if round > pbftmod.lastProposedRound {
+		pbftmod.lastProposedRound = round
+	}
 }
 
 func (pbftmod *PbftCosensusMod) isCommitBroadcasted(digestStr string) bool {
@@ -0,0 +1,427 @@
+// This file contains the view change sub-protocol of PBFT.
+// A replica starts a timer when it is waiting for a request to be committed, if the timer expires, it suspects the primary and broadcasts a VIEW-CHANGE message.
+// The primary of the new view collects 2f+1 VIEW-CHANGE messages and broadcasts a NEW-VIEW message to re-propose the prepared requests.
+package pbft
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"time"
+)
+
+const maxFutureMsgs = 1024 // max number of messages of future views buffered by a replica
+
+// the primary of the view, the primary rotates in a round robin way.
+// In the multi-leader mode, node i is the first primary of instance i
+func (pbftmod *PbftCosensusMod) getPrimary(view int) int {
+	return (pbftmod.instance + view) % pbftmod.pbft_num
+}
+
+func (pbftmod *PbftCosensusMod) getView() int {
+	pbftmod.viewLock.Lock()
+	defer pbftmod.viewLock.Unlock()
+	return pbftmod.view
+}
+
+// whether this node is the primary of the current view
+func (pbftmod *PbftCosensusMod) isPrimary() bool {
+	return pbftmod.getPrimary(pbftmod.getView()) == pbftmod.nodeAttr.Nid
+}
+
+func (pbftmod *PbftCosensusMod) isInViewChange() bool {
+	pbftmod.viewLock.Lock()
+	defer pbftmod.viewLock.Unlock()
+	return pbftmod.inViewChange
+}
+
+// returns whether the message belongs to the current view.
+// Messages of future views are buffered until the node enters that view, messages of the old views are dropped
+func (pbftmod *PbftCosensusMod) acceptView(view int, msg *message.Message) bool {
+	pbftmod.viewLock.Lock()
+	defer pbftmod.viewLock.Unlock()
+
+	if view > pbftmod.view {
+		if len(pbftmod.futureMsgs) < maxFutureMsgs {
+			pbftmod.futureMsgs = append(pbftmod.futureMsgs, msg)
+		}
+		return false
+	}
+	// stop accepting messages of the current view once the view change starts
+	return view == pbftmod.view && !pbftmod.inViewChange
+}
+
+// start the view change timer if it is not running, invoked when the replica is waiting for a request to be committed
+func (pbftmod *PbftCosensusMod) startViewChangeTimer() {
+	// the committee of the ended epoch waits for the reconfiguration, not for the primary
+	if pbftmod.isEpochEnded() {
+		return
+	}
+	pbftmod.viewLock.Lock()
+	defer pbftmod.viewLock.Unlock()
+
+	if pbftmod.vcTimer != nil || pbftmod.getPrimary(pbftmod.view) == pbftmod.nodeAttr.Nid {
+		return
+	}
+	pbftmod.vcTimer = time.AfterFunc(pbftmod.vcTimeout, pbftmod.onViewChangeTimeout)
+}
+
+func (pbftmod *PbftCosensusMod) stopViewChangeTimer() {
+	pbftmod.viewLock.Lock()
+	defer pbftmod.viewLock.Unlock()
+
+	if pbftmod.vcTimer != nil {
+		pbftmod.vcTimer.Stop()
+		pbftmod.vcTimer = nil
+	}
+}
+
+func (pbftmod *PbftCosensusMod) onViewChangeTimeout() {
+	if pbftmod.isEpochEnded() {
+		return
+	}
+	pbftmod.viewLock.Lock()
+	pbftmod.vcTimer = nil
+	newView := pbftmod.view + 1
+	if pbftmod.inViewChange {
+		// the view change itself failed, try the next one
+		newView = pbftmod.targetView + 1
+	}
+	pbftmod.vcTimeout *= 2 // give the next primary more time
+	pbftmod.viewLock.Unlock()
+
+	utils.LoggerInstance.Warn("The view change timer expires, suspect the primary and move to view %d", newView)
+	pbftmod.sendViewChange(newView)
+}
+
+// broadcast the VIEW-CHANGE message for newView with the prepared requests
+func (pbftmod *PbftCosensusMod) sendViewChange(newView int) {
+	pbftmod.viewLock.Lock()
+	if pbftmod.inViewChange && pbftmod.targetView >= newView {
+		pbftmod.viewLock.Unlock()
+		return
+	}
+	pbftmod.inViewChange = true
+	pbftmod.targetView = newView
+	if pbftmod.vcTimer != nil {
+		pbftmod.vcTimer.Stop()
+	}
+	pbftmod.vcTimer = time.AfterFunc(pbftmod.vcTimeout, pbftmod.onViewChangeTimeout)
+	pbftmod.viewLock.Unlock()
+
+	vc := pbftmod.newViewChange(newView)
+	vcmsg := message.Message{
+		MsgType: message.MsgViewChange,
+		Content: utils.Encode(vc),
+	}
+	pbftmod.p2pMod.ConnMananger.Broadcast(pbftmod.nodeAttr.Ipaddr, pbftmod.getNeighbours(config.IPMap[pbftmod.nodeAttr.Sid]), vcmsg.JsonEncode())
+	utils.LoggerInstance.Info("Broadcast the view change message for view %d with %d prepared requests", newView, len(vc.Prepared))
+	go pbftmod.handleViewChange(&vcmsg)
+}
+
+// the VIEW-CHANGE message for newView signed by this node, with the proof of the last stable checkpoint
+func (pbftmod *PbftCosensusMod) newViewChange(newView int) ViewChangeMessage {
+	stableRound, stableProof := pbftmod.getStableCheckpoint()
+	vc := ViewChangeMessage{
+		Epoch:       pbftmod.epoch,
+		Instance:    pbftmod.instance,
+		NewView:     newView,
+		NodeId:      pbftmod.nodeAttr.Nid,
+		StableRound: stableRound,
+		StableProof: stableProof,
+		Prepared:    pbftmod.getPreparedCerts(stableRound),
+	}
+	vc.Sig = signature.Sign(pbftmod.nodeAttr.SecKey, viewChangeContent(&vc))
+	return vc
+}
+
+// the requests prepared from the stable round on, executed or not, each with the 2f+1 prepare messages proving it
+func (pbftmod *PbftCosensusMod) getPreparedCerts(stableRound int) []PreparedCert {
+	pbftmod.requestPoolLock.RLock()
+	defer pbftmod.requestPoolLock.RUnlock()
+
+	prepared := make([]PreparedCert, 0)
+	for _, info := range pbftmod.requestPool {
+		if !info.isCommitBroadcast || info.Round < stableRound {
+			continue
+		}
+		signers, sig := info.aggregateVotes(info.prepareVoters)
+		if len(signers) < 2*pbftmod.malicious_num+1 {
+			continue
+		}
+		prepared = append(prepared, PreparedCert{
+			Round:   info.Round,
+			View:    info.View,
+			Request: info.Req,
+			Signers: signers,
+			Sig:     sig,
+		})
+	}
+	return prepared
+}
+
+// whether the request is prepared by 2f+1 nodes in the round and view of the certificate
+func (pbftmod *PbftCosensusMod) checkPreparedCert(cert *PreparedCert) bool {
+	digest := cert.Request.Digest
+	req := cert.Request
+	req.CalDigest()
+	if req.Digest != digest || len(cert.Signers) < 2*pbftmod.malicious_num+1 {
+		return false
+	}
+	content := pbftMessageContent(message.MsgPrepare, pbftmod.epoch, pbftmod.instance, cert.Round, cert.View, digest)
+	return pbftmod.nodeAttr.VerifyAggregatedSig(pbftmod.nodeAttr.Sid, cert.Signers, content, cert.Sig) == nil
+}
+
+// whether the VIEW-CHANGE message is signed by its sender and proves the stable checkpoint and the prepared requests it claims
+func (pbftmod *PbftCosensusMod) checkViewChange(vc *ViewChangeMessage) bool {
+	if vc.Epoch != pbftmod.epoch || vc.Instance != pbftmod.instance {
+		return false
+	}
+	if !pbftmod.checkSig(vc.NodeId, viewChangeContent(vc), vc.Sig) {
+		utils.LoggerInstance.Warn("The signature of the view change message from node %d is not valid", vc.NodeId)
+		return false
+	}
+	if !pbftmod.checkStableProof(vc.StableRound, vc.StableProof) {
+		utils.LoggerInstance.Warn("The view change message from node %d does not prove the checkpoint of round %d", vc.NodeId, vc.StableRound)
+		return false
+	}
+	for i := range vc.Prepared {
+		cert := &vc.Prepared[i]
+		if cert.Round < vc.StableRound || cert.View >= vc.NewView || !pbftmod.checkPreparedCert(cert) {
+			utils.LoggerInstance.Warn("The view change message from node %d carries a request of round %d not prepared", vc.NodeId, cert.Round)
+			return false
+		}
+	}
+	return true
+}
+
+// collect the VIEW-CHANGE messages; join the view change if f+1 nodes suspect the primary;
+// if this node is the new primary, broadcast the NEW-VIEW message with 2f+1 VIEW-CHANGE messages
+func (pbftmod *PbftCosensusMod) handleViewChange(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle view change")
+
+	vc := ViewChangeMessage{}
+	err := utils.Decode(msg.Content, &vc)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the view change message")
+		return
+	}
+	if vc.Epoch != pbftmod.epoch || vc.Instance != pbftmod.instance {
+		utils.LoggerInstance.Debug("Received view change message of epoch %d, current epoch is %d", vc.Epoch, pbftmod.epoch)
+		return
+	}
+	if vc.NewView <= pbftmod.getView() || !pbftmod.checkViewChange(&vc) {
+		return
+	}
+
+	pbftmod.viewLock.Lock()
+	if vc.NewView <= pbftmod.view {
+		pbftmod.viewLock.Unlock()
+		utils.LoggerInstance.Debug("Received outdated view change message for view %d, current view is %d", vc.NewView, pbftmod.view)
+		return
+	}
+	if pbftmod.viewChangeMsgs[vc.NewView] == nil {
+		pbftmod.viewChangeMsgs[vc.NewView] = make(map[int]*ViewChangeMessage)
+	}
+	pbftmod.viewChangeMsgs[vc.NewView][vc.NodeId] = &vc
+	cnt := len(pbftmod.viewChangeMsgs[vc.NewView])
+
+	join := cnt >= pbftmod.malicious_num+1 && (!pbftmod.inViewChange || pbftmod.targetView < vc.NewView)
+	isNewPrimary := pbftmod.getPrimary(vc.NewView) == pbftmod.nodeAttr.Nid && cnt >= 2*pbftmod.malicious_num+1 && pbftmod.newViewSent < vc.NewView
+	viewChanges := make([]ViewChangeMessage, 0, cnt)
+	if isNewPrimary {
+		pbftmod.newViewSent = vc.NewView
+		for _, m := range pbftmod.viewChangeMsgs[vc.NewView] {
+			viewChanges = append(viewChanges, *m)
+		}
+	}
+	pbftmod.viewLock.Unlock()
+
+	if join {
+		utils.LoggerInstance.Info("%d nodes suspect the primary, join the view change to view %d", cnt, vc.NewView)
+		pbftmod.sendViewChange(vc.NewView)
+	}
+
+	if isNewPrimary {
+		nv := pbftmod.newNewView(vc.NewView, viewChanges)
+		nvmsg := message.Message{
+			MsgType: message.MsgNewView,
+			Content: utils.Encode(nv),
+		}
+		pbftmod.p2pMod.ConnMananger.Broadcast(pbftmod.nodeAttr.Ipaddr, pbftmod.getNeighbours(config.IPMap[pbftmod.nodeAttr.Sid]), nvmsg.JsonEncode())
+		utils.LoggerInstance.Info("Broadcast the new view message for view %d, re-propose %d requests", nv.View, len(nv.PrePrepares))
+		go pbftmod.handleNewView(&nvmsg)
+	}
+}
+
+// the NEW-VIEW message signed by this node as the primary of the view, the re-proposed requests are pre-prepared by it
+func (pbftmod *PbftCosensusMod) newNewView(view int, viewChanges []ViewChangeMessage) NewViewMessage {
+	_, prePrepares := pbftmod.computeNewViewPrePrepares(view, viewChanges)
+	for i := range prePrepares {
+		prePrepares[i] = pbftmod.newPbftMessage(message.MsgPrePrepare, prePrepares[i].Request, prePrepares[i].Round, prePrepares[i].View)
+	}
+	nv := NewViewMessage{
+		Epoch:       pbftmod.epoch,
+		View:        view,
+		Instance:    pbftmod.instance,
+		NodeId:      pbftmod.nodeAttr.Nid,
+		ViewChanges: viewChanges,
+		PrePrepares: prePrepares,
+	}
+	nv.Sig = signature.Sign(pbftmod.nodeAttr.SecKey, newViewContent(&nv))
+	return nv
+}
+
+// verify the NEW-VIEW message and enter the new view
+func (pbftmod *PbftCosensusMod) handleNewView(msg *message.Message) {
+	utils.LoggerInstance.Debug("handle new view")
+
+	nv := NewViewMessage{}
+	err := utils.Decode(msg.Content, &nv)
+	if err != nil {
+		utils.LoggerInstance.Error("Error decoding the new view message")
+		return
+	}
+
+	if nv.Epoch != pbftmod.epoch || nv.Instance != pbftmod.instance || nv.View <= pbftmod.getView() {
+		utils.LoggerInstance.Debug("Received outdated new view message for view %d", nv.View)
+		return
+	}
+	if nv.NodeId != pbftmod.getPrimary(nv.View) || !pbftmod.checkSig(nv.NodeId, newViewContent(&nv), nv.Sig) {
+		utils.LoggerInstance.Warn("Received new view message for view %d not signed by its primary", nv.View)
+		return
+	}
+
+	// the NEW-VIEW message must be backed by 2f+1 valid VIEW-CHANGE messages from distinct nodes
+	senders := utils.NewSet[int]()
+	for i := range nv.ViewChanges {
+		vc := &nv.ViewChanges[i]
+		if vc.NewView != nv.View || senders.Contains(vc.NodeId) || !pbftmod.checkViewChange(vc) {
+			utils.LoggerInstance.Warn("The new view message for view %d carries an invalid view change message", nv.View)
+			return
+		}
+		senders.Add(vc.NodeId)
+	}
+	if senders.Size() < 2*pbftmod.malicious_num+1 {
+		utils.LoggerInstance.Warn("The new view message for view %d only carries %d view change messages", nv.View, senders.Size())
+		return
+	}
+
+	// the re-proposed requests must be the same as what this node computes from the VIEW-CHANGE messages
+	stableRound, prePrepares := pbftmod.computeNewViewPrePrepares(nv.View, nv.ViewChanges)
+	if len(prePrepares) != len(nv.PrePrepares) {
+		utils.LoggerInstance.Warn("The new view message for view %d re-proposes wrong requests", nv.View)
+		return
+	}
+	for i := range prePrepares {
+		if prePrepares[i].Round != nv.PrePrepares[i].Round || prePrepares[i].View != nv.PrePrepares[i].View || prePrepares[i].Request.Digest != nv.PrePrepares[i].Request.Digest {
+			utils.LoggerInstance.Warn("The new view message for view %d re-proposes wrong requests", nv.View)
+			return
+		}
+	}
+
+	pbftmod.viewLock.Lock()
+	if nv.View <= pbftmod.view {
+		pbftmod.viewLock.Unlock()
+		return
+	}
+	pbftmod.view = nv.View
+	pbftmod.inViewChange = false
+	pbftmod.targetView = nv.View
+	pbftmod.vcTimeout = time.Duration(config.ViewChangeTimeout) * time.Millisecond
+	if pbftmod.vcTimer != nil {
+		pbftmod.vcTimer.Stop()
+		pbftmod.vcTimer = nil
+	}
+	for view := range pbftmod.viewChangeMsgs {
+		if view <= nv.View {
+			delete(pbftmod.viewChangeMsgs, view)
+		}
+	}
+	futureMsgs := pbftmod.futureMsgs
+	pbftmod.futureMsgs = nil
+	pbftmod.viewLock.Unlock()
+
+	// the checkpoint proved by the VIEW-CHANGE messages is stable, the rounds before it are not re-proposed
+	for _, vc := range nv.ViewChanges {
+		if vc.StableRound == stableRound {
+			pbftmod.stabilize(vc.StableRound, vc.StableProof)
+			break
+		}
+	}
+	pbftmod.executeCommitted()
+	utils.LoggerInstance.Info("Enter view %d, the primary is node %d", nv.View, pbftmod.getPrimary(nv.View))
+
+	if pbftmod.isPrimary() {
+		pbftmod.setLastProposedRound(stableRound - 1)
+	}
+	for _, pp := range nv.PrePrepares {
+		if pbftmod.isPrimary() {
+			pbftmod.setLastProposedRound(pp.Round)
+		}
+		ppmsg := message.Message{
+			MsgType: message.MsgPrePrepare,
+			Content: utils.Encode(pp),
+		}
+		go pbftmod.handlePrePrepare(&ppmsg)
+	}
+
+	// replay the messages of this view received before the NEW-VIEW message
+	for _, m := range futureMsgs {
+		if handler, ok := pbftmod.p2pMod.Handler(m.MsgType); ok {
+			go handler(m)
+		}
+	}
+
+	// still waiting for requests to be committed, watch the new primary
+	if !pbftmod.pending.isEmpty() {
+		pbftmod.startViewChangeTimer()
+	}
+}
+
+// compute the requests to be re-proposed in the new view from the verified VIEW-CHANGE messages: for every round from the highest stable checkpoint on,
+// re-propose the request prepared in the highest view, the rounds without prepared requests are filled with null requests
+func (pbftmod *PbftCosensusMod) computeNewViewPrePrepares(newView int, viewChanges []ViewChangeMessage) (int, []PbftMessage) {
+	stableRound := 0
+	for _, vc := range viewChanges {
+		if vc.StableRound > stableRound {
+			stableRound = vc.StableRound
+		}
+	}
+
+	best := make(map[int]PreparedCert)
+	maxRound := stableRound - 1
+	for _, vc := range viewChanges {
+		for _, cert := range vc.Prepared {
+			if cert.Round < stableRound {
+				continue
+			}
+			if cur, ok := best[cert.Round]; !ok || cert.View > cur.View {
+				best[cert.Round] = cert
+			}
+			if cert.Round > maxRound {
+				maxRound = cert.Round
+			}
+		}
+	}
+
+	prePrepares := make([]PbftMessage, 0, maxRound-stableRound+1)
+	for round := stableRound; round <= maxRound; round++ {
+		req := message.Request{ShardId: pbftmod.nodeAttr.Sid, ReqType: message.ReqEmpty}
+		if cert, ok := best[round]; ok {
+			req = cert.Request
+		} else {
+			// the null request must be the same on all nodes, so its digest only depends on the view and round
+			req.Content = utils.Encode([2]int{newView, round})
+			req.CalDigest()
+		}
+		prePrepares = append(prePrepares, PbftMessage{
+			Request:  req,
+			Instance: pbftmod.instance,
+			Round:    round,
+			View:     newView,
+		})
+	}
+	return stableRound, prePrepares
+}
@@ -0,0 +1,145 @@
+package pbft
+
+import (
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the request is pre-prepared by node 0 in round 0 of view 0 and prepared by all the nodes on the mod
+func prepareOn(t *testing.T, pbftMod *PbftCosensusMod, keys *testutil.Keys, req message.Request) {
+	pbftMod.handlePrePrepare(signedMsg(keys, 0, message.MsgPrePrepare, req, 0, 0))
+	for nid := range keys.Sks {
+		if nid != pbftMod.nodeAttr.Nid {
+			pbftMod.handlePrepare(signedMsg(keys, nid, message.MsgPrepare, req, 0, 0))
+		}
+	}
+	require.Eventually(t, func() bool { return pbftMod.isCommitBroadcasted(string(req.Digest[:])) }, time.Second, 10*time.Millisecond)
+}
+
+// the mods of the nodes 1, 2 and 3 which prepared the request, node 0 is the silent primary of view 0
+func preparedMods(t *testing.T, keys *testutil.Keys, req message.Request) []*PbftCosensusMod {
+	mods := make([]*PbftCosensusMod, len(keys.Sks))
+	for nid := 1; nid < len(keys.Sks); nid++ {
+		mods[nid], _ = newTestMod(t, nid, keys)
+		prepareOn(t, mods[nid], keys, req)
+	}
+	return mods
+}
+
+func viewChangeMsg(vc ViewChangeMessage) *message.Message {
+	return &message.Message{MsgType: message.MsgViewChange, Content: utils.Encode(vc)}
+}
+
+func newViewMsg(nv NewViewMessage) *message.Message {
+	return &message.Message{MsgType: message.MsgNewView, Content: utils.Encode(nv)}
+}
+
+// the request prepared before the view change is re-proposed in the same round by the new primary
+func TestViewChangeReproposesPrepared(t *testing.T) {
+	keys := newTestKeys()
+	req := testRequest("block")
+	mods := preparedMods(t, keys, req)
+
+	viewChanges := make([]ViewChangeMessage, 0, 3)
+	for nid := 1; nid < 4; nid++ {
+		vc := mods[nid].newViewChange(1)
+		require.Len(t, vc.Prepared, 1)
+		require.True(t, mods[2].checkViewChange(&vc))
+		viewChanges = append(viewChanges, vc)
+	}
+	nv := mods[1].newNewView(1, viewChanges)
+	require.Len(t, nv.PrePrepares, 1)
+	assert.Equal(t, req.Digest, nv.PrePrepares[0].Request.Digest)
+
+	mods[2].handleNewView(newViewMsg(nv))
+	assert.Equal(t, 1, mods[2].getView())
+	assert.Eventually(t, func() bool {
+		mods[2].requestPoolLock.RLock()
+		defer mods[2].requestPoolLock.RUnlock()
+		info := mods[2].requestPool[string(req.Digest[:])]
+		return info.isPrePrepared && info.View == 1 && info.Round == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestViewChangeRejectsForgedProofs(t *testing.T) {
+	keys := newTestKeys()
+	req := testRequest("block")
+	mods := preparedMods(t, keys, req)
+	resign := func(vc *ViewChangeMessage, nid int) {
+		vc.Sig = signature.Sign(keys.Sks[nid], viewChangeContent(vc))
+	}
+
+	// node 3 sends the view change message in the name of node 2
+	vc := mods[3].newViewChange(1)
+	vc.NodeId = 2
+	assert.False(t, mods[1].checkViewChange(&vc))
+
+	// the prepared request is proved by 2 prepare messages only
+	vc = mods[3].newViewChange(1)
+	signers, sigs := make([]int, 0, 2), make([]*signature.Signature, 0, 2)
+	for _, nid := range []int{1, 3} {
+		signers = append(signers, nid)
+		sigs = append(sigs, signature.Sign(keys.Sks[nid], pbftMessageContent(message.MsgPrepare, 0, 0, 0, 0, req.Digest)))
+	}
+	vc.Prepared[0].Signers = signers
+	vc.Prepared[0].Sig, _ = signature.AggregateSignatures(sigs)
+	resign(&vc, 3)
+	assert.False(t, mods[1].checkViewChange(&vc))
+
+	// the prepared request carries another content under its digest
+	vc = mods[3].newViewChange(1)
+	vc.Prepared[0].Request.Content = []byte("forged")
+	resign(&vc, 3)
+	assert.False(t, mods[1].checkViewChange(&vc))
+
+	// a prepared request is made up in a round nobody proposed
+	vc = mods[3].newViewChange(1)
+	vc.Prepared[0].Round = 3
+	resign(&vc, 3)
+	assert.False(t, mods[1].checkViewChange(&vc))
+
+	// the stable checkpoint is claimed without the 2f+1 checkpoints, the new view would skip the rounds before it
+	vc = mods[3].newViewChange(1)
+	vc.StableRound = 20
+	resign(&vc, 3)
+	assert.False(t, mods[1].checkViewChange(&vc))
+
+	// the forged messages are not collected
+	mods[1].handleViewChange(viewChangeMsg(vc))
+	mods[1].viewLock.Lock()
+	assert.Empty(t, mods[1].viewChangeMsgs[1])
+	mods[1].viewLock.Unlock()
+}
+
+func TestNewViewOnlyFromPrimary(t *testing.T) {
+	keys := newTestKeys()
+	req := testRequest("block")
+	mods := preparedMods(t, keys, req)
+	viewChanges := make([]ViewChangeMessage, 0, 3)
+	for nid := 1; nid < 4; nid++ {
+		viewChanges = append(viewChanges, mods[nid].newViewChange(1))
+	}
+
+	// node 2 is not the primary of view 1
+	mods[3].handleNewView(newViewMsg(mods[2].newNewView(1, viewChanges)))
+	assert.Equal(t, 0, mods[3].getView())
+
+	// the primary drops the prepared request
+	nv := mods[1].newNewView(1, viewChanges)
+	nv.PrePrepares = nil
+	nv.Sig = signature.Sign(keys.Sks[1], newViewContent(&nv))
+	mods[3].handleNewView(newViewMsg(nv))
+	assert.Equal(t, 0, mods[3].getView())
+
+	// 2 view change messages, one of them repeated
+	nv = mods[1].newNewView(1, append(viewChanges[:2], viewChanges[0]))
+	mods[3].handleNewView(newViewMsg(nv))
+	assert.Equal(t, 0, mods[3].getView())
+}
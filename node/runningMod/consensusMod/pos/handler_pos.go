@@ -0,0 +1,887 @@
+// This file contains the Algorand-style PoS consensus module, the validators of a shard agree on a block every round.
+// Nobody knows the proposers and the committees in advance: every validator runs the sortition of sortition.go on its stake
+// in every step, and speaks only if its sub-users are selected. A round runs in periods:
+//   - propose(0): the selected proposers propose a block, or re-propose the starting value of the period
+//   - soft vote(2λ): vote for the proposal of the lowest priority, or for the starting value
+//   - cert vote: once a value gets a soft quorum and its block is known, vote for it, a cert quorum commits the block
+//   - next vote(4λ, 6λ, ...): vote for the cert-voted value, or the soft-quorum value, or the starting value, or ⊥.
+//     A next quorum on a value starts the next period with it as the starting value
+//
+// A quorum is more than config.PoSThreshold*config.PoSCommitteeSize votes, the cert votes are aggregated into the certificate
+// of the round, which is served to the nodes behind. The stakes are the balances of the stake accounts, read from the state of the
+// genesis block, then refreshed every config.PoSStakeLookback blocks from the state of an executed block, see refreshStakes
+package pos
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// implement the ConsensusMod interface
+var _ runningModInterface.RunningMod = &PoSCosensusMod{}
+
+const (
+	SilentStrategy     = "Silent"     // a malicious validator neither proposes nor votes
+	EquivocateStrategy = "Equivocate" // a malicious validator sends different blocks and votes to the two halves of the shard
+
+	certHistory = 20 // the certificates of the last certHistory rounds are served to the nodes behind
+)
+
+type PoSCosensusMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	// the stakes of the validators of the shard
+	stakes      map[int]int64
+	totalStake  int64
+	stakeHeight int64            // the height of the block whose state gives the stakes
+	stakeRoots  map[int64][]byte // the state roots of the executed blocks at the heights of the next stakes
+	rootLock    sync.Mutex
+	rootReady   *sync.Cond // signaled when a state root is added to stakeRoots
+
+	// the state of the current round
+	round         int
+	period        int
+	seed          []byte
+	lastBlock     *structs.Block // the last certified block, the proposals of the round extend it
+	startingValue []byte         // the starting value of the period, nil is ⊥
+	periodStart   time.Time
+	nextStep      int                             // the next timed step of the period
+	proposals     map[int]map[int]*proposalRecord // period -> proposer -> the first proposal seen
+	equivocators  map[int]bool                    // the proposers seen proposing two blocks in the round
+	blocks        map[string]*proposalRecord      // the hash of a block -> a proposal carrying it
+	votes         map[stepKey]map[int]*Vote       // the first vote of each voter in a step
+	tally         map[stepKey]map[string]int      // the votes of each value in a step
+	softQuorum    map[int][]byte                  // period -> the value of the soft quorum
+	certVoted     map[int][]byte                  // period -> the value this node cert-votes
+	nextQuorum    map[int]bool                    // the periods ended by a next quorum
+	future        []*message.Message              // the messages of the next round
+	lastRequest   time.Time                       // the certificates are requested at most once per λ
+	certs         map[int]*CertContent            // the certificates of the last rounds
+	wakeup        chan struct{}                   // Run resets the step timer when the period or the round changes
+
+	// tx related
+	pending []structs.Transaction // the txs not committed yet, in the order of arrival
+
+	// commit related
+	certified   *utils.Queue[*certifiedBlock] // the certified blocks waiting to be executed, the execution does not block the rounds
+	commitReady chan struct{}
+
+	posLock sync.Mutex
+}
+
+func NewPoSCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	posMod := new(PoSCosensusMod)
+	posMod.nodeAttr = attr
+	posMod.p2pMod = p2p
+
+	// every validator allocates the same stakes on the genesis state, then reads them back
+	bc := attr.CurChain
+	allocation := make(map[string]*big.Int)
+	for nid := 0; nid < config.NodeNum; nid++ {
+		allocation[config.StakeAddress(attr.Sid, nid)] = big.NewInt(config.ValidatorStake(nid))
+	}
+	bc.AllocateStakes(allocation)
+	posMod.loadStakes(bc.CurrentBlock.Header.StateRoot)
+	posMod.stakeRoots = make(map[int64][]byte)
+	posMod.rootReady = sync.NewCond(&posMod.rootLock)
+
+	seed := sha256.Sum256([]byte("seed-" + strconv.Itoa(attr.Sid)))
+	posMod.seed = seed[:]
+	posMod.lastBlock = bc.CurrentBlock
+	posMod.certs = make(map[int]*CertContent)
+	posMod.wakeup = make(chan struct{}, 1)
+	posMod.pending = make([]structs.Transaction, 0)
+	posMod.certified = utils.NewQueue[*certifiedBlock]()
+	posMod.commitReady = make(chan struct{}, 1)
+	posMod.startRound(1)
+
+	return posMod
+}
+
+// read the stakes of the validators from the balances of their stake accounts in the state
+func (posMod *PoSCosensusMod) loadStakes(stateRoot []byte) {
+	stm := posMod.nodeAttr.CurChain.StateManager
+	posMod.stakes = make(map[int]int64)
+	posMod.totalStake = 0
+	for nid := 0; nid < config.NodeNum; nid++ {
+		if balance := stm.GetBalance(stateRoot, config.StakeAddress(posMod.nodeAttr.Sid, nid)); balance != nil {
+			posMod.stakes[nid] = balance.Int64()
+			posMod.totalStake += balance.Int64()
+		}
+	}
+}
+
+// the height whose state gives the stakes while the last certified block is at height h. It is a multiple of
+// config.PoSStakeLookback and at least config.PoSStakeLookback blocks behind, so the block is usually executed already
+func stakeHeight(h int64) int64 {
+	lookback := int64(config.PoSStakeLookback)
+	return max(h/lookback-1, 0) * lookback
+}
+
+// call with posLock held, refresh the stakes when the last certified block moves them to the next height.
+// Every validator reads the same state at the same round, it waits for the execution of the block if it is behind,
+// the execution never takes posLock
+func (posMod *PoSCosensusMod) refreshStakes() {
+	height := stakeHeight(posMod.lastBlock.Header.Height)
+	if height <= posMod.stakeHeight {
+		return
+	}
+	posMod.rootLock.Lock()
+	for posMod.stakeRoots[height] == nil {
+		posMod.rootReady.Wait()
+	}
+	root := posMod.stakeRoots[height]
+	for h := range posMod.stakeRoots {
+		if h <= height {
+			delete(posMod.stakeRoots, h)
+		}
+	}
+	posMod.rootLock.Unlock()
+
+	posMod.stakeHeight = height
+	posMod.loadStakes(root)
+	utils.LoggerInstance.Info("Refresh the stakes from the state of height %d, the total stake is %d", height, posMod.totalStake)
+}
+
+func isSilent() bool {
+	return config.IsMalicious && config.MaliciousStrategy == SilentStrategy
+}
+
+func isEquivocator() bool {
+	return config.IsMalicious && config.MaliciousStrategy == EquivocateStrategy
+}
+
+// call with posLock held
+func (posMod *PoSCosensusMod) startRound(round int) {
+	posMod.round = round
+	posMod.proposals = make(map[int]map[int]*proposalRecord)
+	posMod.equivocators = make(map[int]bool)
+	posMod.blocks = make(map[string]*proposalRecord)
+	posMod.votes = make(map[stepKey]map[int]*Vote)
+	posMod.tally = make(map[stepKey]map[string]int)
+	posMod.softQuorum = make(map[int][]byte)
+	posMod.certVoted = make(map[int][]byte)
+	posMod.nextQuorum = make(map[int]bool)
+	posMod.startPeriod(1, nil)
+}
+
+// call with posLock held
+func (posMod *PoSCosensusMod) startPeriod(period int, startingValue []byte) {
+	posMod.period = period
+	posMod.startingValue = startingValue
+	posMod.periodStart = time.Now()
+	posMod.nextStep = stepPropose
+	select {
+	case posMod.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (posMod *PoSCosensusMod) quorum() int {
+	return int(config.PoSThreshold * float64(config.PoSCommitteeSize))
+}
+
+// the expected number of the selected sub-users in a step
+func stepTau(step int) float64 {
+	if step == stepPropose {
+		return float64(config.PoSProposerSize)
+	}
+	return float64(config.PoSCommitteeSize)
+}
+
+// receive the txs from the client, every validator keeps all the txs of the shard
+func (posMod *PoSCosensusMod) handleInject(msg *message.Message) {
+	txs := []structs.Transaction{}
+	err := utils.Decode(msg.Content, &txs)
+	if err != nil || len(txs) == 0 {
+		utils.LoggerInstance.Error("error decoding the txs")
+		return
+	}
+
+	posMod.posLock.Lock()
+	defer posMod.posLock.Unlock()
+	posMod.pending = append(posMod.pending, txs...)
+	utils.LoggerInstance.Debug("Receive %d txs, %d txs are pending", len(txs), len(posMod.pending))
+}
+
+// call with posLock held, keep the message of the next round, and ask the sender for the certificates if this node is far behind
+func (posMod *PoSCosensusMod) deferMessage(msg *message.Message, round int, from int) {
+	if round == posMod.round+1 {
+		posMod.future = append(posMod.future, msg)
+		return
+	}
+	posMod.requestCert(from)
+}
+
+// call with posLock held, ask the node for the certificate of the current round
+func (posMod *PoSCosensusMod) requestCert(from int) {
+	if time.Since(posMod.lastRequest) < time.Duration(config.PoSStepTimeout)*time.Millisecond {
+		return
+	}
+	posMod.lastRequest = time.Now()
+	req := message.Message{
+		MsgType: message.MsgCertRequest,
+		Content: utils.Encode(CertRequest{Round: posMod.round, Nid: posMod.nodeAttr.Nid}),
+	}
+	utils.LoggerInstance.Info("Request the certificate of round %d from node %d", posMod.round, from)
+	go posMod.p2pMod.ConnMananger.Send(config.IPMap[posMod.nodeAttr.Sid][from], req.JsonEncode())
+}
+
+// call with posLock held, verify the proposal of the current round and return its record
+func (posMod *PoSCosensusMod) verifyProposal(prop *Proposal) (*proposalRecord, bool) {
+	if prop.Block == nil || prop.Cred == nil {
+		return nil, false
+	}
+	nid := prop.Cred.Nid
+	beta, ok := verifySortition(posMod.nodeAttr.GetVRFKey(posMod.nodeAttr.Sid, nid), prop.Cred, posMod.seed,
+		prop.Round, prop.Period, stepPropose, posMod.stakes[nid], posMod.totalStake, stepTau(stepPropose))
+	if !ok {
+		utils.LoggerInstance.Warn("The credential of proposer %d in round %d period %d is not valid", nid, prop.Round, prop.Period)
+		return nil, false
+	}
+	pubKey := posMod.nodeAttr.GetPubKey(posMod.nodeAttr.Sid, nid)
+	if pubKey == nil || !signature.Verify(pubKey, proposalContent(prop.Round, prop.Period, prop.Block.Hash), prop.Sig) {
+		utils.LoggerInstance.Warn("The signature of proposer %d in round %d is not valid", nid, prop.Round)
+		return nil, false
+	}
+
+	header := prop.Block.Header
+	if !bytes.Equal(prop.Block.Hash, header.Hash()) ||
+		!bytes.Equal(header.PrevBlockHash, posMod.lastBlock.Hash) ||
+		header.Height != posMod.lastBlock.Header.Height+1 ||
+		!bytes.Equal(header.TxRoot, blockchain.GetTxTreeRoot(prop.Block.Transactions)) {
+		utils.LoggerInstance.Warn("The block proposed by node %d in round %d is not valid", nid, prop.Round)
+		return nil, false
+	}
+	return &proposalRecord{Prop: prop, Beta: beta, Priority: priority(beta, prop.Cred.Votes)}, true
+}
+
+func (posMod *PoSCosensusMod) handleProposal(msg *message.Message) {
+	prop := &Proposal{}
+	if err := utils.Decode(msg.Content, prop); err != nil || prop.Cred == nil {
+		utils.LoggerInstance.Error("Error decoding the proposal")
+		return
+	}
+	// the credentials are verified with the VRF keys, the messages may arrive before the keys
+	if !posMod.nodeAttr.WaitForPubKeys(nodeattr.PubKeyWaitTimeout) {
+		return
+	}
+
+	posMod.posLock.Lock()
+	defer posMod.posLock.Unlock()
+	if prop.Round < posMod.round {
+		return
+	}
+	if prop.Round > posMod.round {
+		posMod.deferMessage(msg, prop.Round, prop.Cred.Nid)
+		return
+	}
+	record, ok := posMod.verifyProposal(prop)
+	if !ok {
+		return
+	}
+
+	nid := prop.Cred.Nid
+	if posMod.proposals[prop.Period] == nil {
+		posMod.proposals[prop.Period] = make(map[int]*proposalRecord)
+	}
+	if first, ok := posMod.proposals[prop.Period][nid]; ok {
+		if !bytes.Equal(first.Prop.Block.Hash, prop.Block.Hash) && !posMod.equivocators[nid] {
+			utils.LoggerInstance.Warn("Proposer %d proposes two blocks in round %d period %d", nid, prop.Round, prop.Period)
+			posMod.equivocators[nid] = true
+		}
+	} else {
+		posMod.proposals[prop.Period][nid] = record
+	}
+	// the block may be certified by the others even if its proposer equivocates
+	if _, ok := posMod.blocks[string(prop.Block.Hash)]; !ok {
+		posMod.blocks[string(prop.Block.Hash)] = record
+	}
+	posMod.tryCertVote()
+}
+
+func (posMod *PoSCosensusMod) handleVote(msg *message.Message) {
+	vote := &Vote{}
+	if err := utils.Decode(msg.Content, vote); err != nil || vote.Cred == nil {
+		utils.LoggerInstance.Error("Error decoding the vote")
+		return
+	}
+	// the credentials are verified with the VRF keys, the messages may arrive before the keys
+	if !posMod.nodeAttr.WaitForPubKeys(nodeattr.PubKeyWaitTimeout) {
+		return
+	}
+
+	posMod.posLock.Lock()
+	defer posMod.posLock.Unlock()
+	if vote.Round < posMod.round {
+		return
+	}
+	if vote.Round > posMod.round {
+		posMod.deferMessage(msg, vote.Round, vote.Cred.Nid)
+		return
+	}
+	posMod.countVote(vote, true)
+}
+
+// call with posLock held, count the first vote of the voter in the step, and act on the quorums
+func (posMod *PoSCosensusMod) countVote(vote *Vote, verify bool) {
+	key := stepKey{Period: vote.Period, Step: vote.Step}
+	nid := vote.Cred.Nid
+	if _, ok := posMod.votes[key][nid]; ok {
+		return
+	}
+	if verify && !posMod.verifyVote(vote) {
+		return
+	}
+	if posMod.votes[key] == nil {
+		posMod.votes[key] = make(map[int]*Vote)
+		posMod.tally[key] = make(map[string]int)
+	}
+	posMod.votes[key][nid] = vote
+	posMod.tally[key][string(vote.Value)] += vote.Cred.Votes
+	if posMod.tally[key][string(vote.Value)] <= posMod.quorum() {
+		return
+	}
+
+	switch {
+	case vote.Step == stepSoft:
+		if _, ok := posMod.softQuorum[vote.Period]; !ok && len(vote.Value) > 0 {
+			posMod.softQuorum[vote.Period] = vote.Value
+			posMod.tryCertVote()
+		}
+	case vote.Step == stepCert:
+		posMod.onCertQuorum(key, vote.Value)
+	default:
+		if !posMod.nextQuorum[vote.Period] && vote.Period >= posMod.period {
+			posMod.nextQuorum[vote.Period] = true
+			utils.LoggerInstance.Info("Round %d period %d ends with a next quorum, the starting value of period %d is %x",
+				posMod.round, vote.Period, vote.Period+1, vote.Value)
+			var startingValue []byte
+			if len(vote.Value) > 0 {
+				startingValue = vote.Value
+			}
+			posMod.startPeriod(vote.Period+1, startingValue)
+		}
+	}
+}
+
+// call with posLock held
+func (posMod *PoSCosensusMod) verifyVote(vote *Vote) bool {
+	nid := vote.Cred.Nid
+	if _, ok := verifySortition(posMod.nodeAttr.GetVRFKey(posMod.nodeAttr.Sid, nid), vote.Cred, posMod.seed,
+		vote.Round, vote.Period, vote.Step, posMod.stakes[nid], posMod.totalStake, stepTau(vote.Step)); !ok {
+		utils.LoggerInstance.Warn("The credential of voter %d in round %d step %d is not valid", nid, vote.Round, vote.Step)
+		return false
+	}
+	pubKey := posMod.nodeAttr.GetPubKey(posMod.nodeAttr.Sid, nid)
+	if pubKey == nil || !signature.Verify(pubKey, voteContent(vote.Round, vote.Period, vote.Step, vote.Value), vote.Sig) {
+		utils.LoggerInstance.Warn("The signature of voter %d in round %d is not valid", nid, vote.Round)
+		return false
+	}
+	return true
+}
+
+// call with posLock held, the cert votes of the value are aggregated into the certificate
+func (posMod *PoSCosensusMod) onCertQuorum(key stepKey, value []byte) {
+	cert := &Certificate{Round: posMod.round, Period: key.Period, Value: value}
+	sigs := make([]*signature.Signature, 0)
+	for _, vote := range posMod.votes[key] {
+		if bytes.Equal(vote.Value, value) {
+			cert.Creds = append(cert.Creds, vote.Cred)
+			sigs = append(sigs, vote.Sig)
+		}
+	}
+	aggSig, err := signature.AggregateSignatures(sigs)
+	if err != nil {
+		utils.LoggerInstance.Error("Failed to aggregate the cert votes: %v", err)
+		return
+	}
+	cert.Sig = aggSig
+
+	content := &CertContent{Cert: cert}
+	if len(value) > 0 {
+		record, ok := posMod.blocks[string(value)]
+		if !ok {
+			// the block is certified without reaching this node, fetch the certificate from a voter
+			posMod.requestCert(cert.Creds[0].Nid)
+			return
+		}
+		content.Proposal = record.Prop
+	}
+	posMod.commitRound(content)
+}
+
+// call with posLock held, move to the next round with the certified value
+func (posMod *PoSCosensusMod) commitRound(content *CertContent) {
+	cert := content.Cert
+	now := time.Now()
+	report := message.RoundCommitContent{
+		Sid:       posMod.nodeAttr.Sid,
+		Nid:       posMod.nodeAttr.Nid,
+		Round:     cert.Round,
+		Period:    cert.Period,
+		Value:     cert.Value,
+		Height:    posMod.lastBlock.Header.Height,
+		Proposer:  -1,
+		CertVotes: 0,
+		Time:      now,
+	}
+	for _, cred := range cert.Creds {
+		report.CertVotes += cred.Votes
+	}
+
+	if content.Proposal != nil {
+		b := content.Proposal.Block
+		beta, _ := verifySortition(posMod.nodeAttr.GetVRFKey(posMod.nodeAttr.Sid, content.Proposal.Cred.Nid), content.Proposal.Cred, posMod.seed,
+			content.Proposal.Round, content.Proposal.Period, stepPropose, posMod.stakes[content.Proposal.Cred.Nid], posMod.totalStake, stepTau(stepPropose))
+		seed := sha256.Sum256(append(append([]byte{}, posMod.seed...), beta...))
+		posMod.seed = seed[:]
+		posMod.lastBlock = b
+		report.Height = b.Header.Height
+		report.Proposer = b.Header.Miner
+
+		done := make(map[string]bool)
+		for _, tx := range b.Transactions {
+			done[string(tx.Hash())] = true
+		}
+		kept := posMod.pending[:0]
+		for _, tx := range posMod.pending {
+			if !done[string(tx.Hash())] {
+				kept = append(kept, tx)
+			}
+		}
+		posMod.pending = kept
+		posMod.certified.Enqueue(&certifiedBlock{Block: b, CertTime: now, PendingNum: len(posMod.pending)})
+		select {
+		case posMod.commitReady <- struct{}{}:
+		default:
+		}
+		posMod.refreshStakes()
+		utils.LoggerInstance.Info("Round %d period %d certifies the block of height %d proposed by node %d with %d txs",
+			cert.Round, cert.Period, b.Header.Height, b.Header.Miner, len(b.Transactions))
+	} else {
+		seed := sha256.Sum256(append(append([]byte{}, posMod.seed...), []byte(strconv.Itoa(cert.Round))...))
+		posMod.seed = seed[:]
+		utils.LoggerInstance.Info("Round %d period %d certifies the empty value", cert.Round, cert.Period)
+	}
+
+	posMod.certs[cert.Round] = content
+	delete(posMod.certs, cert.Round-certHistory)
+	rmsg := message.Message{
+		MsgType: message.MsgRoundCommitted,
+		Content: utils.Encode(report),
+	}
+	go posMod.p2pMod.ConnMananger.Send(config.ClientAddr, rmsg.JsonEncode())
+
+	posMod.startRound(cert.Round + 1)
+	future := posMod.future
+	posMod.future = nil
+	if len(future) > 0 {
+		go posMod.replay(future)
+	}
+}
+
+// handle the messages of the round just started
+func (posMod *PoSCosensusMod) replay(msgs []*message.Message) {
+	for _, msg := range msgs {
+		if handler, ok := posMod.p2pMod.Handler(msg.MsgType); ok {
+			handler(msg)
+		}
+	}
+}
+
+// call with posLock held, cert-vote the soft-quorum value once its block is known, before the next votes of the period
+func (posMod *PoSCosensusMod) tryCertVote() {
+	value, ok := posMod.softQuorum[posMod.period]
+	if !ok || posMod.certVoted[posMod.period] != nil || posMod.nextStep > stepNext {
+		return
+	}
+	if _, ok := posMod.blocks[string(value)]; !ok {
+		return
+	}
+	posMod.certVoted[posMod.period] = value
+	posMod.castVote(stepCert, value)
+}
+
+// send the certificate of the requested round to the node behind
+func (posMod *PoSCosensusMod) handleCertRequest(msg *message.Message) {
+	req := CertRequest{}
+	if err := utils.Decode(msg.Content, &req); err != nil {
+		utils.LoggerInstance.Error("Error decoding the certificate request")
+		return
+	}
+
+	posMod.posLock.Lock()
+	content, ok := posMod.certs[req.Round]
+	posMod.posLock.Unlock()
+	if !ok {
+		return
+	}
+	cmsg := message.Message{
+		MsgType: message.MsgCertificate,
+		Content: utils.Encode(content),
+	}
+	go posMod.p2pMod.ConnMananger.Send(config.IPMap[posMod.nodeAttr.Sid][req.Nid], cmsg.JsonEncode())
+}
+
+// commit the round with the certificate from a node ahead
+func (posMod *PoSCosensusMod) handleCertificate(msg *message.Message) {
+	content := &CertContent{}
+	if err := utils.Decode(msg.Content, content); err != nil || content.Cert == nil {
+		utils.LoggerInstance.Error("Error decoding the certificate")
+		return
+	}
+
+	posMod.posLock.Lock()
+	defer posMod.posLock.Unlock()
+	cert := content.Cert
+	if cert.Round != posMod.round || !posMod.verifyCert(content) {
+		return
+	}
+	utils.LoggerInstance.Info("Catch up round %d with the certificate", cert.Round)
+	posMod.commitRound(content)
+}
+
+// call with posLock held, the credentials must carry a quorum and sign the value together
+func (posMod *PoSCosensusMod) verifyCert(content *CertContent) bool {
+	cert := content.Cert
+	votes := 0
+	pubKeys := make([]*signature.PublicKey, 0, len(cert.Creds))
+	seen := make(map[int]bool)
+	for _, cred := range cert.Creds {
+		if seen[cred.Nid] {
+			return false
+		}
+		seen[cred.Nid] = true
+		if _, ok := verifySortition(posMod.nodeAttr.GetVRFKey(posMod.nodeAttr.Sid, cred.Nid), cred, posMod.seed,
+			cert.Round, cert.Period, stepCert, posMod.stakes[cred.Nid], posMod.totalStake, stepTau(stepCert)); !ok {
+			return false
+		}
+		votes += cred.Votes
+		pubKeys = append(pubKeys, posMod.nodeAttr.GetPubKey(posMod.nodeAttr.Sid, cred.Nid))
+	}
+	if votes <= posMod.quorum() || cert.Sig == nil ||
+		!signature.VerifyAggregatedSignature(pubKeys, voteContent(cert.Round, cert.Period, stepCert, cert.Value), cert.Sig) {
+		utils.LoggerInstance.Warn("The certificate of round %d is not valid", cert.Round)
+		return false
+	}
+	if len(cert.Value) == 0 {
+		return content.Proposal == nil
+	}
+	if content.Proposal == nil || !bytes.Equal(content.Proposal.Block.Hash, cert.Value) {
+		return false
+	}
+	_, ok := posMod.verifyProposal(content.Proposal)
+	return ok
+}
+
+// call with posLock held, the sortition of the step, then sign and broadcast the vote if this node is selected
+func (posMod *PoSCosensusMod) castVote(step int, value []byte) {
+	nid := posMod.nodeAttr.Nid
+	cred, _ := sortition(posMod.nodeAttr.VRFKey, nid, posMod.seed, posMod.round, posMod.period, step,
+		posMod.stakes[nid], posMod.totalStake, stepTau(step))
+	if cred.Votes == 0 {
+		return
+	}
+	vote := &Vote{Round: posMod.round, Period: posMod.period, Step: step, Value: value, Cred: cred}
+	vote.Sig = signature.Sign(posMod.nodeAttr.SecKey, voteContent(vote.Round, vote.Period, step, value))
+	utils.LoggerInstance.Debug("Vote %x in round %d period %d step %d with %d votes", value, vote.Round, vote.Period, step, cred.Votes)
+
+	peers := utils.GetNeighbours(config.IPMap[posMod.nodeAttr.Sid], posMod.nodeAttr.Ipaddr)
+	if isEquivocator() {
+		// the other half of the shard receives the vote for another value
+		alt := &Vote{Round: vote.Round, Period: vote.Period, Step: step, Value: posMod.otherValue(value), Cred: cred}
+		alt.Sig = signature.Sign(posMod.nodeAttr.SecKey, voteContent(alt.Round, alt.Period, step, alt.Value))
+		first, second := posMod.halves()
+		posMod.broadcast(first, message.MsgVote, vote)
+		posMod.broadcast(second, message.MsgVote, alt)
+	} else {
+		posMod.broadcast(peers, message.MsgVote, vote)
+	}
+	posMod.countVote(vote, false)
+}
+
+// call with posLock held, the proposer builds a new block, or re-proposes the starting value
+func (posMod *PoSCosensusMod) propose() {
+	nid := posMod.nodeAttr.Nid
+	cred, beta := sortition(posMod.nodeAttr.VRFKey, nid, posMod.seed, posMod.round, posMod.period, stepPropose,
+		posMod.stakes[nid], posMod.totalStake, stepTau(stepPropose))
+	if cred.Votes == 0 {
+		return
+	}
+
+	var b *structs.Block
+	if posMod.startingValue != nil {
+		record, ok := posMod.blocks[string(posMod.startingValue)]
+		if !ok {
+			return
+		}
+		b = record.Prop.Block
+	} else {
+		txs := posMod.pending
+		if len(txs) > config.BlockSize {
+			txs = txs[:config.BlockSize]
+		}
+		txs = append([]structs.Transaction{}, txs...)
+		bh := &structs.BlockHeader{
+			PrevBlockHash: posMod.lastBlock.Hash,
+			Height:        posMod.lastBlock.Header.Height + 1,
+			TimeStamp:     time.Now(),
+			TxRoot:        blockchain.GetTxTreeRoot(txs),
+			Miner:         nid,
+		}
+		b = structs.NewBlock(bh, txs)
+	}
+	prop := &Proposal{Round: posMod.round, Period: posMod.period, Block: b, Cred: cred}
+	prop.Sig = signature.Sign(posMod.nodeAttr.SecKey, proposalContent(prop.Round, prop.Period, b.Hash))
+	utils.LoggerInstance.Info("Propose the block of height %d with %d txs in round %d period %d", b.Header.Height, len(b.Transactions), prop.Round, prop.Period)
+
+	record := &proposalRecord{Prop: prop, Beta: beta, Priority: priority(beta, cred.Votes)}
+	if posMod.proposals[prop.Period] == nil {
+		posMod.proposals[prop.Period] = make(map[int]*proposalRecord)
+	}
+	posMod.proposals[prop.Period][nid] = record
+	posMod.blocks[string(b.Hash)] = record
+
+	peers := utils.GetNeighbours(config.IPMap[posMod.nodeAttr.Sid], posMod.nodeAttr.Ipaddr)
+	if isEquivocator() && posMod.startingValue == nil {
+		// a twin block with another nonce goes to the other half of the shard
+		header := *b.Header
+		header.Nonce++
+		twin := structs.NewBlock(&header, b.Transactions)
+		alt := &Proposal{Round: prop.Round, Period: prop.Period, Block: twin, Cred: cred}
+		alt.Sig = signature.Sign(posMod.nodeAttr.SecKey, proposalContent(alt.Round, alt.Period, twin.Hash))
+		posMod.blocks[string(twin.Hash)] = &proposalRecord{Prop: alt, Beta: beta, Priority: record.Priority}
+		first, second := posMod.halves()
+		posMod.broadcast(first, message.MsgSortitionProposal, prop)
+		posMod.broadcast(second, message.MsgSortitionProposal, alt)
+		return
+	}
+	posMod.broadcast(peers, message.MsgSortitionProposal, prop)
+}
+
+// call with posLock held, the soft vote goes to the starting value, or the proposal of the lowest priority
+func (posMod *PoSCosensusMod) softVote() {
+	value := posMod.startingValue
+	if value == nil {
+		var best *proposalRecord
+		for nid, record := range posMod.proposals[posMod.period] {
+			if posMod.equivocators[nid] {
+				continue
+			}
+			if best == nil || bytes.Compare(record.Priority[:], best.Priority[:]) < 0 {
+				best = record
+			}
+		}
+		if best == nil {
+			return
+		}
+		value = best.Prop.Block.Hash
+	}
+	posMod.castVote(stepSoft, value)
+}
+
+// call with posLock held, the next vote keeps the value this node may have certified, so no other value gets a next quorum
+func (posMod *PoSCosensusMod) nextVote(step int) {
+	value := []byte{}
+	if v, ok := posMod.certVoted[posMod.period]; ok {
+		value = v
+	} else if v, ok := posMod.softQuorum[posMod.period]; ok {
+		value = v
+	} else if posMod.startingValue != nil {
+		value = posMod.startingValue
+	}
+	posMod.castVote(step, value)
+}
+
+// call with posLock held, run the timed step of the period
+func (posMod *PoSCosensusMod) runStep(step int) {
+	switch step {
+	// the step is passed before the vote, a quorum reached by the own vote may start another period
+	case stepPropose:
+		posMod.nextStep = stepSoft
+		posMod.propose()
+	case stepSoft:
+		posMod.nextStep = stepNext
+		posMod.softVote()
+	default:
+		posMod.nextStep = step + 1
+		posMod.nextVote(step)
+	}
+}
+
+// call with posLock held, a value other than the given one for the equivocating votes, ⊥ if no other block is known
+func (posMod *PoSCosensusMod) otherValue(value []byte) []byte {
+	for hash := range posMod.blocks {
+		if hash != string(value) {
+			return []byte(hash)
+		}
+	}
+	return []byte{}
+}
+
+// the two halves of the shard split by the node id, this node is left out
+func (posMod *PoSCosensusMod) halves() ([]string, []string) {
+	first, second := make([]string, 0), make([]string, 0)
+	for nid := 0; nid < config.NodeNum; nid++ {
+		if nid == posMod.nodeAttr.Nid {
+			continue
+		}
+		if nid < config.NodeNum/2 {
+			first = append(first, config.IPMap[posMod.nodeAttr.Sid][nid])
+		} else {
+			second = append(second, config.IPMap[posMod.nodeAttr.Sid][nid])
+		}
+	}
+	return first, second
+}
+
+func (posMod *PoSCosensusMod) broadcast(peers []string, msgType message.MessageType, content interface{}) {
+	msg := message.Message{
+		MsgType: msgType,
+		Content: utils.Encode(content),
+	}
+	go posMod.p2pMod.ConnMananger.Broadcast(posMod.nodeAttr.Ipaddr, peers, msg.JsonEncode())
+}
+
+// execute the certified blocks in order, the execution never takes posLock so that refreshStakes can wait for it
+func (posMod *PoSCosensusMod) commitCertified(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-posMod.commitReady:
+		}
+
+		for {
+			cb, err := posMod.certified.Dequeue()
+			if err != nil {
+				break
+			}
+			// the certificates may still serve the block, the state root is set on a copy of the header
+			header := *cb.Block.Header
+			b := &structs.Block{Header: &header, Transactions: cb.Block.Transactions, Hash: cb.Block.Hash}
+			bc := posMod.nodeAttr.CurChain
+			bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+			bc.CommitBlock(b)
+			utils.LoggerInstance.Info("Commit the block of height %d proposed by node %d with %d txs", b.Header.Height, b.Header.Miner, len(b.Transactions))
+			if b.Header.Height%int64(config.PoSStakeLookback) == 0 {
+				posMod.rootLock.Lock()
+				posMod.stakeRoots[b.Header.Height] = b.Header.StateRoot
+				posMod.rootLock.Unlock()
+				posMod.rootReady.Broadcast()
+			}
+
+			if posMod.nodeAttr.Nid == config.ViewNodeId && len(b.Transactions) > 0 {
+				posMod.sendReply(cb)
+			}
+		}
+	}
+}
+
+// send the certified block back to the client, so that the measure mod can work.
+// The request time is the time the block is proposed, so TCL is the latency of the agreement
+func (posMod *PoSCosensusMod) sendReply(cb *certifiedBlock) {
+	req := message.NewRequest(posMod.nodeAttr.Sid, message.ReqVerifyTxs, utils.Encode(cb.Block))
+	req.ReqTime = cb.Block.Header.TimeStamp
+
+	reply := &message.Reply{
+		Req:  req,
+		Time: cb.CertTime,
+
+		Sid:         posMod.nodeAttr.Sid,
+		ReqQueueLen: cb.PendingNum,
+	}
+
+	replymsg := message.Message{
+		MsgType: message.MsgReply,
+		Content: utils.Encode(reply),
+	}
+	go posMod.p2pMod.ConnMananger.Send(config.ClientAddr, replymsg.JsonEncode())
+}
+
+// a silent validator drops all the messages
+func (posMod *PoSCosensusMod) handleSilent(msg *message.Message) {}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (posMod *PoSCosensusMod) RegisterHandlers() {
+	if isSilent() {
+		posMod.p2pMod.RegisterHandler(message.MsgInject, posMod.handleSilent)
+		posMod.p2pMod.RegisterHandler(message.MsgSortitionProposal, posMod.handleSilent)
+		posMod.p2pMod.RegisterHandler(message.MsgVote, posMod.handleSilent)
+		posMod.p2pMod.RegisterHandler(message.MsgCertRequest, posMod.handleSilent)
+		posMod.p2pMod.RegisterHandler(message.MsgCertificate, posMod.handleSilent)
+		return
+	}
+	posMod.p2pMod.RegisterHandler(message.MsgInject, posMod.handleInject)
+	posMod.p2pMod.RegisterHandler(message.MsgSortitionProposal, posMod.handleProposal)
+	posMod.p2pMod.RegisterHandler(message.MsgVote, posMod.handleVote)
+	posMod.p2pMod.RegisterHandler(message.MsgCertRequest, posMod.handleCertRequest)
+	posMod.p2pMod.RegisterHandler(message.MsgCertificate, posMod.handleCertificate)
+}
+
+// Run drives the timed steps of the periods, the cert votes and the quorums are driven by the messages
+func (posMod *PoSCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if isSilent() {
+		utils.LoggerInstance.Info("This node is a silent validator, do not propose or vote")
+		return
+	}
+	if !posMod.nodeAttr.AwaitPubKeys(ctx) {
+		return
+	}
+
+	maliciousStake := int64(0)
+	for nid, stake := range posMod.stakes {
+		if config.IsMaliciousProcess(posMod.nodeAttr.Sid, nid) {
+			maliciousStake += stake
+		}
+	}
+	utils.LoggerInstance.Info("Start the PoS consensus Mod, the malicious validators hold %.2f%% of the stake %d",
+		100*float64(maliciousStake)/float64(posMod.totalStake), posMod.totalStake)
+
+	go posMod.commitCertified(ctx)
+	lambda := time.Duration(config.PoSStepTimeout) * time.Millisecond
+	posMod.posLock.Lock()
+	posMod.periodStart = time.Now()
+	posMod.posLock.Unlock()
+	for {
+		posMod.posLock.Lock()
+		step := posMod.nextStep
+		wait := time.Until(posMod.periodStart.Add(stepDeadline(step, lambda)))
+		posMod.posLock.Unlock()
+
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			utils.LoggerInstance.Info("Stop the PoS consensus Mod")
+			return
+		case <-posMod.wakeup:
+			timer.Stop()
+		case <-timer.C:
+			posMod.posLock.Lock()
+			// the period may change while waiting for the lock
+			if posMod.nextStep == step {
+				posMod.runStep(step)
+			}
+			posMod.posLock.Unlock()
+		}
+	}
+}
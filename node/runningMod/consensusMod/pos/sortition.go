@@ -0,0 +1,94 @@
+// This file contains the cryptographic sortition of Algorand.
+// Every unit of stake is a sub-user, which is selected with the probability tau/W in a step, W is the total stake.
+// A node learns how many of its sub-users are selected from its VRF output on the seed, the round, the period and the step,
+// and proves it with the VRF proof, so the committees are known only when their members speak
+package pos
+
+import (
+	"BlockChainSimulator/utils"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+
+	"github.com/vechain/go-ecvrf"
+)
+
+// the credential of a node in a step
+type Credential struct {
+	Nid   int
+	Proof []byte // the VRF proof on the sortition input
+	Votes int    // the number of the selected sub-users, the weight of the node in the step
+}
+
+// the input of the VRF, the seed changes every round so the committees cannot be predicted
+func sortitionInput(seed []byte, round int, period int, step int) []byte {
+	return utils.CanonicalEncode(struct {
+		Seed   []byte
+		Round  int
+		Period int
+		Step   int
+	}{seed, round, period, step})
+}
+
+// the sortition of the node, returns the credential and the VRF output, the credential has no votes if the node is not selected
+func sortition(sk *ecdsa.PrivateKey, nid int, seed []byte, round int, period int, step int, stake int64, total int64, tau float64) (*Credential, []byte) {
+	beta, pi, err := ecvrf.P256Sha256Tai.Prove(sk, sortitionInput(seed, round, period, step))
+	if err != nil {
+		utils.LoggerInstance.Error("Error proving the sortition, err: %v", err)
+		return &Credential{Nid: nid}, nil
+	}
+	return &Credential{Nid: nid, Proof: pi, Votes: selected(beta, stake, total, tau)}, beta
+}
+
+// verify the credential of a node, returns the VRF output if the proof and the votes are valid
+func verifySortition(pk *ecdsa.PublicKey, cred *Credential, seed []byte, round int, period int, step int, stake int64, total int64, tau float64) ([]byte, bool) {
+	if pk == nil || cred.Votes <= 0 {
+		return nil, false
+	}
+	beta, err := ecvrf.P256Sha256Tai.Verify(pk, sortitionInput(seed, round, period, step), cred.Proof)
+	if err != nil {
+		return nil, false
+	}
+	return beta, selected(beta, stake, total, tau) == cred.Votes
+}
+
+// the number of the selected sub-users: the VRF output is read as a uniform number in [0, 1),
+// and located in the CDF of the binomial distribution B(stake, tau/total)
+func selected(beta []byte, stake int64, total int64, tau float64) int {
+	if stake <= 0 || total <= 0 || len(beta) < 8 {
+		return 0
+	}
+	p := tau / float64(total)
+	if p >= 1 {
+		return int(stake)
+	}
+	x := float64(binary.BigEndian.Uint64(beta[:8])) / math.Pow(2, 64)
+
+	// the terms are computed in the log space, (1-p)^stake underflows for large stakes
+	w := float64(stake)
+	lw, _ := math.Lgamma(w + 1)
+	logP, logQ := math.Log(p), math.Log1p(-p)
+	cdf := 0.0
+	for j := int64(0); j < stake; j++ {
+		lj, _ := math.Lgamma(float64(j) + 1)
+		lwj, _ := math.Lgamma(w - float64(j) + 1)
+		cdf += math.Exp(lw - lj - lwj + float64(j)*logP + (w-float64(j))*logQ)
+		if x < cdf {
+			return int(j)
+		}
+	}
+	return int(stake)
+}
+
+// the priority of a proposer is the lowest hash of its selected sub-users, the proposal of the lowest priority wins
+func priority(beta []byte, votes int) [32]byte {
+	best := [32]byte{}
+	for i := 1; i <= votes; i++ {
+		h := sha256.Sum256(append(append([]byte{}, beta...), byte(i>>24), byte(i>>16), byte(i>>8), byte(i)))
+		if i == 1 || string(h[:]) < string(best[:]) {
+			best = h
+		}
+	}
+	return best
+}
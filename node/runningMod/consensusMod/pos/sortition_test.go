@@ -0,0 +1,74 @@
+package pos
+
+import (
+	"BlockChainSimulator/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortition(t *testing.T) {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	seed := sha256.Sum256([]byte("seed"))
+	stake, total, tau := int64(1000), int64(4000), 1000.0
+
+	// the selected sub-users follow B(stake, tau/total), the mean is 250 and the deviation is about 14
+	sum := 0
+	rounds := 200
+	for round := 1; round <= rounds; round++ {
+		cred, beta := sortition(sk, 0, seed[:], round, 1, stepSoft, stake, total, tau)
+		assert.NotNil(t, beta)
+		sum += cred.Votes
+
+		// the credential is verified with the public key
+		vbeta, ok := verifySortition(&sk.PublicKey, cred, seed[:], round, 1, stepSoft, stake, total, tau)
+		assert.True(t, ok)
+		assert.Equal(t, beta, vbeta)
+	}
+	mean := float64(sum) / float64(rounds)
+	assert.InDelta(t, 250, mean, 5)
+
+	// a credential claiming more votes, another step or another key is rejected
+	cred, _ := sortition(sk, 0, seed[:], 1, 1, stepSoft, stake, total, tau)
+	forged := *cred
+	forged.Votes++
+	_, ok := verifySortition(&sk.PublicKey, &forged, seed[:], 1, 1, stepSoft, stake, total, tau)
+	assert.False(t, ok)
+	_, ok = verifySortition(&sk.PublicKey, cred, seed[:], 1, 1, stepCert, stake, total, tau)
+	assert.False(t, ok)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ok = verifySortition(&other.PublicKey, cred, seed[:], 1, 1, stepSoft, stake, total, tau)
+	assert.False(t, ok)
+
+	// no stake, no votes
+	cred, _ = sortition(sk, 0, seed[:], 1, 1, stepSoft, 0, total, tau)
+	assert.Equal(t, 0, cred.Votes)
+}
+
+func TestPriority(t *testing.T) {
+	beta := sha256.Sum256([]byte("beta"))
+
+	// more sub-users can only lower the priority, the first ones are kept
+	p1 := priority(beta[:], 1)
+	p5 := priority(beta[:], 5)
+	assert.LessOrEqual(t, string(p5[:]), string(p1[:]))
+	assert.Equal(t, p5, priority(beta[:], 5))
+}
+
+// the stakes are read at the multiples of the lookback, at least one lookback behind the last certified block
+func TestStakeHeight(t *testing.T) {
+	lookback := int64(config.PoSStakeLookback)
+	assert.Equal(t, int64(0), stakeHeight(0))
+	assert.Equal(t, int64(0), stakeHeight(2*lookback-1))
+	assert.Equal(t, lookback, stakeHeight(2*lookback))
+	for h := int64(0); h < 5*lookback; h++ {
+		height := stakeHeight(h)
+		assert.Zero(t, height%lookback)
+		assert.True(t, height == 0 || h-height >= lookback)
+	}
+}
@@ -0,0 +1,109 @@
+package pos
+
+import (
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"time"
+)
+
+// the steps of a period, the next votes take the steps stepNext, stepNext+1, ... until a value gets a next quorum
+const (
+	stepPropose = 1
+	stepSoft    = 2
+	stepCert    = 3
+	stepNext    = 4
+)
+
+// the content of the MsgSortitionProposal message, a proposer re-proposes the starting value in the later periods
+type Proposal struct {
+	Round  int
+	Period int
+	Block  *structs.Block
+	Cred   *Credential          // the credential of the proposer in the propose step of the period
+	Sig    *signature.Signature // the signature of the proposer on proposalContent
+}
+
+// the content of the MsgVote message, an empty value is ⊥
+type Vote struct {
+	Round  int
+	Period int
+	Step   int
+	Value  []byte
+	Cred   *Credential          // the credential of the voter in the step
+	Sig    *signature.Signature // the signature of the voter on voteContent
+}
+
+// the cert votes of a value, every cert vote signs the same content so the signatures are aggregated
+type Certificate struct {
+	Round  int
+	Period int
+	Value  []byte
+	Creds  []*Credential
+	Sig    *signature.Signature
+}
+
+// the content of the MsgCertificate message, the proposal carries the certified block, nil if the value is ⊥
+type CertContent struct {
+	Cert     *Certificate
+	Proposal *Proposal
+}
+
+// the content of the MsgCertRequest message
+type CertRequest struct {
+	Round int
+	Nid   int // the node to send the certificate to
+}
+
+// a verified proposal, the VRF output of the proposer feeds the seed of the next round
+type proposalRecord struct {
+	Prop     *Proposal
+	Beta     []byte
+	Priority [32]byte
+}
+
+// a certified block waiting to be executed
+type certifiedBlock struct {
+	Block      *structs.Block
+	CertTime   time.Time
+	PendingNum int // the number of the pending txs when the block is certified
+}
+
+func proposalContent(round int, period int, value []byte) []byte {
+	return utils.CanonicalEncode(struct {
+		Round  int
+		Period int
+		Value  []byte
+	}{round, period, value})
+}
+
+func voteContent(round int, period int, step int, value []byte) []byte {
+	// ⊥ arrives as nil after the decoding, it is signed as nil
+	if len(value) == 0 {
+		value = nil
+	}
+	return utils.CanonicalEncode(struct {
+		Round  int
+		Period int
+		Step   int
+		Value  []byte
+	}{round, period, step, value})
+}
+
+// the key of the votes of a step
+type stepKey struct {
+	Period int
+	Step   int
+}
+
+// the deadline of a timed step since the start of the period: propose at 0, soft vote at 2λ, the next votes at 4λ, 6λ, ...
+func stepDeadline(step int, lambda time.Duration) time.Duration {
+	switch {
+	case step == stepPropose:
+		return 0
+	case step == stepSoft:
+		return 2 * lambda
+	default:
+		return 4*lambda + time.Duration(step-stepNext)*2*lambda
+	}
+}
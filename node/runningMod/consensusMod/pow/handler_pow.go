@@ -0,0 +1,401 @@
+// This file contains the Nakamoto-style PoW consensus module, every node of the shard is a miner.
+// Mining is simulated: a miner finds a block after an exponential time with the mean difficulty/config.PoWHashRate,
+// so the block interval of the shard follows the difficulty without burning the CPU. The blocks are gossiped to
+// config.PoWGossipFanout random peers per hop, each hop is delayed by config.PoWGossipDelay, the forks come from the delay.
+// The fork choice follows the heaviest chain, a block is committed once it is config.PoWConfirmDepth blocks deep, see blockchain/forkchoice.go
+package pow
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// implement the ConsensusMod interface
+var _ runningModInterface.RunningMod = &PoWCosensusMod{}
+
+const SilentStrategy = "Silent" // a malicious miner neither mines nor relays the blocks, it only takes its hash rate away
+
+type PoWCosensusMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	// mining related
+	tree        *blockchain.ForkTree
+	headChanged chan struct{}         // the miner restarts on the new head, the mining time is memoryless
+	pending     []structs.Transaction // the txs not confirmed yet, in the order of arrival
+	onChain     map[string]bool       // the txs in the unconfirmed blocks of the heaviest chain, the key is the hash of the tx
+	rnd         *rand.Rand
+	powLock     sync.Mutex
+
+	// commit related
+	confirmed    *utils.Queue[*confirmedBlock] // the confirmed blocks waiting to be committed, the execution is slower than the gossip
+	confirmReady chan struct{}
+	confirmedNum int64 // number of the blocks confirmed so far
+}
+
+func NewPoWCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	powMod := new(PoWCosensusMod)
+	powMod.nodeAttr = attr
+	powMod.p2pMod = p2p
+
+	// the difficulty adjustment looks back on the confirmed blocks
+	powMod.tree = attr.CurChain.NewForkTree(config.PoWConfirmDepth, config.PoWRetargetInterval, config.PoWMaxOrphans)
+	powMod.tree.Validate = validateBlock
+	powMod.headChanged = make(chan struct{}, 1)
+	powMod.confirmed = utils.NewQueue[*confirmedBlock]()
+	powMod.confirmReady = make(chan struct{}, 1)
+	powMod.pending = make([]structs.Transaction, 0)
+	powMod.onChain = make(map[string]bool)
+	powMod.rnd = rand.New(rand.NewSource(time.Now().UnixNano() + int64(attr.Sid*config.NodeNum+attr.Nid)))
+
+	return powMod
+}
+
+func (powMod *PoWCosensusMod) isSilent() bool {
+	return config.IsMalicious && config.MaliciousStrategy == SilentStrategy
+}
+
+// receive the txs from the client, every miner keeps all the txs of the shard
+func (powMod *PoWCosensusMod) handleInject(msg *message.Message) {
+	txs := []structs.Transaction{}
+	err := utils.Decode(msg.Content, &txs)
+	if err != nil || len(txs) == 0 {
+		utils.LoggerInstance.Error("error decoding the txs")
+		return
+	}
+
+	powMod.powLock.Lock()
+	defer powMod.powLock.Unlock()
+	powMod.pending = append(powMod.pending, txs...)
+	utils.LoggerInstance.Debug("Receive %d txs, %d txs are pending", len(txs), len(powMod.pending))
+}
+
+// a block gossiped by another miner
+func (powMod *PoWCosensusMod) handleMinedBlock(msg *message.Message) {
+	content := BlockContent{}
+	if err := utils.Decode(msg.Content, &content); err != nil {
+		utils.LoggerInstance.Error("Error decoding the block")
+		return
+	}
+	b := content.Block
+	if b == nil || b.Header == nil {
+		return
+	}
+	// the hash links the blocks of the fork tree, never trust the one of the sender
+	b.Hash = b.Header.Hash()
+
+	powMod.powLock.Lock()
+	if powMod.tree.Known(b.Hash) {
+		powMod.powLock.Unlock()
+		return
+	}
+	connected, reorg, err := powMod.tree.AddBlock(b)
+	if err != nil {
+		powMod.powLock.Unlock()
+		utils.LoggerInstance.Warn("Drop the block of height %d from miner %d: %v", b.Header.Height, b.Header.Miner, err)
+		return
+	}
+	missing := len(connected) == 0 && !powMod.tree.Known(b.Header.PrevBlockHash)
+	powMod.onHeadChanged(reorg)
+	powMod.powLock.Unlock()
+
+	// ask the sender for the parent of the orphan, it has connected the parent before relaying the block
+	if missing {
+		utils.LoggerInstance.Debug("Received the orphan block of height %d, request its parent", b.Header.Height)
+		req := message.Message{
+			MsgType: message.MsgBlockRequest,
+			Content: utils.Encode(BlockRequest{Hash: b.Header.PrevBlockHash, Addr: powMod.nodeAttr.Ipaddr}),
+		}
+		go powMod.p2pMod.ConnMananger.Send(content.From, req.JsonEncode())
+	}
+	for _, node := range connected {
+		powMod.gossip(node.Block)
+	}
+}
+
+// send the requested block back, the blocks confirmed deep under the head are not served
+func (powMod *PoWCosensusMod) handleBlockRequest(msg *message.Message) {
+	req := BlockRequest{}
+	if err := utils.Decode(msg.Content, &req); err != nil {
+		utils.LoggerInstance.Error("Error decoding the block request")
+		return
+	}
+
+	powMod.powLock.Lock()
+	node := powMod.tree.Get(req.Hash)
+	powMod.powLock.Unlock()
+	if node == nil {
+		return
+	}
+
+	bmsg := message.Message{
+		MsgType: message.MsgMinedBlock,
+		Content: utils.Encode(BlockContent{Block: node.Block, From: powMod.nodeAttr.Ipaddr}),
+	}
+	go func() {
+		time.Sleep(time.Duration(config.PoWGossipDelay) * time.Millisecond)
+		powMod.p2pMod.ConnMananger.Send(req.Addr, bmsg.JsonEncode())
+	}()
+}
+
+// call with powLock held, move the txs of the reorganized blocks and confirm the blocks deep enough
+func (powMod *PoWCosensusMod) onHeadChanged(reorg *blockchain.Reorg) {
+	if reorg == nil {
+		return
+	}
+	if len(reorg.Detached) > 0 {
+		utils.LoggerInstance.Info("Reorg: %d blocks are detached, the new head is of height %d", len(reorg.Detached), powMod.tree.Head.Height())
+	}
+	for _, node := range reorg.Detached {
+		for _, tx := range node.Block.Transactions {
+			delete(powMod.onChain, string(tx.Hash()))
+		}
+	}
+	for _, node := range reorg.Attached {
+		for _, tx := range node.Block.Transactions {
+			powMod.onChain[string(tx.Hash())] = true
+		}
+	}
+	select {
+	case powMod.headChanged <- struct{}{}:
+	default:
+	}
+
+	confirmed := powMod.tree.Confirm()
+	if len(confirmed) == 0 {
+		return
+	}
+	done := make(map[string]bool)
+	for _, node := range confirmed {
+		for _, tx := range node.Block.Transactions {
+			done[string(tx.Hash())] = true
+			delete(powMod.onChain, string(tx.Hash()))
+		}
+		powMod.confirmedNum++
+		powMod.confirmed.Enqueue(&confirmedBlock{
+			Block:       node.Block,
+			ConfirmTime: time.Now(),
+			Stale:       powMod.tree.Stale,
+			Confirmed:   powMod.confirmedNum,
+		})
+	}
+	select {
+	case powMod.confirmReady <- struct{}{}:
+	default:
+	}
+	kept := powMod.pending[:0]
+	for _, tx := range powMod.pending {
+		if !done[string(tx.Hash())] {
+			kept = append(kept, tx)
+		}
+	}
+	powMod.pending = kept
+}
+
+// call with powLock held, the first txs not in the heaviest chain yet
+func (powMod *PoWCosensusMod) selectTxs() []structs.Transaction {
+	txs := make([]structs.Transaction, 0)
+	for _, tx := range powMod.pending {
+		if len(txs) >= config.BlockSize {
+			break
+		}
+		if !powMod.onChain[string(tx.Hash())] {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// the mining time of a block, exponentially distributed
+func (powMod *PoWCosensusMod) miningTime(difficulty int64) time.Duration {
+	seconds := powMod.rnd.ExpFloat64() * float64(difficulty) / float64(config.PoWHashRate)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// the block is found on the head, build it and gossip it
+func (powMod *PoWCosensusMod) mine(head *blockchain.ForkNode, difficulty int64) {
+	powMod.powLock.Lock()
+	if powMod.tree.Head != head {
+		powMod.powLock.Unlock()
+		return
+	}
+	txs := powMod.selectTxs()
+	bh := &structs.BlockHeader{
+		PrevBlockHash: head.Block.Hash,
+		Height:        head.Height() + 1,
+		Nonce:         powMod.rnd.Int63(), // the simulated nonce meeting the difficulty
+		TimeStamp:     time.Now(),
+		TxRoot:        blockchain.GetTxTreeRoot(txs),
+		Miner:         powMod.nodeAttr.Nid,
+		Difficulty:    difficulty,
+	}
+	b := structs.NewBlock(bh, txs)
+	_, reorg, err := powMod.tree.AddBlock(b)
+	if err != nil {
+		powMod.powLock.Unlock()
+		utils.LoggerInstance.Error("The mined block is not valid: %v", err)
+		return
+	}
+	powMod.onHeadChanged(reorg)
+	powMod.powLock.Unlock()
+
+	utils.LoggerInstance.Info("Mine the block of height %d with %d txs, difficulty %d", bh.Height, len(txs), difficulty)
+	powMod.gossip(b)
+}
+
+// push the block to config.PoWGossipFanout random peers of the shard
+func (powMod *PoWCosensusMod) gossip(b *structs.Block) {
+	peers := utils.GetNeighbours(config.IPMap[powMod.nodeAttr.Sid], powMod.nodeAttr.Ipaddr)
+	powMod.powLock.Lock()
+	powMod.rnd.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	powMod.powLock.Unlock()
+	if config.PoWGossipFanout > 0 && len(peers) > config.PoWGossipFanout {
+		peers = peers[:config.PoWGossipFanout]
+	}
+
+	bmsg := message.Message{
+		MsgType: message.MsgMinedBlock,
+		Content: utils.Encode(BlockContent{Block: b, From: powMod.nodeAttr.Ipaddr}),
+	}
+	go func() {
+		time.Sleep(time.Duration(config.PoWGossipDelay) * time.Millisecond)
+		powMod.p2pMod.ConnMananger.Broadcast(powMod.nodeAttr.Ipaddr, peers, bmsg.JsonEncode())
+	}()
+}
+
+// commit the confirmed blocks in order, the execution does not block the mining and the gossip
+func (powMod *PoWCosensusMod) commitConfirmed(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-powMod.confirmReady:
+		}
+
+		for {
+			cb, err := powMod.confirmed.Dequeue()
+			if err != nil {
+				break
+			}
+			// the fork tree may still serve the block, the state root is set on a copy of the header
+			header := *cb.Block.Header
+			b := &structs.Block{Header: &header, Transactions: cb.Block.Transactions, Hash: cb.Block.Hash}
+			bc := powMod.nodeAttr.CurChain
+			bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+			bc.CommitBlock(b)
+			utils.LoggerInstance.Info("Commit the block of height %d mined by node %d with %d txs", b.Header.Height, b.Header.Miner, len(b.Transactions))
+
+			// the view node reports the chain of the shard to the client
+			if powMod.nodeAttr.Nid == config.ViewNodeId {
+				powMod.sendReply(cb)
+				powMod.sendConfirm(cb)
+			}
+		}
+	}
+}
+
+// send the confirmed block back to the client, so that the measure mod can work.
+// The request time is the time the block is mined, so TCL is the latency of the confirmation
+func (powMod *PoWCosensusMod) sendReply(cb *confirmedBlock) {
+	req := message.NewRequest(powMod.nodeAttr.Sid, message.ReqVerifyTxs, utils.Encode(cb.Block))
+	req.ReqTime = cb.Block.Header.TimeStamp
+
+	powMod.powLock.Lock()
+	pendingNum := len(powMod.pending)
+	powMod.powLock.Unlock()
+	reply := &message.Reply{
+		Req:  req,
+		Time: cb.ConfirmTime,
+
+		Sid:         powMod.nodeAttr.Sid,
+		ReqQueueLen: pendingNum,
+	}
+
+	replymsg := message.Message{
+		MsgType: message.MsgReply,
+		Content: utils.Encode(reply),
+	}
+	go powMod.p2pMod.ConnMananger.Send(config.ClientAddr, replymsg.JsonEncode())
+}
+
+func (powMod *PoWCosensusMod) sendConfirm(cb *confirmedBlock) {
+	b := cb.Block
+	txLatency := 0.0
+	for _, tx := range b.Transactions {
+		txLatency += cb.ConfirmTime.Sub(tx.GetTime()).Seconds() / float64(len(b.Transactions))
+	}
+	content := message.ConfirmContent{
+		Sid:         powMod.nodeAttr.Sid,
+		Height:      b.Header.Height,
+		Miner:       b.Header.Miner,
+		Difficulty:  b.Header.Difficulty,
+		MinedTime:   b.Header.TimeStamp,
+		ConfirmTime: cb.ConfirmTime,
+		TxNum:       len(b.Transactions),
+		TxLatency:   txLatency,
+		Stale:       cb.Stale,
+		Confirmed:   cb.Confirmed,
+	}
+	cmsg := message.Message{
+		MsgType: message.MsgBlockConfirmed,
+		Content: utils.Encode(content),
+	}
+	go powMod.p2pMod.ConnMananger.Send(config.ClientAddr, cmsg.JsonEncode())
+}
+
+// a silent miner drops all the messages
+func (powMod *PoWCosensusMod) handleSilent(msg *message.Message) {}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (powMod *PoWCosensusMod) RegisterHandlers() {
+	if powMod.isSilent() {
+		powMod.p2pMod.RegisterHandler(message.MsgInject, powMod.handleSilent)
+		powMod.p2pMod.RegisterHandler(message.MsgMinedBlock, powMod.handleSilent)
+		powMod.p2pMod.RegisterHandler(message.MsgBlockRequest, powMod.handleSilent)
+		return
+	}
+	powMod.p2pMod.RegisterHandler(message.MsgInject, powMod.handleInject)
+	powMod.p2pMod.RegisterHandler(message.MsgMinedBlock, powMod.handleMinedBlock)
+	powMod.p2pMod.RegisterHandler(message.MsgBlockRequest, powMod.handleBlockRequest)
+}
+
+// Run starts the miner, a new mining time is drawn every time the head changes
+func (powMod *PoWCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if powMod.isSilent() {
+		utils.LoggerInstance.Info("This node is a silent miner, do not mine")
+		return
+	}
+
+	go powMod.commitConfirmed(ctx)
+	utils.LoggerInstance.Info("Start the PoW consensus Mod")
+	for {
+		powMod.powLock.Lock()
+		head := powMod.tree.Head
+		difficulty := nextDifficulty(head)
+		powMod.powLock.Unlock()
+
+		timer := time.NewTimer(powMod.miningTime(difficulty))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			utils.LoggerInstance.Info("Stop the PoW consensus Mod")
+			return
+		case <-powMod.headChanged:
+			timer.Stop()
+		case <-timer.C:
+			powMod.mine(head, difficulty)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pow
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/structs"
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// the content of the MsgMinedBlock message
+type BlockContent struct {
+	Block *structs.Block
+	From  string // the address of the sender, the receiver requests the parent of an orphan block from it
+}
+
+// the content of the MsgBlockRequest message
+type BlockRequest struct {
+	Hash []byte
+	Addr string // the address to send the block to
+}
+
+// a block confirmed in the heaviest chain, waiting to be committed
+type confirmedBlock struct {
+	Block       *structs.Block
+	ConfirmTime time.Time
+	Stale       int   // number of the stale blocks when the block is confirmed
+	Confirmed   int64 // number of the confirmed blocks, including the block
+}
+
+// the difficulty of the child of the parent. Like Bitcoin, the difficulty is adjusted every config.PoWRetargetInterval blocks
+// by the time the last blocks took against the target interval, at most by a factor of 4
+func nextDifficulty(parent *blockchain.ForkNode) int64 {
+	header := parent.Block.Header
+	if header.Difficulty == 0 {
+		return config.PoWGenesisDifficulty() // the genesis block, or the chain is left by another protocol
+	}
+	interval := int64(config.PoWRetargetInterval)
+	if interval <= 0 || (header.Height+1)%interval != 0 {
+		return header.Difficulty
+	}
+
+	// the time stamp of the genesis block is fixed, the first window starts from the first mined block
+	first := blockchain.Ancestor(parent, max(header.Height-interval, 1))
+	if first == nil || first == parent {
+		return header.Difficulty
+	}
+	span := max(header.TimeStamp.Sub(first.Block.Header.TimeStamp).Milliseconds(), 1)
+	expected := (header.Height - first.Height()) * int64(config.PoWBlockInterval)
+
+	difficulty := header.Difficulty * expected / span
+	difficulty = min(max(difficulty, header.Difficulty/4), header.Difficulty*4)
+	return max(difficulty, 1)
+}
+
+// the proof of work is simulated by the mining time, so a block is checked by the rules every miner follows.
+// The height is checked by the fork tree
+func validateBlock(parent *blockchain.ForkNode, b *structs.Block) error {
+	if want := nextDifficulty(parent); b.Header.Difficulty != want {
+		return fmt.Errorf("the difficulty of the block of height %d is %d, want %d", b.Header.Height, b.Header.Difficulty, want)
+	}
+	if b.Header.TimeStamp.Before(parent.Block.Header.TimeStamp) {
+		return fmt.Errorf("the block of height %d is mined before its parent", b.Header.Height)
+	}
+	// otherwise a miner stretches the span of the retarget window and lowers the difficulty
+	if b.Header.TimeStamp.After(time.Now().Add(time.Duration(config.PoWMaxFutureTime) * time.Millisecond)) {
+		return fmt.Errorf("the block of height %d is mined in the future", b.Header.Height)
+	}
+	if !bytes.Equal(b.Header.TxRoot, blockchain.GetTxTreeRoot(b.Transactions)) {
+		return fmt.Errorf("the tx root of the block of height %d does not match the txs", b.Header.Height)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package pow
+
+import (
+	"BlockChainSimulator/blockchain"
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/structs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the time stamp of a block lies between the one of its parent and the local clock
+func TestValidateBlockTime(t *testing.T) {
+	genesis := structs.NewBlock(&structs.BlockHeader{TimeStamp: time.Now().Add(-time.Minute)}, nil)
+	parent := &blockchain.ForkNode{Block: genesis}
+	mined := func(ts time.Time) *structs.Block {
+		return structs.NewBlock(&structs.BlockHeader{
+			PrevBlockHash: genesis.Hash,
+			Height:        1,
+			TimeStamp:     ts,
+			TxRoot:        blockchain.GetTxTreeRoot(nil),
+			Difficulty:    config.PoWGenesisDifficulty(),
+		}, nil)
+	}
+
+	assert.NoError(t, validateBlock(parent, mined(time.Now())))
+	assert.Error(t, validateBlock(parent, mined(genesis.Header.TimeStamp.Add(-time.Second))), "mined before the parent")
+	assert.Error(t, validateBlock(parent, mined(time.Now().Add(time.Hour))), "mined in the future")
+}
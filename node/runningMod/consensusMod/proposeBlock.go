@@ -43,6 +43,12 @@ func (pbm *ProposeBlockAuxiliaryMod) RegisterHandlers() {
 // get the txs from the txPool, pack them to a block and propose to the shard
 func (pbm *ProposeBlockAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
+
+	// the consensus starts after the public keys are distributed
+	if !pbm.nodeAttr.AwaitPubKeys(ctx) {
+		return
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -66,7 +72,9 @@ func (pbm *ProposeBlockAuxiliaryMod) TryProposeBlock() {
 		}
 
 		utils.LoggerInstance.Info("Propose block with %d txs to shard %d", len(txs), pbm.nodeAttr.Sid)
-		pbm.p2pMod.MsgHandlerMap[message.MsgPropose](&msg)
+		if handler, ok := pbm.p2pMod.Handler(message.MsgPropose); ok {
+			handler(&msg)
+		}
 	}
 	time.Sleep(100 * time.Millisecond)
 }
@@ -5,6 +5,7 @@ import (
 	"BlockChainSimulator/message"
 	"BlockChainSimulator/node/nodeattr"
 	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/consensusMod/ds"
 	"BlockChainSimulator/node/runningMod/runningModInterface"
 	"BlockChainSimulator/signature"
 	"BlockChainSimulator/utils"
@@ -20,9 +21,11 @@ type ProposeStringAuxiliaryMod struct {
 	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
 	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
 
-	// for multi-round consensus case
-	stringQueue   *utils.Queue[string] // the queue of requests waiting for consensus
-	consensusDone chan struct{}
+	// for multi-round consensus case, the instances run in a pipeline
+	stringQueue      *utils.Queue[string] // the queue of requests waiting for consensus
+	nextInstance     int                  // the id of the next instance to start
+	runningInstances *utils.Set[int]      // the instances started but not done, at most config.PipelineWindow
+	consensusDone    chan struct{}
 }
 
 // this mod will receive the txs from client and propose them to the shard
@@ -32,6 +35,8 @@ func NewProposeStringAuxiliaryMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runn
 	sam.p2pMod = p2p
 
 	sam.stringQueue = utils.NewQueue[string]()
+	sam.nextInstance = 0
+	sam.runningInstances = utils.NewSet[int]()
 	sam.consensusDone = make(chan struct{}, 1)
 
 	return sam
@@ -52,7 +57,14 @@ func (sam *ProposeStringAuxiliaryMod) handleInject(msg *message.Message) {
 	sam.stringQueue.Enqueue(str)
 }
 
-func (sam *ProposeStringAuxiliaryMod) handleConsensusDone(_ *message.Message) {
+// the content of the message is the id of the finished instance, a sub-protocol may report the same instance again
+func (sam *ProposeStringAuxiliaryMod) handleConsensusDone(msg *message.Message) {
+	instance := 0
+	if msg == nil || utils.Decode(msg.Content, &instance) != nil || !sam.runningInstances.Contains(instance) {
+		return
+	}
+	sam.runningInstances.Remove(instance)
+
 	// non-blocking send to the channel
 	select {
 	case sam.consensusDone <- struct{}{}:
@@ -65,10 +77,15 @@ func (sam *ProposeStringAuxiliaryMod) RegisterHandlers() {
 	sam.p2pMod.RegisterHandler(message.MsgConsensusDone, sam.handleConsensusDone)
 }
 
-// get the txs from the txPool and propose them to the shard
+// propose the strings one instance after another, the next instance starts without waiting for the previous ones to finish
 func (sam *ProposeStringAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	// the consensus starts after the public keys are distributed
+	if !sam.nodeAttr.AwaitPubKeys(ctx) {
+		return
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -84,55 +101,69 @@ func (sam *ProposeStringAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitGrou
 
 			// set the start time
 			startTime := time.Now().Add(time.Duration(config.StartTimeWait) * time.Millisecond)
+			instance := sam.nextInstance
+			sam.nextInstance++
+			sam.runningInstances.Add(instance)
 
 			initMsg := message.Message{
 				MsgType: message.MsgInit,
-				Content: utils.Encode(startTime),
+				Content: utils.Encode(message.InitContent{Sid: sam.nodeAttr.Sid, Instance: instance, StartTime: startTime}),
 			}
-			utils.LoggerInstance.Info("Broadcast the init message")
-			sam.p2pMod.ConnMananger.Broadcast(sam.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], sam.nodeAttr.Ipaddr), initMsg.JsonEncode())
+			utils.LoggerInstance.Info("Broadcast the init message of instance %d", instance)
+			sam.p2pMod.ConnMananger.Broadcast(sam.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[sam.nodeAttr.Sid], sam.nodeAttr.Ipaddr), initMsg.JsonEncode())
 			// alse send to client to sync time
 			sam.p2pMod.ConnMananger.Send(config.ClientAddr, initMsg.JsonEncode())
 
-			sam.p2pMod.MsgHandlerMap[message.MsgInit](&initMsg)
+			if handler, ok := sam.p2pMod.Handler(message.MsgInit); ok {
+				handler(&initMsg)
+			}
 
 			// wait to start the protocol at the startTime
 			startTimer := time.NewTimer(time.Until(startTime))
 			<-startTimer.C
 
-			sig := signature.Sign(sam.nodeAttr.SecKey, []byte(str))
+			// the signature is bound to the shard and the instance, so it can not be replayed in another instance
+			sig := signature.Sign(sam.nodeAttr.SecKey, ds.SignedContent(sam.nodeAttr.Sid, instance, ds.PhasePropose, []byte(str)))
 			req := message.NewRequestWithSignature(sam.nodeAttr.Sid, message.ReqVerifyString, []byte(str), sig)
 
 			proposeMsg := message.Message{
 				MsgType: message.MsgPropose,
-				Content: utils.Encode(req),
+				Content: utils.Encode(message.InstanceRequest{Instance: instance, Req: *req}),
 			}
 
 			if config.IsMalicious {
+				// the malicious view node equivocates, the bad value is correctly signed so that the receivers accept it
+				badSig := signature.Sign(sam.nodeAttr.SecKey, ds.SignedContent(sam.nodeAttr.Sid, instance, ds.PhasePropose, []byte("bad")))
 				badproposeMsg := message.Message{
 					MsgType: message.MsgPropose,
-					Content: utils.Encode(message.NewRequestWithSignature(sam.nodeAttr.Sid, message.ReqVerifyString, []byte("bad"), sig)),
+					Content: utils.Encode(message.InstanceRequest{Instance: instance, Req: *message.NewRequestWithSignature(sam.nodeAttr.Sid, message.ReqVerifyString, []byte("bad"), badSig)}),
 				}
 				utils.LoggerInstance.Info("Broadcast the propose message")
-				// sam.p2pMod.ConnMananger.Broadcast(sam.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], sam.nodeAttr.Ipaddr), proposeMsg.JsonEncode())
-				sam.p2pMod.MsgHandlerMap[message.MsgPropose](&proposeMsg)
+				// sam.p2pMod.ConnMananger.Broadcast(sam.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[sam.nodeAttr.Sid], sam.nodeAttr.Ipaddr), proposeMsg.JsonEncode())
+				if handler, ok := sam.p2pMod.Handler(message.MsgPropose); ok {
+					handler(&proposeMsg)
+				}
 
-				sam.p2pMod.ConnMananger.Send(config.IPMap[0][1], badproposeMsg.JsonEncode())
-				sam.p2pMod.ConnMananger.Send(config.IPMap[0][2], badproposeMsg.JsonEncode())
+				sam.p2pMod.ConnMananger.Send(config.IPMap[sam.nodeAttr.Sid][1], badproposeMsg.JsonEncode())
+				sam.p2pMod.ConnMananger.Send(config.IPMap[sam.nodeAttr.Sid][2], badproposeMsg.JsonEncode())
 			} else {
 				utils.LoggerInstance.Info("Broadcast the propose message")
-				sam.p2pMod.ConnMananger.Broadcast(sam.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], sam.nodeAttr.Ipaddr), proposeMsg.JsonEncode())
-				sam.p2pMod.MsgHandlerMap[message.MsgPropose](&proposeMsg)
+				sam.p2pMod.ConnMananger.Broadcast(sam.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[sam.nodeAttr.Sid], sam.nodeAttr.Ipaddr), proposeMsg.JsonEncode())
+				if handler, ok := sam.p2pMod.Handler(message.MsgPropose); ok {
+					handler(&proposeMsg)
+				}
 			}
-			// wait for the consensus to be done
-			select {
-			case <-sam.consensusDone:
-				// consensus interval, waits for other nodes to complete the consensus
-				// time.Sleep(time.Millisecond * time.Duration(config.ConsensusInterval))
-				utils.LoggerInstance.Info("Consensus is done, go next round")
-			case <-ctx.Done():
-				utils.LoggerInstance.Info("Stop the consensus Mod")
-				return
+			// wait for an instance to be done if the pipeline is full
+			for sam.runningInstances.Size() >= config.PipelineWindow {
+				select {
+				case <-sam.consensusDone:
+					// consensus interval, waits for other nodes to complete the consensus
+					// time.Sleep(time.Millisecond * time.Duration(config.ConsensusInterval))
+					utils.LoggerInstance.Info("Consensus is done, go next round")
+				case <-ctx.Done():
+					utils.LoggerInstance.Info("Stop the consensus Mod")
+					return
+				}
 			}
 		}
 	}
@@ -41,6 +41,12 @@ func (sam *ProposeTxsAuxiliaryMod) RegisterHandlers() {
 // get the txs from the txPool and propose them to the shard
 func (sam *ProposeTxsAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
+
+	// the consensus starts after the public keys are distributed
+	if !sam.nodeAttr.AwaitPubKeys(ctx) {
+		return
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -59,7 +65,9 @@ func (sam *ProposeTxsAuxiliaryMod) Run(ctx context.Context, wg *sync.WaitGroup)
 				MsgType: message.MsgPropose,
 				Content: utils.Encode(req),
 			}
-			sam.p2pMod.MsgHandlerMap[message.MsgPropose](&msg)
+			if handler, ok := sam.p2pMod.Handler(message.MsgPropose); ok {
+				handler(&msg)
+			}
 		}
 	}
 }
@@ -0,0 +1,546 @@
+// This file contains the Sync HotStuff consensus module, a state machine replication protocol in the synchronous model.
+// The synchrony bound Δ is config.TickInterval, the same clock as DS and TBB. The protocol tolerates f < N/2 faults,
+// a node is certified by the votes of a majority. The leader of view v is node v % N, it proposes a node as soon as
+// its previous node is certified, so the steady state runs at the speed of the network. A replica votes for the first
+// proposal of each height, forwards it to all the nodes, and commits the node 2Δ after voting if no equivocation
+// of the leader is detected in the view. The view change is in viewchange.go
+package synchs
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/runningModInterface"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/structs"
+	"BlockChainSimulator/utils"
+	"context"
+	"sync"
+	"time"
+)
+
+// implement the ConsensusMod interface
+var _ runningModInterface.RunningMod = &SyncHotStuffCosensusMod{}
+
+const (
+	SilentStrategy     = "Silent"     // a malicious node neither proposes nor votes, like a crashed node
+	EquivocateStrategy = "Equivocate" // a malicious leader sends two different proposals to the two halves of the replicas
+)
+
+type SyncHotStuffCosensusMod struct {
+	// vars from the belonging node
+	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
+	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
+
+	// sync hotstuff related
+	node_num      int // number of nodes in the network
+	malicious_num int // max number of malicious nodes, less than half of the nodes
+	quorum        int // the votes of a certificate, a majority of the nodes
+	delta         time.Duration
+
+	// consensus related
+	txPool    *structs.TxPool                           // the pending txs, removed only when they are committed
+	nodes     map[[32]byte]*SyncNode                    // all the nodes not committed yet and the last committed one
+	blocks    map[[32]byte]*structs.Block               // the block carried by the node, nil for the heartbeats
+	pending   map[[32]byte][]*ProposalContent           // proposals whose parent has not been received, the key is the hash of the parent
+	votes     map[[32]byte]map[int]*signature.Signature // node hash -> nid -> signature
+	genesis   *SyncNode
+	highCert  *Cert     // the highest certificate known
+	locked    *Cert     // the highest certificate when quitting the last view
+	execNode  *SyncNode // the last committed node
+	synLock   sync.Mutex
+	progress  chan struct{}  // a new proposal arrives in the view, the blame timer is reset
+	certReady chan struct{}  // the node proposed by this leader is certified
+	local     sync.WaitGroup // the messages of this node being handled locally
+
+	// view related, see viewchange.go
+	view         int
+	inView       bool                                 // false after quitting the view, until entering the next one
+	viewStart    time.Time                            // the time this node enters the view
+	proposals    map[int]*ProposalContent             // height -> the first proposal of the height in the view
+	voted        map[int]bool                         // the heights voted in the view
+	equivocated  bool                                 // whether the leader of the view equivocates
+	blameSent    bool                                 // whether this node blames the leader of the view
+	blames       map[int]map[int]*signature.Signature // view -> nid -> the blame
+	future       []*ProposalContent                   // the proposals of the later views, handled after entering the view
+	lastProposed *SyncNode                            // the last node proposed by this node as the leader
+	lastPropTime time.Time
+}
+
+func NewSyncHotStuffCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
+	shsMod := new(SyncHotStuffCosensusMod)
+	shsMod.nodeAttr = attr
+	shsMod.p2pMod = p2p
+
+	shsMod.node_num = config.NodeNum
+	shsMod.malicious_num = (shsMod.node_num - 1) / 2
+	shsMod.quorum = shsMod.node_num/2 + 1
+	shsMod.delta = time.Duration(config.TickInterval) * time.Millisecond
+
+	shsMod.txPool = structs.NewTxPool(config.BlockSize)
+	shsMod.nodes = make(map[[32]byte]*SyncNode)
+	shsMod.blocks = make(map[[32]byte]*structs.Block)
+	shsMod.pending = make(map[[32]byte][]*ProposalContent)
+	shsMod.votes = make(map[[32]byte]map[int]*signature.Signature)
+	shsMod.blames = make(map[int]map[int]*signature.Signature)
+	shsMod.progress = make(chan struct{}, 1)
+	shsMod.certReady = make(chan struct{}, 1)
+
+	// every node starts from the same genesis node, which is certified without signature
+	shsMod.genesis = &SyncNode{View: -1, Height: 0, Req: message.Request{ReqType: message.ReqEmpty}}
+	genesisHash := shsMod.genesis.Hash()
+	shsMod.nodes[genesisHash] = shsMod.genesis
+	shsMod.highCert = &Cert{View: -1, Height: 0, NodeHash: genesisHash}
+	shsMod.locked = shsMod.highCert
+	shsMod.execNode = shsMod.genesis
+
+	shsMod.view = 0
+	shsMod.inView = true
+	shsMod.resetView()
+
+	return shsMod
+}
+
+func (shsMod *SyncHotStuffCosensusMod) isSilent() bool {
+	return config.IsMalicious && config.MaliciousStrategy == SilentStrategy
+}
+
+func (shsMod *SyncHotStuffCosensusMod) leader(view int) int {
+	return view % shsMod.node_num
+}
+
+// receive the txs from the client, every node keeps all the txs so that any leader has work
+func (shsMod *SyncHotStuffCosensusMod) handleInject(msg *message.Message) {
+	txs := []structs.Transaction{}
+	err := utils.Decode(msg.Content, &txs)
+	if err != nil || len(txs) == 0 {
+		utils.LoggerInstance.Error("error decoding the txs")
+		return
+	}
+
+	utils.LoggerInstance.Debug("Receive %d txs", len(txs))
+	shsMod.txPool.AddTxs(txs)
+}
+
+// the proposal of the leader, forwarded by the replicas
+func (shsMod *SyncHotStuffCosensusMod) handleProposal(msg *message.Message) {
+	p := &ProposalContent{}
+	if err := utils.Decode(msg.Content, p); err != nil {
+		utils.LoggerInstance.Error("Error decoding the proposal")
+		return
+	}
+
+	shsMod.synLock.Lock()
+	defer shsMod.synLock.Unlock()
+	shsMod.receiveProposal(p)
+}
+
+// call with synLock held, detect the equivocation, then vote for the proposal if it is the first of its height
+func (shsMod *SyncHotStuffCosensusMod) receiveProposal(p *ProposalContent) {
+	node := &p.Node
+	if node.View < shsMod.view {
+		return
+	}
+	if node.View > shsMod.view {
+		shsMod.future = append(shsMod.future, p)
+		return
+	}
+	nodeHash := node.Hash()
+	if !shsMod.checkSig(shsMod.leader(node.View), nodeHash[:], p.Sig) {
+		utils.LoggerInstance.Warn("The proposal of height %d is not signed by the leader of view %d", node.Height, node.View)
+		return
+	}
+
+	// the leader signs two nodes of the same height, the view is quit with the proof
+	if first, ok := shsMod.proposals[node.Height]; ok {
+		if first.Node.Hash() != nodeHash && !shsMod.equivocated {
+			utils.LoggerInstance.Warn("The leader of view %d equivocates at height %d", node.View, node.Height)
+			shsMod.equivocated = true
+			shsMod.quitView(&QuitContent{View: node.View, Equivocation: []*ProposalContent{first, p}})
+		}
+		return
+	}
+	shsMod.proposals[node.Height] = p
+
+	// forward the proposal, every honest node receives it in Δ
+	if shsMod.leader(node.View) != shsMod.nodeAttr.Nid {
+		shsMod.broadcast(message.MsgSyncProposal, p, false)
+	}
+	shsMod.acceptProposal(p)
+}
+
+// call with synLock held, buffer the proposal if its parent is unknown, otherwise vote for it
+func (shsMod *SyncHotStuffCosensusMod) acceptProposal(p *ProposalContent) {
+	node := &p.Node
+	nodeHash := node.Hash()
+	parent, ok := shsMod.nodes[node.ParentHash]
+	if !ok {
+		utils.LoggerInstance.Debug("Received the node of height %d before its parent, buffer it", node.Height)
+		shsMod.pending[node.ParentHash] = append(shsMod.pending[node.ParentHash], p)
+		return
+	}
+	if node.Height != parent.Height+1 || p.Justify == nil || p.Justify.NodeHash != node.ParentHash || !shsMod.checkCert(p.Justify) {
+		utils.LoggerInstance.Warn("The certificate carried by the node of height %d is not valid", node.Height)
+		return
+	}
+	if !node.IsEmpty() {
+		b := &structs.Block{}
+		if err := utils.Decode(node.Req.Content, b); err != nil {
+			utils.LoggerInstance.Error("Error decoding the block of the node of height %d", node.Height)
+			return
+		}
+		shsMod.blocks[nodeHash] = b
+	}
+	shsMod.nodes[nodeHash] = node
+	shsMod.updateHighCert(p.Justify)
+	select {
+	case shsMod.progress <- struct{}{}:
+	default:
+	}
+
+	// the first proposal of a new view may extend an older node, its certificate must not rank lower than the locked one
+	if shsMod.inView && node.View == shsMod.view && !shsMod.voted[node.Height] && !shsMod.lockedHigher(p.Justify) {
+		shsMod.voted[node.Height] = true
+		shsMod.sendVote(node)
+		view := shsMod.view
+		time.AfterFunc(2*shsMod.delta, func() { shsMod.onCommitTimer(view, nodeHash) })
+	}
+
+	children := shsMod.pending[nodeHash]
+	delete(shsMod.pending, nodeHash)
+	for _, child := range children {
+		shsMod.acceptProposal(child)
+	}
+}
+
+// collect the votes, a majority of them certify the node
+func (shsMod *SyncHotStuffCosensusMod) handleVote(msg *message.Message) {
+	vote := VoteContent{}
+	if err := utils.Decode(msg.Content, &vote); err != nil {
+		utils.LoggerInstance.Error("Error decoding the vote message")
+		return
+	}
+	if vote.NodeId < 0 || vote.NodeId >= shsMod.node_num || !shsMod.checkSig(vote.NodeId, voteDigest(vote.View, vote.NodeHash), vote.Sig) {
+		utils.LoggerInstance.Warn("The signature of the vote from node %d is not valid", vote.NodeId)
+		return
+	}
+
+	shsMod.synLock.Lock()
+	defer shsMod.synLock.Unlock()
+
+	if vote.View < shsMod.view || vote.Height <= shsMod.execNode.Height {
+		return
+	}
+	if shsMod.votes[vote.NodeHash] == nil {
+		shsMod.votes[vote.NodeHash] = make(map[int]*signature.Signature)
+	}
+	shsMod.votes[vote.NodeHash][vote.NodeId] = vote.Sig
+	if len(shsMod.votes[vote.NodeHash]) != shsMod.quorum {
+		return
+	}
+
+	signers := make([]int, 0, shsMod.quorum)
+	sigs := make([]*signature.Signature, 0, shsMod.quorum)
+	for nid, sig := range shsMod.votes[vote.NodeHash] {
+		signers = append(signers, nid)
+		sigs = append(sigs, sig)
+	}
+	aggSig, err := signature.AggregateSignatures(sigs)
+	if err != nil {
+		utils.LoggerInstance.Error("Error aggregating the signatures, err: %v", err)
+		return
+	}
+	cert := &Cert{View: vote.View, Height: vote.Height, NodeHash: vote.NodeHash, Signers: signers, Sig: aggSig}
+	utils.LoggerInstance.Debug("The node of height %d is certified in view %d", cert.Height, cert.View)
+	shsMod.updateHighCert(cert)
+
+	if shsMod.lastProposed != nil && shsMod.lastProposed.Hash() == vote.NodeHash {
+		select {
+		case shsMod.certReady <- struct{}{}: // notify the leader to propose the next node
+		default:
+		}
+	}
+}
+
+// commit the node 2Δ after voting for it, if this node is still in the view and the leader has not equivocated
+func (shsMod *SyncHotStuffCosensusMod) onCommitTimer(view int, nodeHash [32]byte) {
+	shsMod.synLock.Lock()
+	defer shsMod.synLock.Unlock()
+
+	if view != shsMod.view || !shsMod.inView || shsMod.equivocated {
+		return
+	}
+	node, ok := shsMod.nodes[nodeHash]
+	if !ok || node.Height <= shsMod.execNode.Height {
+		return
+	}
+	shsMod.onCommit(node)
+}
+
+// call with synLock held, commit the node and all its uncommitted ancestors in order
+func (shsMod *SyncHotStuffCosensusMod) onCommit(node *SyncNode) {
+	toCommit := make([]*SyncNode, 0)
+	cur := node
+	for ; cur != nil && cur.Height > shsMod.execNode.Height; cur = shsMod.nodes[cur.ParentHash] {
+		toCommit = append(toCommit, cur)
+	}
+	if cur != shsMod.execNode {
+		utils.LoggerInstance.Error("The node of height %d does not extend the committed chain", node.Height)
+		return
+	}
+
+	for i := len(toCommit) - 1; i >= 0; i-- {
+		cur := toCommit[i]
+		b, ok := shsMod.blocks[cur.Hash()]
+		if !ok {
+			continue
+		}
+
+		bc := shsMod.nodeAttr.CurChain
+		bc.StateManager.UpdateStates(b.Transactions, bc.CurrentBlock.Header.StateRoot)
+		bc.CommitBlock(b)
+		shsMod.txPool.RemoveTxs(b.Transactions)
+		utils.LoggerInstance.Info("Commit the block of node of height %d in view %d with %d txs", cur.Height, cur.View, len(b.Transactions))
+
+		if shsMod.nodeAttr.Nid == config.ViewNodeId {
+			shsMod.sendReply(&cur.Req)
+		}
+	}
+	shsMod.execNode = node
+
+	// the nodes at or below the committed height will never be visited again except the newest one
+	for hash, cur := range shsMod.nodes {
+		if cur.Height <= node.Height && cur != node {
+			delete(shsMod.nodes, hash)
+			delete(shsMod.blocks, hash)
+			delete(shsMod.votes, hash)
+		}
+	}
+}
+
+// call with synLock held, broadcast the vote to all the nodes
+func (shsMod *SyncHotStuffCosensusMod) sendVote(node *SyncNode) {
+	nodeHash := node.Hash()
+	vote := &VoteContent{
+		View:     node.View,
+		Height:   node.Height,
+		NodeHash: nodeHash,
+		NodeId:   shsMod.nodeAttr.Nid,
+		Sig:      signature.Sign(shsMod.nodeAttr.SecKey, voteDigest(node.View, nodeHash)),
+	}
+	shsMod.broadcast(message.MsgVote, vote, true)
+}
+
+// send the committed block back to the client, so that the measure mod can work.
+// The request time is the time the node is proposed, so TCL is the latency of the commit
+func (shsMod *SyncHotStuffCosensusMod) sendReply(req *message.Request) {
+	reply := &message.Reply{
+		Req:  req,
+		Time: time.Now(),
+
+		Sid:         shsMod.nodeAttr.Sid,
+		ReqQueueLen: shsMod.txPool.Size(),
+	}
+
+	replymsg := message.Message{
+		MsgType: message.MsgReply,
+		Content: utils.Encode(reply),
+	}
+	go shsMod.p2pMod.ConnMananger.Send(config.ClientAddr, replymsg.JsonEncode())
+}
+
+// send the message to the other nodes of the shard, and handle it locally if toSelf
+func (shsMod *SyncHotStuffCosensusMod) broadcast(msgType message.MessageType, content interface{}, toSelf bool) {
+	msg := message.Message{
+		MsgType: msgType,
+		Content: utils.Encode(content),
+	}
+	shsMod.p2pMod.ConnMananger.Broadcast(shsMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[shsMod.nodeAttr.Sid], shsMod.nodeAttr.Ipaddr), msg.JsonEncode())
+	if !toSelf {
+		return
+	}
+	if handler, ok := shsMod.p2pMod.Handler(msgType); ok {
+		shsMod.local.Add(1)
+		go func() {
+			defer shsMod.local.Done()
+			handler(&msg)
+		}()
+	}
+}
+
+// call with synLock held
+func (shsMod *SyncHotStuffCosensusMod) updateHighCert(cert *Cert) {
+	if cert.Higher(shsMod.highCert) {
+		shsMod.highCert = cert
+	}
+}
+
+// call with synLock held, the txs of the uncommitted ancestors of the node are not proposed again
+func (shsMod *SyncHotStuffCosensusMod) selectTxs(parent *SyncNode) []structs.Transaction {
+	onChain := make(map[string]bool)
+	for cur := parent; cur != nil && cur.Height > shsMod.execNode.Height; cur = shsMod.nodes[cur.ParentHash] {
+		if b, ok := shsMod.blocks[cur.Hash()]; ok {
+			for _, tx := range b.Transactions {
+				onChain[string(tx.Hash())] = true
+			}
+		}
+	}
+
+	txs := make([]structs.Transaction, 0)
+	for _, tx := range shsMod.txPool.PeekTxs(config.BlockSize + len(onChain)) {
+		if len(txs) >= config.BlockSize {
+			break
+		}
+		if !onChain[string(tx.Hash())] {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// call with synLock held, the leader extends the node of the highest certificate.
+// The leader proposes once its last node is certified, and proposes a heartbeat every Δ when there is no tx
+func (shsMod *SyncHotStuffCosensusMod) tryPropose() {
+	if !shsMod.inView || shsMod.leader(shsMod.view) != shsMod.nodeAttr.Nid {
+		return
+	}
+	// the leader of a new view waits 2Δ for the status of the replicas
+	if shsMod.view > 0 && time.Since(shsMod.viewStart) < 2*shsMod.delta {
+		return
+	}
+	if shsMod.lastProposed != nil && shsMod.lastProposed.Hash() != shsMod.highCert.NodeHash {
+		return
+	}
+	parent, ok := shsMod.nodes[shsMod.highCert.NodeHash]
+	if !ok {
+		utils.LoggerInstance.Warn("The node of the highest certificate is unknown, can not propose")
+		return
+	}
+	txs := shsMod.selectTxs(parent)
+	if len(txs) == 0 && time.Since(shsMod.lastPropTime) < shsMod.delta {
+		return
+	}
+
+	req := message.Request{ShardId: shsMod.nodeAttr.Sid, ReqType: message.ReqEmpty, ReqTime: time.Now()}
+	if len(txs) > 0 {
+		req = *message.NewRequest(shsMod.nodeAttr.Sid, message.ReqVerifyTxs, utils.Encode(shsMod.nodeAttr.CurChain.NewBlock(txs)))
+	}
+	node := &SyncNode{View: shsMod.view, Height: parent.Height + 1, ParentHash: shsMod.highCert.NodeHash, Req: req}
+	shsMod.lastProposed = node
+	shsMod.lastPropTime = time.Now()
+
+	if config.IsMalicious && config.MaliciousStrategy == EquivocateStrategy {
+		shsMod.proposeEquivocation(node)
+		return
+	}
+	nodeHash := node.Hash()
+	p := &ProposalContent{Node: *node, Justify: shsMod.highCert, Sig: signature.Sign(shsMod.nodeAttr.SecKey, nodeHash[:])}
+	utils.LoggerInstance.Info("Propose the node of height %d in view %d with %d txs", node.Height, node.View, len(txs))
+	shsMod.broadcast(message.MsgSyncProposal, p, true)
+}
+
+// call with synLock held, the malicious leader sends the node to the first half of the replicas and a heartbeat to the others
+func (shsMod *SyncHotStuffCosensusMod) proposeEquivocation(node *SyncNode) {
+	conflict := *node
+	conflict.Req = message.Request{ShardId: shsMod.nodeAttr.Sid, ReqType: message.ReqEmpty, ReqTime: time.Now()}
+	addrs := utils.GetNeighbours(config.IPMap[shsMod.nodeAttr.Sid], shsMod.nodeAttr.Ipaddr)
+	for i, n := range []*SyncNode{node, &conflict} {
+		nodeHash := n.Hash()
+		p := &ProposalContent{Node: *n, Justify: shsMod.highCert, Sig: signature.Sign(shsMod.nodeAttr.SecKey, nodeHash[:])}
+		msg := message.Message{MsgType: message.MsgSyncProposal, Content: utils.Encode(p)}
+		half := addrs[:len(addrs)/2]
+		if i == 1 {
+			half = addrs[len(addrs)/2:]
+		}
+		shsMod.p2pMod.ConnMananger.Broadcast(shsMod.nodeAttr.Ipaddr, half, msg.JsonEncode())
+	}
+	utils.LoggerInstance.Info("Send two proposals of height %d in view %d", node.Height, node.View)
+}
+
+// check the signature of a node of the shard, the unknown nodes and keys are rejected
+func (shsMod *SyncHotStuffCosensusMod) checkSig(nid int, msg []byte, sig *signature.Signature) bool {
+	return shsMod.nodeAttr.VerifySig(shsMod.nodeAttr.Sid, nid, msg, sig)
+}
+
+// check the aggregate signature of a certificate, the genesis certificate has no signature
+func (shsMod *SyncHotStuffCosensusMod) checkCert(cert *Cert) bool {
+	if cert.Sig == nil {
+		return cert.View == -1 && cert.NodeHash == shsMod.genesis.Hash()
+	}
+	return shsMod.checkAggregate(cert.Signers, shsMod.quorum, voteDigest(cert.View, cert.NodeHash), cert.Sig)
+}
+
+// check the aggregate signature of at least `least` distinct signers
+func (shsMod *SyncHotStuffCosensusMod) checkAggregate(signers []int, least int, msg []byte, sig *signature.Signature) bool {
+	return len(signers) >= least && shsMod.nodeAttr.VerifyAggregatedSig(shsMod.nodeAttr.Sid, signers, msg, sig) == nil
+}
+
+// a silent node drops all the messages
+func (shsMod *SyncHotStuffCosensusMod) handleSilent(msg *message.Message) {}
+
+// call in node.go according to the current implementation, you can also call this function in the New() function
+func (shsMod *SyncHotStuffCosensusMod) RegisterHandlers() {
+	if shsMod.isSilent() {
+		for _, msgType := range []message.MessageType{message.MsgInject, message.MsgSyncProposal, message.MsgVote, message.MsgViewChange, message.MsgQuitView, message.MsgStatus} {
+			shsMod.p2pMod.RegisterHandler(msgType, shsMod.handleSilent)
+		}
+		return
+	}
+	shsMod.p2pMod.RegisterHandler(message.MsgInject, shsMod.handleInject)
+	shsMod.p2pMod.RegisterHandler(message.MsgSyncProposal, shsMod.handleProposal)
+	shsMod.p2pMod.RegisterHandler(message.MsgVote, shsMod.handleVote)
+	shsMod.p2pMod.RegisterHandler(message.MsgViewChange, shsMod.handleBlame)
+	shsMod.p2pMod.RegisterHandler(message.MsgQuitView, shsMod.handleQuitView)
+	shsMod.p2pMod.RegisterHandler(message.MsgStatus, shsMod.handleStatus)
+}
+
+// Run drives the clock of the node: the leader proposes when its last node is certified or every Δ,
+// and every node blames the leader if no new proposal arrives in config.SyncHSBlameTimeout Δs
+func (shsMod *SyncHotStuffCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if shsMod.isSilent() {
+		utils.LoggerInstance.Info("This node is silent, do not run Sync HotStuff")
+		return
+	}
+
+	// the consensus starts after the public keys are distributed, the first view starts config.StartTimeWait later
+	if !shsMod.nodeAttr.AwaitPubKeys(ctx) {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(config.StartTimeWait) * time.Millisecond):
+	}
+
+	utils.LoggerInstance.Info("Start the Sync HotStuff consensus Mod, Δ is %v", shsMod.delta)
+	ticker := time.NewTicker(shsMod.delta)
+	defer ticker.Stop()
+	blameTimeout := time.Duration(config.SyncHSBlameTimeout) * shsMod.delta
+	blameTimer := time.NewTimer(blameTimeout)
+	defer blameTimer.Stop()
+	for {
+		shsMod.synLock.Lock()
+		shsMod.tryPropose()
+		shsMod.synLock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			utils.LoggerInstance.Info("Stop the Sync HotStuff consensus Mod")
+			return
+		case <-shsMod.certReady:
+		case <-ticker.C:
+		case <-shsMod.progress:
+			if !blameTimer.Stop() {
+				<-blameTimer.C
+			}
+			blameTimer.Reset(blameTimeout)
+		case <-blameTimer.C:
+			shsMod.synLock.Lock()
+			shsMod.blame()
+			shsMod.synLock.Unlock()
+			blameTimer.Reset(blameTimeout)
+		}
+	}
+}
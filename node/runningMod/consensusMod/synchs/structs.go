@@ -0,0 +1,106 @@
+package synchs
+
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"crypto/sha256"
+)
+
+// a block in the Sync HotStuff chain, each node carries one request(a block in this implementation)
+type SyncNode struct {
+	View       int             // the view the node is proposed in
+	Height     int             // the height of the node in the chain
+	ParentHash [32]byte        // the hash of the parent node
+	Req        message.Request // the request proposed in this node, ReqEmpty means a heartbeat of the idle leader
+}
+
+// the certificate of a node, the aggregated signature of the votes of a majority in a view
+type Cert struct {
+	View     int // the view of the votes, the certificates are ranked by the view first
+	Height   int // the height of the certified node
+	NodeHash [32]byte
+	Signers  []int
+	Sig      *signature.Signature // the aggregate signature of the votes, nil for the genesis certificate
+}
+
+// the proposal of the leader, forwarded by every replica so that the equivocation is detected in Δ
+type ProposalContent struct {
+	Node    SyncNode
+	Justify *Cert                // the certificate of the parent
+	Sig     *signature.Signature // the signature of the leader on the node hash
+}
+
+// the vote of a replica, broadcast to all the nodes
+type VoteContent struct {
+	View     int
+	Height   int
+	NodeHash [32]byte
+	NodeId   int
+	Sig      *signature.Signature
+}
+
+// a replica blames the leader of the view for the lack of progress
+type BlameContent struct {
+	View   int
+	NodeId int
+	Sig    *signature.Signature
+}
+
+// the proof to quit a view, either f+1 blames or two proposals of the same height signed by the leader
+type QuitContent struct {
+	View         int
+	Signers      []int
+	Sig          *signature.Signature // the aggregate signature of the blames
+	Equivocation []*ProposalContent
+}
+
+// a replica sends its highest certificate to the leader of the new view
+type StatusContent struct {
+	View    int // the new view
+	NodeId  int
+	Highest *Cert
+}
+
+// the hash of the node, signed by the leader
+func (node *SyncNode) Hash() [32]byte {
+	content := struct {
+		View       int
+		Height     int
+		ParentHash [32]byte
+		Digest     [32]byte
+	}{node.View, node.Height, node.ParentHash, node.Req.Digest}
+	return sha256.Sum256(utils.CanonicalEncode(content))
+}
+
+// whether the node is a heartbeat of the idle leader, it is committed but not executed
+func (node *SyncNode) IsEmpty() bool {
+	return node.Req.ReqType == message.ReqEmpty
+}
+
+// whether the certificate ranks higher than the other one
+func (cert *Cert) Higher(other *Cert) bool {
+	if cert.View != other.View {
+		return cert.View > other.View
+	}
+	return cert.Height > other.Height
+}
+
+// the signed message of a vote
+func voteDigest(view int, nodeHash [32]byte) []byte {
+	digest := sha256.Sum256(utils.CanonicalEncode(struct {
+		Type     string
+		View     int
+		NodeHash [32]byte
+	}{"vote", view, nodeHash}))
+	return digest[:]
+}
+
+// the signed message of a blame
+func blameDigest(view int) []byte {
+	digest := sha256.Sum256(utils.CanonicalEncode(struct {
+		Type string
+		View int
+	}{"blame", view}))
+	return digest[:]
+}
@@ -0,0 +1,126 @@
+package synchs
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the mod of replica 1 in a shard of 4 nodes whose keys are all known, the leader of view 0 is node 0.
+// The test waits for the own messages handled locally before the next mod overwrites the config
+func newTestMod(t *testing.T) (*SyncHotStuffCosensusMod, []*signature.SecretKey) {
+	config.ViewNodeId, config.IsMalicious = 0, false
+	config.TickInterval = 3600 * 1000 // the timers never fire in the tests
+	config.ShardNum, config.NodeNum = 1, 4
+	attr, sks := testutil.NewAttr(t, 0, 1, 4)
+	shsMod := NewSyncHotStuffCosensusMod(attr, p2p.NewP2PMod("")).(*SyncHotStuffCosensusMod)
+	shsMod.RegisterHandlers()
+	t.Cleanup(shsMod.local.Wait)
+	return shsMod, sks
+}
+
+// the certificate of the node signed by the voters
+func certify(sks []*signature.SecretKey, view int, height int, nodeHash [32]byte, voters ...int) *Cert {
+	sigs := make([]*signature.Signature, 0, len(voters))
+	for _, nid := range voters {
+		sigs = append(sigs, signature.Sign(sks[nid], voteDigest(view, nodeHash)))
+	}
+	aggSig, _ := signature.AggregateSignatures(sigs)
+	return &Cert{View: view, Height: height, NodeHash: nodeHash, Signers: voters, Sig: aggSig}
+}
+
+// a heartbeat proposal extending the parent, signed by the signer
+func proposal(sks []*signature.SecretKey, signer int, view int, parent *SyncNode, justify *Cert, tag string) *message.Message {
+	node := SyncNode{View: view, Height: parent.Height + 1, ParentHash: parent.Hash(), Req: message.Request{ReqType: message.ReqEmpty}}
+	node.Req.Digest[0] = tag[0] // the heartbeats of different tags have different hashes
+	nodeHash := node.Hash()
+	p := &ProposalContent{Node: node, Justify: justify, Sig: signature.Sign(sks[signer], nodeHash[:])}
+	return &message.Message{MsgType: message.MsgSyncProposal, Content: utils.Encode(p)}
+}
+
+func TestCheckCert(t *testing.T) {
+	shsMod, sks := newTestMod(t)
+	genesisHash := shsMod.genesis.Hash()
+	assert.True(t, shsMod.checkCert(shsMod.highCert))
+	assert.False(t, shsMod.checkCert(&Cert{View: 0, Height: 1, NodeHash: [32]byte{1}}), "only the genesis certificate has no signature")
+
+	nodeHash := [32]byte{1}
+	assert.True(t, shsMod.checkCert(certify(sks, 0, 1, nodeHash, 0, 1, 2)))
+	assert.False(t, shsMod.checkCert(certify(sks, 0, 1, nodeHash, 0, 1)), "less than a majority")
+	assert.False(t, shsMod.checkCert(certify(sks, 0, 1, nodeHash, 0, 0, 0)), "a voter repeated")
+
+	// the certificate cannot claim a higher view to rank above the lock
+	cert := certify(sks, 0, 1, nodeHash, 0, 1, 2)
+	cert.View = 5
+	assert.False(t, shsMod.checkCert(cert))
+	cert = certify(sks, 0, 1, nodeHash, 0, 1, 2)
+	cert.NodeHash = genesisHash
+	assert.False(t, shsMod.checkCert(cert), "the certificate of another node")
+}
+
+func TestProposalSignedByLeader(t *testing.T) {
+	shsMod, sks := newTestMod(t)
+	shsMod.handleProposal(proposal(sks, 2, 0, shsMod.genesis, shsMod.highCert, "a"))
+	assert.Empty(t, shsMod.proposals, "node 2 is not the leader of view 0")
+
+	shsMod.handleProposal(proposal(sks, 0, 0, shsMod.genesis, shsMod.highCert, "a"))
+	assert.Len(t, shsMod.proposals, 1)
+	assert.True(t, shsMod.voted[1])
+}
+
+// two proposals of the same height signed by the leader make the replica quit the view, with a valid proof
+func TestEquivocationQuitsView(t *testing.T) {
+	shsMod, sks := newTestMod(t)
+	shsMod.handleProposal(proposal(sks, 0, 0, shsMod.genesis, shsMod.highCert, "a"))
+	require.True(t, shsMod.inView)
+
+	shsMod.handleProposal(proposal(sks, 0, 0, shsMod.genesis, shsMod.highCert, "b"))
+	assert.True(t, shsMod.equivocated)
+	assert.False(t, shsMod.inView)
+
+	// the proof is checked by the other replicas
+	a := decodeProposal(t, proposal(sks, 0, 0, shsMod.genesis, shsMod.highCert, "a"))
+	b := decodeProposal(t, proposal(sks, 0, 0, shsMod.genesis, shsMod.highCert, "b"))
+	assert.True(t, shsMod.checkQuit(&QuitContent{View: 0, Equivocation: []*ProposalContent{a, b}}))
+	assert.False(t, shsMod.checkQuit(&QuitContent{View: 0, Equivocation: []*ProposalContent{a, a}}), "the same proposal twice")
+	forged := decodeProposal(t, proposal(sks, 2, 0, shsMod.genesis, shsMod.highCert, "b"))
+	assert.False(t, shsMod.checkQuit(&QuitContent{View: 0, Equivocation: []*ProposalContent{a, forged}}), "not signed by the leader")
+	assert.False(t, shsMod.checkQuit(&QuitContent{View: 1, Equivocation: []*ProposalContent{a, b}}), "the proposals of another view")
+}
+
+// a proposal whose certificate is forged is neither stored nor voted, and its certificate is not adopted
+func TestForgedJustifyRejected(t *testing.T) {
+	for _, valid := range []bool{false, true} {
+		t.Run(fmt.Sprintf("valid=%v", valid), func(t *testing.T) {
+			shsMod, sks := newTestMod(t)
+			first := proposal(sks, 0, 0, shsMod.genesis, shsMod.highCert, "a")
+			shsMod.handleProposal(first)
+			parent := &decodeProposal(t, first).Node
+
+			justify := certify(sks, 0, 1, parent.Hash(), 0, 0, 0)
+			if valid {
+				justify = certify(sks, 0, 1, parent.Hash(), 0, 1, 2)
+			}
+			shsMod.handleProposal(proposal(sks, 0, 0, parent, justify, "b"))
+			shsMod.synLock.Lock()
+			assert.Equal(t, valid, shsMod.voted[2])
+			assert.Equal(t, valid, len(shsMod.nodes) == 3, "the genesis node, the parent and the child")
+			assert.Equal(t, valid, shsMod.highCert.Height == 1)
+			shsMod.synLock.Unlock()
+		})
+	}
+}
+
+func decodeProposal(t *testing.T, msg *message.Message) *ProposalContent {
+	p := &ProposalContent{}
+	require.NoError(t, utils.Decode(msg.Content, p))
+	return p
+}
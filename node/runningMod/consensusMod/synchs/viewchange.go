@@ -0,0 +1,212 @@
+// This file contains the view change of Sync HotStuff.
+// A replica blames the leader if no new proposal arrives in config.SyncHSBlameTimeout Δs. f+1 blames, or two proposals
+// of the same height signed by the leader, prove the leader faulty: a replica broadcasts the proof and quits the view,
+// so every honest replica quits in Δ. It stops voting, and the commit timers of the view are cancelled.
+// After waiting 2Δ it has received every certificate an honest replica could have committed on, it locks on its
+// highest certificate, sends it to the new leader and enters the next view. The new leader waits another 2Δ for the
+// statuses and extends the highest certificate, the replicas only vote for a proposal not ranking below their locks
+package synchs
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"time"
+)
+
+// call with synLock held, clear the state of the previous view
+func (shsMod *SyncHotStuffCosensusMod) resetView() {
+	shsMod.viewStart = time.Now()
+	shsMod.proposals = make(map[int]*ProposalContent)
+	shsMod.voted = make(map[int]bool)
+	shsMod.equivocated = false
+	shsMod.blameSent = false
+	shsMod.lastProposed = nil
+	shsMod.lastPropTime = time.Time{}
+	for view := range shsMod.blames {
+		if view < shsMod.view {
+			delete(shsMod.blames, view)
+		}
+	}
+}
+
+// call with synLock held, whether the lock ranks higher than the certificate
+func (shsMod *SyncHotStuffCosensusMod) lockedHigher(cert *Cert) bool {
+	return shsMod.locked.Higher(cert)
+}
+
+// call with synLock held, blame the leader of the current view once
+func (shsMod *SyncHotStuffCosensusMod) blame() {
+	if !shsMod.inView || shsMod.blameSent {
+		return
+	}
+	shsMod.blameSent = true
+	utils.LoggerInstance.Warn("No progress in view %d, blame the leader %d", shsMod.view, shsMod.leader(shsMod.view))
+	b := &BlameContent{
+		View:   shsMod.view,
+		NodeId: shsMod.nodeAttr.Nid,
+		Sig:    signature.Sign(shsMod.nodeAttr.SecKey, blameDigest(shsMod.view)),
+	}
+	shsMod.broadcast(message.MsgViewChange, b, true)
+}
+
+// collect the blames, f+1 of them form the proof to quit the view
+func (shsMod *SyncHotStuffCosensusMod) handleBlame(msg *message.Message) {
+	b := BlameContent{}
+	if err := utils.Decode(msg.Content, &b); err != nil {
+		utils.LoggerInstance.Error("Error decoding the blame message")
+		return
+	}
+	if b.NodeId < 0 || b.NodeId >= shsMod.node_num || !shsMod.checkSig(b.NodeId, blameDigest(b.View), b.Sig) {
+		utils.LoggerInstance.Warn("The signature of the blame from node %d is not valid", b.NodeId)
+		return
+	}
+
+	shsMod.synLock.Lock()
+	defer shsMod.synLock.Unlock()
+
+	if b.View < shsMod.view {
+		return
+	}
+	if shsMod.blames[b.View] == nil {
+		shsMod.blames[b.View] = make(map[int]*signature.Signature)
+	}
+	shsMod.blames[b.View][b.NodeId] = b.Sig
+	if len(shsMod.blames[b.View]) != shsMod.malicious_num+1 {
+		return
+	}
+
+	signers := make([]int, 0, len(shsMod.blames[b.View]))
+	sigs := make([]*signature.Signature, 0, len(shsMod.blames[b.View]))
+	for nid, sig := range shsMod.blames[b.View] {
+		signers = append(signers, nid)
+		sigs = append(sigs, sig)
+	}
+	aggSig, err := signature.AggregateSignatures(sigs)
+	if err != nil {
+		utils.LoggerInstance.Error("Error aggregating the signatures, err: %v", err)
+		return
+	}
+	shsMod.onQuitProof(&QuitContent{View: b.View, Signers: signers, Sig: aggSig})
+}
+
+// the proof of another replica quitting the view
+func (shsMod *SyncHotStuffCosensusMod) handleQuitView(msg *message.Message) {
+	q := QuitContent{}
+	if err := utils.Decode(msg.Content, &q); err != nil {
+		utils.LoggerInstance.Error("Error decoding the quit view message")
+		return
+	}
+	if !shsMod.checkQuit(&q) {
+		utils.LoggerInstance.Warn("The proof to quit view %d is not valid", q.View)
+		return
+	}
+
+	shsMod.synLock.Lock()
+	defer shsMod.synLock.Unlock()
+	shsMod.onQuitProof(&q)
+}
+
+// the proof is f+1 blames of the view, or two proposals of the same height in the view signed by the leader
+func (shsMod *SyncHotStuffCosensusMod) checkQuit(q *QuitContent) bool {
+	if len(q.Equivocation) == 0 {
+		return shsMod.checkAggregate(q.Signers, shsMod.malicious_num+1, blameDigest(q.View), q.Sig)
+	}
+	if len(q.Equivocation) != 2 || q.Equivocation[0] == nil || q.Equivocation[1] == nil {
+		return false
+	}
+	n0, n1 := &q.Equivocation[0].Node, &q.Equivocation[1].Node
+	h0, h1 := n0.Hash(), n1.Hash()
+	return n0.View == q.View && n1.View == q.View && n0.Height == n1.Height && h0 != h1 &&
+		shsMod.checkSig(shsMod.leader(q.View), h0[:], q.Equivocation[0].Sig) &&
+		shsMod.checkSig(shsMod.leader(q.View), h1[:], q.Equivocation[1].Sig)
+}
+
+// call with synLock held, a node behind catches up to the view of the proof before quitting it
+func (shsMod *SyncHotStuffCosensusMod) onQuitProof(q *QuitContent) {
+	if q.View < shsMod.view || (q.View == shsMod.view && !shsMod.inView) {
+		return
+	}
+	if q.View > shsMod.view {
+		utils.LoggerInstance.Info("Catch up from view %d to view %d", shsMod.view, q.View)
+		shsMod.view = q.View
+		shsMod.inView = true
+		shsMod.resetView()
+	}
+	if len(q.Equivocation) > 0 {
+		shsMod.equivocated = true
+	}
+	shsMod.quitView(q)
+}
+
+// call with synLock held, forward the proof and quit the view, enter the next view 2Δ later
+func (shsMod *SyncHotStuffCosensusMod) quitView(q *QuitContent) {
+	if !shsMod.inView {
+		return
+	}
+	shsMod.inView = false
+	utils.LoggerInstance.Info("Quit view %d", q.View)
+	shsMod.broadcast(message.MsgQuitView, q, false)
+
+	time.AfterFunc(2*shsMod.delta, func() {
+		shsMod.synLock.Lock()
+		defer shsMod.synLock.Unlock()
+		if shsMod.view != q.View || shsMod.inView {
+			return
+		}
+
+		shsMod.locked = shsMod.highCert
+		status := &StatusContent{View: q.View + 1, NodeId: shsMod.nodeAttr.Nid, Highest: shsMod.highCert}
+		next := shsMod.leader(q.View + 1)
+		if next != shsMod.nodeAttr.Nid {
+			smsg := message.Message{MsgType: message.MsgStatus, Content: utils.Encode(status)}
+			go shsMod.p2pMod.ConnMananger.Send(config.IPMap[shsMod.nodeAttr.Sid][next], smsg.JsonEncode())
+		}
+		shsMod.enterView(q.View + 1)
+	})
+}
+
+// call with synLock held
+func (shsMod *SyncHotStuffCosensusMod) enterView(view int) {
+	shsMod.view = view
+	shsMod.inView = true
+	shsMod.resetView()
+	utils.LoggerInstance.Info("Enter view %d, the leader is node %d, locked on height %d of view %d", view, shsMod.leader(view), shsMod.locked.Height, shsMod.locked.View)
+	select {
+	case shsMod.progress <- struct{}{}:
+	default:
+	}
+
+	future := shsMod.future
+	shsMod.future = nil
+	for _, p := range future {
+		shsMod.receiveProposal(p)
+	}
+}
+
+// the new leader learns the highest certificate of the replica
+func (shsMod *SyncHotStuffCosensusMod) handleStatus(msg *message.Message) {
+	status := StatusContent{}
+	if err := utils.Decode(msg.Content, &status); err != nil {
+		utils.LoggerInstance.Error("Error decoding the status message")
+		return
+	}
+	if status.Highest == nil || !shsMod.checkCert(status.Highest) {
+		utils.LoggerInstance.Warn("The certificate in the status of node %d is not valid", status.NodeId)
+		return
+	}
+
+	shsMod.synLock.Lock()
+	defer shsMod.synLock.Unlock()
+
+	if status.View < shsMod.view {
+		return
+	}
+	// the node of the certificate was forwarded to every honest node before the view was quit
+	if _, ok := shsMod.nodes[status.Highest.NodeHash]; !ok {
+		utils.LoggerInstance.Warn("The node of the certificate in the status of node %d is unknown", status.NodeId)
+		return
+	}
+	shsMod.updateHighCert(status.Highest)
+}
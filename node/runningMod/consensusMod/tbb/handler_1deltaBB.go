@@ -5,10 +5,13 @@ import (
 	"BlockChainSimulator/message"
 	"BlockChainSimulator/node/nodeattr"
 	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/consensusMod/ds"
 	"BlockChainSimulator/node/runningMod/runningModInterface"
 	"BlockChainSimulator/signature"
 	"BlockChainSimulator/utils"
 	"context"
+	"log"
+	"sort"
 	"sync"
 	"time"
 )
@@ -21,20 +24,34 @@ type _1Delta_BBConsensusMod struct {
 	nodeAttr *nodeattr.NodeAttr // the attribute of the belonging node
 	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
 	// consensus related
-	view int // the nid of current view number
-	// malicious     bool // whether this node is malicious, does not implement this feature
-	startTime *utils.AtomicValue[time.Time]
+	view      int          // the nid of current view number
+	adversary ds.Adversary // how the malicious node sends the messages, nil for the honest node
 
-	voteMap map[string]*utils.Set[signature.Signature] // the map of votes, the key is the content of the vote, and the value is the signature of the vote
+	// the instances may overlap, each instance has its own state, which is removed when the instance finishes
+	instances    map[int]*dbbInstance // the running instances, key is the instance id
+	finished     *utils.Set[int]      // the finished instances, the late messages of them are ignored
+	instanceLock sync.Mutex
+
+	nonHonestMajority *utils.Set[int] // the running instances in which conflicting QCs are observed
+	forwarding        sync.WaitGroup  // the evidences of the non-honest majority being forwarded
+}
+
+// the state of an instance of the 1Δ-BB* protocol
+type dbbInstance struct {
+	startTime time.Time
+	lock      sync.Mutex // protects the maps below
+
+	voteMap map[string]map[int]*signature.Signature // the map of votes, the key is the content of the vote, and the value is the signatures of the voters
 
 	// local sets
 	QCMap      map[string]*signature.Signature // set '\phi' in the paper, used by 1Δ-BB* protocol
+	QCSigners  map[string][]int                // the signers of the QCs in QCMap
 	proposeSet *utils.Set[string]              // the propose received
 	BlckSet    *utils.Set[string]              // set 'blck' in the paper, used by 1Δ-BB* protocol
 
 	// BADS* protocol
-	BAproposeMap map[string]*utils.Set[signature.Signature] // the map used to generate aggregate signature
-	BABlckSet    *utils.Set[string]                         // set 'A' in the paper, used by BADS* protocol embedded in 1Δ-BB*
+	BAproposeMap map[string]map[int]*signature.Signature // the map used to generate aggregate signature
+	BABlckSet    *utils.Set[string]                      // set 'A' in the paper, used by BADS* protocol embedded in 1Δ-BB*
 
 	CommitPoint1Trigger chan struct{} // the trigger to commit the point 1
 	isCommit            bool          // whether the node has committed the value
@@ -42,14 +59,17 @@ type _1Delta_BBConsensusMod struct {
 }
 
 type QCContent struct {
-	Content string // use string because []byte is not comparable
-	Sig     *signature.Signature
+	Instance int
+	Content  string               // use string because []byte is not comparable
+	Sig      *signature.Signature // the aggregate signature of the votes
+	Signers  []int                // the voters
 }
 
 type VoteContent struct {
-	NodeId  int
-	Content []byte
-	Sig     *signature.Signature
+	Instance int
+	NodeId   int
+	Content  []byte
+	Sig      *signature.Signature
 }
 
 func New_1Delta_BBConsensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
@@ -57,72 +77,127 @@ func New_1Delta_BBConsensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) running
 	_1dbbMod.nodeAttr = attr
 	_1dbbMod.p2pMod = p2p
 
-	_1dbbMod.view = 0 // set 0 as the default view
+	_1dbbMod.view = config.ViewNodeId // every shard runs its own protocol, led by its view node
 
-	_1dbbMod.startTime = utils.NewAtomicValue(time.Now())
+	_1dbbMod.instances = make(map[int]*dbbInstance)
+	_1dbbMod.finished = utils.NewSet[int]()
+	_1dbbMod.nonHonestMajority = utils.NewSet[int]()
 
-	_1dbbMod.voteMap = make(map[string]*utils.Set[signature.Signature])
+	if config.IsMalicious {
+		adversary, err := ds.NewAdversary(config.MaliciousStrategy, attr, p2p)
+		if err != nil {
+			utils.LoggerInstance.Error("Error creating the adversary: %v", err)
+			log.Panicf("Error creating the adversary: %v", err)
+		}
+		_1dbbMod.adversary = adversary
+	}
+
+	return _1dbbMod
+}
+
+// the number of votes to make a QC, and the number of signers of the aggregate signature in BADS*
+func quorum() int {
+	maliciousNum := int(config.MaliciousRatio * float64(config.NodeNum))
+	return config.NodeNum - maliciousNum
+}
 
-	_1dbbMod.QCMap = make(map[string]*signature.Signature)
-	_1dbbMod.proposeSet = utils.NewSet[string]()
-	_1dbbMod.BlckSet = utils.NewSet[string]()
+// aggregate the signatures of the nodes, the signers are returned in ascending order
+func aggregate(sigs map[int]*signature.Signature) (*signature.Signature, []int, error) {
+	signers := make([]int, 0, len(sigs))
+	for nid := range sigs {
+		signers = append(signers, nid)
+	}
+	sort.Ints(signers)
+	sigList := make([]*signature.Signature, 0, len(signers))
+	for _, nid := range signers {
+		sigList = append(sigList, sigs[nid])
+	}
+	aggSig, err := signature.AggregateSignatures(sigList)
+	return aggSig, signers, err
+}
 
-	_1dbbMod.BAproposeMap = make(map[string]*utils.Set[signature.Signature])
-	_1dbbMod.BABlckSet = utils.NewSet[string]()
+// get the running instance, nil if the instance is not started or has finished
+func (_1dbbMod *_1Delta_BBConsensusMod) getInstance(id int) *dbbInstance {
+	_1dbbMod.instanceLock.Lock()
+	defer _1dbbMod.instanceLock.Unlock()
+	return _1dbbMod.instances[id]
+}
 
-	_1dbbMod.CommitPoint1Trigger = make(chan struct{}, 1)
-	_1dbbMod.isCommit = false
-	_1dbbMod.CommitValue = ""
+// get the QCs of the instance, a copy is returned because the map may be modified by the handlers
+func (_1dbbMod *_1Delta_BBConsensusMod) getQCMap(id int) map[string]*signature.Signature {
+	qcMap := make(map[string]*signature.Signature)
+	instance := _1dbbMod.getInstance(id)
+	if instance == nil {
+		return qcMap
+	}
+	instance.lock.Lock()
+	defer instance.lock.Unlock()
+	for value, sig := range instance.QCMap {
+		qcMap[value] = sig
+	}
+	return qcMap
+}
 
-	return _1dbbMod
+func (_1dbbMod *_1Delta_BBConsensusMod) finishInstance(id int) {
+	_1dbbMod.instanceLock.Lock()
+	defer _1dbbMod.instanceLock.Unlock()
+	delete(_1dbbMod.instances, id)
+	_1dbbMod.finished.Add(id)
+	_1dbbMod.nonHonestMajority.Remove(id)
 }
 
 // Initially, every node pi starts the protocol at the same time T= 0, initializes the evidenceset i 0and bick_.
 func (_1dbbMod *_1Delta_BBConsensusMod) HandleInitMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received an init message")
 
-	startTime := time.Time{}
-	err := utils.Decode(msg.Content, &startTime)
+	initContent := message.InitContent{}
+	err := utils.Decode(msg.Content, &initContent)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the init message, err: %v", err)
 		return
 	}
+	id := initContent.Instance
 
-	// set the start time of the protocol
-	_1dbbMod.startTime.Set(startTime, nil)
-	utils.LoggerInstance.Info("Set the start time of the protocol to %v", _1dbbMod.startTime.Get())
-
-	// clear the local sets
-	_1dbbMod.voteMap = make(map[string]*utils.Set[signature.Signature])
-	_1dbbMod.QCMap = make(map[string]*signature.Signature)
-	_1dbbMod.proposeSet.Clear()
-	_1dbbMod.BlckSet.Clear()
-
-	_1dbbMod.BAproposeMap = make(map[string]*utils.Set[signature.Signature])
-	_1dbbMod.BABlckSet.Clear()
-
-	_1dbbMod.CommitPoint1Trigger = make(chan struct{}, 1)
-	_1dbbMod.isCommit = false
-	_1dbbMod.CommitValue = ""
+	_1dbbMod.instanceLock.Lock()
+	if _, exists := _1dbbMod.instances[id]; exists || _1dbbMod.finished.Contains(id) {
+		_1dbbMod.instanceLock.Unlock()
+		utils.LoggerInstance.Warn("The instance %d is already started", id)
+		return
+	}
+	instance := &dbbInstance{
+		startTime:           initContent.StartTime,
+		voteMap:             make(map[string]map[int]*signature.Signature),
+		QCMap:               make(map[string]*signature.Signature),
+		QCSigners:           make(map[string][]int),
+		proposeSet:          utils.NewSet[string](),
+		BlckSet:             utils.NewSet[string](),
+		BAproposeMap:        make(map[string]map[int]*signature.Signature),
+		BABlckSet:           utils.NewSet[string](),
+		CommitPoint1Trigger: make(chan struct{}, 1),
+	}
+	_1dbbMod.instances[id] = instance
+	_1dbbMod.instanceLock.Unlock()
+	utils.LoggerInstance.Info("Set the start time of the instance %d to %v", id, instance.startTime)
 
 	// wait until 4 delta to go BA protocol
-	BAStartTime := _1dbbMod.startTime.Get().Add(time.Duration(4*config.TickInterval) * time.Millisecond)
+	BAStartTime := instance.startTime.Add(time.Duration(4*config.TickInterval) * time.Millisecond)
 	BAStartTimer := time.NewTimer(time.Until(BAStartTime))
 	utils.LoggerInstance.Debug("Set the BA start time to %v", BAStartTime)
 	go func() {
 		<-BAStartTimer.C
 
 		var input []byte
-		if _1dbbMod.BlckSet.Size() == 1 {
-			input = []byte(_1dbbMod.BlckSet.GetItems()[0])
+		if instance.BlckSet.Size() == 1 {
+			input = []byte(instance.BlckSet.GetItems()[0])
 		} else {
 			input = []byte("0")
 		}
 
 		// broadcast the SigListContent(type of Forward2)
 		sigListContent := SigListContent{
+			Instance: id,
 			Input:    input,
-			SigList:  []*signature.Signature{signature.Sign(_1dbbMod.nodeAttr.SecKey, input)},
+			SigList:  []*signature.Signature{signature.Sign(_1dbbMod.nodeAttr.SecKey, _1dbbMod.signedContent(id, ds.PhaseBA, input))},
 			NodeList: []int{_1dbbMod.nodeAttr.Nid},
 		}
 
@@ -130,50 +205,64 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleInitMsg(msg *message.Message) {
 			MsgType: message.MsgForward2,
 			Content: utils.Encode(sigListContent),
 		}
-		if _1dbbMod.BAproposeMap[string(sigListContent.Input)] == nil {
-			_1dbbMod.BAproposeMap[string(sigListContent.Input)] = utils.NewSet[signature.Signature]()
+		instance.lock.Lock()
+		if instance.BAproposeMap[string(sigListContent.Input)] == nil {
+			instance.BAproposeMap[string(sigListContent.Input)] = make(map[int]*signature.Signature)
 		}
-		_1dbbMod.BAproposeMap[string(sigListContent.Input)].Add(*sigListContent.SigList[0])
+		instance.BAproposeMap[string(sigListContent.Input)][_1dbbMod.nodeAttr.Nid] = sigListContent.SigList[0]
+		instance.lock.Unlock()
 		utils.LoggerInstance.Info("Broadcast the start forward message of BADS*")
-		_1dbbMod.p2pMod.ConnMananger.Broadcast(_1dbbMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], _1dbbMod.nodeAttr.Ipaddr), forwardMsg.JsonEncode())
+		_1dbbMod.broadcast(&forwardMsg, instance.startTime)
 	}()
 
 	// wait until f + 6 round to final commit
 	maliciousNodes := int64(config.MaliciousRatio * float64(config.NodeNum))
 	delayDuration := (maliciousNodes + 6) * config.TickInterval * int64(time.Millisecond)
-	commitTime := _1dbbMod.startTime.Get().Add(time.Duration(delayDuration))
+	commitTime := instance.startTime.Add(time.Duration(delayDuration))
 	commitTimer := time.NewTimer(time.Until(commitTime))
 	utils.LoggerInstance.Debug("Set final the commit time to %v", commitTime)
 	go func() {
 		<-commitTimer.C
-		if _1dbbMod.isCommit {
+		instance.lock.Lock()
+		defer instance.lock.Unlock()
+		if instance.isCommit {
 			utils.LoggerInstance.Info("The final commit time f+6 is up, but the node has already committed the value")
 		} else {
-			utils.LoggerInstance.Info("Commit the request")
+			utils.LoggerInstance.Info("Commit the request of instance %d", id)
 
 			// other commit operations
 
-			for _, item := range _1dbbMod.BABlckSet.GetItems() {
+			for _, item := range instance.BABlckSet.GetItems() {
 				utils.LoggerInstance.Info("The value in BABlckSet are: %v", item)
 			}
 
-			if _1dbbMod.BABlckSet.Size() == 1 {
-				utils.LoggerInstance.Info("The value to commit is: %v", _1dbbMod.BABlckSet.GetItems()[0])
-				_1dbbMod.CommitValue = _1dbbMod.BABlckSet.GetItems()[0]
+			if instance.BABlckSet.Size() == 1 {
+				utils.LoggerInstance.Info("The value to commit is: %v", instance.BABlckSet.GetItems()[0])
+				instance.CommitValue = instance.BABlckSet.GetItems()[0]
 			} else {
-				utils.LoggerInstance.Warn("The BABlckSet size is %d, The value to commit is 0", _1dbbMod.BABlckSet.Size())
-				_1dbbMod.CommitValue = "0"
+				utils.LoggerInstance.Warn("The BABlckSet size is %d, The value to commit is 0", instance.BABlckSet.Size())
+				instance.CommitValue = "0"
 			}
 		}
 	}()
 
+	// discard the state of the instance when it finishes
+	time.AfterFunc(time.Until(instance.startTime.Add(ds.InstanceLifetime())), func() {
+		_1dbbMod.finishInstance(id)
+	})
+
 	// if view node, wait n+7 round to start the next consensus
 	if _1dbbMod.nodeAttr.Nid == _1dbbMod.view {
-		consensusDoneTimer := time.NewTimer(time.Duration(config.TickInterval*int64(config.NodeNum+7)) * time.Millisecond)
+		consensusDoneTimer := time.NewTimer(ds.InstanceLifetime())
 		go func() {
 			<-consensusDoneTimer.C
-			utils.LoggerInstance.Info("The consensus is done, start the next round")
-			_1dbbMod.p2pMod.MsgHandlerMap[message.MsgConsensusDone](nil)
+			utils.LoggerInstance.Info("The consensus of instance %d is done, start the next round", id)
+			if handler, ok := _1dbbMod.p2pMod.Handler(message.MsgConsensusDone); ok {
+				handler(&message.Message{
+					MsgType: message.MsgConsensusDone,
+					Content: utils.Encode(id),
+				})
+			}
 		}()
 	}
 }
@@ -182,48 +271,59 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleInitMsg(msg *message.Message) {
 func (_1dbbMod *_1Delta_BBConsensusMod) HandleProposeMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received a propose message")
 
-	req := message.Request{}
-	err := utils.Decode(msg.Content, &req)
+	instanceReq := message.InstanceRequest{}
+	err := utils.Decode(msg.Content, &instanceReq)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the propose message")
 		return
 	}
+	req := instanceReq.Req
 
-	if _1dbbMod.checkSig(req.Sig) {
-		_1dbbMod.proposeSet.Add(string(req.Content))
+	instance := _1dbbMod.getInstance(instanceReq.Instance)
+	if instance == nil {
+		utils.LoggerInstance.Warn("The instance %d is not running, ignore the propose message", instanceReq.Instance)
+		return
+	}
+
+	if ds.VerifySig(_1dbbMod.nodeAttr, _1dbbMod.view, _1dbbMod.signedContent(instanceReq.Instance, ds.PhasePropose, req.Content), req.Sig) {
+		instance.proposeSet.Add(string(req.Content))
 
 		// view node does not need to forward the message
 		if _1dbbMod.nodeAttr.Nid != _1dbbMod.view {
 			forwardMsg := message.Message{
 				MsgType: message.MsgForward1,
-				Content: utils.Encode(req),
+				Content: utils.Encode(instanceReq),
 			}
 			utils.LoggerInstance.Info("Broadcast the forward message of 1Δ-BB*")
-			_1dbbMod.p2pMod.ConnMananger.Broadcast(_1dbbMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], _1dbbMod.nodeAttr.Ipaddr), forwardMsg.JsonEncode())
+			_1dbbMod.broadcast(&forwardMsg, instance.startTime)
 		}
 
 		voteTimer := time.NewTimer(time.Duration(config.TickInterval) * time.Millisecond)
 		go func() {
 			<-voteTimer.C
 			utils.LoggerInstance.Info("The vote time is up, try to vote")
-			if _1dbbMod.proposeSet.Size() == 1 {
-				sig := signature.Sign(_1dbbMod.nodeAttr.SecKey, []byte(_1dbbMod.proposeSet.GetItems()[0]))
+			if instance.proposeSet.Size() == 1 {
+				value := []byte(instance.proposeSet.GetItems()[0])
+				sig := signature.Sign(_1dbbMod.nodeAttr.SecKey, _1dbbMod.signedContent(instanceReq.Instance, ds.PhaseVote, value))
 				voteContent := VoteContent{
-					NodeId:  _1dbbMod.nodeAttr.Nid,
-					Content: []byte(_1dbbMod.proposeSet.GetItems()[0]),
-					Sig:     sig,
+					Instance: instanceReq.Instance,
+					NodeId:   _1dbbMod.nodeAttr.Nid,
+					Content:  value,
+					Sig:      sig,
 				}
 				voteMsg := message.Message{
 					MsgType: message.MsgVote,
 					Content: utils.Encode(voteContent),
 				}
 
-				if _1dbbMod.voteMap[string(voteContent.Content)] == nil {
-					_1dbbMod.voteMap[string(voteContent.Content)] = utils.NewSet[signature.Signature]()
+				instance.lock.Lock()
+				if instance.voteMap[string(voteContent.Content)] == nil {
+					instance.voteMap[string(voteContent.Content)] = make(map[int]*signature.Signature)
 				}
-				_1dbbMod.voteMap[string(voteContent.Content)].Add(*sig)
+				instance.voteMap[string(voteContent.Content)][voteContent.NodeId] = sig
+				instance.lock.Unlock()
 				utils.LoggerInstance.Info("Broadcast the vote message")
-				_1dbbMod.p2pMod.ConnMananger.Broadcast(_1dbbMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], _1dbbMod.nodeAttr.Ipaddr), voteMsg.JsonEncode())
+				_1dbbMod.broadcast(&voteMsg, instance.startTime)
 			}
 		}()
 	} else {
@@ -236,19 +336,26 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleProposeMsg(msg *message.Message) {
 func (_1dbbMod *_1Delta_BBConsensusMod) HandleForward1Msg(msg *message.Message) {
 	utils.LoggerInstance.Debug("Received a forward message")
 
-	req := message.Request{}
-	err := utils.Decode(msg.Content, &req)
+	instanceReq := message.InstanceRequest{}
+	err := utils.Decode(msg.Content, &instanceReq)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the forward message")
 		return
 	}
+	req := instanceReq.Req
+
+	instance := _1dbbMod.getInstance(instanceReq.Instance)
+	if instance == nil {
+		utils.LoggerInstance.Warn("The instance %d is not running, ignore the forward message", instanceReq.Instance)
+		return
+	}
 
-	if _1dbbMod.checkSig(req.Sig) {
-		if _1dbbMod.proposeSet.Contains(string(req.Content)) {
+	if ds.VerifySig(_1dbbMod.nodeAttr, _1dbbMod.view, _1dbbMod.signedContent(instanceReq.Instance, ds.PhasePropose, req.Content), req.Sig) {
+		if instance.proposeSet.Contains(string(req.Content)) {
 			utils.LoggerInstance.Info("The content of the forward message is already in the propose set")
 			return
 		}
-		_1dbbMod.proposeSet.Add(string(req.Content))
+		instance.proposeSet.Add(string(req.Content))
 		utils.LoggerInstance.Info("The content of the forward message is added to the propose set")
 	} else {
 		utils.LoggerInstance.Warn("The signature of the forward message is not valid")
@@ -265,35 +372,47 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleVoteMsg(msg *message.Message) {
 		utils.LoggerInstance.Error("Error decoding the vote message")
 		return
 	}
-	if _1dbbMod.checkSig(voteContent.Sig) {
-		if _1dbbMod.voteMap[string(voteContent.Content)] == nil {
-			_1dbbMod.voteMap[string(voteContent.Content)] = utils.NewSet[signature.Signature]()
+
+	instance := _1dbbMod.getInstance(voteContent.Instance)
+	if instance == nil {
+		utils.LoggerInstance.Warn("The instance %d is not running, ignore the vote message", voteContent.Instance)
+		return
+	}
+
+	if voteContent.NodeId >= 0 && voteContent.NodeId < config.NodeNum && ds.VerifySig(_1dbbMod.nodeAttr, voteContent.NodeId, _1dbbMod.signedContent(voteContent.Instance, ds.PhaseVote, voteContent.Content), voteContent.Sig) {
+		instance.lock.Lock()
+		if instance.voteMap[string(voteContent.Content)] == nil {
+			instance.voteMap[string(voteContent.Content)] = make(map[int]*signature.Signature)
 		}
-		_1dbbMod.voteMap[string(voteContent.Content)].Add(*voteContent.Sig)
-		maliciousNum := int(config.MaliciousRatio * float64(config.NodeNum))
-		if _1dbbMod.voteMap[string(voteContent.Content)].Size() == config.NodeNum-maliciousNum {
-			if _1dbbMod.QCMap[string(voteContent.Content)] != nil { // already have the qc
+		// the votes are counted by the voters, the repeated votes of a node are ignored
+		instance.voteMap[string(voteContent.Content)][voteContent.NodeId] = voteContent.Sig
+		if len(instance.voteMap[string(voteContent.Content)]) == quorum() {
+			if instance.QCMap[string(voteContent.Content)] != nil { // already have the qc
+				instance.lock.Unlock()
 				return
 			}
 			utils.LoggerInstance.Info("Get enough votes of %v to make QC", string(voteContent.Content))
-			aggSig, err := signature.AggregateSignatures(_1dbbMod.voteMap[string(voteContent.Content)].GetItemRefs())
+			aggSig, signers, err := aggregate(instance.voteMap[string(voteContent.Content)])
 			if err != nil {
+				instance.lock.Unlock()
 				utils.LoggerInstance.Error("Error aggregating the signatures, err: %v", err)
 				return
 			}
 			qc := QCContent{
-				Content: string(voteContent.Content),
-				Sig:     aggSig,
+				Instance: voteContent.Instance,
+				Content:  string(voteContent.Content),
+				Sig:      aggSig,
+				Signers:  signers,
 			}
-			_1dbbMod.QCMap[qc.Content] = qc.Sig
-			_1dbbMod.BlckSet.Add(qc.Content)
+			_1dbbMod.addQC(instance, &qc)
 
-			timeFromStart := time.Since(_1dbbMod.startTime.Get())
-			if _1dbbMod.BlckSet.Size() == 1 && timeFromStart < time.Duration(3*config.TickInterval)*time.Millisecond {
+			timeFromStart := time.Since(instance.startTime)
+			if instance.BlckSet.Size() == 1 && timeFromStart < time.Duration(3*config.TickInterval)*time.Millisecond {
 				utils.LoggerInstance.Info("Commit the value %v at commit point 1 in 1Δ-BB* protocol", string(voteContent.Content))
-				_1dbbMod.CommitValue = string(voteContent.Content)
-				_1dbbMod.isCommit = true
-				_1dbbMod.CommitPoint1Trigger <- struct{}{}
+				instance.CommitValue = string(voteContent.Content)
+				instance.isCommit = true
+				instance.CommitPoint1Trigger <- struct{}{}
+				instance.lock.Unlock()
 
 				// broadcast the qc
 				qcMsg := message.Message{
@@ -301,9 +420,11 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleVoteMsg(msg *message.Message) {
 					Content: utils.Encode(qc),
 				}
 				utils.LoggerInstance.Info("Broadcast the qc message")
-				_1dbbMod.p2pMod.ConnMananger.Broadcast(_1dbbMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], _1dbbMod.nodeAttr.Ipaddr), qcMsg.JsonEncode())
+				_1dbbMod.broadcast(&qcMsg, instance.startTime)
+				return
 			}
 		}
+		instance.lock.Unlock()
 	} else {
 		utils.LoggerInstance.Warn("The signature of the vote message is not valid")
 		return
@@ -318,12 +439,74 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleQCMsg(msg *message.Message) {
 		utils.LoggerInstance.Error("Error decoding the qc message")
 		return
 	}
-	if _1dbbMod.checkSig(qc.Sig) {
-		// Non-Honest Maiority Detection in 1Δ-BB* protocol
-		// not implemented yet
+
+	// the detection window is the lifetime of the instance
+	instance := _1dbbMod.getInstance(qc.Instance)
+	if instance == nil {
+		utils.LoggerInstance.Warn("The instance %d is not running, ignore the qc message", qc.Instance)
+		return
+	}
+
+	if _1dbbMod.checkQC(&qc) {
+		instance.lock.Lock()
+		_1dbbMod.addQC(instance, &qc)
+		instance.lock.Unlock()
+	} else {
+		utils.LoggerInstance.Warn("The signature of the qc message is not valid")
 	}
 }
 
+// add the QC to the instance, called with instance.lock held.
+// Non-Honest Majority Detection: the honest nodes never vote for two values, so two QCs of different values
+// in the same instance prove that more than (n - quorum) nodes are malicious
+func (_1dbbMod *_1Delta_BBConsensusMod) addQC(instance *dbbInstance, qc *QCContent) {
+	if _, exists := instance.QCMap[qc.Content]; exists {
+		return
+	}
+	instance.QCMap[qc.Content] = qc.Sig
+	instance.QCSigners[qc.Content] = qc.Signers
+	instance.BlckSet.Add(qc.Content)
+
+	if len(instance.QCMap) > 1 && _1dbbMod.markNonHonestMajority(qc.Instance) {
+		values := make([]string, 0, len(instance.QCMap))
+		evidence := make([]message.Message, 0, len(instance.QCMap))
+		for value, sig := range instance.QCMap {
+			values = append(values, value)
+			evidence = append(evidence, message.Message{
+				MsgType: message.MsgQC,
+				Content: utils.Encode(QCContent{Instance: qc.Instance, Content: value, Sig: sig, Signers: instance.QCSigners[value]}),
+			})
+		}
+		utils.LoggerInstance.Warn("Detect non-honest majority in instance %d, conflicting QCs of %v", qc.Instance, values)
+
+		// forward the conflicting QCs as the evidence, so that every honest node detects it
+		_1dbbMod.forwarding.Add(1)
+		go func() {
+			defer _1dbbMod.forwarding.Done()
+			for i := range evidence {
+				_1dbbMod.broadcast(&evidence[i], instance.startTime)
+			}
+		}()
+	}
+}
+
+// record the non-honest majority of the running instance, false if it is already recorded or the instance has finished,
+// so the record is removed together with the instance
+func (_1dbbMod *_1Delta_BBConsensusMod) markNonHonestMajority(id int) bool {
+	_1dbbMod.instanceLock.Lock()
+	defer _1dbbMod.instanceLock.Unlock()
+	if _, running := _1dbbMod.instances[id]; !running || _1dbbMod.nonHonestMajority.Contains(id) {
+		return false
+	}
+	_1dbbMod.nonHonestMajority.Add(id)
+	return true
+}
+
+// whether conflicting QCs are observed in the instance
+func (_1dbbMod *_1Delta_BBConsensusMod) isNonHonestMajority(id int) bool {
+	return _1dbbMod.nonHonestMajority.Contains(id)
+}
+
 // BADS* protocol
 func (_1dbbMod *_1Delta_BBConsensusMod) HandleForward2Msg(msg *message.Message) {
 	utils.LoggerInstance.Debug("Received a forward message of BADS*")
@@ -335,75 +518,68 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleForward2Msg(msg *message.Message)
 		return
 	}
 
-	if _1dbbMod.checkSigList(sigListContent.SigList) {
-		// get the round of the protocol
-		timeFromStart := time.Since(_1dbbMod.startTime.Get())
+	instance := _1dbbMod.getInstance(sigListContent.Instance)
+	if instance == nil {
+		utils.LoggerInstance.Warn("The instance %d is not running, ignore the forward message of BADS*", sigListContent.Instance)
+		return
+	}
+
+	// get the round of the protocol
+	timeFromStart := time.Since(instance.startTime)
 
+	if _1dbbMod.checkSigList(&sigListContent, timeFromStart) {
 		// time < 5Δ, try to generate the aggregate signature
 		if timeFromStart < time.Duration(5*config.TickInterval)*time.Millisecond {
-			if _1dbbMod.BAproposeMap[string(sigListContent.Input)] == nil {
-				_1dbbMod.BAproposeMap[string(sigListContent.Input)] = utils.NewSet[signature.Signature]()
+			instance.lock.Lock()
+			defer instance.lock.Unlock()
+			if instance.BAproposeMap[string(sigListContent.Input)] == nil {
+				instance.BAproposeMap[string(sigListContent.Input)] = make(map[int]*signature.Signature)
 			}
-			_1dbbMod.BAproposeMap[string(sigListContent.Input)].Add(*sigListContent.SigList[0])
-			maliciousNum := int(config.MaliciousRatio * float64(config.NodeNum))
-			if _1dbbMod.BAproposeMap[string(sigListContent.Input)].Size() == config.NodeNum-maliciousNum {
+			instance.BAproposeMap[string(sigListContent.Input)][sigListContent.NodeList[0]] = sigListContent.SigList[0]
+			if len(instance.BAproposeMap[string(sigListContent.Input)]) == quorum() {
 				utils.LoggerInstance.Info("Get enough \"propose\" of BADS* to make aggSig on %v", string(sigListContent.Input))
-				aggSig, err := signature.AggregateSignatures(_1dbbMod.BAproposeMap[string(sigListContent.Input)].GetItemRefs())
+				aggSig, signers, err := aggregate(instance.BAproposeMap[string(sigListContent.Input)])
 				if err != nil {
 					utils.LoggerInstance.Error("Error aggregating the signatures, err: %v", err)
 					return
 				}
 
 				// wait until 6Δ to broadcast the aggregate signature
-				forwardTimer := time.NewTimer(time.Until(_1dbbMod.startTime.Get().Add(time.Duration(6*config.TickInterval) * time.Millisecond)))
+				forwardTimer := time.NewTimer(time.Until(instance.startTime.Add(time.Duration(6*config.TickInterval) * time.Millisecond)))
 				go func() {
 					<-forwardTimer.C
 					BAForwardMsg := message.Message{
 						MsgType: message.MsgForward2,
 						Content: utils.Encode(SigListContent{
-							Input:    sigListContent.Input,
-							SigList:  []*signature.Signature{aggSig},
-							NodeList: []int{-1}, // -1 means the signature is aggregate signature
+							Instance:   sigListContent.Instance,
+							Input:      sigListContent.Input,
+							SigList:    []*signature.Signature{aggSig},
+							NodeList:   []int{ds.AggregateSigner},
+							AggSigners: signers,
 						}),
 					}
 					utils.LoggerInstance.Info("Broadcast the forward message of BADS* with aggSig")
-					_1dbbMod.p2pMod.ConnMananger.Broadcast(_1dbbMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], _1dbbMod.nodeAttr.Ipaddr), BAForwardMsg.JsonEncode())
+					_1dbbMod.broadcast(&BAForwardMsg, instance.startTime)
 				}()
 			}
 		} else {
 			// time > 5Δ, same to DS protocol
-			if _1dbbMod.BABlckSet.Contains(string(sigListContent.Input)) {
+			if instance.BABlckSet.Contains(string(sigListContent.Input)) {
 				return
 			} else {
-				sigLen := len(sigListContent.SigList)
-				round := int(timeFromStart.Milliseconds()/config.TickInterval) - 5
-
-				// check if the length of the signature list is equal to the round number
-				if sigLen != round && sigLen != round+1 {
-					utils.LoggerInstance.Warn("The length of the signature list %d is not equal to the round number %d", sigLen, round)
-					// return
-				}
-
-				_1dbbMod.BABlckSet.Add(string(sigListContent.Input))
+				instance.BABlckSet.Add(string(sigListContent.Input))
 				utils.LoggerInstance.Info("The content of the forward message is added to the blck set")
 
-				// wait until round==sigLen and broadcast Forward message
-				for {
-					if time.Since(_1dbbMod.startTime.Get()).Milliseconds()/config.TickInterval-5 < int64(sigLen) {
-						break
-					}
-					time.Sleep(time.Millisecond * 100)
-				}
-
+				// forward right away, the chain with the own signature is long enough for the next round
 				// add signature and forward the message
-				sigListContent.SigList = append(sigListContent.SigList, signature.Sign(_1dbbMod.nodeAttr.SecKey, sigListContent.Input))
+				sigListContent.SigList = append(sigListContent.SigList, signature.Sign(_1dbbMod.nodeAttr.SecKey, _1dbbMod.signedContent(sigListContent.Instance, ds.PhaseBA, sigListContent.Input)))
 				sigListContent.NodeList = append(sigListContent.NodeList, _1dbbMod.nodeAttr.Nid)
 
 				BAForwardMsg := message.Message{
 					MsgType: message.MsgForward2,
 					Content: utils.Encode(sigListContent),
 				}
-				_1dbbMod.p2pMod.ConnMananger.Broadcast(_1dbbMod.nodeAttr.Ipaddr, utils.GetNeighbours(config.IPMap[0], _1dbbMod.nodeAttr.Ipaddr), BAForwardMsg.JsonEncode())
+				_1dbbMod.broadcast(&BAForwardMsg, instance.startTime)
 				utils.LoggerInstance.Info("Broadcast the forward message of BADS* with own signature")
 			}
 		}
@@ -413,6 +589,16 @@ func (_1dbbMod *_1Delta_BBConsensusMod) HandleForward2Msg(msg *message.Message)
 	}
 }
 
+// broadcast the message of the instance started at startTime, the message of the malicious node is sent by its adversary
+func (_1dbbMod *_1Delta_BBConsensusMod) broadcast(msg *message.Message, startTime time.Time) {
+	receivers := utils.GetNeighbours(config.IPMap[_1dbbMod.nodeAttr.Sid], _1dbbMod.nodeAttr.Ipaddr)
+	if _1dbbMod.adversary != nil {
+		_1dbbMod.adversary.Send(msg, receivers, startTime)
+		return
+	}
+	_1dbbMod.p2pMod.ConnMananger.Broadcast(_1dbbMod.nodeAttr.Ipaddr, receivers, msg.JsonEncode())
+}
+
 func (_1dbbMod *_1Delta_BBConsensusMod) RegisterHandlers() {
 	_1dbbMod.p2pMod.RegisterHandler(message.MsgInit, _1dbbMod.HandleInitMsg)
 	_1dbbMod.p2pMod.RegisterHandler(message.MsgPropose, _1dbbMod.HandleProposeMsg)
@@ -428,13 +614,55 @@ func (_1dbbMod *_1Delta_BBConsensusMod) Run(ctx context.Context, wg *sync.WaitGr
 	// do nothing, all the operations are triggered by the messages
 }
 
-// check the signature of SigListContent
-// TODO: implement this function
-// 需要前置完成密钥广播模块，尚未完成
-func (_1dbbMod *_1Delta_BBConsensusMod) checkSigList([]*signature.Signature) bool {
+// the content signed by the nodes on the value in the phase of the instance
+func (_1dbbMod *_1Delta_BBConsensusMod) signedContent(instance int, phase string, value []byte) []byte {
+	return ds.SignedContent(_1dbbMod.nodeAttr.Sid, instance, phase, value)
+}
+
+// check the signature list of BADS*.
+// Before 5Δ, it is the single signature of a node on its input.
+// After 5Δ, it is a chain starting with the aggregate signature of a quorum, which is sent at 6Δ(round 1),
+// and every round adds at least one signature, so a chain received in round r carries at least r signatures
+func (_1dbbMod *_1Delta_BBConsensusMod) checkSigList(sigListContent *SigListContent, timeFromStart time.Duration) bool {
+	sigLen := len(sigListContent.SigList)
+	if sigLen == 0 || len(sigListContent.NodeList) != sigLen {
+		utils.LoggerInstance.Warn("The signature list has %d signatures with %d signers", sigLen, len(sigListContent.NodeList))
+		return false
+	}
+
+	if timeFromStart < time.Duration(5*config.TickInterval)*time.Millisecond {
+		if sigLen != 1 || sigListContent.NodeList[0] == ds.AggregateSigner {
+			utils.LoggerInstance.Warn("The \"propose\" of BADS* should carry a single signature")
+			return false
+		}
+	} else {
+		round := int(timeFromStart.Milliseconds()/config.TickInterval) - 5
+		if sigListContent.NodeList[0] != ds.AggregateSigner || len(sigListContent.AggSigners) < quorum() {
+			utils.LoggerInstance.Warn("The signature list of BADS* should start with the aggregate signature of a quorum")
+			return false
+		}
+		if sigLen < round {
+			utils.LoggerInstance.Warn("The signature list of length %d is too short for the round %d", sigLen, round)
+			return false
+		}
+	}
+
+	if err := ds.VerifySigChain(_1dbbMod.nodeAttr, _1dbbMod.signedContent(sigListContent.Instance, ds.PhaseBA, sigListContent.Input), sigListContent.SigList, sigListContent.NodeList, sigListContent.AggSigners); err != nil {
+		utils.LoggerInstance.Warn("Invalid signature list: %v", err)
+		return false
+	}
 	return true
 }
 
-func (_1dbbMod *_1Delta_BBConsensusMod) checkSig(*signature.Signature) bool {
+// check the QC, it is the aggregate signature of a quorum of the voters
+func (_1dbbMod *_1Delta_BBConsensusMod) checkQC(qc *QCContent) bool {
+	if len(qc.Signers) < quorum() {
+		utils.LoggerInstance.Warn("The QC is signed by %d nodes, less than the quorum %d", len(qc.Signers), quorum())
+		return false
+	}
+	if err := ds.VerifyAggregatedSig(_1dbbMod.nodeAttr, qc.Signers, _1dbbMod.signedContent(qc.Instance, ds.PhaseVote, []byte(qc.Content)), qc.Sig); err != nil {
+		utils.LoggerInstance.Warn("Invalid QC: %v", err)
+		return false
+	}
 	return true
 }
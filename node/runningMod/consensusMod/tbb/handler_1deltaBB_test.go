@@ -0,0 +1,159 @@
+package tbb
+
+import (
+	"BlockChainSimulator/config"
+	"BlockChainSimulator/internal/testutil"
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/consensusMod/ds"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the 1Δ-BB* mod of node 0 in a shard of 4 nodes tolerating 2 malicious ones, so a QC needs 2 votes.
+// The test waits for the forwarded evidences before the next mod overwrites the config
+func newTestMod(t *testing.T) (*_1Delta_BBConsensusMod, []*signature.SecretKey) {
+	config.MaliciousRatio, config.IsMalicious = 0.5, false
+	config.ShardNum, config.NodeNum = 1, 4
+	attr, sks := testutil.NewAttr(t, 0, 0, 4)
+	mod := New_1Delta_BBConsensusMod(attr, p2p.NewP2PMod("")).(*_1Delta_BBConsensusMod)
+	t.Cleanup(mod.forwarding.Wait)
+	return mod, sks
+}
+
+// an instance started long ago, so no commit point is reached by the votes
+func startInstance(mod *_1Delta_BBConsensusMod, id int) *dbbInstance {
+	instance := &dbbInstance{
+		startTime:           time.Now().Add(-time.Hour),
+		voteMap:             make(map[string]map[int]*signature.Signature),
+		QCMap:               make(map[string]*signature.Signature),
+		QCSigners:           make(map[string][]int),
+		proposeSet:          utils.NewSet[string](),
+		BlckSet:             utils.NewSet[string](),
+		BAproposeMap:        make(map[string]map[int]*signature.Signature),
+		BABlckSet:           utils.NewSet[string](),
+		CommitPoint1Trigger: make(chan struct{}, 1),
+	}
+	mod.instances[id] = instance
+	return instance
+}
+
+func voteMsg(sks []*signature.SecretKey, nid int, instance int, value string) *message.Message {
+	vote := VoteContent{
+		Instance: instance,
+		NodeId:   nid,
+		Content:  []byte(value),
+		Sig:      signature.Sign(sks[nid], ds.SignedContent(0, instance, ds.PhaseVote, []byte(value))),
+	}
+	return &message.Message{MsgType: message.MsgVote, Content: utils.Encode(vote)}
+}
+
+// the DoubleVote adversary makes two QCs with the help of another malicious node, which every honest node detects
+func TestDoubleVoteDetected(t *testing.T) {
+	mod, sks := newTestMod(t)
+	instance := startInstance(mod, 1)
+	adv := &doubleVoteAdversary{nodeAttr: &nodeattr.NodeAttr{Sid: 0, Nid: 3, SecKey: sks[3]}}
+
+	mod.HandleVoteMsg(voteMsg(sks, 0, 1, "value"))
+	mod.HandleVoteMsg(voteMsg(sks, 3, 1, "value"))
+	assert.Len(t, instance.QCMap, 1)
+	assert.False(t, mod.isNonHonestMajority(1))
+
+	conflict := adv.conflictingVote(&VoteContent{Instance: 1, NodeId: 3, Content: []byte("value")})
+	mod.HandleVoteMsg(&conflict)
+	mod.HandleVoteMsg(voteMsg(sks, 1, 1, "value-conflict"))
+	assert.Len(t, instance.QCMap, 2)
+	assert.True(t, mod.isNonHonestMajority(1))
+
+	// a vote in the name of another node is not counted
+	forged := voteMsg(sks, 3, 1, "other")
+	vote := VoteContent{}
+	require.NoError(t, utils.Decode(forged.Content, &vote))
+	vote.NodeId = 2
+	mod.HandleVoteMsg(&message.Message{MsgType: message.MsgVote, Content: utils.Encode(vote)})
+	assert.Empty(t, instance.voteMap["other"])
+}
+
+func TestCheckQC(t *testing.T) {
+	mod, sks := newTestMod(t)
+	qc := func(instance int, signedInstance int, signers ...int) *QCContent {
+		sigs := make([]*signature.Signature, 0, len(signers))
+		for _, nid := range signers {
+			sigs = append(sigs, signature.Sign(sks[nid], ds.SignedContent(0, signedInstance, ds.PhaseVote, []byte("value"))))
+		}
+		aggSig, err := signature.AggregateSignatures(sigs)
+		require.NoError(t, err)
+		return &QCContent{Instance: instance, Content: "value", Sig: aggSig, Signers: signers}
+	}
+
+	assert.True(t, mod.checkQC(qc(1, 1, 0, 2)))
+	assert.False(t, mod.checkQC(qc(1, 1, 2)), "less than the quorum")
+	assert.False(t, mod.checkQC(qc(1, 1, 2, 2)), "a signer repeated")
+	assert.False(t, mod.checkQC(qc(2, 1, 0, 2)), "the QC of another instance")
+}
+
+// the detection is forgotten with the state of the instance, and not recorded again by a late QC
+func TestNonHonestMajorityPrunedWithInstance(t *testing.T) {
+	mod, sks := newTestMod(t)
+	instance := startInstance(mod, 1)
+	for _, vote := range []struct {
+		nid   int
+		value string
+	}{{0, "value"}, {1, "value"}, {2, "value-conflict"}, {3, "value-conflict"}} {
+		mod.HandleVoteMsg(voteMsg(sks, vote.nid, 1, vote.value))
+	}
+	require.True(t, mod.isNonHonestMajority(1))
+
+	mod.finishInstance(1)
+	assert.False(t, mod.isNonHonestMajority(1))
+	assert.Nil(t, mod.getInstance(1))
+
+	instance.lock.Lock()
+	mod.addQC(instance, &QCContent{Instance: 1, Content: "late", Sig: instance.QCMap["value"], Signers: []int{0, 1}})
+	instance.lock.Unlock()
+	assert.False(t, mod.isNonHonestMajority(1))
+	assert.Zero(t, mod.nonHonestMajority.Size())
+}
+
+// the malicious node with an unknown strategy stops, like the one of PBFT
+func TestUnknownAdversaryPanics(t *testing.T) {
+	config.ShardNum, config.NodeNum = 1, 4
+	attr, _ := testutil.NewAttr(t, 0, 0, 4)
+	defer func() { config.IsMalicious = false }()
+	config.IsMalicious, config.MaliciousStrategy = true, "Unknown"
+	assert.Panics(t, func() { New_1Delta_BBConsensusMod(attr, p2p.NewP2PMod("")) })
+}
+
+// the handler of commit point 1 returns when the instance finishes without reaching the point
+func TestCommitPoint1HandlerReturnsOnFinish(t *testing.T) {
+	config.ShardNum, config.NodeNum = 1, 4
+	attr, _ := testutil.NewAttr(t, 0, 0, 4)
+	tbbMod := NewTBBCosensusMod(attr, p2p.NewP2PMod("")).(*TBBCosensusMod)
+	instance := &tbbInstance{
+		dbbInstance: startInstance(tbbMod.DBBMod, 1),
+		localSetD:   make([]string, 3),
+		done:        make(chan struct{}),
+	}
+	tbbMod.instances[1] = instance
+
+	returned := make(chan struct{})
+	go func() {
+		tbbMod.commitPoint1Handler(instance)
+		close(returned)
+	}()
+	tbbMod.finishInstance(1)
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("the handler of commit point 1 is still waiting")
+	}
+	assert.False(t, instance.isCommit)
+	assert.Equal(t, "", tbbMod.log[1])
+}
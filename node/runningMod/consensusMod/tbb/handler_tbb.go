@@ -1,4 +1,4 @@
-// the TBB protocol module
+// the TBB protocol module, the instances are tagged by the instance id and may overlap
 package tbb
 
 import (
@@ -21,12 +21,24 @@ type TBBCosensusMod struct {
 	p2pMod   *p2p.P2PMod        // the p2p network module of the belonging node
 	// consensus related
 	view int // the nid of current view number
-	// malicious     bool // whether this node is malicious, does not implement this feature
-	startTime *utils.AtomicValue[time.Time]
 
 	DSMod  *ds.DSCosensusMod       // the Delev-Strong consensus module
 	DBBMod *_1Delta_BBConsensusMod // the DBB consensus module
 
+	// the instances may overlap, each instance has its own state, which is removed when the instance finishes
+	instances    map[int]*tbbInstance // the running instances, key is the instance id
+	log          map[int]string       // the committed values of the finished instances, key is the instance id
+	instanceLock sync.Mutex
+}
+
+// the state of an instance of the TBB protocol
+type tbbInstance struct {
+	startTime time.Time
+	lock      sync.Mutex
+
+	dsInstance  *ds.DSInstance // the DS instance with the same id
+	dbbInstance *dbbInstance   // the 1Δ-BB* instance with the same id
+
 	// local sets
 	localSetA *utils.Set[string] // set 'A' in the paper, used by 1Δ-BB* protocol
 	localSetC *utils.Set[string] // set 'C' in the paper, used by DS protocol
@@ -35,19 +47,26 @@ type TBBCosensusMod struct {
 	referenceValue1 string // the reference value for the next step
 	referenceValue2 string // the reference value for the next step
 
-	isCommit bool // whether any commit point is reached
+	isCommit bool          // whether any commit point is reached
+	done     chan struct{} // closed when the instance finishes
 }
 
 // the content with a list of signatures, not aggregate signature, used in DS protocol
 type SigListContent struct {
+	Instance int
 	Input    []byte
 	SigList  []*signature.Signature
-	NodeList []int // indicate the nodes that have signed the request
+	NodeList []int // indicate the nodes that have signed the request, ds.AggregateSigner means an aggregate signature
+
+	AggSigners []int // the signers of the aggregate signature, if NodeList starts with ds.AggregateSigner
 }
 
 type ReplyValue struct {
-	Value string
-	QCMap map[string]*signature.Signature
+	Sid               int
+	Instance          int
+	Value             string
+	QCMap             map[string]*signature.Signature
+	NonHonestMajority bool // whether the node has observed conflicting QCs in the instance
 }
 
 func NewTBBCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInterface.RunningMod {
@@ -55,23 +74,38 @@ func NewTBBCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInter
 	tbbMod.nodeAttr = attr
 	tbbMod.p2pMod = p2p
 
-	tbbMod.view = 0 // set 0 as the default view
-
-	tbbMod.startTime = utils.NewAtomicValue[time.Time](time.Now())
+	tbbMod.view = config.ViewNodeId // the same view node as the 1Δ-BB* sub-module
 
 	tbbMod.DSMod = ds.NewDSCosensusMod(attr, p2p).(*ds.DSCosensusMod)
 	tbbMod.DBBMod = New_1Delta_BBConsensusMod(attr, p2p).(*_1Delta_BBConsensusMod)
 
-	tbbMod.localSetA = tbbMod.DBBMod.BlckSet
-	tbbMod.localSetC = tbbMod.DSMod.ExtrSet
-	tbbMod.localSetD = make([]string, 3) // set D to store the 3 commit point values
+	tbbMod.instances = make(map[int]*tbbInstance)
+	tbbMod.log = make(map[int]string)
 
-	tbbMod.referenceValue1 = ""
-	tbbMod.referenceValue2 = ""
+	return tbbMod
+}
 
-	tbbMod.isCommit = false
+// the first non-empty value of set D, empty if no commit point is reached
+func (instance *tbbInstance) commitValue() string {
+	instance.lock.Lock()
+	defer instance.lock.Unlock()
+	for _, item := range instance.localSetD {
+		if item != "" {
+			return item
+		}
+	}
+	return ""
+}
 
-	return tbbMod
+// move the commit value to the log and discard the state of the instance
+func (tbbMod *TBBCosensusMod) finishInstance(id int) {
+	tbbMod.instanceLock.Lock()
+	defer tbbMod.instanceLock.Unlock()
+	if instance, exists := tbbMod.instances[id]; exists {
+		tbbMod.log[id] = instance.commitValue()
+		delete(tbbMod.instances, id)
+		close(instance.done)
+	}
 }
 
 // Initially, every node starts 1Δ-BB* (with BADs* embedded) protocol and DS protocol at time r = 0
@@ -79,27 +113,46 @@ func NewTBBCosensusMod(attr *nodeattr.NodeAttr, p2p *p2p.P2PMod) runningModInter
 func (tbbMod *TBBCosensusMod) handleInitMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received an init message")
 
-	startTime := time.Time{}
-	err := utils.Decode(msg.Content, &startTime)
+	initContent := message.InitContent{}
+	err := utils.Decode(msg.Content, &initContent)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the init message, err: %v", err)
 		return
 	}
-
-	// set the start time of the protocol
-	tbbMod.startTime.Set(startTime, nil)
-	utils.LoggerInstance.Info("Set the start time of the protocol to %v", tbbMod.startTime.Get())
-
-	// clear the local sets
-	tbbMod.localSetD = make([]string, 3)
-	tbbMod.referenceValue1 = ""
-	tbbMod.referenceValue2 = ""
-	tbbMod.isCommit = false
+	id := initContent.Instance
+	startTime := initContent.StartTime
+
+	tbbMod.instanceLock.Lock()
+	_, running := tbbMod.instances[id]
+	_, finished := tbbMod.log[id]
+	if running || finished {
+		tbbMod.instanceLock.Unlock()
+		utils.LoggerInstance.Warn("The instance %d is already started", id)
+		return
+	}
 
 	// init two sub-modules
 	tbbMod.DSMod.HandleInitMsg(msg)
 	tbbMod.DBBMod.HandleInitMsg(msg)
 
+	instance := &tbbInstance{
+		startTime:   startTime,
+		dsInstance:  tbbMod.DSMod.GetInstance(id),
+		dbbInstance: tbbMod.DBBMod.getInstance(id),
+		localSetD:   make([]string, 3), // set D to store the 3 commit point values
+		done:        make(chan struct{}),
+	}
+	if instance.dsInstance == nil || instance.dbbInstance == nil {
+		tbbMod.instanceLock.Unlock()
+		utils.LoggerInstance.Error("The sub-modules failed to start the instance %d", id)
+		return
+	}
+	instance.localSetA = instance.dbbInstance.BlckSet
+	instance.localSetC = instance.dsInstance.ExtrSet
+	tbbMod.instances[id] = instance
+	tbbMod.instanceLock.Unlock()
+	utils.LoggerInstance.Info("Set the start time of the instance %d to %v", id, startTime)
+
 	t1 := int(float64(config.NodeNum)*config.ResilientRatio) - 1
 	t2 := config.NodeNum - 1
 
@@ -108,11 +161,16 @@ func (tbbMod *TBBCosensusMod) handleInitMsg(msg *message.Message) {
 	commitPoint2Timer := time.NewTimer(time.Until(startTime.Add(time.Duration(int64(t1+6)*config.TickInterval) * time.Millisecond)))
 	commitPoint3Timer := time.NewTimer(time.Until(startTime.Add(time.Duration(int64(t2+6)*config.TickInterval) * time.Millisecond)))
 
-	go tbbMod.referencePoint1Handler(*checkPoint1Timer)
-	go tbbMod.referencePoint2Handler(*checkPoint2Timer)
-	go tbbMod.commitPoint1Handler()
-	go tbbMod.commitPoint2Handler(*commitPoint2Timer)
-	go tbbMod.commitPoint3Handler(*commitPoint3Timer)
+	go tbbMod.referencePoint1Handler(id, instance, *checkPoint1Timer)
+	go tbbMod.referencePoint2Handler(id, instance, *checkPoint2Timer)
+	go tbbMod.commitPoint1Handler(instance)
+	go tbbMod.commitPoint2Handler(instance, *commitPoint2Timer)
+	go tbbMod.commitPoint3Handler(instance, *commitPoint3Timer)
+
+	// discard the state of the instance when it finishes
+	time.AfterFunc(time.Until(startTime.Add(ds.InstanceLifetime())), func() {
+		tbbMod.finishInstance(id)
+	})
 }
 
 // Simultaneously follow the remaining rules of both protocols
@@ -143,24 +201,30 @@ func (tbbMod *TBBCosensusMod) handleQCMsg(msg *message.Message) {
 
 func (tbbMod *TBBCosensusMod) handleQueryMsg(msg *message.Message) {
 	utils.LoggerInstance.Info("Received a query message")
-	ipaddr := ""
-	err := utils.Decode(msg.Content, &ipaddr)
+	query := message.QueryContent{}
+	err := utils.Decode(msg.Content, &query)
 	if err != nil {
 		utils.LoggerInstance.Error("Error decoding the query message, err: %v", err)
 		return
 	}
 
-	value := ""
-	for _, item := range tbbMod.localSetD {
-		if item != "" {
-			value = item
-			break
-		}
+	tbbMod.instanceLock.Lock()
+	value := tbbMod.log[query.Instance]
+	instance, running := tbbMod.instances[query.Instance]
+	tbbMod.instanceLock.Unlock()
+	if running {
+		value = instance.commitValue()
 	}
 
 	replyValue := ReplyValue{
-		Value: value,
-		QCMap: tbbMod.DBBMod.QCMap,
+		Sid:               tbbMod.nodeAttr.Sid,
+		Instance:          query.Instance,
+		Value:             value,
+		QCMap:             tbbMod.DBBMod.getQCMap(query.Instance),
+		NonHonestMajority: tbbMod.DBBMod.isNonHonestMajority(query.Instance),
+	}
+	if replyValue.NonHonestMajority {
+		utils.LoggerInstance.Warn("Report the non-honest majority of instance %d to the client", query.Instance)
 	}
 
 	repMsg := message.Message{
@@ -168,31 +232,24 @@ func (tbbMod *TBBCosensusMod) handleQueryMsg(msg *message.Message) {
 		Content: utils.Encode(replyValue),
 	}
 
-	// send the reply message to the client
-	tbbMod.p2pMod.ConnMananger.Send(ipaddr, repMsg.JsonEncode())
+	// send the reply message to the client, the reply of the malicious node goes through its adversary as well
+	if tbbMod.DBBMod.adversary != nil {
+		tbbMod.DBBMod.adversary.Send(&repMsg, []string{query.Addr}, time.Now())
+		return
+	}
+	tbbMod.p2pMod.ConnMananger.Send(query.Addr, repMsg.JsonEncode())
 }
 
+// the malicious node registers the same handlers, its adversary decides how the messages are sent
 func (tbbMod *TBBCosensusMod) RegisterHandlers() {
-	if config.IsMalicious && tbbMod.nodeAttr.Nid != config.ViewNodeId { // malicious view node still needs to register the handlers, but sends wrong propose
-		tbbMod.p2pMod.RegisterHandler(message.MsgInit, tbbMod.handleInitMsg_m)
-		tbbMod.p2pMod.RegisterHandler(message.MsgPropose, tbbMod.handleProposeMsg_m)
-		tbbMod.p2pMod.RegisterHandler(message.MsgForward, tbbMod.handleForwardMsg_m)
-		tbbMod.p2pMod.RegisterHandler(message.MsgForward1, tbbMod.handleForward1Msg_m)
-		tbbMod.p2pMod.RegisterHandler(message.MsgForward2, tbbMod.handleForward2Msg_m)
-		tbbMod.p2pMod.RegisterHandler(message.MsgVote, tbbMod.handleVoteMsg_m)
-		tbbMod.p2pMod.RegisterHandler(message.MsgQC, tbbMod.handleQCMsg_m)
-		tbbMod.p2pMod.RegisterHandler(message.MsgQuery, tbbMod.handleQueryMsg_m)
-
-	} else {
-		tbbMod.p2pMod.RegisterHandler(message.MsgInit, tbbMod.handleInitMsg)
-		tbbMod.p2pMod.RegisterHandler(message.MsgPropose, tbbMod.handleProposeMsg)
-		tbbMod.p2pMod.RegisterHandler(message.MsgForward, tbbMod.handleForwardMsg)
-		tbbMod.p2pMod.RegisterHandler(message.MsgForward1, tbbMod.handleForward1Msg)
-		tbbMod.p2pMod.RegisterHandler(message.MsgForward2, tbbMod.handleForward2Msg)
-		tbbMod.p2pMod.RegisterHandler(message.MsgVote, tbbMod.handleVoteMsg)
-		tbbMod.p2pMod.RegisterHandler(message.MsgQC, tbbMod.handleQCMsg)
-		tbbMod.p2pMod.RegisterHandler(message.MsgQuery, tbbMod.handleQueryMsg)
-	}
+	tbbMod.p2pMod.RegisterHandler(message.MsgInit, tbbMod.handleInitMsg)
+	tbbMod.p2pMod.RegisterHandler(message.MsgPropose, tbbMod.handleProposeMsg)
+	tbbMod.p2pMod.RegisterHandler(message.MsgForward, tbbMod.handleForwardMsg)
+	tbbMod.p2pMod.RegisterHandler(message.MsgForward1, tbbMod.handleForward1Msg)
+	tbbMod.p2pMod.RegisterHandler(message.MsgForward2, tbbMod.handleForward2Msg)
+	tbbMod.p2pMod.RegisterHandler(message.MsgVote, tbbMod.handleVoteMsg)
+	tbbMod.p2pMod.RegisterHandler(message.MsgQC, tbbMod.handleQCMsg)
+	tbbMod.p2pMod.RegisterHandler(message.MsgQuery, tbbMod.handleQueryMsg)
 }
 
 func (tbbMod *TBBCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
@@ -201,61 +258,79 @@ func (tbbMod *TBBCosensusMod) Run(ctx context.Context, wg *sync.WaitGroup) {
 }
 
 // When r = (t1 + 1) and (t2 + 1), check set C in DS, if |C| = 1, use the value i from C as areference for the next step.
-func (tbbMod *TBBCosensusMod) referencePoint1Handler(refPoint1 time.Timer) {
+func (tbbMod *TBBCosensusMod) referencePoint1Handler(id int, instance *tbbInstance, refPoint1 time.Timer) {
 	<-refPoint1.C
-	if tbbMod.localSetC.Size() == 1 && tbbMod.DSMod.CommitValue == tbbMod.localSetC.GetItems()[0] {
-		tbbMod.referenceValue1 = tbbMod.localSetC.GetItems()[0]
-		utils.LoggerInstance.Info("The reference value is %v", tbbMod.referenceValue1)
+	if instance.localSetC.Size() == 1 && tbbMod.DSMod.GetCommitValue(id) == instance.localSetC.GetItems()[0] {
+		instance.lock.Lock()
+		instance.referenceValue1 = instance.localSetC.GetItems()[0]
+		instance.lock.Unlock()
+		utils.LoggerInstance.Info("The reference value is %v", instance.localSetC.GetItems()[0])
 	} else {
-		utils.LoggerInstance.Info("The size of localSetC at %p is %d, the reference value is empty", tbbMod.localSetC, tbbMod.localSetC.Size())
+		utils.LoggerInstance.Info("The size of localSetC at %p is %d, the reference value is empty", instance.localSetC, instance.localSetC.Size())
 	}
 }
 
-func (tbbMod *TBBCosensusMod) referencePoint2Handler(refPoint1 time.Timer) {
+func (tbbMod *TBBCosensusMod) referencePoint2Handler(id int, instance *tbbInstance, refPoint1 time.Timer) {
 	<-refPoint1.C
-	if tbbMod.localSetC.Size() == 1 && tbbMod.DSMod.CommitValue == tbbMod.localSetC.GetItems()[0] {
-		tbbMod.referenceValue2 = tbbMod.localSetC.GetItems()[0]
-		utils.LoggerInstance.Info("The reference value is %v", tbbMod.referenceValue2)
+	if instance.localSetC.Size() == 1 && tbbMod.DSMod.GetCommitValue(id) == instance.localSetC.GetItems()[0] {
+		instance.lock.Lock()
+		instance.referenceValue2 = instance.localSetC.GetItems()[0]
+		instance.lock.Unlock()
+		utils.LoggerInstance.Info("The reference value is %v", instance.localSetC.GetItems()[0])
 	} else {
-		utils.LoggerInstance.Info("The size of localSetC at %p is %d, the reference value is empty", tbbMod.localSetC, tbbMod.localSetC.Size())
+		utils.LoggerInstance.Info("The size of localSetC at %p is %d, the reference value is empty", instance.localSetC, instance.localSetC.Size())
 	}
 }
 
 // If Event i. in Step 2 of protocol 1A-BB* is triggered, commit the corresponding value. Then, add the value and the corresponding Commit point to the Di.
-func (tbbMod *TBBCosensusMod) commitPoint1Handler() {
-	<-tbbMod.DBBMod.CommitPoint1Trigger
-	tbbMod.localSetD[0] = tbbMod.DBBMod.CommitValue
-	tbbMod.isCommit = true
-	utils.LoggerInstance.Info("The value commited at commit point 1 is %v", tbbMod.localSetD[0])
+func (tbbMod *TBBCosensusMod) commitPoint1Handler(instance *tbbInstance) {
+	select {
+	case <-instance.dbbInstance.CommitPoint1Trigger:
+	case <-instance.done:
+		return // the instance finished without reaching commit point 1
+	}
+	instance.dbbInstance.lock.Lock()
+	commitValue := instance.dbbInstance.CommitValue
+	instance.dbbInstance.lock.Unlock()
+
+	instance.lock.Lock()
+	defer instance.lock.Unlock()
+	instance.localSetD[0] = commitValue
+	instance.isCommit = true
+	utils.LoggerInstance.Info("The value commited at commit point 1 is %v", instance.localSetD[0])
 }
 
-func (tbbMod *TBBCosensusMod) commitPoint2Handler(commitPoint2 time.Timer) {
+func (tbbMod *TBBCosensusMod) commitPoint2Handler(instance *tbbInstance, commitPoint2 time.Timer) {
 	<-commitPoint2.C
-	if tbbMod.isCommit {
+	instance.lock.Lock()
+	defer instance.lock.Unlock()
+	if instance.isCommit {
 		utils.LoggerInstance.Info("already commit, no need to commit again in commitPoint 2")
 		return
 	}
 
-	if tbbMod.localSetA.Size() == 1 {
-		tbbMod.localSetD[1] = tbbMod.localSetA.GetItems()[0]
-		utils.LoggerInstance.Info("The value commited at  commit point 2 is %v, based on BADS* protocol", tbbMod.localSetD[1])
-	} else if tbbMod.referenceValue1 != "" {
-		tbbMod.localSetD[1] = tbbMod.referenceValue1
-		utils.LoggerInstance.Info("The value commited at  commit point 2 is %v, based on the reference value", tbbMod.localSetD[1])
+	if instance.localSetA.Size() == 1 {
+		instance.localSetD[1] = instance.localSetA.GetItems()[0]
+		utils.LoggerInstance.Info("The value commited at  commit point 2 is %v, based on BADS* protocol", instance.localSetD[1])
+	} else if instance.referenceValue1 != "" {
+		instance.localSetD[1] = instance.referenceValue1
+		utils.LoggerInstance.Info("The value commited at  commit point 2 is %v, based on the reference value", instance.localSetD[1])
 	} else {
 		utils.LoggerInstance.Info("The value commited at  commit point 2 is empty")
 	}
 }
 
-func (tbbMod *TBBCosensusMod) commitPoint3Handler(commitPoint3 time.Timer) {
+func (tbbMod *TBBCosensusMod) commitPoint3Handler(instance *tbbInstance, commitPoint3 time.Timer) {
 	<-commitPoint3.C
-
-	if tbbMod.localSetA.Size() == 1 {
-		tbbMod.localSetD[2] = tbbMod.localSetA.GetItems()[0]
-		utils.LoggerInstance.Info("The value commited at  commit point 3 is %v, based on BADS* protocol", tbbMod.localSetD[2])
-	} else if tbbMod.referenceValue2 != "" {
-		tbbMod.localSetD[2] = tbbMod.referenceValue2
-		utils.LoggerInstance.Info("The value commited at  commit point 3 is %v, based on the reference value", tbbMod.localSetD[2])
+	instance.lock.Lock()
+	defer instance.lock.Unlock()
+
+	if instance.localSetA.Size() == 1 {
+		instance.localSetD[2] = instance.localSetA.GetItems()[0]
+		utils.LoggerInstance.Info("The value commited at  commit point 3 is %v, based on BADS* protocol", instance.localSetD[2])
+	} else if instance.referenceValue2 != "" {
+		instance.localSetD[2] = instance.referenceValue2
+		utils.LoggerInstance.Info("The value commited at  commit point 3 is %v, based on the reference value", instance.localSetD[2])
 	} else {
 		utils.LoggerInstance.Info("The value commited at  commit point 3 is empty")
 	}
@@ -1,27 +1,95 @@
+// the malicious TBB node follows the protocol, the messages it sends go through the adversary of the sub-modules.
+// Besides the adversaries of the DS protocol, TBB adds the ones attacking the votes and the QCs of 1Δ-BB*
 package tbb
 
-import "BlockChainSimulator/message"
+import (
+	"BlockChainSimulator/message"
+	"BlockChainSimulator/node/nodeattr"
+	"BlockChainSimulator/node/p2p"
+	"BlockChainSimulator/node/runningMod/consensusMod/ds"
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"time"
+)
 
-func (tbbMod *TBBCosensusMod) handleInitMsg_m(msg *message.Message) {
+const (
+	DoubleVoteAdversary = "DoubleVote" // votes for the proposed value to half of the nodes, and for a conflicting value to the others
+	WithholdQCAdversary = "WithholdQC" // never sends the QCs and the aggregate signatures of BADS*
+)
+
+func init() {
+	ds.RegisterAdversary(DoubleVoteAdversary, newDoubleVoteAdversary)
+	ds.RegisterAdversary(WithholdQCAdversary, newWithholdQCAdversary)
 }
 
-func (tbbMod *TBBCosensusMod) handleProposeMsg_m(msg *message.Message) {
+type doubleVoteAdversary struct {
+	nodeAttr *nodeattr.NodeAttr
+	p2pMod   *p2p.P2PMod
 }
 
-func (tbbMod *TBBCosensusMod) handleForwardMsg_m(msg *message.Message) {
+func newDoubleVoteAdversary(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) ds.Adversary {
+	return &doubleVoteAdversary{nodeAttr: attr, p2pMod: p2pMod}
 }
 
-func (tbbMod *TBBCosensusMod) handleForward1Msg_m(msg *message.Message) {
+func (adv *doubleVoteAdversary) Send(msg *message.Message, receivers []string, startTime time.Time) {
+	voteContent := VoteContent{}
+	if msg.MsgType != message.MsgVote || utils.Decode(msg.Content, &voteContent) != nil {
+		adv.p2pMod.ConnMananger.Broadcast(adv.nodeAttr.Ipaddr, receivers, msg.JsonEncode())
+		return
+	}
+
+	conflictMsg := adv.conflictingVote(&voteContent)
+	half := ds.HalfReceivers(receivers)
+	halfSet := utils.NewSet[string]()
+	for _, receiver := range half {
+		halfSet.Add(receiver)
+	}
+	others := make([]string, 0, len(receivers)-len(half))
+	for _, receiver := range receivers {
+		if !halfSet.Contains(receiver) {
+			others = append(others, receiver)
+		}
+	}
+
+	adv.p2pMod.ConnMananger.Broadcast(adv.nodeAttr.Ipaddr, half, msg.JsonEncode())
+	adv.p2pMod.ConnMananger.Broadcast(adv.nodeAttr.Ipaddr, others, conflictMsg.JsonEncode())
+	utils.LoggerInstance.Warn("[Malicious] Vote for two values to %d and %d nodes", len(half), len(others))
 }
 
-func (tbbMod *TBBCosensusMod) handleForward2Msg_m(msg *message.Message) {
+// the conflicting vote is correctly signed, so the receivers can only detect it by comparing the votes
+func (adv *doubleVoteAdversary) conflictingVote(voteContent *VoteContent) message.Message {
+	conflictValue := append(append([]byte{}, voteContent.Content...), []byte("-conflict")...)
+	return message.Message{
+		MsgType: message.MsgVote,
+		Content: utils.Encode(VoteContent{
+			Instance: voteContent.Instance,
+			NodeId:   voteContent.NodeId,
+			Content:  conflictValue,
+			Sig:      signature.Sign(adv.nodeAttr.SecKey, ds.SignedContent(adv.nodeAttr.Sid, voteContent.Instance, ds.PhaseVote, conflictValue)),
+		}),
+	}
 }
 
-func (tbbMod *TBBCosensusMod) handleVoteMsg_m(msg *message.Message) {
+type withholdQCAdversary struct {
+	nodeAttr *nodeattr.NodeAttr
+	p2pMod   *p2p.P2PMod
 }
 
-func (tbbMod *TBBCosensusMod) handleQCMsg_m(msg *message.Message) {
+func newWithholdQCAdversary(attr *nodeattr.NodeAttr, p2pMod *p2p.P2PMod) ds.Adversary {
+	return &withholdQCAdversary{nodeAttr: attr, p2pMod: p2pMod}
 }
 
-func (tbbMod *TBBCosensusMod) handleQueryMsg_m(msg *message.Message) {
+func (adv *withholdQCAdversary) Send(msg *message.Message, receivers []string, startTime time.Time) {
+	if msg.MsgType == message.MsgQC {
+		utils.LoggerInstance.Warn("[Malicious] Withhold the QC")
+		return
+	}
+	if msg.MsgType == message.MsgForward2 {
+		sigListContent := SigListContent{}
+		if utils.Decode(msg.Content, &sigListContent) == nil && len(sigListContent.NodeList) == 1 && sigListContent.NodeList[0] == ds.AggregateSigner {
+			utils.LoggerInstance.Warn("[Malicious] Withhold the aggregate signature of BADS*")
+			return
+		}
+	}
+	adv.p2pMod.ConnMananger.Broadcast(adv.nodeAttr.Ipaddr, receivers, msg.JsonEncode())
 }
@@ -7,25 +7,43 @@ import (
 	"BlockChainSimulator/node/runningMod/auxiliaryMod"
 	"BlockChainSimulator/node/runningMod/clientMod"
 	"BlockChainSimulator/node/runningMod/consensusMod"
+	"BlockChainSimulator/node/runningMod/consensusMod/cshard"
+	"BlockChainSimulator/node/runningMod/consensusMod/dag"
 	"BlockChainSimulator/node/runningMod/consensusMod/ds"
+	"BlockChainSimulator/node/runningMod/consensusMod/hbbft"
+	"BlockChainSimulator/node/runningMod/consensusMod/hotstuff"
 	"BlockChainSimulator/node/runningMod/consensusMod/pbft"
+	"BlockChainSimulator/node/runningMod/consensusMod/pos"
+	"BlockChainSimulator/node/runningMod/consensusMod/pow"
+	"BlockChainSimulator/node/runningMod/consensusMod/synchs"
 	"BlockChainSimulator/node/runningMod/consensusMod/tbb"
 	"BlockChainSimulator/node/runningMod/runningModInterface"
 )
 
 // Running mod relates to consensus
 const (
-	PBFTMod     string = "pbft"
-	HotStuffMod string = "hotstuff"
+	PBFTMod      string = "pbft"
+	HotStuffMod  string = "hotstuff"
+	PoWMod       string = "pow"       // every node mines, the blocks are confirmed in the heaviest chain
+	PoSMod       string = "pos"       // the proposers and the committees are self-selected by the VRF sortition on the stakes
+	HBBFTMod     string = "hbbft"     // leaderless asynchronous BFT, every node proposes a batch in every epoch
+	SyncHSMod    string = "synchs"    // synchronous SMR on the Δ clock of DS and TBB, tolerates a minority of faults
+	NarwhalMod   string = "narwhal"   // DAG mempool, every node broadcasts its batches in rounds with certificates of availability
+	BullsharkMod string = "bullshark" // orders the DAG of the narwhal mod into the blockchain without extra messages
+	MirBFTMod    string = "mirbft"    // every node leads a PBFT instance over its buckets of the txs, the buckets rotate among the instances
 
 	// add more consensus type here
 	TBBMod string = "tbb"
 	DSMod  string = "dolev-strong"
+
+	CShardMod string = "cshard" // the input verification and coding of CShard, used with PBFT in the origin shards
 )
 
 // Running mod does not relate to consensus
 const (
 	TestAuxiliaryMod string = "testAuxiliary"
+	SyncPubKeysMod   string = "syncPubKeys" // exchange the public keys at startup, the consensus starts after the keys are known
+	ReconfigMod      string = "reconfig"    // move the node to its new shard at the epoch boundaries
 
 	ProposeTxsMod    string = "ProposeTxs"
 	ProposeBlockMod  string = "ProposeBlock"
@@ -35,10 +53,14 @@ const (
 // Running mod used by client
 const (
 	TestMod             string = "test"
-	StartLocalSystemMod string = "startlocal" // used by the client to start the local system, support local environment only
-	StopSystemMod       string = "stop"       // used by the client to stop the local system, support both local and distributed environment
-	MeasureMod          string = "measure"    // used by the client to measure the performance of the system
-	QueryMod            string = "query"      // used by the client to query the consensus result
+	StartLocalSystemMod string = "startlocal"     // used by the client to start the local system, support local environment only
+	StopSystemMod       string = "stop"           // used by the client to stop the local system, support both local and distributed environment
+	MeasureMod          string = "measure"        // used by the client to measure the performance of the system
+	QueryMod            string = "query"          // used by the client to query the consensus result
+	PartitionMod        string = "partition"      // used by the client to migrate the accounts between the shards by CLPA
+	ReconfigBeaconMod   string = "reconfigBeacon" // used by the client to broadcast the randomness of the committee reconfiguration
+	PoWMeasureMod       string = "powMeasure"     // used by the client to record the confirmed blocks and the stale blocks of PoW
+	PoSMeasureMod       string = "posMeasure"     // used by the client to record the rounds of PoS and the safety violations
 
 	// used by TBB protocol
 	QueryTBBMod string = "queryTBB" // used by the client to query the consensus result
@@ -56,8 +78,17 @@ func init() {
 
 	// Consensus Running Mod
 	runningModRegistry[PBFTMod] = pbft.NewPbftCosensusMod
+	runningModRegistry[HotStuffMod] = hotstuff.NewHotStuffCosensusMod
+	runningModRegistry[PoWMod] = pow.NewPoWCosensusMod
+	runningModRegistry[PoSMod] = pos.NewPoSCosensusMod
+	runningModRegistry[HBBFTMod] = hbbft.NewHoneyBadgerCosensusMod
+	runningModRegistry[SyncHSMod] = synchs.NewSyncHotStuffCosensusMod
+	runningModRegistry[NarwhalMod] = dag.NewNarwhalMempoolMod
+	runningModRegistry[BullsharkMod] = dag.NewBullsharkMod
+	runningModRegistry[MirBFTMod] = pbft.NewMirCosensusMod
 	runningModRegistry[TBBMod] = tbb.NewTBBCosensusMod
 	runningModRegistry[DSMod] = ds.NewDSCosensusMod
+	runningModRegistry[CShardMod] = cshard.NewCShardMod
 
 	runningModRegistry[ProposeTxsMod] = consensusMod.NewProposeTxsAuxiliaryMod
 	runningModRegistry[ProposeBlockMod] = consensusMod.NewProposeBlockAuxiliaryMod
@@ -65,12 +96,18 @@ func init() {
 
 	// Auxiliary Running Mod
 	runningModRegistry[TestAuxiliaryMod] = auxiliaryMod.NewTestAuxiliaryMod
+	runningModRegistry[SyncPubKeysMod] = auxiliaryMod.NewSyncPubKeysMod
+	runningModRegistry[ReconfigMod] = auxiliaryMod.NewReconfigMod
 
 	// Client Running Mod
 	runningModRegistry[TestMod] = clientMod.NewTestAuxiliaryMod
 	runningModRegistry[MeasureMod] = clientMod.NewMeasureMod
 	runningModRegistry[QueryMod] = clientMod.NewQueryMod
 	runningModRegistry[QueryTBBMod] = clientMod.NewQueryTBBMod
+	runningModRegistry[PartitionMod] = clientMod.NewPartitionMod
+	runningModRegistry[ReconfigBeaconMod] = clientMod.NewReconfigBeaconMod
+	runningModRegistry[PoWMeasureMod] = clientMod.NewPoWMeasureMod
+	runningModRegistry[PoSMeasureMod] = clientMod.NewPoSMeasureMod
 	runningModRegistry[StartLocalSystemMod] = clientMod.NewStartLocalSystemAuxiliaryMod
 	runningModRegistry[StopSystemMod] = clientMod.NewStopSystemAuxiliaryMod
 	runningModRegistry[SendMimicContractTxsMod] = clientMod.NewSendMimicContractTxsMod
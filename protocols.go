@@ -19,18 +19,73 @@ var (
 		},
 		"Simple": {
 			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.SendMimicAccountTxsMod},
-			nodeMods:     []string{runningMod.PBFTMod},
-			viewNodeMods: []string{runningMod.PBFTMod, runningMod.ProposeBlockMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod}, // any node may become the primary after a view change
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod},
 		},
 		"ClassicPBFT": {
 			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.TestMod},
-			nodeMods:     []string{runningMod.PBFTMod},
-			viewNodeMods: []string{runningMod.PBFTMod, runningMod.ProposeBlockMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod}, // any node may become the primary after a view change
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod},
+		},
+		"HotStuff": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.TestMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.HotStuffMod},
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.HotStuffMod, runningMod.ProposeBlockMod},
+		},
+		"HoneyBadger": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.TestMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.HBBFTMod}, // leaderless, the common coin uses the threshold keys dealt by the client
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.HBBFTMod},
+		},
+		"SyncHotStuff": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.TestMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.SyncHSMod}, // every node keeps the txs, the leader rotates on the view change
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.SyncHSMod},
+		},
+		"Bullshark": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.TestMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.NarwhalMod, runningMod.BullsharkMod}, // the mempool delivers the DAG to the ordering locally
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.NarwhalMod, runningMod.BullsharkMod},
+		},
+		"MirBFT": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.TestMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.MirBFTMod}, // every node keeps the txs and leads its own instance
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.MirBFTMod},
+		},
+		"PoW": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.PoWMeasureMod, runningMod.SendMimicAccountTxsMod},
+			nodeMods:     []string{runningMod.PoWMod}, // every node mines, no signature is needed
+			viewNodeMods: []string{runningMod.PoWMod},
+		},
+		"PoS": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.PoSMeasureMod, runningMod.SendMimicAccountTxsMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PoSMod}, // the VRF keys are distributed with the public keys
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PoSMod},
 		},
 		"TBD": {
 			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.SendMimicContractTxsMod},
-			nodeMods:     []string{runningMod.PBFTMod},
-			viewNodeMods: []string{runningMod.PBFTMod, runningMod.ProposeBlockMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod}, // any node may become the primary after a view change
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod},
+		},
+		"CShard": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.SendMimicAccountTxsMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod, runningMod.CShardMod}, // only the origin shards receive txs
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod, runningMod.CShardMod},
+		},
+		"Monoxide": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.SendMimicAccountTxsMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod}, // any node may become the primary after a view change
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod},
+		},
+		"CLPA": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.PartitionMod, runningMod.SendMimicAccountTxsMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod}, // Monoxide with the accounts migrated at the epoch boundaries
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod},
+		},
+		"Reconfig": {
+			clientMods:   []string{runningMod.StartLocalSystemMod, runningMod.StopSystemMod, runningMod.MeasureMod, runningMod.ReconfigBeaconMod, runningMod.SendMimicAccountTxsMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod, runningMod.ReconfigMod}, // Monoxide with the nodes reassigned to the shards at the epoch boundaries
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.PBFTMod, runningMod.ProposeBlockMod, runningMod.ReconfigMod},
 		},
 		"TBB": {
 			clientMods: []string{
@@ -38,8 +93,8 @@ var (
 				runningMod.StopSystemMod,
 				runningMod.SendStringManualMod,
 				runningMod.QueryTBBMod},
-			nodeMods:     []string{runningMod.TBBMod},
-			viewNodeMods: []string{runningMod.TBBMod, runningMod.ProposeStringMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.TBBMod},
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.TBBMod, runningMod.ProposeStringMod},
 		},
 		"DS": {
 			clientMods: []string{
@@ -47,8 +102,8 @@ var (
 				runningMod.StopSystemMod,
 				runningMod.SendStringManualMod,
 				runningMod.QueryMod},
-			nodeMods:     []string{runningMod.DSMod},
-			viewNodeMods: []string{runningMod.DSMod, runningMod.ProposeStringMod},
+			nodeMods:     []string{runningMod.SyncPubKeysMod, runningMod.DSMod},
+			viewNodeMods: []string{runningMod.SyncPubKeysMod, runningMod.DSMod, runningMod.ProposeStringMod},
 		},
 	}
 )
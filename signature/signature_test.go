@@ -135,3 +135,43 @@ func BenchmarkVerify(b *testing.B) {
 		_ = Verify(pk, msg, sig)
 	}
 }
+
+func TestThresholdSignature(t *testing.T) {
+	msg := []byte("threshold test")
+	mpk, shares, pubShares, err := GenerateThresholdKeys([]byte("seed"), 3, 5)
+	require.NoError(t, err)
+
+	t.Run("the same seed deals the same keys", func(t *testing.T) {
+		mpk2, shares2, _, err := GenerateThresholdKeys([]byte("seed"), 3, 5)
+		require.NoError(t, err)
+		assert.True(t, mpk.p.IsEqual(&mpk2.p))
+		assert.True(t, shares[4].s.IsEqual(&shares2[4].s))
+	})
+
+	sigShares := make([]*Signature, len(shares))
+	for i, share := range shares {
+		sigShares[i] = Sign(share, msg)
+		assert.True(t, Verify(pubShares[i], msg, sigShares[i]))
+	}
+
+	t.Run("any t shares recover the same signature", func(t *testing.T) {
+		sig1, err := RecoverSignature([]*Signature{sigShares[0], sigShares[1], sigShares[2]}, []int{0, 1, 2})
+		require.NoError(t, err)
+		sig2, err := RecoverSignature([]*Signature{sigShares[4], sigShares[1], sigShares[3]}, []int{4, 1, 3})
+		require.NoError(t, err)
+		assert.True(t, Verify(mpk, msg, sig1))
+		assert.Equal(t, sig1.s.Serialize(), sig2.s.Serialize())
+	})
+
+	t.Run("less than t shares do not recover the signature", func(t *testing.T) {
+		sig, err := RecoverSignature([]*Signature{sigShares[0], sigShares[1]}, []int{0, 1})
+		if err == nil {
+			assert.False(t, Verify(mpk, msg, sig))
+		}
+	})
+
+	t.Run("invalid threshold", func(t *testing.T) {
+		_, _, _, err := GenerateThresholdKeys([]byte("seed"), 6, 5)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,123 @@
+// 门限 BLS 签名，t 个签名份额即可恢复主密钥的签名
+package signature
+
+import (
+	"BlockChainSimulator/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/herumi/bls-go-binary/bls"
+)
+
+// 由种子派生 (t, n) 门限密钥，返回主公钥、各节点的私钥份额和公钥份额，第 i 个份额的 id 为 i+1
+// 模拟可信分发者：知道种子的节点都能得到同样的多项式，所以种子只应在参与的节点间共享
+func GenerateThresholdKeys(seed []byte, t int, n int) (*PublicKey, []*SecretKey, []*PublicKey, error) {
+	if t <= 0 || t > n {
+		return nil, nil, nil, fmt.Errorf("invalid threshold %d of %d", t, n)
+	}
+
+	// 多项式的 t 个系数，常数项为主私钥
+	msk := make([]bls.SecretKey, t)
+	for i := range msk {
+		h := sha256.Sum256(append(append([]byte{}, seed...), []byte(strconv.Itoa(i))...))
+		if err := msk[i].SetLittleEndianMod(h[:]); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	mpk := bls.GetMasterPublicKey(msk)
+
+	shares := make([]*SecretKey, n)
+	pubShares := make([]*PublicKey, n)
+	for i := 0; i < n; i++ {
+		id := shareId(i)
+		shares[i] = &SecretKey{}
+		if err := shares[i].s.Set(msk, &id); err != nil {
+			return nil, nil, nil, err
+		}
+		pubShares[i] = &PublicKey{}
+		if err := pubShares[i].p.Set(mpk, &id); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return &PublicKey{mpk[0]}, shares, pubShares, nil
+}
+
+// 由签名份额恢复主密钥的签名，indexes 为份额的序号（从 0 开始），份额数不少于门限时结果才正确
+func RecoverSignature(sigShares []*Signature, indexes []int) (*Signature, error) {
+	if len(sigShares) == 0 || len(sigShares) != len(indexes) {
+		return nil, fmt.Errorf("%d signature shares with %d indexes", len(sigShares), len(indexes))
+	}
+	sigs := make([]bls.Sign, len(sigShares))
+	ids := make([]bls.ID, len(indexes))
+	for i := range sigShares {
+		if sigShares[i] == nil {
+			return nil, fmt.Errorf("signature share at index %d is nil", i)
+		}
+		sigs[i] = sigShares[i].s
+		ids[i] = shareId(indexes[i])
+	}
+
+	sig := &Signature{}
+	if err := sig.s.Recover(sigs, ids); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// 一个节点持有的门限密钥：主公钥、自己的私钥份额和所有节点的公钥份额
+type ThresholdKeys struct {
+	Master    *PublicKey
+	Share     *SecretKey
+	PubShares []*PublicKey
+}
+
+// 作为可信分发者生成 (t, n) 门限密钥，种子取自 crypto/rand，节点 i 的密钥只写入 path(i)，
+// 文件只有所有者可读，私钥份额不会出现在命令行和日志中
+func DealThresholdKeys(t int, n int, path func(nid int) string) error {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return err
+	}
+	master, shares, pubShares, err := GenerateThresholdKeys(seed, t, n)
+	if err != nil {
+		return err
+	}
+	for nid := 0; nid < n; nid++ {
+		file := path(nid)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		// os.WriteFile 不改变已有文件的权限，所以先删除上次运行的文件
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.WriteFile(file, utils.Encode(&ThresholdKeys{Master: master, Share: shares[nid], PubShares: pubShares}), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取 DealThresholdKeys 写入的一个节点的密钥
+func LoadThresholdKeys(file string) (*ThresholdKeys, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := &ThresholdKeys{}
+	if err := utils.Decode(data, keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// 份额的 id 不能为 0，0 处是主密钥
+func shareId(index int) bls.ID {
+	var id bls.ID
+	id.SetDecString(strconv.Itoa(index + 1))
+	return id
+}
@@ -219,7 +219,7 @@ func (s *Storage) SaveCodingSchema(schema *rfccode.CodingSchema) {
 
 func (s *Storage) SaveChunks(chunk *rfccode.Chunk) {
 	err := s.DataBase.Update(func(tx *bolt.Tx) error {
-		bbucket := tx.Bucket([]byte(s.codingSchemaBucket))
+		bbucket := tx.Bucket([]byte(s.chunkBucket))
 
 		err := bbucket.Put(utils.Hash(chunk), utils.Encode(chunk))
 		if err != nil {
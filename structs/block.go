@@ -21,13 +21,17 @@ type BlockHeader struct {
 	// <--used in Account or Contract-->
 	StateRoot []byte // the state of the account or contract
 
+	// <--used in PoW-->
+	Difficulty int64 // the expected number of hashes to mine the block, the weight of the block in the fork choice
+
 	// <--not in use-->
 	// MinerAddress string
 	// Index        int64
 }
 
+// the miners of PoW link the blocks by the hashes, so every process must get the same hash of a header
 func (bh *BlockHeader) Hash() []byte {
-	hash := sha256.Sum256(utils.Encode(bh))
+	hash := sha256.Sum256(utils.CanonicalEncode(bh))
 	return hash[:]
 }
 
@@ -47,7 +51,7 @@ func NewBlock(header *BlockHeader, txs []Transaction) *Block {
 }
 
 func (bh *BlockHeader) String() string {
-	return fmt.Sprintf("[PrevBlockHash: %s\n\tNonce: %d\n\tTimeStamp: %s\n\tTxRoot: %s\n\tStateRoot: %s]", hex.EncodeToString(bh.PrevBlockHash), bh.Nonce, bh.TimeStamp, hex.EncodeToString(bh.TxRoot), hex.EncodeToString(bh.StateRoot))
+	return fmt.Sprintf("[PrevBlockHash: %s\n\tNonce: %d\n\tTimeStamp: %s\n\tTxRoot: %s\n\tStateRoot: %s\n\tDifficulty: %d]", hex.EncodeToString(bh.PrevBlockHash), bh.Nonce, bh.TimeStamp, hex.EncodeToString(bh.TxRoot), hex.EncodeToString(bh.StateRoot), bh.Difficulty)
 }
 
 func (b *Block) String() string {
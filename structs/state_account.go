@@ -38,7 +38,7 @@ func (as *AccountState) GetBalance() *big.Int {
 }
 
 func (as *AccountState) Deposit(amount *big.Int) {
-	as.Balance.Add(as.DirtyBalance, amount)
+	as.DirtyBalance.Add(as.DirtyBalance, amount)
 }
 
 func (as *AccountState) Deduct(amount *big.Int) bool {
@@ -57,10 +57,11 @@ func (as *AccountState) Update(tx Transaction) bool {
 	return true
 }
 
+// the balances are copied, so that updating the DirtyBalance does not change the Balance
 func (as *AccountState) Rollback() {
-	as.DirtyBalance = as.Balance
+	as.DirtyBalance = new(big.Int).Set(as.Balance)
 }
 
 func (as *AccountState) Commit() {
-	as.Balance = as.DirtyBalance
+	as.Balance = new(big.Int).Set(as.DirtyBalance)
 }
@@ -37,10 +37,19 @@ func (cs *ContractState) Update(tx Transaction) bool {
 	return true
 }
 
+// the maps are copied, otherwise the backup is changed with the variables
 func (cs *ContractState) Commit() {
-	cs.BackupVariablesmap = cs.Variables
+	cs.BackupVariablesmap = copyVariables(cs.Variables)
 }
 
 func (cs *ContractState) Rollback() {
-	cs.Variables = cs.BackupVariablesmap
+	cs.Variables = copyVariables(cs.BackupVariablesmap)
+}
+
+func copyVariables(variables map[string]string) map[string]string {
+	copied := make(map[string]string, len(variables))
+	for k, v := range variables {
+		copied[k] = v
+	}
+	return copied
 }
@@ -46,6 +46,24 @@ func NewAccountTransaction(sender Address, recipient Address, nounce int64, valu
 	return tx
 }
 
+// the second half of a cross-shard transaction in Monoxide, the shard of the sender has deducted the value,
+// and the shard of the recipient credits it. The time of the original transaction is kept to measure the cross-shard latency
+func NewRelayTransaction(tx *AccountTransaction) *AccountTransaction {
+	relay := &AccountTransaction{
+		Sender:    tx.Sender,
+		Recipient: tx.Recipient,
+		Nounce:    tx.Nounce,
+		Value:     tx.Value,
+		Time:      tx.Time,
+
+		Relayed:        true,
+		OriginalSender: tx.Sender,
+		FinalRecipient: tx.Recipient,
+	}
+	relay.TxHash = utils.Hash(utils.Encode(relay))
+	return relay
+}
+
 func NewAcconutCoinbase(recipient Address, value *big.Int) *AccountTransaction {
 	return NewAccountTransaction("", recipient, 0, value)
 }
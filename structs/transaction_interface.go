@@ -37,4 +37,7 @@ var (
 	UTXOTransactionType            string = "UTXO"
 	AccountTransactionType         string = "Account"
 	ETHLikeContractTransactionType string = "ETHLikeContract"
+	LockTransactionType            string = "Lock"
+	MigrationTransactionType       string = "Migration"
+	ReceiptTransactionType         string = "Receipt"
 )
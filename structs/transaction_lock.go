@@ -0,0 +1,170 @@
+// Description: This file contains the control transactions of the two-phase locking protocol for the cross-shard contract calls.
+// They are sent between the shards and packed into the blocks like the other txs, so every phase is ordered by the consensus of the receiving shard.
+// Every node of the origin shard signs the lock tx emitted by a committed block, the tx is accepted with the aggregate signature of f+1 of them
+package structs
+
+import (
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+var _ Transaction = &LockTransaction{}
+
+func init() {
+	gob.Register(&LockTransaction{})
+}
+
+type LockPhase int
+
+const (
+	LockPhaseLock    LockPhase = iota // coordinator -> participant, lock the contracts and execute the call
+	LockPhaseVote                     // participant -> coordinator, whether the contracts are locked(prepared)
+	LockPhaseCommit                   // coordinator -> participant, commit the prepared states and release the locks
+	LockPhaseAbort                    // coordinator -> participant, rollback the prepared states and release the locks
+	LockPhaseTimeout                  // coordinator -> itself, abort the call if it is not decided in time
+	LockPhaseQuery                    // participant -> coordinator, the decision is not received in time, send it again
+)
+
+func (phase LockPhase) String() string {
+	switch phase {
+	case LockPhaseLock:
+		return "Lock"
+	case LockPhaseVote:
+		return "Vote"
+	case LockPhaseCommit:
+		return "Commit"
+	case LockPhaseAbort:
+		return "Abort"
+	case LockPhaseTimeout:
+		return "Timeout"
+	case LockPhaseQuery:
+		return "Query"
+	}
+	return "Unknown"
+}
+
+type LockTransaction struct {
+	Phase       LockPhase
+	CallHash    []byte    // the hash of the cross-shard contract call
+	Coordinator int       // the shard of the contract called by the client
+	Participant int       // the shard locking the contracts
+	Contracts   []Address // the contracts of the call in the participant shard
+	Prepared    bool      // the vote of the participant
+
+	Signers []int                // the nids of the nodes of the origin shard signing the tx
+	Sig     *signature.Signature // the aggregate signature on LockContent
+
+	TxHash []byte    // the hash of LockContent, the txs signed by different nodes differ in the signers only
+	Time   time.Time // the time of the contract call, to measure the latency of the cross-shard call
+}
+
+// the content signed by the nodes of the origin shard, encoded canonically so that every node signs the same bytes
+func LockContent(tx *LockTransaction) []byte {
+	return utils.CanonicalEncode(struct {
+		Phase       LockPhase
+		CallHash    []byte
+		Coordinator int
+		Participant int
+		Contracts   []Address
+		Prepared    bool
+		Time        time.Time
+	}{tx.Phase, tx.CallHash, tx.Coordinator, tx.Participant, tx.Contracts, tx.Prepared, tx.Time})
+}
+
+func NewLockTransaction(phase LockPhase, call *ContractTransaction, coordinator, participant int, contracts []Address, prepared bool) *LockTransaction {
+	tx := &LockTransaction{
+		Phase:       phase,
+		CallHash:    call.TxHash,
+		Coordinator: coordinator,
+		Participant: participant,
+		Contracts:   contracts,
+		Prepared:    prepared,
+		Time:        call.Time,
+	}
+	tx.TxHash = utils.Hash(LockContent(tx))
+	return tx
+}
+
+// the next phase of the call, the fields of the call are kept
+func (tx *LockTransaction) Next(phase LockPhase, participant int, contracts []Address, prepared bool) *LockTransaction {
+	next := &LockTransaction{
+		Phase:       phase,
+		CallHash:    tx.CallHash,
+		Coordinator: tx.Coordinator,
+		Participant: participant,
+		Contracts:   contracts,
+		Prepared:    prepared,
+		Time:        tx.Time,
+	}
+	next.TxHash = utils.Hash(LockContent(next))
+	return next
+}
+
+// the copy of the tx carrying the aggregate signature of the signers
+func (tx *LockTransaction) WithSig(signers []int, sig *signature.Signature) *LockTransaction {
+	signed := *tx
+	signed.Signers = signers
+	signed.Sig = sig
+	return &signed
+}
+
+// the shard emitting the tx: the coordinator sends Lock, Commit, Abort and Timeout, the participant sends Vote and Query
+func (tx *LockTransaction) Origin() int {
+	if tx.Phase == LockPhaseVote || tx.Phase == LockPhaseQuery {
+		return tx.Participant
+	}
+	return tx.Coordinator
+}
+
+// the shard handling the tx: the participant handles Lock, Commit and Abort, the coordinator handles Vote, Query and Timeout
+func (tx *LockTransaction) Dest() int {
+	switch tx.Phase {
+	case LockPhaseLock, LockPhaseCommit, LockPhaseAbort:
+		return tx.Participant
+	}
+	return tx.Coordinator
+}
+
+func (tx *LockTransaction) Type() string {
+	return LockTransactionType
+}
+
+func (tx *LockTransaction) ID() []byte {
+	return tx.TxHash
+}
+
+func (tx *LockTransaction) From() []Address {
+	return []Address{}
+}
+
+func (tx *LockTransaction) To() []Address {
+	return tx.Contracts
+}
+
+func (tx *LockTransaction) GetTime() time.Time {
+	return tx.Time
+}
+
+func (tx *LockTransaction) Hash() []byte {
+	return tx.TxHash
+}
+
+func (tx *LockTransaction) IsCoinBase() bool {
+	return false
+}
+
+func (tx *LockTransaction) GetNonce() int64 {
+	return 0
+}
+
+func (tx *LockTransaction) SetTime(time time.Time) {
+	tx.Time = time
+}
+
+func (tx LockTransaction) String() string {
+	return fmt.Sprintf("Call %s: [%s, shard %d-->%d, prepared: %t]", hex.EncodeToString(tx.CallHash), tx.Phase, tx.Coordinator, tx.Participant, tx.Prepared)
+}
@@ -0,0 +1,121 @@
+// Description: This file contains the transactions of the account migration between the shards.
+// The partition tx carries the new mapping of an epoch and is sent to every shard, the migrate tx carries the state of a moved account
+// from its old shard to the new one. Both are packed into the blocks, so the mapping changes at the same block in every node of a shard
+package structs
+
+import (
+	"BlockChainSimulator/utils"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+var _ Transaction = &MigrationTransaction{}
+
+func init() {
+	gob.Register(&MigrationTransaction{})
+}
+
+type MigrationPhase int
+
+const (
+	MigrationPhasePartition MigrationPhase = iota // client -> every shard, the new shards of the moved accounts
+	MigrationPhaseMigrate                         // old shard -> new shard, the state of a moved account
+)
+
+func (phase MigrationPhase) String() string {
+	switch phase {
+	case MigrationPhasePartition:
+		return "Partition"
+	case MigrationPhaseMigrate:
+		return "Migrate"
+	}
+	return "Unknown"
+}
+
+type MigrationTransaction struct {
+	Phase MigrationPhase
+	Epoch int
+	Moves map[Address]int // Partition: key: the moved account, value: the new shard
+
+	Account  Address // Migrate: the moved account
+	OldShard int     // Migrate: the old shard
+	NewShard int     // Migrate: the new shard
+	State    []byte  // Migrate: the encoded state of the account, empty if the account has no state in the old shard
+
+	TxHash []byte
+	Time   time.Time
+}
+
+func NewPartitionTransaction(epoch int, moves map[Address]int) *MigrationTransaction {
+	tx := &MigrationTransaction{
+		Phase: MigrationPhasePartition,
+		Epoch: epoch,
+		Moves: moves,
+		Time:  time.Now(),
+	}
+	tx.TxHash = utils.Hash(utils.Encode(tx))
+	return tx
+}
+
+// the migrate tx keeps the time of the partition tx, so the nodes of the old shard build the same tx
+func NewMigrateTransaction(epoch int, account Address, oldShard, newShard int, state []byte, partitionTime time.Time) *MigrationTransaction {
+	tx := &MigrationTransaction{
+		Phase:    MigrationPhaseMigrate,
+		Epoch:    epoch,
+		Account:  account,
+		OldShard: oldShard,
+		NewShard: newShard,
+		State:    state,
+		Time:     partitionTime,
+	}
+	tx.TxHash = utils.Hash(utils.Encode(tx))
+	return tx
+}
+
+func (tx *MigrationTransaction) Type() string {
+	return MigrationTransactionType
+}
+
+func (tx *MigrationTransaction) ID() []byte {
+	return tx.TxHash
+}
+
+func (tx *MigrationTransaction) From() []Address {
+	return []Address{}
+}
+
+func (tx *MigrationTransaction) To() []Address {
+	if tx.Phase == MigrationPhaseMigrate {
+		return []Address{tx.Account}
+	}
+	return []Address{}
+}
+
+func (tx *MigrationTransaction) GetTime() time.Time {
+	return tx.Time
+}
+
+func (tx *MigrationTransaction) Hash() []byte {
+	return tx.TxHash
+}
+
+func (tx *MigrationTransaction) IsCoinBase() bool {
+	return false
+}
+
+func (tx *MigrationTransaction) GetNonce() int64 {
+	return 0
+}
+
+func (tx *MigrationTransaction) SetTime(time time.Time) {
+	tx.Time = time
+}
+
+func (tx MigrationTransaction) String() string {
+	if tx.Phase == MigrationPhasePartition {
+		return fmt.Sprintf("Epoch %d: [%s, %d accounts]", tx.Epoch, tx.Phase, len(tx.Moves))
+	}
+	return fmt.Sprintf("Epoch %d: [%s %s, shard %d-->%d, %s]", tx.Epoch, tx.Phase, tx.Account, tx.OldShard, tx.NewShard, hex.EncodeToString(tx.TxHash))
+}
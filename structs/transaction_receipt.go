@@ -0,0 +1,100 @@
+// Description: This file contains the receipt of the txs sent between the shards in Monoxide.
+// Every node of the source shard signs the txs emitted by a committed block(the relay txs, the forwarded txs and the migrate txs),
+// the receipt carries the aggregate signature of f+1 of them, so at least one honest node committed the block emitting the txs
+package structs
+
+import (
+	"BlockChainSimulator/signature"
+	"BlockChainSimulator/utils"
+	"encoding/gob"
+	"fmt"
+	"time"
+)
+
+var _ Transaction = &ReceiptTransaction{}
+
+func init() {
+	gob.Register(&ReceiptTransaction{})
+}
+
+type ReceiptTransaction struct {
+	SourceShard int
+	DestShard   int
+	Epoch       int           // the epoch of the source shard
+	Round       int           // the round of the block emitting the txs in the source shard
+	Txs         []Transaction // the txs emitted by the block to the destination shard
+
+	Signers []int                // the nids of the nodes of the source shard signing the txs
+	Sig     *signature.Signature // the aggregate signature on ReceiptContent
+
+	ReceiptID []byte // the hash of the content, the receipts of the same txs built by different nodes differ in the signers only
+	TxHash    []byte
+	Time      time.Time
+}
+
+// the content signed by the nodes of the source shard, the txs are encoded canonically so that every node signs the same bytes
+func ReceiptContent(sourceShard, destShard, epoch, round int, txs []Transaction) []byte {
+	return utils.CanonicalEncode(struct {
+		SourceShard int
+		DestShard   int
+		Epoch       int
+		Round       int
+		Txs         []Transaction
+	}{sourceShard, destShard, epoch, round, txs})
+}
+
+func NewReceiptTransaction(sourceShard, destShard, epoch, round int, txs []Transaction, signers []int, sig *signature.Signature) *ReceiptTransaction {
+	tx := &ReceiptTransaction{
+		SourceShard: sourceShard,
+		DestShard:   destShard,
+		Epoch:       epoch,
+		Round:       round,
+		Txs:         txs,
+		Signers:     signers,
+		Sig:         sig,
+		ReceiptID:   utils.Hash(ReceiptContent(sourceShard, destShard, epoch, round, txs)),
+		Time:        time.Now(),
+	}
+	tx.TxHash = utils.Hash(utils.Encode(tx))
+	return tx
+}
+
+func (tx *ReceiptTransaction) Type() string {
+	return ReceiptTransactionType
+}
+
+func (tx *ReceiptTransaction) ID() []byte {
+	return tx.ReceiptID
+}
+
+func (tx *ReceiptTransaction) From() []Address {
+	return []Address{}
+}
+
+func (tx *ReceiptTransaction) To() []Address {
+	return []Address{}
+}
+
+func (tx *ReceiptTransaction) GetTime() time.Time {
+	return tx.Time
+}
+
+func (tx *ReceiptTransaction) Hash() []byte {
+	return tx.TxHash
+}
+
+func (tx *ReceiptTransaction) IsCoinBase() bool {
+	return false
+}
+
+func (tx *ReceiptTransaction) GetNonce() int64 {
+	return 0
+}
+
+func (tx *ReceiptTransaction) SetTime(time time.Time) {
+	tx.Time = time
+}
+
+func (tx ReceiptTransaction) String() string {
+	return fmt.Sprintf("Receipt: [shard %d-->%d, epoch %d, round %d, %d txs]", tx.SourceShard, tx.DestShard, tx.Epoch, tx.Round, len(tx.Txs))
+}
@@ -98,3 +98,33 @@ func (txPool *TxPool) Size() int {
 	defer txPool.lock.Unlock()
 	return len(txPool.Txs)
 }
+
+// PeekTxs() returns the first `count` transactions from the TxPool without removing them.
+// The protocols proposing the same pool from several nodes remove the txs only when they are committed
+func (txPool *TxPool) PeekTxs(count int) []Transaction {
+	txPool.lock.Lock()
+	defer txPool.lock.Unlock()
+
+	if count > len(txPool.Txs) {
+		count = len(txPool.Txs)
+	}
+	return append([]Transaction{}, txPool.Txs[:count]...)
+}
+
+// RemoveTxs() removes the given transactions from the TxPool, the transactions are matched by the hash
+func (txPool *TxPool) RemoveTxs(txs []Transaction) {
+	txPool.lock.Lock()
+	defer txPool.lock.Unlock()
+
+	removed := make(map[string]bool, len(txs))
+	for _, tx := range txs {
+		removed[string(tx.Hash())] = true
+	}
+	kept := make([]Transaction, 0, len(txPool.Txs))
+	for _, tx := range txPool.Txs {
+		if !removed[string(tx.Hash())] {
+			kept = append(kept, tx)
+		}
+	}
+	txPool.Txs = kept
+}
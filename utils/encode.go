@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/gob"
+	"encoding/json"
 )
 
 // encodes the object using gob encoding.
@@ -30,3 +31,14 @@ func Hash(object interface{}) []byte {
 	hash := sha256.Sum256(Encode(object))
 	return hash[:]
 }
+
+// encodes the object into the same bytes in every process, use it for the contents to be signed or hashed by several nodes.
+// the output of gob is not suitable for this, the type ids in it depend on the order the types are first encoded in the process
+func CanonicalEncode(object interface{}) []byte {
+	data, err := json.Marshal(object)
+	if err != nil {
+		LoggerInstance.Error("Error encoding the object: %v", err)
+		return nil
+	}
+	return data
+}
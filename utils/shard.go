@@ -2,21 +2,128 @@ package utils
 
 import (
 	"BlockChainSimulator/config"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// the methods to allocate the accounts to the shards, set by config.ShardAllocMethod
+const (
+	HashAlloc  = "Hash"  // the last 8 characters of the address modulo the shard number
+	RangeAlloc = "Range" // the first 4 hex characters of the address, split into equal ranges
+	TableAlloc = "Table" // a lookup table updated by the partition txs on chain, the accounts not in the table are hashed
+)
+
+// ShardAllocator maps an account to its shard
+type ShardAllocator interface {
+	Shard(addr config.Address) int
+}
+
+var table = NewShardTable()
+
 func Addr2Shard(addr config.Address) int {
+	return CurrentAllocator().Shard(addr)
+}
+
+// the allocator selected by config.ShardAllocMethod
+func CurrentAllocator() ShardAllocator {
+	switch config.ShardAllocMethod {
+	case RangeAlloc:
+		return rangeAllocator{}
+	case TableAlloc:
+		return table
+	default:
+		return hashAllocator{}
+	}
+}
+
+// the shards holding the accounts, only origin shards has Txs in CShard
+func allocShardNum() int {
+	if config.ConsensusMethod == "CShard" {
+		return config.K
+	}
+	return config.ShardNum
+}
+
+type hashAllocator struct{}
+
+func (hashAllocator) Shard(addr config.Address) int {
 	subaddr := addr[len(addr)-8:]
 	bytes := []byte(subaddr)
 	var num int
 	for _, b := range bytes {
 		num = (num << 8) | int(b)
 	}
-	if config.ConsensusMethod == "CShard" {
-		return num % config.K // Only origin shards has Txs
-	} else {
-		return num % config.ShardNum
+	return num % allocShardNum()
+}
+
+type rangeAllocator struct{}
+
+func (rangeAllocator) Shard(addr config.Address) int {
+	if len(addr) < 4 {
+		return hashAllocator{}.Shard(addr)
+	}
+	prefix, err := strconv.ParseUint(addr[:4], 16, 64)
+	if err != nil {
+		// not a hex address
+		return hashAllocator{}.Shard(addr)
+	}
+	return int(prefix) * allocShardNum() / (1 << 16)
+}
+
+// ShardTable is the lookup table of the accounts moved by the partition txs, it is the same in every node
+// as long as the partition txs are applied in the order of the blocks
+type ShardTable struct {
+	mu    sync.RWMutex
+	epoch int
+	table map[config.Address]int
+}
+
+func NewShardTable() *ShardTable {
+	return &ShardTable{table: make(map[config.Address]int)}
+}
+
+// the table used by Addr2Shard when config.ShardAllocMethod is TableAlloc
+func CurrentShardTable() *ShardTable {
+	return table
+}
+
+func (st *ShardTable) Shard(addr config.Address) int {
+	st.mu.RLock()
+	sid, ok := st.table[addr]
+	st.mu.RUnlock()
+	if ok {
+		return sid
+	}
+	return hashAllocator{}.Shard(addr)
+}
+
+// move an account to the shard
+func (st *ShardTable) Set(addr config.Address, sid int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.table[addr] = sid
+}
+
+// the epoch of the last partition applied to the table
+func (st *ShardTable) Epoch() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.epoch
+}
+
+// apply the moves of an epoch, the outdated epochs are ignored
+func (st *ShardTable) Apply(epoch int, moves map[config.Address]int) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if epoch <= st.epoch {
+		return false
+	}
+	st.epoch = epoch
+	for addr, sid := range moves {
+		st.table[addr] = sid
 	}
+	return true
 }
 
 func GetNeighbours(IPs map[int]string, SelfIP string) []string {